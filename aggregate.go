@@ -0,0 +1,151 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Reduction is a reduction of the reported values of a metric.
+type Reduction int
+
+const (
+	// ReduceLast selects the last reported value.
+	ReduceLast Reduction = iota
+	// ReduceMax selects the largest reported value; it is the
+	// best-so-far value of a maximized metric.
+	ReduceMax
+	// ReduceMin selects the smallest reported value; it is the
+	// best-so-far value of a minimized metric.
+	ReduceMin
+	// ReduceMean averages the reported values.
+	ReduceMean
+)
+
+var reductionNames = map[Reduction]string{
+	ReduceLast: "last",
+	ReduceMax:  "max",
+	ReduceMin:  "min",
+	ReduceMean: "mean",
+}
+
+// String returns the name of the reduction.
+func (r Reduction) String() string {
+	if name, ok := reductionNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reduction(%d)", int(r))
+}
+
+// Aggregation is a policy by which the values of a metric reported by
+// a run over its lifetime are aggregated into the run's value of the
+// metric. The zero Aggregation selects the last reported value, which
+// is how metrics are aggregated by default.
+type Aggregation struct {
+	// Reduce is the reduction applied to the reported values.
+	Reduce Reduction
+	// Window, if positive, restricts the reduction to the final Window
+	// reported values.
+	Window int
+}
+
+// ParseAggregation parses an aggregation from its textual
+// representation: the name of its reduction ("last", "max", "min",
+// or "mean"), optionally followed by ":" and the size of its window.
+// For example, "mean:5" averages the final 5 reported values of a
+// metric.
+func ParseAggregation(s string) (Aggregation, error) {
+	var (
+		agg  Aggregation
+		name = s
+	)
+	if i := strings.Index(s, ":"); i >= 0 {
+		name = s[:i]
+		window, err := strconv.Atoi(s[i+1:])
+		if err != nil || window <= 0 {
+			return Aggregation{}, fmt.Errorf("invalid aggregation %q: window must be a positive integer", s)
+		}
+		agg.Window = window
+	}
+	for r, rname := range reductionNames {
+		if rname == name {
+			agg.Reduce = r
+			return agg, nil
+		}
+	}
+	return Aggregation{}, fmt.Errorf("invalid aggregation %q: must be last, max, min, or mean", s)
+}
+
+// String returns the textual representation of the aggregation, as
+// parsed by ParseAggregation.
+func (a Aggregation) String() string {
+	if a.Window > 0 {
+		return fmt.Sprintf("%s:%d", a.Reduce, a.Window)
+	}
+	return a.Reduce.String()
+}
+
+// Aggregate aggregates the values of the named metric reported in the
+// provided history of metrics. Aggregate returns false if the metric
+// was never reported.
+func (a Aggregation) Aggregate(history []Metrics, name string) (float64, bool) {
+	var values []float64
+	for _, metrics := range history {
+		if v, ok := metrics[name]; ok {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+	if a.Window > 0 && len(values) > a.Window {
+		values = values[len(values)-a.Window:]
+	}
+	v := values[len(values)-1]
+	switch a.Reduce {
+	case ReduceMax:
+		for _, w := range values {
+			v = math.Max(v, w)
+		}
+	case ReduceMin:
+		for _, w := range values {
+			v = math.Min(v, w)
+		}
+	case ReduceMean:
+		var sum float64
+		for _, w := range values {
+			sum += w
+		}
+		v = sum / float64(len(values))
+	}
+	return v, true
+}
+
+// Trial returns the Trial represented by the provided run of the
+// study. Its metrics are those of Run.Trial, except that the metrics
+// for which the study defines an aggregation policy (Study.Aggregate)
+// are aggregated accordingly from the run's metrics history. The trial
+// is marked infeasible if it is complete and its metrics violate the
+// study's constraints.
+func (s Study) Trial(run Run) Trial {
+	trial := run.Trial()
+	if len(s.Aggregate) > 0 && len(run.Metrics) > 0 {
+		metrics := make(Metrics, len(trial.Metrics))
+		for name, v := range trial.Metrics {
+			metrics[name] = v
+		}
+		for name, agg := range s.Aggregate {
+			if v, ok := agg.Aggregate(run.Metrics, name); ok {
+				metrics[name] = v
+			}
+		}
+		trial.Metrics = metrics
+	}
+	trial.Infeasible = !trial.Pending && !s.Feasible(trial.Metrics)
+	return trial
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestParseAggregation(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want diviner.Aggregation
+	}{
+		{"last", diviner.Aggregation{}},
+		{"max", diviner.Aggregation{Reduce: diviner.ReduceMax}},
+		{"min:2", diviner.Aggregation{Reduce: diviner.ReduceMin, Window: 2}},
+		{"mean:5", diviner.Aggregation{Reduce: diviner.ReduceMean, Window: 5}},
+	} {
+		agg, err := diviner.ParseAggregation(test.s)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if got, want := agg, test.want; got != want {
+			t.Errorf("%s: got %v, want %v", test.s, got, want)
+		}
+		if got, want := agg.String(), test.s; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	for _, s := range []string{"", "median", "mean:", "mean:0", "max:x"} {
+		if _, err := diviner.ParseAggregation(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestStudyTrial(t *testing.T) {
+	run := diviner.Run{
+		State: diviner.Success,
+		Metrics: []diviner.Metrics{
+			{"acc": 0.5, "loss": 4},
+			{"acc": 0.9, "loss": 3},
+			{"acc": 0.7, "loss": 2},
+			{"acc": 0.6},
+		},
+	}
+	if got, want := run.Trial().Metrics, (diviner.Metrics{"acc": 0.6}); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	study := diviner.Study{
+		Aggregate: map[string]diviner.Aggregation{
+			"acc":  {Reduce: diviner.ReduceMax},
+			"loss": {Reduce: diviner.ReduceMean, Window: 2},
+			"f1":   {Reduce: diviner.ReduceMin},
+		},
+	}
+	if got, want := study.Trial(run).Metrics, (diviner.Metrics{"acc": 0.9, "loss": 2.5}); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The run's own metrics are not modified.
+	if got, want := run.Metrics[3], (diviner.Metrics{"acc": 0.6}); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAggregateLeaderboard(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	study := diviner.Study{
+		Name:      "test",
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+		Aggregate: map[string]diviner.Aggregation{"acc": {Reduce: diviner.ReduceMax}},
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+		t.Fatal(err)
+	}
+	// The first run ends worse than the second, but attains the best
+	// accuracy of either.
+	for i, history := range [][]float64{{0.9, 0.5}, {0.6, 0.7}} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(int64(i))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, acc := range history {
+			if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": acc}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "done", 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trials, err := diviner.Leaderboard(ctx, db, "test", study.Objective, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(trials), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := trials[0].Metrics["acc"], 0.9; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := trials[0].Runs[0].Seq, uint64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package analysis implements analyses of the results of diviner
+// studies.
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/grailbio/diviner"
+)
+
+// MinTrials is the minimum number of trials required to estimate
+// parameter importance.
+const MinTrials = 4
+
+// Importance is the estimated importance of a parameter to a study's
+// objective.
+type Importance struct {
+	// Param is the name of the parameter.
+	Param string
+	// Importance is the parameter's share of the total importance of
+	// all parameters, in [0, 1]. Parameters whose permutation does not
+	// degrade the model's predictions have zero importance.
+	Importance float64
+	// MSEIncrease is the increase in the model's out-of-bag mean
+	// squared error when the parameter's values are permuted, relative
+	// to the variance of the objective.
+	MSEIncrease float64
+}
+
+// String returns a textual description of the importance.
+func (i Importance) String() string {
+	return fmt.Sprintf("%s=%.3f", i.Param, i.Importance)
+}
+
+// Options configures the random forest used to estimate parameter
+// importance.
+type Options struct {
+	// Trees is the number of trees in the forest.
+	Trees int
+	// MinLeaf is the minimum number of trials in each leaf of a
+	// tree.
+	MinLeaf int
+	// MaxDepth is the maximum depth of each tree.
+	MaxDepth int
+	// Seed is the seed of the random number generator used to
+	// sample bootstraps, features, and permutations.
+	Seed int64
+}
+
+// DefaultOptions are the options used by ParamImportance.
+var DefaultOptions = Options{Trees: 100, MinLeaf: 2, MaxDepth: 12}
+
+// ParamImportance estimates the importance of each parameter to the
+// provided objective from the provided trials, using DefaultOptions.
+// See Options.ParamImportance.
+func ParamImportance(trials []diviner.Trial, objective diviner.Objective) ([]Importance, error) {
+	return DefaultOptions.ParamImportance(trials, objective)
+}
+
+// ParamImportance estimates the importance of each parameter to the
+// provided objective from the provided trials. A random forest of
+// regression trees is fit to the trials' objective values; the
+// importance of a parameter is the increase in the forest's
+// out-of-bag prediction error when the parameter's values are
+// randomly permuted (permutation importance). Trials that did not
+// report the objective are ignored. The returned importances are
+// sorted by decreasing importance.
+//
+// Numeric and boolean parameters are modeled as ordered; other
+// parameters are modeled by the (sorted) order of their distinct
+// values. Trials that lack a parameter (e.g., because it is
+// conditional) are modeled as having a value smaller than all others.
+func (o Options) ParamImportance(trials []diviner.Trial, objective diviner.Objective) ([]Importance, error) {
+	var (
+		names = make(map[string]bool)
+		y     []float64
+		kept  []diviner.Trial
+	)
+	for _, trial := range trials {
+		v, ok := trial.Metrics[objective.Metric]
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		kept = append(kept, trial)
+		y = append(y, v)
+		for name := range trial.Values {
+			names[name] = true
+		}
+	}
+	if len(kept) < MinTrials {
+		return nil, fmt.Errorf("analysis: need at least %d trials that report %s, got %d", MinTrials, objective.Metric, len(kept))
+	}
+	if len(names) == 0 {
+		return nil, errors.New("analysis: trials have no parameters")
+	}
+	params := make([]string, 0, len(names))
+	for name := range names {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	x := make([][]float64, len(kept))
+	for i := range x {
+		x[i] = make([]float64, len(params))
+	}
+	for j, name := range params {
+		column := featureColumn(kept, name)
+		for i := range x {
+			x[i][j] = column[i]
+		}
+	}
+	if o.Trees <= 0 {
+		o.Trees = DefaultOptions.Trees
+	}
+	if o.MinLeaf <= 0 {
+		o.MinLeaf = DefaultOptions.MinLeaf
+	}
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = DefaultOptions.MaxDepth
+	}
+	increase := o.permutationImportance(x, y)
+	varY := variance(y)
+	importances := make([]Importance, len(params))
+	var total float64
+	for j, name := range params {
+		importances[j].Param = name
+		if varY > 0 {
+			importances[j].MSEIncrease = increase[j] / varY
+		}
+		if increase[j] > 0 {
+			total += increase[j]
+		}
+	}
+	if total > 0 {
+		for j := range importances {
+			importances[j].Importance = math.Max(0, increase[j]) / total
+		}
+	}
+	sort.SliceStable(importances, func(i, j int) bool {
+		return importances[i].Importance > importances[j].Importance
+	})
+	return importances, nil
+}
+
+// missing is the feature value of trials that lack a parameter.
+const missing = -math.MaxFloat64
+
+// FeatureColumn returns the feature values of the parameter with the
+// provided name in the provided trials.
+func featureColumn(trials []diviner.Trial, name string) []float64 {
+	var (
+		column = make([]float64, len(trials))
+		levels = make(map[string]float64)
+		keys   []string
+	)
+	for _, trial := range trials {
+		if v, ok := trial.Values[name]; ok && !numeric(v) {
+			if _, ok := levels[v.String()]; !ok {
+				levels[v.String()] = 0
+				keys = append(keys, v.String())
+			}
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		levels[key] = float64(i)
+	}
+	for i, trial := range trials {
+		v, ok := trial.Values[name]
+		if !ok {
+			column[i] = missing
+		} else if x, ok := coordinate(v); ok {
+			column[i] = x
+		} else {
+			column[i] = levels[v.String()]
+		}
+	}
+	return column
+}
+
+func numeric(v diviner.Value) bool {
+	_, ok := coordinate(v)
+	return ok
+}
+
+// PermutationImportance fits a random forest to the provided
+// observations and returns, for each feature, the mean increase in
+// the out-of-bag squared error of the forest's trees when the
+// feature is permuted among each tree's out-of-bag observations.
+func (o Options) permutationImportance(x [][]float64, y []float64) []float64 {
+	var (
+		random   = rand.New(rand.NewSource(o.Seed))
+		n, p     = len(x), len(x[0])
+		increase = make([]float64, p)
+		ntrees   int
+		mtry     = p / 3
+	)
+	if mtry < 1 {
+		mtry = 1
+	}
+	for t := 0; t < o.Trees; t++ {
+		var (
+			sample = make([]int, n)
+			inbag  = make([]bool, n)
+			oob    []int
+		)
+		for i := range sample {
+			sample[i] = random.Intn(n)
+			inbag[sample[i]] = true
+		}
+		for i := range inbag {
+			if !inbag[i] {
+				oob = append(oob, i)
+			}
+		}
+		if len(oob) == 0 {
+			continue
+		}
+		tree := o.grow(x, y, sample, 0, mtry, random)
+		base := tree.mse(x, y, oob, -1, nil)
+		for j := 0; j < p; j++ {
+			perm := random.Perm(len(oob))
+			increase[j] += tree.mse(x, y, oob, j, perm) - base
+		}
+		ntrees++
+	}
+	if ntrees > 0 {
+		for j := range increase {
+			increase[j] /= float64(ntrees)
+		}
+	}
+	return increase
+}
+
+// A node is a node in a regression tree. Leaves have no children.
+type node struct {
+	feature     int
+	threshold   float64
+	left, right *node
+	value       float64
+}
+
+// Predict returns the tree's prediction for the feature vector x.
+func (n *node) predict(x []float64) float64 {
+	for n.left != nil {
+		if x[n.feature] <= n.threshold {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return n.value
+}
+
+// Mse returns the tree's mean squared error on the provided
+// observations. If feature is non-negative, the observations' values
+// of that feature are permuted by perm.
+func (n *node) mse(x [][]float64, y []float64, obs []int, feature int, perm []int) float64 {
+	var (
+		sum float64
+		row []float64
+	)
+	for k, i := range obs {
+		row = x[i]
+		if feature >= 0 {
+			row = append(row[:0:0], x[i]...)
+			row[feature] = x[obs[perm[k]]][feature]
+		}
+		d := n.predict(row) - y[i]
+		sum += d * d
+	}
+	return sum / float64(len(obs))
+}
+
+// Grow grows a regression tree from the provided sample of
+// observations, considering mtry randomly chosen features at each
+// split.
+func (o Options) grow(x [][]float64, y []float64, sample []int, depth, mtry int, random *rand.Rand) *node {
+	leaf := &node{value: mean(y, sample)}
+	if depth >= o.MaxDepth || len(sample) < 2*o.MinLeaf {
+		return leaf
+	}
+	var (
+		bestFeature   = -1
+		bestThreshold float64
+		bestSSE       = sse(y, sample)
+		sorted        = make([]int, len(sample))
+	)
+	for _, j := range random.Perm(len(x[0]))[:mtry] {
+		copy(sorted, sample)
+		sort.Slice(sorted, func(a, b int) bool {
+			return x[sorted[a]][j] < x[sorted[b]][j]
+		})
+		// Scan the split points, maintaining the sums of the
+		// objective (and its square) on the left side.
+		var (
+			total, totalSq float64
+			left, leftSq   float64
+			n              = float64(len(sorted))
+		)
+		for _, i := range sorted {
+			total += y[i]
+			totalSq += y[i] * y[i]
+		}
+		for k := 0; k < len(sorted)-1; k++ {
+			i := sorted[k]
+			left += y[i]
+			leftSq += y[i] * y[i]
+			nl := float64(k + 1)
+			if k+1 < o.MinLeaf || len(sorted)-k-1 < o.MinLeaf {
+				continue
+			}
+			v, w := x[i][j], x[sorted[k+1]][j]
+			if v == w {
+				continue
+			}
+			right, rightSq, nr := total-left, totalSq-leftSq, n-nl
+			s := (leftSq - left*left/nl) + (rightSq - right*right/nr)
+			if s < bestSSE-1e-12 {
+				bestFeature, bestSSE = j, s
+				bestThreshold = v/2 + w/2
+			}
+		}
+	}
+	if bestFeature < 0 {
+		return leaf
+	}
+	var lsample, rsample []int
+	for _, i := range sample {
+		if x[i][bestFeature] <= bestThreshold {
+			lsample = append(lsample, i)
+		} else {
+			rsample = append(rsample, i)
+		}
+	}
+	return &node{
+		feature:   bestFeature,
+		threshold: bestThreshold,
+		left:      o.grow(x, y, lsample, depth+1, mtry, random),
+		right:     o.grow(x, y, rsample, depth+1, mtry, random),
+	}
+}
+
+func mean(y []float64, sample []int) float64 {
+	var sum float64
+	for _, i := range sample {
+		sum += y[i]
+	}
+	return sum / float64(len(sample))
+}
+
+// SSE returns the sum of squared deviations from the mean of the
+// sampled observations.
+func sse(y []float64, sample []int) float64 {
+	m := mean(y, sample)
+	var sum float64
+	for _, i := range sample {
+		sum += (y[i] - m) * (y[i] - m)
+	}
+	return sum
+}
+
+func variance(y []float64) float64 {
+	sample := make([]int, len(y))
+	for i := range sample {
+		sample[i] = i
+	}
+	return sse(y, sample) / float64(len(y))
+}
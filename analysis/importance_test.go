@@ -0,0 +1,94 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package analysis_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/analysis"
+)
+
+func TestParamImportance(t *testing.T) {
+	var (
+		random    = rand.New(rand.NewSource(1))
+		objective = diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+		trials    []diviner.Trial
+		opts      = []string{"adam", "sgd", "rmsprop"}
+	)
+	for i := 0; i < 200; i++ {
+		var (
+			lr        = random.Float64()
+			batch     = random.Int63n(100)
+			optimizer = opts[random.Intn(len(opts))]
+			loss      = 10*lr + random.Float64()*0.1
+		)
+		if optimizer == "sgd" {
+			loss += 3
+		}
+		trials = append(trials, diviner.Trial{
+			Values: diviner.Values{
+				"learning_rate": diviner.Float(lr),
+				"batch_size":    diviner.Int(batch),
+				"optimizer":     diviner.String(optimizer),
+			},
+			Metrics: diviner.Metrics{"loss": loss},
+		})
+	}
+	// Trials without the objective are ignored.
+	trials = append(trials, diviner.Trial{
+		Values:  diviner.Values{"learning_rate": diviner.Float(0.5)},
+		Metrics: diviner.Metrics{"other": 1},
+	})
+	importances, err := analysis.ParamImportance(trials, objective)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(importances), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, want := range []string{"learning_rate", "optimizer", "batch_size"} {
+		if got := importances[i].Param; got != want {
+			t.Errorf("%d: got %v, want %v (%v)", i, got, want, importances)
+		}
+	}
+	var total float64
+	for _, imp := range importances {
+		total += imp.Importance
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("importances sum to %v", total)
+	}
+	if imp := importances[0]; imp.Importance < 0.5 || imp.MSEIncrease < 0.5 {
+		t.Errorf("learning_rate: got %+v", imp)
+	}
+	if imp := importances[2]; imp.Importance > 0.05 {
+		t.Errorf("batch_size: got %+v", imp)
+	}
+
+	// The analysis is deterministic for a given seed.
+	again, err := analysis.ParamImportance(trials, objective)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range again {
+		if got, want := again[i], importances[i]; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParamImportanceTooFewTrials(t *testing.T) {
+	trials := []diviner.Trial{
+		{Values: diviner.Values{"x": diviner.Int(1)}, Metrics: diviner.Metrics{"loss": 1}},
+		{Values: diviner.Values{"x": diviner.Int(2)}, Metrics: diviner.Metrics{"loss": 2}},
+	}
+	_, err := analysis.ParamImportance(trials, diviner.Objective{Direction: diviner.Minimize, Metric: "loss"})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
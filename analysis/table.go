@@ -0,0 +1,279 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package analysis
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/grailbio/diviner"
+)
+
+// A Source is the source of a column's values.
+type Source int
+
+const (
+	// ParamSource columns contain parameter values.
+	ParamSource Source = iota
+	// MetricSource columns contain metrics.
+	MetricSource
+)
+
+// String returns "param" or "metric".
+func (s Source) String() string {
+	if s == MetricSource {
+		return "metric"
+	}
+	return "param"
+}
+
+// A Column is a column of a Table: the values of a single parameter
+// or metric across a set of trials.
+type Column struct {
+	// Name is the name of the parameter or metric.
+	Name string
+	// Source tells whether the column contains parameter values or
+	// metrics.
+	Source Source
+	// Kind is the kind of the column's values. Metrics are of kind
+	// diviner.Real.
+	Kind diviner.Kind
+	// Param is the parameter whose values the column contains; it is
+	// nil for metrics, and for values of parameters unknown to the
+	// table's study.
+	Param diviner.Param
+	// Log tells whether the column's values are best plotted on a log
+	// scale, as they are for log range parameters.
+	Log bool
+	// Levels are the distinct values of categorical columns, in order.
+	// Levels is nil for numeric columns.
+	Levels []string
+	// Values are the column's values, one for each trial; values are
+	// nil for trials that lack the parameter (or metric).
+	Values []diviner.Value
+	// Coords are the column's values as plot coordinates: numeric
+	// values (integers, reals, booleans, durations in seconds, and
+	// times in Unix seconds) are their own coordinates; categorical
+	// values are their indices in Levels. Missing values are NaN.
+	Coords []float64
+}
+
+// Categorical tells whether the column's values are categorical.
+func (c Column) Categorical() bool { return c.Levels != nil }
+
+// MarshalJSON encodes the column as a JSON object; missing
+// coordinates are encoded as null.
+func (c Column) MarshalJSON() ([]byte, error) {
+	col := struct {
+		Name   string        `json:"name"`
+		Source string        `json:"source"`
+		Kind   string        `json:"kind"`
+		Param  string        `json:"param,omitempty"`
+		Log    bool          `json:"log,omitempty"`
+		Levels []string      `json:"levels,omitempty"`
+		Coords []interface{} `json:"coords"`
+	}{
+		Name:   c.Name,
+		Source: c.Source.String(),
+		Kind:   c.Kind.String(),
+		Log:    c.Log,
+		Levels: c.Levels,
+		Coords: make([]interface{}, len(c.Coords)),
+	}
+	if c.Param != nil {
+		col.Param = c.Param.String()
+	}
+	for i, v := range c.Coords {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			col.Coords[i] = v
+		}
+	}
+	return json.Marshal(col)
+}
+
+// A Table is a columnar representation of a set of trials, suitable
+// for parallel-coordinate and scatter plots: it contains a column for
+// each parameter and each metric, each with a row for each trial.
+type Table struct {
+	// Rows is the number of trials in the table.
+	Rows int `json:"rows"`
+	// Columns contains the table's parameter columns, sorted by name,
+	// followed by its metric columns, sorted by name.
+	Columns []Column `json:"columns"`
+}
+
+// Column returns the column containing the named parameter or metric
+// from the provided source, and false if there is no such column.
+func (t *Table) Column(source Source, name string) (Column, bool) {
+	for _, col := range t.Columns {
+		if col.Source == source && col.Name == name {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// NewTable returns a table of the provided trials of a study with the
+// provided parameters. The table has a column for each of the
+// parameters, and one for each parameter value or metric reported by
+// any of the trials. The parameters determine the columns' kinds and
+// the order of their levels: enum and discrete parameters order
+// levels as their values are declared; levels of other categorical
+// columns are sorted.
+func NewTable(params diviner.Params, trials []diviner.Trial) *Table {
+	var (
+		values  = make(map[string]bool)
+		metrics = make(map[string]bool)
+	)
+	for name := range params {
+		values[name] = true
+	}
+	for _, trial := range trials {
+		for name := range trial.Values {
+			values[name] = true
+		}
+		for name := range trial.Metrics {
+			metrics[name] = true
+		}
+	}
+	table := &Table{Rows: len(trials)}
+	for _, name := range sortedNames(values) {
+		col := Column{
+			Name:   name,
+			Source: ParamSource,
+			Param:  params[name],
+			Values: make([]diviner.Value, len(trials)),
+			Coords: make([]float64, len(trials)),
+		}
+		for i, trial := range trials {
+			col.Values[i] = trial.Values[name]
+		}
+		if col.Param != nil {
+			col.Kind = col.Param.Kind()
+		} else {
+			for _, v := range col.Values {
+				if v != nil {
+					col.Kind = v.Kind()
+					break
+				}
+			}
+		}
+		param := col.Param
+		if c, ok := param.(*diviner.Conditional); ok {
+			param = c.Param
+		}
+		_, col.Log = param.(*diviner.LogRange)
+		col.setCoords(param)
+		table.Columns = append(table.Columns, col)
+	}
+	for _, name := range sortedNames(metrics) {
+		col := Column{
+			Name:   name,
+			Source: MetricSource,
+			Kind:   diviner.Real,
+			Values: make([]diviner.Value, len(trials)),
+			Coords: make([]float64, len(trials)),
+		}
+		for i, trial := range trials {
+			col.Coords[i] = math.NaN()
+			if v, ok := trial.Metrics[name]; ok {
+				col.Values[i] = diviner.Float(v)
+				col.Coords[i] = v
+			}
+		}
+		table.Columns = append(table.Columns, col)
+	}
+	return table
+}
+
+// SetCoords computes the column's levels and coordinates from its
+// values. Declared levels are taken from param, if it is an enum or
+// a discrete parameter.
+func (c *Column) setCoords(param diviner.Param) {
+	var (
+		categorical bool
+		declared    []string
+	)
+	for _, v := range c.Values {
+		if v != nil && !numeric(v) {
+			categorical = true
+		}
+	}
+	switch p := param.(type) {
+	case *diviner.Enum:
+		categorical = true
+		declared = p.Names
+	case *diviner.Discrete:
+		if categorical || (len(p.Values()) > 0 && !numeric(p.Values()[0])) {
+			categorical = true
+			for _, v := range p.Values() {
+				declared = append(declared, v.String())
+			}
+		}
+	}
+	index := make(map[string]int)
+	if categorical {
+		c.Levels = append([]string{}, declared...)
+		for i, level := range c.Levels {
+			index[level] = i
+		}
+		var extra []string
+		for _, v := range c.Values {
+			if v == nil {
+				continue
+			}
+			if _, ok := index[v.String()]; !ok {
+				index[v.String()] = -1
+				extra = append(extra, v.String())
+			}
+		}
+		sort.Strings(extra)
+		for _, level := range extra {
+			index[level] = len(c.Levels)
+			c.Levels = append(c.Levels, level)
+		}
+	}
+	for i, v := range c.Values {
+		switch {
+		case v == nil:
+			c.Coords[i] = math.NaN()
+		case categorical:
+			c.Coords[i] = float64(index[v.String()])
+		default:
+			c.Coords[i], _ = coordinate(v)
+		}
+	}
+}
+
+// Coordinate returns the numeric coordinate of the value v, and false
+// if v is not numeric.
+func coordinate(v diviner.Value) (float64, bool) {
+	switch v.Kind() {
+	case diviner.Integer:
+		return float64(v.Int()), true
+	case diviner.Real:
+		return v.Float(), true
+	case diviner.Boolean:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case diviner.Interval:
+		return v.(diviner.Duration).Duration().Seconds(), true
+	case diviner.Timestamp:
+		return float64(v.(diviner.Time).Time().Unix()), true
+	}
+	return 0, false
+}
+
+func sortedNames(m map[string]bool) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
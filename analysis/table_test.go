@@ -0,0 +1,130 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package analysis_test
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/analysis"
+)
+
+func TestTable(t *testing.T) {
+	params := diviner.Params{
+		"lr":        diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1)),
+		"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+		"size":      diviner.NewEnum("small", "medium", "large"),
+		"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+		"layers":    diviner.NewDiscrete(diviner.Int(1), diviner.Int(2)),
+	}
+	trials := []diviner.Trial{
+		{
+			Values: diviner.Values{
+				"lr":        diviner.Float(0.01),
+				"optimizer": diviner.String("sgd"),
+				"size":      diviner.String("large"),
+				"momentum":  diviner.Float(0.9),
+				"layers":    diviner.Int(2),
+				"interval":  diviner.Duration(time.Minute),
+			},
+			Metrics: diviner.Metrics{"acc": 0.9},
+		},
+		{
+			Values: diviner.Values{
+				"lr":        diviner.Float(0.1),
+				"optimizer": diviner.String("adam"),
+				"size":      diviner.String("small"),
+				"layers":    diviner.Int(1),
+			},
+			Metrics: diviner.Metrics{"acc": 0.8, "loss": 0.5},
+		},
+	}
+	table := analysis.NewTable(params, trials)
+	if got, want := table.Rows, 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var names []string
+	for _, col := range table.Columns {
+		names = append(names, col.Source.String()+"."+col.Name)
+	}
+	if got, want := names, []string{
+		"param.interval", "param.layers", "param.lr", "param.momentum", "param.optimizer", "param.size",
+		"metric.acc", "metric.loss",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, test := range []struct {
+		source analysis.Source
+		name   string
+		kind   diviner.Kind
+		log    bool
+		levels []string
+		coords []float64
+	}{
+		{analysis.ParamSource, "interval", diviner.Interval, false, nil, []float64{60, math.NaN()}},
+		{analysis.ParamSource, "layers", diviner.Integer, false, nil, []float64{2, 1}},
+		{analysis.ParamSource, "lr", diviner.Real, true, nil, []float64{0.01, 0.1}},
+		{analysis.ParamSource, "momentum", diviner.Real, false, nil, []float64{0.9, math.NaN()}},
+		{analysis.ParamSource, "optimizer", diviner.Str, false, []string{"sgd", "adam"}, []float64{0, 1}},
+		{analysis.ParamSource, "size", diviner.Str, false, []string{"small", "medium", "large"}, []float64{2, 0}},
+		{analysis.MetricSource, "loss", diviner.Real, false, nil, []float64{math.NaN(), 0.5}},
+	} {
+		col, ok := table.Column(test.source, test.name)
+		if !ok {
+			t.Errorf("missing column %s", test.name)
+			continue
+		}
+		if got, want := col.Kind, test.kind; got != want {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+		if got, want := col.Log, test.log; got != want {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+		if got, want := col.Levels, test.levels; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+		if got, want := col.Categorical(), test.levels != nil; got != want {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+		for i, want := range test.coords {
+			if got := col.Coords[i]; got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+				t.Errorf("%s[%d]: got %v, want %v", test.name, i, got, want)
+			}
+		}
+	}
+	if _, ok := table.Column(analysis.MetricSource, "lr"); ok {
+		t.Error("unexpected metric column lr")
+	}
+
+	// Values outside of the declared levels are appended to them.
+	trials[1].Values["optimizer"] = diviner.String("rmsprop")
+	col, _ := analysis.NewTable(params, trials).Column(analysis.ParamSource, "optimizer")
+	if got, want := col.Levels, []string{"sgd", "adam", "rmsprop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	p, err := json.Marshal(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Columns []map[string]interface{} `json:"columns"`
+	}
+	if err := json.Unmarshal(p, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	momentum := decoded.Columns[3]
+	if got, want := momentum["coords"], []interface{}{0.9, nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := momentum["param"], params["momentum"].String(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,780 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package api serves a diviner database as a read-only JSON API over
+// HTTP, so that tools not written in Go (e.g., notebooks and
+// dashboards) may consume studies and their runs without linking the
+// diviner library. The API's resources are:
+//
+//	GET /v1/studies
+//		The studies, ordered by name. Parameters: prefix (a prefix
+//		of the names of the listed studies), since (see below).
+//	GET /v1/studies/{study}
+//		The named study: its description, objectives, and
+//		parameters (encoded as by diviner.Params.MarshalJSON).
+//	GET /v1/studies/{study}/runs
+//		The study's runs, ordered by sequence number, as
+//		export.Records. Parameters: state (a comma-separated list
+//		of run states, e.g., "success,pending"), since, labels (a
+//		label selector, as parsed by diviner.ParseSelector),
+//		value (name=value, a parameter value that runs must have;
+//		repeatable), metric (name>bound or name<bound, a predicate
+//		on the runs' latest metrics; repeatable).
+//	GET /v1/studies/{study}/runs/{seq}
+//		The run with the provided sequence number.
+//	GET /v1/studies/{study}/runs/{seq}/metrics
+//		The run's metric history, ordered as reported.
+//	GET /v1/studies/{study}/runs/{seq}/logs
+//		The run's logs, as plain text. Parameters: since, follow
+//		("true" to stream the logs until the run completes), stream
+//		(the output streams to return: "stdout", "stderr", or
+//		"all", the default).
+//	GET /v1/studies/{study}/leaderboard
+//		The study's best trials (see diviner.Leaderboard).
+//		Parameters: objective (a metric name, prefixed by "-" to
+//		minimize it; defaults to the study's objective), n (the
+//		number of trials; default 10, or all trials if 0).
+//	GET /v1/studies/{study}/trials
+//		The study's trials, whose runs' metrics are aggregated over
+//		replicates (see diviner.Trials), ordered by their first
+//		runs. Parameters: state (default "success,pending").
+//	POST /v1/studies/{study}/trials
+//		Submits a trial that was evaluated outside of diviner, as a
+//		Submission, if the server permits it (Server.Submit). The
+//		trial is recorded as a successful run of the study, which is
+//		returned, so that it is visible to the study's oracle.
+//
+// Study names are path segments, and must thus be escaped
+// (e.g., "/" as "%2F"). Times given by the since parameter are
+// RFC 3339 timestamps, or durations (e.g., "24h") that are relative
+// to the time of the request; only entries updated since then are
+// returned.
+//
+// Lists are paginated: they return at most page_size (default 100,
+// at most 1000) entries, together with a next_page_token, which is
+// passed as the page_token parameter to retrieve the following page;
+// it is empty on the last page. Errors are reported with an
+// appropriate status code and an object with an "error" member.
+//
+// The API is described by the OpenAPI specification openapi.yaml in
+// this directory; the Python package in the repository's python
+// directory is a client of the API.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/export"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	// MaxSubmission is the maximum size of a submission, in bytes.
+	maxSubmission = 32 << 20
+)
+
+// A Study is the representation of a study.
+type Study struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Objectives  []Objective    `json:"objectives"`
+	Params      diviner.Params `json:"params"`
+	Replicates  int            `json:"replicates,omitempty"`
+	Transfer    []string       `json:"transfer,omitempty"`
+	Archived    bool           `json:"archived,omitempty"`
+}
+
+// An Objective is the representation of a study's objective.
+type Objective struct {
+	Metric string `json:"metric"`
+	// Direction is "maximize" or "minimize".
+	Direction string `json:"direction"`
+}
+
+// NewStudy returns the representation of the provided study.
+func NewStudy(study diviner.Study) Study {
+	s := Study{
+		Name:        study.Name,
+		Description: study.Description,
+		Params:      study.Params,
+		Replicates:  study.Replicates,
+		Transfer:    study.Transfer,
+		Archived:    study.Archived,
+	}
+	for _, obj := range study.AllObjectives() {
+		s.Objectives = append(s.Objectives, Objective{obj.Metric, obj.Direction.String()})
+	}
+	return s
+}
+
+// A Step is a report of a run's metric history.
+type Step struct {
+	// Step is the step of the report (see diviner.Run.History).
+	Step    int                    `json:"step"`
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+// A Trial is a trial of a study, or an entry of its leaderboard.
+type Trial struct {
+	// Rank is the rank of the trial in the leaderboard, starting at
+	// 1. It is omitted outside of leaderboards.
+	Rank int `json:"rank,omitempty"`
+	// Runs are the IDs of the runs comprised by the trial.
+	Runs []string `json:"runs"`
+	// Replicates is the number of the trial's completed replicates.
+	Replicates int                    `json:"replicates"`
+	Pending    bool                   `json:"pending"`
+	Values     map[string]interface{} `json:"values"`
+	Metrics    map[string]interface{} `json:"metrics"`
+}
+
+// NewTrial returns the representation of the provided trial.
+func NewTrial(trial diviner.Trial) Trial {
+	t := Trial{
+		Runs:       make([]string, len(trial.Runs)),
+		Replicates: trial.Replicates.Count(),
+		Pending:    trial.Pending,
+		Values:     export.JSONValues(trial.Values),
+		Metrics:    export.JSONMetrics(trial.Metrics),
+	}
+	for i, run := range trial.Runs {
+		t.Runs[i] = run.ID()
+	}
+	return t
+}
+
+// A Submission is a trial that was evaluated outside of diviner.
+type Submission struct {
+	// Values are the trial's parameter values, which must be valid
+	// for the study's parameters. Numbers are accepted for integer
+	// and real parameters; strings for string parameters; and
+	// booleans for boolean parameters.
+	Values map[string]interface{} `json:"values"`
+	// Metrics is the trial's metric history, ordered as reported. Its
+	// last report is the trial's metrics.
+	Metrics []map[string]float64 `json:"metrics"`
+	// Labels are the labels of the trial's run.
+	Labels diviner.Labels `json:"labels,omitempty"`
+	// Replicate is the replicate of the trial represented by the
+	// submission.
+	Replicate int `json:"replicate,omitempty"`
+}
+
+// Server is an http.Handler that serves the API over a database.
+type Server struct {
+	// Submit permits the submission of trials, which are written to
+	// the database. Otherwise, the server only reads the database.
+	Submit bool
+
+	db diviner.Database
+}
+
+// New returns a new server that serves the provided database.
+func New(db diviner.Database) *Server {
+	return &Server{db: db}
+}
+
+// HTTPError is an error with an HTTP status code.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e httpError) Error() string { return e.err.Error() }
+
+func badRequest(format string, args ...interface{}) error {
+	return httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var path []string
+	for _, elem := range strings.Split(strings.Trim(req.URL.EscapedPath(), "/"), "/") {
+		elem, err := url.PathUnescape(elem)
+		if err != nil {
+			writeError(w, badRequest("invalid path: %v", err))
+			return
+		}
+		path = append(path, elem)
+	}
+	if len(path) < 2 || path[0] != "v1" || path[1] != "studies" {
+		writeError(w, httpError{http.StatusNotFound, fmt.Errorf("no such resource %s", req.URL.Path)})
+		return
+	}
+	path = path[2:]
+	var (
+		reply  interface{}
+		err    error
+		status = http.StatusOK
+	)
+	switch {
+	case len(path) == 2 && path[1] == "trials" && req.Method == "POST":
+		if !s.Submit {
+			err = httpError{http.StatusForbidden, errors.New("the server does not permit submissions")}
+			break
+		}
+		reply, err = s.submit(req, path[0])
+		status = http.StatusCreated
+	case req.Method != "GET" && req.Method != "HEAD":
+		err = httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method)}
+	case len(path) == 0:
+		reply, err = s.studies(req)
+	case len(path) == 1:
+		reply, err = s.study(req, path[0])
+	case len(path) == 2 && path[1] == "runs":
+		reply, err = s.runs(req, path[0])
+	case len(path) == 2 && path[1] == "leaderboard":
+		reply, err = s.leaderboard(req, path[0])
+	case len(path) == 2 && path[1] == "trials":
+		reply, err = s.trials(req, path[0])
+	case len(path) >= 3 && path[1] == "runs":
+		var seq uint64
+		if seq, err = strconv.ParseUint(path[2], 10, 64); err != nil {
+			err = badRequest("invalid run %s", path[2])
+			break
+		}
+		switch {
+		case len(path) == 3:
+			reply, err = s.run(req, path[0], seq)
+		case len(path) == 4 && path[3] == "metrics":
+			reply, err = s.metrics(req, path[0], seq)
+		case len(path) == 4 && path[3] == "logs":
+			err = s.logs(w, req, path[0], seq)
+			if err == nil {
+				return
+			}
+		default:
+			err = httpError{http.StatusNotFound, fmt.Errorf("no such resource %s", req.URL.Path)}
+		}
+	default:
+		err = httpError{http.StatusNotFound, fmt.Errorf("no such resource %s", req.URL.Path)}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		log.Error.Printf("api: error encoding reply to %s: %v", req.URL, err)
+	}
+}
+
+func (s *Server) studies(req *http.Request) (interface{}, error) {
+	query := req.URL.Query()
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		return nil, err
+	}
+	size, token, err := page(query)
+	if err != nil {
+		return nil, err
+	}
+	studies, err := s.db.ListStudies(req.Context(), query.Get("prefix"), since)
+	if err != nil && err != diviner.ErrNotExist {
+		return nil, err
+	}
+	sort.Slice(studies, func(i, j int) bool { return studies[i].Name < studies[j].Name })
+	archived := query.Get("archived") == "true"
+	reply := struct {
+		Studies       []Study `json:"studies"`
+		NextPageToken string  `json:"next_page_token"`
+	}{Studies: []Study{}}
+	for _, study := range studies {
+		if token != "" && study.Name <= token || study.Archived && !archived {
+			continue
+		}
+		if len(reply.Studies) == size {
+			reply.NextPageToken = reply.Studies[size-1].Name
+			break
+		}
+		reply.Studies = append(reply.Studies, NewStudy(study))
+	}
+	return reply, nil
+}
+
+func (s *Server) study(req *http.Request, name string) (interface{}, error) {
+	study, err := s.db.LookupStudy(req.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	return NewStudy(study), nil
+}
+
+func (s *Server) runs(req *http.Request, name string) (interface{}, error) {
+	var (
+		ctx   = req.Context()
+		query = req.URL.Query()
+		q     diviner.Query
+	)
+	study, err := s.db.LookupStudy(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if states := query.Get("state"); states != "" {
+		if q.States, err = parseStates(states); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range query["value"] {
+		if q.Values == nil {
+			q.Values = make(diviner.Values)
+		}
+		if err := parseValue(study.Params, q.Values, v); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range query["metric"] {
+		if err := parseMetric(&q, m); err != nil {
+			return nil, err
+		}
+	}
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		return nil, err
+	}
+	sel, err := diviner.ParseSelector(query.Get("labels"))
+	if err != nil {
+		return nil, badRequest("invalid labels: %v", err)
+	}
+	size, token, err := page(query)
+	if err != nil {
+		return nil, err
+	}
+	var after uint64
+	if token != "" {
+		if after, err = strconv.ParseUint(token, 10, 64); err != nil {
+			return nil, badRequest("invalid page token %q", token)
+		}
+	}
+	runs, err := s.db.Query(ctx, name, q)
+	if err != nil && err != diviner.ErrNotExist {
+		return nil, err
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Seq < runs[j].Seq })
+	reply := struct {
+		Runs          []export.Record `json:"runs"`
+		NextPageToken string          `json:"next_page_token"`
+	}{Runs: []export.Record{}}
+	for _, run := range runs {
+		if run.Seq <= after || run.Updated.Before(since) || !sel.Matches(run.Labels) {
+			continue
+		}
+		if len(reply.Runs) == size {
+			reply.NextPageToken = strconv.FormatUint(reply.Runs[size-1].Seq, 10)
+			break
+		}
+		reply.Runs = append(reply.Runs, export.NewRecord(run))
+	}
+	return reply, nil
+}
+
+func (s *Server) run(req *http.Request, study string, seq uint64) (interface{}, error) {
+	run, err := s.db.LookupRun(req.Context(), study, seq)
+	if err != nil {
+		return nil, err
+	}
+	return export.NewRecord(run), nil
+}
+
+func (s *Server) metrics(req *http.Request, study string, seq uint64) (interface{}, error) {
+	size, token, err := page(req.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	var offset int
+	if token != "" {
+		if offset, err = strconv.Atoi(token); err != nil || offset < 0 {
+			return nil, badRequest("invalid page token %q", token)
+		}
+	}
+	run, err := s.db.LookupRun(req.Context(), study, seq)
+	if err != nil {
+		return nil, err
+	}
+	reply := struct {
+		Metrics       []Step `json:"metrics"`
+		NextPageToken string `json:"next_page_token"`
+	}{Metrics: []Step{}}
+	history := run.History()
+	if offset > len(history) {
+		offset = len(history)
+	}
+	history = history[offset:]
+	if len(history) > size {
+		history = history[:size]
+		reply.NextPageToken = strconv.Itoa(offset + size)
+	}
+	for _, step := range history {
+		reply.Metrics = append(reply.Metrics, Step{step.Step, export.JSONMetrics(step.Metrics)})
+	}
+	return reply, nil
+}
+
+func (s *Server) leaderboard(req *http.Request, name string) (interface{}, error) {
+	var (
+		ctx   = req.Context()
+		query = req.URL.Query()
+		n     = 10
+	)
+	study, err := s.db.LookupStudy(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	objective := study.Objective
+	if spec := query.Get("objective"); spec != "" {
+		objective = diviner.Objective{Direction: diviner.Maximize, Metric: spec}
+		switch {
+		case strings.HasPrefix(spec, "-"):
+			objective = diviner.Objective{Direction: diviner.Minimize, Metric: spec[1:]}
+		case strings.HasPrefix(spec, "+"):
+			objective.Metric = spec[1:]
+		}
+	}
+	if v := query.Get("n"); v != "" {
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			return nil, badRequest("invalid n %q", v)
+		}
+	}
+	trials, err := diviner.Leaderboard(ctx, s.db, name, objective, n)
+	if err != nil {
+		return nil, err
+	}
+	reply := struct {
+		Objective Objective `json:"objective"`
+		Trials    []Trial   `json:"trials"`
+	}{
+		Objective: Objective{objective.Metric, objective.Direction.String()},
+		Trials:    make([]Trial, len(trials)),
+	}
+	for i, trial := range trials {
+		reply.Trials[i] = NewTrial(trial)
+		reply.Trials[i].Rank = i + 1
+	}
+	return reply, nil
+}
+
+func (s *Server) trials(req *http.Request, name string) (interface{}, error) {
+	var (
+		ctx    = req.Context()
+		query  = req.URL.Query()
+		states = diviner.Success | diviner.Pending
+	)
+	study, err := s.db.LookupStudy(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if v := query.Get("state"); v != "" {
+		if states, err = parseStates(v); err != nil {
+			return nil, err
+		}
+	}
+	size, token, err := page(query)
+	if err != nil {
+		return nil, err
+	}
+	var offset int
+	if token != "" {
+		if offset, err = strconv.Atoi(token); err != nil || offset < 0 {
+			return nil, badRequest("invalid page token %q", token)
+		}
+	}
+	m, err := diviner.Trials(ctx, s.db, study, states)
+	if err != nil {
+		return nil, err
+	}
+	var trials []diviner.Trial
+	m.Range(func(_ diviner.Value, v interface{}) {
+		trials = append(trials, v.(diviner.Trial))
+	})
+	sort.SliceStable(trials, func(i, j int) bool {
+		return firstSeq(trials[i]) < firstSeq(trials[j])
+	})
+	reply := struct {
+		Trials        []Trial `json:"trials"`
+		NextPageToken string  `json:"next_page_token"`
+	}{Trials: []Trial{}}
+	if offset > len(trials) {
+		offset = len(trials)
+	}
+	trials = trials[offset:]
+	if len(trials) > size {
+		trials = trials[:size]
+		reply.NextPageToken = strconv.Itoa(offset + size)
+	}
+	for _, trial := range trials {
+		reply.Trials = append(reply.Trials, NewTrial(trial))
+	}
+	return reply, nil
+}
+
+func (s *Server) submit(req *http.Request, name string) (interface{}, error) {
+	ctx := req.Context()
+	study, err := s.db.LookupStudy(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var sub Submission
+	dec := json.NewDecoder(io.LimitReader(req.Body, maxSubmission))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sub); err != nil {
+		return nil, badRequest("invalid submission: %v", err)
+	}
+	values := make(diviner.Values)
+	for name, v := range sub.Values {
+		param, ok := study.Params[name]
+		if !ok {
+			return nil, badRequest("invalid submission: no parameter %s", name)
+		}
+		if values[name], err = decodeValue(param, v); err != nil {
+			return nil, badRequest("invalid submission: parameter %s: %v", name, err)
+		}
+	}
+	if !study.Params.IsValid(values) {
+		return nil, badRequest("invalid submission: values %s are not valid for the study's parameters", values)
+	}
+	if len(sub.Metrics) == 0 {
+		return nil, badRequest("invalid submission: no metrics")
+	}
+	if sub.Replicate < 0 || sub.Replicate > study.Replicates {
+		return nil, badRequest("invalid submission: invalid replicate %d", sub.Replicate)
+	}
+	run, err := s.db.InsertRun(ctx, diviner.Run{
+		Study:     study.Name,
+		Values:    values,
+		Labels:    sub.Labels,
+		Replicate: sub.Replicate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, metrics := range sub.Metrics {
+		if err := s.db.AppendRunMetrics(ctx, study.Name, run.Seq, metrics); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.UpdateRun(ctx, study.Name, run.Seq, diviner.Success, "submitted", 0, 0); err != nil {
+		return nil, err
+	}
+	if run, err = s.db.LookupRun(ctx, study.Name, run.Seq); err != nil {
+		return nil, err
+	}
+	return export.NewRecord(run), nil
+}
+
+func (s *Server) logs(w http.ResponseWriter, req *http.Request, study string, seq uint64) error {
+	query := req.URL.Query()
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		return err
+	}
+	follow := query.Get("follow") == "true"
+	streams, err := diviner.ParseLogStream(query.Get("stream"))
+	if err != nil {
+		return badRequest("%v", err)
+	}
+	// Look up the run first, so that missing runs are reported
+	// before the response is written.
+	if _, err := s.db.LookupRun(req.Context(), study, seq); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var out io.Writer = w
+	if flusher, ok := w.(http.Flusher); ok && follow {
+		out = flushWriter{w, flusher}
+	}
+	if _, err := io.Copy(out, diviner.FilterLog(s.db.Log(study, seq, since, follow), streams)); err != nil {
+		// The response has been written, so the error can only be
+		// logged.
+		log.Error.Printf("api: error copying logs of %s:%d: %v", study, seq, err)
+	}
+	return nil
+}
+
+// FlushWriter flushes each write, so that followed logs are streamed
+// to clients as they are written.
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.f.Flush()
+	return n, err
+}
+
+// FirstSeq returns the smallest sequence number of the trial's runs.
+func firstSeq(trial diviner.Trial) uint64 {
+	var seq uint64
+	for i, run := range trial.Runs {
+		if i == 0 || run.Seq < seq {
+			seq = run.Seq
+		}
+	}
+	return seq
+}
+
+// DecodeValue returns the value of the provided parameter represented
+// by the provided JSON value.
+func decodeValue(param diviner.Param, v interface{}) (diviner.Value, error) {
+	switch v := v.(type) {
+	case float64:
+		switch param.Kind() {
+		case diviner.Integer:
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("%v is not an integer", v)
+			}
+			return diviner.Int(int64(v)), nil
+		case diviner.Real:
+			return diviner.Float(v), nil
+		}
+	case string:
+		if param.Kind() == diviner.Str {
+			return diviner.String(v), nil
+		}
+	case bool:
+		if param.Kind() == diviner.Boolean {
+			return diviner.Bool(v), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s value %v", param.Kind(), v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if e, ok := err.(httpError); ok {
+		code = e.code
+	} else if err == diviner.ErrNotExist {
+		code = http.StatusNotFound
+	} else if err == diviner.ErrArchived {
+		code = http.StatusConflict
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// Page returns the page size and token of a list request.
+func page(query url.Values) (size int, token string, err error) {
+	size = defaultPageSize
+	if v := query.Get("page_size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+			return 0, "", badRequest("invalid page size %q", v)
+		}
+		if size > maxPageSize {
+			size = maxPageSize
+		}
+	}
+	return size, query.Get("page_token"), nil
+}
+
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, badRequest("since=%s does not parse as a duration or RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+var states = []diviner.RunState{diviner.Pending, diviner.Success, diviner.Failure, diviner.TimedOut, diviner.Preempted}
+
+func parseStates(list string) (diviner.RunState, error) {
+	var state diviner.RunState
+	for _, name := range strings.Split(list, ",") {
+		var ok bool
+		for _, s := range states {
+			if s.String() == name {
+				state |= s
+				ok = true
+			}
+		}
+		if !ok {
+			return 0, badRequest("invalid run state %s", name)
+		}
+	}
+	return state, nil
+}
+
+// ParseValue parses the value predicate name=value into values,
+// according to the kind of the named parameter.
+func parseValue(params diviner.Params, values diviner.Values, pred string) error {
+	i := strings.IndexByte(pred, '=')
+	if i < 0 {
+		return badRequest("invalid value %q: expected name=value", pred)
+	}
+	name, str := pred[:i], pred[i+1:]
+	param, ok := params[name]
+	if !ok {
+		return badRequest("invalid value %q: no parameter %s", pred, name)
+	}
+	var (
+		v   diviner.Value
+		err error
+	)
+	switch param.Kind() {
+	case diviner.Integer:
+		var n int64
+		n, err = strconv.ParseInt(str, 10, 64)
+		v = diviner.Int(n)
+	case diviner.Real:
+		var f float64
+		f, err = strconv.ParseFloat(str, 64)
+		v = diviner.Float(f)
+	case diviner.Str:
+		v = diviner.String(str)
+	case diviner.Boolean:
+		var b bool
+		b, err = strconv.ParseBool(str)
+		v = diviner.Bool(b)
+	default:
+		return badRequest("invalid value %q: parameter %s of kind %s cannot be queried", pred, name, param.Kind())
+	}
+	if err != nil {
+		return badRequest("invalid value %q: %v", pred, err)
+	}
+	values[name] = v
+	return nil
+}
+
+// ParseMetric parses the metric predicate name>bound or name<bound
+// into the provided query.
+func parseMetric(q *diviner.Query, pred string) error {
+	i := strings.IndexAny(pred, "<>")
+	if i < 0 {
+		return badRequest("invalid metric %q: expected name>bound or name<bound", pred)
+	}
+	bound, err := strconv.ParseFloat(pred[i+1:], 64)
+	if err != nil {
+		return badRequest("invalid metric %q: %v", pred, err)
+	}
+	m := &q.MetricBelow
+	if pred[i] == '>' {
+		m = &q.MetricAbove
+	}
+	if *m == nil {
+		*m = make(map[string]float64)
+	}
+	(*m)[pred[:i]] = bound
+	return nil
+}
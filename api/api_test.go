@@ -0,0 +1,441 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/api"
+	"github.com/grailbio/diviner/export"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(api.New(newDB(t)))
+}
+
+func newDB(t *testing.T) diviner.Database {
+	t.Helper()
+	var (
+		ctx = context.Background()
+		db  = memdb.New()
+	)
+	for _, name := range []string{"a/test", "b", "c"} {
+		study := diviner.Study{
+			Name: name,
+			Params: diviner.Params{
+				"lr":  diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+				"opt": diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd")),
+			},
+			Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+		}
+		if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		opt := "adam"
+		if i%2 == 1 {
+			opt = "sgd"
+		}
+		run, err := db.InsertRun(ctx, diviner.Run{
+			Study:  "a/test",
+			Values: diviner.Values{"lr": diviner.Float(float64(i) / 10), "opt": diviner.String(opt)},
+			Labels: diviner.Labels{"opt": opt},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for step := 1; step <= 3; step++ {
+			metrics := diviner.Metrics{"step": float64(step), "acc": float64(i)/10 + float64(step)/100}
+			if err := db.AppendRunMetrics(ctx, "a/test", run.Seq, metrics); err != nil {
+				t.Fatal(err)
+			}
+		}
+		state := diviner.Success
+		if i == 4 {
+			state = diviner.Pending
+		}
+		if err := db.UpdateRun(ctx, "a/test", run.Seq, state, "", time.Minute, 0); err != nil {
+			t.Fatal(err)
+		}
+		w := db.Logger("a/test", run.Seq)
+		fmt.Fprintf(w, "run %d\n%cwarning %d\n", run.Seq, diviner.StderrTag, run.Seq)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func get(t *testing.T, srv *httptest.Server, path string, reply interface{}) int {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestStudies(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+	var (
+		names []string
+		token string
+	)
+	for {
+		var reply struct {
+			Studies       []api.Study
+			NextPageToken string `json:"next_page_token"`
+		}
+		if code := get(t, srv, "/v1/studies?page_size=2&page_token="+url.QueryEscape(token), &reply); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+		for _, study := range reply.Studies {
+			names = append(names, study.Name)
+		}
+		if token = reply.NextPageToken; token == "" {
+			break
+		}
+	}
+	if got, want := names, []string{"a/test", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var study api.Study
+	if code := get(t, srv, "/v1/studies/a%2Ftest", &study); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if got, want := study.Objectives, []api.Objective{{"acc", "maximize"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(study.Params), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var e struct{ Error string }
+	if code := get(t, srv, "/v1/studies/nonexistent", &e); code != http.StatusNotFound {
+		t.Errorf("got status %d", code)
+	}
+	if e.Error == "" {
+		t.Error("missing error")
+	}
+}
+
+func TestArchivedStudies(t *testing.T) {
+	db := newDB(t)
+	if err := diviner.ArchiveStudy(context.Background(), db, "b", true); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(api.New(db))
+	defer srv.Close()
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a/test", "c"}},
+		{"?archived=true", []string{"a/test", "b", "c"}},
+	} {
+		var reply struct{ Studies []api.Study }
+		if code := get(t, srv, "/v1/studies"+c.query, &reply); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+		var names []string
+		for _, study := range reply.Studies {
+			names = append(names, study.Name)
+		}
+		if got, want := names, c.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", c.query, got, want)
+		}
+	}
+	var study api.Study
+	if code := get(t, srv, "/v1/studies/b", &study); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if !study.Archived {
+		t.Error("study is not archived")
+	}
+}
+
+func TestRuns(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+	for _, c := range []struct {
+		query string
+		want  []uint64
+	}{
+		{"", []uint64{1, 2, 3, 4, 5}},
+		{"state=success", []uint64{1, 2, 3, 4}},
+		{"state=pending,success&value=opt=sgd", []uint64{2, 4}},
+		{"metric=acc>0.2&metric=acc<0.4", []uint64{3, 4}},
+		{"labels=opt=adam", []uint64{1, 3, 5}},
+		{"since=1h", []uint64{1, 2, 3, 4, 5}},
+		{"since=2000-01-01T00:00:00Z&value=lr=0.1", []uint64{2}},
+	} {
+		var (
+			seqs  []uint64
+			token string
+		)
+		for {
+			var reply struct {
+				Runs          []export.Record
+				NextPageToken string `json:"next_page_token"`
+			}
+			path := "/v1/studies/a%2Ftest/runs?page_size=2&page_token=" + token + "&" + c.query
+			if code := get(t, srv, path, &reply); code != http.StatusOK {
+				t.Fatalf("%s: got status %d", path, code)
+			}
+			for _, run := range reply.Runs {
+				seqs = append(seqs, run.Seq)
+			}
+			if token = reply.NextPageToken; token == "" {
+				break
+			}
+		}
+		if got, want := seqs, c.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", c.query, got, want)
+		}
+	}
+	var e struct{ Error string }
+	for _, query := range []string{"state=done", "value=depth=1", "value=lr=x", "metric=acc", "since=never", "page_size=-1"} {
+		if code := get(t, srv, "/v1/studies/a%2Ftest/runs?"+query, &e); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", query, code)
+		}
+	}
+
+	var run export.Record
+	if code := get(t, srv, "/v1/studies/a%2Ftest/runs/2", &run); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if got, want := run.Metrics["acc"], 0.13; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if code := get(t, srv, "/v1/studies/a%2Ftest/runs/100", &e); code != http.StatusNotFound {
+		t.Errorf("got status %d", code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+	var reply struct {
+		Metrics       []api.Step
+		NextPageToken string `json:"next_page_token"`
+	}
+	if code := get(t, srv, "/v1/studies/a%2Ftest/runs/1/metrics?page_size=2", &reply); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if got, want := reply.Metrics, []api.Step{{1, map[string]interface{}{"acc": 0.01}}, {2, map[string]interface{}{"acc": 0.02}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := reply.NextPageToken, "2"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	reply.Metrics = nil
+	if code := get(t, srv, "/v1/studies/a%2Ftest/runs/1/metrics?page_size=2&page_token=2", &reply); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if got, want := reply.Metrics, []api.Step{{3, map[string]interface{}{"acc": 0.03}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := reply.NextPageToken, ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+	var reply struct {
+		Objective api.Objective
+		Trials    []api.Trial
+	}
+	if code := get(t, srv, "/v1/studies/a%2Ftest/leaderboard?n=2", &reply); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	var runs []string
+	for _, trial := range reply.Trials {
+		runs = append(runs, trial.Runs...)
+	}
+	if got, want := runs, []string{"a/test:4", "a/test:3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := reply.Trials[0].Values["opt"], "sgd"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := reply.Trials[1].Rank, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if code := get(t, srv, "/v1/studies/a%2Ftest/leaderboard?objective=-acc&n=1", &reply); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if got, want := reply.Objective, (api.Objective{"acc", "minimize"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := reply.Trials[0].Runs, []string{"a/test:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+	for _, c := range []struct {
+		query, want string
+	}{
+		{"", "run 3\nwarning 3\n"},
+		{"?stream=all", "run 3\nwarning 3\n"},
+		{"?stream=stdout", "run 3\n"},
+		{"?stream=stderr", "warning 3\n"},
+	} {
+		resp, err := http.Get(srv.URL + "/v1/studies/a%2Ftest/runs/3/logs" + c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("%s: got %v, want %v", c.query, got, want)
+		}
+		if got, want := string(b), c.want; got != want {
+			t.Errorf("%s: got %q, want %q", c.query, got, want)
+		}
+	}
+	var e struct{ Error string }
+	if code := get(t, srv, "/v1/studies/a%2Ftest/runs/100/logs", &e); code != http.StatusNotFound {
+		t.Errorf("got status %d", code)
+	}
+	if code := get(t, srv, "/v1/studies/a%2Ftest/runs/3/logs?stream=bogus", &e); code != http.StatusBadRequest {
+		t.Errorf("got status %d", code)
+	}
+}
+
+func TestTrials(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+	var (
+		runs  []string
+		token string
+	)
+	for {
+		var reply struct {
+			Trials        []api.Trial
+			NextPageToken string `json:"next_page_token"`
+		}
+		if code := get(t, srv, "/v1/studies/a%2Ftest/trials?page_size=2&page_token="+token, &reply); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+		for _, trial := range reply.Trials {
+			runs = append(runs, trial.Runs...)
+			if got, want := trial.Pending, trial.Runs[0] == "a/test:5"; got != want {
+				t.Errorf("%v: got %v, want %v", trial.Runs, got, want)
+			}
+		}
+		if token = reply.NextPageToken; token == "" {
+			break
+		}
+	}
+	if got, want := runs, []string{"a/test:1", "a/test:2", "a/test:3", "a/test:4", "a/test:5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var reply struct{ Trials []api.Trial }
+	if code := get(t, srv, "/v1/studies/a%2Ftest/trials?state=pending", &reply); code != http.StatusOK {
+		t.Fatalf("got status %d", code)
+	}
+	if got, want := len(reply.Trials), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func post(t *testing.T, srv *httptest.Server, path, body string, reply interface{}) int {
+	t.Helper()
+	resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestSubmit(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		db     = newDB(t)
+		server = api.New(db)
+		srv    = httptest.NewServer(server)
+	)
+	defer srv.Close()
+	const submission = `{"values": {"lr": 0.5, "opt": "sgd"}, "metrics": [{"acc": 0.6}, {"acc": 0.7}], "labels": {"source": "notebook"}}`
+	var e struct{ Error string }
+	if code := post(t, srv, "/v1/studies/a%2Ftest/trials", submission, &e); code != http.StatusForbidden {
+		t.Errorf("got status %d", code)
+	}
+	server.Submit = true
+	var run export.Record
+	if code := post(t, srv, "/v1/studies/a%2Ftest/trials", submission, &run); code != http.StatusCreated {
+		t.Fatalf("got status %d", code)
+	}
+	if got, want := run.Seq, uint64(6); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.State, "success"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	stored, err := db.LookupRun(ctx, "a/test", run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored.Values, (diviner.Values{"lr": diviner.Float(0.5), "opt": diviner.String("sgd")}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stored.Metrics, []diviner.Metrics{{"acc": 0.6}, {"acc": 0.7}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := stored.Labels["source"], "notebook"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, body := range []string{
+		`{"values": {"lr": 0.5, "opt": "rmsprop"}, "metrics": [{"acc": 0.6}]}`,
+		`{"values": {"lr": "0.5", "opt": "sgd"}, "metrics": [{"acc": 0.6}]}`,
+		`{"values": {"lr": 0.5, "depth": 1}, "metrics": [{"acc": 0.6}]}`,
+		`{"values": {"lr": 0.5, "opt": "sgd"}}`,
+		`{"values": {"lr": 0.5, "opt": "sgd"}, "metrics": [{"acc": 0.6}], "extra": 1}`,
+		`not json`,
+	} {
+		if code := post(t, srv, "/v1/studies/a%2Ftest/trials", body, &e); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", body, code)
+		}
+	}
+	if code := post(t, srv, "/v1/studies/nonexistent/trials", submission, &e); code != http.StatusNotFound {
+		t.Errorf("got status %d", code)
+	}
+	if code := post(t, srv, "/v1/studies/a%2Ftest/runs", submission, &e); code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d", code)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grailbio/diviner/storage"
+)
+
+// MaxInlineArtifactSize is the maximum size of an artifact's inline
+// payload. Larger artifacts should be stored externally and
+// registered by their URLs.
+const MaxInlineArtifactSize = 256 << 10
+
+// An Artifact is an output of a run, such as a model's weights, a
+// confusion matrix, or a plot, that is registered with the database
+// so that downstream tools may find it. An artifact's contents are
+// either stored externally, at its URL, or, for small artifacts,
+// inline in the database.
+//
+// Run scripts register artifacts by printing a directive line of the
+// form
+//
+//	DIVINER: artifact=model=s3://bucket/models/run1.pt
+//
+// or, with the artifact as a JSON object (see ParseArtifact),
+//
+//	DIVINER: artifact={"name": "confusion", "data": "eyJ0cCI6IDN9"}
+type Artifact struct {
+	// Name is the name of the artifact. It is unique within a run.
+	Name string `json:"name"`
+	// URL is the URL at which the artifact's contents are stored,
+	// e.g., "s3://bucket/models/run1.pt".
+	URL string `json:"url,omitempty"`
+	// Data is the artifact's inline contents. It is set only if URL
+	// is empty.
+	Data []byte `json:"data,omitempty"`
+}
+
+// ParseArtifact parses an artifact registered by a run's script,
+// either as a JSON object with the fields "name" and "url" or "data"
+// (a base64-encoded string), or of the form "name=url".
+func ParseArtifact(s string) (Artifact, error) {
+	var a Artifact
+	if strings.HasPrefix(s, "{") {
+		if err := json.Unmarshal([]byte(s), &a); err != nil {
+			return Artifact{}, fmt.Errorf("artifact %s: %v", s, err)
+		}
+	} else {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return Artifact{}, fmt.Errorf("artifact %s: expected name=url", s)
+		}
+		a.Name, a.URL = parts[0], parts[1]
+	}
+	return a, a.Validate()
+}
+
+// Validate returns an error if the artifact is invalid.
+func (a Artifact) Validate() error {
+	switch {
+	case a.Name == "":
+		return errors.New("artifact has no name")
+	case a.URL == "" && a.Data == nil:
+		return fmt.Errorf("artifact %s has neither a URL nor data", a.Name)
+	case a.URL != "" && a.Data != nil:
+		return fmt.Errorf("artifact %s has both a URL and data", a.Name)
+	case len(a.Data) > MaxInlineArtifactSize:
+		return fmt.Errorf("artifact %s: inline data exceeds %d bytes", a.Name, MaxInlineArtifactSize)
+	}
+	return nil
+}
+
+// String returns a textual description of the artifact.
+func (a Artifact) String() string {
+	if a.URL != "" {
+		return fmt.Sprintf("%s=%s", a.Name, a.URL)
+	}
+	return fmt.Sprintf("%s (inline, %d bytes)", a.Name, len(a.Data))
+}
+
+// Open returns a reader of the artifact's contents.
+func (a Artifact) Open(ctx context.Context) (io.ReadCloser, error) {
+	if a.URL == "" {
+		return ioutil.NopCloser(bytes.NewReader(a.Data)), nil
+	}
+	return storage.Open(ctx, a.URL)
+}
+
+// Artifact returns the run's artifact with the provided name.
+func (r Run) Artifact(name string) (Artifact, bool) {
+	for _, a := range r.Artifacts {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// AddArtifact registers the provided artifact with the run named by
+// the provided study and sequence number, replacing any existing
+// artifact with the same name. It returns the run's artifacts.
+//
+// AddArtifact reads the run's artifacts before replacing them; thus
+// concurrent registrations of the same run's artifacts may be lost.
+func AddArtifact(ctx context.Context, db Database, study string, seq uint64, artifact Artifact) ([]Artifact, error) {
+	if err := artifact.Validate(); err != nil {
+		return nil, err
+	}
+	run, err := db.LookupRun(ctx, study, seq)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := make([]Artifact, 0, len(run.Artifacts)+1)
+	for _, a := range run.Artifacts {
+		if a.Name != artifact.Name {
+			artifacts = append(artifacts, a)
+		}
+	}
+	artifacts = append(artifacts, artifact)
+	return artifacts, db.SetRunArtifacts(ctx, study, seq, artifacts)
+}
+
+// BestArtifact returns the artifact with the provided name from the
+// best successful run of the provided study, as determined by the
+// study's objective, among those that registered such an artifact.
+// BestArtifact returns ErrNotExist if no run did.
+func BestArtifact(ctx context.Context, db Database, study Study, name string) (Run, Artifact, error) {
+	runs, err := db.ListRuns(ctx, study.Name, Success, time.Time{})
+	if err != nil {
+		return Run{}, Artifact{}, err
+	}
+	var (
+		best     Run
+		artifact Artifact
+		value    float64
+		found    bool
+	)
+	for _, run := range runs {
+		a, ok := run.Artifact(name)
+		if !ok {
+			continue
+		}
+		trial := study.Trial(run)
+		v, ok := trial.Metrics[study.Objective.Metric]
+		if !ok || math.IsNaN(v) || trial.Infeasible {
+			continue
+		}
+		if !found || (study.Objective.Direction == Maximize && v > value) || (study.Objective.Direction == Minimize && v < value) {
+			best, artifact, value, found = run, a, v, true
+		}
+	}
+	if !found {
+		return Run{}, Artifact{}, ErrNotExist
+	}
+	return best, artifact, nil
+}
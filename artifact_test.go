@@ -0,0 +1,84 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/testutil"
+)
+
+func TestParseArtifact(t *testing.T) {
+	for _, c := range []struct {
+		arg      string
+		artifact diviner.Artifact
+	}{
+		{"model=s3://bucket/model.pt", diviner.Artifact{Name: "model", URL: "s3://bucket/model.pt"}},
+		{`{"name": "plot", "url": "/tmp/plot.png"}`, diviner.Artifact{Name: "plot", URL: "/tmp/plot.png"}},
+		{`{"name": "confusion", "data": "aGVsbG8="}`, diviner.Artifact{Name: "confusion", Data: []byte("hello")}},
+	} {
+		artifact, err := diviner.ParseArtifact(c.arg)
+		if err != nil {
+			t.Errorf("%s: %v", c.arg, err)
+			continue
+		}
+		if got, want := artifact.String(), c.artifact.String(); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := string(artifact.Data), string(c.artifact.Data); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	for _, arg := range []string{"model", "=s3://bucket/model.pt", `{"name": "x"}`, `{"name": "x", "url": "y", "data": "eA=="}`, "{"} {
+		if _, err := diviner.ParseArtifact(arg); err == nil {
+			t.Errorf("%s: expected error", arg)
+		}
+	}
+}
+
+func TestArtifactOpen(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	path := filepath.Join(dir, "model")
+	if err := ioutil.WriteFile(path, []byte("weights"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run := diviner.Run{Artifacts: []diviner.Artifact{
+		{Name: "model", URL: path},
+		{Name: "confusion", Data: []byte("matrix")},
+	}}
+	for name, want := range map[string]string{"model": "weights", "confusion": "matrix"} {
+		artifact, ok := run.Artifact(name)
+		if !ok {
+			t.Fatalf("missing artifact %s", name)
+		}
+		r, err := artifact.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(p); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, ok := run.Artifact("plot"); ok {
+		t.Error("unexpected artifact")
+	}
+	if err := (diviner.Artifact{Name: "big", Data: []byte(strings.Repeat("x", diviner.MaxInlineArtifactSize+1))}).Validate(); err == nil {
+		t.Error("expected error")
+	}
+}
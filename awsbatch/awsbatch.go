@@ -0,0 +1,423 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package awsbatch implements a diviner.Backend that runs scripts as
+// AWS Batch jobs. The backend uses AWS credentials and configuration
+// from the environment.
+//
+// Each script is run by a job whose job definition is registered for
+// it: the definition's container runs the script with Bash in a
+// scratch working directory, into which the job's files are first
+// written. (Files are embedded in the container's command, and so
+// must be small.) The job's resource requirements are translated into
+// the container's vCPUs, memory, and GPUs. The job is polled until
+// it completes; its container's CloudWatch log stream is streamed
+// back to the caller as the job's output.
+//
+// Jobs that fail because their container instance was terminated,
+// as happens when a spot compute environment's instances are
+// reclaimed, are reported as preempted (see diviner.ErrPreempted).
+// Job definitions are deregistered once their jobs complete, fail,
+// or are canceled; canceled jobs are terminated.
+package awsbatch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/batch/batchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+)
+
+const (
+	// workDir is the working directory of job scripts.
+	workDir = "/diviner/work"
+
+	// maxNameLen is the maximum length of job names, excluding their
+	// unique suffix. AWS Batch limits names to 128 characters.
+	maxNameLen = 100
+
+	defaultLogGroup     = "/aws/batch/job"
+	defaultPollInterval = 5 * time.Second
+	defaultCPU          = 1
+	defaultMemory       = 2 * data.GiB
+)
+
+func init() {
+	gob.Register(new(Backend))
+}
+
+// Backend implements diviner.Backend by running jobs on AWS Batch.
+type Backend struct {
+	// JobQueue is the name or ARN of the job queue to which jobs are
+	// submitted.
+	JobQueue string
+	// Image is the container image in which scripts are run, unless
+	// a job provides its own. It must provide Bash and base64.
+	Image string
+	// Region is the AWS region of the job queue. If empty, the
+	// region is taken from the environment.
+	Region string
+	// JobRole is the ARN of the IAM role assumed by jobs'
+	// containers. If empty, containers have no role.
+	JobRole string
+	// LogGroup is the CloudWatch log group to which jobs' containers
+	// log. Defaults to "/aws/batch/job", the log group used by AWS
+	// Batch.
+	LogGroup string
+	// PollInterval is the interval at which jobs' statuses and logs
+	// are polled. Defaults to 5 seconds.
+	PollInterval time.Duration
+
+	once  sync.Once
+	err   error
+	batch batchiface.BatchAPI
+	logs  cloudwatchlogsiface.CloudWatchLogsAPI
+}
+
+var _ diviner.Backend = (*Backend)(nil)
+
+// Run implements diviner.Backend. It registers a job definition for,
+// and submits, an AWS Batch job to run the provided job, and returns
+// a reader of its container's logs. Reads return an error, carrying
+// the script's exit code, if the job failed.
+func (b *Backend) Run(ctx context.Context, job diviner.Job) (io.ReadCloser, error) {
+	if b.JobQueue == "" {
+		return nil, fmt.Errorf("awsbatch: no job queue defined for job %s", job.Name)
+	}
+	if b.Image == "" && job.Image == "" {
+		return nil, fmt.Errorf("awsbatch: no image defined for job %s", job.Name)
+	}
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	name, err := jobName(job.Name)
+	if err != nil {
+		return nil, err
+	}
+	def, err := b.batch.RegisterJobDefinitionWithContext(ctx, b.jobDefinition(name, job))
+	if err != nil {
+		return nil, fmt.Errorf("awsbatch: register job definition %s: %v", name, err)
+	}
+	arn := aws.StringValue(def.JobDefinitionArn)
+	submitted, err := b.batch.SubmitJobWithContext(ctx, &batch.SubmitJobInput{
+		JobName:       aws.String(name),
+		JobQueue:      aws.String(b.JobQueue),
+		JobDefinition: aws.String(arn),
+	})
+	if err != nil {
+		b.cleanup("", arn)
+		return nil, fmt.Errorf("awsbatch: submit job %s: %v", name, err)
+	}
+	id := aws.StringValue(submitted.JobId)
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	go func() {
+		defer cancel()
+		err := b.wait(ctx, id, w)
+		if ctx.Err() != nil {
+			b.cleanup(id, arn)
+		} else {
+			b.cleanup("", arn)
+		}
+		w.CloseWithError(err)
+	}()
+	return &logReader{r, cancel}, nil
+}
+
+// Init creates the backend's AWS clients, if they have not already
+// been provided.
+func (b *Backend) init() error {
+	b.once.Do(func() {
+		if b.batch != nil && b.logs != nil {
+			return
+		}
+		config := aws.NewConfig()
+		if b.Region != "" {
+			config = config.WithRegion(b.Region)
+		}
+		var sess *session.Session
+		sess, b.err = session.NewSession(config)
+		if b.err != nil {
+			b.err = fmt.Errorf("awsbatch: %v", b.err)
+			return
+		}
+		b.batch = batch.New(sess)
+		b.logs = cloudwatchlogs.New(sess)
+	})
+	return b.err
+}
+
+// Wait polls the job with the provided ID until it completes,
+// streaming its container's logs to w. It returns an error if the
+// job failed.
+func (b *Backend) wait(ctx context.Context, id string, w io.Writer) error {
+	poll := b.PollInterval
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+	var (
+		stream string
+		token  *string
+	)
+	for {
+		out, err := b.batch.DescribeJobsWithContext(ctx, &batch.DescribeJobsInput{
+			Jobs: []*string{aws.String(id)},
+		})
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("awsbatch: describe job %s: %v", id, err)
+		}
+		if len(out.Jobs) == 0 {
+			return fmt.Errorf("awsbatch: job %s not found", id)
+		}
+		detail := out.Jobs[0]
+		if stream == "" && detail.Container != nil {
+			stream = aws.StringValue(detail.Container.LogStreamName)
+		}
+		// Logs are copied after the job's status is retrieved, so that
+		// the job's complete output is copied before it is reported
+		// as done.
+		if stream != "" {
+			if token, err = b.copyLogs(ctx, stream, token, w); err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				return err
+			}
+		}
+		switch aws.StringValue(detail.Status) {
+		case batch.JobStatusSucceeded:
+			return nil
+		case batch.JobStatusFailed:
+			return failure(detail)
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// CopyLogs writes to w the events of the provided log stream that
+// follow the provided token, one per line. It returns the token from
+// which to continue copying. Streams that do not (yet) exist are
+// treated as empty.
+func (b *Backend) copyLogs(ctx context.Context, stream string, token *string, w io.Writer) (*string, error) {
+	for {
+		out, err := b.logs.GetLogEventsWithContext(ctx, &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(b.logGroup()),
+			LogStreamName: aws.String(stream),
+			StartFromHead: aws.Bool(true),
+			NextToken:     token,
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+				return token, nil
+			}
+			return token, fmt.Errorf("awsbatch: get log events %s: %v", stream, err)
+		}
+		for _, event := range out.Events {
+			if _, err := io.WriteString(w, aws.StringValue(event.Message)+"\n"); err != nil {
+				return token, err
+			}
+		}
+		if out.NextForwardToken != nil {
+			token = out.NextForwardToken
+		}
+		if len(out.Events) == 0 {
+			return token, nil
+		}
+	}
+}
+
+func (b *Backend) logGroup() string {
+	if b.LogGroup != "" {
+		return b.LogGroup
+	}
+	return defaultLogGroup
+}
+
+// hostTerminated is the prefix of the status reason of jobs whose
+// container instances were terminated.
+const hostTerminated = "Host EC2"
+
+// Failure returns an error describing the failure of the provided
+// job.
+func failure(detail *batch.JobDetail) error {
+	var (
+		name    = aws.StringValue(detail.JobName)
+		reasons []string
+	)
+	if reason := aws.StringValue(detail.StatusReason); strings.HasPrefix(reason, hostTerminated) {
+		return fmt.Errorf("job %s: %s: %w", name, reason, diviner.ErrPreempted)
+	}
+	if reason := aws.StringValue(detail.StatusReason); reason != "" {
+		reasons = append(reasons, reason)
+	}
+	if c := detail.Container; c != nil {
+		if reason := aws.StringValue(c.Reason); reason != "" {
+			reasons = append(reasons, reason)
+		}
+		if c.ExitCode != nil {
+			if len(reasons) > 0 {
+				return fmt.Errorf("job %s failed with exit code %d: %s", name, *c.ExitCode, strings.Join(reasons, ": "))
+			}
+			return fmt.Errorf("job %s failed with exit code %d", name, *c.ExitCode)
+		}
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("job %s failed: %s", name, strings.Join(reasons, ": "))
+	}
+	return fmt.Errorf("job %s failed", name)
+}
+
+// Cleanup terminates the job with the provided ID, if it is
+// nonempty, and deregisters the job definition with the provided
+// ARN. Cleanup is called also for jobs that have been canceled, and
+// so uses a fresh context.
+func (b *Backend) cleanup(id, arn string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if id != "" {
+		_, err := b.batch.TerminateJobWithContext(ctx, &batch.TerminateJobInput{
+			JobId:  aws.String(id),
+			Reason: aws.String("canceled by diviner"),
+		})
+		if err != nil {
+			log.Error.Printf("awsbatch: terminate job %s: %v", id, err)
+		}
+	}
+	_, err := b.batch.DeregisterJobDefinitionWithContext(ctx, &batch.DeregisterJobDefinitionInput{
+		JobDefinition: aws.String(arn),
+	})
+	if err != nil {
+		log.Error.Printf("awsbatch: deregister job definition %s: %v", arn, err)
+	}
+}
+
+// JobDefinition returns the definition of the AWS Batch job that
+// runs the provided job under the given name.
+func (b *Backend) jobDefinition(name string, job diviner.Job) *batch.RegisterJobDefinitionInput {
+	image := b.Image
+	if job.Image != "" {
+		image = job.Image
+	}
+	env := make([]*batch.KeyValuePair, 0, len(job.Env))
+	for _, kv := range job.Env {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		env = append(env, &batch.KeyValuePair{Name: aws.String(kv[0]), Value: aws.String(kv[1])})
+	}
+	cpu, memory := job.Resources.CPU, job.Resources.Memory
+	if cpu <= 0 {
+		cpu = defaultCPU
+	}
+	if memory <= 0 {
+		memory = defaultMemory
+	}
+	container := &batch.ContainerProperties{
+		Image:       aws.String(image),
+		Command:     aws.StringSlice([]string{"bash", "-c", script(job)}),
+		Vcpus:       aws.Int64(int64(cpu)),
+		Memory:      aws.Int64(int64((memory + data.MiB - 1) / data.MiB)),
+		Environment: env,
+	}
+	if job.Resources.GPU > 0 {
+		container.ResourceRequirements = []*batch.ResourceRequirement{{
+			Type:  aws.String(batch.ResourceTypeGpu),
+			Value: aws.String(fmt.Sprint(job.Resources.GPU)),
+		}}
+	}
+	if b.JobRole != "" {
+		container.JobRoleArn = aws.String(b.JobRole)
+	}
+	return &batch.RegisterJobDefinitionInput{
+		JobDefinitionName:   aws.String(name),
+		Type:                aws.String(batch.JobDefinitionTypeContainer),
+		ContainerProperties: container,
+	}
+}
+
+// Script returns the Bash script run by the container of the
+// provided job: it creates the working directory, writes the job's
+// files into it, and then runs the job's script.
+func script(job diviner.Job) string {
+	commands := []string{"mkdir -p " + workDir, "cd " + workDir}
+	names := make([]string, 0, len(job.Files))
+	for name := range job.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		commands = append(commands, fmt.Sprintf("echo %s | base64 -d > %s",
+			base64.StdEncoding.EncodeToString(job.Files[name]), quote(name)))
+	}
+	return strings.Join(commands, " && ") + " || exit 1\n" + job.Script
+}
+
+// Quote quotes the provided string for Bash.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// JobName returns a unique AWS Batch job name derived from the
+// provided job name. Batch names may contain letters, numbers,
+// hyphens, and underscores.
+func jobName(name string) (string, error) {
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	name = b.String()
+	if len(name) > maxNameLen {
+		name = name[:maxNameLen]
+	}
+	name = strings.Trim(name, "-")
+	if name == "" {
+		name = "diviner"
+	}
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	return name + "-" + hex.EncodeToString(suffix[:]), nil
+}
+
+// LogReader reads a job's logs. Closing it cancels the job.
+type logReader struct {
+	*io.PipeReader
+	cancel func()
+}
+
+func (r *logReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
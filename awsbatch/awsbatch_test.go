@@ -0,0 +1,262 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package awsbatch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/batch/batchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/diviner"
+)
+
+// FakeBatch is a fake AWS Batch service that runs a single job. The
+// job is reported as running for the first describe call, and with
+// the provided final status thereafter.
+type fakeBatch struct {
+	batchiface.BatchAPI
+	status   string
+	exitCode *int64
+	reason   string
+
+	mu           sync.Mutex
+	definition   *batch.RegisterJobDefinitionInput
+	submitted    *batch.SubmitJobInput
+	described    int
+	terminated   bool
+	deregistered bool
+}
+
+func (f *fakeBatch) RegisterJobDefinitionWithContext(ctx aws.Context, input *batch.RegisterJobDefinitionInput, _ ...request.Option) (*batch.RegisterJobDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.definition = input
+	return &batch.RegisterJobDefinitionOutput{JobDefinitionArn: aws.String("arn:def")}, nil
+}
+
+func (f *fakeBatch) SubmitJobWithContext(ctx aws.Context, input *batch.SubmitJobInput, _ ...request.Option) (*batch.SubmitJobOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.submitted = input
+	return &batch.SubmitJobOutput{JobId: aws.String("job-1"), JobName: input.JobName}, nil
+}
+
+func (f *fakeBatch) DescribeJobsWithContext(ctx aws.Context, input *batch.DescribeJobsInput, _ ...request.Option) (*batch.DescribeJobsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.described++
+	detail := &batch.JobDetail{
+		JobId:   aws.String("job-1"),
+		JobName: f.submitted.JobName,
+		Status:  aws.String(batch.JobStatusRunning),
+		Container: &batch.ContainerDetail{
+			LogStreamName: aws.String("stream"),
+		},
+	}
+	if f.described > 1 && f.status != "" {
+		detail.Status = aws.String(f.status)
+		detail.Container.ExitCode = f.exitCode
+		if f.status == batch.JobStatusFailed {
+			detail.StatusReason = aws.String("Essential container in task exited")
+			if f.reason != "" {
+				detail.StatusReason = aws.String(f.reason)
+			}
+		}
+	}
+	return &batch.DescribeJobsOutput{Jobs: []*batch.JobDetail{detail}}, nil
+}
+
+func (f *fakeBatch) TerminateJobWithContext(ctx aws.Context, input *batch.TerminateJobInput, _ ...request.Option) (*batch.TerminateJobOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.terminated = true
+	return &batch.TerminateJobOutput{}, nil
+}
+
+func (f *fakeBatch) DeregisterJobDefinitionWithContext(ctx aws.Context, input *batch.DeregisterJobDefinitionInput, _ ...request.Option) (*batch.DeregisterJobDefinitionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deregistered = true
+	return &batch.DeregisterJobDefinitionOutput{}, nil
+}
+
+// FakeLogs is a fake CloudWatch Logs service whose log stream
+// contains one page of events, followed by an empty page.
+type fakeLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	messages []string
+}
+
+func (f *fakeLogs) GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, _ ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if aws.StringValue(input.LogStreamName) != "stream" {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "no such stream", nil)
+	}
+	out := &cloudwatchlogs.GetLogEventsOutput{NextForwardToken: aws.String("end")}
+	if input.NextToken == nil {
+		for _, msg := range f.messages {
+			out.Events = append(out.Events, &cloudwatchlogs.OutputLogEvent{Message: aws.String(msg)})
+		}
+	}
+	return out, nil
+}
+
+func newBackend(status string, exitCode int64) (*Backend, *fakeBatch) {
+	f := &fakeBatch{status: status, exitCode: aws.Int64(exitCode)}
+	b := &Backend{
+		JobQueue:     "queue",
+		Image:        "ubuntu:18.04",
+		JobRole:      "arn:role",
+		PollInterval: time.Millisecond,
+		batch:        f,
+		logs:         &fakeLogs{messages: []string{"hello world", "METRICS: acc=0.5"}},
+	}
+	return b, f
+}
+
+func TestBackend(t *testing.T) {
+	b, f := newBackend(batch.JobStatusSucceeded, 0)
+	ctx := context.Background()
+	job := diviner.Job{
+		Name:      "study=test,x=1",
+		Script:    "echo hello world",
+		Env:       []string{"FOO=bar"},
+		Files:     map[string][]byte{"data.txt": []byte("some data")},
+		Resources: diviner.Resources{CPU: 4, Memory: 10 * data.GiB, GPU: 2},
+	}
+	r, err := b.Run(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "hello world\nMETRICS: acc=0.5\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got, want := aws.StringValue(f.submitted.JobQueue), "queue"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if name := aws.StringValue(f.submitted.JobName); !strings.HasPrefix(name, "study-test-x-1-") {
+		t.Errorf("bad job name %s", name)
+	}
+	c := f.definition.ContainerProperties
+	if got, want := aws.StringValue(c.Image), "ubuntu:18.04"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := aws.Int64Value(c.Vcpus), int64(4); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := aws.Int64Value(c.Memory), int64(10<<10); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(c.ResourceRequirements), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := aws.StringValue(c.ResourceRequirements[0].Value), "2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := aws.StringValue(c.JobRoleArn), "arn:role"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(c.Environment), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := aws.StringValue(c.Environment[0].Value), "bar"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	command := aws.StringValueSlice(c.Command)
+	if got, want := len(command), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if !strings.Contains(command[2], "echo c29tZSBkYXRh | base64 -d > 'data.txt'") || !strings.HasSuffix(command[2], "\necho hello world") {
+		t.Errorf("bad command %q", command[2])
+	}
+	if !f.deregistered {
+		t.Error("job definition was not deregistered")
+	}
+	if f.terminated {
+		t.Error("completed job was terminated")
+	}
+}
+
+func TestBackendFailure(t *testing.T) {
+	b, f := newBackend(batch.JobStatusFailed, 3)
+	r, err := b.Run(context.Background(), diviner.Job{Name: "test", Script: "exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(r)
+	r.Close()
+	if err == nil || !strings.Contains(err.Error(), "failed with exit code 3: Essential container in task exited") {
+		t.Errorf("bad error %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.deregistered {
+		t.Error("job definition was not deregistered")
+	}
+}
+
+func TestBackendPreempted(t *testing.T) {
+	b, f := newBackend(batch.JobStatusFailed, 0)
+	f.reason = "Host EC2 (instance i-0123456789abcdef0) terminated."
+	r, err := b.Run(context.Background(), diviner.Job{Name: "test", Script: "sleep 1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(r)
+	r.Close()
+	if !errors.Is(err, diviner.ErrPreempted) {
+		t.Errorf("got %v, want %v", err, diviner.ErrPreempted)
+	}
+}
+
+func TestBackendCancel(t *testing.T) {
+	b, f := newBackend("", 0)
+	r, err := b.Run(context.Background(), diviner.Job{Name: "test", Script: "sleep 1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	for {
+		f.mu.Lock()
+		done := f.terminated && f.deregistered
+		f.mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackendNoImage(t *testing.T) {
+	b, _ := newBackend(batch.JobStatusSucceeded, 0)
+	b.Image = ""
+	if _, err := b.Run(context.Background(), diviner.Job{Name: "test"}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package bigquery exports diviner runs to Google BigQuery tables, so
+// that study results may be joined against other analytics data.
+//
+// Rows are inserted by streaming newline-delimited JSON to the "bq"
+// command line tool [1], which must be installed and authenticated in
+// the environment. The table's schema is given by Schema; it may be
+// created with, e.g.:
+//
+//	bq mk --table dataset.table schema.json
+//
+// [1] https://cloud.google.com/bigquery/docs/bq-command-line-tool
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+// Schema is the BigQuery JSON schema of the rows produced by NewRow.
+const Schema = `[
+  {"name": "id", "type": "STRING", "mode": "REQUIRED"},
+  {"name": "study", "type": "STRING", "mode": "REQUIRED"},
+  {"name": "seq", "type": "INTEGER", "mode": "REQUIRED"},
+  {"name": "replicate", "type": "INTEGER", "mode": "REQUIRED"},
+  {"name": "state", "type": "STRING", "mode": "REQUIRED"},
+  {"name": "created", "type": "TIMESTAMP", "mode": "REQUIRED"},
+  {"name": "updated", "type": "TIMESTAMP", "mode": "REQUIRED"},
+  {"name": "runtime_seconds", "type": "FLOAT", "mode": "REQUIRED"},
+  {"name": "retries", "type": "INTEGER", "mode": "REQUIRED"},
+  {"name": "values", "type": "RECORD", "mode": "REPEATED", "fields": [
+    {"name": "name", "type": "STRING", "mode": "REQUIRED"},
+    {"name": "kind", "type": "STRING", "mode": "REQUIRED"},
+    {"name": "value", "type": "STRING", "mode": "REQUIRED"}
+  ]},
+  {"name": "metrics", "type": "RECORD", "mode": "REPEATED", "fields": [
+    {"name": "name", "type": "STRING", "mode": "REQUIRED"},
+    {"name": "value", "type": "FLOAT", "mode": "REQUIRED"}
+  ]}
+]`
+
+// A Row is a single BigQuery row representing a run.
+type Row struct {
+	// ID is the run's diviner ID; it may be used to deduplicate rows.
+	ID             string        `json:"id"`
+	Study          string        `json:"study"`
+	Seq            uint64        `json:"seq"`
+	Replicate      int           `json:"replicate"`
+	State          string        `json:"state"`
+	Created        string        `json:"created"`
+	Updated        string        `json:"updated"`
+	RuntimeSeconds float64       `json:"runtime_seconds"`
+	Retries        int           `json:"retries"`
+	Values         []ValueField  `json:"values"`
+	Metrics        []MetricField `json:"metrics"`
+}
+
+// ValueField is a single parameter value in a Row.
+type ValueField struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// MetricField is a single metric in a Row.
+type MetricField struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// NewRow returns the row representing the provided run. The run's
+// metrics are those of its trial, i.e., the last reported metrics.
+func NewRow(run diviner.Run) Row {
+	row := Row{
+		ID:             run.ID(),
+		Study:          run.Study,
+		Seq:            run.Seq,
+		Replicate:      run.Replicate,
+		State:          run.State.String(),
+		Created:        run.Created.UTC().Format(time.RFC3339Nano),
+		Updated:        run.Updated.UTC().Format(time.RFC3339Nano),
+		RuntimeSeconds: run.Runtime.Seconds(),
+		Retries:        run.Retries,
+		Values:         []ValueField{},
+		Metrics:        []MetricField{},
+	}
+	for _, v := range run.Values.Sorted() {
+		row.Values = append(row.Values, ValueField{v.Name, v.Kind().String(), v.Value.String()})
+	}
+	for _, m := range run.Trial().Metrics.Sorted() {
+		row.Metrics = append(row.Metrics, MetricField{m.Name, m.Value})
+	}
+	return row
+}
+
+// A Table is a BigQuery table into which rows are inserted.
+type Table struct {
+	// Project is the GCP project containing the table. If empty,
+	// bq's default project is used.
+	Project string
+	// Name is the name of the table, in the form "dataset.table".
+	Name string
+	// Command is the command used to invoke bq. It defaults to
+	// []string{"bq"}.
+	Command []string
+}
+
+// Insert appends the provided rows to the table.
+func (t Table) Insert(ctx context.Context, rows []Row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var in bytes.Buffer
+	enc := json.NewEncoder(&in)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	args := t.Command
+	if len(args) == 0 {
+		args = []string{"bq"}
+	}
+	args = append([]string{}, args...)
+	if t.Project != "" {
+		args = append(args, "--project_id="+t.Project)
+	}
+	args = append(args, "insert", t.Name)
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = &in
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("bigquery: insert into %s: %v: %s", t.Name, err, out.String())
+	}
+	return nil
+}
+
+// Export inserts into the table every completed run in the named
+// studies that has been updated since the provided time. It returns
+// the number of rows inserted.
+func Export(ctx context.Context, db diviner.Database, table Table, studies []string, since time.Time) (int, error) {
+	var rows []Row
+	for _, study := range studies {
+		runs, err := db.ListRuns(ctx, study, diviner.Success|diviner.Failure|diviner.TimedOut|diviner.Preempted, since)
+		if err != nil && err != diviner.ErrNotExist {
+			return 0, err
+		}
+		for _, run := range runs {
+			rows = append(rows, NewRow(run))
+		}
+	}
+	return len(rows), table.Insert(ctx, rows)
+}
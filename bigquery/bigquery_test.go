@@ -0,0 +1,83 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigquery_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/bigquery"
+	"github.com/grailbio/testutil"
+)
+
+func TestInsert(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	out := filepath.Join(dir, "rows.json")
+
+	created := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	run := diviner.Run{
+		Study:     "test",
+		Seq:       2,
+		Replicate: 1,
+		State:     diviner.Success,
+		Values:    diviner.Values{"lr": diviner.Float(0.1), "opt": diviner.String("adam")},
+		Created:   created,
+		Updated:   created.Add(time.Minute),
+		Runtime:   time.Minute,
+		Metrics:   []diviner.Metrics{{"acc": 0.5}, {"acc": 0.9, "loss": 0.1}},
+	}
+	table := bigquery.Table{
+		Name:    "dataset.table",
+		Command: []string{"bash", "-c", `test "$1" = insert && test "$2" = dataset.table && cat > ` + out, "bq"},
+	}
+	if err := table.Insert(context.Background(), []bigquery.Row{bigquery.NewRow(run)}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	if !scan.Scan() {
+		t.Fatal("no rows inserted")
+	}
+	var row bigquery.Row
+	if err := json.Unmarshal(scan.Bytes(), &row); err != nil {
+		t.Fatal(err)
+	}
+	want := bigquery.Row{
+		ID:             "test:2",
+		Study:          "test",
+		Seq:            2,
+		Replicate:      1,
+		State:          "success",
+		Created:        "2019-10-01T12:00:00Z",
+		Updated:        "2019-10-01T12:01:00Z",
+		RuntimeSeconds: 60,
+		Values: []bigquery.ValueField{
+			{Name: "lr", Kind: "real", Value: "0.1"},
+			{Name: "opt", Kind: "string", Value: "adam"},
+		},
+		Metrics: []bigquery.MetricField{
+			{Name: "acc", Value: 0.9},
+			{Name: "loss", Value: 0.1},
+		},
+	}
+	if !reflect.DeepEqual(row, want) {
+		t.Errorf("got %+v, want %+v", row, want)
+	}
+	if scan.Scan() {
+		t.Error("extra rows inserted")
+	}
+}
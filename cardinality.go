@@ -0,0 +1,200 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Cardinality is the number of distinct points in a parameter space,
+// as computed by Params.Cardinality. Spaces that include continuous
+// parameters are infinite.
+type Cardinality struct {
+	// Infinite tells whether the space is infinite.
+	Infinite bool
+	// N is the number of points in a finite space. It is nil if the
+	// space is infinite.
+	N *big.Int
+}
+
+// String returns the number of points in the space, or "infinite".
+func (c Cardinality) String() string {
+	if c.Infinite {
+		return "infinite"
+	}
+	return c.N.String()
+}
+
+// Int returns the cardinality as an int. Int returns false if the
+// space is infinite, or if its cardinality overflows an int.
+func (c Cardinality) Int() (int, bool) {
+	if c.Infinite || !c.N.IsInt64() {
+		return 0, false
+	}
+	n := c.N.Int64()
+	if int64(int(n)) != n {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// Exceeds tells whether the space has more than n points.
+func (c Cardinality) Exceeds(n int64) bool {
+	return c.Infinite || c.N.Cmp(big.NewInt(n)) > 0
+}
+
+// Cardinality returns the number of distinct assignments of the
+// parameters, i.e., the number of points in the grid that they
+// define. As in grid search, conditional parameters contribute only
+// to the points at which they are active. Cardinality does not
+// enumerate the parameters' values, and so is cheap even when the
+// space is very large. The parameters are assumed to be valid (see
+// Validate).
+func (p Params) Cardinality() Cardinality {
+	children := make(map[string][]NamedParam)
+	var roots []NamedParam
+	for _, param := range p.Sorted() {
+		if c, ok := param.Param.(*Conditional); ok {
+			if _, ok := p[c.On]; ok {
+				children[c.On] = append(children[c.On], param)
+				continue
+			}
+		}
+		roots = append(roots, param)
+	}
+	n := big.NewInt(1)
+	for _, param := range roots {
+		m, ok := subspaceSize(param, children)
+		if !ok {
+			return Cardinality{Infinite: true}
+		}
+		n.Mul(n, m)
+	}
+	return Cardinality{N: n}
+}
+
+// SubspaceSize returns the number of assignments of the provided
+// parameter together with the parameters that are conditional on it,
+// given that the parameter is active. SubspaceSize returns false if
+// the subspace is infinite.
+func subspaceSize(param NamedParam, children map[string][]NamedParam) (*big.Int, bool) {
+	n, ok := paramSize(param.Param)
+	if !ok {
+		return nil, false
+	}
+	if len(children[param.Name]) == 0 {
+		return n, true
+	}
+	// Each value on which some child is conditioned contributes the
+	// product of the sizes of the children that it activates; every
+	// other value contributes a single point.
+	sizes := make([]*big.Int, len(children[param.Name]))
+	for i, child := range children[param.Name] {
+		if sizes[i], ok = subspaceSize(child, children); !ok {
+			return nil, false
+		}
+	}
+	seen := NewMap()
+	for _, child := range children[param.Name] {
+		for _, v := range child.Param.(*Conditional).In {
+			if _, ok := seen.Get(v); ok {
+				continue
+			}
+			seen.Put(v, true)
+			m := big.NewInt(1)
+			for i, other := range children[param.Name] {
+				if containsValue(other.Param.(*Conditional).In, v) {
+					m.Mul(m, sizes[i])
+				}
+			}
+			n.Sub(n, big.NewInt(1))
+			n.Add(n, m)
+		}
+	}
+	return n, true
+}
+
+// ParamSize returns the number of values of the provided parameter,
+// and false if the parameter is continuous.
+func paramSize(param Param) (*big.Int, bool) {
+	if c, ok := param.(*Conditional); ok {
+		param = c.Param
+	}
+	switch param := param.(type) {
+	case *Discrete:
+		return big.NewInt(int64(len(param.Values()))), true
+	case Ordinal:
+		return big.NewInt(int64(param.Len())), true
+	case *Range:
+		if param.Kind() != Integer {
+			return nil, false
+		}
+		return big.NewInt(param.End.Int() - param.Start.Int()), true
+	case *LogRange:
+		return paramSize((*Range)(param))
+	case *Vector:
+		n := big.NewInt(1)
+		for _, elem := range param.Elems {
+			m, ok := paramSize(elem)
+			if !ok {
+				return nil, false
+			}
+			n.Mul(n, m)
+		}
+		return n, true
+	}
+	values := param.Values()
+	if values == nil {
+		return nil, false
+	}
+	return big.NewInt(int64(len(values))), true
+}
+
+func containsValue(values []Value, v Value) bool {
+	for _, w := range values {
+		if w.Equal(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enumerate returns every distinct assignment of the parameters, as
+// counted by Cardinality. The assignments are laid out so that the
+// first parameter, in the order given by Ordered, varies fastest;
+// conditional parameters are included only in the assignments in which
+// they are active. Enumerate returns an error if any parameter is
+// continuous. Since the number of assignments grows exponentially with
+// the number of parameters, callers should check the space's
+// cardinality before enumerating it.
+func (p Params) Enumerate() ([]Values, error) {
+	points := []Values{make(Values)}
+	for _, param := range p.Ordered() {
+		values := param.Values()
+		if len(values) == 0 {
+			return nil, fmt.Errorf("parameter %s is not discrete", param.Name)
+		}
+		expanded := make([]Values, 0, len(points))
+		for i, v := range values {
+			for _, point := range points {
+				if !IsActive(param.Param, point) {
+					if i == 0 {
+						expanded = append(expanded, point)
+					}
+					continue
+				}
+				next := make(Values, len(point)+1)
+				for name, w := range point {
+					next[name] = w
+				}
+				next[param.Name] = v
+				expanded = append(expanded, next)
+			}
+		}
+		points = expanded
+	}
+	return points, nil
+}
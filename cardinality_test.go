@@ -0,0 +1,102 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestCardinality(t *testing.T) {
+	for _, test := range []struct {
+		params diviner.Params
+		want   string
+	}{
+		{diviner.Params{}, "1"},
+		{
+			diviner.Params{
+				"layers":    diviner.NewRange(diviner.Int(1), diviner.Int(4)),
+				"batch":     diviner.NewQuantizedRange(diviner.Int(32), diviner.Int(256), diviner.Int(32)),
+				"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+				"size":      diviner.NewEnum("small", "large"),
+				"widths":    diviner.NewVector(diviner.NewDiscrete(diviner.Int(1), diviner.Int(2)), diviner.NewRange(diviner.Int(0), diviner.Int(3))),
+			},
+			"504",
+		},
+		{
+			diviner.Params{
+				"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam"), diviner.String("rmsprop")),
+				"momentum":  diviner.NewConditional(diviner.NewDiscrete(diviner.Float(0), diviner.Float(0.9)), "optimizer", diviner.String("sgd"), diviner.String("rmsprop")),
+				"nesterov":  diviner.NewConditional(diviner.NewDiscrete(diviner.Bool(false), diviner.Bool(true)), "optimizer", diviner.String("sgd")),
+				"decay":     diviner.NewConditional(diviner.NewDiscrete(diviner.Float(0.1), diviner.Float(0.2), diviner.Float(0.3)), "momentum", diviner.Float(0.9)),
+			},
+			// sgd: 2 nesterov x (1 + 3 decay); adam: 1; rmsprop: 1 + 3 decay.
+			"13",
+		},
+		{
+			diviner.Params{
+				"lr":     diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+				"layers": diviner.NewRange(diviner.Int(1), diviner.Int(4)),
+			},
+			"infinite",
+		},
+		{
+			diviner.Params{
+				"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+				"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+			},
+			"infinite",
+		},
+	} {
+		size := test.params.Cardinality()
+		if got, want := size.String(), test.want; got != want {
+			t.Errorf("%v: got %v, want %v", test.params, got, want)
+		}
+		points, err := test.params.Enumerate()
+		if size.Infinite {
+			if err == nil {
+				t.Errorf("%v: expected error", test.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, ok := size.Int(); !ok || n != len(points) {
+			t.Errorf("%v: got %v points, want %v", test.params, len(points), size)
+		}
+		seen := diviner.NewMap()
+		for _, point := range points {
+			if !test.params.IsValid(point) {
+				t.Errorf("%v: invalid point %v", test.params, point)
+			}
+			if _, ok := seen.Get(point); ok {
+				t.Errorf("%v: duplicate point %v", test.params, point)
+			}
+			seen.Put(point, true)
+		}
+	}
+}
+
+func TestCardinalityLarge(t *testing.T) {
+	params := make(diviner.Params)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		params[name] = diviner.NewRange(diviner.Int(0), diviner.Int(1000))
+	}
+	size := params.Cardinality()
+	if got, want := size.String(), "1000000000000000000000000"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := size.Int(); ok {
+		t.Error("expected overflow")
+	}
+	if !size.Exceeds(1e6) {
+		t.Error("expected size to exceed 1e6")
+	}
+	if got, want := (diviner.Params{"a": params["a"]}).Cardinality().Exceeds(1000), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/file"
+	"go.starlark.net/starlark"
+)
+
+// DefaultCheckpointInterval is the interval at which checkpoints are
+// saved when a Checkpoint does not specify one.
+const DefaultCheckpointInterval = 10 * time.Minute
+
+// A Checkpoint describes a file in which a run saves its state, so
+// that a run that is interrupted (e.g., because its machine was
+// preempted, or it timed out) can resume from its latest state
+// instead of starting over.
+//
+// While a run is executing, the runner periodically saves the
+// checkpoint file from the run's working directory to a persistent
+// store. When the run is restarted, whether because it timed out or
+// because it was retried according to its retry policy, the latest
+// saved checkpoint is restored to the working directory before the
+// run's script is started. Scripts should replace the checkpoint
+// file atomically (e.g., by renaming a temporary file) so that
+// incomplete checkpoints are never saved.
+//
+// The checkpoint's location is also provided to the run's script in
+// the environment variable DIVINER_CHECKPOINT_URL, so that scripts
+// run by backend systems, which do not support snapshotting, may
+// manage their own checkpoints.
+type Checkpoint struct {
+	// Path is the path of the checkpoint file, relative to the
+	// run's working directory.
+	Path string
+	// URL is the URL of the directory (e.g., "s3://bucket/checkpoints")
+	// under which checkpoints are stored. Each run's checkpoint is
+	// stored at URL/study/seq, where seq is the sequence number of
+	// the run's first attempt. Checkpoints may be kept in any blob
+	// store supported by package storage: S3 (s3://), GCS (gs://),
+	// or Azure Blob Storage (az://).
+	URL string
+	// Interval is the interval at which checkpoints are saved.
+	// Defaults to DefaultCheckpointInterval.
+	Interval time.Duration
+}
+
+// IsZero tells whether c is the zero checkpoint, i.e., whether
+// checkpointing is disabled.
+func (c Checkpoint) IsZero() bool {
+	return c == Checkpoint{}
+}
+
+// Location returns the URL of the checkpoint of the run with the
+// provided study and sequence number. Seq should be the sequence
+// number of the run's first attempt, so that retries share the
+// checkpoint.
+func (c Checkpoint) Location(study string, seq uint64) string {
+	return file.Join(c.URL, study, strconv.FormatUint(seq, 10))
+}
+
+// Validate returns an error if the checkpoint is invalid.
+func (c Checkpoint) Validate() error {
+	switch {
+	case c.Path == "":
+		return errors.New("checkpoint path is empty")
+	case c.URL == "":
+		return errors.New("checkpoint URL is empty")
+	case filepath.IsAbs(c.Path) || strings.HasPrefix(filepath.Clean(c.Path), ".."):
+		return fmt.Errorf("checkpoint path %s is not within the working directory", c.Path)
+	case c.Interval < 0:
+		return fmt.Errorf("negative checkpoint interval %s", c.Interval)
+	}
+	return nil
+}
+
+// String returns a textual description of the checkpoint.
+func (c Checkpoint) String() string {
+	return fmt.Sprintf("checkpoint(path=%q, url=%q, interval=%s)", c.Path, c.URL, c.Interval)
+}
+
+// Type implements starlark.Value.
+func (Checkpoint) Type() string { return "checkpoint" }
+
+// Freeze implements starlark.Value.
+func (Checkpoint) Freeze() {}
+
+// Truth implements starlark.Value.
+func (c Checkpoint) Truth() starlark.Bool { return starlark.Bool(!c.IsZero()) }
+
+// Hash implements starlark.Value.
+func (Checkpoint) Hash() (uint32, error) { return 0, errors.New("checkpoint is not hashable") }
@@ -0,0 +1,35 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ckpt := diviner.Checkpoint{Path: "model.ckpt", URL: "s3://bucket/checkpoints/"}
+	if err := ckpt.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ckpt.Location("study", 12), "s3://bucket/checkpoints/study/12"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if ckpt.IsZero() || !(diviner.Checkpoint{}).IsZero() {
+		t.Error("bad IsZero")
+	}
+	for _, invalid := range []diviner.Checkpoint{
+		{URL: "s3://bucket"},
+		{Path: "model.ckpt"},
+		{Path: "/tmp/model.ckpt", URL: "s3://bucket"},
+		{Path: "../model.ckpt", URL: "s3://bucket"},
+		{Path: "model.ckpt", URL: "s3://bucket", Interval: -1},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("%v: expected error", invalid)
+		}
+	}
+}
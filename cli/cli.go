@@ -0,0 +1,3202 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package cli implements the diviner command line tool. Programs that
+// define studies in Go (see diviner.Register) may call Main to provide
+// the full diviner command line interface over these studies, which
+// are referred to by the script name "go:registry". For example:
+//
+//	func init() {
+//		diviner.Register(diviner.Study{
+//			Name:      "mystudy",
+//			Params:    diviner.Params{"lr": diviner.NewRange(diviner.Float(0.001), diviner.Float(0.1))},
+//			Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+//			Oracle:    oracle.NewRandom(1),
+//			Run:       runMyStudy,
+//		})
+//	}
+//
+//	func main() {
+//		cli.Main()
+//	}
+//
+// With this binary, "mybinary run go:registry mystudy" conducts trials
+// of the study.
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/file/s3file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/bigmachine"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/analysis"
+	"github.com/grailbio/diviner/api"
+	"github.com/grailbio/diviner/bigquery"
+	"github.com/grailbio/diviner/client"
+	"github.com/grailbio/diviner/export"
+	"github.com/grailbio/diviner/grpcdb"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/diviner/logchunk"
+	"github.com/grailbio/diviner/mlflow"
+	"github.com/grailbio/diviner/oracle"
+	"github.com/grailbio/diviner/pgdb"
+	"github.com/grailbio/diviner/report"
+	"github.com/grailbio/diviner/runner"
+	"github.com/grailbio/diviner/script"
+	"github.com/grailbio/diviner/stats"
+	_ "github.com/grailbio/diviner/stats/datadog"
+	_ "github.com/grailbio/diviner/stats/prometheus"
+	"github.com/grailbio/diviner/storage"
+	"github.com/grailbio/diviner/tensorboard"
+	"github.com/grailbio/diviner/wandb"
+	"google.golang.org/grpc"
+)
+
+func initS3() {
+	file.RegisterImplementation("s3", func() file.Implementation {
+		return s3file.NewImplementation(s3file.NewDefaultProvider(
+			session.Options{}),
+			s3file.Options{})
+	})
+	storage.Register("s3", storage.NewS3(session.Options{}))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+	diviner list [-runs] [-archived] [-labels selector] studies...
+		List studies available studies or runs.
+	diviner list -l script.dv studies...
+		List studies available studies defined in script.dv.
+	diviner projects
+		List the projects of the database's studies.
+	diviner info [-v] [-l script] names...
+		Display information for the given study or run names.
+	diviner metrics id
+		Writes all metrics reported by the named run in TSV format.
+	diviner leaderboard [-objective objective] [-n N] [-values values] [-metrics metrics] studies...
+		Display a leaderboard of all trails in the provided studies. The leaderboard
+		uses the studies' shared objective unless overridden.
+	diviner importance [-objective objective] [-trees N] studies...
+		Estimate the importance of each parameter to the studies' objective.
+	diviner report [-objective objective] [-n N] [-o file] study
+		Write a self-contained HTML report summarizing the given study.
+	diviner convergence [-objective objective] [-all] [-summary] studies...
+		Display the best objective attained so far by the given studies
+		as their runs completed.
+	diviner run [-rounds M] [-trials N] [-stream] script.dv [studies]
+		Run M rounds of N trials of the studies matching regexp. All
+		studies are run if the regexp is omitted. If -stream is specified,
+		the study is run in streaming mode: N trials are maintained in
+		parallel; new points are queried from the study's oracle as needed.
+	diviner pause [-addr address] studies...
+		Stop launching new trials in the named studies of a running
+		diviner run; in-flight runs are allowed to complete.
+	diviner resume [-addr address] studies...
+		Resume studies that were paused by diviner pause.
+	diviner script script.dv study [-param=value...]
+		Render a bash script containing functions implementing a study,
+		including its datasets.
+	diviner reproduce [-local] [-script script.dv] [-seed n] [-print] run
+		Perform a new run that reproduces a historical run, using its
+		recorded script, datasets, systems, and seed.
+	diviner logs [-f] run
+		Write the logs for the given run to standard output.
+	diviner lineage [-descendants] run
+		Display the runs from which the given run was derived by retries
+		and forks, or the runs derived from it.
+	diviner label run labels...
+		Add (key=value or key), or remove (key-), labels of the given run.
+	diviner enqueue script.dv study [name=value...]
+		Request a trial of the given study with the given parameter values,
+		to be run before the study's oracle is consulted.
+	diviner artifacts [-o file] run|study [artifact]
+		List the artifacts of the given run, or write an artifact's contents.
+	diviner dataset [-invalidate] names...
+		Display the completion records of the named datasets, or invalidate them.
+	diviner archive [-restore] studies...
+		Archive the given studies, hiding them and making their runs immutable,
+		or restore them.
+	diviner retention [-failed-logs duration] [-keep-best k] study
+		Display or set the retention policy of the given study.
+	diviner maintain [-every interval] [-compact] [-train-log-dict]
+		Apply the retention policies of the database's studies, compact
+		local databases, and train their log dictionaries.
+	diviner [-db type,name] create-table
+		Create the underlying database table required for storing
+		Diviner studies and runs.
+	diviner bigquery [-project project] [-since time] [-every duration] table studies...
+		Append completed runs of the given studies to a BigQuery table.
+	diviner tensorboard [-since time] [-every duration] logdir studies...
+		Write the metric histories of the given studies' runs as TensorBoard event files.
+	diviner mlflow export [-url url] [-prefix prefix] [-since time] [-every duration] studies...
+		Mirror the given studies' runs into an MLflow tracking server.
+	diviner mlflow import [-url url] [-as name] script.dv study experiment
+		Import the finished runs of an MLflow experiment as trials of the given study.
+	diviner wandb export -entity entity -project project [-since time] [-every duration] studies...
+		Log the given studies' runs as Weights & Biases runs.
+	diviner wandb sweep -entity entity -project project [-method method] script.dv study
+		Create a Weights & Biases sweep over the given study's parameters.
+	diviner export [-format csv|json] [-state states] [-since time] [-costs] [-o file] studies...
+		Export the runs of the given studies, or their aggregate costs, as CSV or JSON Lines.
+	diviner [-db type,name] serve-db [-addr address] [-maintain interval]
+		Serve the database to remote diviner processes over gRPC.
+	diviner [-db type,name] serve-api [-addr address] [-submit]
+		Serve the database's studies and runs as a JSON API over HTTP.
+	diviner [-db local,filename] migrate
+		Upgrade a local database to the current schema version.
+	diviner [-db local,filename] backup url
+		Back up a local database to the given URL, e.g., on S3.
+	diviner [-db local,filename] restore url
+		Restore a local database from a backup.
+
+Whenever studies are named in commands, they are interpreted as
+anchored regular expressions. Thus a given study name without any
+regular expression control characters matches the study exactly. The
+-help flag for each subcommand provides detailed documentation for
+the command.
+
+Databases may be shared by many teams, each of which names its
+studies within a project: with -project (default $DIVINER_PROJECT),
+commands name studies, and datasets, within the given project, and
+only the project's studies are visible to them. Project-qualified
+study names (project/study) are accepted by commands without
+-project, too.
+
+Local databases encrypt their runs' logs and metrics with the key in
+$DIVINER_DB_KEY, if it is set: a base64-encoded 256-bit key, or
+kms://keyid, naming an AWS KMS key that wraps the database's key.
+
+Commands that accept a script (script.dv) also accept the name
+go:registry, which refers to the studies defined in Go and registered
+with diviner.Register in binaries that embed the diviner command line
+interface.
+
+Scripts whose names end in .yaml or .yml are read as YAML study
+specifications instead of Starlark scripts; see the documentation for
+LoadYAML in package github.com/grailbio/diviner/script.
+
+Flags:`)
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+var (
+	httpaddr    = flag.String("http", ":6000", "http status address")
+	statsConfig = flag.String("stats", "none", "sink for runner statistics, e.g., datadog,127.0.0.1:8125 or prometheus,:9464")
+)
+
+var traverser = traverse.Limit(400)
+
+var defaultDB = "dynamodb,diviner"
+
+// Main runs the diviner command line tool with the process's
+// arguments. It does not return.
+func Main() {
+	initS3()
+	log.SetPrefix("")
+	log.AddFlags()
+	log.SetFlags(log.Ldate | log.Ltime)
+
+	runner.Logger = log.Info
+	cwd := flag.String("C", "", "Enter the given directory")
+	databaseConfig := flag.String("db", defaultDB, "database where state is stored: local,filename; dynamodb,table; postgres,dsn; grpc,address; or memory,")
+	project := flag.String("project", os.Getenv("DIVINER_PROJECT"), "project within which studies are named (default $DIVINER_PROJECT)")
+	logCompression := flag.String("log-compression", "", "compression of the run logs written to local and postgres databases: gzip, zstd, or none, optionally with a level, e.g., zstd:19 (default gzip)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+	}
+
+	bigmachine.Init()
+
+	if *cwd != "" {
+		if err := os.Chdir(*cwd); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Databases are migrated, backed up, and restored before they are
+	// otherwise opened.
+	switch flag.Arg(0) {
+	case "migrate":
+		migrate(*databaseConfig, flag.Args()[1:])
+		return
+	case "backup":
+		backup(*databaseConfig, flag.Args()[1:])
+		return
+	case "restore":
+		restore(*databaseConfig, flag.Args()[1:])
+		return
+	}
+	open := client.Open
+	if readOnly(flag.Args()) {
+		open = client.OpenReadOnly
+	}
+	database, err := open(*databaseConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *logCompression != "" {
+		opts, err := logchunk.ParseOptions(*logCompression)
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch db := database.(type) {
+		case *localdb.DB:
+			db.LogOptions = opts
+		case *pgdb.DB:
+			db.LogOptions = opts
+		}
+	}
+	// Local databases are backed up and compacted whole, regardless
+	// of project.
+	local, _ := database.(*localdb.DB)
+	database = diviner.InProject(database, *project)
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "list":
+		list(database, args)
+	case "projects":
+		projects(database, args)
+	case "info":
+		info(database, args)
+	case "metrics":
+		metrics(database, args)
+	case "run":
+		run(database, local, args)
+	case "pause":
+		pause(args, false)
+	case "resume":
+		pause(args, true)
+	case "script":
+		showScript(database, args)
+	case "leaderboard":
+		leaderboard(database, args)
+	case "importance":
+		importance(database, args)
+	case "report":
+		htmlReport(database, args)
+	case "convergence":
+		convergence(database, args)
+	case "reproduce":
+		reproduce(database, args)
+	case "logs":
+		logs(database, args)
+	case "lineage":
+		lineage(database, args)
+	case "enqueue":
+		enqueue(database, args)
+	case "label":
+		label(database, args)
+	case "artifacts":
+		artifacts(database, args)
+	case "dataset":
+		datasets(database, args)
+	case "archive":
+		archive(database, args)
+	case "retention":
+		retention(database, args)
+	case "maintain":
+		maintain(database, local, args)
+	case "bigquery":
+		exportBigQuery(database, args)
+	case "tensorboard":
+		exportTensorBoard(database, args)
+	case "mlflow":
+		mlflowBridge(database, args)
+	case "wandb":
+		wandbBridge(database, args)
+	case "export":
+		exportRuns(database, args)
+	case "serve-db":
+		serveDB(database, args)
+	case "serve-api":
+		serveAPI(database, args)
+	case "create-table":
+		if err := database.CreateTable(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+	}
+}
+
+// ReadOnlyCommands are the commands that only read from the
+// database, which is opened in read-only mode for them.
+var readOnlyCommands = map[string]bool{
+	"list":        true,
+	"projects":    true,
+	"info":        true,
+	"metrics":     true,
+	"script":      true,
+	"leaderboard": true,
+	"importance":  true,
+	"report":      true,
+	"convergence": true,
+	"logs":        true,
+	"lineage":     true,
+	"artifacts":   true,
+	"bigquery":    true,
+	"tensorboard": true,
+	"wandb":       true,
+	"export":      true,
+	"serve-api":   true,
+}
+
+// ReadOnly tells whether the provided command line (a command and its
+// arguments) only reads from the database. Serve-api writes to the
+// database if it permits submissions.
+func readOnly(args []string) bool {
+	if !readOnlyCommands[args[0]] {
+		return false
+	}
+	if args[0] == "serve-api" {
+		for _, arg := range args[1:] {
+			switch strings.TrimLeft(arg, "-") {
+			case "submit", "submit=true", "submit=1":
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func find(studies []diviner.Study, name string) diviner.Study {
+	for _, study := range studies {
+		if study.Name == name {
+			return study
+		}
+	}
+	fmt.Fprintf(os.Stderr, "study %s not defined in config %s\n", name, flag.Arg(0))
+	os.Exit(2)
+	panic("not reached")
+}
+
+func match(studies *[]diviner.Study, pat string) {
+	r, err := regexp.Compile(pat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var n int
+	for _, study := range *studies {
+		if r.MatchString(study.Name) {
+			(*studies)[n] = study
+			n++
+		}
+	}
+	if n == 0 {
+		log.Fatalf("no studies matched %s", r)
+	}
+	*studies = (*studies)[:n]
+}
+
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	since, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-since=%s does not parse as a duration or date\n", s)
+	}
+	return since, nil
+}
+
+// parseStates parses a comma-separated list of run states.
+func parseStates(list string) diviner.RunState {
+	var state diviner.RunState
+	for _, s := range strings.Split(list, ",") {
+		switch s {
+		case "pending":
+			state |= diviner.Pending
+		case "success":
+			state |= diviner.Success
+		case "failure":
+			state |= diviner.Failure
+		case "timedout":
+			state |= diviner.TimedOut
+		case "preempted":
+			state |= diviner.Preempted
+		default:
+			log.Fatalf("invalid run state %s", s)
+		}
+	}
+	if state == 0 {
+		log.Fatal("no run states given")
+	}
+	return state
+}
+
+func list(db diviner.Database, args []string) {
+	var (
+		flags     = flag.NewFlagSet("list", flag.ExitOnError)
+		listRuns  = flags.Bool("runs", false, "list runs matching studies")
+		load      = flags.String("l", "", "load studies from the provided script file")
+		runState  = flags.String("state", "pending,success,failure,timedout,preempted", "list of run states to query")
+		status    = flags.Bool("s", false, "show status for pending runs")
+		sinceFlag = flags.String("since", "", "only show entries that have been updated since the provided date or duration")
+		valuesRe  = flags.String("values", "^$", "comma-separated list of anchored regular expression matching parameter values to display")
+		labels    = flags.String("labels", "", "only list runs whose labels match the provided selector, e.g., baseline,gpu=a100,owner!=alice")
+		archived  = flags.Bool("archived", false, "include archived studies")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage:
+	diviner list [-runs] [-archived] studies...
+	diviner list -l script.dv [-runs] studies...
+
+List prints a summary overview of all studies (or runs) that match
+the given study names. Archived studies (see diviner archive) are
+listed only if -archived is given.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	args = flags.Args()
+	if len(args) == 0 {
+		args = []string{".*"} // list all
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = parseSince(*sinceFlag); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			flags.Usage()
+		}
+	}
+	// This is a hack to make sure we don't overscan studies when looking at pending
+	// runs. One hour is more than enough slack for keepalive; but this really should
+	// be pushed into the database layer.
+	if since.IsZero() && *runState == "pending" && *listRuns {
+		since = time.Now().Add(-time.Hour)
+	}
+	getter := databaseGetter(db, since)
+	if *load != "" {
+		getter = scriptGetter(*load)
+	}
+	studies := studies(ctx, args, getter)
+	if !*archived {
+		unarchived := studies[:0]
+		for _, study := range studies {
+			if !study.Archived {
+				unarchived = append(unarchived, study)
+			}
+		}
+		studies = unarchived
+	}
+	if !*listRuns {
+		for _, study := range studies {
+			fmt.Println(study.Name)
+		}
+		return
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	state := parseStates(*runState)
+	sel, err := diviner.ParseSelector(*labels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runs := make([][]diviner.Run, len(studies))
+	err = traverser.Each(len(runs), func(i int) (err error) {
+		runs[i], err = diviner.SelectRuns(ctx, db, studies[i].Name, state, since, sel)
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	valueKeys := make(map[string]bool)
+	for i := range studies {
+		for _, run := range runs[i] {
+			for key := range run.Values {
+				valueKeys[key] = true
+			}
+		}
+	}
+	var (
+		valuesOrdered = matchAndSort(valueKeys, *valuesRe)
+		values        = make([]string, len(valuesOrdered))
+		now           = time.Now()
+	)
+	for i, study := range studies {
+		sort.Slice(runs[i], func(j, k int) bool {
+			return runs[i][j].Seq < runs[i][k].Seq
+		})
+		for _, run := range runs[i] {
+			var layout = time.Kitchen
+			switch dur := now.Sub(run.Created); {
+			case dur > 7*24*time.Hour:
+				layout = "2Jan06"
+			case dur > 24*time.Hour:
+				layout = "Mon3:04PM"
+			}
+			runtime := run.Runtime
+			runtime -= runtime % time.Second
+			if !*status {
+				run.Status = ""
+			}
+			fmt.Fprintf(&tw, "%s:%d\t%s\t%s\t%s\t%s",
+				study.Name, run.Seq, run.Created.Local().Format(layout),
+				runtime, run.State, run.Status)
+			values = values[:0]
+			for _, key := range valuesOrdered {
+				if v, ok := run.Values[key]; ok {
+					values = append(values, fmt.Sprintf("%s:%s", key, v))
+				}
+			}
+			if len(values) > 0 {
+				fmt.Fprint(&tw, "\t", strings.Join(values, " "))
+			}
+			if len(run.Labels) > 0 {
+				fmt.Fprint(&tw, "\t[", run.Labels, "]")
+			}
+			fmt.Fprintln(&tw)
+		}
+	}
+	tw.Flush()
+}
+
+var (
+	studyTemplate = template.Must(template.New("study").Parse(`study {{.Name}}:
+	{{if .Objectives}}objectives:	{{range $i, $obj := .Objectives}}{{if $i}}, {{end}}{{$obj}}{{end}}{{else}}objective:	{{.Objective}}{{end}}{{range $_, $value := .Params.Sorted }}
+	{{$value.Name}}:	{{$value.Param}}{{end}}
+	size:	{{.Params.Cardinality}}
+	oracle:	{{printf "%T" .Oracle}}
+	replicates:	{{.Replicates}}{{if .MaxParallel}}
+	max parallel:	{{.MaxParallel}}{{end}}{{if .Aggregate}}
+	aggregate:	{{range $metric, $agg := .Aggregate}}{{$metric}}={{$agg}} {{end}}{{end}}{{if .Constraints}}
+	constraints:	{{range $i, $c := .Constraints}}{{if $i}}, {{end}}{{$c}}{{end}}{{end}}{{if .Transfer}}
+	transfer:	{{range $i, $name := .Transfer}}{{if $i}}, {{end}}{{$name}}{{end}}{{end}}{{if .Archived}}
+	archived:	true{{end}}{{if not .Retention.IsZero}}
+	retention:	{{.Retention}}{{end}}
+	description:	{{.Description}}
+`))
+
+	runFuncMap = template.FuncMap{
+		"reindent": reindent,
+		"join":     strings.Join,
+		"shquote":  shquote,
+	}
+
+	runTemplate = template.Must(template.New("study").Funcs(runFuncMap).Parse(`run {{.study}}:{{.run.Seq}}:
+	state:	{{.run.State}}{{if .status}}
+	status:	{{.run.Status}}{{end}}
+	created:	{{.run.Created.Local}}{{if not .run.Started.IsZero}}
+	started:	{{.run.Started.Local}}{{end}}{{if not .run.Completed.IsZero}}
+	completed:	{{.run.Completed.Local}}{{end}}
+	runtime:	{{.run.Runtime}}
+	restarts:	{{.run.Retries}}{{if .run.Labels}}
+	labels:	{{.run.Labels}}{{end}}
+{{if .run.RetryOf}}	retry of:	{{.study}}:{{.run.RetryOf}} (attempt {{.run.Attempt}})
+{{end}}{{if .run.Parent}}	parent:	{{.study}}:{{.run.Parent}} ({{.run.Derivation}})
+{{end}}{{if not .run.Config.Resources.IsZero}}	resources:	{{.run.Config.Resources}}
+{{end}}{{if not .run.Config.Checkpoint.IsZero}}	checkpoint:	{{.run.Config.Checkpoint.Path}} (saved to {{.run.Config.Checkpoint.URL}})
+{{end}}{{with .run.Source}}	source:	{{.}}{{if .Repository}}
+		repository:	{{.Repository}}{{end}}{{if .Commit}}
+		commit:	{{.Commit}}{{end}}{{if .StudyDigest}}
+		study digest:	{{.StudyDigest}}{{end}}{{if and $.verbose .Diff}}
+		diff:
+{{reindent "			" .Diff}}{{end}}
+{{end}}{{if .run.Config.Env}}	env:{{range $name, $value := .run.Config.Env}}
+		{{$name}}:	{{$value}}{{end}}
+{{end}}{{if .run.Artifacts}}	artifacts:{{range $_, $artifact := .run.Artifacts}}
+		{{$artifact}}{{end}}
+{{end}}{{with .run.Cost}}	cost:	{{.}}
+{{end}}{{with .run.Exit}}	exit:	{{.}}{{if .Stderr}}
+	stderr:{{range $_, $line := .Stderr}}
+		{{$line}}{{end}}{{end}}
+{{end}}	replicate:	{{.run.Replicate}}{{with .run.Config.Seed}}
+	seed:	{{.}}{{end}}
+	values:{{range $_, $value := .run.Values.Sorted }}
+		{{$value.Name}}:	{{$value.Value}}{{end}}{{if .verbose}}{{range $index, $metrics := .run.Metrics}}
+	metrics[{{$index}}]:{{range $_, $metric := $metrics.Sorted}}
+		{{$metric.Name}}:	{{$metric.Value}}{{end}}{{end}}{{else}}
+	metrics:{{range $_, $metric := .run.Trial.Metrics.Sorted }}
+		{{$metric.Name}}:	{{$metric.Value}}{{end}}{{end}}{{if .verbose}}
+	script:
+{{reindent "		" .run.Config.Script}}{{end}}
+`))
+
+	runConfigTemplate = template.Must(template.New("run_config").Funcs(runFuncMap).Parse(`{{range $_, $dataset :=  .Datasets}}function dataset{{$dataset.Name}} {
+#	if_not_exist:	{{$dataset.IfNotExist}}
+#	local_files:	{{join $dataset.LocalFiles ", "}}
+
+{{$dataset.Script}}
+}{{end}}
+function study {
+#	local_files:	{{join .LocalFiles ", "}}
+{{range $name, $value := .Env}}export {{$name}}={{shquote $value}}
+{{end}}{{with .Seed}}export DIVINER_SEED={{.}}
+{{end}}{{.Script}}
+}
+`))
+)
+
+func projects(db diviner.Database, args []string) {
+	flags := flag.NewFlagSet("projects", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner projects
+
+Projects lists the projects of the database's studies (see -project),
+together with their numbers of studies. Studies whose names are not
+qualified by a project are listed under "-".`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	projects, counts, err := diviner.Projects(context.Background(), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	defer tw.Flush()
+	for _, project := range projects {
+		name := project
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(&tw, "%s\t%d\n", name, counts[project])
+	}
+}
+
+func info(db diviner.Database, args []string) {
+	var (
+		flags   = flag.NewFlagSet("list", flag.ExitOnError)
+		verbose = flags.Bool("v", false, "show all available information")
+		load    = flags.String("l", "", "load studies from the provided script file")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner info [-v] [-l script] ids...
+
+Info displays detailed information about studies or runs.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	getter := databaseGetter(db, time.Time{})
+	if *load != "" {
+		getter = scriptGetter(*load)
+	}
+	ctx := context.Background()
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	for _, arg := range flags.Args() {
+		study, seq := splitName(arg)
+		if seq == 0 {
+			study := getter(ctx, study, false)[0]
+			if err := studyTemplate.Execute(&tw, study); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			run, err := db.LookupRun(ctx, study, seq)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = runTemplate.Execute(&tw, map[string]interface{}{
+				"study":   study,
+				"run":     run,
+				"verbose": *verbose,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	tw.Flush()
+}
+
+func metrics(db diviner.Database, args []string) {
+	var (
+		flags     = flag.NewFlagSet("metrics", flag.ExitOnError)
+		metricsRe = flags.String("metrics", ".*", "comma-separated list of anchored regular expression matching metrics to display")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: metrics id
+
+Writes all metrics reported by the provided run to standard output in
+TSV format. Every unique metric name reported over time is a single
+column; missing values are denoted by "NA".`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	study, seq := splitName(flags.Arg(0))
+	if seq == 0 {
+		log.Fatalf("not a valid run: %s", flags.Arg(0))
+	}
+	ctx := context.Background()
+	run, err := db.LookupRun(ctx, study, seq)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := make(map[string]bool)
+	for _, metrics := range run.Metrics {
+		for key := range metrics {
+			keys[key] = true
+		}
+	}
+	sorted := matchAndSort(keys, *metricsRe)
+	w := csv.NewWriter(os.Stdout)
+	w.Comma = '\t'
+	if err := w.Write(sorted); err != nil {
+		log.Fatal(err)
+	}
+	record := make([]string, len(sorted))
+	for _, metrics := range run.Metrics {
+		for i, key := range sorted {
+			v, ok := metrics[key]
+			if !ok {
+				record[i] = "NA"
+				continue
+			}
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		if err := w.Write(record); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(db diviner.Database, local *localdb.DB, args []string) {
+	var (
+		flags     = flag.NewFlagSet("run", flag.ExitOnError)
+		ntrials   = flags.Int("trials", 1, "number of trials to run in each round, or parallelism when streaming")
+		stream    = flags.Bool("stream", false, "perform a streaming study")
+		nrounds   = flags.Int("rounds", 1, "number of rounds to run")
+		replicate = flags.Int("replicate", 0, "replicate to re-run")
+		dedup     = flags.Bool("dedup", false, "reuse completed runs with the same values instead of running them again")
+		parallel  = flags.Int("parallel", 0, "maximum number of runs performed concurrently over all studies; unlimited if 0")
+		perStudy  = flags.Int("study-parallel", 0, "maximum number of concurrent runs of each study that does not set max_parallel; unlimited if 0")
+		idle      = flags.Duration("idle-timeout", 5*time.Minute, "time for which idle machines are kept for reuse by later runs")
+		source    = flags.Bool("source", true, "record the state of the script's git repository with each run")
+		backupURL = flags.String("backup", "", "URL, e.g., s3://bucket/diviner.ddb, to which a local database is periodically backed up")
+		every     = flags.Duration("backup-every", time.Hour, "interval at which the database is backed up")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner run [-rounds n] [-trials n] [-stream] [-dedup] [-parallel n] [-study-parallel n] [-idle-timeout d] [-source=false] [-backup url [-backup-every d]] script.dv [studies-or-runs]
+
+Run performs trials for the studies as specified in the given diviner
+script. The rounds for each matching study is run concurrently; each
+round runs up to the given number of trials at the same time.
+
+If -stream is given, the study is run in streaming mode, maintaining
+n concurrent trials at all times, querying the underlying oracle for
+new points as needed.
+
+If -dedup is given, trials whose values (and replicate) were already
+evaluated by a successful run of the study are not run again; the
+results of the previous run are reused instead.
+
+The number of runs performed concurrently is limited by the
+parallelism of their systems, by their studies' max_parallel, and by
+the -parallel and -study-parallel flags, which limit all runs, and
+the runs of each study that does not set its own limit. These keep a
+large study from starving the other studies that share the runner.
+
+Machines are reused by later runs of their systems, possibly from
+other studies, and are stopped once they have been idle for the
+duration given by -idle-timeout. Scripts may keep files that are
+expensive to recreate (e.g., downloaded datasets) in the directory
+named by the environment variable DIVINER_CACHE, which is retained
+by reused machines.
+
+Each run is recorded with its source: the commit of the git
+repository that contains the script, the diff of any uncommitted
+changes, and a digest of the script itself, so that results may be
+traced back to the code that produced them (see diviner info). The
+source is not recorded if -source=false is given.
+
+A study is run by one runner at a time. Runners hold a lease on each
+study that they run, which is recorded in the database and renewed
+while the study runs; runs of the study by other runners fail right
+away, naming the process that holds its lease. The leases of runners
+that die expire after a minute, after which the study's orphaned runs
+are taken over by its next runner.
+
+If -backup is given, a local database is backed up to the given URL,
+e.g., on S3, at the interval given by -backup-every, so that the loss
+of the machine's disk does not lose the studies' runs. Backups may be
+restored by diviner restore.
+
+The run command runs a diagnostic http server where individual
+run status may be obtained. If a shared database is used, this may
+also be used to inspect run status. Studies may be paused and resumed
+through this server with the commands diviner pause and diviner
+resume.
+
+If no studies are specified, all defined studies are run concurrently.
+Concurrent studies share the machines of their systems: machines are
+given to the waiting runs of the study that holds the fewest of them,
+so that a study with many trials does not starve the others. The
+diagnostic server reports each study's usage of the runner.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+	}
+	studies, err := loadStudies(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	args = flags.Args()[1:]
+	// Make sure they are either all runs or all studies.
+	study := true // "all studies"
+	for i, arg := range args {
+		_, run := splitName(arg)
+		if i == 0 {
+			study = run == 0
+		} else if run == 0 != study {
+			fmt.Fprintf(os.Stderr, "%s: cannot mix studies and runs (args: %v)\n", arg, args)
+			flags.Usage()
+		}
+	}
+
+	go func() {
+		err := http.ListenAndServe(*httpaddr, nil)
+		log.Error.Printf("failed to start diagnostic http server: %v", err)
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := runner.New(db)
+	sink, err := stats.Open(*statsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runner.SetStats(sink)
+	runner.SetDedup(*dedup)
+	runner.SetParallelism(*parallel, *perStudy)
+	runner.SetIdleTimeout(*idle)
+	if *backupURL != "" {
+		if local == nil {
+			log.Fatal("-backup: only local databases are backed up")
+		}
+		runner.SetBackup(local, *backupURL, *every)
+	}
+	if *source && flags.Arg(0) != registryScript {
+		source, err := diviner.CaptureSource(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if source.Dirty {
+			log.Printf("warning: %s has uncommitted changes, which are recorded with each run", source.Repository)
+		}
+		runner.SetSource(source)
+	}
+	go func() {
+		if err := runner.Loop(ctx); err != context.Canceled {
+			log.Fatal(err)
+		}
+	}()
+	expvar.Publish("diviner", expvar.Func(func() interface{} { return runner.Counters() }))
+	http.Handle("/", runner)
+
+	if study {
+		switch len(args) {
+		case 0: // all studies
+		case 1:
+			match(&studies, args[0])
+		default:
+			flags.Usage()
+			os.Exit(2)
+		}
+		names := make([]string, len(studies))
+		for i, study := range studies {
+			names[i] = study.Name
+			if study.Oracle == nil {
+				studies[i].Oracle = &oracle.GridSearch{}
+			}
+			if _, ok := studies[i].Oracle.(*oracle.GridSearch); ok {
+				if size := study.Params.Cardinality(); size.Exceeds(gridWarnSize) {
+					log.Printf("warning: study %s: grid search over a parameter space of size %s", study.Name, size)
+				}
+			}
+		}
+		checkNotArchived(ctx, db, studies)
+		log.Printf("performing trials for studies: %s", strings.Join(names, ", "))
+
+		var nerr uint32
+		_ = traverser.Each(len(studies), func(i int) error {
+			var err error
+			if *stream {
+				err = streamStudy(ctx, runner, studies[i], *ntrials)
+			} else {
+				err = runStudy(ctx, runner, studies[i], *ntrials, *nrounds)
+			}
+			if err != nil {
+				atomic.AddUint32(&nerr, 1)
+				log.Error.Printf("study %v failed: %v", studies[i], err)
+			}
+			return nil
+		})
+		if nerr > 0 {
+			os.Exit(1)
+		}
+	} else {
+		var (
+			runs      = make([]diviner.Run, len(args))
+			runsStudy = make([]diviner.Study, len(args))
+		)
+		for i := range runsStudy {
+			study, _ := splitName(args[i])
+			runsStudy[i] = find(studies, study)
+		}
+		checkNotArchived(ctx, db, runsStudy)
+		err := traverser.Each(len(runs), func(i int) (err error) {
+			study, seq := splitName(args[i])
+			runs[i], err = db.LookupRun(ctx, study, seq)
+			return
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		// The runs' values must be valid for the current version of
+		// their studies.
+		for i := range runs {
+			if runs[i].Values, err = runsStudy[i].Params.Coerce(runs[i].Values, false); err != nil {
+				log.Fatalf("run %s: %v", args[i], err)
+			}
+		}
+		err = traverse.Each(len(runs), func(i int) (err error) {
+			log.Printf("repeating run %s", args[i])
+			runs[i], err = runner.Run(ctx, runsStudy[i], runs[i].Values, *replicate)
+			return
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i, run := range runs {
+			if run.State != diviner.Success {
+				log.Error.Printf("run %s failed", args[i])
+			}
+		}
+	}
+}
+
+func runStudy(ctx context.Context, runner *runner.Runner, study diviner.Study, ntrials, nrounds int) error {
+	var (
+		round int
+		done  bool
+	)
+	for ; !done && round < nrounds; round++ {
+		log.Printf("%s: starting %d trials in round %d/%d", study.Name, ntrials, round, nrounds)
+		var err error
+		done, err = runner.Round(ctx, study, ntrials)
+		if err != nil {
+			return err
+		}
+	}
+	if done {
+		log.Printf("%s: study complete after %d rounds", study.Name, round)
+	}
+	return nil
+}
+
+// CheckNotArchived exits if any of the provided studies is archived in
+// the database, since the runs of archived studies are immutable.
+func checkNotArchived(ctx context.Context, db diviner.Database, studies []diviner.Study) {
+	for _, study := range studies {
+		stored, err := db.LookupStudy(ctx, study.Name)
+		if err == diviner.ErrNotExist {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		if stored.Archived {
+			log.Fatalf("study %s is archived; restore it with diviner archive -restore", study.Name)
+		}
+	}
+}
+
+func streamStudy(ctx context.Context, runner *runner.Runner, study diviner.Study, nparallel int) error {
+	streamer := runner.Stream(ctx, study, nparallel)
+	go func() {
+		var (
+			c             = make(chan os.Signal, 1)
+			lastInterrupt time.Time
+			stopped       bool
+		)
+		signal.Notify(c, os.Interrupt)
+		// Note that this won't do the right thing when using local
+		// bigmachines, since these are launched via os.Exec, and
+		// the signal will be propagated accordingly.
+		for range c {
+			if time.Since(lastInterrupt) < time.Second {
+				os.Exit(1)
+			}
+			if !stopped {
+				streamer.Stop()
+				stopped = true
+				log.Printf("stopping streaming study; waiting for ongoing trials to complete")
+			}
+			lastInterrupt = time.Now()
+		}
+	}()
+	return streamer.Wait()
+}
+
+func pause(args []string, resume bool) {
+	name := "pause"
+	if resume {
+		name = "resume"
+	}
+	var (
+		flags = flag.NewFlagSet(name, flag.ExitOnError)
+		addr  = flags.String("addr", "", "address of the diagnostic http server of the diviner run; defaults to the address given by -http on the local host")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: diviner %s [-addr address] studies...
+
+Pause instructs a running "diviner run" to stop launching new trials
+for the named studies. Runs that are already in flight are allowed to
+complete; the number of in-flight runs of each study is reported.
+Once a paused study has no runs in flight, the diviner run process may
+be stopped, and the study later continued from the database by a new
+diviner run: the study's oracle is given all of its previous trials.
+
+Resume resumes studies in a running "diviner run" that were paused by
+diviner pause.
+
+Studies are named exactly, as they appear on the status page.
+`, name)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	if *addr == "" {
+		*addr = *httpaddr
+		if strings.HasPrefix(*addr, ":") {
+			*addr = "localhost" + *addr
+		}
+	}
+	for _, study := range flags.Args() {
+		u := url.URL{
+			Scheme:   "http",
+			Host:     *addr,
+			Path:     "/" + name,
+			RawQuery: url.Values{"study": {study}}.Encode(),
+		}
+		resp, err := http.Post(u.String(), "text/plain", nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("%s %s: %s", name, study, resp.Status)
+		}
+		_, err = io.Copy(os.Stdout, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func showScript(db diviner.Database, args []string) {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner script script.dv study [-param1=value1 -param2=value2 ...]
+
+Script renders a bash script to standard output containing a function
+for each of the study's datasets and the study itself. The study's
+parameter values can be specified via flags; unspecified parameter
+values are sampled randomly from valid parameter values. The
+study is always invoked with replicate 0.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	ident := flags.String("ident", "run_ident", "run ID to use for the rendered run")
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+	}
+	getter := scriptGetter(flags.Arg(0))
+	studyUsage := func() {
+		fmt.Fprintln(os.Stderr, "valid studies are:")
+		for _, study := range studies(context.Background(), []string{".*"}, getter) {
+			fmt.Fprintf(os.Stderr, "\t%s\n", study.Name)
+		}
+		os.Exit(2)
+	}
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "no study specified")
+		studyUsage()
+	}
+	args = flags.Args()[2:]
+	ctx := context.Background()
+	matched := studies(ctx, []string{flags.Arg(1)}, getter)
+	if len(matched) != 1 {
+		if len(matched) == 0 {
+			fmt.Fprintln(os.Stderr, "no studies matched")
+		} else {
+			fmt.Fprintln(os.Stderr, "multiple studies matched")
+		}
+		studyUsage()
+	}
+	var (
+		study  = matched[0]
+		values = make(diviner.Values)
+		rng    = rand.New(rand.NewSource(0))
+	)
+	flags = flag.NewFlagSet(study.Name, flag.ExitOnError)
+	// Sort these so that they get the same (random) value each time.
+	names := make([]string, 0, len(study.Params))
+	for name := range study.Params {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		switch param := study.Params[name]; param.Kind() {
+		default:
+			log.Fatalf("unsupporter parameter %s", param)
+		case diviner.Integer:
+			p := new(diviner.Int)
+			flags.Int64Var((*int64)(p), name, param.Sample(rng).Int(), "integer parameter")
+			values[name] = p
+		case diviner.Real:
+			p := new(diviner.Float)
+			flags.Float64Var((*float64)(p), name, param.Sample(rng).Float(), "real parameter")
+			values[name] = p
+		case diviner.Str:
+			p := new(diviner.String)
+			flags.StringVar((*string)(p), name, param.Sample(rng).Str(), "string parameter")
+			values[name] = p
+		case diviner.Boolean:
+			p := new(diviner.Bool)
+			flags.BoolVar((*bool)(p), name, param.Sample(rng).Bool(), "boolean parameter")
+			values[name] = p
+		case diviner.Interval:
+			p := new(diviner.Duration)
+			flags.DurationVar((*time.Duration)(p), name, param.Sample(rng).(diviner.Duration).Duration(), "duration parameter")
+			values[name] = p
+		case diviner.Seq:
+			log.Printf("parameter %s (%s) cannot be overriden", name, param)
+			values[name] = param.Sample(rng)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	// Drop the values of conditional parameters that are inactive.
+	for _, param := range study.Params.Ordered() {
+		if !diviner.IsActive(param.Param, values) {
+			delete(values, param.Name)
+		}
+	}
+	values, err := study.Params.Coerce(values, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := study.Run(values, 0, *ident)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	if err := runConfigTemplate.Execute(&tw, config); err != nil {
+		log.Fatal(err)
+	}
+	tw.Flush()
+}
+
+func leaderboard(db diviner.Database, args []string) {
+	var (
+		flags             = flag.NewFlagSet("leaderboard", flag.ExitOnError)
+		objectiveOverride = flags.String("objective", "", "objective to use instead of studies' shared objective")
+		numEntries        = flags.Int("n", 10, "number of top trials to display")
+		valuesRe          = flags.String("values", ".", "comma-separated list of anchored regular expression matching parameter values to display")
+		metricsRe         = flags.String("metrics", "^$", `comma-separated list of anchored regular expression matching additional metrics to display.
+Each regex can be prefixed with '+' or '-'. A regex with '+' (or '-'), when combined with -best, will pick the largest (or smallest) metric from each run.`)
+		expand = flags.Bool("expand", false, "expand replicates into their own trials")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner leaderboard [-objective objective] [-n N] [-values values] [-metrics metrics] studies...
+
+Leaderboard displays the top N performing trials from the matched
+studies, as defined by the objective shared by the studies. This
+objective may be overridden via the -objective flag (which accepts a
+metric name, prefixed by "+" or "-" to indicate a maximizing or
+minimizing objective respectively). By default the runs and their
+score obtained by the objective metric is displayed; additional
+metrics as well as run parameter values may be displayed by
+specifying regular expressions for matching them via the flags
+-metrics and -values.
+
+Trials of studies with replicates are scored by the mean of their
+replicates' metrics, which is displayed together with its standard
+deviation and range over the replicates.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	ctx := context.Background()
+	studies := studies(ctx, flags.Args(), databaseGetter(db, time.Time{}))
+	if len(studies) == 0 {
+		log.Fatal("no studies matched")
+	}
+	objective := sharedObjective(studies, *objectiveOverride)
+	type trial struct {
+		diviner.Trial
+		Study string
+	}
+	var (
+		trialsMu sync.Mutex
+		trials   []trial
+	)
+	// Each study's leaderboard is retrieved from the database, which
+	// may use its metric index to avoid reading the study's other
+	// runs; the leaderboards are then merged.
+	err := traverser.Each(len(studies), func(i int) error {
+		var t []diviner.Trial
+		if *expand {
+			runs, err := db.BestRuns(ctx, studies[i].Name, objective, *numEntries)
+			if err != nil && err != diviner.ErrNotExist {
+				return err
+			}
+			for _, run := range runs {
+				if trial := studies[i].Trial(run); !trial.Infeasible {
+					t = append(t, trial)
+				}
+			}
+		} else {
+			var err error
+			t, err = diviner.Leaderboard(ctx, db, studies[i].Name, objective, *numEntries)
+			if err != nil && err != diviner.ErrNotExist {
+				return err
+			}
+		}
+		trialsMu.Lock()
+		for _, v := range t {
+			trials = append(trials, trial{v, studies[i].Name})
+		}
+		trialsMu.Unlock()
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var (
+		values  = make(map[string]bool)
+		metrics = make(map[string]bool)
+	)
+	for _, trial := range trials {
+		for name := range trial.Metrics {
+			metrics[name] = true
+		}
+		for name := range trial.Values {
+			values[name] = true
+		}
+	}
+	sort.SliceStable(trials, func(i, j int) bool {
+		iv, _ := trials[i].Metrics[objective.Metric]
+		jv, _ := trials[j].Metrics[objective.Metric]
+		switch objective.Direction {
+		case diviner.Maximize:
+			return jv < iv
+		case diviner.Minimize:
+			return iv < jv
+		default:
+			panic(objective)
+		}
+	})
+	if *numEntries > 0 && len(trials) > *numEntries {
+		trials = trials[:*numEntries]
+	}
+	delete(metrics, objective.Metric)
+
+	var metricsOrdered []diviner.Objective
+	for _, m := range strings.Split(*metricsRe, ",") {
+		obj := parseObjective(m)
+		re, err := regexp.Compile(obj.Metric)
+		if err != nil {
+			log.Fatal(err)
+		}
+		i := len(metricsOrdered)
+		for k := range metrics {
+			if !re.MatchString(k) {
+				continue
+			}
+			metricsOrdered = append(metricsOrdered, diviner.Objective{obj.Direction, k})
+			delete(metrics, k)
+		}
+		// We sort the expansions, but retain the order of the list of matches.
+		sort.Slice(metricsOrdered[i:len(metricsOrdered)], func(i0, i1 int) bool {
+			return metricsOrdered[i0-i].Metric < metricsOrdered[i1-i].Metric
+		})
+	}
+	var (
+		valuesOrdered = matchAndSort(values, *valuesRe)
+		tw            tabwriter.Writer
+	)
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprintf(&tw, "study\treplicates\t%s", objective.Metric)
+	if len(metricsOrdered) > 0 {
+		for _, metric := range metricsOrdered {
+			fmt.Fprint(&tw, "\t"+metric.Metric)
+		}
+	}
+	// Remove values that are the same for every study.
+	// They are not interesting to display. But if we have
+	// just a single trial, then the values may be pertinent.
+	if len(trials) > 1 {
+		var n int
+	dedup:
+		for _, name := range valuesOrdered {
+			var value diviner.Value
+			for i, trial := range trials {
+				if i == 0 {
+					value = trial.Values[name]
+				} else if !trial.Values[name].Equal(value) {
+					valuesOrdered[n] = name
+					n++
+					continue dedup
+				}
+			}
+		}
+		valuesOrdered = valuesOrdered[:n]
+	}
+
+	if len(valuesOrdered) > 0 {
+		fmt.Fprint(&tw, "\t"+strings.Join(valuesOrdered, "\t"))
+	}
+	fmt.Fprintln(&tw)
+	for _, trial := range trials {
+		v := trial.Metrics[objective.Metric]
+		min, max := trial.Range(objective.Metric)
+		sort.Slice(trial.Runs, func(i, j int) bool { return trial.Runs[i].Seq < trial.Runs[j].Seq })
+		seqs := make([]string, len(trial.Runs))
+		for i := range seqs {
+			seqs[i] = fmt.Sprint(trial.Runs[i].Seq)
+		}
+
+		replicates := make([]string, 0, trial.Replicates.Count())
+		r := trial.Replicates
+		for idx, r := r.Next(); idx != -1; idx, r = r.Next() {
+			replicates = append(replicates, fmt.Sprint(idx))
+		}
+
+		fmt.Fprintf(&tw, "%s:%s\t%s\t", trial.Study, strings.Join(seqs, ","), strings.Join(replicates, ","))
+		if min == max {
+			fmt.Fprintf(&tw, "%.3g", v)
+		} else {
+			fmt.Fprintf(&tw, "%.3g ±%.3g [%.3g-%.3g]", v, trial.Stddev(objective.Metric), min, max)
+		}
+		if len(metricsOrdered) > 0 {
+			metrics := make([]string, len(metricsOrdered))
+			for i, metric := range metricsOrdered {
+				if v, ok := trial.Metrics[metric.Metric]; ok {
+					min, max := trial.Range(metric.Metric)
+					log.Print(metric.Metric, ": ", min, max)
+					if min == max {
+						metrics[i] = fmt.Sprintf("%.3g", v)
+					} else {
+						metrics[i] = fmt.Sprintf("%.3g ±%.3g [%.3g-%.3g]", v, trial.Stddev(metric.Metric), min, max)
+					}
+				} else {
+					metrics[i] = "NA"
+				}
+			}
+			fmt.Fprint(&tw, "\t"+strings.Join(metrics, "\t"))
+		}
+		if len(valuesOrdered) > 0 {
+			values := make([]string, len(valuesOrdered))
+			for i, name := range valuesOrdered {
+				if v, ok := trial.Values[name]; ok {
+					switch v.Kind() {
+					default:
+						values[i] = fmt.Sprint(v)
+					case diviner.Real:
+						values[i] = fmt.Sprintf("%.3g", v.Float())
+					case diviner.Str:
+						values[i] = fmt.Sprintf("%q", v.Str())
+					}
+				} else {
+					values[i] = "NA"
+				}
+			}
+			fmt.Fprint(&tw, "\t"+strings.Join(values, "\t"))
+		}
+		fmt.Fprintln(&tw)
+	}
+	tw.Flush()
+}
+
+// ParseObjective parses an objective specified as a metric name,
+// prefixed by "+" or "-" to indicate a maximizing or minimizing
+// objective respectively. Objectives without a prefix are maximized.
+func parseObjective(spec string) (objective diviner.Objective) {
+	switch {
+	case strings.HasPrefix(spec, "-"):
+		objective.Direction = diviner.Minimize
+		objective.Metric = spec[1:]
+	case strings.HasPrefix(spec, "+"):
+		objective.Direction = diviner.Maximize
+		objective.Metric = spec[1:]
+	default:
+		objective.Direction = diviner.Maximize
+		objective.Metric = spec
+	}
+	return
+}
+
+// SharedObjective returns the objective shared by the provided
+// studies, or the objective parsed from override, if it is
+// nonempty. It fails if the studies do not share an objective and
+// no override is provided.
+func sharedObjective(studies []diviner.Study, override string) diviner.Objective {
+	if override != "" {
+		return parseObjective(override)
+	}
+	for i := range studies {
+		if i > 0 && studies[i].Objective != studies[i-1].Objective {
+			log.Fatalf("studies %s and %s do not share objectives: override with -objective",
+				studies[i].Name, studies[i-1].Name)
+		}
+	}
+	return studies[0].Objective
+}
+
+func importance(db diviner.Database, args []string) {
+	var (
+		flags             = flag.NewFlagSet("importance", flag.ExitOnError)
+		objectiveOverride = flags.String("objective", "", "objective to use instead of studies' shared objective")
+		trees             = flags.Int("trees", analysis.DefaultOptions.Trees, "number of trees in the random forest")
+		seed              = flags.Int64("seed", 0, "random seed of the analysis")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner importance [-objective objective] [-trees N] [-seed seed] studies...
+
+Importance estimates how much each parameter of the matched studies
+matters to their shared objective (which may be overridden as in
+diviner leaderboard). A random forest is fit to the studies'
+successful trials; a parameter's importance is the increase in the
+forest's out-of-bag prediction error when the parameter's values are
+permuted. Importance displays each parameter's share of the total
+importance, and the error increase relative to the variance of the
+objective.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	ctx := context.Background()
+	studies := studies(ctx, flags.Args(), databaseGetter(db, time.Time{}))
+	if len(studies) == 0 {
+		log.Fatal("no studies matched")
+	}
+	objective := sharedObjective(studies, *objectiveOverride)
+	var (
+		trialsMu sync.Mutex
+		trials   []diviner.Trial
+	)
+	err := traverser.Each(len(studies), func(i int) error {
+		t, err := diviner.Trials(ctx, db, studies[i], diviner.Success)
+		if err != nil {
+			return err
+		}
+		trialsMu.Lock()
+		t.Range(func(_ diviner.Value, v interface{}) {
+			trials = append(trials, v.(diviner.Trial))
+		})
+		trialsMu.Unlock()
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := analysis.DefaultOptions
+	opts.Trees = *trees
+	opts.Seed = *seed
+	importances, err := opts.ParamImportance(trials, objective)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprintln(&tw, "param\timportance\tmse_increase")
+	for _, imp := range importances {
+		fmt.Fprintf(&tw, "%s\t%.3f\t%.3f\n", imp.Param, imp.Importance, imp.MSEIncrease)
+	}
+	tw.Flush()
+}
+
+func htmlReport(db diviner.Database, args []string) {
+	var (
+		flags             = flag.NewFlagSet("report", flag.ExitOnError)
+		objectiveOverride = flags.String("objective", "", "objective to use instead of the study's objective")
+		top               = flags.Int("n", 10, "number of best trials to list")
+		output            = flags.String("o", "", "write the report to the provided file instead of standard output")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner report [-objective objective] [-n N] [-o file] study
+
+Report writes a self-contained HTML report of the named study (which
+is matched exactly), rendered from the contents of the database: the
+study's definition and run counts; its N best trials according to its
+objective (which may be overridden as in diviner leaderboard); a chart
+of the objective over time; the estimated importance of each
+parameter (see diviner importance); a parallel coordinates plot of
+its successful trials; and a summary of its failed runs. The report's
+scripts and styles are embedded in the page, so that it may be viewed
+offline and shared as a single file.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	var opts report.HTMLOptions
+	if *objectiveOverride != "" {
+		opts.Objective = parseObjective(*objectiveOverride)
+	}
+	opts.Top = *top
+	w := os.Stdout
+	if *output != "" {
+		var err error
+		if w, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	err := report.WriteHTML(context.Background(), db, bw, flags.Arg(0), opts)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && w != os.Stdout {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func convergence(db diviner.Database, args []string) {
+	var (
+		flags             = flag.NewFlagSet("convergence", flag.ExitOnError)
+		objectiveOverride = flags.String("objective", "", "objective to use instead of studies' shared objective")
+		all               = flags.Bool("all", false, "display every successful run, not only those that improved on the best objective")
+		summary           = flags.Bool("summary", false, "display a summary line for each study instead")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner convergence [-objective objective] [-all] [-summary] studies...
+
+Convergence displays, for each of the matched studies, the best value
+of the studies' shared objective (which may be overridden as in
+diviner leaderboard) attained so far as the studies' successful runs
+completed, in order of completion. By default only the runs that
+improved on the best value are displayed; with -all, every successful
+run that reported the objective is displayed. Each row includes the
+number of successful runs completed so far and the time elapsed since
+the study's first run was created, so that studies that explore the
+same space with different oracles may be compared.
+
+With -summary, a single line is displayed for each study instead: its
+best value, the run that attained it, and the number of successful
+runs that have completed since (its stall count); a study that has
+stalled for many runs has likely converged.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	ctx := context.Background()
+	studies := studies(ctx, flags.Args(), databaseGetter(db, time.Time{}))
+	if len(studies) == 0 {
+		log.Fatal("no studies matched")
+	}
+	objective := sharedObjective(studies, *objectiveOverride)
+	curves := make([]diviner.Convergence, len(studies))
+	err := traverser.Each(len(studies), func(i int) error {
+		var err error
+		curves[i], err = diviner.StudyConvergence(ctx, db, studies[i].Name, objective)
+		if err == diviner.ErrNotExist {
+			err = nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	if *summary {
+		fmt.Fprintf(&tw, "study\truns\t%s\tbest_run\tbest_at\tstalled\n", objective.Metric)
+		for i, c := range curves {
+			best, ok := c.Best()
+			if !ok {
+				fmt.Fprintf(&tw, "%s\t%d\tNA\tNA\tNA\t%d\n", studies[i].Name, c.Runs, c.Stalled())
+				continue
+			}
+			fmt.Fprintf(&tw, "%s\t%d\t%.4g\t%s:%d\t%d\t%d\n",
+				studies[i].Name, c.Runs, best.Best, studies[i].Name, best.Seq, best.Runs, c.Stalled())
+		}
+		tw.Flush()
+		return
+	}
+	fmt.Fprintf(&tw, "study\truns\trun\tcompleted\telapsed\t%s\tbest\n", objective.Metric)
+	for i, c := range curves {
+		for _, point := range c.Points {
+			if !*all && !point.Improved {
+				continue
+			}
+			fmt.Fprintf(&tw, "%s\t%d\t%s:%d\t%s\t%s\t%.4g\t%.4g\n",
+				studies[i].Name, point.Runs, studies[i].Name, point.Seq,
+				point.Completed.Local().Format("2006-01-02 15:04:05"),
+				point.Completed.Sub(c.Started).Round(time.Second),
+				point.Value, point.Best)
+		}
+	}
+	tw.Flush()
+}
+
+func reproduce(db diviner.Database, args []string) {
+	var (
+		flags  = flag.NewFlagSet("reproduce", flag.ExitOnError)
+		local  = flags.Bool("local", false, "perform the reproduction on the local machine instead of the run's systems")
+		load   = flags.String("script", "", "script defining the run's study, used if the run has no recorded config")
+		render = flags.Bool("print", false, "print the run's script instead of performing it")
+		seed   = flags.Int64("seed", 0, "seed for the reproduction, instead of the run's seed")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner reproduce [-local] [-script script.dv] [-seed n] [-print] run
+
+Reproduce performs a new run that reproduces the named historical run:
+it uses the run's values and replicate, together with the run config
+(script, datasets, local files, and systems) that was recorded when
+the run was created. Runs created before configs were recorded are
+reproduced with the config defined by their study in the script given
+by -script. Reproduce warns if any of the run's local files have
+changed since the run was created. The reproduction is given the
+run's recorded seed (in DIVINER_SEED), unless another is given by
+-seed.
+
+The reproduction is recorded as a new run of the study, labeled with
+reproduces=run. When it completes, its metrics are displayed next to
+the original run's, for verification.
+
+If -local is given, the run and its datasets are performed on the
+local machine. If -print is given, the run's script (as in diviner
+script) is written to standard output instead.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	name, seq := splitName(flags.Arg(0))
+	if seq == 0 {
+		log.Fatalf("%s: not a run", flags.Arg(0))
+	}
+	ctx := context.Background()
+	orig, err := db.LookupRun(ctx, name, seq)
+	if err != nil {
+		log.Fatalf("run %s: %v", flags.Arg(0), err)
+	}
+	study, err := db.LookupStudy(ctx, name)
+	if err != nil {
+		log.Fatalf("study %s: %v", name, err)
+	}
+	if *load != "" {
+		studies, err := loadStudies(*load)
+		if err != nil {
+			log.Fatal(err)
+		}
+		study = find(studies, name)
+	}
+	config, err := orig.ResolveConfig(study)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if orig.Config.IsZero() {
+		log.Printf("run %s has no recorded config; using the config defined by %s", orig.ID(), *load)
+	}
+	if *seed != 0 {
+		if *seed < 0 || *seed > math.MaxInt32 {
+			log.Fatalf("seed %d is out of range", *seed)
+		}
+		config.Seed = *seed
+	}
+	changed, err := config.ChangedLocalFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range changed {
+		log.Error.Printf("warning: local file %s has changed since run %s was created", path, orig.ID())
+	}
+	if *render {
+		var tw tabwriter.Writer
+		tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+		if err := runConfigTemplate.Execute(&tw, config); err != nil {
+			log.Fatal(err)
+		}
+		tw.Flush()
+		return
+	}
+	if *local {
+		system := &diviner.System{ID: "local", System: bigmachine.Local}
+		config.Systems = []*diviner.System{system}
+		datasets := make([]diviner.Dataset, len(config.Datasets))
+		for i, dataset := range config.Datasets {
+			dataset.Systems = config.Systems
+			datasets[i] = dataset
+		}
+		config.Datasets = datasets
+	}
+
+	go func() {
+		err := http.ListenAndServe(*httpaddr, nil)
+		log.Error.Printf("failed to start diagnostic http server: %v", err)
+	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runner := runner.New(db)
+	go func() {
+		if err := runner.Loop(ctx); err != context.Canceled {
+			log.Fatal(err)
+		}
+	}()
+	http.Handle("/", runner)
+	log.Printf("reproducing run %s with values %s (replicate %d)", orig.ID(), orig.Values, orig.Replicate)
+	run, err := runner.Reproduce(ctx, study, orig, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("run %s: %s", run.ID(), run.State)
+	var (
+		origMetrics = orig.Trial().Metrics
+		runMetrics  = run.Trial().Metrics
+		names       = make(map[string]bool)
+	)
+	for name := range origMetrics {
+		names[name] = true
+	}
+	for name := range runMetrics {
+		names[name] = true
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	fmt.Fprintf(&tw, "metric\t%s\t%s\n", orig.ID(), run.ID())
+	for _, name := range matchAndSort(names, ".") {
+		fmt.Fprintf(&tw, "%s\t%s\t%s\n", name, formatMetric(origMetrics, name), formatMetric(runMetrics, name))
+	}
+	tw.Flush()
+	if run.State != diviner.Success {
+		os.Exit(1)
+	}
+}
+
+// FormatMetric formats the named metric, or "-" if it is missing.
+func formatMetric(metrics diviner.Metrics, name string) string {
+	v, ok := metrics[name]
+	if !ok {
+		return "-"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func logs(db diviner.Database, args []string) {
+	var (
+		flags     = flag.NewFlagSet("logs", flag.ExitOnError)
+		sinceFlag = flags.String("since", "", "only show messages that have been generated since the provided date or duration")
+		follow    = flags.Bool("f", false, "follow the log: print updates as they are appended")
+		stream    = flags.String("stream", "all", "the output streams to show: stdout, stderr, or all")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: diviner logs [-f] [-stream stdout|stderr|all] run
+
+Logs writes a run's logs to standard output. If the -f flag is given,
+the logs is followed and updates are printed as they become available,
+until the run completes. The -stream flag selects whether to show
+what the run's script wrote to standard output (together with
+diviner's own messages), to standard error, or both.
+`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	study, seq := splitName(flags.Arg(0))
+	if seq == 0 {
+		log.Fatalf("invalid run name %s", flags.Arg(0))
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = parseSince(*sinceFlag); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			flags.Usage()
+		}
+	}
+	streams, err := diviner.ParseLogStream(*stream)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+	}
+	if _, err := io.Copy(os.Stdout, diviner.FilterLog(db.Log(study, seq, since, *follow), streams)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func label(db diviner.Database, args []string) {
+	flags := flag.NewFlagSet("label", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner label run labels...
+
+Label updates the labels attached to the named run. Each label is
+given as key=value or key (a label without a value) to add the label,
+replacing any existing value, or as key- to remove it. With no labels,
+the run's current labels are printed. Runs are selected by their
+labels with "diviner list -runs -labels selector".`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	study, seq := splitName(flags.Arg(0))
+	if seq == 0 {
+		log.Fatalf("%s: not a run", flags.Arg(0))
+	}
+	var (
+		set    = make(diviner.Labels)
+		remove []string
+	)
+	for _, arg := range flags.Args()[1:] {
+		if strings.HasSuffix(arg, "-") && !strings.Contains(arg, "=") {
+			remove = append(remove, strings.TrimSuffix(arg, "-"))
+			continue
+		}
+		labels, err := diviner.ParseLabels(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for key, value := range labels {
+			set[key] = value
+		}
+	}
+	ctx := context.Background()
+	if len(set) == 0 && len(remove) == 0 {
+		run, err := db.LookupRun(ctx, study, seq)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(run.Labels)
+		return
+	}
+	labels, err := diviner.UpdateLabels(ctx, db, study, seq, set, remove)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(labels)
+}
+
+func enqueue(db diviner.Database, args []string) {
+	flags := flag.NewFlagSet("enqueue", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner enqueue script.dv study [name=value...]
+
+Enqueue requests a trial of the named study, defined in script.dv,
+with the given parameter values, bypassing the study's oracle: for
+example,
+
+	diviner enqueue mnist.dv mnist learning_rate=3e-4 batch_size=512
+
+The trial is queued in the database, and performed by the next round
+of "diviner run" (or by a running "diviner run -stream") before new
+trials are requested from the study's oracle. Queued trials are
+labeled "queued" and are otherwise tracked like other trials; they
+are among the trials observed by the study's oracle. A value must be
+given for each of the study's active parameters; if the study has a
+fidelity, it may be given as well, and defaults to the study's full
+fidelity. With no values, the study's queued trials are printed.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	studies, err := loadStudies(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var (
+		ctx   = context.Background()
+		study = find(studies, flags.Arg(1))
+	)
+	if flags.NArg() == 2 {
+		queued, err := db.QueuedValues(ctx, study.Name)
+		if err != nil && err != diviner.ErrNotExist {
+			log.Fatal(err)
+		}
+		for _, values := range queued {
+			fmt.Println(values)
+		}
+		return
+	}
+	values, err := parseValues(study, flags.Args()[2:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if values, err = diviner.Enqueue(ctx, db, study, values); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(values)
+}
+
+// ParseValues parses the provided parameter values, each given as
+// name=value, of the provided study. Values are parsed according to
+// the kinds of their parameters; the study's fidelity, if any, is a
+// real number.
+func parseValues(study diviner.Study, args []string) (diviner.Values, error) {
+	values := make(diviner.Values)
+	for _, arg := range args {
+		i := strings.IndexByte(arg, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid value %q: expected name=value", arg)
+		}
+		name, str := arg[:i], arg[i+1:]
+		kind := diviner.Real
+		if param, ok := study.Params[name]; ok {
+			kind = param.Kind()
+		} else if study.Fidelity == nil || name != study.Fidelity.Name {
+			return nil, fmt.Errorf("invalid value %q: study %s has no parameter %s", arg, study.Name, name)
+		}
+		var (
+			v   diviner.Value
+			err error
+		)
+		switch kind {
+		case diviner.Integer:
+			var n int64
+			n, err = strconv.ParseInt(str, 10, 64)
+			v = diviner.Int(n)
+		case diviner.Real:
+			var f float64
+			f, err = strconv.ParseFloat(str, 64)
+			v = diviner.Float(f)
+		case diviner.Str:
+			v = diviner.String(str)
+		case diviner.Boolean:
+			var b bool
+			b, err = strconv.ParseBool(str)
+			v = diviner.Bool(b)
+		case diviner.Interval:
+			var d time.Duration
+			d, err = time.ParseDuration(str)
+			v = diviner.Duration(d)
+		default:
+			return nil, fmt.Errorf("invalid value %q: parameters of kind %s cannot be given", arg, kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q: %v", arg, err)
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+func datasets(db diviner.Database, args []string) {
+	var (
+		flags      = flag.NewFlagSet("dataset", flag.ExitOnError)
+		invalidate = flags.Bool("invalidate", false, "invalidate the named datasets, so that they are produced again")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner dataset [-invalidate] names...
+
+Dataset displays, for each named dataset, the digest of its definition
+as of its last completion, together with its completion time. Datasets
+are produced again when their digests change. With -invalidate, the
+datasets' records are instead deleted, so that the datasets are
+produced again when next required by a run.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	ctx := context.Background()
+	if *invalidate {
+		for _, name := range flags.Args() {
+			if err := db.InvalidateDataset(ctx, name); err != nil {
+				log.Fatalf("dataset %s: %v", name, err)
+			}
+		}
+		return
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	defer tw.Flush()
+	for _, name := range flags.Args() {
+		record, err := db.LookupDataset(ctx, name)
+		if err == diviner.ErrNotExist {
+			fmt.Fprintf(&tw, "%s\tnot completed\n", name)
+			continue
+		} else if err != nil {
+			log.Fatalf("dataset %s: %v", name, err)
+		}
+		fmt.Fprintf(&tw, "%s\t%s\t%s\n", record.Name, record.Digest, record.Completed.Local().Format(time.RFC3339))
+	}
+}
+
+func archive(db diviner.Database, args []string) {
+	var (
+		flags   = flag.NewFlagSet("archive", flag.ExitOnError)
+		restore = flags.Bool("restore", false, "restore the archived studies instead")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner archive [-restore] studies...
+
+Archive archives the named studies. Archived studies are omitted from
+listings (see diviner list -archived), their runs can no longer be
+performed or modified, and their retention policies are not applied.
+With -restore, archived studies are restored instead.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	ctx := context.Background()
+	for _, study := range studies(ctx, flags.Args(), databaseGetter(db, time.Time{})) {
+		if study.Archived == !*restore {
+			continue
+		}
+		if err := diviner.ArchiveStudy(ctx, db, study.Name, !*restore); err != nil {
+			log.Fatalf("study %s: %v", study.Name, err)
+		}
+		if *restore {
+			log.Printf("restored study %s", study.Name)
+		} else {
+			log.Printf("archived study %s", study.Name)
+		}
+	}
+}
+
+func retention(db diviner.Database, args []string) {
+	var (
+		flags      = flag.NewFlagSet("retention", flag.ExitOnError)
+		failedLogs = flags.String("failed-logs", "", "duration, e.g., 30d or 72h, for which the logs of failed runs are retained; 0 retains them indefinitely")
+		keepBest   = flags.Int("keep-best", 0, "number of the best runs that are retained; 0 retains all runs")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner retention [-failed-logs duration] [-keep-best k] study
+
+Retention sets the retention policy of the given study, which is
+applied by diviner maintain, or by a database served with diviner
+serve-db -maintain. With -failed-logs, the logs of failed runs are
+deleted once they are older than the given duration (e.g., 30d), and
+the runs are labeled logs_deleted; with -keep-best, completed runs
+that are not among the study's k best, according to its objective,
+are deleted. Flags that are not given leave the corresponding part of
+the policy unchanged. Without flags, the study's policy is printed.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	ctx := context.Background()
+	study, err := db.LookupStudy(ctx, flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	policy := study.Retention
+	var set bool
+	flags.Visit(func(f *flag.Flag) {
+		set = true
+		switch f.Name {
+		case "failed-logs":
+			if policy.FailedLogs, err = parseDays(*failedLogs); err != nil {
+				log.Fatalf("-failed-logs: %v", err)
+			}
+		case "keep-best":
+			policy.KeepBest = *keepBest
+		}
+	})
+	if !set {
+		fmt.Println(policy)
+		return
+	}
+	if policy.FailedLogs < 0 || policy.KeepBest < 0 {
+		log.Fatal("retention durations and counts must not be negative")
+	}
+	if err := diviner.SetRetention(ctx, db, study.Name, policy); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(policy)
+}
+
+// ParseDays parses a duration, which may also be given as a number of
+// days, e.g., "30d".
+func parseDays(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func maintain(db diviner.Database, local *localdb.DB, args []string) {
+	var (
+		flags      = flag.NewFlagSet("maintain", flag.ExitOnError)
+		every      = flags.Duration("every", 0, "perform maintenance at the given interval until interrupted")
+		compaction = flags.Bool("compact", false, "compact a local database after maintenance, reclaiming the space of deleted data")
+		trainDict  = flags.Bool("train-log-dict", false, "train a new zstd log dictionary for a local database on its recent logs")
+		dictSize   = flags.Int("dict-size", logchunk.DefaultDictionarySize, "maximum size, in bytes, of the trained log dictionary")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner maintain [-every interval] [-compact] [-train-log-dict [-dict-size n]]
+
+Maintain applies the retention policies of the database's studies
+(see diviner retention): it deletes the logs of old failed runs, and
+the runs that are not among their studies' best. Archived studies
+are skipped. With -every, maintenance is performed at the given
+interval until the command is interrupted.
+
+Local database files do not shrink when data are deleted from them.
+With -compact, a local database is rewritten after maintenance into
+a fresh file that contains only its live data, and the reclaimed
+space is reported. Databases cannot be compacted while they are
+open elsewhere, e.g., by a runner or by diviner serve-db.
+
+With -train-log-dict, a new dictionary is trained on samples of a
+local database's recent logs, and stored in the database. The logs
+that are subsequently written with zstd compression (see the flag
+-log-compression) are compressed with the dictionary, which makes
+their (small) chunks much smaller. Logs compressed with earlier
+dictionaries remain readable.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	if *compaction && local == nil {
+		log.Fatal("-compact: only local databases are compacted")
+	}
+	if *trainDict && local == nil {
+		log.Fatal("-train-log-dict: only local databases have log dictionaries")
+	}
+	if (*compaction || *trainDict) && *every > 0 {
+		log.Fatal("-compact and -train-log-dict cannot be combined with -every")
+	}
+	ctx := context.Background()
+	if *every > 0 {
+		diviner.MaintainEvery(ctx, db, *every)
+		return
+	}
+	report, err := diviner.Maintain(ctx, db, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(report)
+	if *trainDict {
+		dict, err := local.TrainLogDictionary(ctx, *dictSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("trained log dictionary %d (%s)\n", dict.ID, data.Size(len(dict.Content)))
+	}
+	if !*compaction {
+		return
+	}
+	filename := local.Path()
+	if err := local.Close(); err != nil {
+		log.Fatal(err)
+	}
+	before, after, err := localdb.Compact(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var reclaimed int64
+	if after < before {
+		reclaimed = before - after
+	}
+	fmt.Printf("%s: compacted from %s to %s, reclaiming %s\n",
+		filename, data.Size(before), data.Size(after), data.Size(reclaimed))
+}
+
+func lineage(db diviner.Database, args []string) {
+	var (
+		flags       = flag.NewFlagSet("lineage", flag.ExitOnError)
+		descendants = flags.Bool("descendants", false, "display the runs derived from the run instead of its ancestors")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner lineage [-descendants] run
+
+Lineage displays the ancestry of the named run: the runs from which
+it was derived, ending with the run itself. Retries are derived from
+the attempt they retry, and runs forked by population based training
+from the run whose values and checkpoint they exploit. Each run is
+shown with how it was derived from its parent, its attempt number,
+state, and parameter values. If -descendants is given, the runs that
+were derived, directly or indirectly, from the named run are shown
+instead.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	study, seq := splitName(flags.Arg(0))
+	if seq == 0 {
+		log.Fatalf("invalid run name %s", flags.Arg(0))
+	}
+	var (
+		ctx  = context.Background()
+		runs []diviner.Run
+		err  error
+	)
+	if *descendants {
+		runs, err = diviner.Descendants(ctx, db, study, seq)
+	} else {
+		runs, err = diviner.Lineage(ctx, db, study, seq)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tw tabwriter.Writer
+	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
+	for _, run := range runs {
+		derivation := "-"
+		if run.Parent != 0 {
+			derivation = fmt.Sprintf("%s of %d", run.Derivation(), run.Parent)
+		}
+		fmt.Fprintf(&tw, "%s\t%s\tattempt %d\t%s\t%s\n",
+			run.ID(), derivation, run.Attempt, run.State, run.Values)
+	}
+	tw.Flush()
+}
+
+func artifacts(db diviner.Database, args []string) {
+	var (
+		flags  = flag.NewFlagSet("artifacts", flag.ExitOnError)
+		output = flags.String("o", "", "write the artifact to the named file instead of standard output")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner artifacts [-o file] run|study [artifact]
+
+Artifacts lists the artifacts registered by the named run. If an
+artifact is named, its contents are instead written to standard
+output, or to the file given by -o. If a study is named in place of a
+run, the artifact is taken from the study's best successful run, as
+determined by its objective, that registered it.
+
+Runs register artifacts by printing directives of the form
+"DIVINER: artifact=name=url".`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 && flags.NArg() != 2 {
+		flags.Usage()
+	}
+	ctx := context.Background()
+	var (
+		run  diviner.Run
+		name = flags.Arg(1)
+		err  error
+	)
+	switch study, seq := splitName(flags.Arg(0)); {
+	case seq != 0:
+		if run, err = db.LookupRun(ctx, study, seq); err != nil {
+			log.Fatal(err)
+		}
+	case name == "":
+		log.Fatalf("%s: not a run", flags.Arg(0))
+	default:
+		s, err := db.LookupStudy(ctx, study)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if run, _, err = diviner.BestArtifact(ctx, db, s, name); err != nil {
+			log.Fatalf("study %s: artifact %s: %v", study, name, err)
+		}
+		log.Printf("artifact %s from run %s", name, run.ID())
+	}
+	if name == "" {
+		for _, artifact := range run.Artifacts {
+			fmt.Println(artifact)
+		}
+		return
+	}
+	artifact, ok := run.Artifact(name)
+	if !ok {
+		log.Fatalf("run %s has no artifact %s", run.ID(), name)
+	}
+	r, err := artifact.Open(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+		w = f
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serveDB(db diviner.Database, args []string) {
+	var (
+		flags    = flag.NewFlagSet("serve-db", flag.ExitOnError)
+		addr     = flags.String("addr", ":6001", "address on which to serve the database")
+		interval = flags.Duration("maintain", 0, "interval at which the studies' retention policies are applied; never if 0")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: diviner serve-db [-addr address] [-maintain interval]
+
+Serve-db serves the database given by the -db flag over gRPC, so that
+it can be shared by diviner processes on other machines. Such processes
+use the database by passing the flag -db grpc,host:port.
+
+With -maintain, the server also applies the retention policies of the
+database's studies at the given interval (see diviner maintain).
+`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *interval > 0 {
+		go diviner.MaintainEvery(context.Background(), db, *interval)
+	}
+	srv := grpc.NewServer()
+	grpcdb.Register(srv, db)
+	log.Printf("serving database on %s", l.Addr())
+	if err := srv.Serve(l); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serveAPI(db diviner.Database, args []string) {
+	var (
+		flags  = flag.NewFlagSet("serve-api", flag.ExitOnError)
+		addr   = flags.String("addr", ":8080", "address on which to serve the API")
+		submit = flags.Bool("submit", false, "permit the submission of trials")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: diviner serve-api [-addr address] [-submit]
+
+Serve-api serves the studies, runs, metric histories, logs, and
+leaderboards of the database given by the -db flag as JSON over HTTP,
+so that they may be consumed by tools that do not link the diviner
+library, e.g., notebooks and dashboards. For example,
+
+	curl 'localhost:8080/v1/studies/mnist/runs?state=success&metric=acc>0.9'
+
+lists the successful runs of study mnist whose accuracy exceeds 0.9.
+The API is unauthenticated, and read-only unless -submit is given:
+the server then accepts trials that were evaluated elsewhere (POST
+/v1/studies/{study}/trials), recording them as successful runs. See
+package github.com/grailbio/diviner/api for its resources and
+parameters; directory python contains a Python client of the API.
+`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving API on %s", l.Addr())
+	server := api.New(db)
+	server.Submit = *submit
+	if err := http.Serve(l, server); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func migrate(config string, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: diviner -db local,filename migrate
+
+Migrate upgrades the local database given by the -db flag, if it was
+written by an older version of diviner, to the current schema version.
+`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+	filename := strings.TrimPrefix(config, "local,")
+	if filename == config {
+		log.Fatalf("database %s is not a local database; only local databases are migrated", config)
+	}
+	from, to, err := localdb.Migrate(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if from == to {
+		fmt.Printf("%s: up to date at schema version %d\n", filename, to)
+	} else {
+		fmt.Printf("%s: migrated from schema version %d to %d\n", filename, from, to)
+	}
+}
+
+func backup(config string, args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: diviner -db local,filename backup url
+
+Backup writes a snapshot of the local database given by the -db flag
+to the given URL, e.g., s3://bucket/diviner.ddb, from which it may be
+restored by diviner restore. The database is opened read-only: if a
+runner has it open for writing, a snapshot of the database as of the
+backup's start is backed up. Such runners may instead back it up
+themselves (see diviner run -backup).
+`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	filename := strings.TrimPrefix(config, "local,")
+	if filename == config {
+		log.Fatalf("database %s is not a local database; only local databases are backed up", config)
+	}
+	db, err := localdb.OpenReadOnly(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Backup(context.Background(), flags.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func restore(config string, args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: diviner -db local,filename restore url
+
+Restore restores the backup at the given URL (see diviner backup) as
+the local database given by the -db flag, which must not exist.
+`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	filename := strings.TrimPrefix(config, "local,")
+	if filename == config {
+		log.Fatalf("database %s is not a local database; only local databases are restored", config)
+	}
+	if err := localdb.Restore(context.Background(), flags.Arg(0), filename); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func exportBigQuery(db diviner.Database, args []string) {
+	var (
+		flags     = flag.NewFlagSet("bigquery", flag.ExitOnError)
+		project   = flags.String("project", "", "GCP project containing the table")
+		sinceFlag = flags.String("since", "", "only export runs that have been updated since the provided date or duration")
+		every     = flags.Duration("every", 0, "if nonzero, export newly completed runs perpetually at this interval")
+		schema    = flags.Bool("schema", false, "print the table's schema and exit")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner bigquery [-project project] [-since time] [-every duration] table studies...
+
+Bigquery appends the completed runs of the matching studies to the
+provided BigQuery table (given as dataset.table) using the bq command
+line tool. The table's schema is printed by the -schema flag. If
+-every is given, the command runs perpetually, exporting runs that
+complete after each export.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *schema {
+		fmt.Println(bigquery.Schema)
+		return
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = parseSince(*sinceFlag); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			flags.Usage()
+		}
+	}
+	var (
+		ctx   = context.Background()
+		table = bigquery.Table{Project: *project, Name: flags.Arg(0)}
+	)
+	for {
+		var (
+			start = time.Now()
+			names []string
+		)
+		for _, study := range studies(ctx, flags.Args()[1:], databaseGetter(db, since)) {
+			names = append(names, study.Name)
+		}
+		n, err := bigquery.Export(ctx, db, table, names, since)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("exported %d runs from %d studies to %s", n, len(names), table.Name)
+		if *every == 0 {
+			return
+		}
+		since = start
+		time.Sleep(*every)
+	}
+}
+
+func exportTensorBoard(db diviner.Database, args []string) {
+	var (
+		flags     = flag.NewFlagSet("tensorboard", flag.ExitOnError)
+		sinceFlag = flags.String("since", "", "only export runs that have been updated since the provided date or duration")
+		every     = flags.Duration("every", 0, "if nonzero, export updated runs perpetually at this interval")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner tensorboard [-since time] [-every duration] logdir studies...
+
+Tensorboard writes the parameter values and metric histories of the
+runs of the matching studies as TensorBoard event files under the
+provided log directory, which may be a local path or an S3 URL. Each
+run is written to the directory logdir/study/seq, so that TensorBoard,
+started with --logdir logdir, displays each run separately, grouped by
+study. Metrics are written as scalar summaries at the steps at which
+they were reported; parameter values are written as a text summary.
+
+If -every is given, the command runs perpetually, mirroring the
+histories of runs that are updated after each export; the event files
+of such runs are rewritten.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = parseSince(*sinceFlag); err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			flags.Usage()
+		}
+	}
+	var (
+		ctx    = context.Background()
+		logdir = flags.Arg(0)
+	)
+	for {
+		var (
+			start = time.Now()
+			names []string
+		)
+		for _, study := range studies(ctx, flags.Args()[1:], databaseGetter(db, since)) {
+			names = append(names, study.Name)
+		}
+		n, err := tensorboard.Export(ctx, db, logdir, names, since)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("exported %d runs from %d studies to %s", n, len(names), logdir)
+		if *every == 0 {
+			return
+		}
+		since = start
+		time.Sleep(*every)
+	}
+}
+
+func mlflowBridge(db diviner.Database, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage:
+	diviner mlflow export [-url url] [-prefix prefix] [-since time] [-every duration] studies...
+	diviner mlflow import [-url url] [-as name] script.dv study experiment
+
+Mlflow bridges diviner and an MLflow tracking server, given by -url,
+which defaults to $MLFLOW_TRACKING_URI.
+
+Mlflow export mirrors the runs of the matching studies into the
+tracking server: each study is exported to an experiment of the same
+name, prefixed by -prefix; each run to an MLflow run with the run's
+parameter values, metric history, state, labels, and artifacts. If
+-every is given, the command runs perpetually, exporting runs that are
+updated after each export; only metrics reported since the previous
+export are logged.
+
+Mlflow import imports the finished runs of the named MLflow
+experiment as successful runs of the named study, defined in the
+provided script, or of the study named by -as, which is created with
+the definition of the scripted study. The runs' parameters are parsed
+according to the study's parameters; runs whose parameters are
+missing or invalid are skipped. Imported trials warm-start the study,
+or studies that transfer from it (see Study.Transfer).`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	var (
+		cmd         = args[0]
+		flags       = flag.NewFlagSet("mlflow "+cmd, flag.ExitOnError)
+		trackingURL = flags.String("url", os.Getenv("MLFLOW_TRACKING_URI"), "URL of the MLflow tracking server")
+		prefix      = flags.String("prefix", "", "prefix of the names of exported experiments")
+		sinceFlag   = flags.String("since", "", "only export runs that have been updated since the provided date or duration")
+		every       = flags.Duration("every", 0, "if nonzero, export updated runs perpetually at this interval")
+		as          = flags.String("as", "", "name of the study into which runs are imported")
+	)
+	flags.Usage = func() {
+		usage()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	if *trackingURL == "" {
+		log.Fatal("no tracking server: provide -url or set MLFLOW_TRACKING_URI")
+	}
+	var (
+		ctx    = context.Background()
+		client = &mlflow.Client{URL: *trackingURL}
+	)
+	switch cmd {
+	case "export":
+		if flags.NArg() == 0 {
+			flags.Usage()
+		}
+		var since time.Time
+		if *sinceFlag != "" {
+			var err error
+			if since, err = parseSince(*sinceFlag); err != nil {
+				fmt.Fprintf(os.Stderr, err.Error())
+				flags.Usage()
+			}
+		}
+		for {
+			var (
+				start = time.Now()
+				names []string
+			)
+			for _, study := range studies(ctx, flags.Args(), databaseGetter(db, since)) {
+				names = append(names, study.Name)
+			}
+			n, err := mlflow.Export(ctx, db, client, *prefix, names, since)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("exported %d runs from %d studies to %s", n, len(names), *trackingURL)
+			if *every == 0 {
+				return
+			}
+			since = start
+			time.Sleep(*every)
+		}
+	case "import":
+		if flags.NArg() != 3 {
+			flags.Usage()
+		}
+		studies, err := loadStudies(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		study := find(studies, flags.Arg(1))
+		if *as != "" {
+			study.Name = *as
+		}
+		imported, skipped, err := mlflow.Import(ctx, client, db, flags.Arg(2), study)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("imported %d runs from experiment %s into study %s; skipped %d runs with invalid parameters",
+			imported, flags.Arg(2), study.Name, skipped)
+	default:
+		usage()
+	}
+}
+
+func wandbBridge(db diviner.Database, args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `usage:
+	diviner wandb export -entity entity -project project [-since time] [-every duration] studies...
+	diviner wandb sweep -entity entity -project project [-method method] script.dv study
+
+Wandb bridges diviner and Weights & Biases. Requests are authorized
+by $WANDB_API_KEY. Runs and sweeps belong to the given project, owned
+by the given entity (a user or team).
+
+Wandb export logs the runs of the matching studies as W&B runs,
+grouped by study: each run's configuration contains its parameter
+values, and its history its metric reports. If -every is given, the
+command runs perpetually, exporting runs that are updated after each
+export; only metrics reported since the previous export are
+streamed.
+
+Wandb sweep creates a sweep over the parameters of the study defined
+in the provided script, optimizing its objective with the provided
+search method (bayes, random, or grid; default bayes), and prints the
+sweep's ID. The study may then use the sweep as its oracle, through
+the wandb_sweep builtin, so that the sweep's controller suggests its
+trials.`)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	var (
+		cmd       = args[0]
+		flags     = flag.NewFlagSet("wandb "+cmd, flag.ExitOnError)
+		entity    = flags.String("entity", "", "the user or team that owns the project")
+		project   = flags.String("project", "", "the W&B project")
+		sinceFlag = flags.String("since", "", "only export runs that have been updated since the provided date or duration")
+		every     = flags.Duration("every", 0, "if nonzero, export updated runs perpetually at this interval")
+		method    = flags.String("method", "bayes", "the sweep's search method")
+	)
+	flags.Usage = func() {
+		usage()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	if *entity == "" || *project == "" {
+		log.Fatal("no project: provide -entity and -project")
+	}
+	var (
+		ctx    = context.Background()
+		client = &wandb.Client{Entity: *entity, Project: *project}
+	)
+	switch cmd {
+	case "export":
+		if flags.NArg() == 0 {
+			flags.Usage()
+		}
+		var since time.Time
+		if *sinceFlag != "" {
+			var err error
+			if since, err = parseSince(*sinceFlag); err != nil {
+				fmt.Fprintf(os.Stderr, err.Error())
+				flags.Usage()
+			}
+		}
+		for {
+			var (
+				start = time.Now()
+				names []string
+			)
+			for _, study := range studies(ctx, flags.Args(), databaseGetter(db, since)) {
+				names = append(names, study.Name)
+			}
+			n, err := wandb.Export(ctx, db, client, names, since)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("exported %d runs from %d studies to project %s", n, len(names), *project)
+			if *every == 0 {
+				return
+			}
+			since = start
+			time.Sleep(*every)
+		}
+	case "sweep":
+		if flags.NArg() != 2 {
+			flags.Usage()
+		}
+		studies, err := loadStudies(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		study := find(studies, flags.Arg(1))
+		config, err := wandb.SweepConfig(study, *method)
+		if err != nil {
+			log.Fatal(err)
+		}
+		id, err := client.CreateSweep(ctx, config, study.Description)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(id)
+	default:
+		usage()
+	}
+}
+
+func exportRuns(db diviner.Database, args []string) {
+	var (
+		flags     = flag.NewFlagSet("export", flag.ExitOnError)
+		format    = flags.String("format", "csv", "output format: csv or json (JSON Lines)")
+		runState  = flags.String("state", "pending,success,failure,timedout,preempted", "list of run states to export")
+		sinceFlag = flags.String("since", "", "only export runs that have been updated since the provided date or duration")
+		output    = flags.String("o", "", "write output to the provided file instead of standard output")
+		costs     = flags.Bool("costs", false, "export the aggregate estimated cost of each study instead of its runs")
+		trials    = flags.Bool("trials", false, "export the (replicated) trials of each study instead of its runs")
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: diviner export [-format csv|json] [-state states] [-since time] [-costs] [-trials] [-o file] studies...
+
+Export writes the runs of the matching studies, including their
+parameter values, metrics, states, and timestamps, in a format
+suitable for analysis with tools like pandas or R. CSV output
+contains a column for each parameter value ("values.name") and metric
+("metrics.name") reported by the exported runs; JSON output contains
+a JSON object for each run, one per line. Runs include their machine
+types, regions, and estimated costs, where known.
+
+If -costs is given, export instead writes a record for each study
+containing the number of its runs, their total duration, and their
+total estimated cost in dollars.
+
+If -trials is given, export instead writes the trials of each study,
+whose runs' metrics are aggregated over replicates, as a table with a
+column for each parameter and metric, suitable for parallel
+coordinate and scatter plots: CSV output contains a row for each
+trial; JSON output contains a table object, with a list of
+coordinates for each column, for each study. The -since flag does
+not apply to trials.`)
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+	}
+	f, err := export.ParseFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		if since, err = parseSince(*sinceFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var (
+		ctx   = context.Background()
+		state = parseStates(*runState)
+		names []string
+	)
+	for _, study := range studies(ctx, flags.Args(), databaseGetter(db, since)) {
+		names = append(names, study.Name)
+	}
+	w := os.Stdout
+	if *output != "" {
+		if w, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	var n int
+	switch {
+	case *costs:
+		n, err = export.ExportCosts(ctx, db, bw, f, names)
+	case *trials:
+		n, err = export.ExportTrials(ctx, db, bw, f, names, state)
+	default:
+		n, err = export.Export(ctx, db, bw, f, names, state, since)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && w != os.Stdout {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case *costs:
+		log.Printf("exported costs of %d studies", n)
+	case *trials:
+		log.Printf("exported %d trials from %d studies", n, len(names))
+	default:
+		log.Printf("exported %d runs from %d studies", n, len(names))
+	}
+}
+
+func databaseGetter(db diviner.Database, since time.Time) func(context.Context, string, bool) []diviner.Study {
+	return func(ctx context.Context, query string, isPrefix bool) []diviner.Study {
+		if !isPrefix {
+			study, err := db.LookupStudy(ctx, query)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return []diviner.Study{study}
+		}
+		studies, err := db.ListStudies(ctx, query, since)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sort.Slice(studies, func(i, j int) bool {
+			return studies[i].Name < studies[j].Name
+		})
+		return studies
+	}
+}
+
+// gridWarnSize is the size of parameter spaces above which grid
+// searches are warned about before they are launched.
+const gridWarnSize = 10000
+
+// registryScript is the script name used to refer to the studies
+// registered (by diviner.Register) in the running binary.
+const registryScript = "go:registry"
+
+// loadStudies loads the studies defined by the named script. If the
+// name is registryScript, the registered studies are returned
+// instead; YAML study specifications (.yaml or .yml) are loaded by
+// script.LoadYAML.
+func loadStudies(filename string) ([]diviner.Study, error) {
+	switch {
+	case strings.HasSuffix(filename, ".yaml"), strings.HasSuffix(filename, ".yml"):
+		return script.LoadYAML(filename, nil)
+	case filename != registryScript:
+		return script.Load(filename, nil)
+	}
+	studies := diviner.Registered()
+	if len(studies) == 0 {
+		return nil, fmt.Errorf("%s: no studies are registered in this binary", registryScript)
+	}
+	return studies, nil
+}
+
+func scriptGetter(filename string) func(context.Context, string, bool) []diviner.Study {
+	return func(_ context.Context, query string, isPrefix bool) []diviner.Study {
+		studies, err := loadStudies(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !isPrefix {
+			return []diviner.Study{find(studies, query)}
+		}
+		var n int
+		for _, study := range studies {
+			if strings.HasPrefix(study.Name, query) {
+				studies[n] = study
+				n++
+			}
+		}
+		return studies[:n]
+	}
+}
+
+func studies(ctx context.Context, args []string, get func(context.Context, string, bool) []diviner.Study) []diviner.Study {
+	var studies []diviner.Study
+	for _, arg := range args {
+		re, err := regexp.Compile(`^` + arg + `$`)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// TODO(marius): make point query if complete
+		prefix, complete := re.LiteralPrefix()
+		matched := get(ctx, prefix, !complete)
+		for _, study := range matched {
+			if re.MatchString(study.Name) {
+				studies = append(studies, study)
+			}
+		}
+	}
+	if len(studies) == 0 {
+		return nil
+	}
+	sort.SliceStable(studies, func(i, j int) bool { return studies[i].Name < studies[j].Name })
+	var n int
+	for i := range studies {
+		if studies[n].Name != studies[i].Name {
+			n++
+		}
+		studies[n] = studies[i]
+	}
+	return studies[:n+1]
+}
+
+func matchAndSort(keys map[string]bool, match string) []string {
+	sorted := make([]string, 0, len(keys))
+	for _, m := range strings.Split(match, ",") {
+		re, err := regexp.Compile(m)
+		if err != nil {
+			log.Fatal(err)
+		}
+		i := len(sorted)
+		for k := range keys {
+			if !re.MatchString(k) {
+				continue
+			}
+			sorted = append(sorted, k)
+			delete(keys, k)
+		}
+		// We sort the expansions, but retain the order of the list of matches.
+		sort.Strings(sorted[i:len(sorted)])
+	}
+	return sorted
+}
+
+func splitName(name string) (study string, seq uint64) {
+	parts := strings.SplitN(name, ":", 2)
+	switch len(parts) {
+	case 1:
+		return parts[0], 0
+	case 2:
+		if parts[1] == "" {
+			log.Fatalf("%s: empty run seq", name)
+		}
+		seq, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			log.Fatalf("invalid name %s: %v", name, err)
+		}
+		return parts[0], seq
+	default:
+		panic(parts)
+	}
+}
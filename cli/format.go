@@ -2,7 +2,7 @@
 // Use of this source code is governed by the Apache 2.0
 // license that can be found in the LICENSE file.
 
-package main
+package cli
 
 import (
 	"strings"
@@ -64,3 +64,9 @@ func spacePrefix(s string) string {
 	}
 	return prefix
 }
+
+// Shquote quotes s for Bash, so that it is interpreted as a single
+// word with the literal value s.
+func shquote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package client provides a stable, high-level API for embedding
+// diviner in Go programs. A Client bundles a database with a runner,
+// and provides methods to define studies, conduct trials, and query
+// their results, without wiring together diviner's internals.
+//
+// A typical use:
+//
+//	func main() {
+//		bigmachine.Init()
+//		db, err := client.Open("local,studies.ddb")
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		c := client.New(db)
+//		defer c.Close()
+//		if err := c.Run(ctx, study, 10, 5); err != nil {
+//			log.Fatal(err)
+//		}
+//		best, err := c.Best(ctx, study.Name, study.Objective, 1)
+//		...
+//	}
+//
+// Because runs are executed with bigmachine, programs using Client to
+// conduct trials must call bigmachine.Init early in main.
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/dydb"
+	"github.com/grailbio/diviner/grpcdb"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/diviner/memdb"
+	"github.com/grailbio/diviner/oracle"
+	"github.com/grailbio/diviner/pgdb"
+	"github.com/grailbio/diviner/report"
+	"github.com/grailbio/diviner/runner"
+)
+
+// Open opens the database described by the provided specification,
+// which is of the form "kind,name". The following kinds are
+// supported:
+//
+//	local,filename     a localdb database stored in the provided file
+//	dynamodb,table     a dydb database using the provided DynamoDB table
+//	postgres,dsn       a pgdb database using the provided PostgreSQL data source
+//	grpc,address       a grpcdb client of the database served at the provided address
+//	memory,name        a memdb database private to the process; the name is ignored
+//
+// Local databases are encrypted with the key given by the environment
+// variable named by KeyEnv, if it is set.
+func Open(spec string) (diviner.Database, error) {
+	parts := strings.SplitN(spec, ",", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid database config %s", spec)
+	}
+	switch kind, name := parts[0], parts[1]; kind {
+	case "local":
+		db, err := localdb.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return db, useKey(db)
+	case "dynamodb":
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		return dydb.New(sess, name), nil
+	case "postgres":
+		return pgdb.Open(name)
+	case "grpc":
+		return grpcdb.Dial(name)
+	case "memory":
+		return memdb.New(), nil
+	default:
+		return nil, fmt.Errorf("invalid database kind %s", kind)
+	}
+}
+
+// OpenReadOnly opens the database described by the provided
+// specification, as Open does, in read-only mode: writes to the
+// returned database fail with diviner.ErrReadOnly. Local databases
+// are opened with localdb.OpenReadOnly, so that any number of
+// readers may share a database file, including while it is open for
+// writing by a runner.
+func OpenReadOnly(spec string) (diviner.Database, error) {
+	if name := strings.TrimPrefix(spec, "local,"); name != spec {
+		db, err := localdb.OpenReadOnly(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := useKey(db); err != nil {
+			return nil, err
+		}
+		return diviner.ReadOnly(db), nil
+	}
+	db, err := Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	return diviner.ReadOnly(db), nil
+}
+
+// KeyEnv is the environment variable that holds the key of encrypted
+// local databases: either a base64-encoded 256-bit key, or
+// kms://keyid, naming the AWS KMS key that wraps the database's data
+// key. See localdb.DB.UseKey.
+const KeyEnv = "DIVINER_DB_KEY"
+
+// UseKey sets the key of the provided local database from KeyEnv,
+// closing the database if the key cannot be set.
+func useKey(db *localdb.DB) error {
+	spec := os.Getenv(KeyEnv)
+	if spec == "" {
+		return nil
+	}
+	if err := db.UseKey(context.Background(), spec); err != nil {
+		db.Close()
+		return err
+	}
+	return nil
+}
+
+// A Client is used to conduct and query diviner studies. Clients
+// are safe for concurrent use.
+type Client struct {
+	db diviner.Database
+
+	mu     sync.Mutex
+	runner *runner.Runner
+	cancel func()
+	errc   chan error
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// New returns a new client that stores its studies and runs in the
+// provided database.
+func New(db diviner.Database) *Client {
+	return &Client{db: db}
+}
+
+// Database returns the client's underlying database.
+func (c *Client) Database() diviner.Database {
+	return c.db
+}
+
+// Runner returns the client's runner, starting its loop if it has
+// not yet been started. The runner is stopped when the client is
+// closed, after which it may not be used.
+func (c *Client) Runner() *runner.Runner {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.runner == nil {
+		var ctx context.Context
+		ctx, c.cancel = context.WithCancel(context.Background())
+		c.runner = runner.New(c.db)
+		c.errc = make(chan error, 1)
+		go func(r *runner.Runner) {
+			c.errc <- r.Loop(ctx)
+		}(c.runner)
+	}
+	return c.runner
+}
+
+// Close stops the client's runner, if it was started. Runs in
+// progress are abandoned. Close may be called more than once; later
+// calls return the result of the first.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		cancel, errc := c.cancel, c.errc
+		c.mu.Unlock()
+		if cancel == nil {
+			return
+		}
+		cancel()
+		if err := <-errc; err != nil && err != context.Canceled {
+			c.closeErr = err
+		}
+	})
+	return c.closeErr
+}
+
+// Define registers the provided study in the database. The study is
+// not modified if it already exists.
+func (c *Client) Define(ctx context.Context, study diviner.Study) error {
+	_, err := c.db.CreateStudyIfNotExist(ctx, study)
+	return err
+}
+
+// Run conducts up to nrounds rounds of ntrials trials each for the
+// provided study, returning early if the study's oracle is
+// exhausted. If the study does not define an oracle, grid search is
+// used.
+func (c *Client) Run(ctx context.Context, study diviner.Study, ntrials, nrounds int) error {
+	study = withDefaults(study)
+	for round := 0; round < nrounds; round++ {
+		done, err := c.Runner().Round(ctx, study, ntrials)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+	}
+	return nil
+}
+
+// Stream conducts the provided study in streaming mode, maintaining
+// nparallel trials at a time. The returned streamer is used to stop
+// and wait for the study.
+func (c *Client) Stream(ctx context.Context, study diviner.Study, nparallel int) *runner.Streamer {
+	return c.Runner().Stream(ctx, withDefaults(study), nparallel)
+}
+
+// Trial conducts a single trial of the provided study with the
+// provided parameter values and replicate number, returning the
+// resulting run.
+func (c *Client) Trial(ctx context.Context, study diviner.Study, values diviner.Values, replicate int) (diviner.Run, error) {
+	return c.Runner().Run(ctx, study, values, replicate)
+}
+
+// Study returns the named study as stored in the database.
+func (c *Client) Study(ctx context.Context, name string) (diviner.Study, error) {
+	return c.db.LookupStudy(ctx, name)
+}
+
+// Studies returns the studies whose names have the provided prefix.
+func (c *Client) Studies(ctx context.Context, prefix string) ([]diviner.Study, error) {
+	return c.db.ListStudies(ctx, prefix, time.Time{})
+}
+
+// Runs returns the runs of the named study in the provided states.
+func (c *Client) Runs(ctx context.Context, study string, states diviner.RunState) ([]diviner.Run, error) {
+	return c.db.ListRuns(ctx, study, states, time.Time{})
+}
+
+// Trials returns the successful trials of the named study.
+func (c *Client) Trials(ctx context.Context, study string) ([]diviner.Trial, error) {
+	return report.Trials(ctx, c.db, study, diviner.Success)
+}
+
+// Best returns the n best trials of the named study, according to
+// the provided objective.
+func (c *Client) Best(ctx context.Context, study string, objective diviner.Objective, n int) ([]diviner.Trial, error) {
+	return report.Best(ctx, c.db, study, objective, n)
+}
+
+func withDefaults(study diviner.Study) diviner.Study {
+	if study.Oracle == nil {
+		study.Oracle = &oracle.GridSearch{}
+	}
+	return study
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/client"
+	"github.com/grailbio/testutil"
+)
+
+func TestOpen(t *testing.T) {
+	for _, spec := range []string{"local", "bogus,x"} {
+		if _, err := client.Open(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := client.Open("local," + filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New(db)
+	defer c.Close()
+
+	study := diviner.Study{
+		Name:      "test",
+		Params:    diviner.Params{"x": diviner.NewDiscrete(diviner.Int(1), diviner.Int(2))},
+		Objective: diviner.Objective{Direction: diviner.Minimize, Metric: "loss"},
+	}
+	if err := c.Define(ctx, study); err != nil {
+		t.Fatal(err)
+	}
+	studies, err := c.Studies(ctx, "te")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Name, "test"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i, loss := range []float64{0.5, 0.25} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: study.Name, Values: diviner.Values{"x": diviner.Int(i + 1)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, study.Name, run.Seq, diviner.Metrics{"loss": loss}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, study.Name, run.Seq, diviner.Success, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := c.Runs(ctx, study.Name, diviner.Any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	best, err := c.Best(ctx, study.Name, study.Objective, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(best), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := best[0].Values["x"].Int(), int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	db, err := client.Open("memory,test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.New(db)
+	study := diviner.Study{
+		Name:   "test",
+		Params: diviner.Params{"x": diviner.NewDiscrete(diviner.Int(1), diviner.Int(2), diviner.Int(3))},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			return diviner.Metrics{"loss": float64(values["x"].Int())}, nil
+		},
+		Objective: diviner.Objective{Direction: diviner.Minimize, Metric: "loss"},
+	}
+	// Studies without an oracle are conducted by grid search, which
+	// is exhausted after all three trials.
+	if err := c.Run(ctx, study, 2, 10); err != nil {
+		t.Fatal(err)
+	}
+	trials, err := c.Trials(ctx, study.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(trials), 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run, err := c.Trial(ctx, study, diviner.Values{"x": diviner.Int(2)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.State, diviner.Success; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Replicate, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if c.Database() != db {
+		t.Error("wrong database")
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Close(); err != nil {
+			t.Errorf("close %d: %v", i, err)
+		}
+	}
+}
+
+func TestCloseUnstarted(t *testing.T) {
+	c := client.New(nil)
+	for i := 0; i < 2; i++ {
+		if err := c.Close(); err != nil {
+			t.Errorf("close %d: %v", i, err)
+		}
+	}
+}
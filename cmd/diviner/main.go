@@ -102,7 +102,13 @@
 //	# key-value pairs, e.g., the line:
 //	#	METRICS: acc=0.4,loss=0.9
 //	# indicates that the "acc" metrics has a value of 0.4 and the
-//	# "loss" metric a value of 0.9.
+//	# "loss" metric a value of 0.9. Runs may report metrics
+//	# repeatedly, e.g., once per epoch; such reports may be tagged
+//	# with the reserved metric "step":
+//	#	METRICS: step=3,acc=0.4,loss=0.9
+//	# Metrics may also be reported as JSON objects, with the
+//	# prefix "DIVINER_METRICS ":
+//	#	DIVINER_METRICS {"step":3,"acc":0.4,"loss":0.9}
 //	neural_network = black_box(
 //	  name="neural_network",
 //	  params={
@@ -121,16 +127,26 @@
 //	)
 //
 // Scripts defined in a trial's run configuration are run on the specified
-// machine under its default user and environment.
+// machine under its default user and environment. The environment
+// variable DIVINER_SEED holds a random seed for the run, which is
+// recorded with it, so that stochastic results can be traced and
+// reproduced; run_config's seed argument fixes the seed instead.
 //
 // With a configuration in hand, the diviner tool is used to conduct trails,
 // and examine study results.
 //
+// Studies may also be defined directly in Go, and registered with
+// diviner.Register. Binaries that embed the diviner command line tool
+// (see package github.com/grailbio/diviner/cli) then accept the script
+// name "go:registry" to refer to these studies.
+//
 // Usage:
 //	diviner list [-runs] studies...
 //		List studies available studies or runs.
 //	diviner list -l script.dv [-runs] studies...
 //		List studies available studies defined in script.dv.
+//	diviner projects
+//		List the projects of the database's studies.
 //	diviner info [-v] [-l script] names...
 //		Display information for the given study or run names.
 //	diviner metrics id
@@ -151,11 +167,48 @@
 //		Re-run previous runs in studies defined in the script.dv.
 //		This uses parameter values from a previous run(s) and
 // 		re-runs them.
-//	diviner logs [-f] [-since=time] run
+//	diviner enqueue script.dv study [name=value...]
+//		Request a trial of the given study with the given parameter
+//		values, to be run before the study's oracle is consulted.
+//	diviner logs [-f] [-since=time] [-stream=stdout|stderr|all] run
 //		Write the logs for the given run to standard output.
 //	diviner [-db type,name] create-table
 //		Create the underlying database table required for storing
 //		Diviner studies and runs.
+//	diviner bigquery [-project project] [-since time] [-every duration] table studies...
+//		Append completed runs of the given studies to a BigQuery table.
+//	diviner tensorboard [-since time] [-every duration] logdir studies...
+//		Write the metric histories of the given studies' runs as
+//		TensorBoard event files.
+//	diviner mlflow export [-url url] [-prefix prefix] [-since time] [-every duration] studies...
+//		Mirror the given studies' runs into an MLflow tracking server.
+//	diviner mlflow import [-url url] [-as name] script.dv study experiment
+//		Import the finished runs of an MLflow experiment as trials of
+//		the given study.
+//	diviner wandb export -entity entity -project project [-since time] [-every duration] studies...
+//		Log the given studies' runs as Weights & Biases runs.
+//	diviner wandb sweep -entity entity -project project [-method method] script.dv study
+//		Create a Weights & Biases sweep over the given study's
+//		parameters.
+//	diviner archive [-restore] studies...
+//		Archive the given studies, hiding them and making their runs
+//		immutable, or restore them.
+//	diviner retention [-failed-logs duration] [-keep-best k] study
+//		Display or set the retention policy of the given study.
+//	diviner maintain [-every interval] [-compact] [-train-log-dict]
+//		Apply the retention policies of the database's studies, compact
+//		local databases, and train their log dictionaries.
+//	diviner [-db type,name] serve-db [-addr address] [-maintain interval]
+//		Serve the database to remote diviner processes over gRPC.
+//	diviner [-db type,name] serve-api [-addr address] [-submit]
+//		Serve the database's studies and runs as a JSON API over
+//		HTTP.
+//	diviner [-db local,filename] migrate
+//		Upgrade a local database to the current schema version.
+//	diviner [-db local,filename] backup url
+//		Back up a local database to the given URL, e.g., on S3.
+//	diviner [-db local,filename] restore url
+//		Restore a local database from a backup.
 //
 // diviner list [-runs] studies... lists the studies matching the regular
 // expressions given. If -runs is specified then the study's runs are
@@ -167,6 +220,9 @@
 // are shown. If -runs is given, the matching studies' runs are listed
 // instead.
 //
+// diviner projects lists the projects of the database's studies, and
+// the number of studies in each.
+//
 // diviner info [-v] [-l script] names... displays detailed
 // information about the matching study or run names. If -v is given
 // then even more verbose output is given. If -l is given, then
@@ -185,19 +241,63 @@
 // providing regular expressions to the -values and -metrics flags
 // respectively.
 //
+// diviner report [-objective objective] [-n N] [-o file] study writes
+// a self-contained HTML report of the named study: its best trials, a
+// chart of its objective over time, the importance of its parameters,
+// a parallel coordinates plot of its trials, and a summary of its
+// failed runs.
+//
+// diviner convergence [-objective objective] [-all] [-summary]
+// studies... displays the best objective value attained so far by
+// each of the matching studies as their successful runs completed.
+// With -summary, a line is displayed for each study instead, giving
+// its best value and the number of runs that have completed since it
+// was attained, so that studies may be checked for convergence, and
+// their oracles compared.
+//
 // diviner run [-rounds M] [-trials N] [-stream] script.dv [studies]
 // performs trials as defined in the provided script. M rounds of N
 // trials each are performed for each of the studies that matches the
 // argument. If no studies are specified, all studies are run
-// concurrently. If -stream is specified, the study is run in
-// streaming mode: N trials are maintained in parallel; new points
-// are queried from the study's oracle as needed.
+// concurrently, sharing the machines of their systems fairly. If
+// -stream is specified, the study is run in streaming mode: N trials
+// are maintained in parallel; new points are queried from the study's
+// oracle as needed. The -parallel flag
+// limits the number of runs performed concurrently over all studies,
+// and -study-parallel the concurrent runs of each study that does not
+// set its own limit with max_parallel, so that one large study cannot
+// starve the others. Machines are reused by later runs and stopped
+// after they have been idle for the duration given by -idle-timeout
+// (default 5m); files kept in $DIVINER_CACHE are retained across
+// the runs that reuse a machine. Each run is recorded with its
+// source: the commit of the git repository containing the script,
+// the diff of its uncommitted changes, and the script's digest, as
+// displayed by diviner info and included in exports; -source=false
+// disables this.
+//
+// A study is run by one runner at a time: runners hold a lease on each
+// study that they run, recorded in the database and renewed while the
+// study runs, and a second runner of the same study fails right away,
+// naming the process (pid and host) that holds it. The leases of
+// runners that die expire after a minute, after which the study's
+// next runner takes over its orphaned runs. A local database may be
+// opened by only one process: commands that open one held by a runner
+// fail after a few seconds rather than blocking; runners on several
+// machines share a database through diviner serve-db.
 //
 // diviner run script.dv runs... re-runs one or more runs from
 // studies defined in the provided script. Specifically: parameter
 // values are taken from the named runs and re-launched with the
 // current version of the study from the script.
 //
+// diviner enqueue script.dv study name=value... requests a trial of
+// the named study with explicit parameter values, bypassing its
+// oracle; e.g., "diviner enqueue mnist.dv mnist lr=3e-4 bs=512". The
+// trial is queued in the database and performed by the study's next
+// round, before new trials are requested from its oracle; its runs
+// are labeled "queued", and it is thereafter observed by the oracle
+// like any other trial. With no values, the study's queue is printed.
+//
 // diviner script script.dv study [-param=value...] renders a bash
 // script containing functions for each of the study's datasets as
 // well as the study itself. This is mostly intended for debugging
@@ -206,1167 +306,163 @@
 //
 // diviner logs [-f] run writes logs from the named run to standard
 // output. If -f is given, the log is followed and updates are written
-// as they appear.
+// as they appear, until the run completes. Diviner stores what a run's
+// script writes to standard output and standard error as separate
+// streams; -stream=stderr shows only the latter, e.g., to triage a
+// failure. The script's exit code, the reason for its failure, and
+// the last lines of its standard error are also shown by diviner info.
+//
+// diviner lineage [-descendants] run displays the ancestry of the
+// named run: the runs from which it was derived, ending with the run
+// itself. A retry is derived from the attempt it retries, and a run
+// forked by population based training from the run whose values and
+// checkpoint it exploits. With -descendants, the runs derived from
+// the named run are displayed instead.
 //
 // diviner [-db type,name] create-table creates the underlying
 // database table of the provided type and name (default
 // dynamodb,diviner). This is a one-time setup operation required
 // before using the table.
+// Databases are one of "local,filename" (a local database file),
+// "dynamodb,table" (a DynamoDB table), "postgres,dsn" (a PostgreSQL
+// database, shareable across machines, given by the provided data
+// source name, e.g., "postgres,postgres://user@host/diviner"),
+// "grpc,address" (a database served by diviner serve-db), or
+// "memory," (an in-memory database whose contents are discarded when
+// diviner exits, e.g., for trying out a study).
+//
+// Teams that share a database name their studies within projects.
+// The flag -project (default $DIVINER_PROJECT) confines a command to
+// the given project: studies and datasets are named within it, so
+// that two projects may each have a study named mnist, and studies of
+// other projects are not visible. Studies are stored under their
+// project-qualified names, e.g., vision/mnist, by which they may also
+// be named without -project.
+//
+// Local databases encrypt the logs and metrics of their runs with
+// AES-GCM when the environment variable DIVINER_DB_KEY is set, either
+// to a base64-encoded 256-bit key (e.g., from openssl rand -base64
+// 32), or to kms://keyid, naming an AWS KMS key that wraps a data key
+// stored in the database. Once a database is encrypted, its logs and
+// metrics can be neither read nor written without its key; studies
+// and runs' parameters remain readable. Backups of encrypted databases
+// are encrypted, too.
+//
+// Commands that only read the database (list, projects, info,
+// metrics, script, leaderboard, importance, report, convergence, logs,
+// lineage, artifacts, bigquery, tensorboard, wandb, export, and
+// serve-api without -submit) open it in read-only mode. Local database files
+// may thus be read by several such commands at once. Files that a runner
+// has open for writing are read from a snapshot, copied when the command
+// starts, so that such commands do not observe the runner's later writes
+// (e.g., diviner logs -f does not follow the logs of its runs): databases
+// whose readers must follow their writers should be served with diviner
+// serve-db and read through grpc,address.
+//
+// diviner bigquery [-project project] [-since time] [-every duration]
+// table studies... appends the completed runs of the matching studies
+// to the named BigQuery table (in the form dataset.table) using the bq
+// tool. If -every is given, the command runs perpetually, exporting
+// newly completed runs at the provided interval.
+//
+// diviner tensorboard [-since time] [-every duration] logdir
+// studies... writes the parameter values and metric histories of the
+// runs of the matching studies as TensorBoard event files, one
+// directory per run, under logdir/study/seq. The log directory may be
+// local or on S3. If -every is given, the command runs perpetually,
+// rewriting the event files of runs that are updated, so that
+// TensorBoard mirrors the studies as they progress.
+//
+// diviner mlflow export [-url url] [-prefix prefix] [-since time]
+// [-every duration] studies... mirrors the runs of the matching
+// studies into the MLflow tracking server at url (by default
+// $MLFLOW_TRACKING_URI): each study becomes an experiment, and each
+// run an MLflow run with the run's parameter values, metric history,
+// state, labels, and artifacts. With -every, the command runs
+// perpetually, exporting the runs that are updated.
+//
+// diviner mlflow import [-url url] [-as name] script.dv study
+// experiment imports the finished runs of the named MLflow experiment
+// as successful runs of the named study (or of the study named by
+// -as), so that they warm-start it or the studies that transfer from
+// it.
+//
+// diviner wandb export -entity entity -project project [-since time]
+// [-every duration] studies... logs the runs of the matching studies
+// as runs of the given Weights & Biases project, grouped by study,
+// with the runs' parameter values as their configurations and their
+// metric histories; with -every, the command runs perpetually,
+// streaming the metrics of runs as they are reported. diviner wandb
+// sweep -entity entity -project project [-method method] script.dv
+// study creates a sweep over the named study's parameters and prints
+// its ID; the study may then consume the sweep's suggestions through
+// the wandb_sweep oracle. Requests are authorized by $WANDB_API_KEY.
+//
+// diviner archive [-restore] studies... archives the matching studies:
+// archived studies are omitted from diviner list (unless -archived is
+// given) and from the API's study listings, and their runs can no
+// longer be performed or modified. With -restore, the studies are
+// restored.
+//
+// diviner retention [-failed-logs duration] [-keep-best k] study sets
+// the retention policy of the named study: the logs of its failed
+// runs are deleted once they are older than the given duration (e.g.,
+// 30d), and its completed runs that are not among its k best are
+// deleted. Retention policies are applied by diviner maintain, which
+// performs maintenance once (or, with -every, at the given interval),
+// and by diviner serve-db -maintain interval, which performs it in the
+// background of the database server. Local database files do not
+// shrink as data are deleted; diviner maintain -compact rewrites a
+// local database, after maintenance, into a fresh file that contains
+// only its live data, and reports the space reclaimed.
+//
+// Runs' logs are stored in chunks, each compressed independently: by
+// default with gzip, or as given by the flag -log-compression, e.g.,
+// zstd:19 (a scheme, optionally with a level). Zstd chunks in local
+// databases are much smaller when they are compressed with a
+// dictionary: diviner maintain -train-log-dict [-dict-size n] trains
+// one on the database's recent logs, and stores it in the database,
+// so that the zstd chunks subsequently written to it are compressed
+// with it. Chunks written with other schemes, or with earlier
+// dictionaries, remain readable.
+//
+// diviner [-db type,name] serve-db [-addr address] [-maintain interval]
+// serves the database over gRPC at the provided address (default
+// :6001), so that diviner processes on other machines may share it,
+// e.g., a local database: such processes use the database
+// "grpc,host:6001".
+//
+// diviner [-db type,name] serve-api [-addr address] [-submit] serves
+// the database's studies, runs, metric histories, logs, trials, and
+// leaderboards as a JSON API over HTTP at the provided address
+// (default :8080), with pagination and filtering, so that they may be
+// consumed by tools that do not link the diviner library (e.g.,
+// notebooks). For example, GET /v1/studies/mnist/runs?state=success
+// lists the successful runs of the study mnist. The API is read-only
+// unless -submit is given, in which case trials evaluated elsewhere
+// may be submitted to a study, and the database is opened for
+// writing. See package github.com/grailbio/diviner/api for the API's
+// resources; directory python contains a Python client.
+//
+// diviner [-db local,filename] migrate upgrades the schema of a local
+// database written by an older version of diviner, e.g., so that it
+// may be read in read-only mode. Local databases are also upgraded
+// automatically when they are opened for writing.
+//
+// diviner [-db local,filename] backup url writes a snapshot of a local
+// database to the given URL, e.g., s3://bucket/diviner.ddb, and
+// diviner [-db local,filename] restore url restores a backup as a new
+// local database. Runners that hold a local database open back it up
+// themselves with diviner run -backup url [-backup-every interval]
+// (by default, hourly), so that the loss of their machine's disk does
+// not lose weeks of experiments.
 //
 // [1] https://www.kdd.org/kdd2017/papers/view/google-vizier-a-service-for-black-box-optimization
 // [2] https://docs.bazel.build/versions/master/skylark/language.html
 package main
 
-import (
-	"context"
-	"encoding/csv"
-	"expvar"
-	"flag"
-	"fmt"
-	"io"
-	"math/rand"
-	"net/http"
-	_ "net/http/pprof"
-	"os"
-	"os/signal"
-	"regexp"
-	"sort"
-	"strconv"
-	"strings"
-	"sync"
-	"sync/atomic"
-	"text/tabwriter"
-	"text/template"
-	"time"
-
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/grailbio/base/file"
-	"github.com/grailbio/base/file/s3file"
-	"github.com/grailbio/base/log"
-	"github.com/grailbio/base/traverse"
-	"github.com/grailbio/bigmachine"
-	"github.com/grailbio/diviner"
-	"github.com/grailbio/diviner/dydb"
-	"github.com/grailbio/diviner/localdb"
-	"github.com/grailbio/diviner/oracle"
-	"github.com/grailbio/diviner/runner"
-	"github.com/grailbio/diviner/script"
-)
-
-func initS3() {
-	file.RegisterImplementation("s3", func() file.Implementation {
-		return s3file.NewImplementation(s3file.NewDefaultProvider(
-			session.Options{}),
-			s3file.Options{})
-	})
-}
-
-func usage() {
-	fmt.Fprintln(os.Stderr, `usage:
-	diviner list [-runs] studies...
-		List studies available studies or runs.
-	diviner list -l script.dv studies...
-		List studies available studies defined in script.dv.
-	diviner info [-v] [-l script] names...
-		Display information for the given study or run names.
-	diviner metrics id
-		Writes all metrics reported by the named run in TSV format.
-	diviner leaderboard [-objective objective] [-n N] [-values values] [-metrics metrics] studies...
-		Display a leaderboard of all trails in the provided studies. The leaderboard
-		uses the studies' shared objective unless overridden.
-	diviner run [-rounds M] [-trials N] [-stream] script.dv [studies]
-		Run M rounds of N trials of the studies matching regexp. All
-		studies are run if the regexp is omitted. If -stream is specified,
-		the study is run in streaming mode: N trials are maintained in
-		parallel; new points are queried from the study's oracle as needed.
-	diviner script script.dv study [-param=value...]
-		Render a bash script containing functions implementing a study,
-		including its datasets.
-	diviner logs [-f] run
-		Write the logs for the given run to standard output.
-	diviner [-db type,name] create-table
-		Create the underlying database table required for storing
-		Diviner studies and runs.
-
-Whenever studies are named in commands, they are interpreted as
-anchored regular expressions. Thus a given study name without any
-regular expression control characters matches the study exactly. The
--help flag for each subcommand provides detailed documentation for
-the command.
-
-Flags:`)
-	flag.PrintDefaults()
-	os.Exit(2)
-}
-
-var httpaddr = flag.String("http", ":6000", "http status address")
-
-var traverser = traverse.Limit(400)
-
-var defaultDB = "dynamodb,diviner"
+import "github.com/grailbio/diviner/cli"
 
 func main() {
-	initS3()
-	log.SetPrefix("")
-	log.AddFlags()
-	log.SetFlags(log.Ldate | log.Ltime)
-
-	runner.Logger = log.Info
-	cwd := flag.String("C", "", "Enter the given directory")
-	databaseConfig := flag.String("db", defaultDB, "database table where state is stored")
-	flag.Usage = usage
-	flag.Parse()
-	if flag.NArg() == 0 {
-		flag.Usage()
-	}
-
-	bigmachine.Init()
-
-	if *cwd != "" {
-		if err := os.Chdir(*cwd); err != nil {
-			log.Fatal(err)
-		}
-	}
-	var database diviner.Database
-	parts := strings.SplitN(*databaseConfig, ",", 2)
-	if len(parts) != 2 {
-		log.Fatalf("invalid database config %s", *databaseConfig)
-	}
-	switch kind, table := parts[0], parts[1]; kind {
-	case "local":
-		var err error
-		database, err = localdb.Open(table)
-		if err != nil {
-			log.Fatal(err)
-		}
-	case "dynamodb":
-		database = dydb.New(session.New(), table)
-	default:
-		log.Fatalf("invalid database kind %s", kind)
-	}
-
-	args := flag.Args()[1:]
-	switch flag.Arg(0) {
-	case "list":
-		list(database, args)
-	case "info":
-		info(database, args)
-	case "metrics":
-		metrics(database, args)
-	case "run":
-		run(database, args)
-	case "script":
-		showScript(database, args)
-	case "leaderboard":
-		leaderboard(database, args)
-	case "logs":
-		logs(database, args)
-	case "create-table":
-		if err := database.CreateTable(context.Background()); err != nil {
-			log.Fatal(err)
-		}
-	default:
-		flag.Usage()
-	}
-}
-
-func find(studies []diviner.Study, name string) diviner.Study {
-	for _, study := range studies {
-		if study.Name == name {
-			return study
-		}
-	}
-	fmt.Fprintf(os.Stderr, "study %s not defined in config %s\n", name, flag.Arg(0))
-	os.Exit(2)
-	panic("not reached")
-}
-
-func match(studies *[]diviner.Study, pat string) {
-	r, err := regexp.Compile(pat)
-	if err != nil {
-		log.Fatal(err)
-	}
-	var n int
-	for _, study := range *studies {
-		if r.MatchString(study.Name) {
-			(*studies)[n] = study
-			n++
-		}
-	}
-	if n == 0 {
-		log.Fatalf("no studies matched %s", r)
-	}
-	*studies = (*studies)[:n]
-}
-
-func parseSince(s string) (time.Time, error) {
-	if d, err := time.ParseDuration(s); err == nil {
-		return time.Now().Add(-d), nil
-	}
-	since, err := time.Parse("2006-01-02", s)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("-since=%s does not parse as a duration or date\n", s)
-	}
-	return since, nil
-}
-
-func list(db diviner.Database, args []string) {
-	var (
-		flags     = flag.NewFlagSet("list", flag.ExitOnError)
-		listRuns  = flags.Bool("runs", false, "list runs matching studies")
-		load      = flags.String("l", "", "load studies from the provided script file")
-		runState  = flags.String("state", "pending,success,failure", "list of run states to query")
-		status    = flags.Bool("s", false, "show status for pending runs")
-		sinceFlag = flags.String("since", "", "only show entries that have been updated since the provided date or duration")
-		valuesRe  = flags.String("values", "^$", "comma-separated list of anchored regular expression matching parameter values to display")
-	)
-	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, `usage:
-	diviner list [-runs] studies...
-	diviner list -l script.dv [-runs] studies...
-
-List prints a summary overview of all studies (or runs) that match
-the given study names.`)
-		flags.PrintDefaults()
-		os.Exit(2)
-	}
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	ctx := context.Background()
-	args = flags.Args()
-	if len(args) == 0 {
-		args = []string{".*"} // list all
-	}
-	var since time.Time
-	if *sinceFlag != "" {
-		var err error
-		if since, err = parseSince(*sinceFlag); err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			flags.Usage()
-		}
-	}
-	// This is a hack to make sure we don't overscan studies when looking at pending
-	// runs. One hour is more than enough slack for keepalive; but this really should
-	// be pushed into the database layer.
-	if since.IsZero() && *runState == "pending" && *listRuns {
-		since = time.Now().Add(-time.Hour)
-	}
-	getter := databaseGetter(db, since)
-	if *load != "" {
-		getter = scriptGetter(*load)
-	}
-	studies := studies(ctx, args, getter)
-	if !*listRuns {
-		for _, study := range studies {
-			fmt.Println(study.Name)
-		}
-		return
-	}
-	var tw tabwriter.Writer
-	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
-	var state diviner.RunState
-	for _, s := range strings.Split(*runState, ",") {
-		switch s {
-		case "pending":
-			state |= diviner.Pending
-		case "success":
-			state |= diviner.Success
-		case "failure":
-			state |= diviner.Failure
-		default:
-			log.Fatalf("invalid run state %s", state)
-		}
-	}
-	if state == 0 {
-		log.Fatal("no run states given")
-	}
-	runs := make([][]diviner.Run, len(studies))
-	err := traverser.Each(len(runs), func(i int) (err error) {
-		runs[i], err = db.ListRuns(ctx, studies[i].Name, state, since)
-		return err
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	valueKeys := make(map[string]bool)
-	for i := range studies {
-		for _, run := range runs[i] {
-			for key := range run.Values {
-				valueKeys[key] = true
-			}
-		}
-	}
-	var (
-		valuesOrdered = matchAndSort(valueKeys, *valuesRe)
-		values        = make([]string, len(valuesOrdered))
-		now           = time.Now()
-	)
-	for i, study := range studies {
-		sort.Slice(runs[i], func(j, k int) bool {
-			return runs[i][j].Seq < runs[i][k].Seq
-		})
-		for _, run := range runs[i] {
-			var layout = time.Kitchen
-			switch dur := now.Sub(run.Created); {
-			case dur > 7*24*time.Hour:
-				layout = "2Jan06"
-			case dur > 24*time.Hour:
-				layout = "Mon3:04PM"
-			}
-			runtime := run.Runtime
-			runtime -= runtime % time.Second
-			if !*status {
-				run.Status = ""
-			}
-			fmt.Fprintf(&tw, "%s:%d\t%s\t%s\t%s\t%s",
-				study.Name, run.Seq, run.Created.Local().Format(layout),
-				runtime, run.State, run.Status)
-			values = values[:0]
-			for _, key := range valuesOrdered {
-				if v, ok := run.Values[key]; ok {
-					values = append(values, fmt.Sprintf("%s:%s", key, v))
-				}
-			}
-			if len(values) > 0 {
-				fmt.Fprint(&tw, "\t", strings.Join(values, " "))
-			}
-			fmt.Fprintln(&tw)
-		}
-	}
-	tw.Flush()
-}
-
-var (
-	studyTemplate = template.Must(template.New("study").Parse(`study {{.Name}}:
-	objective:	{{.Objective}}{{range $_, $value := .Params.Sorted }}
-	{{$value.Name}}:	{{$value.Param}}{{end}}
-	oracle:	{{printf "%T" .Oracle}}
-	replicates:	{{.Replicates}}
-	description:	{{.Description}}
-`))
-
-	runFuncMap = template.FuncMap{
-		"reindent": reindent,
-		"join":     strings.Join,
-	}
-
-	runTemplate = template.Must(template.New("study").Funcs(runFuncMap).Parse(`run {{.study}}:{{.run.Seq}}:
-	state:	{{.run.State}}{{if .status}}
-	status:	{{.run.Status}}{{end}}
-	created:	{{.run.Created.Local}}
-	runtime:	{{.run.Runtime}}
-	restarts:	{{.run.Retries}}
-	replicate:	{{.run.Replicate}}
-	values:{{range $_, $value := .run.Values.Sorted }}
-		{{$value.Name}}:	{{$value.Value}}{{end}}{{if .verbose}}{{range $index, $metrics := .run.Metrics}}
-	metrics[{{$index}}]:{{range $_, $metric := $metrics.Sorted}}
-		{{$metric.Name}}:	{{$metric.Value}}{{end}}{{end}}{{else}}
-	metrics:{{range $_, $metric := .run.Trial.Metrics.Sorted }}
-		{{$metric.Name}}:	{{$metric.Value}}{{end}}{{end}}{{if .verbose}}
-	script:
-{{reindent "		" .run.Config.Script}}{{end}}
-`))
-
-	runConfigTemplate = template.Must(template.New("run_config").Funcs(runFuncMap).Parse(`{{range $_, $dataset :=  .Datasets}}function dataset{{$dataset.Name}} {
-#	if_not_exist:	{{$dataset.IfNotExist}}
-#	local_files:	{{join $dataset.LocalFiles ", "}}
-
-{{$dataset.Script}}
-}{{end}}
-function study {
-#	local_files:	{{join .LocalFiles ", "}}
-{{.Script}}
-}
-`))
-)
-
-func info(db diviner.Database, args []string) {
-	var (
-		flags   = flag.NewFlagSet("list", flag.ExitOnError)
-		verbose = flags.Bool("v", false, "show all available information")
-		load    = flags.String("l", "", "load studies from the provided script file")
-	)
-	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, `usage: diviner info [-v] [-l script] ids...
-
-Info displays detailed information about studies or runs.`)
-		flags.PrintDefaults()
-		os.Exit(2)
-	}
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	if flags.NArg() == 0 {
-		flags.Usage()
-	}
-	getter := databaseGetter(db, time.Time{})
-	if *load != "" {
-		getter = scriptGetter(*load)
-	}
-	ctx := context.Background()
-	var tw tabwriter.Writer
-	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
-	for _, arg := range flags.Args() {
-		study, seq := splitName(arg)
-		if seq == 0 {
-			study := getter(ctx, study, false)[0]
-			if err := studyTemplate.Execute(&tw, study); err != nil {
-				log.Fatal(err)
-			}
-		} else {
-			run, err := db.LookupRun(ctx, study, seq)
-			if err != nil {
-				log.Fatal(err)
-			}
-			err = runTemplate.Execute(&tw, map[string]interface{}{
-				"study":   study,
-				"run":     run,
-				"verbose": *verbose,
-			})
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-	}
-	tw.Flush()
-}
-
-func metrics(db diviner.Database, args []string) {
-	var (
-		flags     = flag.NewFlagSet("metrics", flag.ExitOnError)
-		metricsRe = flags.String("metrics", ".*", "comma-separated list of anchored regular expression matching metrics to display")
-	)
-	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, `usage: metrics id
-
-Writes all metrics reported by the provided run to standard output in
-TSV format. Every unique metric name reported over time is a single
-column; missing values are denoted by "NA".`)
-		flags.PrintDefaults()
-		os.Exit(2)
-	}
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	if flags.NArg() != 1 {
-		flags.Usage()
-	}
-	study, seq := splitName(flags.Arg(0))
-	if seq == 0 {
-		log.Fatalf("not a valid run: %s", flags.Arg(0))
-	}
-	ctx := context.Background()
-	run, err := db.LookupRun(ctx, study, seq)
-	if err != nil {
-		log.Fatal(err)
-	}
-	keys := make(map[string]bool)
-	for _, metrics := range run.Metrics {
-		for key := range metrics {
-			keys[key] = true
-		}
-	}
-	sorted := matchAndSort(keys, *metricsRe)
-	w := csv.NewWriter(os.Stdout)
-	w.Comma = '\t'
-	if err := w.Write(sorted); err != nil {
-		log.Fatal(err)
-	}
-	record := make([]string, len(sorted))
-	for _, metrics := range run.Metrics {
-		for i, key := range sorted {
-			v, ok := metrics[key]
-			if !ok {
-				record[i] = "NA"
-				continue
-			}
-			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
-		}
-		if err := w.Write(record); err != nil {
-			log.Fatal(err)
-		}
-	}
-	w.Flush()
-	if err := w.Error(); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func run(db diviner.Database, args []string) {
-	var (
-		flags     = flag.NewFlagSet("run", flag.ExitOnError)
-		ntrials   = flags.Int("trials", 1, "number of trials to run in each round, or parallelism when streaming")
-		stream    = flags.Bool("stream", false, "perform a streaming study")
-		nrounds   = flags.Int("rounds", 1, "number of rounds to run")
-		replicate = flags.Int("replicate", 0, "replicate to re-run")
-	)
-	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, `usage: diviner run [-rounds n] [-trials n] [-stream] script.dv [studies-or-runs]
-
-Run performs trials for the studies as specified in the given diviner
-script. The rounds for each matching study is run concurrently; each
-round runs up to the given number of trials at the same time.
-
-If -stream is given, the study is run in streaming mode, maintaining
-n concurrent trials at all times, querying the underlying oracle for
-new points as needed.
-
-The run command runs a diagnostic http server where individual
-run status may be obtained. If a shared database is used, this may
-also be used to inspect run status.
-
-If no studies are specified, all defined studies are run concurrently.`)
-		flags.PrintDefaults()
-		os.Exit(2)
-	}
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	if flags.NArg() < 1 {
-		flags.Usage()
-	}
-	studies, err := script.Load(flags.Arg(0), nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	args = flags.Args()[1:]
-	// Make sure they are either all runs or all studies.
-	study := true // "all studies"
-	for i, arg := range args {
-		_, run := splitName(arg)
-		if i == 0 {
-			study = run == 0
-		} else if run == 0 != study {
-			fmt.Fprintf(os.Stderr, "%s: cannot mix studies and runs (args: %v)\n", arg, args)
-			flags.Usage()
-		}
-	}
-
-	go func() {
-		err := http.ListenAndServe(*httpaddr, nil)
-		log.Error.Printf("failed to start diagnostic http server: %v", err)
-	}()
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	runner := runner.New(db)
-	go func() {
-		if err := runner.Loop(ctx); err != context.Canceled {
-			log.Fatal(err)
-		}
-	}()
-	expvar.Publish("diviner", expvar.Func(func() interface{} { return runner.Counters() }))
-	http.Handle("/", runner)
-
-	if study {
-		switch len(args) {
-		case 0: // all studies
-		case 1:
-			match(&studies, args[0])
-		default:
-			flags.Usage()
-			os.Exit(2)
-		}
-		names := make([]string, len(studies))
-		for i, study := range studies {
-			names[i] = study.Name
-			if study.Oracle == nil {
-				studies[i].Oracle = &oracle.GridSearch{}
-			}
-		}
-		log.Printf("performing trials for studies: %s", strings.Join(names, ", "))
-
-		var nerr uint32
-		_ = traverser.Each(len(studies), func(i int) error {
-			var err error
-			if *stream {
-				err = streamStudy(ctx, runner, studies[i], *ntrials)
-			} else {
-				err = runStudy(ctx, runner, studies[i], *ntrials, *nrounds)
-			}
-			if err != nil {
-				atomic.AddUint32(&nerr, 1)
-				log.Error.Printf("study %v failed: %v", studies[i], err)
-			}
-			return nil
-		})
-		if nerr > 0 {
-			os.Exit(1)
-		}
-	} else {
-		var (
-			runs      = make([]diviner.Run, len(args))
-			runsStudy = make([]diviner.Study, len(args))
-		)
-		for i := range runsStudy {
-			study, _ := splitName(args[i])
-			runsStudy[i] = find(studies, study)
-		}
-		err := traverser.Each(len(runs), func(i int) (err error) {
-			study, seq := splitName(args[i])
-			runs[i], err = db.LookupRun(ctx, study, seq)
-			return
-		})
-		if err != nil {
-			log.Fatal(err)
-		}
-		err = traverse.Each(len(runs), func(i int) (err error) {
-			log.Printf("repeating run %s", args[i])
-			runs[i], err = runner.Run(ctx, runsStudy[i], runs[i].Values, *replicate)
-			return
-		})
-		if err != nil {
-			log.Fatal(err)
-		}
-		for i, run := range runs {
-			if run.State != diviner.Success {
-				log.Error.Printf("run %s failed", args[i])
-			}
-		}
-	}
-}
-
-func runStudy(ctx context.Context, runner *runner.Runner, study diviner.Study, ntrials, nrounds int) error {
-	var (
-		round int
-		done  bool
-	)
-	for ; !done && round < nrounds; round++ {
-		log.Printf("%s: starting %d trials in round %d/%d", study.Name, ntrials, round, nrounds)
-		var err error
-		done, err = runner.Round(ctx, study, ntrials)
-		if err != nil {
-			return err
-		}
-	}
-	if done {
-		log.Printf("%s: study complete after %d rounds", study.Name, round)
-	}
-	return nil
-}
-
-func streamStudy(ctx context.Context, runner *runner.Runner, study diviner.Study, nparallel int) error {
-	streamer := runner.Stream(ctx, study, nparallel)
-	go func() {
-		var (
-			c             = make(chan os.Signal, 1)
-			lastInterrupt time.Time
-			stopped       bool
-		)
-		signal.Notify(c, os.Interrupt)
-		// Note that this won't do the right thing when using local
-		// bigmachines, since these are launched via os.Exec, and
-		// the signal will be propagated accordingly.
-		for range c {
-			if time.Since(lastInterrupt) < time.Second {
-				os.Exit(1)
-			}
-			if !stopped {
-				streamer.Stop()
-				stopped = true
-				log.Printf("stopping streaming study; waiting for ongoing trials to complete")
-			}
-			lastInterrupt = time.Now()
-		}
-	}()
-	return streamer.Wait()
-}
-
-func showScript(db diviner.Database, args []string) {
-	flags := flag.NewFlagSet("show", flag.ExitOnError)
-	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, `usage: diviner script script.dv study [-param1=value1 -param2=value2 ...]
-
-Script renders a bash script to standard output containing a function
-for each of the study's datasets and the study itself. The study's
-parameter values can be specified via flags; unspecified parameter
-values are sampled randomly from valid parameter values. The
-study is always invoked with replicate 0.`)
-		flags.PrintDefaults()
-		os.Exit(2)
-	}
-	ident := flags.String("ident", "run_ident", "run ID to use for the rendered run")
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	if flags.NArg() < 1 {
-		flags.Usage()
-	}
-	getter := scriptGetter(flags.Arg(0))
-	studyUsage := func() {
-		fmt.Fprintln(os.Stderr, "valid studies are:")
-		for _, study := range studies(context.Background(), []string{".*"}, getter) {
-			fmt.Fprintf(os.Stderr, "\t%s\n", study.Name)
-		}
-		os.Exit(2)
-	}
-	if flags.NArg() < 2 {
-		fmt.Fprintln(os.Stderr, "no study specified")
-		studyUsage()
-	}
-	args = flags.Args()[2:]
-	ctx := context.Background()
-	matched := studies(ctx, []string{flags.Arg(1)}, getter)
-	if len(matched) != 1 {
-		if len(matched) == 0 {
-			fmt.Fprintln(os.Stderr, "no studies matched")
-		} else {
-			fmt.Fprintln(os.Stderr, "multiple studies matched")
-		}
-		studyUsage()
-	}
-	var (
-		study  = matched[0]
-		values = make(diviner.Values)
-		rng    = rand.New(rand.NewSource(0))
-	)
-	flags = flag.NewFlagSet(study.Name, flag.ExitOnError)
-	// Sort these so that they get the same (random) value each time.
-	names := make([]string, 0, len(study.Params))
-	for name := range study.Params {
-		names = append(names, name)
-	}
-	for _, name := range names {
-		switch param := study.Params[name]; param.Kind() {
-		default:
-			log.Fatalf("unsupporter parameter %s", param)
-		case diviner.Integer:
-			p := new(diviner.Int)
-			flags.Int64Var((*int64)(p), name, param.Sample(rng).Int(), "integer parameter")
-			values[name] = p
-		case diviner.Real:
-			p := new(diviner.Float)
-			flags.Float64Var((*float64)(p), name, param.Sample(rng).Float(), "real parameter")
-			values[name] = p
-		case diviner.Str:
-			p := new(diviner.String)
-			flags.StringVar((*string)(p), name, param.Sample(rng).Str(), "string parameter")
-			values[name] = p
-		case diviner.Seq:
-			log.Printf("parameter %s (%s) cannot be overriden", name, param)
-			values[name] = param.Sample(rng)
-		}
-	}
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	for name, val := range values {
-		if p := study.Params[name]; !p.IsValid(val) {
-			log.Fatalf("value %s is not valid for parameter %s %s", val, name, p)
-		}
-	}
-	config, err := study.Run(values, 0, *ident)
-	if err != nil {
-		log.Fatal(err)
-	}
-	var tw tabwriter.Writer
-	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
-	if err := runConfigTemplate.Execute(&tw, config); err != nil {
-		log.Fatal(err)
-	}
-	tw.Flush()
-}
-
-func leaderboard(db diviner.Database, args []string) {
-	var (
-		flags             = flag.NewFlagSet("leaderboard", flag.ExitOnError)
-		objectiveOverride = flags.String("objective", "", "objective to use instead of studies' shared objective")
-		numEntries        = flags.Int("n", 10, "number of top trials to display")
-		valuesRe          = flags.String("values", ".", "comma-separated list of anchored regular expression matching parameter values to display")
-		metricsRe         = flags.String("metrics", "^$", `comma-separated list of anchored regular expression matching additional metrics to display.
-Each regex can be prefixed with '+' or '-'. A regex with '+' (or '-'), when combined with -best, will pick the largest (or smallest) metric from each run.`)
-		expand = flags.Bool("expand", false, "expand replicates into their own trials")
-	)
-	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, `usage: diviner leaderboard [-objective objective] [-n N] [-values values] [-metrics metrics] studies...
-
-Leaderboard displays the top N performing trials from the matched
-studies, as defined by the objective shared by the studies. This
-objective may be overridden via the -objective flag (which accepts a
-metric name, prefixed by "+" or "-" to indicate a maximizing or
-minimizing objective respectively). By default the runs and their
-score obtained by the objective metric is displayed; additional
-metrics as well as run parameter values may be displayed by
-specifying regular expressions for matching them via the flags
--metrics and -values.`)
-		flags.PrintDefaults()
-		os.Exit(2)
-	}
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	if flags.NArg() == 0 {
-		flags.Usage()
-	}
-	ctx := context.Background()
-	studies := studies(ctx, flags.Args(), databaseGetter(db, time.Time{}))
-	if len(studies) == 0 {
-		log.Fatal("no studies matched")
-	}
-
-	parseObjective := func(spec string) (objective diviner.Objective) {
-		switch {
-		case strings.HasPrefix(spec, "-"):
-			objective.Direction = diviner.Minimize
-			objective.Metric = spec[1:]
-		case strings.HasPrefix(spec, "+"):
-			objective.Direction = diviner.Maximize
-			objective.Metric = spec[1:]
-		default:
-			objective.Direction = diviner.Maximize
-			objective.Metric = spec
-		}
-		return
-	}
-
-	objective := studies[0].Objective
-	if *objectiveOverride == "" {
-		for i := range studies {
-			if i > 0 && studies[i].Objective != studies[i-1].Objective {
-				log.Fatalf("studies %s and %s do not share objectives: override with -objective",
-					studies[i].Name, studies[i-1].Name)
-			}
-		}
-	} else {
-		objective = parseObjective(*objectiveOverride)
-	}
-	type trial struct {
-		diviner.Trial
-		Study string
-	}
-	var (
-		trialsMu sync.Mutex
-		trials   []trial
-	)
-	err := traverser.Each(len(studies), func(i int) error {
-		if *expand {
-			runs, err := db.ListRuns(ctx, studies[i].Name, diviner.Success, time.Time{})
-			if err != nil && err != diviner.ErrNotExist {
-				return err
-			}
-			trialsMu.Lock()
-			for _, run := range runs {
-				trials = append(trials, trial{run.Trial(), studies[i].Name})
-			}
-			trialsMu.Unlock()
-			return nil
-		}
-		t, err := diviner.Trials(ctx, db, studies[i], diviner.Success)
-		if err != nil {
-			return err
-		}
-		trialsMu.Lock()
-		t.Range(func(_ diviner.Value, v interface{}) {
-			trials = append(trials, trial{v.(diviner.Trial), studies[i].Name})
-		})
-		trialsMu.Unlock()
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	var (
-		n       int
-		values  = make(map[string]bool)
-		metrics = make(map[string]bool)
-	)
-
-	for _, trial := range trials {
-		if _, ok := trial.Metrics[objective.Metric]; !ok {
-			continue
-		}
-		trials[n] = trial
-		n++
-		for name := range trial.Metrics {
-			metrics[name] = true
-
-		}
-		for name := range trial.Values {
-			values[name] = true
-		}
-	}
-	if n < len(trials) {
-		log.Printf("skipping %d trials due to missing metrics", len(trials)-n)
-		trials = trials[:n]
-	}
-	sort.SliceStable(trials, func(i, j int) bool {
-		iv, _ := trials[i].Metrics[objective.Metric]
-		jv, _ := trials[j].Metrics[objective.Metric]
-		switch objective.Direction {
-		case diviner.Maximize:
-			return jv < iv
-		case diviner.Minimize:
-			return iv < jv
-		default:
-			panic(objective)
-		}
-	})
-	if *numEntries > 0 && len(trials) > *numEntries {
-		trials = trials[:*numEntries]
-	}
-	delete(metrics, objective.Metric)
-
-	var metricsOrdered []diviner.Objective
-	for _, m := range strings.Split(*metricsRe, ",") {
-		obj := parseObjective(m)
-		re, err := regexp.Compile(obj.Metric)
-		if err != nil {
-			log.Fatal(err)
-		}
-		i := len(metricsOrdered)
-		for k := range metrics {
-			if !re.MatchString(k) {
-				continue
-			}
-			metricsOrdered = append(metricsOrdered, diviner.Objective{obj.Direction, k})
-			delete(metrics, k)
-		}
-		// We sort the expansions, but retain the order of the list of matches.
-		sort.Slice(metricsOrdered[i:len(metricsOrdered)], func(i0, i1 int) bool {
-			return metricsOrdered[i0-i].Metric < metricsOrdered[i1-i].Metric
-		})
-	}
-	var (
-		valuesOrdered = matchAndSort(values, *valuesRe)
-		tw            tabwriter.Writer
-	)
-	tw.Init(os.Stdout, 4, 4, 1, ' ', 0)
-	fmt.Fprintf(&tw, "study\treplicates\t%s", objective.Metric)
-	if len(metricsOrdered) > 0 {
-		for _, metric := range metricsOrdered {
-			fmt.Fprint(&tw, "\t"+metric.Metric)
-		}
-	}
-	// Remove values that are the same for every study.
-	// They are not interesting to display. But if we have
-	// just a single trial, then the values may be pertinent.
-	if len(trials) > 1 {
-		var n int
-	dedup:
-		for _, name := range valuesOrdered {
-			var value diviner.Value
-			for i, trial := range trials {
-				if i == 0 {
-					value = trial.Values[name]
-				} else if !trial.Values[name].Equal(value) {
-					valuesOrdered[n] = name
-					n++
-					continue dedup
-				}
-			}
-		}
-		valuesOrdered = valuesOrdered[:n]
-	}
-
-	if len(valuesOrdered) > 0 {
-		fmt.Fprint(&tw, "\t"+strings.Join(valuesOrdered, "\t"))
-	}
-	fmt.Fprintln(&tw)
-	for _, trial := range trials {
-		v := trial.Metrics[objective.Metric]
-		min, max := trial.Range(objective.Metric)
-		sort.Slice(trial.Runs, func(i, j int) bool { return trial.Runs[i].Seq < trial.Runs[j].Seq })
-		seqs := make([]string, len(trial.Runs))
-		for i := range seqs {
-			seqs[i] = fmt.Sprint(trial.Runs[i].Seq)
-		}
-
-		replicates := make([]string, 0, trial.Replicates.Count())
-		r := trial.Replicates
-		for idx, r := r.Next(); idx != -1; idx, r = r.Next() {
-			replicates = append(replicates, fmt.Sprint(idx))
-		}
-
-		fmt.Fprintf(&tw, "%s:%s\t%s\t", trial.Study, strings.Join(seqs, ","), strings.Join(replicates, ","))
-		if min == max {
-			fmt.Fprintf(&tw, "%.3g", v)
-		} else {
-			fmt.Fprintf(&tw, "%.3g [%.3g-%.3g]", v, min, max)
-		}
-		if len(metricsOrdered) > 0 {
-			metrics := make([]string, len(metricsOrdered))
-			for i, metric := range metricsOrdered {
-				if v, ok := trial.Metrics[metric.Metric]; ok {
-					min, max := trial.Range(metric.Metric)
-					log.Print(metric.Metric, ": ", min, max)
-					if min == max {
-						metrics[i] = fmt.Sprintf("%.3g", v)
-					} else {
-						metrics[i] = fmt.Sprintf("%.3g [%.3g-%.3g]", v, min, max)
-					}
-				} else {
-					metrics[i] = "NA"
-				}
-			}
-			fmt.Fprint(&tw, "\t"+strings.Join(metrics, "\t"))
-		}
-		if len(valuesOrdered) > 0 {
-			values := make([]string, len(valuesOrdered))
-			for i, name := range valuesOrdered {
-				if v, ok := trial.Values[name]; ok {
-					switch v.Kind() {
-					default:
-						values[i] = fmt.Sprint(v)
-					case diviner.Real:
-						values[i] = fmt.Sprintf("%.3g", v.Float())
-					case diviner.Str:
-						values[i] = fmt.Sprintf("%q", v.Str())
-					}
-				} else {
-					values[i] = "NA"
-				}
-			}
-			fmt.Fprint(&tw, "\t"+strings.Join(values, "\t"))
-		}
-		fmt.Fprintln(&tw)
-	}
-	tw.Flush()
-}
-
-func logs(db diviner.Database, args []string) {
-	var (
-		flags     = flag.NewFlagSet("logs", flag.ExitOnError)
-		sinceFlag = flags.String("since", "", "only show messages that have been generated since the provided date or duration")
-		follow    = flags.Bool("f", false, "follow the log: print updates as they are appended")
-	)
-	flags.Usage = func() {
-		fmt.Fprintf(os.Stderr, `usage: diviner logs [-f] run
-
-Logs writes a run's logs to standard output. If the -f flag is given,
-the logs is followed and updates are printed as they become available.
-`)
-		flags.PrintDefaults()
-		os.Exit(2)
-	}
-	if err := flags.Parse(args); err != nil {
-		log.Fatal(err)
-	}
-	if flags.NArg() != 1 {
-		flags.Usage()
-	}
-	study, seq := splitName(flags.Arg(0))
-	if seq == 0 {
-		log.Fatalf("invalid run name %s", flags.Arg(0))
-	}
-	var since time.Time
-	if *sinceFlag != "" {
-		var err error
-		if since, err = parseSince(*sinceFlag); err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			flags.Usage()
-		}
-	}
-	if _, err := io.Copy(os.Stdout, db.Log(study, seq, since, *follow)); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func databaseGetter(db diviner.Database, since time.Time) func(context.Context, string, bool) []diviner.Study {
-	return func(ctx context.Context, query string, isPrefix bool) []diviner.Study {
-		if !isPrefix {
-			study, err := db.LookupStudy(ctx, query)
-			if err != nil {
-				log.Fatal(err)
-			}
-			return []diviner.Study{study}
-		}
-		studies, err := db.ListStudies(ctx, query, since)
-		if err != nil {
-			log.Fatal(err)
-		}
-		sort.Slice(studies, func(i, j int) bool {
-			return studies[i].Name < studies[j].Name
-		})
-		return studies
-	}
-}
-
-func scriptGetter(filename string) func(context.Context, string, bool) []diviner.Study {
-	return func(_ context.Context, query string, isPrefix bool) []diviner.Study {
-		studies, err := script.Load(filename, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if !isPrefix {
-			return []diviner.Study{find(studies, query)}
-		}
-		var n int
-		for _, study := range studies {
-			if strings.HasPrefix(study.Name, query) {
-				studies[n] = study
-				n++
-			}
-		}
-		return studies[:n]
-	}
-}
-
-func studies(ctx context.Context, args []string, get func(context.Context, string, bool) []diviner.Study) []diviner.Study {
-	var studies []diviner.Study
-	for _, arg := range args {
-		re, err := regexp.Compile(`^` + arg + `$`)
-		if err != nil {
-			log.Fatal(err)
-		}
-		// TODO(marius): make point query if complete
-		prefix, complete := re.LiteralPrefix()
-		matched := get(ctx, prefix, !complete)
-		for _, study := range matched {
-			if re.MatchString(study.Name) {
-				studies = append(studies, study)
-			}
-		}
-	}
-	if len(studies) == 0 {
-		return nil
-	}
-	sort.SliceStable(studies, func(i, j int) bool { return studies[i].Name < studies[j].Name })
-	var n int
-	for i := range studies {
-		if studies[n].Name != studies[i].Name {
-			n++
-		}
-		studies[n] = studies[i]
-	}
-	return studies[:n+1]
-}
-
-func matchAndSort(keys map[string]bool, match string) []string {
-	sorted := make([]string, 0, len(keys))
-	for _, m := range strings.Split(match, ",") {
-		re, err := regexp.Compile(m)
-		if err != nil {
-			log.Fatal(err)
-		}
-		i := len(sorted)
-		for k := range keys {
-			if !re.MatchString(k) {
-				continue
-			}
-			sorted = append(sorted, k)
-			delete(keys, k)
-		}
-		// We sort the expansions, but retain the order of the list of matches.
-		sort.Strings(sorted[i:len(sorted)])
-	}
-	return sorted
-}
-
-func splitName(name string) (study string, seq uint64) {
-	parts := strings.SplitN(name, ":", 2)
-	switch len(parts) {
-	case 1:
-		return parts[0], 0
-	case 2:
-		if parts[1] == "" {
-			log.Fatalf("%s: empty run seq", name)
-		}
-		seq, err := strconv.ParseUint(parts[1], 10, 64)
-		if err != nil {
-			log.Fatalf("invalid name %s: %v", name, err)
-		}
-		return parts[0], seq
-	default:
-		panic(parts)
-	}
+	cli.Main()
 }
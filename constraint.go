@@ -0,0 +1,106 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A Constraint is a feasibility condition on a metric reported by a
+// study's runs, for example:
+//
+//	latency_ms < 50
+//
+// Trials whose metrics violate any of their study's constraints are
+// infeasible (see Trial.Infeasible).
+type Constraint struct {
+	// Metric names the constrained metric.
+	Metric string
+	// Op is the comparison by which the metric is constrained: one of
+	// "<", "<=", ">", or ">=".
+	Op string
+	// Value is the value to which the metric is compared.
+	Value float64
+}
+
+// constraintOps lists the comparison operators of constraints, longest
+// first so that they are matched greedily.
+var constraintOps = []string{"<=", ">=", "<", ">"}
+
+// constraintSuffixes are the multipliers of the suffixes that may
+// abbreviate the values of constraints, e.g., "10M".
+var constraintSuffixes = map[byte]float64{'k': 1e3, 'K': 1e3, 'M': 1e6, 'G': 1e9}
+
+// ParseConstraint parses a constraint from its textual representation:
+// a metric name, followed by a comparison operator and a number, as
+// in "latency_ms < 50". The number may be abbreviated with one of the
+// suffixes k (or K), M, and G, as in "params < 10M".
+func ParseConstraint(s string) (Constraint, error) {
+	for _, op := range constraintOps {
+		i := strings.Index(s, op)
+		if i < 0 {
+			continue
+		}
+		c := Constraint{Metric: strings.TrimSpace(s[:i]), Op: op}
+		if c.Metric == "" {
+			return Constraint{}, fmt.Errorf("invalid constraint %q: missing metric", s)
+		}
+		value := strings.TrimSpace(s[i+len(op):])
+		mult := 1.0
+		if n := len(value); n > 0 {
+			if m, ok := constraintSuffixes[value[n-1]]; ok {
+				mult, value = m, value[:n-1]
+			}
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) {
+			return Constraint{}, fmt.Errorf("invalid constraint %q: bad value", s)
+		}
+		c.Value = v * mult
+		return c, nil
+	}
+	return Constraint{}, fmt.Errorf("invalid constraint %q: must compare a metric with <, <=, >, or >=", s)
+}
+
+// String returns the textual representation of the constraint, as
+// parsed by ParseConstraint.
+func (c Constraint) String() string {
+	return fmt.Sprintf("%s %s %s", c.Metric, c.Op, strconv.FormatFloat(c.Value, 'g', -1, 64))
+}
+
+// Satisfied tells whether the provided metrics satisfy the constraint.
+// Metrics that do not report the constrained metric, or report it as
+// NaN, do not.
+func (c Constraint) Satisfied(metrics Metrics) bool {
+	v, ok := metrics[c.Metric]
+	if !ok || math.IsNaN(v) {
+		return false
+	}
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	}
+	return false
+}
+
+// Feasible tells whether the provided metrics satisfy all of the
+// study's constraints (Study.Constraints).
+func (s Study) Feasible(metrics Metrics) bool {
+	for _, c := range s.Constraints {
+		if !c.Satisfied(metrics) {
+			return false
+		}
+	}
+	return true
+}
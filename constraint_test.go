@@ -0,0 +1,132 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestParseConstraint(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want diviner.Constraint
+		str  string
+	}{
+		{"latency_ms < 50", diviner.Constraint{"latency_ms", "<", 50}, "latency_ms < 50"},
+		{"params<=10M", diviner.Constraint{"params", "<=", 10e6}, "params <= 1e+07"},
+		{"acc > 0.9", diviner.Constraint{"acc", ">", 0.9}, "acc > 0.9"},
+		{" size >= -1.5k ", diviner.Constraint{"size", ">=", -1500}, "size >= -1500"},
+	} {
+		c, err := diviner.ParseConstraint(test.s)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if got, want := c, test.want; got != want {
+			t.Errorf("%s: got %v, want %v", test.s, got, want)
+		}
+		if got, want := c.String(), test.str; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, err := diviner.ParseConstraint(c.String()); err != nil || got != c {
+			t.Errorf("%s: did not round-trip: %v, %v", c, got, err)
+		}
+	}
+	for _, s := range []string{"", "latency", "< 50", "latency < fast", "latency = 50", "latency < NaN"} {
+		if _, err := diviner.ParseConstraint(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestConstraintSatisfied(t *testing.T) {
+	c := diviner.Constraint{Metric: "latency", Op: "<=", Value: 50}
+	for _, test := range []struct {
+		metrics diviner.Metrics
+		want    bool
+	}{
+		{diviner.Metrics{"latency": 10}, true},
+		{diviner.Metrics{"latency": 50}, true},
+		{diviner.Metrics{"latency": 51}, false},
+		{diviner.Metrics{"latency": math.NaN()}, false},
+		{diviner.Metrics{"acc": 1}, false},
+	} {
+		if got, want := c.Satisfied(test.metrics), test.want; got != want {
+			t.Errorf("%v: got %v, want %v", test.metrics, got, want)
+		}
+	}
+}
+
+func TestStudyTrialInfeasible(t *testing.T) {
+	study := diviner.Study{
+		Constraints: []diviner.Constraint{{Metric: "latency", Op: "<", Value: 50}},
+	}
+	for _, test := range []struct {
+		run  diviner.Run
+		want bool
+	}{
+		{diviner.Run{State: diviner.Success, Metrics: []diviner.Metrics{{"latency": 10}}}, false},
+		{diviner.Run{State: diviner.Success, Metrics: []diviner.Metrics{{"latency": 100}}}, true},
+		{diviner.Run{State: diviner.Success, Metrics: []diviner.Metrics{{"acc": 1}}}, true},
+		// Pending trials are not (yet) infeasible.
+		{diviner.Run{State: diviner.Pending, Metrics: []diviner.Metrics{{"latency": 100}}}, false},
+	} {
+		if got, want := study.Trial(test.run).Infeasible, test.want; got != want {
+			t.Errorf("%v: got %v, want %v", test.run.Metrics, got, want)
+		}
+	}
+}
+
+func TestConstraintLeaderboard(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	study := diviner.Study{
+		Name:        "test",
+		Objective:   diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+		Constraints: []diviner.Constraint{{Metric: "latency", Op: "<", Value: 50}},
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+		t.Fatal(err)
+	}
+	// The most accurate run is too slow.
+	for i, metrics := range []diviner.Metrics{
+		{"acc": 0.9, "latency": 100},
+		{"acc": 0.8, "latency": 20},
+		{"acc": 0.7, "latency": 10},
+	} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(int64(i))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, metrics); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "done", 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trials, err := diviner.Leaderboard(ctx, db, "test", study.Objective, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(trials), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := trials[0].Metrics["acc"], 0.8; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	all, err := diviner.Trials(ctx, db, study, diviner.Success)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trial, ok := all.Get(diviner.Values{"x": diviner.Int(0)}); !ok || !trial.(diviner.Trial).Infeasible {
+		t.Errorf("trial not marked infeasible: %v", trial)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// A ConvergencePoint records the objective value reported by a
+// successful run of a study, together with the best value attained
+// by the study when the run completed.
+type ConvergencePoint struct {
+	// Seq is the sequence number of the run.
+	Seq uint64 `json:"seq"`
+	// Completed is the time at which the run completed.
+	Completed time.Time `json:"completed"`
+	// Runs is the number of the study's successful runs that had
+	// completed at the time, including this one.
+	Runs int `json:"runs"`
+	// Value is the objective value reported by the run.
+	Value float64 `json:"value"`
+	// Best is the best objective value attained so far.
+	Best float64 `json:"best"`
+	// Improved tells whether the run improved on the previous best
+	// value.
+	Improved bool `json:"improved"`
+}
+
+// Convergence is the time series of a study's best objective value so
+// far, computed from its successful runs in order of their
+// completion. Because each point records the number of runs that
+// preceded it, as well as its time, the convergence of studies that
+// explore the same space with different oracles may be compared run
+// for run.
+type Convergence struct {
+	// Started is the creation time of the study's first run.
+	Started time.Time `json:"started"`
+	// Runs is the number of the study's successful runs.
+	Runs int `json:"runs"`
+	// Points contains a point for each successful run that reported
+	// a (feasible) value of the objective, in order of completion.
+	Points []ConvergencePoint `json:"points"`
+}
+
+// NewConvergence computes the convergence of a study from the
+// provided runs with respect to the provided objective. Successful
+// runs that do not report the objective, whose value is NaN or
+// infinite, or whose metrics violate the study's constraints (see
+// Study.Feasible) are counted, but they never improve on the best
+// value, as in StopConditions.Check. Replicates are not averaged:
+// each run is considered separately.
+func NewConvergence(study Study, objective Objective, runs []Run) Convergence {
+	var (
+		c       Convergence
+		success []Run
+	)
+	for _, run := range runs {
+		if c.Started.IsZero() || run.Created.Before(c.Started) {
+			c.Started = run.Created
+		}
+		if run.State == Success {
+			success = append(success, run)
+		}
+	}
+	sort.SliceStable(success, func(i, j int) bool {
+		if !success[i].Completed.Equal(success[j].Completed) {
+			return success[i].Completed.Before(success[j].Completed)
+		}
+		return success[i].Seq < success[j].Seq
+	})
+	best := math.NaN()
+	for _, run := range success {
+		c.Runs++
+		metrics := run.Trial().Metrics
+		v, ok := metrics[objective.Metric]
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) || !study.Feasible(metrics) {
+			continue
+		}
+		point := ConvergencePoint{
+			Seq:       run.Seq,
+			Completed: run.Completed,
+			Runs:      c.Runs,
+			Value:     v,
+		}
+		if math.IsNaN(best) || better(objective, v, best) {
+			best = v
+			point.Improved = true
+		}
+		point.Best = best
+		c.Points = append(c.Points, point)
+	}
+	return c
+}
+
+// StudyConvergence computes the convergence of the named study with
+// respect to the provided objective, or the study's objective if the
+// provided objective's metric is empty.
+func StudyConvergence(ctx context.Context, db Database, study string, objective Objective) (Convergence, error) {
+	s, err := db.LookupStudy(ctx, study)
+	if err != nil {
+		return Convergence{}, err
+	}
+	if objective.Metric == "" {
+		objective = s.Objective
+	}
+	runs, err := db.ListRuns(ctx, study, Any, time.Time{})
+	if err != nil && err != ErrNotExist {
+		return Convergence{}, err
+	}
+	return NewConvergence(s, objective, runs), nil
+}
+
+// Best returns the point at which the study attained its best
+// objective value, and false if no run reported the objective.
+func (c Convergence) Best() (ConvergencePoint, bool) {
+	for i := len(c.Points) - 1; i >= 0; i-- {
+		if c.Points[i].Improved {
+			return c.Points[i], true
+		}
+	}
+	return ConvergencePoint{}, false
+}
+
+// Stalled returns the number of successful runs that have completed
+// since the study attained its best objective value: a study that has
+// stalled for many runs has likely converged. If no run reported the
+// objective, Stalled returns the number of successful runs.
+func (c Convergence) Stalled() int {
+	best, ok := c.Best()
+	if !ok {
+		return c.Runs
+	}
+	return c.Runs - best.Runs
+}
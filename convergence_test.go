@@ -0,0 +1,93 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestConvergence(t *testing.T) {
+	var (
+		now       = time.Now()
+		objective = diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+		runs      []diviner.Run
+	)
+	// Runs are listed out of order of completion; the fourth run does
+	// not report the objective.
+	for i, loss := range []float64{0.5, 0.3, 0.4, -1, 0.2, 0.6} {
+		run := diviner.Run{
+			Seq:       uint64(i + 1),
+			State:     diviner.Success,
+			Created:   now.Add(-time.Duration(10-i) * time.Hour),
+			Completed: now.Add(-time.Duration(9-i) * time.Hour),
+			Metrics:   []diviner.Metrics{{"loss": loss}},
+		}
+		if loss < 0 {
+			run.Metrics = []diviner.Metrics{{"acc": 0.5}}
+		}
+		runs = append([]diviner.Run{run}, runs...)
+	}
+	runs = append(runs, diviner.Run{Seq: 7, State: diviner.Failure, Created: now})
+	c := diviner.NewConvergence(diviner.Study{}, objective, runs)
+	if got, want := c.Started, now.Add(-10*time.Hour); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := c.Runs, 6; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var (
+		seqs     []uint64
+		counts   []int
+		best     []float64
+		improved []bool
+	)
+	for _, point := range c.Points {
+		seqs = append(seqs, point.Seq)
+		counts = append(counts, point.Runs)
+		best = append(best, point.Best)
+		improved = append(improved, point.Improved)
+	}
+	if got, want := seqs, []uint64{1, 2, 3, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := counts, []int{1, 2, 3, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := best, []float64{0.5, 0.3, 0.3, 0.2, 0.2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := improved, []bool{true, true, false, true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	point, ok := c.Best()
+	if !ok {
+		t.Fatal("no best point")
+	}
+	if got, want := point.Seq, uint64(5); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := c.Stalled(), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	c = diviner.NewConvergence(diviner.Study{}, diviner.Objective{Metric: "acc"}, runs)
+	if got, want := len(c.Points), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := c.Stalled(), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	c = diviner.NewConvergence(diviner.Study{}, diviner.Objective{Metric: "missing"}, runs)
+	if _, ok := c.Best(); ok {
+		t.Error("unexpected best point")
+	}
+	if got, want := c.Stalled(), 6; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grailbio/bigmachine/ec2system/instances"
+)
+
+// A MachineDescriber is a bigmachine system or a backend that can
+// describe the machines on which it performs runs, so that the
+// runs' costs can be estimated.
+type MachineDescriber interface {
+	// Machine returns the type of the system's machines (e.g., an
+	// EC2 instance type), and the region in which they run.
+	Machine() (machineType, region string)
+}
+
+// Pricing provides the hourly prices of machines, by which the costs
+// of runs are estimated.
+type Pricing interface {
+	// HourlyPrice returns the price, in dollars per hour, of a
+	// machine of the provided type in the provided region. It
+	// returns false if the price is not known.
+	HourlyPrice(machineType, region string) (price float64, ok bool)
+}
+
+// A PriceTable is a Pricing that maps machine types to their hourly
+// prices in each region. The price of a machine type in the region
+// "" applies to regions for which the table has no price.
+type PriceTable map[string]map[string]float64
+
+// HourlyPrice implements Pricing.
+func (t PriceTable) HourlyPrice(machineType, region string) (float64, bool) {
+	prices := t[machineType]
+	if price, ok := prices[region]; ok {
+		return price, true
+	}
+	price, ok := prices[""]
+	return price, ok
+}
+
+// EC2Pricing prices EC2 instance types by their on-demand prices, as
+// recorded in bigmachine's instance table. Costs of runs on spot
+// instances are thus overestimated.
+var EC2Pricing Pricing = ec2Pricing{}
+
+type ec2Pricing struct{}
+
+func (ec2Pricing) HourlyPrice(machineType, region string) (float64, bool) {
+	for _, typ := range instances.Types {
+		if typ.Name == machineType {
+			price, ok := typ.Price[region]
+			return price, ok
+		}
+	}
+	return 0, false
+}
+
+// RunCost records the machine on which a run was performed, and the
+// run's estimated cost.
+type RunCost struct {
+	// MachineType is the type of the machine on which the run was
+	// performed. It is empty if the run's system does not describe
+	// its machines (see MachineDescriber).
+	MachineType string `json:"machine_type,omitempty"`
+	// Region is the region of the machine on which the run was
+	// performed.
+	Region string `json:"region,omitempty"`
+	// Duration is the wall-clock time for which the run's script
+	// executed, summed over its tries.
+	Duration time.Duration `json:"duration"`
+	// HourlyPrice is the price, in dollars per hour, of the run's
+	// machine. It is zero if the price is unknown.
+	HourlyPrice float64 `json:"hourly_price,omitempty"`
+	// Dollars is the estimated cost of the run: its duration at the
+	// machine's hourly price.
+	Dollars float64 `json:"dollars"`
+}
+
+// Priced tells whether the run's cost was estimated; that is,
+// whether the price of its machine is known.
+func (c RunCost) Priced() bool {
+	return c.HourlyPrice > 0
+}
+
+// String returns a textual summary of the cost.
+func (c RunCost) String() string {
+	s := c.Duration.String()
+	if c.MachineType != "" {
+		s = fmt.Sprintf("%s on %s", s, c.MachineType)
+		if c.Region != "" {
+			s += " in " + c.Region
+		}
+	}
+	if !c.Priced() {
+		return s + ", cost unknown"
+	}
+	return fmt.Sprintf("%s, $%.2f", s, c.Dollars)
+}
+
+// EstimateCost returns the cost of a run that executed for duration,
+// on a machine of the provided type and region, priced by pricing.
+func EstimateCost(pricing Pricing, machineType, region string, duration time.Duration) RunCost {
+	cost := RunCost{MachineType: machineType, Region: region, Duration: duration}
+	if machineType == "" || pricing == nil {
+		return cost
+	}
+	if price, ok := pricing.HourlyPrice(machineType, region); ok && price > 0 {
+		cost.HourlyPrice = price
+		cost.Dollars = price * duration.Hours()
+	}
+	return cost
+}
+
+// A CostSummary aggregates the costs of a study's runs.
+type CostSummary struct {
+	// Study is the name of the study.
+	Study string `json:"study"`
+	// Runs is the number of the study's runs whose costs were
+	// recorded.
+	Runs int `json:"runs"`
+	// Unpriced is the number of those runs whose costs could not be
+	// estimated. Their durations are included in Duration, but not
+	// in Dollars.
+	Unpriced int `json:"unpriced"`
+	// Duration is the total machine time used by the runs.
+	Duration time.Duration `json:"duration"`
+	// Dollars is the total estimated cost of the runs.
+	Dollars float64 `json:"dollars"`
+}
+
+// Add adds the cost of a run to the summary.
+func (s *CostSummary) Add(cost RunCost) {
+	s.Runs++
+	if !cost.Priced() {
+		s.Unpriced++
+	}
+	s.Duration += cost.Duration
+	s.Dollars += cost.Dollars
+}
+
+// StudyCost returns the aggregate cost of the named study's runs, in
+// any state. Runs without recorded costs (e.g., pending runs) are
+// omitted.
+func StudyCost(ctx context.Context, db Database, study string) (CostSummary, error) {
+	summary := CostSummary{Study: study}
+	it := db.Scan(ctx, study, Any)
+	defer it.Close()
+	for it.Next() {
+		if cost := it.Run().Cost; cost != nil {
+			summary.Add(*cost)
+		}
+	}
+	return summary, it.Err()
+}
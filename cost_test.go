@@ -0,0 +1,63 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestPriceTable(t *testing.T) {
+	table := diviner.PriceTable{
+		"gpu": {"": 3, "us-east-1": 2.5},
+	}
+	for _, c := range []struct {
+		machineType, region string
+		price               float64
+		ok                  bool
+	}{
+		{"gpu", "us-east-1", 2.5, true},
+		{"gpu", "eu-west-1", 3, true},
+		{"cpu", "us-east-1", 0, false},
+	} {
+		price, ok := table.HourlyPrice(c.machineType, c.region)
+		if got, want := ok, c.ok; got != want {
+			t.Errorf("%s/%s: got %v, want %v", c.machineType, c.region, got, want)
+		}
+		if got, want := price, c.price; got != want {
+			t.Errorf("%s/%s: got %v, want %v", c.machineType, c.region, got, want)
+		}
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	table := diviner.PriceTable{"gpu": {"": 3}}
+	cost := diviner.EstimateCost(table, "gpu", "us-west-2", 90*time.Minute)
+	if got, want := cost.Dollars, 4.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := cost.String(), "1h30m0s on gpu in us-west-2, $4.50"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	unpriced := diviner.EstimateCost(table, "", "", time.Minute)
+	if unpriced.Priced() {
+		t.Errorf("run without a machine type was priced: %v", unpriced)
+	}
+	if got, want := unpriced.String(), "1m0s, cost unknown"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, ok := diviner.EC2Pricing.HourlyPrice("m5.large", "us-west-2"); !ok {
+		t.Error("m5.large is not priced")
+	}
+
+	var summary diviner.CostSummary
+	summary.Add(cost)
+	summary.Add(unpriced)
+	if got, want := summary, (diviner.CostSummary{Runs: 2, Unpriced: 1, Duration: 91 * time.Minute, Dollars: 4.5}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"time"
 )
 
@@ -23,9 +24,18 @@ const (
 	Success
 	// Failure indicates that the run failed.
 	Failure
+	// TimedOut indicates that the run was killed because it exceeded
+	// its timeout (see RunConfig.Timeout).
+	TimedOut
+	// Preempted indicates that the run was interrupted because the
+	// machine on which it ran was reclaimed by its provider: e.g., a
+	// spot instance was terminated (see System.Spot). Preempted runs
+	// are rescheduled as permitted by their retry policy's
+	// MaxPreemptions.
+	Preempted
 
 	// Any contains all run states.
-	Any = Pending | Success | Failure
+	Any = Pending | Success | Failure | TimedOut | Preempted
 )
 
 // String returns a simple textual representation of a run state.
@@ -39,6 +49,10 @@ func (s RunState) String() string {
 		return "success"
 	case Failure:
 		return "failure"
+	case TimedOut:
+		return "timedout"
+	case Preempted:
+		return "preempted"
 	default:
 		return "INVALID"
 	}
@@ -75,14 +89,56 @@ type Run struct {
 	// Updated is the last time the run's state was updated. Updated is
 	// used as a keepalive mechanism.
 	Updated time.Time
+	// Started is the time at which the run first started executing.
+	// It is zero if the run has not yet started.
+	Started time.Time
+	// Completed is the time at which the run completed, successfully
+	// or not. It is zero for pending runs.
+	Completed time.Time
 	// Runtime is the runtime duration of the run.
 	Runtime time.Duration
 
 	// Number of times the run was retried.
 	Retries int
 
+	// RetryOf is the sequence number of the original run of which
+	// this run is a retry, as performed by a RetryPolicy. It is zero
+	// for original runs.
+	RetryOf uint64
+	// Attempt is the attempt number of the run: 1 for original runs,
+	// 2 for their first retry, and so on. It is zero for runs created
+	// before attempts were recorded.
+	Attempt int
+	// Parent is the sequence number of the run from which this run
+	// was derived (see Lineage): the previous attempt of a retried
+	// run, or the run exploited by a run forked by an Exploiter. It
+	// is zero for runs that were not derived from another run.
+	Parent uint64
+
+	// Labels are the user-defined labels attached to the run. See
+	// Labels and Selector.
+	Labels Labels
+
+	// Artifacts is the manifest of the outputs registered by the run
+	// (see Artifact), in the order registered.
+	Artifacts []Artifact
+
+	// Exit describes how the run's script last exited. It is nil
+	// for runs that have not completed an attempt.
+	Exit *RunExit
+
+	// Cost records the machine on which the run was performed and
+	// its estimated cost. It is nil for runs that have not completed
+	// an attempt.
+	Cost *RunCost
+
+	// Source describes the code from which the run was produced (see
+	// CaptureSource). It is nil if the source was not captured.
+	Source *Source
+
 	// Metrics is the history of metrics, in the order reported by the
-	// run.
+	// run. Reports may be tagged with a training step; see StepMetric
+	// and History.
 	//
 	// TODO(marius): include timestamps for these, or some other
 	// reference (e.g., runtime).
@@ -94,7 +150,9 @@ func (r Run) ID() string {
 	return fmt.Sprintf("%s:%d", r.Study, r.Seq)
 }
 
-// Trial returns the Trial represented by this run.
+// Trial returns the Trial represented by this run. Its metrics are
+// the last reported by the run; Study.Trial aggregates them according
+// to the study's policies instead.
 //
 // TODO(marius): allow other metric selection policies
 // (e.g., minimize train and test loss difference)
@@ -107,10 +165,76 @@ func (r Run) Trial() Trial {
 	return trial
 }
 
+// StepMetric is the name of the reserved metric with which runs tag
+// intermediate metrics reports with the training step (e.g., epoch)
+// at which they were produced. For example, the line
+//
+//	METRICS: step=3,acc=0.7
+//
+// reports that the run's accuracy was 0.7 at step 3.
+const StepMetric = "step"
+
+// Step returns the step with which metrics m is tagged, if any.
+func (m Metrics) Step() (step int, ok bool) {
+	v, ok := m[StepMetric]
+	if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// A MetricsStep is a single metrics report in a run's metric history.
+type MetricsStep struct {
+	// Step is the step at which the metrics were reported. It is
+	// the step with which the report was tagged (see StepMetric),
+	// or else the index of the report in the run's history.
+	Step int
+	// Metrics are the reported metrics, excluding StepMetric.
+	Metrics Metrics
+}
+
+// History returns the run's metric history, ordered as reported.
+func (r Run) History() []MetricsStep {
+	history := make([]MetricsStep, len(r.Metrics))
+	for i, metrics := range r.Metrics {
+		history[i].Step = i
+		if step, ok := metrics.Step(); ok {
+			history[i].Step = step
+		}
+		history[i].Metrics = make(Metrics, len(metrics))
+		for k, v := range metrics {
+			if k != StepMetric {
+				history[i].Metrics[k] = v
+			}
+		}
+	}
+	return history
+}
+
+// MetricsHistory returns the full history of metrics reported by the
+// run with the provided study and sequence number, in the order in
+// which they were reported.
+func MetricsHistory(ctx context.Context, db Database, study string, seq uint64) ([]Metrics, error) {
+	run, err := db.LookupRun(ctx, study, seq)
+	if err != nil {
+		return nil, err
+	}
+	return run.Metrics, nil
+}
+
 // ErrNotExist is returned from a database when a study or run does not exist.
 var ErrNotExist = errors.New("study or run does not exist")
 
+// ErrArchived is returned from a database when the runs of an
+// archived study (see ArchiveStudy) are modified.
+var ErrArchived = errors.New("study is archived")
+
 // A Database is used to track and manage studies and runs.
+//
+// The runs of archived studies are immutable: databases fail
+// InsertRun, UpdateRun, AppendRunMetrics, SetRunLabels,
+// SetRunArtifacts, SetRunExit, SetRunCost, DeleteRun, DeleteRunLogs,
+// and EnqueueValues with ErrArchived for them.
 type Database interface {
 	// CreateTable creates the underlying database table.
 	CreateTable(context.Context) error
@@ -120,6 +244,11 @@ type Database interface {
 	CreateStudyIfNotExist(ctx context.Context, study Study) (created bool, err error)
 	// LookupStudy returns the study with the provided name.
 	LookupStudy(ctx context.Context, name string) (Study, error)
+	// UpdateStudy replaces the definition of the study with the
+	// provided study's name, e.g., to archive it or to change its
+	// retention policy. UpdateStudy returns ErrNotExist if the study
+	// does not exist.
+	UpdateStudy(ctx context.Context, study Study) error
 	// ListStudies returns the set of studies matching the provided prefix and whose
 	// last update time is not before the provided time.
 	ListStudies(ctx context.Context, prefix string, since time.Time) ([]Study, error)
@@ -128,7 +257,8 @@ type Database interface {
 	// provided study.
 	NextSeq(ctx context.Context, study string) (uint64, error)
 	// InsertRun inserts the provided run into a study. The run's study,
-	// values, and config must be populated; other fields are ignored.
+	// values, and config must be populated; its labels, if any, are
+	// stored with it; other fields are ignored.
 	// If the sequence number is provided (>0), then it is assumed to
 	// have been reserved by NextSeq. The run's study must already
 	// exist, and the returned Run is assigned a sequence number, state,
@@ -149,13 +279,124 @@ type Database interface {
 	// run states. ListRuns only returns runs that have been updated since the provided
 	// time.
 	ListRuns(ctx context.Context, study string, states RunState, since time.Time) ([]Run, error)
+	// Scan returns an iterator over the runs in the provided study
+	// matching the queried run states. Unlike ListRuns, Scan retrieves
+	// runs incrementally, so that only a bounded number of them are
+	// held in memory at a time. The iterator's Err returns ErrNotExist
+	// if the study does not exist.
+	Scan(ctx context.Context, study string, states RunState) RunIterator
+	// Query returns the runs in the provided study that satisfy the
+	// provided query. Databases that index runs' metrics use their
+	// indexes to avoid reading runs that do not satisfy the query's
+	// metric predicates.
+	Query(ctx context.Context, study string, query Query) ([]Run, error)
+	// BestRuns returns the k best successful runs in the provided
+	// study according to the provided objective, ordered as by
+	// SortRuns; runs that do not report the objective's metric are
+	// omitted. If k <= 0, all such runs are returned. Databases that
+	// index runs' metrics use their indexes to avoid reading other
+	// runs. See also Leaderboard.
+	BestRuns(ctx context.Context, study string, objective Objective, k int) ([]Run, error)
 	// LookupRun returns the run named by the provided study and sequence number.
 	LookupRun(ctx context.Context, study string, seq uint64) (Run, error)
+	// SetRunLabels replaces the labels of the run named by the
+	// provided study and sequence number. SetRunLabels returns
+	// ErrNotExist if the run does not exist. Runs are selected by
+	// their labels with SelectRuns.
+	SetRunLabels(ctx context.Context, study string, seq uint64, labels Labels) error
+	// SetRunArtifacts replaces the artifact manifest of the run named
+	// by the provided study and sequence number. SetRunArtifacts
+	// returns ErrNotExist if the run does not exist. Artifacts are
+	// usually registered with AddArtifact.
+	SetRunArtifacts(ctx context.Context, study string, seq uint64, artifacts []Artifact) error
+	// SetRunExit records how the script of the run named by the
+	// provided study and sequence number exited, replacing any exit
+	// previously recorded. SetRunExit returns ErrNotExist if the run
+	// does not exist.
+	SetRunExit(ctx context.Context, study string, seq uint64, exit RunExit) error
+	// SetRunCost records the cost of the run named by the provided
+	// study and sequence number, replacing any cost previously
+	// recorded. SetRunCost returns ErrNotExist if the run does not
+	// exist. The costs of a study's runs are aggregated by StudyCost.
+	SetRunCost(ctx context.Context, study string, seq uint64, cost RunCost) error
+
+	// DeleteRun deletes the run named by the provided study and
+	// sequence number, together with its metrics and logs. DeleteRun
+	// returns ErrNotExist if the run does not exist.
+	DeleteRun(ctx context.Context, study string, seq uint64) error
+	// DeleteRunLogs deletes the logs of the run named by the provided
+	// study and sequence number, retaining the run and its metrics.
+	// DeleteRunLogs returns ErrNotExist if the run does not exist.
+	DeleteRunLogs(ctx context.Context, study string, seq uint64) error
+	// DeleteStudy deletes the named study and all of its runs,
+	// including their metrics and logs. DeleteStudy returns
+	// ErrNotExist if the study does not exist.
+	DeleteStudy(ctx context.Context, name string) error
+
+	// LookupDataset returns the record of the named dataset's last
+	// completion. LookupDataset returns ErrNotExist if the dataset has
+	// not been completed, or if its record has been invalidated.
+	LookupDataset(ctx context.Context, name string) (DatasetRecord, error)
+	// SetDataset records the completion of a dataset, replacing any
+	// previous record of the same dataset.
+	SetDataset(ctx context.Context, record DatasetRecord) error
+	// InvalidateDataset deletes the record of the named dataset, so
+	// that it is produced again when it is next required.
+	// InvalidateDataset returns ErrNotExist if there is no such
+	// record.
+	InvalidateDataset(ctx context.Context, name string) error
+
+	// LookupOracleState returns the oracle state last saved for the
+	// named study by SetOracleState (see StatefulOracle).
+	// LookupOracleState returns ErrNotExist if the study does not exist
+	// or if no state has been saved for it.
+	LookupOracleState(ctx context.Context, study string) ([]byte, error)
+	// SetOracleState saves the state of the named study's oracle,
+	// replacing any state saved previously. The state is deleted
+	// along with the study. SetOracleState returns ErrNotExist if
+	// the study does not exist.
+	SetOracleState(ctx context.Context, study string, state []byte) error
+
+	// EnqueueValues appends the provided parameter values to the
+	// named study's queue of requested trials. Runners perform queued
+	// trials, in the order in which they were enqueued, before they
+	// consult the study's oracle (see Enqueue). The queue is deleted
+	// along with the study. EnqueueValues returns ErrNotExist if the
+	// study does not exist.
+	EnqueueValues(ctx context.Context, study string, values Values) error
+	// DequeueValues removes up to n values (all values, if n <= 0)
+	// from the front of the named study's queue and returns them, in
+	// the order in which they were enqueued. Each enqueued value is returned by at most
+	// one call to DequeueValues, even if several runners service the
+	// study concurrently. DequeueValues returns ErrNotExist if the
+	// study does not exist.
+	DequeueValues(ctx context.Context, study string, n int) ([]Values, error)
+	// QueuedValues returns the values in the named study's queue, in
+	// the order in which they were enqueued, without removing them.
+	// QueuedValues returns ErrNotExist if the study does not exist.
+	QueuedValues(ctx context.Context, study string) ([]Values, error)
+
+	// AcquireLease acquires, or renews, the named study's lease on
+	// behalf of the provided lease's owner (see Lease.Take). The
+	// acquisition is atomic: of the runners that compete for a study,
+	// at most one holds its lease at a time. AcquireLease returns the
+	// study's lease after the call, and whether it is held by the
+	// provided lease's owner; if another owner holds an unexpired
+	// lease, the study's lease is unchanged. The lease is deleted
+	// along with the study. AcquireLease returns ErrNotExist if the
+	// study does not exist.
+	AcquireLease(ctx context.Context, study string, lease Lease) (held Lease, acquired bool, err error)
+	// ReleaseLease releases the named study's lease if it is held by
+	// the provided owner, so that the study may be acquired by other
+	// runners before the lease expires; otherwise it is a no-op.
+	// ReleaseLease returns ErrNotExist if the study does not exist.
+	ReleaseLease(ctx context.Context, study, owner string) error
 
 	// Log obtains a reader for the logs emitted by the run named by the study and
 	// sequence number. If !since.IsZero(), show messages added at or after the
-	// given time. If follow is true, the returned reader is a perpetual stream,
-	// updated as new log entries are appended.
+	// given time. If follow is true, the returned reader is a stream that is
+	// updated as new log entries are appended; it ends (with io.EOF) when the
+	// run completes.
 	Log(study string, seq uint64, since time.Time, follow bool) io.Reader
 
 	// Logger returns an io.WriteCloser, to which log messages can be written,
@@ -163,15 +404,97 @@ type Database interface {
 	Logger(study string, seq uint64) io.WriteCloser
 }
 
+// A RunIterator iterates over a sequence of runs retrieved from a
+// database. Iterators are used as follows:
+//
+//	it := db.Scan(ctx, study, states)
+//	defer it.Close()
+//	for it.Next() {
+//		run := it.Run()
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		// ...
+//	}
+type RunIterator interface {
+	// Next advances the iterator to the next run. It returns false
+	// when there are no more runs, or when an error occurred.
+	Next() bool
+	// Run returns the current run.
+	Run() Run
+	// Err returns the error, if any, that ended the iteration.
+	Err() error
+	// Close releases the iterator's resources. Iterators that are
+	// abandoned before Next returns false must be closed.
+	Close() error
+}
+
+// NewRunIterator returns a RunIterator that retrieves runs in pages
+// from the provided function. Each call to next returns the next page
+// of runs, which may be empty; next returns io.EOF, possibly
+// together with a final page, when there are no more runs.
+func NewRunIterator(next func() ([]Run, error)) RunIterator {
+	return &pageIterator{next: next}
+}
+
+type pageIterator struct {
+	next func() ([]Run, error)
+	page []Run
+	run  Run
+	err  error
+	done bool
+}
+
+func (it *pageIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done {
+			return false
+		}
+		it.page, it.err = it.next()
+		if it.err != nil {
+			it.done = true
+			if it.err == io.EOF {
+				it.err = nil
+			} else {
+				it.page = nil
+			}
+		}
+	}
+	it.run, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *pageIterator) Run() Run { return it.run }
+
+func (it *pageIterator) Err() error { return it.err }
+
+func (it *pageIterator) Close() error {
+	it.page, it.done = nil, true
+	return nil
+}
+
+// ScanAll returns all of the runs produced by the provided iterator,
+// which is then closed.
+func ScanAll(it RunIterator) ([]Run, error) {
+	defer it.Close()
+	var runs []Run
+	for it.Next() {
+		runs = append(runs, it.Run())
+	}
+	return runs, it.Err()
+}
+
 // Trials queries the database db for all runs in the provided study,
 // and returns a set of composite trials for each replicate of a
 // value set. The returned map maps value sets to these composite
 // trials.
 //
-// Trial metrics are averaged across runs in the states as indicated
-// by the provided run states; flags are set on the returned trials
-// to indicate which replicates they comprise and whether any pending
-// results were used.
+// The metrics of each run are aggregated according to the study's
+// policies (see Study.Trial); trial metrics are then averaged across
+// runs in the states as indicated by the provided run states; flags
+// are set on the returned trials to indicate which replicates they
+// comprise, whether any pending results were used, and whether the
+// averaged metrics violate the study's constraints.
 //
 // TODO(marius): this is a reasonable approach for some metrics, but
 // not for others. We should provide a way for users to (e.g., as
@@ -189,13 +512,62 @@ func Trials(ctx context.Context, db Database, study Study, states RunState) (*Ma
 		if v, ok := replicates.Get(runs[i].Values); ok {
 			trials = v.([]Trial)
 		}
-		trials = append(trials, runs[i].Trial())
+		trials = append(trials, study.Trial(runs[i]))
 		replicates.Put(runs[i].Values, trials)
 	}
 	trials := NewMap()
 	replicates.Range(func(key Value, v interface{}) {
 		values := key.(Values)
-		trials.Put(&values, ReplicatedTrial(v.([]Trial)))
+		trial := ReplicatedTrial(v.([]Trial))
+		trial.Infeasible = !trial.Pending && !study.Feasible(trial.Metrics)
+		trials.Put(&values, trial)
 	})
 	return trials, nil
 }
+
+// TransferTrials returns the successful trials of the studies from
+// which the provided study transfers (Study.Transfer), in the order
+// in which they are listed. Only trials whose values are valid for
+// every one of the study's active parameters are transferred; the
+// values of other parameters are dropped. When several of the transferred
+// trials share values, only the first of them is returned.
+//
+// Oracles are given transferred trials in addition to the study's own,
+// and so treat them as if they were trials of the study.
+func TransferTrials(ctx context.Context, db Database, study Study) ([]Trial, error) {
+	var (
+		transferred []Trial
+		seen        = NewMap()
+	)
+	for _, name := range study.Transfer {
+		from, err := db.LookupStudy(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("transfer from study %s: %v", name, err)
+		}
+		trials, err := Trials(ctx, db, from, Success)
+		if err != nil {
+			return nil, fmt.Errorf("transfer from study %s: %v", name, err)
+		}
+		trials.Range(func(_ Value, v interface{}) {
+			trial := v.(Trial)
+			values := make(Values, len(study.Params))
+			for _, param := range study.Params.Ordered() {
+				if !IsActive(param.Param, values) {
+					continue
+				}
+				v, ok := trial.Values[param.Name]
+				if !ok || !param.IsValid(v) {
+					return
+				}
+				values[param.Name] = v
+			}
+			if _, ok := seen.Get(values); ok {
+				return
+			}
+			seen.Put(&values, true)
+			trial.Values = values
+			transferred = append(transferred, trial)
+		})
+	}
+	return transferred, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/grailbio/diviner/storage"
+)
+
+// A DatasetRecord records the completion of a dataset. Runners
+// consult a dataset's record before producing it: the dataset is
+// produced again only if its digest has changed since it was
+// recorded.
+type DatasetRecord struct {
+	// Name is the name of the dataset.
+	Name string
+	// Digest is the dataset's digest (see Dataset.Digest) at the time
+	// it was completed.
+	Digest string
+	// Completed is the time at which the dataset was completed.
+	Completed time.Time
+}
+
+// Digest returns a digest of the definition of the dataset: its
+// name, script, and output (IfNotExist) URL; the contents of its
+// local files; and the sizes and modification times of its inputs.
+// The dataset's systems do not contribute to its digest.
+func (d Dataset) Digest(ctx context.Context) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "name %q\nscript %q\nifnotexist %q\n", d.Name, d.Script, d.IfNotExist)
+	for _, path := range d.LocalFiles {
+		p, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("dataset %s: %v", d.Name, err)
+		}
+		fmt.Fprintf(h, "file %q %d\n", filepath.Base(path), len(p))
+		h.Write(p)
+	}
+	for _, url := range d.Inputs {
+		info, err := storage.Stat(ctx, url)
+		if err != nil {
+			return "", fmt.Errorf("dataset %s: input %s: %v", d.Name, url, err)
+		}
+		fmt.Fprintf(h, "input %q %d %d\n", url, info.Size, info.ModTime.UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
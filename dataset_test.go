@@ -0,0 +1,81 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/testutil"
+)
+
+func TestDatasetDigest(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	var (
+		local = filepath.Join(dir, "local")
+		input = filepath.Join(dir, "input")
+	)
+	for _, path := range []string{local, input} {
+		if err := ioutil.WriteFile(path, []byte("v1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dataset := diviner.Dataset{
+		Name:       "test",
+		Script:     "make dataset",
+		LocalFiles: []string{local},
+		Inputs:     []string{input},
+	}
+	digest := func(d diviner.Dataset) string {
+		t.Helper()
+		digest, err := d.Digest(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return digest
+	}
+	orig := digest(dataset)
+	if got, want := digest(dataset), orig; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	withSystems := dataset
+	withSystems.Systems = []*diviner.System{{ID: "local"}}
+	if got, want := digest(withSystems), orig; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	withScript := dataset
+	withScript.Script = "make dataset2"
+	if digest(withScript) == orig {
+		t.Error("digest did not change with script")
+	}
+
+	if err := ioutil.WriteFile(local, []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := digest(dataset)
+	if changed == orig {
+		t.Error("digest did not change with local file")
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if digest(dataset) == changed {
+		t.Error("digest did not change with input")
+	}
+
+	missing := dataset
+	missing.Inputs = []string{filepath.Join(dir, "missing")}
+	if _, err := missing.Digest(ctx); err == nil {
+		t.Error("expected error")
+	}
+}
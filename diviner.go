@@ -15,7 +15,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/bits"
+	"math/rand"
 	"sort"
 	"strings"
 	"time"
@@ -50,18 +52,107 @@ func (p Params) Sorted() []NamedParam {
 	return sorted
 }
 
+// Ordered returns the set of parameters sorted so that each
+// conditional parameter follows the parameter on which it depends,
+// and otherwise by name. Conditional parameters whose dependencies
+// are missing or cyclic are placed last.
+func (p Params) Ordered() []NamedParam {
+	var (
+		sorted  = p.Sorted()
+		ordered = make([]NamedParam, 0, len(sorted))
+		placed  = make(map[string]bool, len(sorted))
+	)
+	for len(ordered) < len(sorted) {
+		n := len(ordered)
+		for _, param := range sorted {
+			if placed[param.Name] {
+				continue
+			}
+			if c, ok := param.Param.(*Conditional); ok && !placed[c.On] {
+				continue
+			}
+			ordered = append(ordered, param)
+			placed[param.Name] = true
+		}
+		if len(ordered) == n {
+			for _, param := range sorted {
+				if !placed[param.Name] {
+					ordered = append(ordered, param)
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// Validate returns an error if the parameters' conditions are
+// invalid: each conditional parameter must depend on an existing
+// parameter, with values that are valid for that parameter, and
+// conditions may not be cyclic.
+func (p Params) Validate() error {
+	for _, param := range p.Sorted() {
+		c, ok := param.Param.(*Conditional)
+		if !ok {
+			continue
+		}
+		on, ok := p[c.On]
+		if !ok {
+			return fmt.Errorf("parameter %s depends on nonexistent parameter %s", param.Name, c.On)
+		}
+		for _, v := range c.In {
+			if !on.IsValid(v) {
+				return fmt.Errorf("parameter %s: value %s is not valid for parameter %s %s", param.Name, v, c.On, on)
+			}
+		}
+		seen := map[string]bool{param.Name: true}
+		for ok {
+			if seen[c.On] {
+				return fmt.Errorf("parameter %s has cyclic conditions", param.Name)
+			}
+			seen[c.On] = true
+			c, ok = p[c.On].(*Conditional)
+		}
+	}
+	return nil
+}
+
+// Sample draws a set of parameter values using the provided random
+// number generator. Parameters are sampled in the order given by
+// Ordered; conditional parameters that are inactive for the values
+// sampled so far are omitted.
+func (p Params) Sample(r *rand.Rand) Values {
+	values := make(Values, len(p))
+	for _, param := range p.Ordered() {
+		if IsActive(param.Param, values) {
+			values[param.Name] = param.Sample(r)
+		}
+	}
+	return values
+}
+
 // IsValid returns whether the given set of values are a valid assignment
-// of exactly the parameters in this Params.
+// of exactly the active parameters in this Params: every unconditional
+// parameter, and every conditional parameter whose condition holds,
+// must have a valid value; other conditional parameters must be
+// absent.
 func (p Params) IsValid(values Values) bool {
-	if len(p) != len(values) {
-		return false
-	}
-	for name, v := range values {
-		param, ok := p[name]
+	for name, param := range p {
+		v, ok := values[name]
+		if !IsActive(param, values) {
+			if ok {
+				return false
+			}
+			continue
+		}
 		if !ok || !param.IsValid(v) {
 			return false
 		}
 	}
+	for name := range values {
+		if _, ok := p[name]; !ok {
+			return false
+		}
+	}
 	return true
 }
 
@@ -181,6 +272,10 @@ type Trial struct {
 	// may have incomplete or non-final metrics.
 	Pending bool
 
+	// Infeasible indicates whether the (completed) trial's metrics
+	// violate its study's constraints (Study.Constraints).
+	Infeasible bool
+
 	// Replicates contains the set of completed replicates
 	// comprising this trial. Replicates are stored in a bitset.
 	Replicates Replicates
@@ -232,6 +327,47 @@ func (t Trial) Range(name string) (min, max float64) {
 	return
 }
 
+// Mean returns the mean of the provided metric over the trial's
+// replicates. This is the value of the metric in the trial's
+// (aggregate) metrics, which oracles optimize.
+func (t Trial) Mean(name string) float64 {
+	var (
+		sum float64
+		n   int
+	)
+	for _, metrics := range t.ReplicateMetrics {
+		if v, ok := metrics[name]; ok {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return t.Metrics[name]
+	}
+	return sum / float64(n)
+}
+
+// Stddev returns the sample standard deviation of the provided
+// metric over the trial's replicates. Stddev returns 0 for trials
+// with fewer than two replicates reporting the metric.
+func (t Trial) Stddev(name string) float64 {
+	var (
+		mean = t.Mean(name)
+		ss   float64
+		n    int
+	)
+	for _, metrics := range t.ReplicateMetrics {
+		if v, ok := metrics[name]; ok {
+			ss += (v - mean) * (v - mean)
+			n++
+		}
+	}
+	if n < 2 {
+		return 0
+	}
+	return math.Sqrt(ss / float64(n-1))
+}
+
 // ReplicatedTrials constructs a single trial from the provided
 // trials. The composite trial represents each replicate present in
 // the provided replicates. Metrics are averaged. The provided trials
@@ -349,16 +485,84 @@ type Oracle interface {
 	// with the oracle.
 }
 
+// A MultiOracle is an Oracle that optimizes multiple objectives
+// simultaneously. Runners call NextMulti for studies with more than
+// one objective; oracles that do not implement MultiOracle optimize
+// only a study's primary objective.
+type MultiOracle interface {
+	Oracle
+
+	// NextMulti returns the next n parameter values to run, as in
+	// Next, but optimizing for all of the provided objectives.
+	NextMulti(previous []Trial, params Params, objectives []Objective, n int) ([]Values, error)
+}
+
+// A StatefulOracle is an Oracle that maintains internal state across
+// calls to Next, beyond what can be derived from previous trials.
+// Runners save the oracle's state to the database (see
+// Database.SetOracleState) after each call to Next, and restore it
+// before a study's oracle is first used, so that a study's optimization
+// continues where it left off when it is resumed by a new process,
+// for example after a crash.
+type StatefulOracle interface {
+	Oracle
+
+	// SaveState returns an encoding of the oracle's internal state.
+	SaveState() ([]byte, error)
+	// LoadState restores the oracle's internal state from an
+	// encoding returned by SaveState.
+	LoadState(state []byte) error
+}
+
+// A Scheduler decides whether runs should be stopped early, based
+// on the intermediate metrics they report. Schedulers let studies
+// avoid spending machine time on trials that are unlikely to be
+// competitive.
+type Scheduler interface {
+	// Stop is called each time a run reports metrics, with the
+	// study's objective, the run's ID, and the run's metric history
+	// thus far. Stop returns true if the run should be stopped.
+	// Stopped runs complete successfully, with the metrics they
+	// reported before they were stopped. Stop may be called
+	// concurrently.
+	Stop(objective Objective, id string, history []MetricsStep) bool
+}
+
+// A CompletionObserver is informed of the metric histories of a
+// study's successfully completed runs. Schedulers that compare runs
+// with completed runs (e.g., the median stopping rule) implement
+// CompletionObserver.
+type CompletionObserver interface {
+	// Completed is called when a run completes successfully, with
+	// the study's objective, the run's ID, and the run's full metric
+	// history. Completed may be called concurrently.
+	Completed(objective Objective, id string, history []MetricsStep)
+}
+
 // A Dataset describes a preprocessing step that's required
 // by a run. It may be shared among multiple runs.
+//
+// Datasets are produced once for each version of their definition,
+// as identified by their digest (see Digest): runners record the
+// digests of the datasets they complete in the database, and produce
+// a dataset again only if its digest changes (e.g., because its
+// script or inputs changed), or if its record is invalidated (see
+// Database.InvalidateDataset).
 type Dataset struct {
-	// Name is a unique name describing the dataset. Uniqueness is
-	// required: diviner only runs one dataset for each named dataset,
+	// Name is a unique name describing the dataset. Runners process
+	// only one definition of each named dataset at a time: a changed
+	// definition is processed once the previous one is done.
 	Name string
 	// IfNotExist may contain a URL which is checked for existence
 	// before running the script that produces this dataset. It is
-	// assumed the dataset already exists if the URL exists.
+	// assumed the dataset already exists if the URL exists, unless
+	// the dataset was last completed with a different digest. URLs
+	// may name objects in any blob store supported by package
+	// storage (e.g., s3://, gs://, or az:// URLs).
 	IfNotExist string
+	// Inputs is a set of URLs of the dataset's inputs. Changes to
+	// their sizes or modification times invalidate the dataset.
+	Inputs []string
 	// LocalFiles is a set of files (local to where diviner is run)
 	// that should be made available in the script's environment.
 	// These files are copied into the script's working directory,
@@ -401,8 +605,14 @@ func (Dataset) Hash() (uint32, error) { return 0, errNotHashable }
 //
 // 	METRICS: acc=0.55,loss=12.3
 //
-// TODO(marius): make this mechanism more flexible and
-// less error prone.
+// Alternatively, black boxes may emit lines that begin with
+// "DIVINER_METRICS ", followed by a JSON object. Numbers in the object
+// are metrics; integers are recorded exactly, and the step
+// (see StepMetric), if present, must be an integer. String values,
+// which are not metrics, are recorded as the run's labels (see
+// Labels). For example:
+//
+// 	DIVINER_METRICS {"step":10,"acc":0.93,"checkpoint":"s3://bucket/ckpt10"}
 //
 // TODO(marius): allow interpreters other than Bash.
 type RunConfig struct {
@@ -410,6 +620,11 @@ type RunConfig struct {
 	// run.
 	Datasets []Dataset
 	// Script is a script that should be interpreted by Bash.
+	// Scripts run on bigmachine systems are given a cache directory
+	// in the environment variable DIVINER_CACHE. Unlike their working
+	// directories, the cache directory is retained by machines that
+	// are reused by later runs, which may thus reuse the files (e.g.,
+	// downloaded datasets) cached there.
 	Script string
 	// LocalFiles is a set of files (local to where diviner is run)
 	// that should be made available in the script's environment.
@@ -423,6 +638,63 @@ type RunConfig struct {
 	// systems requirements. If Len(Systems)>1, each is tried until one of them
 	// successfully allocates a machine.
 	Systems []*System
+
+	// Resources describes the resources required by the run. Systems
+	// whose machines do not provide them are reconfigured, if
+	// possible, or else skipped. By default, runs may be performed on
+	// any of their systems.
+	Resources Resources
+
+	// Retry is the policy used to retry failed runs. By default,
+	// failed runs are not retried.
+	Retry RetryPolicy
+
+	// Checkpoint, if non-zero, describes the file in which the run
+	// saves its state, so that interrupted runs may be resumed.
+	Checkpoint Checkpoint
+
+	// Timeout, if positive, is the maximum duration of the run's
+	// script. Runs that exceed their timeout are killed and marked
+	// TimedOut; they are retried only if the retry policy permits
+	// it. Defaults to the study's Timeout.
+	Timeout time.Duration
+
+	// Image, if non-empty, is the container image (e.g.,
+	// "pytorch/pytorch:1.4-cuda10.1-cudnn7-runtime") in which the
+	// run's script is executed, so that trials may use software that
+	// is not installed on their systems' machines. Workers run the
+	// script with Docker, pulling the image as needed; the image must
+	// provide Bash. The script's working directory is mounted in the
+	// container, and runs that require GPUs are given access to them.
+	// Backends that run containers use the image in place of their
+	// own.
+	Image string
+
+	// Env is a set of environment variables that are exported to the
+	// run's script, on every system and in its container, if any, so
+	// that parameter values may be given to scripts without
+	// substituting them into the script itself. Variables whose names
+	// begin with "DIVINER" are reserved. Like the rest of the config,
+	// the variables are recorded with the run.
+	Env map[string]string
+
+	// Seed is the seed for the run's random number generators, which
+	// is exported to its script in the environment variable
+	// DIVINER_SEED. If Seed is zero, the runner assigns a random seed
+	// when the run is created, so that each run, including each
+	// replicate, is seeded independently. A fixed (positive) seed may
+	// be given instead, e.g., derived from the replicate number, to
+	// make runs deterministic. Seeds are recorded with the run's
+	// config, so that its retries and reproductions reuse them. Seeds
+	// are less than 2^31, so that they are accepted by common
+	// libraries.
+	Seed int64
+
+	// LocalFileDigests maps each of the run's local files to the
+	// SHA-256 digest of its contents at the time the run was created,
+	// so that reproductions of the run can detect changed files. See
+	// RunConfig.ChangedLocalFiles.
+	LocalFileDigests map[string]string
 }
 
 // String returns a textual description of the run config.
@@ -452,6 +724,11 @@ type Study struct {
 	Name string
 	// Objective is the objective to be maximized.
 	Objective Objective
+	// Objectives, if non-empty, lists the objectives of a
+	// multi-objective study. Objective is then the first of these:
+	// it is used by oracles, schedulers, and tools that optimize
+	// only a single objective.
+	Objectives []Objective
 	// Params is the set of parameters accepted by this
 	// study.
 	Params Params
@@ -460,16 +737,72 @@ type Study struct {
 	// each trial in the study.
 	Replicates int
 
+	// Aggregate maps the names of metrics to the policies by which
+	// their reported values are aggregated into the metrics of the
+	// study's trials (see Study.Trial), and thus its objective. The
+	// last reported value of metrics without a policy is used.
+	Aggregate map[string]Aggregation
+
+	// Constraints are the feasibility conditions on the metrics of the
+	// study's trials. Trials that violate any of them are infeasible:
+	// they are excluded from the study's best trials, and oracles that
+	// are aware of constraints avoid them.
+	Constraints []Constraint
+
+	// Fidelity, if non-nil, is the study's budget knob (e.g., the
+	// number of training epochs), which multi-fidelity oracles set for
+	// each trial separately from its parameter values. See Fidelity.
+	Fidelity *Fidelity
+
 	// Human-readable description of the study.
 	Description string
 
+	// Transfer lists the names of previous studies whose successful
+	// trials are used to warm-start the study's oracle, so that it
+	// need not start from scratch. See TransferTrials.
+	Transfer []string
+
+	// Timeout, if positive, is the default timeout of the study's
+	// runs, used when their run configs do not specify one.
+	Timeout time.Duration
+
+	// MaxParallel, if positive, limits the number of the study's runs
+	// that a runner performs concurrently, independently of the
+	// parallelism of their systems. This keeps large studies from
+	// starving the other studies that share a runner.
+	MaxParallel int
+
+	// Stop are the conditions (e.g., a target objective value, or a
+	// budget of trials) under which the study is stopped before its
+	// oracle is exhausted.
+	Stop StopConditions
+
+	// Archived tells whether the study is archived (see ArchiveStudy).
+	// Archived studies are omitted from default listings, and their
+	// runs may no longer be modified.
+	Archived bool
+
+	// Retention is the study's retention policy, by which database
+	// maintenance (see Maintain) removes its old logs and runs.
+	Retention Retention
+
 	// Oracle is the oracle used to pick parameter values.
 	Oracle Oracle `json:"-"` // TODO(marius): encode oracle name/type/params?
 
+	// Scheduler, if non-nil, is consulted whenever a run reports
+	// metrics, and may stop runs early.
+	Scheduler Scheduler `json:"-"`
+
+	// Notifiers are notified of the study's events, e.g., when its
+	// runs fail, or when it finds a new best trial.
+	Notifiers []Notifier `json:"-"`
+
 	// Run is called with a set of Values (i.e., a concrete
 	// instantiation of values in the ranges as indicated by the black
 	// box parameters defined above); it produces a run configuration
 	// which is then used to conduct a trial of these parameter values.
+	// The values of studies with a Fidelity include the fidelity at
+	// which the trial is to be run.
 	// The run's replicate number is passed in. (This may be used to,
 	// e.g., select a model fold.) Parameter id is a unique id for the
 	// run (vis-a-vis diviner's database). It may be used to name data
@@ -487,6 +820,15 @@ func (s Study) String() string {
 	return fmt.Sprintf("study(name=%s, params=%s, objective=%s)", s.Name, s.Params, s.Objective)
 }
 
+// AllObjectives returns all of the study's objectives: Objectives,
+// if it is non-empty, or else the single Objective.
+func (s Study) AllObjectives() []Objective {
+	if len(s.Objectives) > 0 {
+		return s.Objectives
+	}
+	return []Objective{s.Objective}
+}
+
 // Type implements starlark.Value.
 func (Study) Type() string { return "study" }
 
@@ -5,6 +5,7 @@
 package diviner
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -61,6 +62,18 @@ func TestReplicatedTrial(t *testing.T) {
 	if rep.Pending {
 		t.Error("rep was pending")
 	}
+	if got, want := rep.Mean("x"), 1.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rep.Stddev("x"), math.Sqrt(0.5); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rep.Stddev("y"), 0.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := (Run{State: Success, Metrics: []Metrics{{"x": 1.0}}}).Trial().Stddev("x"), 0.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
 
 	var (
 		now  = time.Now()
@@ -89,3 +102,29 @@ func replicatedTrial(runs ...Run) Trial {
 	}
 	return ReplicatedTrial(trials)
 }
+
+func TestRunHistory(t *testing.T) {
+	run := Run{Metrics: []Metrics{
+		{"acc": 0.1},
+		{StepMetric: 5, "acc": 0.5},
+		{StepMetric: 10, "acc": 0.7, "loss": 0.2},
+	}}
+	history := run.History()
+	if got, want := len(history), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, step := range []int{0, 5, 10} {
+		if got, want := history[i].Step, step; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if _, ok := history[i].Metrics[StepMetric]; ok {
+			t.Errorf("step %d: step metric not removed", step)
+		}
+	}
+	if got, want := history[2].Metrics, (Metrics{"acc": 0.7, "loss": 0.2}); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := run.Metrics[0].Step(); ok {
+		t.Error("untagged metrics have step")
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"strconv"
 	"sync"
@@ -49,6 +51,11 @@ const (
 	scanSegments = 50
 
 	keepaliveIndexName = "date-keepalive-index"
+
+	// DatasetPrefix prefixes the (study) keys of the items that
+	// record completed datasets. Dataset items have no metadata, and
+	// thus are not mistaken for studies.
+	datasetPrefix = "dataset:"
 )
 
 // A DB represents a session to a DynamoDB table; it implements
@@ -75,8 +82,8 @@ func New(sess *session.Session, table string) *DB {
 // CreateStudyIfNotExist creates a new study if it does not already exist.
 // Existing studies are not updated.
 func (d *DB) CreateStudyIfNotExist(ctx context.Context, study diviner.Study) (created bool, err error) {
-	var b bytes.Buffer
-	if err := gob.NewEncoder(&b).Encode(study); err != nil {
+	meta, err := diviner.MarshalStudy(study)
+	if err != nil {
 		return false, err
 	}
 	input := &dynamodb.PutItemInput{
@@ -86,7 +93,7 @@ func (d *DB) CreateStudyIfNotExist(ctx context.Context, study diviner.Study) (cr
 			"study":       {S: aws.String(study.Name)},
 			"run":         {N: aws.String("0")},
 			"num_studies": {N: aws.String("0")},
-			"meta":        {B: b.Bytes()},
+			"meta":        {B: meta},
 		},
 		ExpressionAttributeNames: appendAttributeNames(nil, "study"),
 	}
@@ -119,8 +126,61 @@ func (d *DB) LookupStudy(ctx context.Context, name string) (study diviner.Study,
 	if meta := out.Item["meta"]; meta == nil || meta.B == nil {
 		return diviner.Study{}, diviner.ErrNotExist
 	}
-	err = gob.NewDecoder(bytes.NewReader(out.Item["meta"].B)).Decode(&study)
-	return
+	return diviner.UnmarshalStudy(out.Item["meta"].B)
+}
+
+// UpdateStudy replaces the metadata of an existing study. Whether the
+// study is archived is also stored in its own attribute, so that
+// writes to the study's runs can check it without retrieving its
+// metadata.
+func (d *DB) UpdateStudy(ctx context.Context, study diviner.Study) error {
+	meta, err := diviner.MarshalStudy(study)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study.Name, 0),
+		ConditionExpression:      aws.String(`attribute_exists(#meta)`),
+		UpdateExpression:         aws.String(`SET #meta = :meta, #archived = :archived`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "meta", "archived"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":meta":     {B: meta},
+			":archived": {BOOL: aws.Bool(study.Archived)},
+		},
+	}
+	_, err = d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	d.keepaliveStudy(ctx, study.Name)
+	return nil
+}
+
+// checkArchived returns ErrArchived if the named study is archived,
+// and ErrNotExist if it does not exist.
+func (d *DB) checkArchived(ctx context.Context, study string) error {
+	input := &dynamodb.GetItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, 0),
+		ProjectionExpression:     aws.String(`#study, #archived`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study", "archived"),
+	}
+	out, err := d.db.GetItemWithContext(ctx, input)
+	debug("dynamodb.GetItem", input, out, err)
+	if err != nil {
+		return err
+	}
+	if out.Item == nil {
+		return diviner.ErrNotExist
+	}
+	if archived := out.Item["archived"]; archived != nil && archived.BOOL != nil && *archived.BOOL {
+		return diviner.ErrArchived
+	}
+	return nil
 }
 
 // ListStudies returns the set of studies in the database that have the provided
@@ -205,6 +265,9 @@ func (d *DB) studies(ctx context.Context, prefix string, segment, totalSegments
 // InsertRun inserts a new run into the provided study. The returned run is
 // assigned a fresh sequence number and is returned with state Pending.
 func (d *DB) InsertRun(ctx context.Context, run diviner.Run) (diviner.Run, error) {
+	if err := d.checkArchived(ctx, run.Study); err != nil {
+		return diviner.Run{}, err
+	}
 	if run.Seq == 0 {
 		var err error
 		run.Seq, err = d.NextSeq(ctx, run.Study)
@@ -238,6 +301,9 @@ func (d *DB) InsertRun(ctx context.Context, run diviner.Run) (diviner.Run, error
 // UpdateRun updates the state, message, and runtime of the run named by the provided
 // study and sequence number.
 func (d *DB) UpdateRun(ctx context.Context, study string, seq uint64, state diviner.RunState, message string, runtime time.Duration, retry int) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
 	if message == "" {
 		// The dynamoDB API does not allow for empty string values.
 		message = "(none)"
@@ -257,6 +323,16 @@ func (d *DB) UpdateRun(ctx context.Context, study string, seq uint64, state divi
 		},
 		ExpressionAttributeNames: appendAttributeNames(nil, "status", "state", "runtime", "retries", "keepalive", "date"),
 	}
+	// Start and completion times are set only once.
+	if runtime > 0 {
+		*input.UpdateExpression += `, #started = if_not_exists(#started, :started)`
+		input.ExpressionAttributeValues[":started"] = &dynamodb.AttributeValue{S: aws.String(now.Add(-runtime).UTC().Format(timeLayout))}
+		input.ExpressionAttributeNames = appendAttributeNames(input.ExpressionAttributeNames, "started")
+	}
+	if state != diviner.Pending {
+		*input.UpdateExpression += `, #completed = if_not_exists(#completed, :timestamp)`
+		input.ExpressionAttributeNames = appendAttributeNames(input.ExpressionAttributeNames, "completed")
+	}
 	_, err := d.db.UpdateItemWithContext(ctx, input)
 	debug("dynamodb.UpdateItem", input, nil, err)
 	d.keepaliveStudy(ctx, study)
@@ -266,6 +342,9 @@ func (d *DB) UpdateRun(ctx context.Context, study string, seq uint64, state divi
 // AppendRunMetrics reports new run metrics for the run named by the provided
 // study and sequence number.
 func (d *DB) AppendRunMetrics(ctx context.Context, study string, seq uint64, metrics diviner.Metrics) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
 	input := &dynamodb.UpdateItemInput{
 		TableName:        aws.String(d.table),
 		Key:              key(study, seq),
@@ -334,6 +413,57 @@ func (d *DB) ListRuns(ctx context.Context, study string, states diviner.RunState
 	return
 }
 
+// scanPageSize is the maximum number of items that are queried for
+// each page of a scan.
+const scanPageSize = 100
+
+// Scan returns an iterator over the runs in the provided study that
+// match the queried run states. Runs are queried in pages of at most
+// scanPageSize items.
+func (d *DB) Scan(ctx context.Context, study string, states diviner.RunState) diviner.RunIterator {
+	var (
+		lastKey map[string]*dynamodb.AttributeValue
+		started bool
+	)
+	return diviner.NewRunIterator(func() ([]diviner.Run, error) {
+		if started && lastKey == nil {
+			return nil, io.EOF
+		}
+		started = true
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(d.table),
+			KeyConditionExpression: aws.String(`#study = :study AND #run > :zero`),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":study": {S: aws.String(study)},
+				":zero":  {N: aws.String("0")},
+			},
+			ExpressionAttributeNames: appendAttributeNames(nil, "study", "run"),
+			ExclusiveStartKey:        lastKey,
+			Limit:                    aws.Int64(scanPageSize),
+		}
+		out, err := d.db.QueryWithContext(ctx, input)
+		debug("dynamodb.Query", input, out, err)
+		if err != nil {
+			return nil, err
+		}
+		lastKey = out.LastEvaluatedKey
+		return d.appendRuns(nil, study, states, time.Time{}, out.Items...)
+	})
+}
+
+// Query returns the runs in the provided study that satisfy the
+// provided query. Queries are evaluated by scanning the study's runs.
+func (d *DB) Query(ctx context.Context, study string, query diviner.Query) ([]diviner.Run, error) {
+	return diviner.QueryRuns(ctx, d, study, query)
+}
+
+// BestRuns returns the k best successful runs in the provided study.
+// As runs' metrics are not indexed, all of the study's successful
+// runs are scanned.
+func (d *DB) BestRuns(ctx context.Context, study string, objective diviner.Objective, k int) ([]diviner.Run, error) {
+	return diviner.RankRuns(ctx, d, study, objective, k)
+}
+
 // LookupRun retruns the run named by the provided study and sequence number.
 func (d *DB) LookupRun(ctx context.Context, study string, seq uint64) (diviner.Run, error) {
 	input := &dynamodb.GetItemInput{
@@ -348,6 +478,563 @@ func (d *DB) LookupRun(ctx context.Context, study string, seq uint64) (diviner.R
 	return unmarshal(out.Item)
 }
 
+// SetRunLabels replaces the labels of the run named by the provided
+// study and sequence number. Labels are stored as a JSON-encoded
+// attribute, since DynamoDB does not permit the empty values of
+// value-less labels.
+func (d *DB) SetRunLabels(ctx context.Context, study string, seq uint64, labels diviner.Labels) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, seq),
+		ConditionExpression:      aws.String(`attribute_exists(#study)`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study", "labels"),
+	}
+	if len(labels) == 0 {
+		input.UpdateExpression = aws.String(`REMOVE #labels`)
+	} else {
+		p, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		input.UpdateExpression = aws.String(`SET #labels = :labels`)
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":labels": {B: p},
+		}
+	}
+	_, err := d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	}
+	return err
+}
+
+// SetRunArtifacts replaces the artifact manifest of the run named by
+// the provided study and sequence number. The manifest is stored as
+// a JSON-encoded attribute.
+func (d *DB) SetRunArtifacts(ctx context.Context, study string, seq uint64, artifacts []diviner.Artifact) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, seq),
+		ConditionExpression:      aws.String(`attribute_exists(#study)`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study", "artifacts"),
+	}
+	if len(artifacts) == 0 {
+		input.UpdateExpression = aws.String(`REMOVE #artifacts`)
+	} else {
+		p, err := json.Marshal(artifacts)
+		if err != nil {
+			return err
+		}
+		input.UpdateExpression = aws.String(`SET #artifacts = :artifacts`)
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":artifacts": {B: p},
+		}
+	}
+	_, err := d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	}
+	return err
+}
+
+// SetRunExit records how the script of the run named by the provided
+// study and sequence number exited. The exit is stored as a
+// JSON-encoded attribute.
+func (d *DB) SetRunExit(ctx context.Context, study string, seq uint64, exit diviner.RunExit) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
+	p, err := json.Marshal(exit)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, seq),
+		ConditionExpression:      aws.String(`attribute_exists(#study)`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study", "exit"),
+		UpdateExpression:         aws.String(`SET #exit = :exit`),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":exit": {B: p},
+		},
+	}
+	_, err = d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	}
+	return err
+}
+
+// SetRunCost records the cost of the run named by the provided study
+// and sequence number. The cost is stored as a JSON-encoded
+// attribute.
+func (d *DB) SetRunCost(ctx context.Context, study string, seq uint64, cost diviner.RunCost) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
+	p, err := json.Marshal(cost)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, seq),
+		ConditionExpression:      aws.String(`attribute_exists(#study)`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study", "cost"),
+		UpdateExpression:         aws.String(`SET #cost = :cost`),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cost": {B: p},
+		},
+	}
+	_, err = d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	}
+	return err
+}
+
+// LookupDataset implements diviner.Database.
+func (d *DB) LookupDataset(ctx context.Context, name string) (diviner.DatasetRecord, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(d.table),
+		Key:       key(datasetPrefix+name, 0),
+	}
+	out, err := d.db.GetItemWithContext(ctx, input)
+	debug("dynamodb.GetItem", input, out, err)
+	if err != nil {
+		return diviner.DatasetRecord{}, err
+	}
+	digest, completed := out.Item["digest"], out.Item["completed"]
+	if digest == nil || digest.S == nil || completed == nil || completed.S == nil {
+		return diviner.DatasetRecord{}, diviner.ErrNotExist
+	}
+	record := diviner.DatasetRecord{Name: name, Digest: *digest.S}
+	record.Completed, err = time.Parse(timeLayout, *completed.S)
+	return record, err
+}
+
+// SetDataset implements diviner.Database.
+func (d *DB) SetDataset(ctx context.Context, record diviner.DatasetRecord) error {
+	item := key(datasetPrefix+record.Name, 0)
+	item["digest"] = &dynamodb.AttributeValue{S: aws.String(record.Digest)}
+	item["completed"] = &dynamodb.AttributeValue{S: aws.String(record.Completed.UTC().Format(timeLayout))}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+	}
+	_, err := d.db.PutItemWithContext(ctx, input)
+	debug("dynamodb.PutItem", input, nil, err)
+	return err
+}
+
+// InvalidateDataset implements diviner.Database.
+func (d *DB) InvalidateDataset(ctx context.Context, name string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(datasetPrefix+name, 0),
+		ConditionExpression:      aws.String(`attribute_exists(#study)`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study"),
+	}
+	_, err := d.db.DeleteItemWithContext(ctx, input)
+	debug("dynamodb.DeleteItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	}
+	return err
+}
+
+// LookupOracleState implements diviner.Database. Oracle states are
+// stored in the study's (metadata) item.
+func (d *DB) LookupOracleState(ctx context.Context, study string) ([]byte, error) {
+	input := &dynamodb.GetItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, 0),
+		ProjectionExpression:     aws.String(`#oracle_state`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "oracle_state"),
+	}
+	out, err := d.db.GetItemWithContext(ctx, input)
+	debug("dynamodb.GetItem", input, out, err)
+	if err != nil {
+		return nil, err
+	}
+	state := out.Item["oracle_state"]
+	if state == nil || state.B == nil {
+		return nil, diviner.ErrNotExist
+	}
+	return state.B, nil
+}
+
+// SetOracleState implements diviner.Database.
+func (d *DB) SetOracleState(ctx context.Context, study string, state []byte) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, 0),
+		ConditionExpression:      aws.String(`attribute_exists(#meta)`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "meta", "oracle_state"),
+	}
+	if len(state) == 0 {
+		// DynamoDB does not store empty binary attributes.
+		input.UpdateExpression = aws.String(`REMOVE #oracle_state`)
+	} else {
+		input.UpdateExpression = aws.String(`SET #oracle_state = :state`)
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":state": {B: state},
+		}
+	}
+	_, err := d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	}
+	return err
+}
+
+// AcquireLease implements diviner.Database. Leases are stored in the
+// study's (metadata) item, and are replaced by conditional updates
+// that fail if the lease changed since it was read.
+func (d *DB) AcquireLease(ctx context.Context, study string, lease diviner.Lease) (diviner.Lease, bool, error) {
+	for {
+		prev, p, err := d.lease(ctx, study)
+		if err != nil {
+			return diviner.Lease{}, false, err
+		}
+		held, acquired := lease.Take(prev, time.Now())
+		if !acquired {
+			return held, false, nil
+		}
+		next, err := json.Marshal(held)
+		if err != nil {
+			return diviner.Lease{}, false, err
+		}
+		input := d.leaseUpdate(study, p, next)
+		_, err = d.db.UpdateItemWithContext(ctx, input)
+		debug("dynamodb.UpdateItem", input, nil, err)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+			// The lease changed, or the study was deleted, since it
+			// was read.
+			continue
+		} else if err != nil {
+			return diviner.Lease{}, false, err
+		}
+		return held, true, nil
+	}
+}
+
+// ReleaseLease implements diviner.Database.
+func (d *DB) ReleaseLease(ctx context.Context, study, owner string) error {
+	held, p, err := d.lease(ctx, study)
+	if err != nil || p == nil || held.Owner != owner {
+		return err
+	}
+	input := d.leaseUpdate(study, p, nil)
+	_, err = d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		// The lease was taken over by another owner.
+		return nil
+	}
+	return err
+}
+
+// LeaseUpdate returns the conditional update that replaces the named
+// study's lease, whose encoding is prev (nil if the study is not
+// leased), with the lease encoded by next, or removes it if next is
+// nil. DynamoDB rejects expressions that declare attribute names or
+// values that they do not use, so each update declares only its own.
+func (d *DB) leaseUpdate(study string, prev, next []byte) *dynamodb.UpdateItemInput {
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       key(study, 0),
+		ExpressionAttributeValues: make(map[string]*dynamodb.AttributeValue),
+	}
+	if prev == nil {
+		input.ConditionExpression = aws.String(`attribute_exists(#meta) AND attribute_not_exists(#lease)`)
+		input.ExpressionAttributeNames = appendAttributeNames(nil, "meta", "lease")
+	} else {
+		input.ConditionExpression = aws.String(`#lease = :prev`)
+		input.ExpressionAttributeNames = appendAttributeNames(nil, "lease")
+		input.ExpressionAttributeValues[":prev"] = &dynamodb.AttributeValue{B: prev}
+	}
+	if next == nil {
+		input.UpdateExpression = aws.String(`REMOVE #lease`)
+	} else {
+		input.UpdateExpression = aws.String(`SET #lease = :lease`)
+		input.ExpressionAttributeValues[":lease"] = &dynamodb.AttributeValue{B: next}
+	}
+	if len(input.ExpressionAttributeValues) == 0 {
+		input.ExpressionAttributeValues = nil
+	}
+	return input
+}
+
+// Lease returns the lease of the named study, together with its
+// encoding, which is nil if the study is not leased.
+func (d *DB) lease(ctx context.Context, study string) (lease diviner.Lease, p []byte, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, 0),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String(`#meta, #lease`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "meta", "lease"),
+	}
+	out, err := d.db.GetItemWithContext(ctx, input)
+	debug("dynamodb.GetItem", input, out, err)
+	if err != nil {
+		return diviner.Lease{}, nil, err
+	}
+	if out.Item["meta"] == nil {
+		return diviner.Lease{}, nil, diviner.ErrNotExist
+	}
+	if v := out.Item["lease"]; v != nil && v.B != nil {
+		p = v.B
+		err = json.Unmarshal(p, &lease)
+	}
+	return lease, p, err
+}
+
+// EnqueueValues implements diviner.Database. Queues are stored in the
+// study's (metadata) item, as a list of encoded values together with a
+// version that is incremented by each update to the queue.
+func (d *DB) EnqueueValues(ctx context.Context, study string, values diviner.Values) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
+	p, err := diviner.MarshalValues(values)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, 0),
+		ConditionExpression:      aws.String(`attribute_exists(#meta)`),
+		UpdateExpression:         aws.String(`SET #queue = list_append(if_not_exists(#queue, :empty), :values) ADD #queue_version :one`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "meta", "queue", "queue_version"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":empty":  {L: []*dynamodb.AttributeValue{}},
+			":values": {L: []*dynamodb.AttributeValue{{B: p}}},
+			":one":    {N: aws.String("1")},
+		},
+	}
+	_, err = d.db.UpdateItemWithContext(ctx, input)
+	debug("dynamodb.UpdateItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	}
+	return err
+}
+
+// DequeueValues implements diviner.Database. The queue is updated
+// only if its version is unchanged since it was read; otherwise the
+// dequeue is retried, so that concurrent dequeues do not return the
+// same values.
+func (d *DB) DequeueValues(ctx context.Context, study string, n int) ([]diviner.Values, error) {
+	for {
+		queue, version, err := d.queue(ctx, study)
+		if err != nil || len(queue) == 0 {
+			return nil, err
+		}
+		k := n
+		if k <= 0 || k > len(queue) {
+			k = len(queue)
+		}
+		input := &dynamodb.UpdateItemInput{
+			TableName:                aws.String(d.table),
+			Key:                      key(study, 0),
+			ConditionExpression:      aws.String(`#queue_version = :version`),
+			ExpressionAttributeNames: appendAttributeNames(nil, "queue", "queue_version"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":version": {N: version},
+				":one":     {N: aws.String("1")},
+			},
+		}
+		if k == len(queue) {
+			input.UpdateExpression = aws.String(`REMOVE #queue ADD #queue_version :one`)
+		} else {
+			input.UpdateExpression = aws.String(`SET #queue = :rest ADD #queue_version :one`)
+			input.ExpressionAttributeValues[":rest"] = &dynamodb.AttributeValue{L: queue[k:]}
+		}
+		_, err = d.db.UpdateItemWithContext(ctx, input)
+		debug("dynamodb.UpdateItem", input, nil, err)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return decodeQueue(queue[:k])
+	}
+}
+
+// QueuedValues implements diviner.Database.
+func (d *DB) QueuedValues(ctx context.Context, study string) ([]diviner.Values, error) {
+	queue, _, err := d.queue(ctx, study)
+	if err != nil {
+		return nil, err
+	}
+	return decodeQueue(queue)
+}
+
+// Queue returns the (encoded) queue of the named study, together with
+// its version.
+func (d *DB) queue(ctx context.Context, study string) (queue []*dynamodb.AttributeValue, version *string, err error) {
+	input := &dynamodb.GetItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, 0),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String(`#study, #queue, #queue_version`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study", "queue", "queue_version"),
+	}
+	out, err := d.db.GetItemWithContext(ctx, input)
+	debug("dynamodb.GetItem", input, out, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	if out.Item["study"] == nil {
+		return nil, nil, diviner.ErrNotExist
+	}
+	if v := out.Item["queue"]; v != nil {
+		queue = v.L
+	}
+	if v := out.Item["queue_version"]; v != nil {
+		version = v.N
+	}
+	return queue, version, nil
+}
+
+func decodeQueue(queue []*dynamodb.AttributeValue) ([]diviner.Values, error) {
+	values := make([]diviner.Values, len(queue))
+	for i, v := range queue {
+		var err error
+		if values[i], err = diviner.UnmarshalValues(v.B); err != nil {
+			return nil, errors.E("decode queued values", err)
+		}
+	}
+	return values, nil
+}
+
+// DeleteRun deletes the run named by the provided study and sequence
+// number. The run's metrics are stored with the run; its log stream
+// is deleted separately.
+func (d *DB) DeleteRun(ctx context.Context, study string, seq uint64) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
+	input := &dynamodb.DeleteItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, seq),
+		ConditionExpression:      aws.String(`attribute_exists(#study)`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study"),
+	}
+	_, err := d.db.DeleteItemWithContext(ctx, input)
+	debug("dynamodb.DeleteItem", input, nil, err)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return diviner.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	return d.deleteLogs(ctx, study, seq)
+}
+
+// DeleteRunLogs deletes the log stream of the run named by the
+// provided study and sequence number.
+func (d *DB) DeleteRunLogs(ctx context.Context, study string, seq uint64) error {
+	if err := d.checkArchived(ctx, study); err != nil {
+		return err
+	}
+	input := &dynamodb.GetItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      key(study, seq),
+		ProjectionExpression:     aws.String(`#study`),
+		ExpressionAttributeNames: appendAttributeNames(nil, "study"),
+	}
+	out, err := d.db.GetItemWithContext(ctx, input)
+	debug("dynamodb.GetItem", input, out, err)
+	if err != nil {
+		return err
+	}
+	if out.Item == nil {
+		return diviner.ErrNotExist
+	}
+	return d.deleteLogs(ctx, study, seq)
+}
+
+// DeleteStudy deletes the named study, all of its runs, and their
+// logs.
+func (d *DB) DeleteStudy(ctx context.Context, name string) error {
+	if _, err := d.LookupStudy(ctx, name); err != nil {
+		return err
+	}
+	// Delete the runs before the study item, so that an interrupted
+	// deletion may be resumed.
+	var (
+		seqs    []uint64
+		lastKey map[string]*dynamodb.AttributeValue
+	)
+	for {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(d.table),
+			KeyConditionExpression: aws.String(`#study = :study AND #run > :zero`),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":study": {S: aws.String(name)},
+				":zero":  {N: aws.String("0")},
+			},
+			ExpressionAttributeNames: appendAttributeNames(nil, "study", "run"),
+			ProjectionExpression:     aws.String(`#run`),
+			ExclusiveStartKey:        lastKey,
+		}
+		out, err := d.db.QueryWithContext(ctx, input)
+		debug("dynamodb.Query", input, out, err)
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			if item["run"] == nil || item["run"].N == nil {
+				continue
+			}
+			seq, err := strconv.ParseUint(*item["run"].N, 10, 64)
+			if err != nil {
+				return err
+			}
+			seqs = append(seqs, seq)
+		}
+		lastKey = out.LastEvaluatedKey
+		if lastKey == nil {
+			break
+		}
+	}
+	err := traverse.Limit(10).Each(len(seqs), func(i int) error {
+		input := &dynamodb.DeleteItemInput{
+			TableName: aws.String(d.table),
+			Key:       key(name, seqs[i]),
+		}
+		_, err := d.db.DeleteItemWithContext(ctx, input)
+		debug("dynamodb.DeleteItem", input, nil, err)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.deleteStudyLogs(ctx, name); err != nil {
+		return err
+	}
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       key(name, 0),
+	}
+	_, err = d.db.DeleteItemWithContext(ctx, input)
+	debug("dynamodb.DeleteItem", input, nil, err)
+	return err
+}
+
 // keepaliveStudy update's the study's update time. Concurrent calls for
 // a single study are coalesced.
 //
@@ -496,9 +1183,8 @@ func appendAttributeNames(attrs map[string]*string, newAttrs ...string) map[stri
 
 func appendStudies(studies []diviner.Study, items ...map[string]*dynamodb.AttributeValue) []diviner.Study {
 	for _, item := range items {
-		var study diviner.Study
-		p := item["meta"].B
-		if err := gob.NewDecoder(bytes.NewReader(p)).Decode(&study); err != nil {
+		study, err := diviner.UnmarshalStudy(item["meta"].B)
+		if err != nil {
 			log.Error.Printf("skipping invalid study %s: %v", aws.StringValue(item["study"].S), err)
 			continue
 		}
@@ -520,7 +1206,17 @@ type dynamodbRun struct {
 	Created   string            `dynamoattr:"timestamp"`
 	Runtime   string            `dynamoattr:"runtime"`
 	Keepalive string            `dynamoattr:"keepalive"`
+	Started   string            `dynamoattr:"started"`
+	Completed string            `dynamoattr:"completed"`
 	Retries   int               `dynamoattr:"retries"`
+	RetryOf   uint64            `dynamoattr:"retry_of"`
+	Attempt   int               `dynamoattr:"attempt"`
+	Parent    uint64            `dynamoattr:"parent"`
+	Labels    []byte            `dynamoattr:"labels"`
+	Artifacts []byte            `dynamoattr:"artifacts"`
+	Exit      []byte            `dynamoattr:"exit"`
+	Cost      []byte            `dynamoattr:"cost"`
+	Source    []byte            `dynamoattr:"source"`
 	Date      string            `dynamoattr:"date"`
 	Config    []byte            `dynamoattr:"config"`
 }
@@ -530,11 +1226,10 @@ func marshal(run diviner.Run) (map[string]*dynamodb.AttributeValue, error) {
 	dyrun.Study = run.Study
 	dyrun.Seq = run.Seq
 	dyrun.Replicate = run.Replicate
-	b := new(bytes.Buffer)
-	if err := gob.NewEncoder(b).Encode(run.Values); err != nil {
+	var err error
+	if dyrun.Values, err = diviner.MarshalValues(run.Values); err != nil {
 		return nil, err
 	}
-	dyrun.Values = b.Bytes()
 	dyrun.Metrics = run.Metrics
 	if dyrun.Metrics == nil {
 		dyrun.Metrics = []diviner.Metrics{}
@@ -545,8 +1240,36 @@ func marshal(run diviner.Run) (map[string]*dynamodb.AttributeValue, error) {
 	dyrun.Runtime = run.Runtime.String()
 	dyrun.Keepalive = run.Updated.UTC().Format(timeLayout)
 	dyrun.Retries = run.Retries
+	dyrun.RetryOf = run.RetryOf
+	dyrun.Attempt = run.Attempt
+	dyrun.Parent = run.Parent
+	if len(run.Labels) > 0 {
+		if dyrun.Labels, err = json.Marshal(run.Labels); err != nil {
+			return nil, err
+		}
+	}
+	if len(run.Artifacts) > 0 {
+		if dyrun.Artifacts, err = json.Marshal(run.Artifacts); err != nil {
+			return nil, err
+		}
+	}
+	if run.Exit != nil {
+		if dyrun.Exit, err = json.Marshal(run.Exit); err != nil {
+			return nil, err
+		}
+	}
+	if run.Cost != nil {
+		if dyrun.Cost, err = json.Marshal(run.Cost); err != nil {
+			return nil, err
+		}
+	}
+	if run.Source != nil {
+		if dyrun.Source, err = json.Marshal(run.Source); err != nil {
+			return nil, err
+		}
+	}
 	dyrun.Date = run.Updated.UTC().Format(dateLayout)
-	b = new(bytes.Buffer)
+	b := new(bytes.Buffer)
 	if err := gob.NewEncoder(b).Encode(run.Config); err != nil {
 		return nil, err
 	}
@@ -563,7 +1286,8 @@ func unmarshal(attrs map[string]*dynamodb.AttributeValue) (diviner.Run, error) {
 	run.Study = dyrun.Study
 	run.Seq = dyrun.Seq
 	run.Replicate = dyrun.Replicate
-	if err := gob.NewDecoder(bytes.NewReader(dyrun.Values)).Decode(&run.Values); err != nil {
+	var err error
+	if run.Values, err = diviner.UnmarshalValues(dyrun.Values); err != nil {
 		return diviner.Run{}, errors.E("decode values", err)
 	}
 	run.Metrics = dyrun.Metrics
@@ -574,17 +1298,30 @@ func unmarshal(attrs map[string]*dynamodb.AttributeValue) (diviner.Run, error) {
 		run.State = diviner.Success
 	case "failure":
 		run.State = diviner.Failure
+	case "timedout":
+		run.State = diviner.TimedOut
+	case "preempted":
+		run.State = diviner.Preempted
 	default:
 		return diviner.Run{}, fmt.Errorf("invalid run state %s", dyrun.State)
 	}
 	run.Status = dyrun.Status
-	var err error
 	if run.Created, err = time.Parse(timeLayout, dyrun.Created); err != nil {
 		return diviner.Run{}, err
 	}
 	if run.Updated, err = time.Parse(timeLayout, dyrun.Keepalive); err != nil {
 		return diviner.Run{}, err
 	}
+	if dyrun.Started != "" {
+		if run.Started, err = time.Parse(timeLayout, dyrun.Started); err != nil {
+			return diviner.Run{}, err
+		}
+	}
+	if dyrun.Completed != "" {
+		if run.Completed, err = time.Parse(timeLayout, dyrun.Completed); err != nil {
+			return diviner.Run{}, err
+		}
+	}
 	if run.State == diviner.Pending && time.Since(run.Updated) > 2*keepaliveInterval {
 		run.State = diviner.Failure
 	}
@@ -598,6 +1335,37 @@ func unmarshal(attrs map[string]*dynamodb.AttributeValue) (diviner.Run, error) {
 		run.Runtime = time.Since(run.Created)
 	}
 	run.Retries = dyrun.Retries
+	run.RetryOf = dyrun.RetryOf
+	run.Attempt = dyrun.Attempt
+	run.Parent = dyrun.Parent
+	if len(dyrun.Labels) > 0 {
+		if err := json.Unmarshal(dyrun.Labels, &run.Labels); err != nil {
+			return diviner.Run{}, errors.E("decode labels", err)
+		}
+	}
+	if len(dyrun.Artifacts) > 0 {
+		if err := json.Unmarshal(dyrun.Artifacts, &run.Artifacts); err != nil {
+			return diviner.Run{}, errors.E("decode artifacts", err)
+		}
+	}
+	if len(dyrun.Exit) > 0 {
+		run.Exit = new(diviner.RunExit)
+		if err := json.Unmarshal(dyrun.Exit, run.Exit); err != nil {
+			return diviner.Run{}, errors.E("decode exit", err)
+		}
+	}
+	if len(dyrun.Cost) > 0 {
+		run.Cost = new(diviner.RunCost)
+		if err := json.Unmarshal(dyrun.Cost, run.Cost); err != nil {
+			return diviner.Run{}, errors.E("decode cost", err)
+		}
+	}
+	if len(dyrun.Source) > 0 {
+		run.Source = new(diviner.Source)
+		if err := json.Unmarshal(dyrun.Source, run.Source); err != nil {
+			return diviner.Run{}, errors.E("decode source", err)
+		}
+	}
 
 	if err := gob.NewDecoder(bytes.NewReader(dyrun.Config)).Decode(&run.Config); err != nil {
 		return diviner.Run{}, errors.E("decode config", err)
@@ -0,0 +1,69 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package dydb
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var placeholder = regexp.MustCompile(`[#:][a-z_]+`)
+
+// CheckExpression checks that the update's expressions use exactly
+// the attribute names and values that it declares, as DynamoDB
+// requires.
+func checkExpression(t *testing.T, input *dynamodb.UpdateItemInput) {
+	t.Helper()
+	used := make(map[string]bool)
+	expr := aws.StringValue(input.ConditionExpression) + " " + aws.StringValue(input.UpdateExpression)
+	for _, p := range placeholder.FindAllString(expr, -1) {
+		used[p] = true
+	}
+	declared := make(map[string]bool)
+	for name := range input.ExpressionAttributeNames {
+		declared[name] = true
+	}
+	for value := range input.ExpressionAttributeValues {
+		declared[value] = true
+	}
+	for p := range used {
+		if !declared[p] {
+			t.Errorf("%s: %s is not declared", expr, p)
+		}
+	}
+	for p := range declared {
+		if !used[p] {
+			t.Errorf("%s: %s is declared but not used", expr, p)
+		}
+	}
+}
+
+func TestLeaseUpdate(t *testing.T) {
+	d := &DB{table: "test"}
+	for _, test := range []struct {
+		name       string
+		prev, next []byte
+		condition  string
+	}{
+		{"acquire", nil, []byte("next"), "attribute_not_exists"},
+		{"renew", []byte("prev"), []byte("next"), "= :prev"},
+		{"release", []byte("prev"), nil, "= :prev"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			input := d.leaseUpdate("study", test.prev, test.next)
+			checkExpression(t, input)
+			if cond := aws.StringValue(input.ConditionExpression); !strings.Contains(cond, test.condition) {
+				t.Errorf("bad condition %s", cond)
+			}
+			if got, want := input.ExpressionAttributeValues[":prev"] != nil, test.prev != nil; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
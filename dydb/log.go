@@ -6,6 +6,7 @@ package dydb
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -18,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/base/sync/once"
+	"github.com/grailbio/diviner"
 )
 
 // Initialization for each unique log group.
@@ -218,6 +220,9 @@ func (l *logger) sync() error {
 }
 
 type logReader struct {
+	db            *DB
+	study         string
+	seq           uint64
 	sess          *session.Session
 	group, stream string
 
@@ -225,22 +230,45 @@ type logReader struct {
 	follow    bool
 	save, buf []byte
 	nextToken *string
+	// final is set when the reader has observed the run's
+	// completion.
+	final bool
 }
 
 // Log returns an io.Reader that reads log messages from
-// the AWS CloudWatch Logs service.
+// the AWS CloudWatch Logs service. Followed logs end when the run
+// completes.
 func (d *DB) Log(study string, seq uint64, since time.Time, follow bool) io.Reader {
 	group, stream := d.streamKeys(study, seq)
-	return &logReader{sess: d.sess, group: group, stream: stream, since: since, follow: follow}
+	return &logReader{
+		db:     d,
+		study:  study,
+		seq:    seq,
+		sess:   d.sess,
+		group:  group,
+		stream: stream,
+		since:  since,
+		follow: follow,
+	}
 }
 
 func (r *logReader) Read(p []byte) (n int, err error) {
 	for len(r.buf) == 0 {
 		var err error
 		r.buf, err = r.append(r.save[:0])
-		if err == errEmptyReply {
-			if r.follow {
-				time.Sleep(5 * time.Second)
+		if err == errEmptyReply || (err == io.EOF && r.follow) {
+			if r.follow && !r.final {
+				run, lookupErr := r.db.LookupRun(context.Background(), r.study, r.seq)
+				if lookupErr != nil {
+					return 0, lookupErr
+				}
+				// Since the runner closes its logger before updating the
+				// run's final state, one more look after observing the
+				// run's completion finds any remaining entries.
+				r.final = run.State != diviner.Pending
+				if !r.final {
+					time.Sleep(5 * time.Second)
+				}
 				continue
 			}
 			err = io.EOF
@@ -294,3 +322,34 @@ func (d *DB) streamKeys(study string, seq uint64) (group, stream string) {
 	study = strings.Replace(study, "=", "_", -1)
 	return fmt.Sprintf("%s/%s", d.table, study), fmt.Sprint(seq)
 }
+
+// deleteLogs deletes the logs of the provided run.
+func (d *DB) deleteLogs(ctx context.Context, study string, seq uint64) error {
+	group, stream := d.streamKeys(study, seq)
+	input := &cloudwatchlogs.DeleteLogStreamInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+	}
+	_, err := cloudwatchlogs.New(d.sess).DeleteLogStreamWithContext(ctx, input)
+	debug("cloudwatchlogs.DeleteLogStream", input, nil, err)
+	return ignoreNotFound(err)
+}
+
+// deleteStudyLogs deletes the logs of all of the runs of the
+// provided study.
+func (d *DB) deleteStudyLogs(ctx context.Context, study string) error {
+	group, _ := d.streamKeys(study, 0)
+	input := &cloudwatchlogs.DeleteLogGroupInput{
+		LogGroupName: aws.String(group),
+	}
+	_, err := cloudwatchlogs.New(d.sess).DeleteLogGroupWithContext(ctx, input)
+	debug("cloudwatchlogs.DeleteLogGroup", input, nil, err)
+	return ignoreNotFound(err)
+}
+
+func ignoreNotFound(err error) error {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+		return nil
+	}
+	return err
+}
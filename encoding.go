@@ -0,0 +1,362 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Values and metrics are persisted by databases using a versioned,
+// self-describing binary encoding, so that they remain readable as
+// diviner's Go types evolve. Encoded data begin with encodingMagic,
+// followed by a version byte; the remainder is version-specific.
+// Data without the magic prefix are assumed to be legacy gob
+// encodings, and are decoded as such.
+//
+// Version 1 encodes a set of values as a count followed by sorted
+// (name, value) pairs. Each value is a kind byte followed by a
+// kind-specific payload: integers are zigzag varints; floats are
+// their IEEE 754 bits in little-endian order; strings are
+// length-prefixed; booleans are one byte; and lists and dicts are
+// counts followed by their elements. Metrics are encoded as a count
+// followed by sorted (name, float) pairs.
+//
+// Version 2 encodes run and study records as JSON documents; see
+// MarshalRun and MarshalStudy.
+//
+// New versions must be added (and not replace old ones) when the
+// encoding changes. Decoders return an error for versions newer than
+// they understand.
+
+// encodingMagic prefixes versioned encodings. Gob streams never begin
+// with a zero byte, so the magic cannot be confused with legacy data.
+var encodingMagic = []byte{0, 'd', 'v'}
+
+const (
+	encodingV1 = 1
+
+	// EncodingVersion is the version of the encoding produced by
+	// MarshalValues and MarshalMetrics.
+	EncodingVersion = encodingV1
+)
+
+const (
+	tagInt byte = iota + 1
+	tagFloat
+	tagString
+	tagBool
+	tagList
+	tagDict
+	tagDuration
+	tagTime
+)
+
+// errShortBuffer is returned when decoding truncated data.
+var errShortBuffer = errors.New("encoding: unexpected end of data")
+
+// MarshalValues returns the versioned encoding of the provided values.
+func MarshalValues(values Values) ([]byte, error) {
+	e := newEncoder()
+	if err := e.values(values); err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// UnmarshalValues decodes values encoded by MarshalValues, or by
+// legacy (gob-based) versions of diviner.
+func UnmarshalValues(p []byte) (Values, error) {
+	if !bytes.HasPrefix(p, encodingMagic) {
+		var values Values
+		if err := gob.NewDecoder(bytes.NewReader(p)).Decode(&values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+	d, err := newDecoder(p)
+	if err != nil {
+		return nil, err
+	}
+	return d.values()
+}
+
+// MarshalMetrics returns the versioned encoding of the provided metrics.
+func MarshalMetrics(metrics Metrics) ([]byte, error) {
+	e := newEncoder()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	e.uvarint(uint64(len(names)))
+	for _, name := range names {
+		e.string(name)
+		e.float(metrics[name])
+	}
+	return e.Bytes(), nil
+}
+
+// UnmarshalMetrics decodes metrics encoded by MarshalMetrics, or by
+// legacy (gob-based) versions of diviner.
+func UnmarshalMetrics(p []byte) (Metrics, error) {
+	if !bytes.HasPrefix(p, encodingMagic) {
+		var metrics Metrics
+		if err := gob.NewDecoder(bytes.NewReader(p)).Decode(&metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	}
+	d, err := newDecoder(p)
+	if err != nil {
+		return nil, err
+	}
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	metrics := make(Metrics, n)
+	for i := 0; i < n; i++ {
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if metrics[name], err = d.float(); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+type encoder struct {
+	bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newEncoder() *encoder {
+	e := new(encoder)
+	e.Write(encodingMagic)
+	e.WriteByte(EncodingVersion)
+	return e
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.Write(e.scratch[:n])
+}
+
+func (e *encoder) varint(v int64) {
+	n := binary.PutVarint(e.scratch[:], v)
+	e.Write(e.scratch[:n])
+}
+
+func (e *encoder) float(v float64) {
+	binary.LittleEndian.PutUint64(e.scratch[:8], math.Float64bits(v))
+	e.Write(e.scratch[:8])
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encoder) values(values Values) error {
+	e.uvarint(uint64(len(values)))
+	for _, v := range values.Sorted() {
+		e.string(v.Name)
+		if err := e.value(v.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) value(v Value) error {
+	switch v := v.(type) {
+	case Int:
+		e.WriteByte(tagInt)
+		e.varint(int64(v))
+	case Float:
+		e.WriteByte(tagFloat)
+		e.float(float64(v))
+	case String:
+		e.WriteByte(tagString)
+		e.string(string(v))
+	case Bool:
+		e.WriteByte(tagBool)
+		if v {
+			e.WriteByte(1)
+		} else {
+			e.WriteByte(0)
+		}
+	case Duration:
+		e.WriteByte(tagDuration)
+		e.varint(int64(v))
+	case Time:
+		e.WriteByte(tagTime)
+		e.varint(int64(v))
+	case List:
+		e.WriteByte(tagList)
+		e.uvarint(uint64(len(v)))
+		for _, elem := range v {
+			if err := e.value(elem); err != nil {
+				return err
+			}
+		}
+	case Values:
+		e.WriteByte(tagDict)
+		return e.values(v)
+	case *Values:
+		// For backward compatibility. Older diviner used *Value as well as Value.
+		e.WriteByte(tagDict)
+		return e.values(*v)
+	default:
+		return fmt.Errorf("encoding: cannot encode value %v of type %T", v, v)
+	}
+	return nil
+}
+
+type decoder struct {
+	p []byte
+}
+
+func newDecoder(p []byte) (*decoder, error) {
+	p = p[len(encodingMagic):]
+	if len(p) == 0 {
+		return nil, errShortBuffer
+	}
+	if version := p[0]; version != encodingV1 {
+		return nil, fmt.Errorf("encoding: unsupported version %d (this diviner supports up to version %d)", version, EncodingVersion)
+	}
+	return &decoder{p[1:]}, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	if len(d.p) == 0 {
+		return 0, errShortBuffer
+	}
+	b := d.p[0]
+	d.p = d.p[1:]
+	return b, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.p)
+	if n <= 0 {
+		return 0, errShortBuffer
+	}
+	d.p = d.p[n:]
+	return v, nil
+}
+
+func (d *decoder) varint() (int64, error) {
+	v, n := binary.Varint(d.p)
+	if n <= 0 {
+		return 0, errShortBuffer
+	}
+	d.p = d.p[n:]
+	return v, nil
+}
+
+// count decodes a length, checking that it is plausible given the
+// remaining data; every element requires at least one byte.
+func (d *decoder) count() (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.p)) {
+		return 0, errShortBuffer
+	}
+	return int(n), nil
+}
+
+func (d *decoder) float() (float64, error) {
+	if len(d.p) < 8 {
+		return 0, errShortBuffer
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.p))
+	d.p = d.p[8:]
+	return v, nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(len(d.p)) {
+		return "", errShortBuffer
+	}
+	s := string(d.p[:n])
+	d.p = d.p[n:]
+	return s, nil
+}
+
+func (d *decoder) values() (Values, error) {
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	values := make(Values, n)
+	for i := 0; i < n; i++ {
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if values[name], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (d *decoder) value() (Value, error) {
+	tag, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagInt:
+		v, err := d.varint()
+		return Int(v), err
+	case tagFloat:
+		v, err := d.float()
+		return Float(v), err
+	case tagString:
+		v, err := d.string()
+		return String(v), err
+	case tagBool:
+		v, err := d.byte()
+		return Bool(v != 0), err
+	case tagDuration:
+		v, err := d.varint()
+		return Duration(v), err
+	case tagTime:
+		v, err := d.varint()
+		return Time(v), err
+	case tagList:
+		n, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+		list := make(List, n)
+		for i := range list {
+			if list[i], err = d.value(); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case tagDict:
+		return d.values()
+	default:
+		return nil, fmt.Errorf("encoding: invalid value tag %d", tag)
+	}
+}
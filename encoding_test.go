@@ -0,0 +1,98 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+var testValues = diviner.Values{
+	"int":    diviner.Int(-123),
+	"float":  diviner.Float(0.25),
+	"string": diviner.String("hello"),
+	"bool":   diviner.Bool(true),
+	"list":   diviner.List{diviner.Int(1), diviner.String("x")},
+	"dict":   diviner.Values{"nested": diviner.Float(1.5)},
+	"period": diviner.Duration(90 * time.Minute),
+	"start":  diviner.NewTime(time.Date(2019, 6, 1, 12, 0, 0, 500, time.UTC)),
+}
+
+func TestEncodeValues(t *testing.T) {
+	p, err := diviner.MarshalValues(testValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := diviner.UnmarshalValues(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !values.Equal(testValues) {
+		t.Errorf("got %v, want %v", values, testValues)
+	}
+	for i := range p {
+		if _, err := diviner.UnmarshalValues(p[:i]); err == nil {
+			t.Errorf("%d: expected error on truncated data", i)
+		}
+	}
+}
+
+func TestEncodeMetrics(t *testing.T) {
+	metrics := diviner.Metrics{"acc": 0.9, "loss": 0.1}
+	p, err := diviner.MarshalMetrics(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := diviner.UnmarshalMetrics(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, metrics) {
+		t.Errorf("got %v, want %v", decoded, metrics)
+	}
+}
+
+func TestEncodeLegacy(t *testing.T) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(testValues); err != nil {
+		t.Fatal(err)
+	}
+	values, err := diviner.UnmarshalValues(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !values.Equal(testValues) {
+		t.Errorf("got %v, want %v", values, testValues)
+	}
+
+	metrics := diviner.Metrics{"acc": 0.5}
+	b.Reset()
+	if err := gob.NewEncoder(&b).Encode(metrics); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := diviner.UnmarshalMetrics(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, metrics) {
+		t.Errorf("got %v, want %v", decoded, metrics)
+	}
+}
+
+func TestEncodeFutureVersion(t *testing.T) {
+	p, err := diviner.MarshalValues(testValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p[3] = diviner.EncodingVersion + 1
+	if _, err := diviner.UnmarshalValues(p); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventKind is the kind of an Event. Event kinds are bit flags, so
+// that a set of kinds may be represented by a single EventKind.
+type EventKind int
+
+const (
+	// RunStarted indicates that a run has started.
+	RunStarted EventKind = 1 << iota
+	// RunFailed indicates that a run has failed or timed out.
+	RunFailed
+	// RunCompleted indicates that a run has completed successfully.
+	RunCompleted
+	// StudyFinished indicates that a study has finished: its oracle
+	// is exhausted, and all of its runs have completed.
+	StudyFinished
+	// NewBest indicates that a run has completed with the best
+	// objective value seen so far in its study.
+	NewBest
+
+	// AllEvents contains all event kinds.
+	AllEvents = RunStarted | RunFailed | RunCompleted | StudyFinished | NewBest
+)
+
+var eventNames = []struct {
+	kind EventKind
+	name string
+}{
+	{RunStarted, "run_started"},
+	{RunFailed, "run_failed"},
+	{RunCompleted, "run_completed"},
+	{StudyFinished, "study_finished"},
+	{NewBest, "new_best"},
+}
+
+// ParseEventKind returns the event kind with the provided name
+// (e.g., "run_failed"; see EventKind.String).
+func ParseEventKind(name string) (EventKind, error) {
+	for _, e := range eventNames {
+		if e.name == name {
+			return e.kind, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event kind %q", name)
+}
+
+// String returns the names of the event kinds in k, separated by
+// "|".
+func (k EventKind) String() string {
+	var names []string
+	for _, e := range eventNames {
+		if k&e.kind != 0 {
+			names = append(names, e.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Contains tells whether the set of event kinds k contains kind. The
+// zero EventKind contains all kinds.
+func (k EventKind) Contains(kind EventKind) bool {
+	return k == 0 || k&kind == kind
+}
+
+// An Event describes a change in the state of a study or one of its
+// runs. Events are delivered to the study's notifiers.
+type Event struct {
+	// Kind is the kind of the event.
+	Kind EventKind
+	// Study is the name of the study.
+	Study string
+	// Objective is the study's objective.
+	Objective Objective
+	// Run is the run to which the event pertains. It is the zero Run
+	// for study events.
+	Run Run
+	// Time is the time at which the event occurred.
+	Time time.Time
+	// Message is a human-readable description of the event.
+	Message string
+}
+
+// String returns a one-line textual description of the event.
+func (e Event) String() string {
+	if e.Run.Seq == 0 {
+		return fmt.Sprintf("study %s: %s", e.Study, e.Message)
+	}
+	return fmt.Sprintf("run %s: %s", e.Run.ID(), e.Message)
+}
+
+// A Notifier is notified of the events of a study. Studies configure
+// their notifiers (see Study.Notifiers) to, e.g., post messages to a
+// chat channel or a webhook. Errors returned by Notify are logged,
+// and do not affect the study. Notify may be called concurrently.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// StderrTag is the byte that prefixes lines of a run's log that were
+// written by its script to standard error. Lines written to standard
+// output, and messages from diviner itself, are untagged. Use
+// FilterLog to select a stream and remove the tags.
+const StderrTag = '\x02'
+
+// MaxExitStderr is the maximum number of trailing lines of a script's
+// standard error that are retained in a RunExit.
+const MaxExitStderr = 20
+
+// RunExit describes how a run's script exited, so that failures can
+// be triaged without reading the run's log.
+type RunExit struct {
+	// Code is the exit code of the run's script. It is -1 if the code
+	// is unknown: e.g., because the script was terminated by a
+	// signal, or because the run's backend does not report exit
+	// codes.
+	Code int `json:"code"`
+	// Signal is the name of the signal that terminated the script,
+	// if any.
+	Signal string `json:"signal,omitempty"`
+	// Reason describes why the run failed. It is empty for
+	// successful runs.
+	Reason string `json:"reason,omitempty"`
+	// Stderr contains the last lines (up to MaxExitStderr) that the
+	// script wrote to standard error.
+	Stderr []string `json:"stderr,omitempty"`
+}
+
+// String returns a textual summary of the exit.
+func (e RunExit) String() string {
+	var s string
+	switch {
+	case e.Signal != "":
+		s = "terminated by signal " + e.Signal
+	case e.Code < 0:
+		s = "exit code unknown"
+	default:
+		s = fmt.Sprintf("exit code %d", e.Code)
+	}
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// LogStream selects the output streams of a run's log.
+type LogStream int
+
+const (
+	// Stdout selects the script's standard output, together with
+	// messages from diviner.
+	Stdout LogStream = 1 << iota
+	// Stderr selects the script's standard error.
+	Stderr
+	// AllStreams selects all of the run's output.
+	AllStreams = Stdout | Stderr
+)
+
+// ParseLogStream parses a log stream from one of "stdout", "stderr",
+// or "all".
+func ParseLogStream(s string) (LogStream, error) {
+	switch s {
+	case "stdout":
+		return Stdout, nil
+	case "stderr":
+		return Stderr, nil
+	case "all", "":
+		return AllStreams, nil
+	}
+	return 0, fmt.Errorf("invalid log stream %q: must be stdout, stderr, or all", s)
+}
+
+// String returns the name of the log stream.
+func (s LogStream) String() string {
+	switch s {
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	case AllStreams:
+		return "all"
+	}
+	return fmt.Sprintf("LogStream(%d)", int(s))
+}
+
+// FilterLog returns a reader of the lines of the run log r that
+// belong to the provided streams, with their stream tags removed.
+func FilterLog(r io.Reader, streams LogStream) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		var (
+			in  = bufio.NewReader(r)
+			out = bufio.NewWriter(pw)
+			err error
+		)
+		for err == nil {
+			var line string
+			line, err = in.ReadString('\n')
+			if line == "" {
+				continue
+			}
+			stream := Stdout
+			if line[0] == StderrTag {
+				stream, line = Stderr, line[1:]
+			}
+			if streams&stream == 0 {
+				continue
+			}
+			if _, werr := out.WriteString(line); werr != nil {
+				err = werr
+				break
+			}
+			// Flush complete lines as they are read so that followed
+			// logs are streamed.
+			if in.Buffered() == 0 {
+				if ferr := out.Flush(); ferr != nil {
+					err = ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			err = out.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
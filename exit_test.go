@@ -0,0 +1,49 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestFilterLog(t *testing.T) {
+	const log = "diviner: started\n\x02+ echo hello\nhello\n\x02warning\nbye"
+	for _, test := range []struct {
+		streams diviner.LogStream
+		want    string
+	}{
+		{diviner.Stdout, "diviner: started\nhello\nbye"},
+		{diviner.Stderr, "+ echo hello\nwarning\n"},
+		{diviner.AllStreams, "diviner: started\n+ echo hello\nhello\nwarning\nbye"},
+	} {
+		p, err := ioutil.ReadAll(diviner.FilterLog(strings.NewReader(log), test.streams))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(p), test.want; got != want {
+			t.Errorf("%s: got %q, want %q", test.streams, got, want)
+		}
+	}
+}
+
+func TestRunExitString(t *testing.T) {
+	for _, test := range []struct {
+		exit diviner.RunExit
+		want string
+	}{
+		{diviner.RunExit{}, "exit code 0"},
+		{diviner.RunExit{Code: 3, Reason: "exit status 3"}, "exit code 3: exit status 3"},
+		{diviner.RunExit{Code: -1, Signal: "killed"}, "terminated by signal killed"},
+		{diviner.RunExit{Code: -1}, "exit code unknown"},
+	} {
+		if got, want := test.exit.String(), test.want; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+// A Member is a live run of a study's population, as presented to an
+// Exploiter.
+type Member struct {
+	// ID is the run's identifier.
+	ID string
+	// Values are the run's parameter values.
+	Values Values
+	// History is the metric history reported by the run thus far.
+	History []MetricsStep
+}
+
+// An Exploit describes how a run is continued after it is exploited:
+// it is restarted with new parameter values from the latest
+// checkpoint of another, better performing, run.
+type Exploit struct {
+	// From is the ID of the member whose checkpoint is copied.
+	From string
+	// Values are the parameter values with which the run continues.
+	Values Values
+}
+
+// An Exploiter is an oracle that also guides live runs, implementing
+// the exploit and explore steps of population based training [1].
+// Runners consult the exploiters of studies whose oracle implements
+// Exploiter each time a run reports metrics.
+//
+// When a run is exploited, the runner stops it (it completes
+// successfully, with the metrics it reported thus far) and continues
+// with a new run with the exploit's values. If the study's runs are
+// checkpointed (see Checkpoint), the new run is started from the
+// latest saved checkpoint of the run from which it exploits.
+//
+// [1] Jaderberg et al., "Population Based Training of Neural
+// Networks", https://arxiv.org/abs/1711.09846
+type Exploiter interface {
+	Oracle
+
+	// Exploit is called each time a run reports metrics, with the
+	// study's objective and parameters, the run, and the study's
+	// current population of live runs (which includes the run).
+	// Exploit returns true if the run should be exploited as
+	// described by the returned Exploit. Exploit may be called
+	// concurrently.
+	Exploit(objective Objective, params Params, run Member, population []Member) (Exploit, bool)
+}
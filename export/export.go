@@ -0,0 +1,513 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package export writes diviner runs in formats suitable for analysis
+// with tools such as pandas or R: CSV, with a column for each of a
+// run's fields, parameter values, and metrics; or JSON Lines, with a
+// JSON object for each run. It also exports the aggregate costs of
+// studies, and their trials as columnar tables (see ExportTrials).
+//
+// Value and metric columns in CSV output are named "values.name" and
+// "metrics.name", as produced by, e.g., pandas.json_normalize from the
+// JSON output.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+// A Format is a format in which runs are exported.
+type Format int
+
+const (
+	// JSON is the JSON Lines format: each run is written as a JSON
+	// object (a Record), followed by a newline.
+	JSON Format = iota
+	// CSV is the comma-separated values format: a header row is
+	// followed by a row for each run.
+	CSV
+)
+
+// ParseFormat parses the name of a format: "json" or "csv".
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "json", "jsonl":
+		return JSON, nil
+	case "csv":
+		return CSV, nil
+	}
+	return 0, fmt.Errorf("invalid export format %s", name)
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case JSON:
+		return "json"
+	case CSV:
+		return "csv"
+	default:
+		panic(int(f))
+	}
+}
+
+// A Record is the exported representation of a run. Times are
+// formatted as RFC 3339 strings; they are empty if unset. The run's
+// metrics are those of its trial, i.e., the last reported metrics.
+// Non-finite metrics are exported as JSON nulls. The run's estimated
+// cost is omitted if it is unknown, as are its seed (see
+// diviner.RunConfig.Seed) and source (see diviner.Source) if they were
+// not recorded.
+type Record struct {
+	ID             string                 `json:"id"`
+	Study          string                 `json:"study"`
+	Seq            uint64                 `json:"seq"`
+	Replicate      int                    `json:"replicate"`
+	Seed           int64                  `json:"seed,omitempty"`
+	State          string                 `json:"state"`
+	Status         string                 `json:"status"`
+	Created        string                 `json:"created"`
+	Updated        string                 `json:"updated"`
+	Started        string                 `json:"started,omitempty"`
+	Completed      string                 `json:"completed,omitempty"`
+	RuntimeSeconds float64                `json:"runtime_seconds"`
+	Retries        int                    `json:"retries"`
+	RetryOf        uint64                 `json:"retry_of,omitempty"`
+	Attempt        int                    `json:"attempt,omitempty"`
+	Parent         uint64                 `json:"parent,omitempty"`
+	Labels         diviner.Labels         `json:"labels,omitempty"`
+	MachineType    string                 `json:"machine_type,omitempty"`
+	Region         string                 `json:"region,omitempty"`
+	CostDollars    *float64               `json:"cost_dollars,omitempty"`
+	Source         *diviner.Source        `json:"source,omitempty"`
+	Values         map[string]interface{} `json:"values"`
+	Metrics        map[string]interface{} `json:"metrics"`
+}
+
+// NewRecord returns the record representing the provided run.
+func NewRecord(run diviner.Run) Record {
+	rec := Record{
+		ID:             run.ID(),
+		Study:          run.Study,
+		Seq:            run.Seq,
+		Replicate:      run.Replicate,
+		Seed:           run.Config.Seed,
+		State:          run.State.String(),
+		Status:         run.Status,
+		Created:        formatTime(run.Created),
+		Updated:        formatTime(run.Updated),
+		Started:        formatTime(run.Started),
+		Completed:      formatTime(run.Completed),
+		RuntimeSeconds: run.Runtime.Seconds(),
+		Retries:        run.Retries,
+		RetryOf:        run.RetryOf,
+		Attempt:        run.Attempt,
+		Parent:         run.Parent,
+		Labels:         run.Labels,
+		Source:         run.Source,
+		Values:         JSONValues(run.Values),
+		Metrics:        JSONMetrics(run.Trial().Metrics),
+	}
+	if cost := run.Cost; cost != nil {
+		rec.MachineType = cost.MachineType
+		rec.Region = cost.Region
+		if cost.Priced() {
+			rec.CostDollars = &cost.Dollars
+		}
+	}
+	return rec
+}
+
+// JSONValues returns the JSON representation of the provided
+// parameter values, as in a Record.
+func JSONValues(values diviner.Values) map[string]interface{} {
+	m := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		m[name] = jsonValue(value)
+	}
+	return m
+}
+
+// JSONMetrics returns the JSON representation of the provided
+// metrics, as in a Record: non-finite metrics are nulls.
+func JSONMetrics(metrics diviner.Metrics) map[string]interface{} {
+	m := make(map[string]interface{}, len(metrics))
+	for name, metric := range metrics {
+		if math.IsNaN(metric) || math.IsInf(metric, 0) {
+			m[name] = nil
+		} else {
+			m[name] = metric
+		}
+	}
+	return m
+}
+
+// An Encoder writes runs to an output stream.
+type Encoder interface {
+	// Encode writes the provided run.
+	Encode(run diviner.Run) error
+	// Flush writes any buffered data to the underlying stream.
+	Flush() error
+}
+
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+// NewJSONEncoder returns an encoder that writes runs to w in the
+// JSON Lines format.
+func NewJSONEncoder(w io.Writer) Encoder {
+	return jsonEncoder{json.NewEncoder(w)}
+}
+
+func (e jsonEncoder) Encode(run diviner.Run) error {
+	return e.enc.Encode(NewRecord(run))
+}
+
+func (jsonEncoder) Flush() error { return nil }
+
+// csvColumns are the columns of CSV output that precede value and
+// metric columns.
+var csvColumns = []string{
+	"id", "study", "seq", "replicate", "seed", "state", "status",
+	"created", "updated", "started", "completed",
+	"runtime_seconds", "retries", "retry_of", "attempt", "parent", "labels",
+	"machine_type", "region", "cost_dollars",
+	"repository", "commit", "dirty", "study_digest",
+}
+
+type csvEncoder struct {
+	w       *csv.Writer
+	values  []string
+	metrics []string
+	header  bool
+}
+
+// NewCSVEncoder returns an encoder that writes runs to w in the CSV
+// format, with a column for each of the provided parameter values
+// and metrics. Runs that lack a value or metric leave the
+// corresponding column empty; other values and metrics are omitted.
+// The header row is written with the first run, or when the encoder
+// is flushed.
+func NewCSVEncoder(w io.Writer, values, metrics []string) Encoder {
+	return &csvEncoder{w: csv.NewWriter(w), values: values, metrics: metrics}
+}
+
+// WriteHeader writes the header row, if it has not yet been written.
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	header := append([]string{}, csvColumns...)
+	for _, name := range e.values {
+		header = append(header, "values."+name)
+	}
+	for _, name := range e.metrics {
+		header = append(header, "metrics."+name)
+	}
+	return e.w.Write(header)
+}
+
+func (e *csvEncoder) Encode(run diviner.Run) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	var seed, retryOf, parent string
+	if run.Config.Seed != 0 {
+		seed = strconv.FormatInt(run.Config.Seed, 10)
+	}
+	if run.RetryOf != 0 {
+		retryOf = strconv.FormatUint(run.RetryOf, 10)
+	}
+	if run.Parent != 0 {
+		parent = strconv.FormatUint(run.Parent, 10)
+	}
+	var machineType, region, dollars string
+	if cost := run.Cost; cost != nil {
+		machineType, region = cost.MachineType, cost.Region
+		if cost.Priced() {
+			dollars = strconv.FormatFloat(cost.Dollars, 'g', -1, 64)
+		}
+	}
+	var repository, commit, dirty, studyDigest string
+	if source := run.Source; source != nil {
+		repository, commit, studyDigest = source.Repository, source.Commit, source.StudyDigest
+		dirty = strconv.FormatBool(source.Dirty)
+	}
+	row := []string{
+		run.ID(),
+		run.Study,
+		strconv.FormatUint(run.Seq, 10),
+		strconv.Itoa(run.Replicate),
+		seed,
+		run.State.String(),
+		run.Status,
+		formatTime(run.Created),
+		formatTime(run.Updated),
+		formatTime(run.Started),
+		formatTime(run.Completed),
+		strconv.FormatFloat(run.Runtime.Seconds(), 'g', -1, 64),
+		strconv.Itoa(run.Retries),
+		retryOf,
+		strconv.Itoa(run.Attempt),
+		parent,
+		run.Labels.String(),
+		machineType,
+		region,
+		dollars,
+		repository,
+		commit,
+		dirty,
+		studyDigest,
+	}
+	for _, name := range e.values {
+		var field string
+		if v, ok := run.Values[name]; ok {
+			field = csvValue(v)
+		}
+		row = append(row, field)
+	}
+	metrics := run.Trial().Metrics
+	for _, name := range e.metrics {
+		var field string
+		if v, ok := metrics[name]; ok {
+			field = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		row = append(row, field)
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) Flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Export writes the runs in the provided states of the named studies,
+// updated since the provided time, to w in the given format. It
+// returns the number of runs written.
+//
+// Runs are written as they are scanned from the database (see
+// diviner.Database.Scan), so that only a bounded number of runs are
+// held in memory. CSV output requires an additional pass over the
+// runs in order to determine the output's columns.
+func Export(ctx context.Context, db diviner.Database, w io.Writer, format Format, studies []string, states diviner.RunState, since time.Time) (int, error) {
+	var enc Encoder
+	switch format {
+	case JSON:
+		enc = NewJSONEncoder(w)
+	case CSV:
+		values, metrics, err := columns(ctx, db, studies, states, since)
+		if err != nil {
+			return 0, err
+		}
+		enc = NewCSVEncoder(w, values, metrics)
+	default:
+		return 0, fmt.Errorf("invalid export format %d", format)
+	}
+	var n int
+	err := scan(ctx, db, studies, states, since, func(run diviner.Run) error {
+		if err := enc.Encode(run); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, enc.Flush()
+}
+
+// A CostRecord is the exported representation of the aggregate cost
+// of a study's runs (see diviner.StudyCost).
+type CostRecord struct {
+	Study           string  `json:"study"`
+	Runs            int     `json:"runs"`
+	Unpriced        int     `json:"unpriced"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Dollars         float64 `json:"dollars"`
+}
+
+// ExportCosts writes the aggregate costs of the named studies' runs
+// to w in the given format: a CostRecord object per line, or a CSV
+// row per study. Studies that do not exist are skipped. ExportCosts
+// returns the number of studies written.
+func ExportCosts(ctx context.Context, db diviner.Database, w io.Writer, format Format, studies []string) (int, error) {
+	var records []CostRecord
+	for _, study := range studies {
+		summary, err := diviner.StudyCost(ctx, db, study)
+		if err == diviner.ErrNotExist {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		records = append(records, CostRecord{
+			Study:           summary.Study,
+			Runs:            summary.Runs,
+			Unpriced:        summary.Unpriced,
+			DurationSeconds: summary.Duration.Seconds(),
+			Dollars:         summary.Dollars,
+		})
+	}
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return 0, err
+			}
+		}
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"study", "runs", "unpriced", "duration_seconds", "dollars"}); err != nil {
+			return 0, err
+		}
+		for _, rec := range records {
+			err := cw.Write([]string{
+				rec.Study,
+				strconv.Itoa(rec.Runs),
+				strconv.Itoa(rec.Unpriced),
+				strconv.FormatFloat(rec.DurationSeconds, 'g', -1, 64),
+				strconv.FormatFloat(rec.Dollars, 'g', -1, 64),
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("invalid export format %d", format)
+	}
+	return len(records), nil
+}
+
+// Scan calls fn for each of the runs in the provided states of the
+// named studies, updated since the provided time. Studies that do not
+// exist are skipped.
+func scan(ctx context.Context, db diviner.Database, studies []string, states diviner.RunState, since time.Time, fn func(diviner.Run) error) error {
+	for _, study := range studies {
+		it := db.Scan(ctx, study, states)
+		for it.Next() {
+			run := it.Run()
+			if run.Updated.Before(since) {
+				continue
+			}
+			if err := fn(run); err != nil {
+				it.Close()
+				return err
+			}
+		}
+		err := it.Err()
+		it.Close()
+		if err != nil && err != diviner.ErrNotExist {
+			return err
+		}
+	}
+	return nil
+}
+
+// Columns returns the sorted names of the parameter values and
+// metrics of the runs that are exported from the provided studies.
+func columns(ctx context.Context, db diviner.Database, studies []string, states diviner.RunState, since time.Time) (values, metrics []string, err error) {
+	var (
+		valueNames  = make(map[string]bool)
+		metricNames = make(map[string]bool)
+	)
+	err = scan(ctx, db, studies, states, since, func(run diviner.Run) error {
+		for name := range run.Values {
+			valueNames[name] = true
+		}
+		for name := range run.Trial().Metrics {
+			metricNames[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sortedKeys(valueNames), sortedKeys(metricNames), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// JSONValue returns the JSON representation of a parameter value.
+func jsonValue(v diviner.Value) interface{} {
+	switch v.Kind() {
+	case diviner.Integer:
+		return v.Int()
+	case diviner.Real:
+		return v.Float()
+	case diviner.Str:
+		return v.Str()
+	case diviner.Boolean:
+		return v.Bool()
+	case diviner.Seq:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = jsonValue(v.Index(i))
+		}
+		return list
+	case diviner.ValueDict:
+		var values diviner.Values
+		switch v := v.(type) {
+		case diviner.Values:
+			values = v
+		case *diviner.Values:
+			values = *v
+		}
+		dict := make(map[string]interface{}, len(values))
+		for name, value := range values {
+			dict[name] = jsonValue(value)
+		}
+		return dict
+	default:
+		return v.String()
+	}
+}
+
+// CSVValue returns the CSV representation of a parameter value.
+// Scalars are written directly; other values are written as JSON.
+func csvValue(v diviner.Value) string {
+	switch v.Kind() {
+	case diviner.Integer, diviner.Real, diviner.Str, diviner.Boolean, diviner.Interval, diviner.Timestamp:
+		return fmt.Sprint(jsonValue(v))
+	}
+	p, err := json.Marshal(jsonValue(v))
+	if err != nil {
+		return v.String()
+	}
+	return string(p)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/export"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/testutil"
+)
+
+func testDB(t *testing.T) (db diviner.Database, cleanup func()) {
+	t.Helper()
+	dir, cleanup := testutil.TempDir(t, "", "")
+	ldb, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := ldb.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, values := range []diviner.Values{
+		{"lr": diviner.Float(0.1), "opt": diviner.String("adam")},
+		{"lr": diviner.Float(0.2), "layers": diviner.List{diviner.Int(1), diviner.Int(2)}},
+	} {
+		var (
+			source *diviner.Source
+			config diviner.RunConfig
+		)
+		if i == 0 {
+			source = &diviner.Source{Commit: "0123456789abcdef", Dirty: true, Diff: "+x\n", StudyDigest: "fedcba"}
+			config = diviner.RunConfig{Script: "train", Seed: 17}
+		}
+		run, err := ldb.InsertRun(ctx, diviner.Run{Study: "test", Values: values, Config: config, Source: source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ldb.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 0.5 + float64(i)/10}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ldb.UpdateRun(ctx, "test", run.Seq, diviner.Success, "done", time.Minute, 0); err != nil {
+			t.Fatal(err)
+		}
+		cost := diviner.RunCost{Duration: time.Minute}
+		if i == 0 {
+			cost = diviner.EstimateCost(diviner.PriceTable{"m5.large": {"us-west-2": 0.12}}, "m5.large", "us-west-2", 30*time.Minute)
+		}
+		if err := ldb.SetRunCost(ctx, "test", run.Seq, cost); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ldb, cleanup
+}
+
+func TestExportJSON(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	var b bytes.Buffer
+	n, err := export.Export(context.Background(), db, &b, export.JSON, []string{"test"}, diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var records []export.Record
+	for _, line := range lines {
+		var rec export.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if records[0].Seq > records[1].Seq {
+		records[0], records[1] = records[1], records[0]
+	}
+	rec := records[1]
+	if got, want := rec.ID, "test:2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rec.State, "success"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rec.RuntimeSeconds, 60.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if rec.Created == "" || rec.Updated == "" {
+		t.Errorf("missing timestamps: %+v", rec)
+	}
+	if got, want := rec.Values["lr"], 0.2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(rec.Values["layers"].([]interface{})), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rec.Metrics["acc"], 0.6; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if rec.CostDollars != nil {
+		t.Errorf("unexpected cost %v", *rec.CostDollars)
+	}
+	if rec.Source != nil {
+		t.Errorf("unexpected source %v", *rec.Source)
+	}
+	if rec.Seed != 0 {
+		t.Errorf("unexpected seed %v", rec.Seed)
+	}
+	rec = records[0]
+	if got, want := rec.Seed, int64(17); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rec.MachineType, "m5.large"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if rec.CostDollars == nil {
+		t.Fatal("missing cost")
+	}
+	if got, want := *rec.CostDollars, 0.06; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if rec.Source == nil {
+		t.Fatal("missing source")
+	}
+	if got, want := *rec.Source, (diviner.Source{Commit: "0123456789abcdef", Dirty: true, Diff: "+x\n", StudyDigest: "fedcba"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	var b bytes.Buffer
+	if _, err := export.Export(context.Background(), db, &b, export.CSV, []string{"test"}, diviner.Success, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(rows), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	header := rows[0]
+	if got, want := strings.Join(header[len(header)-4:], ","), "values.layers,values.lr,values.opt,metrics.acc"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	byID := make(map[string][]string)
+	for _, row := range rows[1:] {
+		if got, want := len(row), len(header); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		byID[row[0]] = row[len(row)-4:]
+		if row[0] == "test:1" {
+			if got, want := row[4], "17"; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got, want := strings.Join(row[20:24], ","), ",0123456789abcdef,true,fedcba"; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	}
+	if got, want := strings.Join(byID["test:1"], ","), ",0.1,adam,0.5"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := strings.Join(byID["test:2"], ","), "[1,2],0.2,,0.6"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	var b bytes.Buffer
+	n, err := export.Export(context.Background(), db, &b, export.CSV, []string{"test"}, diviner.Failure, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := b.String(), "id,study,seq,replicate,seed,state,status,created,updated,started,completed,runtime_seconds,retries,retry_of,attempt,parent,labels,machine_type,region,cost_dollars,repository,commit,dirty,study_digest\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportCosts(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	var b bytes.Buffer
+	n, err := export.ExportCosts(context.Background(), db, &b, export.CSV, []string{"test", "nonexistent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := b.String(), "study,runs,unpriced,duration_seconds,dollars\ntest,2,1,1860,0.06\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportTrials(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	var b bytes.Buffer
+	n, err := export.ExportTrials(ctx, db, &b, export.CSV, []string{"test", "nonexistent"}, diviner.Success)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"study", "replicates", "pending", "values.layers", "values.lr", "values.opt", "metrics.acc"},
+		{"test", "1", "false", "", "0.1", "adam", "0.5"},
+		{"test", "1", "false", "[1,2]", "0.2", "", "0.6"},
+	}
+	if got := rows; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	b.Reset()
+	if _, err := export.ExportTrials(ctx, db, &b, export.JSON, []string{"test"}, diviner.Success); err != nil {
+		t.Fatal(err)
+	}
+	var rec struct {
+		Study   string `json:"study"`
+		Rows    int    `json:"rows"`
+		Columns []struct {
+			Name   string        `json:"name"`
+			Source string        `json:"source"`
+			Levels []string      `json:"levels"`
+			Coords []interface{} `json:"coords"`
+		} `json:"columns"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.Study, "test"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rec.Rows, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(rec.Columns), 4; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	opt := rec.Columns[2]
+	if got, want := opt.Name, "opt"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := opt.Levels, []string{"adam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := opt.Coords, []interface{}{0.0, nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rec.Columns[3].Source, "metric"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/analysis"
+)
+
+// A TableRecord is the exported representation of the trials of a
+// study, as a columnar analysis.Table.
+type TableRecord struct {
+	Study string `json:"study"`
+	*analysis.Table
+}
+
+// ExportTrials writes the (replicated) trials, in the provided
+// states, of the named studies to w in the given format, returning
+// the number of trials written. Each study's trials are first
+// assembled into an analysis.Table, with a column for each parameter
+// and metric. In JSON output, each study's table is written as a
+// TableRecord object per line; in CSV output, each trial is written
+// as a row with columns "study", "replicates", and "pending",
+// followed by a column for each of the studies' parameter values
+// ("values.name") and metrics ("metrics.name"). Studies that do not
+// exist are skipped.
+func ExportTrials(ctx context.Context, db diviner.Database, w io.Writer, format Format, studies []string, states diviner.RunState) (int, error) {
+	var (
+		records []TableRecord
+		trials  [][]diviner.Trial
+		n       int
+	)
+	for _, name := range studies {
+		study, err := db.LookupStudy(ctx, name)
+		if err == diviner.ErrNotExist {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		m, err := diviner.Trials(ctx, db, study, states)
+		if err != nil {
+			return 0, err
+		}
+		var list []diviner.Trial
+		m.Range(func(_ diviner.Value, v interface{}) {
+			list = append(list, v.(diviner.Trial))
+		})
+		sort.SliceStable(list, func(i, j int) bool {
+			return firstSeq(list[i]) < firstSeq(list[j])
+		})
+		records = append(records, TableRecord{study.Name, analysis.NewTable(study.Params, list)})
+		trials = append(trials, list)
+		n += len(list)
+	}
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return 0, err
+			}
+		}
+	case CSV:
+		if err := writeTables(w, records, trials); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("invalid export format %d", format)
+	}
+	return n, nil
+}
+
+// WriteTables writes the provided tables in CSV format, with a column
+// for each parameter and metric in any of the tables.
+func writeTables(w io.Writer, records []TableRecord, trials [][]diviner.Trial) error {
+	var (
+		values  = make(map[string]bool)
+		metrics = make(map[string]bool)
+	)
+	for _, rec := range records {
+		for _, col := range rec.Columns {
+			if col.Source == analysis.MetricSource {
+				metrics[col.Name] = true
+			} else {
+				values[col.Name] = true
+			}
+		}
+	}
+	var (
+		valueNames  = sortedKeys(values)
+		metricNames = sortedKeys(metrics)
+		header      = []string{"study", "replicates", "pending"}
+	)
+	for _, name := range valueNames {
+		header = append(header, "values."+name)
+	}
+	for _, name := range metricNames {
+		header = append(header, "metrics."+name)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for k, rec := range records {
+		var (
+			valueCols  = make([]*analysis.Column, len(valueNames))
+			metricCols = make([]*analysis.Column, len(metricNames))
+		)
+		for i, name := range valueNames {
+			if col, ok := rec.Column(analysis.ParamSource, name); ok {
+				valueCols[i] = &col
+			}
+		}
+		for i, name := range metricNames {
+			if col, ok := rec.Column(analysis.MetricSource, name); ok {
+				metricCols[i] = &col
+			}
+		}
+		for row := 0; row < rec.Rows; row++ {
+			trial := trials[k][row]
+			fields := []string{
+				rec.Study,
+				strconv.Itoa(trial.Replicates.Count()),
+				strconv.FormatBool(trial.Pending),
+			}
+			for _, col := range valueCols {
+				var field string
+				if col != nil && col.Values[row] != nil {
+					field = csvValue(col.Values[row])
+				}
+				fields = append(fields, field)
+			}
+			for _, col := range metricCols {
+				var field string
+				if col != nil && col.Values[row] != nil {
+					field = strconv.FormatFloat(col.Coords[row], 'g', -1, 64)
+				}
+				fields = append(fields, field)
+			}
+			if err := cw.Write(fields); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// FirstSeq returns the smallest sequence number of the trial's runs.
+func firstSeq(trial diviner.Trial) uint64 {
+	var seq uint64
+	for i, run := range trial.Runs {
+		if i == 0 || run.Seq < seq {
+			seq = run.Seq
+		}
+	}
+	return seq
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go.starlark.net/starlark"
+)
+
+// A Fidelity is a study's budget knob: a quantity, such as the number
+// of training epochs or the fraction of the training data used, that
+// trades off the cost of a run against how faithfully it evaluates its
+// parameter values. Multi-fidelity oracles (see FidelityOracle) choose
+// the fidelity of each trial separately from its parameter values,
+// for example to evaluate many trials cheaply and only the most
+// promising of them at full fidelity.
+//
+// A run's fidelity is passed to the study's Run (or Acquire) function
+// among its values, named by the fidelity's Name, so that the run can
+// honor it. The fidelity is not itself a parameter: it is not
+// included in the study's Params, and trials run at different
+// fidelities are distinct trials. Oracles that do not implement
+// FidelityOracle run every trial at the maximum fidelity.
+type Fidelity struct {
+	// Name is the name of the value by which runs receive their
+	// fidelity; e.g., "epochs". It must not name one of the study's
+	// parameters.
+	Name string
+	// Min and Max are the smallest and largest fidelities at which
+	// trials are run. Max is a study's full fidelity.
+	Min, Max float64
+	// Integer tells whether fidelities are integers (e.g., a number
+	// of epochs), rather than real numbers (e.g., a fraction of a
+	// dataset).
+	Integer bool
+}
+
+// Validate returns an error if the fidelity is not valid for a study
+// with the provided parameters.
+func (f Fidelity) Validate(params Params) error {
+	switch {
+	case f.Name == "":
+		return errors.New("fidelity has no name")
+	case f.Min <= 0 || f.Max < f.Min:
+		return fmt.Errorf("fidelity %s: invalid range [%v, %v]", f.Name, f.Min, f.Max)
+	}
+	if _, ok := params[f.Name]; ok {
+		return fmt.Errorf("fidelity %s has the same name as a parameter", f.Name)
+	}
+	return nil
+}
+
+// Round clamps the provided fidelity to the range of the fidelity,
+// and rounds it if the fidelity is an integer.
+func (f Fidelity) Round(fidelity float64) float64 {
+	fidelity = math.Max(f.Min, math.Min(f.Max, fidelity))
+	if f.Integer {
+		fidelity = math.Round(fidelity)
+	}
+	return fidelity
+}
+
+// Value returns the value representing the provided fidelity, as
+// rounded by Round.
+func (f Fidelity) Value(fidelity float64) Value {
+	fidelity = f.Round(fidelity)
+	if f.Integer {
+		return Int(fidelity)
+	}
+	return Float(fidelity)
+}
+
+// Get returns the fidelity included in the provided values. It
+// returns false if the values do not include a fidelity.
+func (f Fidelity) Get(values Values) (float64, bool) {
+	v, ok := values[f.Name]
+	if !ok {
+		return 0, false
+	}
+	fidelity, err := TryFloat(v)
+	return fidelity, err == nil
+}
+
+// With returns a copy of the provided values that includes the
+// provided fidelity.
+func (f Fidelity) With(values Values, fidelity float64) Values {
+	with := make(Values, len(values)+1)
+	for name, value := range values {
+		with[name] = value
+	}
+	with[f.Name] = f.Value(fidelity)
+	return with
+}
+
+// Strip returns the provided values without their fidelity: that is,
+// the trial's parameter values alone.
+func (f Fidelity) Strip(values Values) Values {
+	if _, ok := values[f.Name]; !ok {
+		return values
+	}
+	stripped := make(Values, len(values)-1)
+	for name, value := range values {
+		if name != f.Name {
+			stripped[name] = value
+		}
+	}
+	return stripped
+}
+
+// String returns a textual description of the fidelity.
+func (f Fidelity) String() string {
+	return fmt.Sprintf("fidelity(name=%s, min=%v, max=%v)", f.Name, f.Min, f.Max)
+}
+
+// Type implements starlark.Value.
+func (Fidelity) Type() string { return "fidelity" }
+
+// Freeze implements starlark.Value.
+func (Fidelity) Freeze() {}
+
+// Truth implements starlark.Value.
+func (Fidelity) Truth() starlark.Bool { return true }
+
+// Hash implements starlark.Value.
+func (Fidelity) Hash() (uint32, error) { return 0, errors.New("fidelity is not hashable") }
+
+// A FidelityOracle is an Oracle that also chooses the fidelity at
+// which each trial is run, as do, e.g., Hyperband and BOHB. Runners
+// call NextFidelity for studies with a Fidelity whose oracles
+// implement FidelityOracle.
+type FidelityOracle interface {
+	Oracle
+
+	// NextFidelity returns the next n parameter values to run, as in
+	// Next, each of which includes the fidelity (named by
+	// fidelity.Name) at which it is to be run. The values of previous
+	// trials likewise include the fidelities at which they were run.
+	NextFidelity(previous []Trial, params Params, objective Objective, fidelity Fidelity, n int) ([]Values, error)
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestFidelity(t *testing.T) {
+	params := diviner.Params{"lr": diviner.NewRange(diviner.Float(0), diviner.Float(1))}
+	for _, f := range []diviner.Fidelity{
+		{Name: "", Min: 1, Max: 2},
+		{Name: "epochs", Min: 0, Max: 2},
+		{Name: "epochs", Min: 3, Max: 2},
+		{Name: "lr", Min: 1, Max: 2},
+	} {
+		if err := f.Validate(params); err == nil {
+			t.Errorf("%v: expected error", f)
+		}
+	}
+	epochs := diviner.Fidelity{Name: "epochs", Min: 1, Max: 27, Integer: true}
+	if err := epochs.Validate(params); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		fidelity float64
+		value    diviner.Value
+	}{
+		{0.2, diviner.Int(1)},
+		{2.6, diviner.Int(3)},
+		{100, diviner.Int(27)},
+	} {
+		if got, want := epochs.Value(c.fidelity), c.value; !got.Equal(want) {
+			t.Errorf("%v: got %v, want %v", c.fidelity, got, want)
+		}
+	}
+	fraction := diviner.Fidelity{Name: "fraction", Min: 0.1, Max: 1}
+	if got, want := fraction.Value(0.25), diviner.Value(diviner.Float(0.25)); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	values := diviner.Values{"lr": diviner.Float(0.1)}
+	if _, ok := epochs.Get(values); ok {
+		t.Error("values have no fidelity")
+	}
+	with := epochs.With(values, 9)
+	if got, want := len(values), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if f, ok := epochs.Get(with); !ok || f != 9 {
+		t.Errorf("got %v, %v, want 9, true", f, ok)
+	}
+	if got, want := epochs.Strip(with).String(), values.String(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
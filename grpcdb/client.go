@@ -0,0 +1,430 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package grpcdb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	logStream    = &grpc.StreamDesc{StreamName: "Log", ServerStreams: true}
+	scanStream   = &grpc.StreamDesc{StreamName: "Scan", ServerStreams: true}
+	loggerStream = &grpc.StreamDesc{StreamName: "Logger", ClientStreams: true}
+)
+
+// DB implements diviner.Database by calling a remote divinerdb.Database
+// service.
+type DB struct {
+	conn *grpc.ClientConn
+}
+
+var _ diviner.Database = (*DB)(nil)
+
+// Dial connects to the divinerdb.Database service at the provided
+// address. Connections are insecure unless options specifying
+// transport credentials are provided.
+func Dial(addr string, opts ...grpc.DialOption) (*DB, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn), nil
+}
+
+// New returns a new DB that uses the provided client connection.
+func New(conn *grpc.ClientConn) *DB {
+	return &DB{conn}
+}
+
+// Close closes the DB's underlying connection.
+func (d *DB) Close() error {
+	return d.conn.Close()
+}
+
+func (d *DB) call(ctx context.Context, method string, req *request) (*reply, error) {
+	reply := new(reply)
+	err := d.conn.Invoke(ctx, "/"+serviceName+"/"+method, req, reply, grpc.CallContentSubtype(codecName))
+	return reply, fromStatus(err)
+}
+
+// CreateTable implements diviner.Database.
+func (d *DB) CreateTable(ctx context.Context) error {
+	_, err := d.call(ctx, "CreateTable", &request{})
+	return err
+}
+
+// CreateStudyIfNotExist implements diviner.Database.
+func (d *DB) CreateStudyIfNotExist(ctx context.Context, study diviner.Study) (bool, error) {
+	reply, err := d.call(ctx, "CreateStudyIfNotExist", &request{Study: study})
+	return reply.Created, err
+}
+
+// LookupStudy implements diviner.Database.
+func (d *DB) LookupStudy(ctx context.Context, name string) (diviner.Study, error) {
+	reply, err := d.call(ctx, "LookupStudy", &request{Name: name})
+	return reply.Study, err
+}
+
+// UpdateStudy implements diviner.Database.
+func (d *DB) UpdateStudy(ctx context.Context, study diviner.Study) error {
+	_, err := d.call(ctx, "UpdateStudy", &request{Study: study})
+	return err
+}
+
+// ListStudies implements diviner.Database.
+func (d *DB) ListStudies(ctx context.Context, prefix string, since time.Time) ([]diviner.Study, error) {
+	reply, err := d.call(ctx, "ListStudies", &request{Prefix: prefix, Since: since})
+	return reply.Studies, err
+}
+
+// NextSeq implements diviner.Database.
+func (d *DB) NextSeq(ctx context.Context, study string) (uint64, error) {
+	reply, err := d.call(ctx, "NextSeq", &request{Name: study})
+	return reply.Seq, err
+}
+
+// InsertRun implements diviner.Database.
+func (d *DB) InsertRun(ctx context.Context, run diviner.Run) (diviner.Run, error) {
+	reply, err := d.call(ctx, "InsertRun", &request{Run: run})
+	return reply.Run, err
+}
+
+// UpdateRun implements diviner.Database.
+func (d *DB) UpdateRun(ctx context.Context, study string, seq uint64, state diviner.RunState, message string, runtime time.Duration, retry int) error {
+	_, err := d.call(ctx, "UpdateRun", &request{
+		Name:    study,
+		Seq:     seq,
+		State:   state,
+		Message: message,
+		Runtime: runtime,
+		Retry:   retry,
+	})
+	return err
+}
+
+// AppendRunMetrics implements diviner.Database.
+func (d *DB) AppendRunMetrics(ctx context.Context, study string, seq uint64, metrics diviner.Metrics) error {
+	_, err := d.call(ctx, "AppendRunMetrics", &request{Name: study, Seq: seq, Metrics: metrics})
+	return err
+}
+
+// ListRuns implements diviner.Database.
+func (d *DB) ListRuns(ctx context.Context, study string, states diviner.RunState, since time.Time) ([]diviner.Run, error) {
+	reply, err := d.call(ctx, "ListRuns", &request{Name: study, State: states, Since: since})
+	return reply.Runs, err
+}
+
+// Scan implements diviner.Database. Runs are streamed from the server
+// in batches.
+func (d *DB) Scan(ctx context.Context, study string, states diviner.RunState) diviner.RunIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	var stream grpc.ClientStream
+	it := diviner.NewRunIterator(func() ([]diviner.Run, error) {
+		if stream == nil {
+			var err error
+			stream, err = d.conn.NewStream(ctx, scanStream,
+				"/"+serviceName+"/Scan", grpc.CallContentSubtype(codecName))
+			if err == nil {
+				err = stream.SendMsg(&request{Name: study, State: states})
+			}
+			if err == nil {
+				err = stream.CloseSend()
+			}
+			if err != nil {
+				return nil, fromStatus(err)
+			}
+		}
+		reply := new(reply)
+		if err := stream.RecvMsg(reply); err != nil {
+			return nil, fromStatus(err)
+		}
+		return reply.Runs, nil
+	})
+	return &scanIterator{it, cancel}
+}
+
+// ScanIterator cancels its stream when it is closed.
+type scanIterator struct {
+	diviner.RunIterator
+	cancel func()
+}
+
+func (it *scanIterator) Close() error {
+	it.cancel()
+	return it.RunIterator.Close()
+}
+
+// Query implements diviner.Database. Queries are evaluated by the
+// server.
+func (d *DB) Query(ctx context.Context, study string, query diviner.Query) ([]diviner.Run, error) {
+	reply, err := d.call(ctx, "Query", &request{Name: study, Query: query})
+	return reply.Runs, err
+}
+
+// BestRuns implements diviner.Database. The runs are ranked by the
+// server.
+func (d *DB) BestRuns(ctx context.Context, study string, objective diviner.Objective, k int) ([]diviner.Run, error) {
+	reply, err := d.call(ctx, "BestRuns", &request{Name: study, Objective: objective, Limit: k})
+	return reply.Runs, err
+}
+
+// LookupRun implements diviner.Database.
+func (d *DB) LookupRun(ctx context.Context, study string, seq uint64) (diviner.Run, error) {
+	reply, err := d.call(ctx, "LookupRun", &request{Name: study, Seq: seq})
+	return reply.Run, err
+}
+
+// SetRunLabels implements diviner.Database.
+func (d *DB) SetRunLabels(ctx context.Context, study string, seq uint64, labels diviner.Labels) error {
+	_, err := d.call(ctx, "SetRunLabels", &request{Name: study, Seq: seq, Labels: labels})
+	return err
+}
+
+// SetRunArtifacts implements diviner.Database.
+func (d *DB) SetRunArtifacts(ctx context.Context, study string, seq uint64, artifacts []diviner.Artifact) error {
+	_, err := d.call(ctx, "SetRunArtifacts", &request{Name: study, Seq: seq, Artifacts: artifacts})
+	return err
+}
+
+// SetRunExit implements diviner.Database.
+func (d *DB) SetRunExit(ctx context.Context, study string, seq uint64, exit diviner.RunExit) error {
+	_, err := d.call(ctx, "SetRunExit", &request{Name: study, Seq: seq, Exit: exit})
+	return err
+}
+
+// SetRunCost implements diviner.Database.
+func (d *DB) SetRunCost(ctx context.Context, study string, seq uint64, cost diviner.RunCost) error {
+	_, err := d.call(ctx, "SetRunCost", &request{Name: study, Seq: seq, Cost: cost})
+	return err
+}
+
+// DeleteRun implements diviner.Database.
+func (d *DB) DeleteRun(ctx context.Context, study string, seq uint64) error {
+	_, err := d.call(ctx, "DeleteRun", &request{Name: study, Seq: seq})
+	return err
+}
+
+// DeleteRunLogs implements diviner.Database.
+func (d *DB) DeleteRunLogs(ctx context.Context, study string, seq uint64) error {
+	_, err := d.call(ctx, "DeleteRunLogs", &request{Name: study, Seq: seq})
+	return err
+}
+
+// DeleteStudy implements diviner.Database.
+func (d *DB) DeleteStudy(ctx context.Context, name string) error {
+	_, err := d.call(ctx, "DeleteStudy", &request{Name: name})
+	return err
+}
+
+// LookupDataset implements diviner.Database.
+func (d *DB) LookupDataset(ctx context.Context, name string) (diviner.DatasetRecord, error) {
+	reply, err := d.call(ctx, "LookupDataset", &request{Name: name})
+	return reply.Dataset, err
+}
+
+// SetDataset implements diviner.Database.
+func (d *DB) SetDataset(ctx context.Context, record diviner.DatasetRecord) error {
+	_, err := d.call(ctx, "SetDataset", &request{Dataset: record})
+	return err
+}
+
+// InvalidateDataset implements diviner.Database.
+func (d *DB) InvalidateDataset(ctx context.Context, name string) error {
+	_, err := d.call(ctx, "InvalidateDataset", &request{Name: name})
+	return err
+}
+
+// LookupOracleState implements diviner.Database.
+func (d *DB) LookupOracleState(ctx context.Context, study string) ([]byte, error) {
+	reply, err := d.call(ctx, "LookupOracleState", &request{Name: study})
+	return reply.Data, err
+}
+
+// SetOracleState implements diviner.Database.
+func (d *DB) SetOracleState(ctx context.Context, study string, state []byte) error {
+	_, err := d.call(ctx, "SetOracleState", &request{Name: study, Data: state})
+	return err
+}
+
+// AcquireLease implements diviner.Database.
+func (d *DB) AcquireLease(ctx context.Context, study string, lease diviner.Lease) (diviner.Lease, bool, error) {
+	reply, err := d.call(ctx, "AcquireLease", &request{Name: study, Lease: lease})
+	return reply.Lease, reply.Acquired, err
+}
+
+// ReleaseLease implements diviner.Database.
+func (d *DB) ReleaseLease(ctx context.Context, study, owner string) error {
+	_, err := d.call(ctx, "ReleaseLease", &request{Name: study, Lease: diviner.Lease{Owner: owner}})
+	return err
+}
+
+// EnqueueValues implements diviner.Database.
+func (d *DB) EnqueueValues(ctx context.Context, study string, values diviner.Values) error {
+	_, err := d.call(ctx, "EnqueueValues", &request{Name: study, Values: values})
+	return err
+}
+
+// DequeueValues implements diviner.Database.
+func (d *DB) DequeueValues(ctx context.Context, study string, n int) ([]diviner.Values, error) {
+	reply, err := d.call(ctx, "DequeueValues", &request{Name: study, Limit: n})
+	return reply.Values, err
+}
+
+// QueuedValues implements diviner.Database.
+func (d *DB) QueuedValues(ctx context.Context, study string) ([]diviner.Values, error) {
+	reply, err := d.call(ctx, "QueuedValues", &request{Name: study})
+	return reply.Values, err
+}
+
+// Log implements diviner.Database. Logs are streamed from the server
+// as they are read.
+func (d *DB) Log(study string, seq uint64, since time.Time, follow bool) io.Reader {
+	return &logReader{db: d, req: request{Name: study, Seq: seq, Since: since, Follow: follow}}
+}
+
+type logReader struct {
+	db     *DB
+	req    request
+	stream grpc.ClientStream
+	buf    []byte
+	err    error
+}
+
+func (r *logReader) Read(p []byte) (n int, err error) {
+	if r.stream == nil && r.err == nil {
+		r.stream, r.err = r.db.conn.NewStream(context.Background(), logStream,
+			"/"+serviceName+"/Log", grpc.CallContentSubtype(codecName))
+		if r.err == nil {
+			r.err = r.stream.SendMsg(&r.req)
+		}
+		if r.err == nil {
+			r.err = r.stream.CloseSend()
+		}
+		r.err = fromStatus(r.err)
+	}
+	for len(r.buf) == 0 && r.err == nil {
+		reply := new(reply)
+		if r.err = fromStatus(r.stream.RecvMsg(reply)); r.err == nil {
+			r.buf = reply.Data
+		}
+	}
+	if len(r.buf) == 0 {
+		return 0, r.err
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Logger implements diviner.Database. Log data are buffered and
+// streamed to the server, which writes them to the underlying
+// database's logger.
+func (d *DB) Logger(study string, seq uint64) io.WriteCloser {
+	w := &logWriter{db: d, study: study, seq: seq}
+	return &logger{bufio.NewWriterSize(w, 4<<10), w}
+}
+
+type logger struct {
+	*bufio.Writer
+	w *logWriter
+}
+
+func (l *logger) Close() error {
+	err := l.Flush()
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	return l.w.close(err)
+}
+
+// LogWriter writes chunks to a Logger stream, which is opened on the
+// first write.
+type logWriter struct {
+	db    *DB
+	study string
+	seq   uint64
+
+	mu     sync.Mutex
+	stream grpc.ClientStream
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stream == nil {
+		var err error
+		w.stream, err = w.db.conn.NewStream(context.Background(), loggerStream,
+			"/"+serviceName+"/Logger", grpc.CallContentSubtype(codecName))
+		if err != nil {
+			return 0, fromStatus(err)
+		}
+		if err := w.stream.SendMsg(&request{Name: w.study, Seq: w.seq}); err != nil {
+			w.stream = nil
+			return 0, fromStatus(err)
+		}
+	}
+	if err := w.stream.SendMsg(&request{Data: p}); err != nil {
+		return 0, w.close(err)
+	}
+	return len(p), nil
+}
+
+// close closes the writer's stream and waits for the server to
+// acknowledge it; err is the error, if any, that caused the stream
+// to be closed.
+func (w *logWriter) close(err error) error {
+	if w.stream == nil {
+		return err
+	}
+	stream := w.stream
+	w.stream = nil
+	if cerr := stream.CloseSend(); err == nil {
+		err = cerr
+	}
+	// A failed send is reported, with its cause, by RecvMsg.
+	if rerr := stream.RecvMsg(new(reply)); rerr != nil && rerr != io.EOF {
+		err = rerr
+	}
+	if err != nil {
+		log.Error.Printf("grpcdb: logger %s:%d: %v", w.study, w.seq, err)
+	}
+	return fromStatus(err)
+}
+
+// fromStatus converts a gRPC status error into the corresponding
+// database error.
+func fromStatus(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		return diviner.ErrNotExist
+	case codes.FailedPrecondition:
+		return diviner.ErrArchived
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+	return errors.New(s.Message())
+}
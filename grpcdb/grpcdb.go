@@ -0,0 +1,106 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package grpcdb implements a gRPC service, divinerdb.Database, that
+// exposes a diviner.Database to remote clients, together with a
+// client that itself implements diviner.Database. This allows many
+// runner processes, possibly on different machines, to share a single
+// database (e.g., a localdb database) hosted by one process.
+//
+// Messages are encoded with gob, just as diviner databases encode
+// studies and runs; thus servers must link in the same oracle,
+// scheduler, and system implementations as their clients.
+//
+// A server is set up as follows:
+//
+//	srv := grpc.NewServer()
+//	grpcdb.Register(srv, db)
+//	srv.Serve(listener)
+//
+// and a client connects to it with:
+//
+//	db, err := grpcdb.Dial("host:6001")
+package grpcdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"google.golang.org/grpc/encoding"
+)
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// serviceName is the fully qualified name of the gRPC service.
+const serviceName = "divinerdb.Database"
+
+// codecName is the gRPC content subtype used by the service.
+const codecName = "gob"
+
+// Codec implements a gRPC codec (encoding.Codec) using gob.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (codec) Name() string { return codecName }
+
+// Request is the request message for all of the service's methods. Each
+// method uses the subset of fields corresponding to its arguments.
+type request struct {
+	Study     diviner.Study
+	Name      string
+	Prefix    string
+	Since     time.Time
+	Run       diviner.Run
+	Seq       uint64
+	State     diviner.RunState
+	Message   string
+	Runtime   time.Duration
+	Retry     int
+	Metrics   diviner.Metrics
+	Labels    diviner.Labels
+	Artifacts []diviner.Artifact
+	Exit      diviner.RunExit
+	Cost      diviner.RunCost
+	Query     diviner.Query
+	Objective diviner.Objective
+	Limit     int
+	Dataset   diviner.DatasetRecord
+	Follow    bool
+	Values    diviner.Values
+	Lease     diviner.Lease
+	// Data is a chunk of log data, sent by the Logger stream, or an
+	// oracle state.
+	Data []byte
+}
+
+// Reply is the reply message for all of the service's methods. Each
+// method uses the subset of fields corresponding to its results.
+type reply struct {
+	Created  bool
+	Acquired bool
+	Study    diviner.Study
+	Studies  []diviner.Study
+	Seq      uint64
+	Run      diviner.Run
+	Runs     []diviner.Run
+	Dataset  diviner.DatasetRecord
+	Values   []diviner.Values
+	Lease    diviner.Lease
+	// Data is a chunk of log data, sent by the Log stream, or an
+	// oracle state.
+	Data []byte
+}
@@ -0,0 +1,267 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package grpcdb_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/grpcdb"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/diviner/oracle"
+	"github.com/grailbio/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestDB(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	local, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	grpcdb.Register(srv, local)
+	go srv.Serve(l)
+	defer srv.Stop()
+	db, err := grpcdb.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"learning_rate": diviner.NewRange(diviner.Float(0.1), diviner.Float(1.0)),
+		},
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	for _, want := range []bool{true, false} {
+		created, err := db.CreateStudyIfNotExist(ctx, study)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := created; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	studies, err := db.ListStudies(ctx, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := studies, []diviner.Study{study}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.LookupStudy(ctx, "nonexistent"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+
+	values := diviner.Values{"learning_rate": diviner.Float(0.5)}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	logc := make(chan string)
+	go func() {
+		p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, true))
+		if err != nil {
+			t.Error(err)
+		}
+		logc <- string(p)
+	}()
+	w := db.Logger("test", run.Seq)
+	if _, err := io.WriteString(w, "hello, world\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "ok", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-logc, "hello, world\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	runs, err := db.ListRuns(ctx, "test", diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if scanned, err := diviner.ScanAll(db.Scan(ctx, "test", diviner.Success)); err != nil {
+		t.Fatal(err)
+	} else if got, want := scanned, runs; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := diviner.ScanAll(db.Scan(ctx, "nonexistent", diviner.Any)); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if queried, err := db.Query(ctx, "test", diviner.Query{States: diviner.Success}); err != nil {
+		t.Fatal(err)
+	} else if got, want := queried, runs; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want, err := local.LookupRun(ctx, "test", run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runs[0]; !reflect.DeepEqual(got.Values, want.Values) || !reflect.DeepEqual(got.Metrics, want.Metrics) ||
+		got.Status != want.Status || got.Runtime != want.Runtime {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := db.SetRunLabels(ctx, "test", run.Seq, diviner.Labels{"gpu": "a100"}); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := local.LookupRun(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	} else if got, want := run.Labels.String(), "gpu=a100"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	artifacts := []diviner.Artifact{{Name: "model", URL: "s3://bucket/model"}}
+	if err := db.SetRunArtifacts(ctx, "test", run.Seq, artifacts); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := local.LookupRun(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	} else if got, want := run.Artifacts, artifacts; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	objective := diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+	if got, err := db.BestRuns(ctx, "test", objective, 10); err != nil {
+		t.Fatal(err)
+	} else if want, err := local.BestRuns(ctx, "test", objective, 10); err != nil {
+		t.Fatal(err)
+	} else if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	exit := diviner.RunExit{Code: 2, Reason: "exit status 2", Stderr: []string{"oops"}}
+	if err := db.SetRunExit(ctx, "test", run.Seq, exit); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := local.LookupRun(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	} else if run.Exit == nil || !reflect.DeepEqual(*run.Exit, exit) {
+		t.Errorf("got %v, want %v", run.Exit, exit)
+	}
+	cost := diviner.RunCost{MachineType: "m5.large", Region: "us-west-2", Duration: time.Hour, HourlyPrice: 0.096, Dollars: 0.096}
+	if err := db.SetRunCost(ctx, "test", run.Seq, cost); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := local.LookupRun(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	} else if run.Cost == nil || *run.Cost != cost {
+		t.Errorf("got %v, want %v", run.Cost, cost)
+	}
+	if err := db.SetDataset(ctx, diviner.DatasetRecord{Name: "data", Digest: "abc", Completed: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := db.LookupDataset(ctx, "data"); err != nil {
+		t.Fatal(err)
+	} else if got, want := record.Digest, "abc"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := db.InvalidateDataset(ctx, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupDataset(ctx, "data"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := db.LookupOracleState(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if err := db.SetOracleState(ctx, "test", []byte("state")); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := db.LookupOracleState(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(state), "state"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	queued := diviner.Values{"x": diviner.Int(1), "opt": diviner.String("adam")}
+	if err := db.EnqueueValues(ctx, "test", queued); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := db.QueuedValues(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{queued}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.DequeueValues(ctx, "test", 10); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{queued}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := db.EnqueueValues(ctx, "nonexistent", queued), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	lease := diviner.Lease{Owner: "a", PID: 1, Expires: time.Now().Add(time.Minute)}
+	if held, ok, err := db.AcquireLease(ctx, "test", lease); err != nil {
+		t.Fatal(err)
+	} else if !ok || held.PID != 1 {
+		t.Errorf("got %v, %v, want %v, true", held, ok, lease)
+	}
+	if held, ok, err := db.AcquireLease(ctx, "test", diviner.Lease{Owner: "b"}); err != nil {
+		t.Fatal(err)
+	} else if ok || held.Owner != "a" {
+		t.Errorf("got %v, %v, want %v, false", held, ok, lease)
+	}
+	if err := db.ReleaseLease(ctx, "test", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.AcquireLease(ctx, "nonexistent", lease); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+
+	if err := db.DeleteRunLogs(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, false)); err != nil {
+		t.Fatal(err)
+	} else if len(p) != 0 {
+		t.Errorf("unexpected log %q", p)
+	}
+	if err := diviner.ArchiveStudy(ctx, db, "test", true); err != nil {
+		t.Fatal(err)
+	}
+	if study, err := db.LookupStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if !study.Archived {
+		t.Error("study is not archived")
+	}
+	if got, want := db.SetRunLabels(ctx, "test", run.Seq, nil), diviner.ErrArchived; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := db.DeleteStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupRun(ctx, "test", run.Seq); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, false)); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package grpcdb
+
+import (
+	"context"
+	"io"
+
+	"github.com/grailbio/diviner"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// logChunkSize is the maximum size of log chunks sent by the Log
+// stream.
+const logChunkSize = 32 << 10
+
+// scanBatchSize is the maximum number of runs sent in each message of
+// the Scan stream.
+const scanBatchSize = 100
+
+// A method implements a unary method of the service on a database.
+type method func(ctx context.Context, db diviner.Database, req *request) (*reply, error)
+
+var methods = map[string]method{
+	"CreateTable": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.CreateTable(ctx)
+	},
+	"CreateStudyIfNotExist": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		created, err := db.CreateStudyIfNotExist(ctx, req.Study)
+		return &reply{Created: created}, err
+	},
+	"LookupStudy": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		study, err := db.LookupStudy(ctx, req.Name)
+		return &reply{Study: study}, err
+	},
+	"UpdateStudy": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.UpdateStudy(ctx, req.Study)
+	},
+	"ListStudies": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		studies, err := db.ListStudies(ctx, req.Prefix, req.Since)
+		return &reply{Studies: studies}, err
+	},
+	"NextSeq": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		seq, err := db.NextSeq(ctx, req.Name)
+		return &reply{Seq: seq}, err
+	},
+	"InsertRun": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		run, err := db.InsertRun(ctx, req.Run)
+		return &reply{Run: run}, err
+	},
+	"UpdateRun": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.UpdateRun(ctx, req.Name, req.Seq, req.State, req.Message, req.Runtime, req.Retry)
+	},
+	"AppendRunMetrics": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.AppendRunMetrics(ctx, req.Name, req.Seq, req.Metrics)
+	},
+	"ListRuns": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		runs, err := db.ListRuns(ctx, req.Name, req.State, req.Since)
+		return &reply{Runs: runs}, err
+	},
+	"Query": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		runs, err := db.Query(ctx, req.Name, req.Query)
+		return &reply{Runs: runs}, err
+	},
+	"BestRuns": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		runs, err := db.BestRuns(ctx, req.Name, req.Objective, req.Limit)
+		return &reply{Runs: runs}, err
+	},
+	"LookupRun": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		run, err := db.LookupRun(ctx, req.Name, req.Seq)
+		return &reply{Run: run}, err
+	},
+	"SetRunLabels": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.SetRunLabels(ctx, req.Name, req.Seq, req.Labels)
+	},
+	"SetRunArtifacts": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.SetRunArtifacts(ctx, req.Name, req.Seq, req.Artifacts)
+	},
+	"SetRunExit": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.SetRunExit(ctx, req.Name, req.Seq, req.Exit)
+	},
+	"SetRunCost": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.SetRunCost(ctx, req.Name, req.Seq, req.Cost)
+	},
+	"DeleteRun": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.DeleteRun(ctx, req.Name, req.Seq)
+	},
+	"DeleteRunLogs": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.DeleteRunLogs(ctx, req.Name, req.Seq)
+	},
+	"DeleteStudy": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.DeleteStudy(ctx, req.Name)
+	},
+	"LookupDataset": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		record, err := db.LookupDataset(ctx, req.Name)
+		return &reply{Dataset: record}, err
+	},
+	"SetDataset": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.SetDataset(ctx, req.Dataset)
+	},
+	"InvalidateDataset": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.InvalidateDataset(ctx, req.Name)
+	},
+	"LookupOracleState": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		state, err := db.LookupOracleState(ctx, req.Name)
+		return &reply{Data: state}, err
+	},
+	"SetOracleState": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.SetOracleState(ctx, req.Name, req.Data)
+	},
+	"AcquireLease": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		lease, acquired, err := db.AcquireLease(ctx, req.Name, req.Lease)
+		return &reply{Lease: lease, Acquired: acquired}, err
+	},
+	"ReleaseLease": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.ReleaseLease(ctx, req.Name, req.Lease.Owner)
+	},
+	"EnqueueValues": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		return new(reply), db.EnqueueValues(ctx, req.Name, req.Values)
+	},
+	"DequeueValues": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		values, err := db.DequeueValues(ctx, req.Name, req.Limit)
+		return &reply{Values: values}, err
+	},
+	"QueuedValues": func(ctx context.Context, db diviner.Database, req *request) (*reply, error) {
+		values, err := db.QueuedValues(ctx, req.Name)
+		return &reply{Values: values}, err
+	},
+}
+
+// Register registers a divinerdb.Database service, serving the
+// provided database, with the provided gRPC server.
+func Register(s *grpc.Server, db diviner.Database) {
+	desc := &grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*diviner.Database)(nil),
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Log", Handler: serveLog, ServerStreams: true},
+			{StreamName: "Scan", Handler: serveScan, ServerStreams: true},
+			{StreamName: "Logger", Handler: serveLogger, ClientStreams: true},
+		},
+	}
+	for name, m := range methods {
+		m := m
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: name,
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := new(request)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					reply, err := m(ctx, srv.(diviner.Database), req.(*request))
+					return reply, toStatus(err)
+				}
+				if interceptor == nil {
+					return handler(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+				return interceptor(ctx, req, info, handler)
+			},
+		})
+	}
+	s.RegisterService(desc, db)
+}
+
+// ServeLog streams the logs of the requested run to the client in
+// chunks.
+func serveLog(srv interface{}, stream grpc.ServerStream) error {
+	req := new(request)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	var (
+		r   = srv.(diviner.Database).Log(req.Name, req.Seq, req.Since, req.Follow)
+		buf = make([]byte, logChunkSize)
+	)
+	for {
+		// Reads of followed logs may block indefinitely; we give up
+		// only once the client has gone away.
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&reply{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// ServeScan streams the requested runs to the client in batches of
+// scanBatchSize runs.
+func serveScan(srv interface{}, stream grpc.ServerStream) error {
+	req := new(request)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	it := srv.(diviner.Database).Scan(stream.Context(), req.Name, req.State)
+	defer it.Close()
+	var runs []diviner.Run
+	for it.Next() {
+		runs = append(runs, it.Run())
+		if len(runs) == scanBatchSize {
+			if err := stream.SendMsg(&reply{Runs: runs}); err != nil {
+				return err
+			}
+			runs = nil
+		}
+	}
+	if err := it.Err(); err != nil {
+		return toStatus(err)
+	}
+	if len(runs) > 0 {
+		return stream.SendMsg(&reply{Runs: runs})
+	}
+	return nil
+}
+
+// ServeLogger writes log chunks received from the client to the logger
+// of the run named in the stream's first message.
+func serveLogger(srv interface{}, stream grpc.ServerStream) error {
+	req := new(request)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	w := srv.(diviner.Database).Logger(req.Name, req.Seq)
+	for {
+		if len(req.Data) > 0 {
+			if _, err := w.Write(req.Data); err != nil {
+				w.Close()
+				return toStatus(err)
+			}
+		}
+		req = new(request)
+		if err := stream.RecvMsg(req); err == io.EOF {
+			break
+		} else if err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return toStatus(err)
+	}
+	return stream.SendMsg(new(reply))
+}
+
+// toStatus converts database errors into gRPC status errors so that
+// they can be reconstituted by the client.
+func toStatus(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case diviner.ErrNotExist:
+		return status.Error(codes.NotFound, err.Error())
+	case diviner.ErrArchived:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
@@ -0,0 +1,367 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package kubernetes implements a diviner.Backend that runs scripts
+// as Kubernetes jobs. The backend drives the cluster through kubectl,
+// which must be installed and configured with access to the cluster.
+//
+// Each script is run in a single-pod job whose container runs the
+// script with Bash in a scratch working directory. Files are
+// provided to the job through a config map, which is copied into the
+// working directory before the script is run. The job's resource
+// requirements are translated into container resource requests and
+// limits; GPUs are requested as "nvidia.com/gpu". The pod's logs are
+// streamed back to the caller as the job's output.
+//
+// Jobs and their config maps are deleted once they complete, fail,
+// or are canceled.
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+)
+
+const (
+	// workDir is the working directory of job scripts.
+	workDir = "/diviner/work"
+	// filesDir is the directory at which the job's config map is
+	// mounted.
+	filesDir = "/diviner/files"
+
+	// maxNameLen is the maximum length of job names, excluding their
+	// unique suffix. Pod names derived from job names are limited to
+	// 63 characters.
+	maxNameLen = 40
+
+	defaultPollInterval = 5 * time.Second
+	defaultStartTimeout = 30 * time.Minute
+)
+
+func init() {
+	gob.Register(new(Backend))
+}
+
+// Backend implements diviner.Backend by running jobs on a Kubernetes
+// cluster.
+type Backend struct {
+	// Image is the container image in which scripts are run, unless
+	// a job provides its own. It must provide Bash.
+	Image string
+	// Namespace is the namespace in which jobs are created. If empty,
+	// the current kubectl context's namespace is used.
+	Namespace string
+	// Context is the kubectl context used to access the cluster. If
+	// empty, the current context is used.
+	Context string
+	// NodeSelector constrains the nodes on which jobs' pods are
+	// scheduled to those with the given labels.
+	NodeSelector map[string]string
+	// ServiceAccount is the service account under which jobs' pods
+	// are run.
+	ServiceAccount string
+	// Kubectl is the path of the kubectl binary. Defaults to
+	// "kubectl".
+	Kubectl string
+	// PollInterval is the interval at which the job's status is
+	// polled once its logs have been consumed. Defaults to 5 seconds.
+	PollInterval time.Duration
+	// StartTimeout is the maximum amount of time to wait for a job's
+	// pod to start running. Defaults to 30 minutes.
+	StartTimeout time.Duration
+}
+
+var _ diviner.Backend = (*Backend)(nil)
+
+// Run implements diviner.Backend. It creates a Kubernetes job to run
+// the provided job and returns a reader of its pod's logs. Reads
+// return an error, carrying the script's exit code, if the job
+// failed.
+func (b *Backend) Run(ctx context.Context, job diviner.Job) (io.ReadCloser, error) {
+	if b.Image == "" && job.Image == "" {
+		return nil, fmt.Errorf("kubernetes: no image defined for job %s", job.Name)
+	}
+	name, err := jobName(job.Name)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := json.Marshal(b.manifest(name, job))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.kubectl(ctx, bytes.NewReader(manifest), "create", "-f", "-"); err != nil {
+		b.delete(name)
+		return nil, fmt.Errorf("kubernetes: create job %s: %v", name, err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	go func() {
+		defer cancel()
+		err := b.wait(ctx, name, w)
+		b.delete(name)
+		w.CloseWithError(err)
+	}()
+	return &logReader{r, cancel}, nil
+}
+
+// Wait streams the logs of the named job to w and then waits for the
+// job to complete, returning an error if it failed.
+func (b *Backend) wait(ctx context.Context, name string, w io.Writer) error {
+	startTimeout := b.StartTimeout
+	if startTimeout <= 0 {
+		startTimeout = defaultStartTimeout
+	}
+	var stderr bytes.Buffer
+	cmd := b.command(ctx, "logs", "--follow", "--pod-running-timeout="+startTimeout.String(), "job/"+name)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Logs may fail to stream, e.g., if the pod fails to start;
+		// the job's status tells us whether it failed.
+		log.Error.Printf("kubernetes: logs %s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	poll := b.PollInterval
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+	for {
+		out, err := b.kubectl(ctx, nil, "get", "job", name, "--output=jsonpath={.status.succeeded},{.status.failed}")
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("kubernetes: job %s: %v", name, err)
+		}
+		succeeded, failed := parseStatus(out)
+		switch {
+		case succeeded > 0:
+			return nil
+		case failed > 0:
+			return b.failure(ctx, name)
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Failure returns an error describing the failure of the named job.
+func (b *Backend) failure(ctx context.Context, name string) error {
+	out, err := b.kubectl(ctx, nil, "get", "pods", "--selector=job-name="+name,
+		"--output=jsonpath={.items[0].status.containerStatuses[0].state.terminated.exitCode}")
+	if code := strings.TrimSpace(out); err == nil && code != "" {
+		return fmt.Errorf("job %s failed with exit code %s", name, code)
+	}
+	return fmt.Errorf("job %s failed", name)
+}
+
+// Delete deletes the named job, its pods, and its config map. Delete
+// is called also for jobs that have been canceled, and so uses a
+// fresh context.
+func (b *Backend) delete(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := b.kubectl(ctx, nil, "delete", "job,configmap", name, "--ignore-not-found", "--wait=false"); err != nil {
+		log.Error.Printf("kubernetes: delete job %s: %v", name, err)
+	}
+}
+
+// Command returns a kubectl command with the provided arguments,
+// using the backend's context and namespace.
+func (b *Backend) command(ctx context.Context, args ...string) *exec.Cmd {
+	kubectl := b.Kubectl
+	if kubectl == "" {
+		kubectl = "kubectl"
+	}
+	var global []string
+	if b.Context != "" {
+		global = append(global, "--context="+b.Context)
+	}
+	if b.Namespace != "" {
+		global = append(global, "--namespace="+b.Namespace)
+	}
+	return exec.CommandContext(ctx, kubectl, append(global, args...)...)
+}
+
+// Kubectl runs kubectl with the provided standard input and
+// arguments, returning its standard output.
+func (b *Backend) kubectl(ctx context.Context, stdin io.Reader, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := b.command(ctx, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("kubectl %s: %v: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("kubectl %s: %v", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// Manifest returns the manifest of the Kubernetes objects needed to
+// run the provided job under the given name: a config map containing
+// the job's files, and the job itself.
+func (b *Backend) manifest(name string, job diviner.Job) object {
+	labels := object{"app.kubernetes.io/managed-by": "diviner"}
+	env := make([]object, 0, len(job.Env))
+	for _, kv := range job.Env {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		env = append(env, object{"name": kv[0], "value": kv[1]})
+	}
+	script := job.Script
+	if len(job.Files) > 0 {
+		// Config map keys are mounted as symbolic links.
+		script = fmt.Sprintf("cp -L %s/* . && %s", filesDir, script)
+	}
+	image := b.Image
+	if job.Image != "" {
+		image = job.Image
+	}
+	container := object{
+		"name":       "diviner",
+		"image":      image,
+		"command":    []string{"bash", "-c", script},
+		"workingDir": workDir,
+		"env":        env,
+		"volumeMounts": []object{
+			{"name": "work", "mountPath": workDir},
+			{"name": "files", "mountPath": filesDir},
+		},
+	}
+	if quantities := resourceQuantities(job.Resources); len(quantities) > 0 {
+		container["resources"] = object{"requests": quantities, "limits": quantities}
+	}
+	pod := object{
+		"restartPolicy": "Never",
+		"containers":    []object{container},
+		"volumes": []object{
+			{"name": "work", "emptyDir": object{}},
+			{"name": "files", "configMap": object{"name": name}},
+		},
+	}
+	if len(b.NodeSelector) > 0 {
+		pod["nodeSelector"] = b.NodeSelector
+	}
+	if b.ServiceAccount != "" {
+		pod["serviceAccountName"] = b.ServiceAccount
+	}
+	return object{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items": []object{
+			{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   object{"name": name, "labels": labels},
+				"binaryData": job.Files,
+			},
+			{
+				"apiVersion": "batch/v1",
+				"kind":       "Job",
+				"metadata": object{
+					"name":        name,
+					"labels":      labels,
+					"annotations": object{"diviner/job": job.Name},
+				},
+				"spec": object{
+					"backoffLimit": 0,
+					"template": object{
+						"metadata": object{"labels": labels},
+						"spec":     pod,
+					},
+				},
+			},
+		},
+	}
+}
+
+// An object is a Kubernetes object, or part of one, to be serialized
+// as JSON.
+type object map[string]interface{}
+
+// ResourceQuantities returns the Kubernetes resource quantities
+// corresponding to the provided resources.
+func resourceQuantities(r diviner.Resources) map[string]string {
+	quantities := make(map[string]string)
+	if r.CPU > 0 {
+		quantities["cpu"] = strconv.Itoa(r.CPU)
+	}
+	if r.Memory > 0 {
+		quantities["memory"] = strconv.FormatInt(int64(r.Memory), 10)
+	}
+	if r.GPU > 0 {
+		quantities["nvidia.com/gpu"] = strconv.Itoa(r.GPU)
+	}
+	return quantities
+}
+
+// JobName returns a unique Kubernetes object name derived from the
+// provided job name. Kubernetes names must be DNS-1123 labels:
+// lower case alphanumeric characters or '-', beginning and ending
+// with an alphanumeric character.
+func jobName(name string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	name = b.String()
+	if len(name) > maxNameLen {
+		name = name[:maxNameLen]
+	}
+	name = strings.Trim(name, "-")
+	if name == "" {
+		name = "diviner"
+	}
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	return name + "-" + hex.EncodeToString(suffix[:]), nil
+}
+
+// ParseStatus parses the succeeded and failed counts of a job, as
+// printed by kubectl's jsonpath output.
+func parseStatus(out string) (succeeded, failed int) {
+	parts := strings.SplitN(strings.TrimSpace(out), ",", 2)
+	succeeded, _ = strconv.Atoi(parts[0])
+	if len(parts) > 1 {
+		failed, _ = strconv.Atoi(parts[1])
+	}
+	return
+}
+
+// LogReader reads a job's logs. Closing it cancels the job.
+type logReader struct {
+	*io.PipeReader
+	cancel func()
+}
+
+func (r *logReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package kubernetes_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/kubernetes"
+	"github.com/grailbio/testutil"
+)
+
+// FakeKubectl is a kubectl replacement that records its invocations
+// and manifests, and reports job status from the file "status".
+const fakeKubectl = `#!/bin/bash
+dir=%s
+echo "$@" >> $dir/log
+while [[ $1 == --* ]]; do shift; done
+case "$1" in
+create) cat > $dir/manifest.json ;;
+logs) echo hello world; echo METRICS: acc=0.5 ;;
+get)
+	case "$2" in
+	job) cat $dir/status ;;
+	pods) echo 3 ;;
+	esac ;;
+esac
+`
+
+func newBackend(t *testing.T, status string) (b *kubernetes.Backend, dir string, cleanup func()) {
+	t.Helper()
+	dir, cleanup = testutil.TempDir(t, "", "")
+	kubectl := filepath.Join(dir, "kubectl")
+	if err := ioutil.WriteFile(kubectl, []byte(fmt.Sprintf(fakeKubectl, dir)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b = &kubernetes.Backend{
+		Image:        "ubuntu:18.04",
+		Namespace:    "ml",
+		NodeSelector: map[string]string{"accelerator": "nvidia-tesla-v100"},
+		Kubectl:      kubectl,
+		PollInterval: time.Millisecond,
+	}
+	return b, dir, cleanup
+}
+
+func TestBackend(t *testing.T) {
+	b, dir, cleanup := newBackend(t, "1,")
+	defer cleanup()
+	job := diviner.Job{
+		Name:      "study=test,seq=1",
+		Script:    "set -ex; echo hello world",
+		Env:       []string{"DIVINER_TEST_COUNT=0"},
+		Files:     map[string][]byte{"data.txt": []byte("data")},
+		Resources: diviner.Resources{CPU: 2, Memory: 4 * data.GiB, GPU: 1},
+	}
+	out, err := b.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), "hello world\nMETRICS: acc=0.5\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	p, err = ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest struct {
+		Items []struct {
+			Kind     string
+			Metadata struct{ Name string }
+			Data     map[string]string `json:"binaryData"`
+			Spec     struct {
+				BackoffLimit int
+				Template     struct {
+					Spec struct {
+						RestartPolicy string
+						NodeSelector  map[string]string
+						Containers    []struct {
+							Image     string
+							Command   []string
+							Env       []struct{ Name, Value string }
+							Resources struct {
+								Requests map[string]string
+								Limits   map[string]string
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(p, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(manifest.Items), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	configMap, k8sJob := manifest.Items[0], manifest.Items[1]
+	if got, want := configMap.Kind, "ConfigMap"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := configMap.Data["data.txt"], "ZGF0YQ=="; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := k8sJob.Kind, "Job"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	name := k8sJob.Metadata.Name
+	if got, want := name, configMap.Metadata.Name; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !strings.HasPrefix(name, "study-test-seq-1-") {
+		t.Errorf("bad job name %s", name)
+	}
+	spec := k8sJob.Spec.Template.Spec
+	if got, want := spec.RestartPolicy, "Never"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := spec.NodeSelector["accelerator"], "nvidia-tesla-v100"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(spec.Containers), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	container := spec.Containers[0]
+	if got, want := container.Image, "ubuntu:18.04"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := strings.Join(container.Command, " "), "bash -c cp -L /diviner/files/* . && set -ex; echo hello world"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := fmt.Sprint(container.Env), "[{DIVINER_TEST_COUNT 0}]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, quantities := range []map[string]string{container.Resources.Requests, container.Resources.Limits} {
+		if got, want := fmt.Sprint(quantities), "map[cpu:2 memory:4294967296 nvidia.com/gpu:1]"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	p, err = ioutil.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if got, want := log[0], "--namespace=ml create -f -"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := log[len(log)-1], fmt.Sprintf("--namespace=ml delete job,configmap %s --ignore-not-found --wait=false", name); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBackendFailure(t *testing.T) {
+	b, _, cleanup := newBackend(t, ",1")
+	defer cleanup()
+	out, err := b.Run(context.Background(), diviner.Job{Name: "test", Script: "exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	_, err = ioutil.ReadAll(out)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := err.Error(), "failed with exit code 3"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %v, want suffix %v", got, want)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Labels are arbitrary key/value pairs attached to runs, with which
+// users mark runs for later retrieval, e.g., "baseline", "gpu=a100",
+// or "owner=alice". Labels without a value (e.g., "baseline") are
+// represented by an empty value.
+type Labels map[string]string
+
+// ParseLabels parses a comma-separated list of labels of the form
+// "key=value" or "key".
+func ParseLabels(s string) (Labels, error) {
+	labels := make(Labels)
+	if s == "" {
+		return labels, nil
+	}
+	for _, elem := range strings.Split(s, ",") {
+		kv := strings.SplitN(elem, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if err := validLabelKey(key); err != nil {
+			return nil, err
+		}
+		var value string
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// String returns the labels as a sorted, comma-separated list,
+// as parsed by ParseLabels.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	elems := make([]string, len(keys))
+	for i, key := range keys {
+		elems[i] = key
+		if value := l[key]; value != "" {
+			elems[i] += "=" + value
+		}
+	}
+	return strings.Join(elems, ",")
+}
+
+// SetLabel sets the label key to the provided value.
+func (r *Run) SetLabel(key, value string) {
+	if r.Labels == nil {
+		r.Labels = make(Labels)
+	}
+	r.Labels[key] = value
+}
+
+// validLabelKey returns an error if key may not be used as a label
+// key.
+func validLabelKey(key string) error {
+	if key == "" {
+		return errors.New("empty label key")
+	}
+	if strings.ContainsAny(key, "=!, \t\n") {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	return nil
+}
+
+// A Selector selects runs by their labels. A selector comprises a
+// set of requirements, all of which must be met by a run's labels
+// for it to be selected. The zero Selector selects all runs.
+type Selector struct {
+	reqs []requirement
+}
+
+type requirement struct {
+	key, value string
+	hasValue   bool
+	negate     bool
+}
+
+func (r requirement) matches(labels Labels) bool {
+	value, ok := labels[r.key]
+	if r.hasValue {
+		ok = ok && value == r.value
+	}
+	return ok != r.negate
+}
+
+func (r requirement) String() string {
+	switch {
+	case r.hasValue && r.negate:
+		return r.key + "!=" + r.value
+	case r.hasValue:
+		return r.key + "=" + r.value
+	case r.negate:
+		return "!" + r.key
+	default:
+		return r.key
+	}
+}
+
+// ParseSelector parses a selector from a comma-separated list of
+// requirements. Each requirement is one of:
+//
+//	key          the run has label key, with any value;
+//	!key         the run does not have label key;
+//	key=value    the run's label key has the given value;
+//	key!=value   the run's label key is absent or has another value.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, elem := range strings.Split(s, ",") {
+		var req requirement
+		elem = strings.TrimSpace(elem)
+		switch {
+		case strings.Contains(elem, "!="):
+			kv := strings.SplitN(elem, "!=", 2)
+			req = requirement{key: kv[0], value: kv[1], hasValue: true, negate: true}
+		case strings.Contains(elem, "="):
+			kv := strings.SplitN(elem, "=", 2)
+			req = requirement{key: kv[0], value: kv[1], hasValue: true}
+		case strings.HasPrefix(elem, "!"):
+			req = requirement{key: elem[1:], negate: true}
+		default:
+			req = requirement{key: elem}
+		}
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+		if err := validLabelKey(req.key); err != nil {
+			return Selector{}, fmt.Errorf("selector %q: %v", s, err)
+		}
+		sel.reqs = append(sel.reqs, req)
+	}
+	return sel, nil
+}
+
+// IsEmpty tells whether the selector has no requirements, and thus
+// selects all runs.
+func (s Selector) IsEmpty() bool {
+	return len(s.reqs) == 0
+}
+
+// Matches tells whether the provided labels satisfy the selector.
+func (s Selector) Matches(labels Labels) bool {
+	for _, req := range s.reqs {
+		if !req.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the selector in the syntax parsed by ParseSelector.
+func (s Selector) String() string {
+	elems := make([]string, len(s.reqs))
+	for i, req := range s.reqs {
+		elems[i] = req.String()
+	}
+	return strings.Join(elems, ",")
+}
+
+// SelectRuns returns the runs in the provided study that match the
+// queried run states and label selector, and that have been updated
+// since the provided time. It is like Database.ListRuns, but filters
+// runs by their labels.
+func SelectRuns(ctx context.Context, db Database, study string, states RunState, since time.Time, sel Selector) ([]Run, error) {
+	runs, err := db.ListRuns(ctx, study, states, since)
+	if err != nil || sel.IsEmpty() {
+		return runs, err
+	}
+	var n int
+	for _, run := range runs {
+		if sel.Matches(run.Labels) {
+			runs[n] = run
+			n++
+		}
+	}
+	return runs[:n], nil
+}
+
+// UpdateLabels updates the labels of the run named by the provided
+// study and sequence number: the labels in set are added to the run,
+// replacing existing labels with the same keys, and the labels keyed
+// by remove are removed from it. UpdateLabels returns the run's new
+// labels.
+//
+// UpdateLabels reads the run's labels before replacing them; thus
+// concurrent updates of the same run's labels may be lost.
+func UpdateLabels(ctx context.Context, db Database, study string, seq uint64, set Labels, remove []string) (Labels, error) {
+	run, err := db.LookupRun(ctx, study, seq)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(Labels, len(run.Labels)+len(set))
+	for key, value := range run.Labels {
+		labels[key] = value
+	}
+	for key, value := range set {
+		if err := validLabelKey(key); err != nil {
+			return nil, err
+		}
+		labels[key] = value
+	}
+	for _, key := range remove {
+		delete(labels, key)
+	}
+	return labels, db.SetRunLabels(ctx, study, seq, labels)
+}
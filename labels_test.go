@@ -0,0 +1,66 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestLabels(t *testing.T) {
+	labels, err := diviner.ParseLabels("owner=alice, baseline,gpu=a100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := labels.String(), "baseline,gpu=a100,owner=alice"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := diviner.ParseLabels("a,=b"); err == nil {
+		t.Error("expected error")
+	}
+	var run diviner.Run
+	run.SetLabel("owner", "bob")
+	if got, want := run.Labels.String(), "owner=bob"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSelector(t *testing.T) {
+	labels := diviner.Labels{"baseline": "", "gpu": "a100", "owner": "alice"}
+	for _, c := range []struct {
+		selector string
+		match    bool
+	}{
+		{"", true},
+		{"baseline", true},
+		{"!baseline", false},
+		{"gpu=a100", true},
+		{"gpu=v100", false},
+		{"gpu!=v100", true},
+		{"owner!=alice", false},
+		{"baseline,gpu=a100,owner=alice", true},
+		{"baseline,gpu=a100,owner=bob", false},
+		{"!experimental,owner=alice", true},
+		{"missing", false},
+		{"missing!=x", true},
+	} {
+		sel, err := diviner.ParseSelector(c.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sel.Matches(labels), c.match; got != want {
+			t.Errorf("%s: got %v, want %v", c.selector, got, want)
+		}
+		if got, want := sel.String(), c.selector; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	for _, bad := range []string{"=a100", "!", "a,,b"} {
+		if _, err := diviner.ParseSelector(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// Leaderboard returns the k best successful trials of the named study,
+// ordered from best to worst according to the provided objective.
+// Trials that do not report the objective's metric are omitted. If k
+// <= 0, all such trials are returned.
+//
+// Leaderboard retrieves the study's best runs with Database.BestRuns,
+// so that databases that index runs' metrics need not read the
+// study's other runs. When several runs share values, the trial is
+// represented by the best of them. Trials of studies with replicates
+// (Study.Replicates) average their replicates' metrics, as in Trials;
+// their leaderboards are computed from all of the study's successful
+// runs, as are the leaderboards of studies that aggregate their
+// metrics (Study.Aggregate) or constrain them (Study.Constraints).
+// Infeasible trials are omitted.
+func Leaderboard(ctx context.Context, db Database, study string, objective Objective, k int) ([]Trial, error) {
+	s, err := db.LookupStudy(ctx, study)
+	if err != nil {
+		return nil, err
+	}
+	if s.Replicates > 0 || len(s.Aggregate) > 0 || len(s.Constraints) > 0 {
+		return replicatedLeaderboard(ctx, db, s, objective, k)
+	}
+	// Runs that share values may crowd a trial out of the k best runs,
+	// so we widen the search until k distinct trials are found.
+	n := k
+	for {
+		runs, err := db.BestRuns(ctx, study, objective, n)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			trials []Trial
+			seen   = NewMap()
+		)
+		for _, run := range runs {
+			if _, ok := seen.Get(run.Values); ok {
+				continue
+			}
+			seen.Put(run.Values, true)
+			trials = append(trials, run.Trial())
+			if k > 0 && len(trials) == k {
+				return trials, nil
+			}
+		}
+		if n <= 0 || len(runs) < n {
+			return trials, nil
+		}
+		n *= 2
+	}
+}
+
+// ReplicatedLeaderboard returns the k best successful trials of the
+// provided study, whose replicates are combined as in Trials.
+func replicatedLeaderboard(ctx context.Context, db Database, study Study, objective Objective, k int) ([]Trial, error) {
+	m, err := Trials(ctx, db, study, Success)
+	if err != nil {
+		return nil, err
+	}
+	var trials []Trial
+	m.Range(func(_ Value, v interface{}) {
+		trial := v.(Trial)
+		if v, ok := trial.Metrics[objective.Metric]; ok && !math.IsNaN(v) && !trial.Infeasible {
+			trials = append(trials, trial)
+		}
+	})
+	sort.SliceStable(trials, func(i, j int) bool {
+		iv, jv := trials[i].Metrics[objective.Metric], trials[j].Metrics[objective.Metric]
+		if iv != jv {
+			return better(objective, iv, jv)
+		}
+		return firstSeq(trials[i]) < firstSeq(trials[j])
+	})
+	if k > 0 && len(trials) > k {
+		trials = trials[:k]
+	}
+	return trials, nil
+}
+
+// RankRuns returns the k best successful runs of the provided study
+// according to the provided objective, ordered as by SortRuns. Runs
+// that do not report the objective's metric are omitted. If k <= 0,
+// all such runs are returned. RankRuns scans all of the study's
+// successful runs; it is used by databases that do not index runs'
+// metrics to implement BestRuns.
+func RankRuns(ctx context.Context, db Database, study string, objective Objective, k int) ([]Run, error) {
+	runs, err := QueryRuns(ctx, db, study, Query{States: Success})
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	for _, run := range runs {
+		if v, ok := run.Trial().Metrics[objective.Metric]; ok && !math.IsNaN(v) {
+			runs[n] = run
+			n++
+		}
+	}
+	runs = runs[:n]
+	SortRuns(runs, objective)
+	if k > 0 && len(runs) > k {
+		runs = runs[:k]
+	}
+	return runs, nil
+}
+
+// SortRuns sorts the provided runs from best to worst according to
+// their latest values of the objective's metric. Runs with equal
+// values are ordered by their sequence numbers; runs that do not
+// report the metric, or whose metric is NaN, are ordered last.
+func SortRuns(runs []Run, objective Objective) {
+	sort.SliceStable(runs, func(i, j int) bool {
+		iv, iok := runs[i].Trial().Metrics[objective.Metric]
+		jv, jok := runs[j].Trial().Metrics[objective.Metric]
+		iok = iok && !math.IsNaN(iv)
+		jok = jok && !math.IsNaN(jv)
+		switch {
+		case !iok || !jok:
+			return iok && !jok
+		case iv != jv:
+			return better(objective, iv, jv)
+		default:
+			return runs[i].Seq < runs[j].Seq
+		}
+	})
+}
+
+// FirstSeq returns the smallest sequence number of the runs
+// comprising the provided trial.
+func firstSeq(trial Trial) uint64 {
+	var seq uint64
+	for _, run := range trial.Runs {
+		if seq == 0 || run.Seq < seq {
+			seq = run.Seq
+		}
+	}
+	return seq
+}
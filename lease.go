@@ -0,0 +1,97 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+// A Lease records a process's ownership of a study: while a runner
+// holds a study's lease, it is the only runner that performs the
+// study's trials, and that resumes the study's failed (or orphaned)
+// runs. Leases expire unless they are renewed, so that the studies of
+// runners that die are taken over by the next runner to acquire
+// them. See Database.AcquireLease.
+//
+// Lease expiry is judged by the clock of the process that acquires
+// the lease, so the clocks of the machines that share a database
+// should be synchronized to well within the leases' TTLs.
+type Lease struct {
+	// Owner uniquely identifies the lease's owner, e.g., a runner
+	// process.
+	Owner string `json:"owner"`
+	// Host and PID identify the owner's process, so that its users
+	// can find it.
+	Host string `json:"host,omitempty"`
+	PID  int    `json:"pid,omitempty"`
+	// Acquired is the time at which the owner acquired the lease.
+	Acquired time.Time `json:"acquired"`
+	// Expires is the time at which the lease expires, unless it is
+	// renewed.
+	Expires time.Time `json:"expires"`
+}
+
+// NewLease returns a new lease, owned by a new, unique owner in the
+// current process, that expires after the provided TTL.
+func NewLease(ttl time.Duration) (Lease, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Lease{}, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	now := time.Now()
+	return Lease{
+		Owner:    hex.EncodeToString(id[:]),
+		Host:     host,
+		PID:      os.Getpid(),
+		Acquired: now,
+		Expires:  now.Add(ttl),
+	}, nil
+}
+
+// Renew returns a copy of the lease that expires after the provided
+// TTL.
+func (l Lease) Renew(ttl time.Duration) Lease {
+	l.Expires = time.Now().Add(ttl)
+	return l
+}
+
+// Expired tells whether the lease has expired at the provided time.
+func (l Lease) Expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// Take returns the lease of a study that results from l's owner
+// acquiring the study, whose lease is currently held (the zero Lease
+// if the study is not leased), and whether the acquisition succeeded.
+// The lease is acquired if the study is not leased, if its lease has
+// expired at the provided time, or if it is held by l's owner, in
+// which case the lease is renewed: the held lease's acquisition time
+// is retained. Otherwise the held lease is returned. Take implements
+// the semantics of Database.AcquireLease.
+func (l Lease) Take(held Lease, now time.Time) (Lease, bool) {
+	switch {
+	case held.Owner == l.Owner:
+		l.Acquired = held.Acquired
+		return l, true
+	case held.Owner == "" || held.Expired(now):
+		return l, true
+	default:
+		return held, false
+	}
+}
+
+// String returns a textual description of the lease's owner.
+func (l Lease) String() string {
+	return fmt.Sprintf("pid %d on %s since %s (lease %s, expires %s)",
+		l.PID, l.Host, l.Acquired.Format(time.RFC3339), l.Owner, l.Expires.Format(time.RFC3339))
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestLease(t *testing.T) {
+	now := time.Now()
+	lease, err := diviner.NewLease(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lease.PID, os.Getpid(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if lease.Expired(now) || !lease.Expired(now.Add(2*time.Minute)) {
+		t.Errorf("lease %v: bad expiry", lease)
+	}
+	other, err := diviner.NewLease(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Owner == other.Owner {
+		t.Fatal("owners are not unique")
+	}
+
+	if held, ok := lease.Take(diviner.Lease{}, now); !ok || held != lease {
+		t.Errorf("got %v, %v, want %v, true", held, ok, lease)
+	}
+	if held, ok := lease.Take(other, now); ok || held != other {
+		t.Errorf("got %v, %v, want %v, false", held, ok, other)
+	}
+	// Expired leases are taken over.
+	if held, ok := lease.Take(other, now.Add(2*time.Minute)); !ok || held != lease {
+		t.Errorf("got %v, %v, want %v, true", held, ok, lease)
+	}
+	// Renewals retain the lease's acquisition time.
+	renewed := lease.Renew(time.Hour)
+	renewed.Acquired = now.Add(time.Second)
+	held, ok := renewed.Take(lease, now)
+	if !ok {
+		t.Fatal("lease not renewed")
+	}
+	if got, want := held.Acquired, lease.Acquired; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := held.Expires, renewed.Expires; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Runs may be derived from other runs of their study: a retry (see
+// RetryPolicy) continues the previous attempt of a failed, timed-out,
+// or preempted run, resuming from its checkpoint, if any; and a run
+// forked by an Exploiter continues from the values and checkpoint of
+// the run it exploits. Derived runs record the run from which they
+// were derived in Run.Parent. Following these links traces how a
+// run, e.g., the one that produced a study's best model, came to be.
+
+// Derivation describes how the run was derived from its parent:
+// "retry" for retries, "fork" for runs forked by an Exploiter, and
+// the empty string for runs that were not derived from another run.
+func (r Run) Derivation() string {
+	switch {
+	case r.Parent == 0:
+		return ""
+	case r.RetryOf != 0:
+		return "retry"
+	default:
+		return "fork"
+	}
+}
+
+// Lineage returns the ancestry of the named run: the run, its
+// parent, its parent's parent, and so on, ordered from the earliest
+// ancestor to the run itself. Lineages end early at ancestors that
+// have since been deleted.
+func Lineage(ctx context.Context, db Database, study string, seq uint64) ([]Run, error) {
+	run, err := db.LookupRun(ctx, study, seq)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lineage = []Run{run}
+		seen    = map[uint64]bool{seq: true}
+	)
+	for run.Parent != 0 && !seen[run.Parent] {
+		seen[run.Parent] = true
+		run, err = db.LookupRun(ctx, study, run.Parent)
+		if err == ErrNotExist {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lineage = append(lineage, run)
+	}
+	for i, j := 0, len(lineage)-1; i < j; i, j = i+1, j-1 {
+		lineage[i], lineage[j] = lineage[j], lineage[i]
+	}
+	return lineage, nil
+}
+
+// Descendants returns the runs that were derived, directly or
+// indirectly, from the named run, ordered by sequence number.
+func Descendants(ctx context.Context, db Database, study string, seq uint64) ([]Run, error) {
+	if _, err := db.LookupRun(ctx, study, seq); err != nil {
+		return nil, err
+	}
+	runs, err := db.ListRuns(ctx, study, Any, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[uint64][]Run)
+	for _, run := range runs {
+		if run.Parent != 0 {
+			children[run.Parent] = append(children[run.Parent], run)
+		}
+	}
+	var (
+		descendants []Run
+		seen        = map[uint64]bool{seq: true}
+		queue       = []uint64{seq}
+	)
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, child := range children[parent] {
+			if seen[child.Seq] {
+				continue
+			}
+			seen[child.Seq] = true
+			descendants = append(descendants, child)
+			queue = append(queue, child.Seq)
+		}
+	}
+	sort.Slice(descendants, func(i, j int) bool {
+		return descendants[i].Seq < descendants[j].Seq
+	})
+	return descendants, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestLineage(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	// 1 is retried by 2, which is retried by 3; 4 is forked from 3,
+	// and 5 from 1. 6 is unrelated.
+	for _, run := range []diviner.Run{
+		{Attempt: 1},
+		{RetryOf: 1, Attempt: 2, Parent: 1},
+		{RetryOf: 1, Attempt: 3, Parent: 2},
+		{Attempt: 1, Parent: 3},
+		{Attempt: 1, Parent: 1},
+		{Attempt: 1},
+	} {
+		run.Study = "test"
+		if _, err := db.InsertRun(ctx, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seqs := func(runs []diviner.Run) []uint64 {
+		var seqs []uint64
+		for _, run := range runs {
+			seqs = append(seqs, run.Seq)
+		}
+		return seqs
+	}
+	for _, test := range []struct {
+		seq         uint64
+		lineage     []uint64
+		descendants []uint64
+	}{
+		{1, []uint64{1}, []uint64{2, 3, 4, 5}},
+		{3, []uint64{1, 2, 3}, []uint64{4}},
+		{4, []uint64{1, 2, 3, 4}, nil},
+		{6, []uint64{6}, nil},
+	} {
+		lineage, err := diviner.Lineage(ctx, db, "test", test.seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := seqs(lineage), test.lineage; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got %v, want %v", test.seq, got, want)
+		}
+		descendants, err := diviner.Descendants(ctx, db, "test", test.seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := seqs(descendants), test.descendants; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got %v, want %v", test.seq, got, want)
+		}
+	}
+	lineage, err := diviner.Lineage(ctx, db, "test", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var derivations []string
+	for _, run := range lineage {
+		derivations = append(derivations, run.Derivation())
+	}
+	if got, want := derivations, []string{"", "retry", "retry", "fork"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Lineages end at deleted ancestors.
+	if err := db.DeleteRun(ctx, "test", 2); err != nil {
+		t.Fatal(err)
+	}
+	if lineage, err := diviner.Lineage(ctx, db, "test", 4); err != nil {
+		t.Fatal(err)
+	} else if got, want := seqs(lineage), []uint64{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := diviner.Lineage(ctx, db, "test", 100); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
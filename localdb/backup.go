@@ -0,0 +1,85 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/grailbio/base/file"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent snapshot of the database to the provided
+// URL, e.g., s3://bucket/diviner.ddb, from which it may be restored
+// by Restore. The snapshot is written by a read transaction, which is
+// streamed to the URL, so that the database remains available for
+// writing while it is backed up. Backups are written through package
+// github.com/grailbio/base/file: URLs may be local paths, or URLs
+// whose schemes are registered with the package (as s3 is by the
+// diviner command). An existing backup at the URL is replaced only
+// once the new backup is complete.
+func (d *DB) Backup(ctx context.Context, url string) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		f, err := file.Create(ctx, url)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.WriteTo(f.Writer(ctx)); err != nil {
+			f.Discard(ctx)
+			return fmt.Errorf("localdb backup %s: %v", url, err)
+		}
+		return f.Close(ctx)
+	})
+}
+
+// Restore restores the database backed up (by Backup) to the provided
+// URL as a new database file with the provided name. The backup is
+// copied to a temporary file in the same directory, and checked to be
+// a readable database, before it is renamed, so that a failed restore
+// does not leave a partial database behind. Restore does not replace
+// existing files.
+func Restore(ctx context.Context, url, filename string) error {
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("localdb restore: %s already exists", filename)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := file.Open(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer f.Close(ctx)
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, f.Reader(ctx))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("localdb restore %s: %v", url, err)
+	}
+	db, err := OpenReadOnly(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("localdb restore %s: %v", url, err)
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/testutil"
+)
+
+func TestBackup(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "backup.ddb")
+	if err := db.Backup(ctx, backup); err != nil {
+		t.Fatal(err)
+	}
+	// Writes after the backup are not restored.
+	if _, err := db.InsertRun(ctx, diviner.Run{Study: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := filepath.Join(dir, "restored.ddb")
+	if err := localdb.Restore(ctx, backup, restored); err != nil {
+		t.Fatal(err)
+	}
+	db, err = localdb.Open(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.ListRuns(ctx, "test", diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := runs[0].Trial().Metrics["acc"], 0.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := localdb.Restore(ctx, backup, restored); err == nil {
+		t.Error("expected error restoring over an existing database")
+	}
+
+	// Invalid backups are not restored.
+	invalid := filepath.Join(dir, "invalid.ddb")
+	if err := ioutil.WriteFile(invalid, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	restored = filepath.Join(dir, "invalid-restored.ddb")
+	if err := localdb.Restore(ctx, invalid, restored); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := os.Stat(restored); !os.IsNotExist(err) {
+		t.Errorf("got %v, want not exist", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(files), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CompactTxSize is the number of bytes of keys and values copied by
+// each of a compaction's transactions.
+const compactTxSize = 64 << 20
+
+// Compact rewrites the database with the provided filename into a
+// fresh file, which replaces it, returning the sizes of the file
+// before and after compaction. Bolt files never shrink: the pages
+// freed by deleted runs, logs, and studies are reused by later
+// writes, but are not returned to the file system. Compact copies
+// only the database's live buckets (preserving their sequences, from
+// which runs are numbered), and thus reclaims the space of everything
+// that was deleted.
+//
+// The database must not be open while it is compacted. The compacted
+// database is written to a temporary file in the same directory, so
+// that an interrupted compaction leaves the original intact.
+func Compact(filename string) (before, after int64, err error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	src, err := bolt.Open(filename, 0666, &bolt.Options{ReadOnly: true, Timeout: readOnlyTimeout})
+	if err == bolt.ErrTimeout {
+		return 0, 0, fmt.Errorf("localdb %s: database is open by another process", filename)
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".compact")
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	dst, err := bolt.Open(tmp.Name(), info.Mode(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := compact(dst, src); err != nil {
+		dst.Close()
+		return 0, 0, fmt.Errorf("localdb compact %s: %v", filename, err)
+	}
+	if err := dst.Close(); err != nil {
+		return 0, 0, err
+	}
+	compacted, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), compacted.Size(), nil
+}
+
+// Compact copies the buckets of src into dst, committing a
+// transaction in dst for every compactTxSize bytes copied.
+func compact(dst, src *bolt.DB) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	var size int
+	// Keys and values are valid only during the source transaction,
+	// and must remain valid until they are committed.
+	return src.View(func(srcTx *bolt.Tx) error {
+		err := srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walk(b, nil, name, nil, func(path [][]byte, k, v []byte, seq uint64) error {
+				if size += len(k) + len(v); size > compactTxSize {
+					if err := tx.Commit(); err != nil {
+						tx = nil
+						return err
+					}
+					if tx, err = dst.Begin(true); err != nil {
+						tx = nil
+						return err
+					}
+					size = len(k) + len(v)
+				}
+				if len(path) == 0 {
+					b, err := tx.CreateBucket(k)
+					if err != nil {
+						return err
+					}
+					return b.SetSequence(seq)
+				}
+				b := tx.Bucket(path[0])
+				for _, name := range path[1:] {
+					b = b.Bucket(name)
+				}
+				if v != nil {
+					return b.Put(k, v)
+				}
+				b, err := b.CreateBucket(k)
+				if err != nil {
+					return err
+				}
+				return b.SetSequence(seq)
+			})
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Commit()
+		tx = nil
+		return err
+	})
+}
+
+// Walk calls fn for the key k, with value v, in the bucket with the
+// provided path, and, if k names the bucket b (i.e., v is nil), for
+// each of the keys in b, recursively. Buckets are visited before
+// their contents, together with their sequences.
+func walk(b *bolt.Bucket, path [][]byte, k, v []byte, fn func(path [][]byte, k, v []byte, seq uint64) error) error {
+	if v != nil {
+		return fn(path, k, v, 0)
+	}
+	if err := fn(path, k, nil, b.Sequence()); err != nil {
+		return err
+	}
+	path = append(path[:len(path):len(path)], k)
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return walk(b.Bucket(k), path, k, nil, fn)
+		}
+		return walk(b, path, k, v, fn)
+	})
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/testutil"
+)
+
+func TestCompact(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	var (
+		ctx      = context.Background()
+		filename = filepath.Join(dir, "test.ddb")
+	)
+	db, err := localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	const nrun = 20
+	// Logs are compressed, so they are made incompressible.
+	logs := make([][]byte, nrun)
+	for i := range logs {
+		p := make([]byte, 32<<10)
+		rand.Read(p)
+		logs[i] = []byte(hex.EncodeToString(p))
+	}
+	for i := 0; i < nrun; i++ {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+		w := db.Logger("test", run.Seq)
+		if _, err := w.Write(logs[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// All but the last run are deleted.
+	for seq := uint64(1); seq < nrun; seq++ {
+		if err := db.DeleteRun(ctx, "test", seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := diviner.ArchiveStudy(ctx, db, "test", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	before, after, err := localdb.Compact(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before {
+		t.Errorf("compaction did not reclaim space: %d bytes before, %d after", before, after)
+	}
+
+	db, err = localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	study, err := db.LookupStudy(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !study.Archived {
+		t.Error("study is not archived")
+	}
+	runs, err := db.ListRuns(ctx, "test", diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := runs[0].Seq, uint64(nrun); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := runs[0].Trial().Metrics["acc"], float64(nrun-1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	p, err := ioutil.ReadAll(db.Log("test", runs[0].Seq, time.Time{}, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), string(logs[nrun-1]); got != want {
+		t.Errorf("got %d bytes of logs, want %d", len(got), len(want))
+	}
+	// Sequence numbers are not reused.
+	if err := diviner.ArchiveStudy(ctx, db, "test", false); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Seq, uint64(nrun+1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Databases may encrypt the payloads of their runs' logs and metrics
+// with AES-GCM, so that the outputs of training jobs that handle
+// sensitive data are not readable from the database file, e.g., on a
+// shared disk. Everything else (studies, runs' metadata and values,
+// and the metric indexes) is stored in the clear.
+//
+// Encrypted payloads are stored as sealedPrefix, followed by a random
+// nonce and the ciphertext. The prefix is distinct from the first
+// byte of every unencrypted payload (log chunks begin with a
+// compression format's magic number or logchunk's raw prefix, and
+// metrics with diviner's encoding magic or a gob length), so that
+// databases may hold payloads written both before and after
+// encryption was enabled.
+//
+// Encrypted databases store, in their schema bucket, a check value
+// (a known plaintext sealed with the database's key) with which keys
+// are verified, and the KMS-wrapped data key of databases whose keys
+// are managed by KMS (see SetKMSKey). Once a database stores a check
+// value, its payloads cannot be written, nor its encrypted payloads
+// read, until its key is set.
+var (
+	sealedPrefix = []byte{0xde, 1}
+
+	keyCheckKey = []byte("keycheck")
+	dataKeyKey  = []byte("datakey")
+
+	keyCheck = []byte("diviner localdb key check")
+)
+
+// KeySize is the size, in bytes, of database keys (AES-256).
+const KeySize = 32
+
+// ErrNoKey is returned when the payloads of an encrypted database are
+// accessed before its key is set.
+var ErrNoKey = errors.New("localdb: database is encrypted, but no key is set")
+
+// ParseKey decodes a base64-encoded database key, e.g., as generated
+// by "openssl rand -base64 32".
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("localdb: invalid key: %v", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("localdb: invalid key: got %d bytes, want %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// UseKey sets the database's key as described by the provided
+// specification: either kms://keyid, naming the AWS KMS key (by its
+// ID, ARN, or alias) with which the database's data key is wrapped
+// (see SetKMSKey), or a base64-encoded key (see ParseKey).
+func (d *DB) UseKey(ctx context.Context, spec string) error {
+	if keyID := strings.TrimPrefix(spec, "kms://"); keyID != spec {
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		return d.SetKMSKey(ctx, kms.New(sess), keyID)
+	}
+	key, err := ParseKey(spec)
+	if err != nil {
+		return err
+	}
+	return d.SetKey(key)
+}
+
+// SetKey sets the key with which the database's log chunks and
+// metrics are encrypted and decrypted. SetKey returns an error if the
+// database was encrypted with a different key. Payloads written
+// before the database's key was first set remain unencrypted, and
+// readable. SetKey may be called only before the database's first
+// use.
+func (d *DB) SetKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("localdb: invalid key: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	var sealed []byte
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		// Values are valid only during the transaction.
+		if b := tx.Bucket(schemaKey); b != nil {
+			sealed = append([]byte{}, b.Get(keyCheckKey)...)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(sealed) > 0 {
+		p, err := unsealWith(aead, sealed)
+		if err != nil || !bytes.Equal(p, keyCheck) {
+			return fmt.Errorf("localdb %s: wrong key", d.db.Path())
+		}
+	} else if !d.db.IsReadOnly() {
+		sealed, err := sealWith(aead, keyCheck)
+		if err != nil {
+			return err
+		}
+		if err := d.db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(schemaKey)
+			if err != nil {
+				return err
+			}
+			return b.Put(keyCheckKey, sealed)
+		}); err != nil {
+			return err
+		}
+	}
+	d.aead = aead
+	d.encrypted = true
+	return nil
+}
+
+// SetKMSKey sets the database's key (see SetKey) to its data key,
+// which is stored in the database, wrapped by the AWS KMS key with
+// the provided ID. The data key is generated by KMS, and stored, when
+// the key of a writable database is first set; thereafter, the data
+// key is unwrapped by KMS whenever the database is opened, so that
+// access to the database's encrypted payloads is controlled by access
+// to the KMS key.
+func (d *DB) SetKMSKey(ctx context.Context, api kmsiface.KMSAPI, keyID string) error {
+	var wrapped []byte
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(schemaKey); b != nil {
+			wrapped = append([]byte{}, b.Get(dataKeyKey)...)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(wrapped) > 0 {
+		out, err := api.DecryptWithContext(ctx, &kms.DecryptInput{CiphertextBlob: wrapped})
+		if err != nil {
+			return fmt.Errorf("localdb %s: unwrap data key: %v", d.db.Path(), err)
+		}
+		return d.SetKey(out.Plaintext)
+	}
+	if d.db.IsReadOnly() {
+		return fmt.Errorf("localdb %s: database has no KMS data key", d.db.Path())
+	}
+	out, err := api.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(keyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return fmt.Errorf("localdb %s: generate data key: %v", d.db.Path(), err)
+	}
+	// The data key is stored before it is first used, so that a
+	// database never holds payloads encrypted with a lost key.
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(schemaKey)
+		if err != nil {
+			return err
+		}
+		if b.Get(keyCheckKey) != nil {
+			return fmt.Errorf("localdb %s: database is encrypted with another key", d.db.Path())
+		}
+		return b.Put(dataKeyKey, out.CiphertextBlob)
+	}); err != nil {
+		return err
+	}
+	return d.SetKey(out.Plaintext)
+}
+
+// Seal returns the payload p as it is stored in the database:
+// encrypted if the database is encrypted.
+func (d *DB) seal(p []byte) ([]byte, error) {
+	if !d.encrypted {
+		return p, nil
+	}
+	if d.aead == nil {
+		return nil, ErrNoKey
+	}
+	return sealWith(d.aead, p)
+}
+
+// Unseal returns the contents of the stored payload p, decrypting it
+// if it is encrypted.
+func (d *DB) unseal(p []byte) ([]byte, error) {
+	if !bytes.HasPrefix(p, sealedPrefix) {
+		return p, nil
+	}
+	if d.aead == nil {
+		return nil, ErrNoKey
+	}
+	return unsealWith(d.aead, p)
+}
+
+func sealWith(aead cipher.AEAD, p []byte) ([]byte, error) {
+	sealed := make([]byte, len(sealedPrefix)+aead.NonceSize(), len(sealedPrefix)+aead.NonceSize()+len(p)+aead.Overhead())
+	copy(sealed, sealedPrefix)
+	if _, err := rand.Read(sealed[len(sealedPrefix):]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[len(sealedPrefix):], p, nil), nil
+}
+
+func unsealWith(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	sealed = sealed[len(sealedPrefix):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("localdb: malformed encrypted payload")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	p, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("localdb: decrypt payload: %v", err)
+	}
+	return p, nil
+}
+
+// Encrypted tells whether the database in the provided transaction
+// is encrypted.
+func encrypted(tx *bolt.Tx) bool {
+	b := tx.Bucket(schemaKey)
+	return b != nil && b.Get(keyCheckKey) != nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/diviner/logchunk"
+	"github.com/grailbio/testutil"
+)
+
+const secret = "the patient's name is redacted"
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, localdb.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// WriteRun writes a run, with metrics and logs, to the study "test".
+func writeRun(t *testing.T, db *localdb.DB) diviner.Run {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	w := db.Logger("test", run.Seq)
+	if _, err := io.WriteString(w, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+// CheckRun checks that the metrics and logs written by writeRun are
+// readable.
+func checkRun(t *testing.T, db *localdb.DB, seq uint64) {
+	t.Helper()
+	run, err := db.LookupRun(context.Background(), "test", seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Trial().Metrics["acc"], 0.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	p, err := ioutil.ReadAll(db.Log("test", seq, time.Time{}, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), secret; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	filename := filepath.Join(dir, "test.ddb")
+	db, err := localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Chunks are stored uncompressed, so that unencrypted logs would
+	// be found in the file.
+	db.LogOptions.Compression = logchunk.None
+	plain := writeRun(t, db)
+	key := newKey(t)
+	if err := db.SetKey(key); err != nil {
+		t.Fatal(err)
+	}
+	sealed := writeRun(t, db)
+	checkRun(t, db, plain.Seq)
+	checkRun(t, db, sealed.Seq)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the log written before the key was set is in the clear.
+	if got, want := bytes.Count(p, []byte(secret)), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	db, err = localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupRun(ctx, "test", sealed.Seq); err != localdb.ErrNoKey {
+		t.Errorf("got %v, want %v", err, localdb.ErrNoKey)
+	}
+	if _, err := ioutil.ReadAll(db.Log("test", sealed.Seq, time.Time{}, false)); err != localdb.ErrNoKey {
+		t.Errorf("got %v, want %v", err, localdb.ErrNoKey)
+	}
+	if err := db.AppendRunMetrics(ctx, "test", plain.Seq, diviner.Metrics{"acc": 0.6}); err != localdb.ErrNoKey {
+		t.Errorf("got %v, want %v", err, localdb.ErrNoKey)
+	}
+	// Studies and unencrypted payloads remain readable.
+	if _, err := db.LookupStudy(ctx, "test"); err != nil {
+		t.Error(err)
+	}
+	checkRun(t, db, plain.Seq)
+	if err := db.SetKey(newKey(t)); err == nil {
+		t.Error("expected error setting the wrong key")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = localdb.OpenReadOnly(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UseKey(ctx, base64.StdEncoding.EncodeToString(key)); err != nil {
+		t.Fatal(err)
+	}
+	checkRun(t, db, sealed.Seq)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := localdb.ParseKey(base64.StdEncoding.EncodeToString(key[:16])); err == nil {
+		t.Error("expected error parsing a short key")
+	}
+}
+
+// FakeKMS wraps data keys by XORing them with its key.
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	key       []byte
+	generated int
+}
+
+func (f *fakeKMS) wrap(p []byte) []byte {
+	q := make([]byte, len(p))
+	for i := range p {
+		q[i] = p[i] ^ f.key[i%len(f.key)]
+	}
+	return q
+}
+
+func (f *fakeKMS) GenerateDataKeyWithContext(_ aws.Context, in *kms.GenerateDataKeyInput, _ ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	if aws.StringValue(in.KeyId) != "alias/diviner" {
+		return nil, errors.New("no such key")
+	}
+	f.generated++
+	key := make([]byte, localdb.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{KeyId: in.KeyId, Plaintext: key, CiphertextBlob: f.wrap(key)}, nil
+}
+
+func (f *fakeKMS) DecryptWithContext(_ aws.Context, in *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: f.wrap(in.CiphertextBlob)}, nil
+}
+
+func TestKMSKey(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	filename := filepath.Join(dir, "test.ddb")
+	api := &fakeKMS{key: []byte("kms")}
+	db, err := localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetKMSKey(ctx, api, "alias/diviner"); err != nil {
+		t.Fatal(err)
+	}
+	run := writeRun(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetKMSKey(ctx, api, "alias/diviner"); err != nil {
+		t.Fatal(err)
+	}
+	checkRun(t, db, run.Seq)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := api.generated, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Databases encrypted with other keys are not rewrapped.
+	filename = filepath.Join(dir, "other.ddb")
+	db, err = localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SetKey(newKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetKMSKey(ctx, api, "alias/diviner"); err == nil {
+		t.Error("expected error setting a KMS key on a database encrypted with another key")
+	}
+}
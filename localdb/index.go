@@ -0,0 +1,370 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grailbio/diviner"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Each study maintains an index of its runs' latest metrics (i.e.,
+// the metrics of their trials), with which queries on metrics are
+// answered without reading every run. The index comprises a bucket
+// for each metric, nested in the study's index bucket, keyed by the
+// metric's value and the run's sequence number; NaN values are not
+// indexed.
+//
+// A second index, of the same structure, holds the metrics of only
+// the study's successful runs, so that its best runs (e.g., for
+// leaderboards) are found without reading the runs that did not
+// succeed. Runs enter and leave the success index as their states
+// change.
+//
+// Studies created by older versions of localdb lack an index, or a
+// success index; they are built on the first query of such a study.
+// The indexedKey of the study bucket marks studies whose indexes are
+// complete, with the version of the indexes' structure.
+var (
+	indexKey        = []byte("index")
+	successIndexKey = []byte("successindex")
+	indexedKey      = []byte("indexed")
+)
+
+// IndexVersion is the version of the structure of studies' indexes.
+// Version 1 indexes lacked success indexes.
+const indexVersion = 2
+
+// IndexComplete tells whether the indexes of the provided study bucket are
+// complete, and of the current version.
+func indexComplete(study *bolt.Bucket) bool {
+	return bytes.Equal(study.Get(indexedKey), []byte{indexVersion})
+}
+
+// Query implements diviner.Database. Queries with metric predicates
+// are answered from the study's metric index (or, for queries of
+// successful runs, its success index): runs are read only if they
+// satisfy the predicates on the first of the queried metrics. Runs
+// are returned in sequence order.
+func (d *DB) Query(ctx context.Context, study string, query diviner.Query) ([]diviner.Run, error) {
+	metric, lo, hi, ok := indexRange(query)
+	if ok {
+		var err error
+		if ok, err = d.ensureIndex(study); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		runs, err := diviner.QueryRuns(ctx, d, study, query)
+		sort.Slice(runs, func(i, j int) bool { return runs[i].Seq < runs[j].Seq })
+		return runs, err
+	}
+	var runs []diviner.Run
+	err := d.db.View(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		index := indexKey
+		if query.RunStates() == diviner.Success {
+			index = successIndexKey
+		}
+		b = lookup(b, index, metric)
+		if b == nil {
+			return nil
+		}
+		var (
+			c        = b.Cursor()
+			min, max = orderedFloat(lo), orderedFloat(hi)
+			seqs     []uint64
+		)
+		for k, _ := c.Seek(min); k != nil && bytes.Compare(k[:8], max) < 0; k, _ = c.Next() {
+			if bytes.Equal(k[:8], min) {
+				continue
+			}
+			seqs = append(seqs, binary.BigEndian.Uint64(k[8:]))
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		for _, seq := range seqs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			k := make([]byte, 8)
+			binary.LittleEndian.PutUint64(k, seq)
+			run, ok, err := d.readRun(tx, study, k, query.RunStates(), time.Time{})
+			if err != nil {
+				return err
+			}
+			if ok && query.Matches(run) {
+				runs = append(runs, run)
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// BestRuns implements diviner.Database. Runs are read from the
+// success index of the objective's metric, best values first, until k
+// runs (and any runs tied with the last of them) are read.
+func (d *DB) BestRuns(ctx context.Context, study string, objective diviner.Objective, k int) ([]diviner.Run, error) {
+	if indexed, err := d.ensureIndex(study); err != nil {
+		return nil, err
+	} else if !indexed {
+		return diviner.RankRuns(ctx, d, study, objective, k)
+	}
+	var runs []diviner.Run
+	err := d.db.View(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		b = lookup(b, successIndexKey, objective.Metric)
+		if b == nil {
+			return nil
+		}
+		var (
+			c           = b.Cursor()
+			first, next = c.First, c.Next
+			last        []byte
+		)
+		if objective.Direction == diviner.Maximize {
+			first, next = c.Last, c.Prev
+		}
+		for key, _ := first(); key != nil; key, _ = next() {
+			if k > 0 && len(runs) >= k && !bytes.Equal(key[:8], last) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			seq := make([]byte, 8)
+			binary.LittleEndian.PutUint64(seq, binary.BigEndian.Uint64(key[8:]))
+			run, ok, err := d.readRun(tx, study, seq, diviner.Success, time.Time{})
+			if err != nil {
+				return err
+			}
+			if ok {
+				runs = append(runs, run)
+				last = key[:8]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	diviner.SortRuns(runs, objective)
+	if k > 0 && len(runs) > k {
+		runs = runs[:k]
+	}
+	return runs, nil
+}
+
+// IndexRange returns the metric whose index is used to answer the
+// provided query, together with the (exclusive) bounds of the
+// metric's values. It returns false if the query has no metric
+// predicates.
+func indexRange(query diviner.Query) (metric string, lo, hi float64, ok bool) {
+	var names []string
+	for name := range query.MetricAbove {
+		names = append(names, name)
+	}
+	for name := range query.MetricBelow {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "", 0, 0, false
+	}
+	sort.Strings(names)
+	metric, lo, hi = names[0], math.Inf(-1), math.Inf(1)
+	if bound, ok := query.MetricAbove[metric]; ok {
+		lo = bound
+	}
+	if bound, ok := query.MetricBelow[metric]; ok {
+		hi = bound
+	}
+	return metric, lo, hi, true
+}
+
+// EnsureIndex builds the metric index of the named study, if it is
+// not already complete. It returns false if the index is incomplete
+// and cannot be built because the database is read-only.
+func (d *DB) ensureIndex(study string) (indexed bool, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		indexed = indexComplete(b)
+		return nil
+	})
+	if err != nil || indexed || d.db.IsReadOnly() {
+		return indexed, err
+	}
+	return true, d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if indexComplete(b) {
+			return nil
+		}
+		for _, index := range [][]byte{indexKey, successIndexKey} {
+			if b.Bucket(index) != nil {
+				if err := b.DeleteBucket(index); err != nil {
+					return err
+				}
+			}
+		}
+		if runs := lookup(b, runsKey); runs != nil {
+			err := runs.ForEach(func(k, _ []byte) error {
+				if len(k) != 8 {
+					return errors.New("malformed key")
+				}
+				seq := binary.LittleEndian.Uint64(k)
+				rb := lookup(tx, runKey{study, seq})
+				metrics, err := d.lastMetrics(rb)
+				if err != nil {
+					return err
+				}
+				if err := updateIndex(b, indexKey, seq, metrics, false); err != nil {
+					return err
+				}
+				if !succeeded(rb) {
+					return nil
+				}
+				return updateIndex(b, successIndexKey, seq, metrics, false)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return b.Put(indexedKey, []byte{indexVersion})
+	})
+}
+
+// UpdateIndex adds to (or, if remove is true, removes from) the
+// provided metric index of the provided study bucket the entries for
+// the provided metrics of run seq.
+func updateIndex(study *bolt.Bucket, index []byte, seq uint64, metrics diviner.Metrics, remove bool) error {
+	for name, value := range metrics {
+		if math.IsNaN(value) {
+			continue
+		}
+		k := make([]byte, 16)
+		copy(k, orderedFloat(value))
+		binary.BigEndian.PutUint64(k[8:], seq)
+		if remove {
+			if b := lookup(study, index, name); b != nil {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		b, _ := create(study, index, name)
+		if b == nil {
+			return errors.New("failed to create index bucket")
+		}
+		if err := b.Put(k, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexMetrics replaces, in the indexes of the named study, the
+// latest metrics of run seq, stored in the run bucket b, with the
+// provided metrics. A nil metrics removes the run from the indexes.
+// Studies whose indexes are incomplete are left untouched, as their
+// indexes are rebuilt when they are queried.
+func (d *DB) indexMetrics(tx *bolt.Tx, study string, seq uint64, b *bolt.Bucket, metrics diviner.Metrics) error {
+	sb := lookup(tx, studiesKey, study)
+	if sb == nil || !indexComplete(sb) {
+		return nil
+	}
+	last, err := d.lastMetrics(b)
+	if err != nil {
+		return err
+	}
+	indexes := [][]byte{indexKey}
+	if succeeded(b) {
+		indexes = append(indexes, successIndexKey)
+	}
+	for _, index := range indexes {
+		if err := updateIndex(sb, index, seq, last, true); err != nil {
+			return err
+		}
+		if err := updateIndex(sb, index, seq, metrics, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexState adds run seq, stored in the run bucket b, to the success
+// index of the named study when its state changes to Success from the
+// provided state, and removes it when its state changes from Success.
+func (d *DB) indexState(tx *bolt.Tx, study string, seq uint64, b *bolt.Bucket, from, to diviner.RunState) error {
+	sb := lookup(tx, studiesKey, study)
+	if sb == nil || !indexComplete(sb) || (from == diviner.Success) == (to == diviner.Success) {
+		return nil
+	}
+	last, err := d.lastMetrics(b)
+	if err != nil {
+		return err
+	}
+	return updateIndex(sb, successIndexKey, seq, last, from == diviner.Success)
+}
+
+// Succeeded tells whether the run stored in run bucket b succeeded.
+func succeeded(b *bolt.Bucket) bool {
+	var run diviner.Run
+	ok, err := getMeta(b, &run)
+	return err == nil && ok && run.State == diviner.Success
+}
+
+// LastMetrics returns the last metrics reported to the run stored in
+// run bucket b.
+func (d *DB) lastMetrics(b *bolt.Bucket) (diviner.Metrics, error) {
+	if b == nil {
+		return nil, nil
+	}
+	b = lookup(b, metricsKey)
+	if b == nil {
+		return nil, nil
+	}
+	_, v := b.Cursor().Last()
+	if v == nil {
+		return nil, nil
+	}
+	v, err := d.unseal(v)
+	if err != nil {
+		return nil, err
+	}
+	return diviner.UnmarshalMetrics(v)
+}
+
+// OrderedFloat encodes the provided value so that the encodings of
+// values sort bytewise in the values' numeric order.
+func orderedFloat(f float64) []byte {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	p := make([]byte, 8)
+	binary.BigEndian.PutUint64(p, bits)
+	return p
+}
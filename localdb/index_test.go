@@ -0,0 +1,196 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/testutil"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestOrderedFloat(t *testing.T) {
+	values := []float64{math.Inf(-1), -1e10, -2.5, -1, -1e-10, 0, 1e-10, 1, 2.5, 1e10, math.Inf(1)}
+	keys := make([][]byte, len(values))
+	for i, v := range values {
+		keys[i] = orderedFloat(v)
+	}
+	if !sort.SliceIsSorted(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 }) {
+		t.Errorf("encodings of %v are not sorted", values)
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a study created by an older version of localdb, which
+	// has no index.
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		return lookup(tx, studiesKey, "test").Delete(indexedKey)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range []float64{0.5, 0.9} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": acc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.db.View(func(tx *bolt.Tx) error {
+		if lookup(tx, studiesKey, "test", indexKey) != nil {
+			t.Error("unexpected index")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.Query(ctx, "test", diviner.Query{MetricAbove: map[string]float64{"acc": 0.6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := runs[0].Seq, uint64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	err = db.db.View(func(tx *bolt.Tx) error {
+		if lookup(tx, studiesKey, "test").Get(indexedKey) == nil {
+			t.Error("index was not built")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// IndexedSeqs returns the sequence numbers of the runs in the provided
+// index of the study "test" for the metric "acc", in index order.
+func indexedSeqs(t *testing.T, db *DB, index []byte) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, "test")
+		if b = b.Bucket(index); b == nil {
+			return nil
+		}
+		if b = b.Bucket([]byte("acc")); b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			seqs = append(seqs, binary.BigEndian.Uint64(k[8:]))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func TestSuccessIndex(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		acc   float64
+		state diviner.RunState
+	}{
+		{0.5, diviner.Success},
+		{0.9, diviner.Failure},
+		{0.7, diviner.Pending},
+		{0.6, diviner.Success},
+	} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": r.acc}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, "test", run.Seq, r.state, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := indexedSeqs(t, db, indexKey), []uint64{1, 4, 3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := indexedSeqs(t, db, successIndexKey), []uint64{1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Runs enter and leave the success index as their states change,
+	// and their entries follow their metrics.
+	if err := db.UpdateRun(ctx, "test", 3, diviner.Success, "", time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", 1, diviner.Failure, "", time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AppendRunMetrics(ctx, "test", 4, diviner.Metrics{"acc": 0.8}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteRun(ctx, "test", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexedSeqs(t, db, successIndexKey), []uint64{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Indexes of version 1, which lack success indexes, are rebuilt.
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, "test")
+		if err := b.DeleteBucket(successIndexKey); err != nil {
+			return err
+		}
+		return b.Put(indexedKey, []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.BestRuns(ctx, "test", diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := runs[0].Seq, uint64(4); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := indexedSeqs(t, db, successIndexKey), []uint64{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
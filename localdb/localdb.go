@@ -4,52 +4,193 @@
 
 // Package localdb implements a diviner database on the local file
 // system using boltdb.
+//
+// The database's most frequent writes (run updates and keepalives,
+// metrics, and log chunks) are batched: concurrent writes are
+// coalesced into a single transaction, and thus a single fsync,
+// delaying each write by at most maxBatchDelay. Writes made by a
+// single caller are still committed in order, each before the call
+// returns.
 package localdb
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/cipher"
 	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/logchunk"
 	bolt "go.etcd.io/bbolt"
 )
 
-const keepaliveInterval = 30 * time.Second
+const (
+	keepaliveInterval = 30 * time.Second
+
+	// MaxBatchDelay bounds the latency that batching adds to writes.
+	maxBatchDelay = 10 * time.Millisecond
+	// MaxBatchSize is the maximum number of writes coalesced into a
+	// single transaction.
+	maxBatchSize = 1000
+
+	// ReadOnlyTimeout is the time that Compact waits for the
+	// database's writer, if any, to release its lock.
+	readOnlyTimeout = 5 * time.Second
+	// SnapshotTimeout is the time that OpenReadOnly waits for the
+	// database's writer, if any, to release its lock before it reads
+	// a snapshot of the database instead.
+	snapshotTimeout = 100 * time.Millisecond
+	// OpenTimeout is the time that Open waits for other processes
+	// that hold the database open to release their locks.
+	openTimeout = 5 * time.Second
+)
 
 var (
-	studiesKey = []byte("studies")
-	metaKey    = []byte("meta")
-	updatedKey = []byte("updated")
-	runsKey    = []byte("runs")
-	logsKey    = []byte("logs")
-	metricsKey = []byte("metrics")
+	studiesKey  = []byte("studies")
+	datasetsKey = []byte("datasets")
+	metaKey     = []byte("meta")
+	updatedKey  = []byte("updated")
+	runsKey     = []byte("runs")
+	logsKey     = []byte("logs")
+	metricsKey  = []byte("metrics")
+	valuesKey   = []byte("values")
+	oracleKey   = []byte("oracle")
+	queueKey    = []byte("queue")
+	archivedKey = []byte("archived")
+	leaseKey    = []byte("lease")
 )
 
 // DB implements diviner.Database using Bolt.
 type DB struct {
+	// LogOptions configure the chunks in which runs' logs are
+	// stored. They may be changed only before the database's first
+	// use.
+	LogOptions logchunk.Options
+
 	db *bolt.DB
+	// Encrypted tells whether the database's payloads are encrypted
+	// with aead, its key (see SetKey).
+	encrypted bool
+	aead      cipher.AEAD
+
+	mu    sync.Mutex
+	dicts map[uint32]*logchunk.Dictionary
 }
 
 // Open opens and returns a new database with the provided filename.
-// The file is created if it does not already exist.
-func Open(filename string) (db *DB, err error) {
-	db = new(DB)
-	db.db, err = bolt.Open(filename, 0666, nil)
+// The file is created if it does not already exist. Databases written
+// by older versions of localdb are upgraded to the current schema
+// (see Migrate).
+//
+// A database may be open for writing by only one process at a time:
+// Open fails, rather than waiting indefinitely, if the database
+// remains open in another process (e.g., another runner) for more
+// than a few seconds. Databases that are shared by several processes
+// should instead be served to them, as by diviner serve-db.
+func Open(filename string) (*DB, error) {
+	db, from, err := open(filename)
 	if err != nil {
 		return nil, err
 	}
-	return db, db.db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists(studiesKey)
+	if from < schemaVersion {
+		log.Printf("localdb %s: migrated schema from version %d to %d", filename, from, schemaVersion)
+	}
+	return db, nil
+}
+
+// Open opens the database with the provided filename, creating it if
+// it does not exist, and applies its pending migrations. Open returns
+// the database's schema version before migration.
+func open(filename string) (db *DB, from int, err error) {
+	db = new(DB)
+	db.db, err = bolt.Open(filename, 0666, &bolt.Options{Timeout: openTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, 0, fmt.Errorf("localdb %s: database is open by another process; share it with diviner serve-db", filename)
+	} else if err != nil {
+		return nil, 0, err
+	}
+	db.db.MaxBatchDelay = maxBatchDelay
+	db.db.MaxBatchSize = maxBatchSize
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		// New databases are created at the current schema version.
+		if tx.Bucket(studiesKey) == nil {
+			if err := setVersion(tx, schemaVersion); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucketIfNotExists(studiesKey); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(datasetsKey)
+		db.encrypted = encrypted(tx)
 		return err
 	})
+	if err == nil {
+		from, err = db.migrate()
+	}
+	if err != nil {
+		db.db.Close()
+		return nil, 0, err
+	}
+	return db, from, nil
+}
+
+// OpenReadOnly opens and returns the existing database with the
+// provided filename in read-only mode: writes to the returned
+// database fail. Any number of read-only databases may be open on
+// the same file at once.
+//
+// Bolt's read-only databases share a lock on the file that excludes
+// its writer, so that a writer cannot open the file until its readers
+// are closed. If the database is instead open for writing (e.g., by a
+// runner), OpenReadOnly opens a snapshot of it, copied to a temporary
+// file (see openSnapshot): the returned database then does not
+// observe later writes, and it must be reopened to observe them.
+// Databases whose readers must follow their writes (e.g., to follow
+// runs' logs) should instead be served to their readers, as by
+// diviner serve-db.
+func OpenReadOnly(filename string) (*DB, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filename, 0666, &bolt.Options{ReadOnly: true, Timeout: snapshotTimeout})
+	if err == bolt.ErrTimeout {
+		db, err = openSnapshot(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Databases of older schema versions cannot be migrated, but
+	// remain readable.
+	d := &DB{db: db}
+	if err := db.View(func(tx *bolt.Tx) error {
+		_, err := version(tx)
+		d.encrypted = encrypted(tx)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close closes the database. Read-only databases should be closed
+// when they are no longer used, releasing their locks.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Path returns the name of the database's file.
+func (d *DB) Path() string {
+	return d.db.Path()
 }
 
 // CreateTable is a no-op.
@@ -65,7 +206,11 @@ func (d *DB) CreateStudyIfNotExist(ctx context.Context, study diviner.Study) (cr
 			if err := put(b, updatedKey, time.Now()); err != nil {
 				return err
 			}
-			if err := put(b, metaKey, study); err != nil {
+			if err := putStudy(b, study); err != nil {
+				return err
+			}
+			// The (empty) index of a new study is complete.
+			if err := b.Put(indexedKey, []byte{indexVersion}); err != nil {
 				return err
 			}
 		}
@@ -81,7 +226,7 @@ func (d *DB) LookupStudy(ctx context.Context, name string) (study diviner.Study,
 		if b == nil {
 			return diviner.ErrNotExist
 		}
-		if ok, err := get(b, metaKey, &study); err != nil {
+		if ok, err := getStudy(b, &study); err != nil {
 			return err
 		} else if !ok {
 			return errors.New("inconsistent database")
@@ -92,6 +237,31 @@ func (d *DB) LookupStudy(ctx context.Context, name string) (study diviner.Study,
 	return
 }
 
+// UpdateStudy implements diviner.Database. Whether the study is
+// archived is also stored separately, so that writes to its runs can
+// check it cheaply.
+func (d *DB) UpdateStudy(ctx context.Context, study diviner.Study) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study.Name)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if err := putStudy(b, study); err != nil {
+			return err
+		}
+		var err error
+		if study.Archived {
+			err = b.Put(archivedKey, []byte{1})
+		} else {
+			err = b.Delete(archivedKey)
+		}
+		if err != nil {
+			return err
+		}
+		return put(b, updatedKey, time.Now())
+	})
+}
+
 // Studies implements diviner.Database.
 func (d *DB) ListStudies(ctx context.Context, prefix string, since time.Time) (studies []diviner.Study, err error) {
 	err = d.db.View(func(tx *bolt.Tx) error {
@@ -112,7 +282,7 @@ func (d *DB) ListStudies(ctx context.Context, prefix string, since time.Time) (s
 				continue
 			}
 			var study diviner.Study
-			if ok, err := get(b.Bucket(k), metaKey, &study); err != nil {
+			if ok, err := getStudy(b.Bucket(k), &study); err != nil {
 				return err
 			} else if !ok {
 				return errors.New("inconsistent database")
@@ -148,6 +318,9 @@ func (d *DB) InsertRun(ctx context.Context, run diviner.Run) (diviner.Run, error
 		if b == nil {
 			return diviner.ErrNotExist
 		}
+		if err := archived(tx, run.Study); err != nil {
+			return err
+		}
 		b, _ = create(b, runsKey)
 		if b == nil {
 			return errors.New("failed to create bucket for runs")
@@ -162,7 +335,7 @@ func (d *DB) InsertRun(ctx context.Context, run diviner.Run) (diviner.Run, error
 		if b == nil {
 			return errors.New("failed to create bucket for run")
 		}
-		err := put(b, metaKey, run)
+		err := putRun(b, run)
 		if err := put(lookup(tx, studiesKey, run.Study), updatedKey, time.Now()); err != nil {
 			log.Error.Printf("run %v: update: %s", run, err)
 		}
@@ -171,23 +344,39 @@ func (d *DB) InsertRun(ctx context.Context, run diviner.Run) (diviner.Run, error
 	return run, err
 }
 
+// UpdateRun implements diviner.Database. Updates are batched.
 func (d *DB) UpdateRun(ctx context.Context, study string, seq uint64, state diviner.RunState, message string, runtime time.Duration, retry int) error {
-	return d.db.Update(func(tx *bolt.Tx) (e error) {
+	// Batched functions may be retried, and thus must be idempotent.
+	return d.db.Batch(func(tx *bolt.Tx) (e error) {
 		b := lookup(tx, runKey{study, seq})
 		if b == nil {
 			return diviner.ErrNotExist
 		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
 		var run diviner.Run
-		ok, err := get(b, metaKey, &run)
+		ok, err := getMeta(b, &run)
 		if err == nil && !ok {
 			return diviner.ErrNotExist
 		}
+		// The run's values, if stored separately, are left untouched.
 		run.Updated = time.Now()
+		if run.Started.IsZero() && runtime > 0 {
+			run.Started = run.Updated.Add(-runtime)
+		}
+		if state != diviner.Pending && run.Completed.IsZero() {
+			run.Completed = run.Updated
+		}
+		from := run.State
 		run.State = state
 		run.Status = message
 		run.Runtime = runtime
 		run.Retries = retry
-		err = put(b, metaKey, run)
+		err = putMeta(b, run)
+		if err == nil {
+			err = d.indexState(tx, study, seq, b, from, state)
+		}
 		// Update the study time as well, so that it shows up properly in listings.
 		if err := put(lookup(tx, studiesKey, run.Study), updatedKey, time.Now()); err != nil {
 			log.Error.Printf("run %v: update: %s", run, err)
@@ -196,18 +385,32 @@ func (d *DB) UpdateRun(ctx context.Context, study string, seq uint64, state divi
 	})
 }
 
+// AppendRunMetrics implements diviner.Database. Appends are batched.
 func (d *DB) AppendRunMetrics(ctx context.Context, study string, seq uint64, metrics diviner.Metrics) error {
-	return d.db.Update(func(tx *bolt.Tx) (e error) {
+	return d.db.Batch(func(tx *bolt.Tx) (e error) {
 		b := lookup(tx, runKey{study, seq})
 		if b == nil {
 			return diviner.ErrNotExist
 		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		if err := d.indexMetrics(tx, study, seq, b, metrics); err != nil {
+			return err
+		}
 		b, _ = create(b, metricsKey)
 		if b == nil {
 			return errors.New("failed to create metrics bucket")
 		}
+		p, err := diviner.MarshalMetrics(metrics)
+		if err == nil {
+			p, err = d.seal(p)
+		}
+		if err != nil {
+			return err
+		}
 		seq, _ := b.NextSequence()
-		return put(b, seq, metrics)
+		return b.Put(key(seq), p)
 	})
 }
 
@@ -223,40 +426,97 @@ func (d *DB) ListRuns(ctx context.Context, study string, states diviner.RunState
 			return nil
 		}
 		return b.ForEach(func(k, v []byte) error {
-			if len(k) != 8 {
-				return errors.New("malformed key")
+			run, ok, err := d.readRun(tx, study, k, states, since)
+			if ok {
+				runs = append(runs, run)
 			}
-			var run diviner.Run
-			run.Study = study
-			run.Seq = binary.LittleEndian.Uint64(k)
-			b := lookup(tx, runKey{study, run.Seq})
+			return err
+		})
+	})
+	return
+}
+
+// scanPageSize is the number of runs that are read in each of a
+// scan's transactions.
+const scanPageSize = 100
+
+// Scan implements diviner.Database. Runs are read in pages of
+// scanPageSize runs, each in its own transaction, so that long scans
+// do not keep the database from being written.
+func (d *DB) Scan(ctx context.Context, study string, states diviner.RunState) diviner.RunIterator {
+	var last []byte
+	return diviner.NewRunIterator(func() (runs []diviner.Run, err error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err = d.db.View(func(tx *bolt.Tx) error {
+			b := lookup(tx, studiesKey, study)
 			if b == nil {
-				return nil
-			}
-			ok, err := get(b, metaKey, &run)
-			if err != nil {
-				return err
-			} else if err == nil && !ok {
-				return nil
+				return diviner.ErrNotExist
 			}
-			if run.Updated.Before(since) {
-				return nil
+			b = lookup(b, runsKey)
+			if b == nil {
+				return io.EOF
 			}
-			if run.State == diviner.Pending && time.Since(run.Updated) > 2*keepaliveInterval {
-				run.State = diviner.Failure
+			var (
+				c = b.Cursor()
+				k []byte
+			)
+			if last == nil {
+				k, _ = c.First()
+			} else if k, _ = c.Seek(last); bytes.Equal(k, last) {
+				k, _ = c.Next()
 			}
-			if run.State&states != run.State {
-				return nil
+			for n := 0; k != nil && n < scanPageSize; k, _ = c.Next() {
+				run, ok, err := d.readRun(tx, study, k, states, time.Time{})
+				if err != nil {
+					return err
+				}
+				if ok {
+					runs = append(runs, run)
+				}
+				// Keys are valid only for the life of the transaction.
+				last = append(last[:0], k...)
+				n++
 			}
-			run.Metrics, err = unmarshalMetrics(b)
-			if err != nil {
-				return err
+			if k == nil {
+				return io.EOF
 			}
-			runs = append(runs, run)
 			return nil
 		})
+		return
 	})
-	return
+}
+
+// ReadRun reads the run with the provided key from the provided
+// study's runs. It returns false if the run is not in one of the
+// provided states or was last updated before the provided time.
+func (d *DB) readRun(tx *bolt.Tx, study string, k []byte, states diviner.RunState, since time.Time) (diviner.Run, bool, error) {
+	if len(k) != 8 {
+		return diviner.Run{}, false, errors.New("malformed key")
+	}
+	var run diviner.Run
+	run.Study = study
+	run.Seq = binary.LittleEndian.Uint64(k)
+	b := lookup(tx, runKey{study, run.Seq})
+	if b == nil {
+		return run, false, nil
+	}
+	ok, err := getRun(b, &run)
+	if err != nil || !ok {
+		return run, false, err
+	}
+	if run.Updated.Before(since) {
+		return run, false, nil
+	}
+	if run.State == diviner.Pending && time.Since(run.Updated) > 2*keepaliveInterval {
+		run.State = diviner.Failure
+	}
+	if run.State&states != run.State {
+		return run, false, nil
+	}
+	run.Metrics, err = d.unmarshalMetrics(b)
+	return run, err == nil, err
 }
 
 // Run implements diviner.Database.
@@ -266,7 +526,7 @@ func (d *DB) LookupRun(ctx context.Context, study string, seq uint64) (run divin
 		if b == nil {
 			return diviner.ErrNotExist
 		}
-		ok, err := get(b, metaKey, &run)
+		ok, err := getRun(b, &run)
 		if err == nil && !ok {
 			err = diviner.ErrNotExist
 		}
@@ -276,8 +536,328 @@ func (d *DB) LookupRun(ctx context.Context, study string, seq uint64) (run divin
 		if run.State == diviner.Pending && time.Since(run.Updated) > 2*keepaliveInterval {
 			run.State = diviner.Failure
 		}
-		run.Metrics, err = unmarshalMetrics(b)
+		run.Metrics, err = d.unmarshalMetrics(b)
+		return err
+	})
+	return
+}
+
+// SetRunLabels implements diviner.Database.
+func (d *DB) SetRunLabels(ctx context.Context, study string, seq uint64, labels diviner.Labels) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, runKey{study, seq})
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		var run diviner.Run
+		ok, err := getMeta(b, &run)
+		if err == nil && !ok {
+			return diviner.ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		// The run's values, if stored separately, are left untouched.
+		run.Labels = labels
+		return putMeta(b, run)
+	})
+}
+
+// SetRunArtifacts implements diviner.Database.
+func (d *DB) SetRunArtifacts(ctx context.Context, study string, seq uint64, artifacts []diviner.Artifact) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, runKey{study, seq})
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		var run diviner.Run
+		ok, err := getMeta(b, &run)
+		if err == nil && !ok {
+			return diviner.ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		run.Artifacts = artifacts
+		return putMeta(b, run)
+	})
+}
+
+// SetRunExit implements diviner.Database.
+func (d *DB) SetRunExit(ctx context.Context, study string, seq uint64, exit diviner.RunExit) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, runKey{study, seq})
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		var run diviner.Run
+		ok, err := getMeta(b, &run)
+		if err == nil && !ok {
+			return diviner.ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		run.Exit = &exit
+		return putMeta(b, run)
+	})
+}
+
+// SetRunCost implements diviner.Database.
+func (d *DB) SetRunCost(ctx context.Context, study string, seq uint64, cost diviner.RunCost) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, runKey{study, seq})
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		var run diviner.Run
+		ok, err := getMeta(b, &run)
+		if err == nil && !ok {
+			return diviner.ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		run.Cost = &cost
+		return putMeta(b, run)
+	})
+}
+
+// DeleteRun implements diviner.Database. The run's metrics and log
+// buckets are removed along with it.
+func (d *DB) DeleteRun(ctx context.Context, study string, seq uint64) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		// Run buckets are keyed as in bucket.
+		k := make([]byte, 8)
+		binary.LittleEndian.PutUint64(k, seq)
+		b := lookup(tx, studiesKey, study, runsKey)
+		if b == nil || b.Bucket(k) == nil {
+			return diviner.ErrNotExist
+		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		if err := d.indexMetrics(tx, study, seq, b.Bucket(k), nil); err != nil {
+			return err
+		}
+		if err := b.DeleteBucket(k); err != nil {
+			return err
+		}
+		return put(lookup(tx, studiesKey, study), updatedKey, time.Now())
+	})
+}
+
+// DeleteRunLogs implements diviner.Database.
+func (d *DB) DeleteRunLogs(ctx context.Context, study string, seq uint64) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, runKey{study, seq})
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		if b.Bucket(logsKey) == nil {
+			return nil
+		}
+		return b.DeleteBucket(logsKey)
+	})
+}
+
+// DeleteStudy implements diviner.Database. All of the study's runs,
+// and their metrics and logs, are removed along with it.
+func (d *DB) DeleteStudy(ctx context.Context, name string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(studiesKey)
+		if b == nil || b.Bucket([]byte(name)) == nil {
+			return diviner.ErrNotExist
+		}
+		return b.DeleteBucket([]byte(name))
+	})
+}
+
+// LookupDataset implements diviner.Database.
+func (d *DB) LookupDataset(ctx context.Context, name string) (record diviner.DatasetRecord, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		ok, err := get(tx.Bucket(datasetsKey), []byte(name), &record)
+		if err == nil && !ok {
+			err = diviner.ErrNotExist
+		}
+		return err
+	})
+	return
+}
+
+// SetDataset implements diviner.Database.
+func (d *DB) SetDataset(ctx context.Context, record diviner.DatasetRecord) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(datasetsKey), []byte(record.Name), record)
+	})
+}
+
+// InvalidateDataset implements diviner.Database.
+func (d *DB) InvalidateDataset(ctx context.Context, name string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(datasetsKey)
+		if b.Get([]byte(name)) == nil {
+			return diviner.ErrNotExist
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+// LookupOracleState implements diviner.Database. The state is stored
+// in the study's bucket.
+func (d *DB) LookupOracleState(ctx context.Context, study string) (state []byte, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		p := b.Get(oracleKey)
+		if p == nil {
+			return diviner.ErrNotExist
+		}
+		state = append([]byte{}, p...)
+		return nil
+	})
+	return
+}
+
+// SetOracleState implements diviner.Database.
+func (d *DB) SetOracleState(ctx context.Context, study string, state []byte) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		return b.Put(oracleKey, state)
+	})
+}
+
+// AcquireLease implements diviner.Database. The lease is stored in
+// the study's bucket.
+func (d *DB) AcquireLease(ctx context.Context, study string, lease diviner.Lease) (held diviner.Lease, acquired bool, err error) {
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		var prev diviner.Lease
+		if _, err := get(b, leaseKey, &prev); err != nil {
+			return err
+		}
+		held, acquired = lease.Take(prev, time.Now())
+		if !acquired {
+			return nil
+		}
+		return put(b, leaseKey, held)
+	})
+	return
+}
+
+// ReleaseLease implements diviner.Database.
+func (d *DB) ReleaseLease(ctx context.Context, study, owner string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		var held diviner.Lease
+		if ok, err := get(b, leaseKey, &held); err != nil || !ok || held.Owner != owner {
+			return err
+		}
+		return b.Delete(leaseKey)
+	})
+}
+
+// EnqueueValues implements diviner.Database. The queue is stored in
+// a bucket of the study's bucket, keyed by the values' positions in
+// the queue.
+func (d *DB) EnqueueValues(ctx context.Context, study string, values diviner.Values) error {
+	p, err := diviner.MarshalValues(values)
+	if err != nil {
 		return err
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if err := archived(tx, study); err != nil {
+			return err
+		}
+		b, _ = create(b, queueKey)
+		if b == nil {
+			return errors.New("failed to create queue bucket")
+		}
+		seq, _ := b.NextSequence()
+		return b.Put(key(seq), p)
+	})
+}
+
+// DequeueValues implements diviner.Database.
+func (d *DB) DequeueValues(ctx context.Context, study string, n int) (values []diviner.Values, err error) {
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if b = lookup(b, queueKey); b == nil {
+			return nil
+		}
+		var keys [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && (n <= 0 || len(values) < n); k, v = c.Next() {
+			vals, err := diviner.UnmarshalValues(v)
+			if err != nil {
+				return err
+			}
+			values = append(values, vals)
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		values = nil
+	}
+	return
+}
+
+// QueuedValues implements diviner.Database.
+func (d *DB) QueuedValues(ctx context.Context, study string) (values []diviner.Values, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		b := lookup(tx, studiesKey, study)
+		if b == nil {
+			return diviner.ErrNotExist
+		}
+		if b = lookup(b, queueKey); b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			vals, err := diviner.UnmarshalValues(v)
+			if err == nil {
+				values = append(values, vals)
+			}
+			return err
+		})
 	})
 	return
 }
@@ -366,15 +946,97 @@ func unmarshal(p []byte, ptr interface{}) error {
 	return gob.NewDecoder(bytes.NewReader(p)).Decode(ptr)
 }
 
-func unmarshalMetrics(b *bolt.Bucket) ([]diviner.Metrics, error) {
+// putRun stores the provided run's metadata in the run bucket b. The
+// run's values are stored separately, in diviner's versioned
+// encoding.
+func putRun(b *bolt.Bucket, run diviner.Run) error {
+	p, err := diviner.MarshalValues(run.Values)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(valuesKey, p); err != nil {
+		return err
+	}
+	run.Values = nil
+	return putMeta(b, run)
+}
+
+// getRun retrieves the run stored in run bucket b. Runs stored by
+// older versions of localdb carry their values in the run metadata.
+func getRun(b *bolt.Bucket, run *diviner.Run) (bool, error) {
+	ok, err := getMeta(b, run)
+	if !ok || err != nil {
+		return ok, err
+	}
+	if p := b.Get(valuesKey); p != nil {
+		run.Values, err = diviner.UnmarshalValues(p)
+	}
+	return true, err
+}
+
+// PutMeta stores the provided run's metadata in run bucket b, in
+// diviner's versioned record encoding.
+func putMeta(b *bolt.Bucket, run diviner.Run) error {
+	p, err := diviner.MarshalRun(run)
+	if err != nil {
+		return err
+	}
+	return b.Put(metaKey, p)
+}
+
+// GetMeta retrieves the metadata of the run stored in run bucket b.
+// Metadata stored by older versions of localdb are gob-encoded.
+func getMeta(b *bolt.Bucket, run *diviner.Run) (bool, error) {
+	p := b.Get(metaKey)
+	if p == nil {
+		return false, nil
+	}
+	var err error
+	*run, err = diviner.UnmarshalRun(p)
+	return err == nil, err
+}
+
+// PutStudy stores the provided study's metadata in study bucket b.
+func putStudy(b *bolt.Bucket, study diviner.Study) error {
+	p, err := diviner.MarshalStudy(study)
+	if err != nil {
+		return err
+	}
+	return b.Put(metaKey, p)
+}
+
+// Archived returns ErrArchived if the named study is archived.
+func archived(tx *bolt.Tx, study string) error {
+	if b := lookup(tx, studiesKey, study); b != nil && b.Get(archivedKey) != nil {
+		return diviner.ErrArchived
+	}
+	return nil
+}
+
+// GetStudy retrieves the study stored in study bucket b.
+func getStudy(b *bolt.Bucket, study *diviner.Study) (bool, error) {
+	p := b.Get(metaKey)
+	if p == nil {
+		return false, nil
+	}
+	var err error
+	*study, err = diviner.UnmarshalStudy(p)
+	return err == nil, err
+}
+
+func (d *DB) unmarshalMetrics(b *bolt.Bucket) ([]diviner.Metrics, error) {
 	b = lookup(b, metricsKey)
 	if b == nil {
 		return nil, nil
 	}
 	var list []diviner.Metrics
 	err := b.ForEach(func(k, v []byte) error {
-		var metrics diviner.Metrics
-		if err := unmarshal(v, &metrics); err != nil {
+		v, err := d.unseal(v)
+		if err != nil {
+			return err
+		}
+		metrics, err := diviner.UnmarshalMetrics(v)
+		if err != nil {
 			return err
 		}
 		list = append(list, metrics)
@@ -396,23 +1058,3 @@ func key(k interface{}) []byte {
 	}
 	return key
 }
-
-func deflate(p []byte) ([]byte, error) {
-	var b bytes.Buffer
-	w := gzip.NewWriter(&b)
-	if _, err := w.Write(p); err != nil {
-		return nil, err
-	}
-	if err := w.Close(); err != nil {
-		return nil, err
-	}
-	return b.Bytes(), nil
-}
-
-func inflate(p []byte) ([]byte, error) {
-	r, err := gzip.NewReader(bytes.NewReader(p))
-	if err != nil {
-		return nil, err
-	}
-	return ioutil.ReadAll(r)
-}
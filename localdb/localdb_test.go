@@ -6,14 +6,23 @@ package localdb_test
 
 import (
 	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/grailbio/diviner"
 	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/diviner/logchunk"
 	"github.com/grailbio/testutil"
+	bolt "go.etcd.io/bbolt"
 )
 
 func TestDB(t *testing.T) {
@@ -187,3 +196,1203 @@ func TestDB(t *testing.T) {
 	}
 }
 */
+
+func TestRunTimes(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Pending, "waiting", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	run, err = db.LookupRun(ctx, "test", run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.Started.IsZero() || !run.Completed.IsZero() {
+		t.Errorf("run %v: unexpected start or completion time", run)
+	}
+	if got, want := run.Status, "waiting"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Pending, "running", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "ok", 2*time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	run, err = db.LookupRun(ctx, "test", run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Status, "ok"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The start time is recorded once, from the first update with a runtime.
+	if started := run.Updated.Add(-time.Minute); run.Started.After(started) || run.Started.Before(started.Add(-time.Second)) {
+		t.Errorf("unexpected start time %s (updated %s)", run.Started, run.Updated)
+	}
+	if got, want := run.Completed, run.Updated; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"test", "other"} {
+		if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var seqs []uint64
+	for i := 0; i < 3; i++ {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 1}); err != nil {
+			t.Fatal(err)
+		}
+		w := db.Logger("test", run.Seq)
+		if _, err := io.WriteString(w, "log\n"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, run.Seq)
+	}
+	if _, err := db.InsertRun(ctx, diviner.Run{Study: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteRun(ctx, "test", seqs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.DeleteRun(ctx, "test", seqs[1]), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.LookupRun(ctx, "test", seqs[1]); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := ioutil.ReadAll(db.Log("test", seqs[1], time.Time{}, false)); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	runs, err := db.ListRuns(ctx, "test", diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := runs[0].Seq, seqs[0]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := runs[1].Seq, seqs[2]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := db.DeleteStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.DeleteStudy(ctx, "test"), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.LookupStudy(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := db.LookupRun(ctx, "test", seqs[0]); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	studies, err := db.ListStudies(ctx, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Name, "other"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if runs, err := db.ListRuns(ctx, "other", diviner.Any, time.Time{}); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(runs), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.UpdateStudy(ctx, diviner.Study{Name: "test"}), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := db.Logger("test", run.Seq)
+	fmt.Fprintln(w, "hello world")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteRunLogs(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, false)); err != nil {
+		t.Fatal(err)
+	} else if len(p) != 0 {
+		t.Errorf("unexpected log %q", p)
+	}
+	if got, want := db.DeleteRunLogs(ctx, "test", run.Seq+1), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	retention := diviner.Retention{FailedLogs: time.Hour, KeepBest: 2}
+	if err := db.UpdateStudy(ctx, diviner.Study{Name: "test", Archived: true, Retention: retention}); err != nil {
+		t.Fatal(err)
+	}
+	study, err := db.LookupStudy(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !study.Archived {
+		t.Error("study is not archived")
+	}
+	if got, want := study.Retention, retention; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.InsertRun(ctx, diviner.Run{Study: "test"}); err != diviner.ErrArchived {
+		t.Errorf("got %v, want %v", err, diviner.ErrArchived)
+	}
+	for _, err := range []error{
+		db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Minute, 0),
+		db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 1}),
+		db.SetRunLabels(ctx, "test", run.Seq, diviner.Labels{"x": "y"}),
+		db.DeleteRunLogs(ctx, "test", run.Seq),
+		db.DeleteRun(ctx, "test", run.Seq),
+		db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(1)}),
+	} {
+		if err != diviner.ErrArchived {
+			t.Errorf("got %v, want %v", err, diviner.ErrArchived)
+		}
+	}
+	if err := db.UpdateStudy(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logc := make(chan string)
+	go func() {
+		p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, true))
+		if err != nil {
+			t.Error(err)
+		}
+		logc <- string(p)
+	}()
+	w := db.Logger("test", run.Seq)
+	for _, line := range []string{"one\n", "two\n"} {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.(interface{ Flush() error }).Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case log := <-logc:
+		t.Fatalf("log of pending run ended: %q", log)
+	case <-time.After(time.Second):
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "ok", time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-logc, "one\ntwo\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for i := 0; i < 3; i++ {
+		run := diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(i)}}
+		if i == 0 {
+			run.SetLabel("baseline", "")
+		}
+		run, err := db.InsertRun(ctx, run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, run.Seq)
+	}
+	labels, err := diviner.UpdateLabels(ctx, db, "test", seqs[1], diviner.Labels{"gpu": "a100", "owner": "alice"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := labels.String(), "gpu=a100,owner=alice"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := diviner.UpdateLabels(ctx, db, "test", seqs[2], diviner.Labels{"gpu": "v100"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diviner.UpdateLabels(ctx, db, "test", seqs[1], nil, []string{"owner"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.LookupRun(ctx, "test", seqs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Labels.String(), "gpu=a100"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Values are retained when labels are updated.
+	if got, want := run.Values["x"], diviner.Int(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, c := range []struct {
+		selector string
+		seqs     []uint64
+	}{
+		{"", seqs},
+		{"baseline", seqs[:1]},
+		{"gpu", seqs[1:]},
+		{"gpu=a100", seqs[1:2]},
+		{"!baseline,gpu!=a100", seqs[2:]},
+	} {
+		sel, err := diviner.ParseSelector(c.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs, err := diviner.SelectRuns(ctx, db, "test", diviner.Any, time.Time{}, sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]uint64, len(runs))
+		for i, run := range runs {
+			got[i] = run.Seq
+		}
+		if want := c.seqs; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", c.selector, got, want)
+		}
+	}
+	if got, want := db.SetRunLabels(ctx, "test", 100, nil), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	study := diviner.Study{
+		Name:      "test",
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+		t.Fatal(err)
+	}
+	for i, acc := range []float64{0.5, 0.9, 0.7} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": acc}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+		// The best run does not register a model.
+		if i == 1 {
+			continue
+		}
+		model := diviner.Artifact{Name: "model", URL: fmt.Sprintf("s3://bucket/model%d", i)}
+		if _, err := diviner.AddArtifact(ctx, db, "test", run.Seq, model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	artifacts, err := diviner.AddArtifact(ctx, db, "test", 1, diviner.Artifact{Name: "plot", Data: []byte("png")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(artifacts), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Artifacts with the same name are replaced.
+	if _, err := diviner.AddArtifact(ctx, db, "test", 1, diviner.Artifact{Name: "model", URL: "s3://bucket/final"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.LookupRun(ctx, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(run.Artifacts), "[plot (inline, 3 bytes) model=s3://bucket/final]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Values["x"], diviner.Int(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run, artifact, err := diviner.BestArtifact(ctx, db, study, "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Seq, uint64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := artifact.URL, "s3://bucket/model2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, _, err := diviner.BestArtifact(ctx, db, study, "weights"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if got, want := db.SetRunArtifacts(ctx, "test", 100, nil), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	// Insert enough runs to span several pages, every third of which
+	// fails.
+	const N = 250
+	for i := 0; i < N; i++ {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := diviner.Success
+		if i%3 == 0 {
+			state = diviner.Failure
+		}
+		if err := db.UpdateRun(ctx, "test", run.Seq, state, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, states := range []diviner.RunState{diviner.Any, diviner.Success, diviner.Failure} {
+		runs, err := db.ListRuns(ctx, "test", states, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanned, err := diviner.ScanAll(db.Scan(ctx, "test", states))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(scanned), len(runs); got != want {
+			t.Fatalf("%s: got %v, want %v", states, got, want)
+		}
+		for i := range runs {
+			if got, want := scanned[i].Seq, runs[i].Seq; got != want {
+				t.Errorf("%s: run %d: got %v, want %v", states, i, got, want)
+			}
+		}
+	}
+	it := db.Scan(ctx, "test", diviner.Any)
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() {
+		t.Error("closed iterator returned a run")
+	}
+	if _, err := diviner.ScanAll(db.Scan(ctx, "nonexistent", diviner.Any)); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := diviner.ScanAll(db.Scan(cancelCtx, "test", diviner.Any)); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, acc := range []float64{0.5, 0.95, -1, 0.92, math.NaN(), 0.99} {
+		optimizer := "sgd"
+		if i%2 == 1 {
+			optimizer = "adam"
+		}
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"optimizer": diviner.String(optimizer)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the latest metrics are indexed.
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 0.97}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": acc, "loss": 1 - acc}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seqs := func(query diviner.Query) string {
+		t.Helper()
+		runs, err := db.Query(ctx, "test", query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs := make([]string, len(runs))
+		for i, run := range runs {
+			seqs[i] = fmt.Sprint(run.Seq)
+		}
+		return strings.Join(seqs, ",")
+	}
+	for _, test := range []struct {
+		query diviner.Query
+		want  string
+	}{
+		{diviner.Query{}, "1,2,3,4,5,6"},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0.9}}, "2,4,6"},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0.95}}, "6"},
+		{diviner.Query{MetricBelow: map[string]float64{"acc": 0.9}}, "1,3"},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0}, MetricBelow: map[string]float64{"acc": 0.95}}, "1,4"},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0.9}, MetricBelow: map[string]float64{"loss": 0.03}}, "6"},
+		{diviner.Query{Values: diviner.Values{"optimizer": diviner.String("sgd")}}, "1,3,5"},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0.5}, Values: diviner.Values{"optimizer": diviner.String("sgd")}}, ""},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0.9}, States: diviner.Pending}, ""},
+		{diviner.Query{MetricAbove: map[string]float64{"nonexistent": 0}}, ""},
+	} {
+		if got, want := seqs(test.query), test.want; got != want {
+			t.Errorf("%v: got %v, want %v", test.query, got, want)
+		}
+	}
+
+	// The index follows updated metrics and deleted runs.
+	if err := db.AppendRunMetrics(ctx, "test", 1, diviner.Metrics{"acc": 0.91}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteRun(ctx, "test", 6); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := seqs(diviner.Query{MetricAbove: map[string]float64{"acc": 0.9}}), "1,2,4"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.Query(ctx, "nonexistent", diviner.Query{MetricAbove: map[string]float64{"acc": 0.9}}); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
+
+func TestLogCompression(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	var want strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&want, "line %d\n", i)
+	}
+	for _, c := range []logchunk.Compression{logchunk.Gzip, logchunk.Zstd, logchunk.None} {
+		db.LogOptions = logchunk.Options{Compression: c, BlockSize: 1 << 10}
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := db.Logger("test", run.Seq)
+		for _, line := range strings.SplitAfter(want.String(), "\n") {
+			if _, err := io.WriteString(w, line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(p), want.String(); got != want {
+			t.Errorf("%s: got %q, want %q", c, got, want)
+		}
+	}
+}
+
+// WriteLog writes a training log of the provided number of lines to
+// a new run of the study "test", returning the run and its log.
+func writeLog(t *testing.T, db *localdb.DB, lines int) (diviner.Run, string) {
+	t.Helper()
+	run, err := db.InsertRun(context.Background(), diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "INFO run %d: epoch %d step %d loss=%.4f accuracy=%.4f\n", run.Seq, i/10, i, 1/float64(i+1), float64(i)/float64(lines))
+	}
+	w := db.Logger("test", run.Seq)
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return run, b.String()
+}
+
+func TestLogDictionary(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	filename := filepath.Join(dir, "test.ddb")
+	db, err := localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TrainLogDictionary(ctx, 0); err == nil {
+		t.Error("expected error training a dictionary without logs")
+	}
+	db.LogOptions = logchunk.Options{Compression: logchunk.Zstd, BlockSize: 1 << 10}
+	logs := make(map[uint64]string)
+	run, log := writeLog(t, db, 100)
+	logs[run.Seq] = log
+	dict, err := db.TrainLogDictionary(ctx, 4<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, log = writeLog(t, db, 100)
+	logs[run.Seq] = log
+	// Logs compressed with replaced dictionaries remain readable.
+	if _, err := db.TrainLogDictionary(ctx, 1<<10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bdb, err := bolt.Open(filename, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bdb.View(func(tx *bolt.Tx) error {
+		k := make([]byte, 8)
+		binary.LittleEndian.PutUint64(k, run.Seq)
+		b := tx.Bucket([]byte("studies")).Bucket([]byte("test")).Bucket([]byte("runs")).Bucket(k).Bucket([]byte("logs"))
+		return b.ForEach(func(_, chunk []byte) error {
+			if got, want := logchunk.DictionaryID(chunk), dict.ID; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = localdb.OpenReadOnly(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for seq, want := range logs {
+		p, err := ioutil.ReadAll(db.Log("test", seq, time.Time{}, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(p); got != want {
+			t.Errorf("run %d: got %q, want %q", seq, got, want)
+		}
+	}
+}
+
+func TestDatasets(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupDataset(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	record := diviner.DatasetRecord{Name: "test", Digest: "abc", Completed: time.Now().Round(0)}
+	if err := db.SetDataset(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.LookupDataset(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != record.Name || got.Digest != record.Digest || !got.Completed.Equal(record.Completed) {
+		t.Errorf("got %v, want %v", got, record)
+	}
+	record.Digest = "def"
+	if err := db.SetDataset(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.LookupDataset(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if got.Digest != "def" {
+		t.Errorf("got %v, want def", got.Digest)
+	}
+	if err := db.InvalidateDataset(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupDataset(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if err := db.InvalidateDataset(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
+
+func TestOracleState(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.SetOracleState(ctx, "test", []byte("state")), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupOracleState(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	for _, state := range []string{"state0", "state1"} {
+		if err := db.SetOracleState(ctx, "test", []byte(state)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := db.LookupOracleState(ctx, "test"); err != nil {
+			t.Fatal(err)
+		} else if string(got) != state {
+			t.Errorf("got %s, want %s", got, state)
+		}
+	}
+	if err := db.DeleteStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupOracleState(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
+
+func TestLease(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.AcquireLease(ctx, "test", diviner.Lease{Owner: "a"}); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		now   = time.Now()
+		a     = diviner.Lease{Owner: "a", PID: 1, Acquired: now, Expires: now.Add(time.Minute)}
+		b     = diviner.Lease{Owner: "b", PID: 2, Acquired: now, Expires: now.Add(time.Minute)}
+		stale = diviner.Lease{Owner: "c", PID: 3, Acquired: now, Expires: now.Add(-time.Second)}
+	)
+	if _, ok, err := db.AcquireLease(ctx, "test", a); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	}
+	if held, ok, err := db.AcquireLease(ctx, "test", b); err != nil {
+		t.Fatal(err)
+	} else if ok || held.Owner != "a" || held.PID != 1 {
+		t.Errorf("got %v, %v, want lease of a", held, ok)
+	}
+	// Renewals by the owner retain the lease's acquisition time.
+	renewed := a
+	renewed.Acquired = now.Add(time.Second)
+	renewed.Expires = now.Add(time.Hour)
+	if held, ok, err := db.AcquireLease(ctx, "test", renewed); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	} else if !held.Acquired.Equal(now) || !held.Expires.Equal(renewed.Expires) {
+		t.Errorf("bad renewal %v", held)
+	}
+	if err := db.ReleaseLease(ctx, "test", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReleaseLease(ctx, "test", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// Expired leases are taken over.
+	if _, ok, err := db.AcquireLease(ctx, "test", stale); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	}
+	if _, ok, err := db.AcquireLease(ctx, "test", b); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	}
+	// The lease is deleted along with the study.
+	if err := db.DeleteStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.AcquireLease(ctx, "test", a); err != nil || !ok {
+		t.Errorf("got %v, %v, want true", ok, err)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(0)}), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(int64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if values, err := db.DequeueValues(ctx, "test", 2); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{{"x": diviner.Int(0)}, {"x": diviner.Int(1)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := db.QueuedValues(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{{"x": diviner.Int(2)}, {"x": diviner.Int(3)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.DequeueValues(ctx, "test", 10); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(values), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.DequeueValues(ctx, "test", 10); err != nil {
+		t.Fatal(err)
+	} else if len(values) != 0 {
+		t.Errorf("unexpected values %v", values)
+	}
+}
+
+func TestBestRuns(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []struct {
+		x     int
+		acc   float64
+		state diviner.RunState
+	}{
+		{0, 0.5, diviner.Success},
+		{1, 0.9, diviner.Success},
+		{2, 0.7, diviner.Success},
+		{3, 0.9, diviner.Success},
+		{4, math.NaN(), diviner.Success},
+		{5, 0.3, diviner.Failure},
+		{6, 0.99, diviner.Pending},
+		{1, 0.95, diviner.Success},
+	} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(int64(r.x))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": r.acc}); err != nil {
+			t.Fatal(err)
+		}
+		if r.state != diviner.Pending {
+			if err := db.UpdateRun(ctx, "test", run.Seq, r.state, "", time.Second, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var (
+		maximize = diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+		minimize = diviner.Objective{Direction: diviner.Minimize, Metric: "acc"}
+	)
+	seqs := func(runs []diviner.Run) string {
+		seqs := make([]string, len(runs))
+		for i, run := range runs {
+			seqs[i] = fmt.Sprint(run.Seq)
+		}
+		return strings.Join(seqs, ",")
+	}
+	for _, test := range []struct {
+		objective diviner.Objective
+		k         int
+		want      string
+	}{
+		{maximize, 2, "8,2"},
+		{maximize, 3, "8,2,4"},
+		{maximize, 0, "8,2,4,3,1"},
+		{minimize, 2, "1,3"},
+		{diviner.Objective{Direction: diviner.Maximize, Metric: "loss"}, 2, ""},
+	} {
+		runs, err := db.BestRuns(ctx, "test", test.objective, test.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := seqs(runs), test.want; got != want {
+			t.Errorf("%v, %d: got %v, want %v", test.objective, test.k, got, want)
+		}
+		// The index must agree with a scan of the study's runs.
+		runs, err = diviner.RankRuns(ctx, db, "test", test.objective, test.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := seqs(runs), test.want; got != want {
+			t.Errorf("%v, %d: scanned: got %v, want %v", test.objective, test.k, got, want)
+		}
+	}
+	trials, err := diviner.Leaderboard(ctx, db, "test", maximize, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(trials), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := seqs(append(trials[0].Runs, trials[1].Runs...)), "8,4"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.BestRuns(ctx, "nonexistent", maximize, 1); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	const (
+		nrun    = 20
+		nmetric = 10
+	)
+	var seqs [nrun]uint64
+	for i := range seqs {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs[i] = run.Seq
+	}
+	// Concurrent writes are batched; each run's writes must still be
+	// committed in order.
+	var wg sync.WaitGroup
+	for _, seq := range seqs {
+		wg.Add(1)
+		go func(seq uint64) {
+			defer wg.Done()
+			w := db.Logger("test", seq)
+			for i := 0; i < nmetric; i++ {
+				if err := db.AppendRunMetrics(ctx, "test", seq, diviner.Metrics{"step": float64(i)}); err != nil {
+					t.Error(err)
+				}
+				if err := db.UpdateRun(ctx, "test", seq, diviner.Pending, fmt.Sprint(i), time.Second, 0); err != nil {
+					t.Error(err)
+				}
+				if _, err := fmt.Fprintf(w, "%d\n", i); err != nil {
+					t.Error(err)
+				}
+				if err := w.(interface{ Flush() error }).Flush(); err != nil {
+					t.Error(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Error(err)
+			}
+		}(seq)
+	}
+	wg.Wait()
+	var wantLog strings.Builder
+	for i := 0; i < nmetric; i++ {
+		fmt.Fprintf(&wantLog, "%d\n", i)
+	}
+	for _, seq := range seqs {
+		run, err := db.LookupRun(ctx, "test", seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(run.Metrics), nmetric; got != want {
+			t.Fatalf("run %d: got %v, want %v", seq, got, want)
+		}
+		for i, metrics := range run.Metrics {
+			if got, want := metrics["step"], float64(i); got != want {
+				t.Errorf("run %d: got %v, want %v", seq, got, want)
+			}
+		}
+		if got, want := run.Status, fmt.Sprint(nmetric-1); got != want {
+			t.Errorf("run %d: got %v, want %v", seq, got, want)
+		}
+		p, err := ioutil.ReadAll(db.Log("test", seq, time.Time{}, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(p), wantLog.String(); got != want {
+			t.Errorf("run %d: got %q, want %q", seq, got, want)
+		}
+	}
+}
+
+func TestOpenLocked(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	filename := filepath.Join(dir, "test.ddb")
+	db, err := localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// The database is locked by its writer: a second writer fails,
+	// rather than waiting for the database to be closed.
+	if _, err := localdb.Open(filename); err == nil || !strings.Contains(err.Error(), "open by another process") {
+		t.Errorf("bad error %v", err)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	filename := filepath.Join(dir, "test.ddb")
+	if _, err := localdb.OpenReadOnly(filename); err == nil {
+		t.Error("expected error")
+	}
+	db, err := localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range []float64{0.5, 0.9} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": acc}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read-only databases may be opened concurrently.
+	var readers [2]*localdb.DB
+	for i := range readers {
+		if readers[i], err = localdb.OpenReadOnly(filename); err != nil {
+			t.Fatal(err)
+		}
+		defer readers[i].Close()
+	}
+	ro := readers[1]
+	runs, err := ro.ListRuns(ctx, "test", diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The study's metric index cannot be built, so queries scan the
+	// study's runs instead.
+	if runs, err := ro.Query(ctx, "test", diviner.Query{MetricAbove: map[string]float64{"acc": 0.6}}); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(runs), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	best, err := ro.BestRuns(ctx, "test", diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := best[0].Seq, uint64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := ro.UpdateRun(ctx, "test", 1, diviner.Failure, "", time.Second, 0); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestOpenReadOnlyWriter(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	filename := filepath.Join(dir, "test.ddb")
+	db, err := localdb.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertRun(ctx, diviner.Run{Study: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	// The database is open for writing, so readers open snapshots of
+	// it, which do not lock out the writer, even while it writes.
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		for i := 0; err == nil; i++ {
+			select {
+			case <-done:
+				errc <- nil
+				return
+			default:
+			}
+			err = db.AppendRunMetrics(ctx, "test", 1, diviner.Metrics{"step": float64(i)})
+			time.Sleep(time.Millisecond)
+		}
+		errc <- err
+	}()
+	for i := 0; i < 3; i++ {
+		ro, err := localdb.OpenReadOnly(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs, err := ro.ListRuns(ctx, "test", diviner.Any, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(runs), 1; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if err := ro.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := localdb.OpenReadOnly(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	// Snapshots do not observe later writes.
+	if _, err := db.InsertRun(ctx, diviner.Run{Study: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := ro.ListRuns(ctx, "test", diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -5,66 +5,61 @@
 package localdb
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"time"
 
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/logchunk"
 	bolt "go.etcd.io/bbolt"
 )
 
 var errEndOfStream = errors.New("end of stream")
 
-type writeFlusher interface {
-	io.Writer
-	Flush() error
-}
-
-type flushCloser struct {
-	writeFlusher
-}
-
-func (f flushCloser) Close() error {
-	return f.Flush()
-}
-
 type runWriter struct {
-	db    *bolt.DB
+	db    *DB
 	study string
 	seq   uint64
 }
 
+// Logger implements diviner.Database. Writes are coalesced into
+// chunks as configured by the database's LogOptions; chunks are
+// written in batches. Zstd chunks are compressed with the database's
+// current log dictionary (see TrainLogDictionary), if it has one and
+// LogOptions do not name another.
 func (d *DB) Logger(study string, seq uint64) io.WriteCloser {
-	return flushCloser{bufio.NewWriterSize(runWriter{d.db, study, seq}, 4<<10)}
+	opts := d.LogOptions
+	if opts.Compression == logchunk.Zstd && opts.Dictionary == nil {
+		var err error
+		if opts.Dictionary, err = d.currentLogDictionary(); err != nil {
+			log.Error.Printf("localdb log %s:%d: %v; compressing without a dictionary", study, seq, err)
+		}
+	}
+	return logchunk.NewWriter(runWriter{d, study, seq}.put, opts)
 }
 
-func (w runWriter) Write(p []byte) (n int, err error) {
-	n = len(p)
-	err = w.db.Update(func(tx *bolt.Tx) error {
+func (w runWriter) put(chunk []byte) error {
+	chunk, err := w.db.seal(chunk)
+	if err != nil {
+		return err
+	}
+	return w.db.db.Batch(func(tx *bolt.Tx) error {
 		b, _ := create(tx, runKey{w.study, w.seq}, logsKey)
 		if b == nil {
 			return errors.New("failed to create logs bucket")
 		}
-		p, err = deflate(p)
-		if err != nil {
-			return err
-		}
 		seq, _ := b.NextSequence()
-		return b.Put(key(seq), p)
+		return b.Put(key(seq), chunk)
 	})
-	if err != nil {
-		n = 0
-	}
-	return
 }
 
-func (runWriter) Flush() error { return nil }
-func (runWriter) Close() error { return nil }
-
 type runReader struct {
-	db     *bolt.DB
+	db     *DB
 	study  string
 	seq    uint64
 	whence uint64
@@ -72,31 +67,41 @@ type runReader struct {
 	follow bool
 }
 
-// Log implements diviner.Run.
+// Log implements diviner.Database. Followed logs end when the run
+// completes.
 func (d *DB) Log(study string, seq uint64, since time.Time, follow bool) io.Reader {
 	// TODO(saito) Support "since".
 	if !since.IsZero() {
 		log.Error.Printf("localdb log %s: -since not supported", study)
 	}
-	return &runReader{db: d.db, study: study, seq: seq, whence: 1, follow: follow}
+	return &runReader{db: d, study: study, seq: seq, whence: 1, follow: follow}
 }
 
 func (r *runReader) Read(p []byte) (n int, err error) {
 	for len(r.buf) == 0 {
-		err = r.db.View(func(tx *bolt.Tx) error {
-			b := lookup(tx, runKey{r.study, r.seq})
-			if b == nil {
+		err = r.db.db.View(func(tx *bolt.Tx) error {
+			run := lookup(tx, runKey{r.study, r.seq})
+			if run == nil {
 				return diviner.ErrNotExist
 			}
-			b = b.Bucket(logsKey)
+			b := run.Bucket(logsKey)
 			if b == nil {
+				if r.follow && !done(run) {
+					return errEndOfStream
+				}
 				return io.EOF
 			}
 			r.buf = b.Get(key(r.whence))
 			if r.buf == nil {
+				if r.follow && done(run) {
+					// The run has completed, and thus its logs are complete,
+					// too: the runner closes its logger before updating the
+					// run's final state.
+					return io.EOF
+				}
 				return errEndOfStream
 			}
-			r.buf, err = inflate(r.buf)
+			r.buf, err = r.db.decodeChunk(tx, r.buf)
 			if err != nil {
 				return err
 			}
@@ -120,3 +125,171 @@ func (r *runReader) Read(p []byte) (n int, err error) {
 	r.buf = r.buf[n:]
 	return
 }
+
+// done tells whether the run stored in the run bucket b has
+// completed, either because it is no longer pending, or because its
+// keepalive has expired.
+func done(b *bolt.Bucket) bool {
+	var run diviner.Run
+	if ok, err := getMeta(b, &run); err != nil || !ok {
+		return false
+	}
+	return run.State != diviner.Pending || time.Since(run.Updated) > 2*keepaliveInterval
+}
+
+// Each database stores the dictionaries with which its runs' zstd log
+// chunks are compressed in the logdicts bucket, keyed by their IDs,
+// together with the ID of its current dictionary, with which new
+// chunks are compressed. Dictionaries are retained when they are
+// replaced, so that older chunks remain readable. As they are made of
+// the content of logs, dictionaries are encrypted in encrypted
+// databases.
+var (
+	logDictsKey = []byte("logdicts")
+	currentKey  = []byte("current")
+)
+
+// DictSampleSize is the number of bytes of logs sampled to train a
+// dictionary.
+const dictSampleSize = 16 << 20
+
+// TrainLogDictionary trains a dictionary of at most the provided size
+// (see logchunk.TrainDictionary) on samples of the logs of the
+// database's most recent runs (the first and last chunks of each, in
+// which scripts typically log their setup and their progress), stores
+// it, and makes it the database's
+// current log dictionary, so that the logs subsequently written with
+// zstd compression are compressed with it.
+func (d *DB) TrainLogDictionary(ctx context.Context, size int) (*logchunk.Dictionary, error) {
+	var samples [][]byte
+	err := d.db.View(func(tx *bolt.Tx) error {
+		n := 0
+		return tx.Bucket(studiesKey).ForEach(func(k, v []byte) error {
+			runs := lookup(tx, studiesKey, k, runsKey)
+			if v != nil || runs == nil {
+				return nil
+			}
+			c := runs.Cursor()
+			for k, v := c.Last(); k != nil && n < dictSampleSize; k, v = c.Prev() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				logs := lookup(runs, k, logsKey)
+				if v != nil || logs == nil {
+					continue
+				}
+				lc := logs.Cursor()
+				first, _ := lc.First()
+				last, _ := lc.Last()
+				keys := [][]byte{first}
+				if !bytes.Equal(last, first) {
+					keys = append(keys, last)
+				}
+				for _, k := range keys {
+					if k == nil {
+						continue
+					}
+					p, err := d.decodeChunk(tx, logs.Get(k))
+					if err != nil {
+						return err
+					}
+					samples = append(samples, p)
+					n += len(p)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	dict, err := logchunk.TrainDictionary(samples, size)
+	if err != nil {
+		return nil, err
+	}
+	p, err := d.seal(dict.Content)
+	if err != nil {
+		return nil, err
+	}
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(logDictsKey)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key(uint64(dict.ID)), p); err != nil {
+			return err
+		}
+		return b.Put(currentKey, key(uint64(dict.ID)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.cacheLogDictionary(dict)
+	return dict, nil
+}
+
+// CurrentLogDictionary returns the database's current log
+// dictionary, or nil if it has none.
+func (d *DB) currentLogDictionary() (dict *logchunk.Dictionary, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(logDictsKey)
+		if b == nil || len(b.Get(currentKey)) != 8 {
+			return nil
+		}
+		dict, err = d.logDictionary(tx, binary.BigEndian.Uint32(b.Get(currentKey)[4:]))
+		return err
+	})
+	return
+}
+
+// LogDictionary returns the log dictionary with the provided ID.
+// Dictionaries are cached once they are read.
+func (d *DB) logDictionary(tx *bolt.Tx, id uint32) (*logchunk.Dictionary, error) {
+	d.mu.Lock()
+	dict := d.dicts[id]
+	d.mu.Unlock()
+	if dict != nil {
+		return dict, nil
+	}
+	var p []byte
+	if b := tx.Bucket(logDictsKey); b != nil {
+		p = b.Get(key(uint64(id)))
+	}
+	if p == nil {
+		return nil, fmt.Errorf("localdb: log dictionary %d does not exist", id)
+	}
+	p, err := d.unseal(p)
+	if err != nil {
+		return nil, err
+	}
+	// Values are valid only during the transaction.
+	dict = &logchunk.Dictionary{ID: id, Content: append([]byte{}, p...)}
+	d.cacheLogDictionary(dict)
+	return dict, nil
+}
+
+func (d *DB) cacheLogDictionary(dict *logchunk.Dictionary) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dicts == nil {
+		d.dicts = make(map[uint32]*logchunk.Dictionary)
+	}
+	d.dicts[dict.ID] = dict
+}
+
+// DecodeChunk returns the contents of the stored log chunk.
+func (d *DB) decodeChunk(tx *bolt.Tx, chunk []byte) ([]byte, error) {
+	chunk, err := d.unseal(chunk)
+	if err != nil {
+		return nil, err
+	}
+	id := logchunk.DictionaryID(chunk)
+	if id == 0 {
+		return logchunk.Decode(chunk)
+	}
+	dict, err := d.logDictionary(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return logchunk.Decode(chunk, dict)
+}
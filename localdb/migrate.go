@@ -0,0 +1,206 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	schemaKey  = []byte("schema")
+	versionKey = []byte("version")
+)
+
+// A migration upgrades a database from the preceding schema version.
+type migration struct {
+	// Description describes the migration to users.
+	description string
+	// Migrate upgrades the database in the provided transaction.
+	migrate func(tx *bolt.Tx) error
+}
+
+// Migrations lists the migrations of localdb's schema, in order:
+// migrations[i] upgrades databases of version i to version i+1.
+// Databases created before schema versions were introduced have
+// version 0. A migration must be appended whenever the schema changes
+// incompatibly, e.g., when the encoding of run metadata changes so
+// that older records no longer decode.
+var migrations = []migration{
+	{"store run values and metrics in diviner's versioned encoding", migrateEncoding},
+	{"store run and study metadata as versioned (JSON) records", migrateRecords},
+}
+
+// SchemaVersion is the version of the schema of databases written by
+// this version of localdb.
+var schemaVersion = len(migrations)
+
+// Migrate upgrades the database with the provided filename to the
+// current schema version, returning the database's versions before
+// and after the upgrade. Open upgrades databases automatically;
+// Migrate is useful to upgrade databases ahead of their use, e.g.,
+// before they are opened read-only.
+func Migrate(filename string) (from, to int, err error) {
+	db, from, err := open(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, schemaVersion, db.Close()
+}
+
+// Migrate applies the pending migrations of the database, each in
+// its own transaction, returning the database's version before they
+// were applied.
+func (d *DB) migrate() (from int, err error) {
+	if err = d.db.View(func(tx *bolt.Tx) (e error) {
+		from, e = version(tx)
+		return
+	}); err != nil {
+		return 0, err
+	}
+	for v := from; v < schemaVersion; v++ {
+		err = d.db.Update(func(tx *bolt.Tx) error {
+			if err := migrations[v].migrate(tx); err != nil {
+				return err
+			}
+			return setVersion(tx, v+1)
+		})
+		if err != nil {
+			return from, fmt.Errorf("localdb: migrate to schema version %d (%s): %v", v+1, migrations[v].description, err)
+		}
+	}
+	return from, nil
+}
+
+// Version returns the schema version of the database, returning an
+// error if it is newer than the current version.
+func version(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket(schemaKey)
+	if b == nil {
+		return 0, nil
+	}
+	p := b.Get(versionKey)
+	if p == nil {
+		return 0, nil
+	}
+	if len(p) != 8 {
+		return 0, fmt.Errorf("localdb: malformed schema version %x", p)
+	}
+	v := int(binary.BigEndian.Uint64(p))
+	if v > schemaVersion {
+		return 0, fmt.Errorf("localdb: database schema version %d is newer than supported version %d", v, schemaVersion)
+	}
+	return v, nil
+}
+
+func setVersion(tx *bolt.Tx, v int) error {
+	b, err := tx.CreateBucketIfNotExists(schemaKey)
+	if err != nil {
+		return err
+	}
+	return b.Put(versionKey, key(uint64(v)))
+}
+
+// MigrateEncoding rewrites runs' values and metrics stored in
+// (legacy) gob encodings in diviner's versioned encoding.
+func migrateEncoding(tx *bolt.Tx) error {
+	n, err := forEachRun(tx, func(b *bolt.Bucket) error {
+		var run diviner.Run
+		if ok, err := getRun(b, &run); err != nil || !ok {
+			return err
+		}
+		if err := putRun(b, run); err != nil {
+			return err
+		}
+		mb := lookup(b, metricsKey)
+		if mb == nil {
+			return nil
+		}
+		// Buckets may not be modified while they are iterated over.
+		var keys, values [][]byte
+		if err := mb.ForEach(func(k, v []byte) error {
+			metrics, err := diviner.UnmarshalMetrics(v)
+			if err != nil {
+				return err
+			}
+			p, err := diviner.MarshalMetrics(metrics)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte{}, k...))
+			values = append(values, p)
+			return nil
+		}); err != nil {
+			return err
+		}
+		for i := range keys {
+			if err := mb.Put(keys[i], values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		log.Printf("localdb: rewrote the values and metrics of %d runs", n)
+	}
+	return err
+}
+
+// MigrateRecords rewrites gob-encoded run and study metadata as
+// versioned records. Runs' values, stored separately, are left
+// untouched.
+func migrateRecords(tx *bolt.Tx) error {
+	err := tx.Bucket(studiesKey).ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		b := lookup(tx, studiesKey, k)
+		var study diviner.Study
+		if ok, err := getStudy(b, &study); err != nil || !ok {
+			return err
+		}
+		return putStudy(b, study)
+	})
+	if err != nil {
+		return err
+	}
+	n, err := forEachRun(tx, func(b *bolt.Bucket) error {
+		var run diviner.Run
+		if ok, err := getMeta(b, &run); err != nil || !ok {
+			return err
+		}
+		return putMeta(b, run)
+	})
+	if err == nil {
+		log.Printf("localdb: rewrote the metadata of %d runs", n)
+	}
+	return err
+}
+
+// ForEachRun calls fn with the bucket of each run in the database,
+// returning the number of runs visited.
+func forEachRun(tx *bolt.Tx, fn func(b *bolt.Bucket) error) (n int, err error) {
+	err = tx.Bucket(studiesKey).ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		runs := lookup(tx, studiesKey, k, runsKey)
+		if runs == nil {
+			return nil
+		}
+		return runs.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			n++
+			return fn(runs.Bucket(k))
+		})
+	})
+	return
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/testutil"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	filename := filepath.Join(dir, "test.ddb")
+	db, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	study := diviner.Study{
+		Name:      "test",
+		Params:    diviner.Params{"layers": diviner.NewRange(diviner.Int(1), diviner.Int(10))},
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+		t.Fatal(err)
+	}
+	values := diviner.Values{"optimizer": diviner.String("adam"), "layers": diviner.Int(3)}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a database written by an older version of localdb,
+	// which has no schema version, and stores values in the run
+	// metadata and metrics as gobs.
+	err = db.db.Update(func(tx *bolt.Tx) error {
+		if err := put(lookup(tx, studiesKey, "test"), metaKey, study); err != nil {
+			return err
+		}
+		b := lookup(tx, runKey{"test", run.Seq})
+		if err := b.Delete(valuesKey); err != nil {
+			return err
+		}
+		if err := put(b, metaKey, run); err != nil {
+			return err
+		}
+		mb, _ := create(b, metricsKey)
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(diviner.Metrics{"acc": 0.5}); err != nil {
+			return err
+		}
+		if err := mb.Put(key(uint64(1)), buf.Bytes()); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(schemaKey)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	from, to, err := Migrate(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := from, 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := to, schemaVersion; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if from, _, err = Migrate(filename); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := from, schemaVersion; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	db, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := lookup(tx, runKey{"test", run.Seq})
+		if b.Get(valuesKey) == nil {
+			t.Error("values not migrated")
+		}
+		var meta diviner.Run
+		if _, err := getMeta(b, &meta); err != nil {
+			return err
+		}
+		if meta.Values != nil {
+			t.Errorf("values remain in metadata: %v", meta.Values)
+		}
+		for _, p := range [][]byte{b.Get(metaKey), lookup(tx, studiesKey, "test").Get(metaKey)} {
+			if !bytes.HasPrefix(p, []byte{0, 'd', 'v', 2}) {
+				t.Errorf("metadata not migrated: %q", p)
+			}
+		}
+		p := lookup(b, metricsKey).Get(key(uint64(1)))
+		if gob.NewDecoder(bytes.NewReader(p)).Decode(new(diviner.Metrics)) == nil {
+			t.Error("metrics not migrated")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.LookupStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, study) {
+		t.Errorf("got %v, want %v", got, study)
+	}
+	got, err := db.LookupRun(ctx, "test", run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := got.Values, values; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := got.Metrics, []diviner.Metrics{{"acc": 0.5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Databases of newer schema versions are not opened.
+	if err := db.db.Update(func(tx *bolt.Tx) error { return setVersion(tx, schemaVersion+1) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := OpenReadOnly(filename); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestNewSchemaVersion(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	from, to, err := Migrate(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := from, to; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// SnapshotAttempts is the number of times that openSnapshot
+	// copies a database that changes while it is copied before it
+	// gives up.
+	snapshotAttempts = 5
+	// MetaSize is the size of the prefix of a database file that
+	// holds its meta pages: its first two pages, of at most 64KB
+	// each.
+	metaSize = 2 << 16
+)
+
+// OpenSnapshot opens a read-only bolt database on a private copy of
+// the database file with the provided name, which may be open for
+// writing by another process. Bolt commits a transaction by updating
+// the database's meta pages, after it has written the transaction's
+// pages to pages that are free in the committed database. A copy that
+// is made while no transaction commits is thus consistent: the file's
+// meta pages are compared before and after each copy, and the copy is
+// retried if they changed.
+//
+// The copy is written to the system's temporary directory, and is
+// removed once it is open, so that it is reclaimed when the returned
+// database is closed.
+func openSnapshot(filename string) (*bolt.DB, error) {
+	for attempt := 0; attempt < snapshotAttempts; attempt++ {
+		db, err := copySnapshot(filename)
+		if err != nil || db != nil {
+			return db, err
+		}
+	}
+	return nil, fmt.Errorf("localdb %s: database changed while it was copied in each of %d attempts", filename, snapshotAttempts)
+}
+
+// CopySnapshot copies the named database file and opens the copy, as
+// described by openSnapshot. CopySnapshot returns a nil database if
+// the file's meta pages changed during the copy.
+func copySnapshot(filename string) (*bolt.DB, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	before, err := readMeta(f)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile("", filepath.Base(filename)+".snapshot")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, f)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("localdb %s: snapshot: %v", filename, err)
+	}
+	after, err := readMeta(f)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(before, after) {
+		return nil, nil
+	}
+	return bolt.Open(tmp.Name(), 0666, &bolt.Options{ReadOnly: true})
+}
+
+// ReadMeta returns the prefix of f that holds its meta pages.
+func readMeta(f *os.File) ([]byte, error) {
+	p := make([]byte, metaSize)
+	n, err := f.ReadAt(p, 0)
+	if err == io.EOF {
+		err = nil
+	}
+	return p[:n], err
+}
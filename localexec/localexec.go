@@ -0,0 +1,148 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package localexec implements a diviner.Backend that runs scripts as
+// subprocesses of the diviner process itself, without bigmachine. It
+// is intended for small studies, and for debugging studies before
+// they are run on a cluster.
+//
+// Each script is run by Bash in a fresh working directory, into
+// which the job's files are written. The script inherits diviner's
+// environment, extended by the job's. Its combined standard output
+// and standard error are streamed back to the runner, which records
+// its logs and metrics in the database as for any other system. When
+// a job is canceled, its whole process group is killed.
+//
+// Jobs run on the local host regardless of the resources they
+// require; the number of concurrent jobs should be limited with the
+// system's parallelism.
+package localexec
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(new(Backend))
+}
+
+// Backend implements diviner.Backend by running jobs as local Bash
+// processes.
+type Backend struct {
+	// Dir is the directory in which the jobs' working directories
+	// are created. Defaults to the system's temporary directory.
+	Dir string
+	// Keep retains the jobs' working directories after they
+	// complete, so that their outputs may be inspected.
+	Keep bool
+	// Bash is the path of the Bash binary. Defaults to "bash".
+	Bash string
+}
+
+var _ diviner.Backend = (*Backend)(nil)
+
+// Run implements diviner.Backend. It starts the job's script in a new
+// working directory and returns a reader of its output. Reads return
+// an error, carrying the script's exit status, if the script failed.
+func (b *Backend) Run(ctx context.Context, job diviner.Job) (io.ReadCloser, error) {
+	if job.Image != "" {
+		return nil, fmt.Errorf("localexec: job %s: container images are not supported", job.Name)
+	}
+	dir, err := ioutil.TempDir(b.Dir, jobPrefix(job.Name))
+	if err != nil {
+		return nil, fmt.Errorf("localexec: create working directory: %v", err)
+	}
+	for name, p := range job.Files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), p, 0644); err != nil {
+			b.cleanup(dir)
+			return nil, fmt.Errorf("localexec: write file %s: %v", name, err)
+		}
+	}
+	bash := b.Bash
+	if bash == "" {
+		bash = "bash"
+	}
+	r, w := io.Pipe()
+	cmd := exec.Command(bash, "-c", job.Script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), job.Env...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	// Run the script in its own process group, so that the script's
+	// children are also killed when the job is canceled.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		b.cleanup(dir)
+		return nil, fmt.Errorf("localexec: start job %s: %v", job.Name, err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Signaling the negative pid kills the process group.
+			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+				log.Error.Printf("localexec: kill job %s: %v", job.Name, err)
+			}
+		case <-done:
+		}
+	}()
+	go func() {
+		err := cmd.Wait()
+		close(done)
+		cancel()
+		b.cleanup(dir)
+		if err != nil {
+			err = fmt.Errorf("job %s failed: %v", job.Name, err)
+		}
+		w.CloseWithError(err)
+	}()
+	return &outputReader{r, cancel}, nil
+}
+
+// Cleanup removes the provided working directory, unless the backend
+// keeps them.
+func (b *Backend) cleanup(dir string) {
+	if b.Keep {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Error.Printf("localexec: remove %s: %v", dir, err)
+	}
+}
+
+type outputReader struct {
+	*io.PipeReader
+	cancel func()
+}
+
+func (r *outputReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// JobPrefix returns a prefix for the working directory of the named
+// job, with path separators and other unusual characters replaced.
+func jobPrefix(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '-'
+		}
+	}, name) + "-"
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localexec_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/localexec"
+	"github.com/grailbio/testutil"
+)
+
+func TestBackend(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	b := &localexec.Backend{Dir: dir}
+	job := diviner.Job{
+		Name:   "study=test,seq=1",
+		Script: "cat data.txt; echo METRICS: count=$DIVINER_TEST_COUNT; echo error >&2",
+		Env:    []string{"DIVINER_TEST_COUNT=3"},
+		Files:  map[string][]byte{"data.txt": []byte("hello world\n")},
+	}
+	out, err := b.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), "hello world\nMETRICS: count=3\nerror\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// Working directories are removed once their jobs complete.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(infos), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBackendFailure(t *testing.T) {
+	b := new(localexec.Backend)
+	out, err := b.Run(context.Background(), diviner.Job{Name: "test", Script: "echo failing; exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	p, err := ioutil.ReadAll(out)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := string(p), "failing\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := err.Error(), "exit status 3"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %v, want suffix %v", got, want)
+	}
+}
+
+func TestBackendCancel(t *testing.T) {
+	b := new(localexec.Backend)
+	ctx, cancel := context.WithCancel(context.Background())
+	// The script's child process must also be killed for its output
+	// to be closed.
+	out, err := b.Run(ctx, diviner.Job{Name: "test", Script: "echo started; sleep 100; echo done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	p := make([]byte, 8)
+	if _, err := out.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	cancel()
+	p, err = ioutil.ReadAll(out)
+	if err == nil {
+		t.Error("expected error")
+	}
+	if strings.Contains(string(p), "done") {
+		t.Errorf("job was not killed: %s", p)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("job took %s to be killed", elapsed)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package logchunk
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DefaultDictionarySize is the default size of the dictionaries
+	// trained by TrainDictionary.
+	DefaultDictionarySize = 64 << 10
+
+	// Lines longer than maxLine are rarely repeated, and are not
+	// included in dictionaries.
+	maxLine = 1 << 10
+	// ExamplesPerTemplate is the number of lines of each template
+	// that are included in a dictionary, so that it contains the
+	// template's typical numbers, too.
+	examplesPerTemplate = 8
+	// MinDictionarySize is the size of the smallest usable
+	// dictionary: zstd's initial repeat offsets reach back 8 bytes.
+	minDictionarySize = 8
+)
+
+// A Dictionary is a zstd dictionary of raw content, which primes the
+// compressor's history, so that (small) chunks are compressed as if
+// their logs were preceded by the dictionary's content.
+type Dictionary struct {
+	// ID identifies the dictionary in the chunks compressed with it.
+	ID uint32
+	// Content is the dictionary's content.
+	Content []byte
+}
+
+// NewDictionary returns the dictionary with the provided content.
+// Its ID is derived from a checksum of the content, and lies outside
+// the ranges of IDs reserved by the zstd format.
+func NewDictionary(content []byte) *Dictionary {
+	const lo, hi = 1 << 15, 1 << 31
+	return &Dictionary{
+		ID:      lo + crc32.ChecksumIEEE(content)%(hi-lo),
+		Content: content,
+	}
+}
+
+// DictionaryID returns the ID of the dictionary with which the
+// provided chunk was compressed, or 0 if it was compressed without a
+// dictionary.
+func DictionaryID(chunk []byte) uint32 {
+	if !bytes.HasPrefix(chunk, zstdMagic) {
+		return 0
+	}
+	var h zstd.Header
+	if err := h.Decode(chunk); err != nil {
+		return 0
+	}
+	return h.DictionaryID
+}
+
+// TrainDictionary returns a dictionary of at most the provided size
+// (or DefaultDictionarySize, if it is not positive) trained on the
+// provided samples of logs. Logs are mostly the repetition of a few
+// lines that differ only in their numbers (steps, metrics,
+// timestamps): the samples' lines are grouped into templates, which
+// elide their numbers, and the dictionary comprises a few of the
+// latest lines of the most frequent templates, with the most frequent
+// last, where they are cheapest to match. Templates that occur only
+// once are omitted.
+func TrainDictionary(samples [][]byte, size int) (*Dictionary, error) {
+	if size <= 0 {
+		size = DefaultDictionarySize
+	}
+	type template struct {
+		count int
+		lines []string
+	}
+	templates := make(map[string]*template)
+	for _, sample := range samples {
+		for _, line := range bytes.SplitAfter(sample, []byte("\n")) {
+			if len(line) == 0 || len(line) > maxLine {
+				continue
+			}
+			key := templateOf(line)
+			t := templates[key]
+			if t == nil {
+				t = new(template)
+				templates[key] = t
+			}
+			t.count++
+			t.lines = append(t.lines, string(line))
+			if len(t.lines) > examplesPerTemplate {
+				t.lines = t.lines[1:]
+			}
+		}
+	}
+	var sorted []string
+	for key, t := range templates {
+		if t.count > 1 {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		ti, tj := templates[sorted[i]], templates[sorted[j]]
+		if ti.count != tj.count {
+			return ti.count > tj.count
+		}
+		return sorted[i] < sorted[j]
+	})
+	var (
+		chosen []string
+		n      int
+	)
+	for _, key := range sorted {
+		for _, line := range templates[key].lines {
+			if n+len(line) > size {
+				continue
+			}
+			chosen = append(chosen, line)
+			n += len(line)
+		}
+	}
+	if n < minDictionarySize {
+		return nil, errors.New("logchunk: log samples have too little repeated content for a dictionary")
+	}
+	content := make([]byte, 0, n)
+	for i := len(chosen) - 1; i >= 0; i-- {
+		content = append(content, chosen[i]...)
+	}
+	return NewDictionary(content), nil
+}
+
+// TemplateOf returns the template of the provided line: the line,
+// with each of its runs of digits replaced by a single '#'.
+func templateOf(line []byte) string {
+	var b strings.Builder
+	digits := false
+	for _, c := range line {
+		if isDigit(c) {
+			if !digits {
+				b.WriteByte('#')
+			}
+			digits = true
+			continue
+		}
+		digits = false
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
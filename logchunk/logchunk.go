@@ -0,0 +1,362 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package logchunk implements the storage format of run logs in
+// databases that store logs as a sequence of chunks (e.g., localdb
+// and pgdb). A Writer coalesces a run's (typically small) log writes
+// into blocks, each of which is compressed independently and stored
+// as a chunk; Decode decompresses a chunk written with any of the
+// supported compression schemes. Since chunks are small, zstd chunks
+// may be compressed with a dictionary trained on samples of earlier
+// logs (see TrainDictionary), from which much of their content is
+// matched.
+//
+// Decode also accepts the chunks written by earlier versions of
+// diviner: gzip-compressed chunks, written by localdb, and
+// uncompressed chunks, written by pgdb.
+package logchunk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DefaultBlockSize is the default size of the blocks into which
+	// a Writer coalesces writes.
+	DefaultBlockSize = 64 << 10
+	// DefaultFlushInterval is the default interval after which a
+	// Writer flushes buffered data, so that followers of a log see
+	// it even if the block is not full.
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// Compression is a scheme with which log chunks are compressed.
+type Compression int
+
+const (
+	// Gzip compresses chunks with gzip. It is the default.
+	Gzip Compression = iota
+	// Zstd compresses chunks with Zstandard.
+	Zstd
+	// None stores chunks uncompressed.
+	None
+)
+
+// ParseCompression returns the compression scheme with the provided
+// name: "gzip", "zstd", or "none".
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "gzip":
+		return Gzip, nil
+	case "zstd":
+		return Zstd, nil
+	case "none":
+		return None, nil
+	}
+	return 0, fmt.Errorf("unknown log compression %q", name)
+}
+
+// String returns the name of the compression scheme.
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case None:
+		return "none"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// ParseOptions parses the compression scheme and level of the
+// provided specification: the name of a scheme (see
+// ParseCompression), optionally followed by a colon and a level,
+// e.g., "zstd:19".
+func ParseOptions(spec string) (Options, error) {
+	var opts Options
+	name, level := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, level = spec[:i], spec[i+1:]
+	}
+	var err error
+	if opts.Compression, err = ParseCompression(name); err != nil || level == "" {
+		return opts, err
+	}
+	if opts.Level, err = strconv.Atoi(level); err != nil {
+		return opts, fmt.Errorf("invalid log compression level %q", level)
+	}
+	max, ok := map[Compression]int{Gzip: gzip.BestCompression, Zstd: 22}[opts.Compression]
+	if !ok {
+		return opts, fmt.Errorf("log compression %s has no levels", opts.Compression)
+	}
+	if opts.Level < 1 || opts.Level > max {
+		return opts, fmt.Errorf("log compression level %d of %s is not between 1 and %d", opts.Level, opts.Compression, max)
+	}
+	return opts, nil
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// rawPrefix is the prefix of uncompressed chunks. It distinguishes
+// them from compressed chunks whose contents happen to begin with a
+// compression format's magic number.
+const rawPrefix = 0
+
+// Zstd encoders and decoders are expensive to create, and safe for
+// concurrent use: they are created once for each level and
+// dictionary, and shared.
+var (
+	zstdMu       sync.Mutex
+	zstdEncoders = make(map[zstdKey]*zstd.Encoder)
+	zstdDecoders = make(map[uint32]*zstd.Decoder)
+)
+
+type zstdKey struct {
+	level int
+	dict  uint32
+}
+
+func zstdEncoder(level int, dict *Dictionary) (*zstd.Encoder, error) {
+	key := zstdKey{level: level}
+	var opts []zstd.EOption
+	if level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	if dict != nil {
+		key.dict = dict.ID
+		// Zstd's (default) double fast encoder ignores its dictionary
+		// in its first encoding. Encoders with dictionaries thus use a
+		// single block encoder, which is primed when it is created.
+		opts = append(opts, zstd.WithEncoderDictRaw(dict.ID, dict.Content), zstd.WithEncoderConcurrency(1))
+	}
+	zstdMu.Lock()
+	defer zstdMu.Unlock()
+	if enc := zstdEncoders[key]; enc != nil {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if dict != nil {
+		enc.EncodeAll([]byte{0}, nil)
+	}
+	zstdEncoders[key] = enc
+	return enc, nil
+}
+
+func zstdDecoder(dict *Dictionary) (*zstd.Decoder, error) {
+	var (
+		id   uint32
+		opts []zstd.DOption
+	)
+	if dict != nil {
+		id = dict.ID
+		opts = append(opts, zstd.WithDecoderDictRaw(dict.ID, dict.Content))
+	}
+	zstdMu.Lock()
+	defer zstdMu.Unlock()
+	if dec := zstdDecoders[id]; dec != nil {
+		return dec, nil
+	}
+	dec, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	zstdDecoders[id] = dec
+	return dec, nil
+}
+
+// Encode returns the chunk that stores p with the provided
+// compression scheme, at its default level.
+func Encode(c Compression, p []byte) ([]byte, error) {
+	return Options{Compression: c}.Encode(p)
+}
+
+// Encode returns the chunk that stores p with the options'
+// compression scheme, level, and dictionary.
+func (o Options) Encode(p []byte) ([]byte, error) {
+	switch o.Compression {
+	case Gzip:
+		level := o.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		var b bytes.Buffer
+		w, err := gzip.NewWriterLevel(&b, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case Zstd:
+		enc, err := zstdEncoder(o.Level, o.Dictionary)
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(p, nil), nil
+	case None:
+		return append([]byte{rawPrefix}, p...), nil
+	}
+	return nil, fmt.Errorf("unknown log compression %v", o.Compression)
+}
+
+// Decode returns the contents of the provided chunk. The chunk's
+// compression scheme is determined from its contents. Chunks
+// compressed with a dictionary are decoded only if the dictionary
+// (see DictionaryID) is among those provided.
+func Decode(chunk []byte, dicts ...*Dictionary) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(chunk, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(chunk))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case bytes.HasPrefix(chunk, zstdMagic):
+		var dict *Dictionary
+		if id := DictionaryID(chunk); id != 0 {
+			for _, d := range dicts {
+				if d.ID == id {
+					dict = d
+					break
+				}
+			}
+			if dict == nil {
+				return nil, fmt.Errorf("logchunk: chunk is compressed with missing dictionary %d", id)
+			}
+		}
+		dec, err := zstdDecoder(dict)
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(chunk, nil)
+	case len(chunk) > 0 && chunk[0] == rawPrefix:
+		return chunk[1:], nil
+	}
+	// Uncompressed chunks written by earlier versions of pgdb carry
+	// no prefix.
+	return chunk, nil
+}
+
+// Options configure a Writer.
+type Options struct {
+	// Compression is the scheme with which chunks are compressed.
+	Compression Compression
+	// Level is the level at which chunks are compressed, on the
+	// scale of the compression scheme: 1 (fastest) to 9 (smallest)
+	// for gzip, and 1 to 22 for zstd, whose levels are approximated
+	// by the encoder's four speeds. Zero selects the scheme's default
+	// level.
+	Level int
+	// Dictionary, if non-nil, is the dictionary with which zstd
+	// chunks are compressed. It must be provided to Decode to decode
+	// them.
+	Dictionary *Dictionary
+	// BlockSize is the size of the blocks into which writes are
+	// coalesced. Defaults to DefaultBlockSize.
+	BlockSize int
+	// FlushInterval is the maximum time for which written data are
+	// buffered before they are flushed. Defaults to
+	// DefaultFlushInterval; a negative value disables timed flushes.
+	FlushInterval time.Duration
+}
+
+// A Writer coalesces writes into blocks, and stores each block, once
+// it is full, as a compressed chunk. Partial blocks are stored when
+// the writer is flushed or closed, and when data have been buffered
+// for the writer's flush interval. Writers are safe for concurrent
+// use.
+type Writer struct {
+	put  func(chunk []byte) error
+	opts Options
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+// NewWriter returns a new Writer that stores chunks with the
+// provided function, which is called serially.
+func NewWriter(put func(chunk []byte) error, opts Options) *Writer {
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultBlockSize
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	return &Writer{put: put, opts: opts}
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= w.opts.BlockSize {
+		if err := w.store(w.buf[:w.opts.BlockSize]); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[w.opts.BlockSize:]...)
+	}
+	if len(w.buf) > 0 && w.timer == nil && w.opts.FlushInterval > 0 {
+		w.timer = time.AfterFunc(w.opts.FlushInterval, func() { _ = w.Flush() })
+	}
+	return len(p), nil
+}
+
+// Flush stores any buffered data as a chunk.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.err != nil || len(w.buf) == 0 {
+		return w.err
+	}
+	if err := w.store(w.buf); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close flushes the writer.
+func (w *Writer) Close() error {
+	return w.Flush()
+}
+
+// Store compresses and stores the provided block. Errors are sticky.
+func (w *Writer) store(block []byte) error {
+	chunk, err := w.opts.Encode(block)
+	if err == nil {
+		err = w.put(chunk)
+	}
+	w.err = err
+	return err
+}
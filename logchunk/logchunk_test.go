@@ -0,0 +1,243 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package logchunk_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner/logchunk"
+)
+
+func TestEncode(t *testing.T) {
+	for _, p := range []string{"", "hello, world\n", "\x1f\x8b not gzip", "\x00raw", strings.Repeat("abc", 1000)} {
+		for _, c := range []logchunk.Compression{logchunk.Gzip, logchunk.Zstd, logchunk.None} {
+			chunk, err := logchunk.Encode(c, []byte(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := logchunk.Decode(chunk)
+			if err != nil {
+				t.Fatalf("%s %q: %v", c, p, err)
+			}
+			if got, want := string(q), p; got != want {
+				t.Errorf("%s: got %q, want %q", c, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte("gzipped\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for chunk, want := range map[string]string{
+		b.String(): "gzipped\n",
+		"raw\n":    "raw\n",
+	} {
+		p, err := logchunk.Decode([]byte(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(p); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range []logchunk.Compression{logchunk.Gzip, logchunk.Zstd, logchunk.None} {
+		d, err := logchunk.ParseCompression(c.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := d, c; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := logchunk.ParseCompression("lz4"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+type chunks struct {
+	mu     sync.Mutex
+	chunks []string
+}
+
+func (c *chunks) put(chunk []byte) error {
+	p, err := logchunk.Decode(chunk)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.chunks = append(c.chunks, string(p))
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *chunks) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.chunks...)
+}
+
+func TestWriter(t *testing.T) {
+	var c chunks
+	w := logchunk.NewWriter(c.put, logchunk.Options{Compression: logchunk.Zstd, BlockSize: 4, FlushInterval: -1})
+	for _, p := range []string{"a", "bc", "defghij", "k"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := strings.Join(c.get(), "|"), "abcd|efgh"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(c.get(), "|"), "abcd|efgh|ijk"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWriterFlushInterval(t *testing.T) {
+	var c chunks
+	w := logchunk.NewWriter(c.put, logchunk.Options{FlushInterval: 10 * time.Millisecond})
+	if _, err := w.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, want := strings.Join(c.get(), "|"), "hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(c.get()), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	for spec, want := range map[string]logchunk.Options{
+		"gzip":    {Compression: logchunk.Gzip},
+		"gzip:1":  {Compression: logchunk.Gzip, Level: 1},
+		"zstd:19": {Compression: logchunk.Zstd, Level: 19},
+		"none":    {Compression: logchunk.None},
+	} {
+		opts, err := logchunk.ParseOptions(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := opts; got != want {
+			t.Errorf("%s: got %v, want %v", spec, got, want)
+		}
+	}
+	for _, spec := range []string{"lz4", "gzip:10", "zstd:0", "zstd:fast", "none:1"} {
+		if _, err := logchunk.ParseOptions(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	p := []byte(strings.Repeat("step 1 loss 0.25 accuracy 0.5\n", 100))
+	for _, opts := range []logchunk.Options{
+		{Compression: logchunk.Gzip, Level: 1},
+		{Compression: logchunk.Gzip, Level: 9},
+		{Compression: logchunk.Zstd, Level: 1},
+		{Compression: logchunk.Zstd, Level: 22},
+	} {
+		chunk, err := opts.Encode(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := logchunk.Decode(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, q) {
+			t.Errorf("%v: chunk does not round-trip", opts)
+		}
+	}
+}
+
+// Logs returns a sample of a training script's logs.
+func logs(seed, n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		step := seed*n + i
+		fmt.Fprintf(&b, "2019-07-%02d 12:%02d:%02d INFO training: epoch %d step %d loss=%.4f accuracy=%.4f learning_rate=%g\n",
+			1+step%28, step%60, (step*7)%60, step/100, step, 1/float64(step+1), float64(step%1000)/1000, 0.001)
+	}
+	return b.Bytes()
+}
+
+func TestDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 20; i++ {
+		samples = append(samples, logs(i, 50))
+	}
+	dict, err := logchunk.TrainDictionary(samples, 4<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dict.Content); n == 0 || n > 4<<10 {
+		t.Fatalf("dictionary has %d bytes", n)
+	}
+	if !bytes.Contains(dict.Content, []byte(" INFO training: epoch ")) {
+		t.Errorf("dictionary %q lacks the logs' common text", dict.Content)
+	}
+	if got, want := logchunk.NewDictionary(dict.Content).ID, dict.ID; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	p := logs(100, 20)
+	plain, err := logchunk.Encode(logchunk.Zstd, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := logchunk.Options{Compression: logchunk.Zstd, Dictionary: dict}.Encode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(chunk), len(plain); got >= want {
+		t.Errorf("dictionary chunk has %d bytes, without dictionary %d", got, want)
+	}
+	if got, want := logchunk.DictionaryID(chunk), dict.ID; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := logchunk.DictionaryID(plain), uint32(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := logchunk.Decode(chunk); err == nil {
+		t.Error("expected error decoding without the dictionary")
+	}
+	q, err := logchunk.Decode(chunk, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, q) {
+		t.Errorf("got %q, want %q", q, p)
+	}
+
+	if _, err := logchunk.TrainDictionary([][]byte{[]byte("step 1\nepoch 2\n")}, 0); err == nil {
+		t.Error("expected error training on samples without repetition")
+	}
+}
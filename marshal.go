@@ -0,0 +1,534 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Values, parameters, metrics, and trials implement json.Marshaler
+// and json.Unmarshaler, so that they round-trip through JSON (e.g.,
+// in REST APIs and export files) without resorting to gob. Their
+// encodings are those of records (see MarshalRun): values are
+// single-member objects named by their kind, so that kinds are
+// preserved, and non-finite floats are strings. Parameters are
+// encoded likewise, as objects with a single member named by the
+// parameter's type:
+//
+//	{"range": {"start": {"int": 1}, "end": {"int": 10}}}
+//	{"log_range": {"start": {"float": 0.0001}, "end": {"float": 1}}}
+//	{"quantized_range": {"start": ..., "end": ..., "step": ...}}
+//	{"discrete": {"values": [...], "weights": [...]}}
+//	{"enum": ["small", "medium", "large"]}
+//	{"vector": [...]}
+//	{"conditional": {"param": ..., "on": "optimizer", "in": [...]}}
+//
+// The same types also implement the Marshaler and Unmarshaler
+// interfaces of gopkg.in/yaml.v2; their YAML encodings mirror their
+// JSON encodings.
+
+// MarshalJSON implements json.Marshaler.
+func (v Values) MarshalJSON() ([]byte, error) {
+	m, err := encodeValuesJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Values) UnmarshalJSON(p []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	values, err := decodeValuesJSON(m)
+	if err != nil {
+		return err
+	}
+	*v = values
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (v Values) MarshalYAML() (interface{}, error) { return marshalYAML(v) }
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (v *Values) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return unmarshalYAML(unmarshal, v)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m Metrics) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(encodeMetricsJSON(m))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Metrics) UnmarshalJSON(p []byte) error {
+	var rec map[string]jsonFloat
+	if err := json.Unmarshal(p, &rec); err != nil {
+		return err
+	}
+	if rec == nil {
+		*m = nil
+		return nil
+	}
+	*m = decodeMetricsJSON(rec)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (m Metrics) MarshalYAML() (interface{}, error) { return marshalYAML(m) }
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (m *Metrics) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return unmarshalYAML(unmarshal, m)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Params) MarshalJSON() ([]byte, error) {
+	if p == nil {
+		return []byte("null"), nil
+	}
+	m := make(map[string]json.RawMessage, len(p))
+	for name, param := range p {
+		var err error
+		if m[name], err = encodeParamJSON(param); err != nil {
+			return nil, fmt.Errorf("parameter %s: %v", name, err)
+		}
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Params) UnmarshalJSON(b []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if m == nil {
+		*p = nil
+		return nil
+	}
+	params := make(Params, len(m))
+	for name, b := range m {
+		var err error
+		if params[name], err = decodeParamJSON(b); err != nil {
+			return fmt.Errorf("parameter %s: %v", name, err)
+		}
+	}
+	*p = params
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (p Params) MarshalYAML() (interface{}, error) { return marshalYAML(p) }
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *Params) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return unmarshalYAML(unmarshal, p)
+}
+
+// TrialRecord is the JSON encoding of a trial. Its runs are encoded
+// as run records.
+type trialRecord struct {
+	Values           Values             `json:"values,omitempty"`
+	Metrics          Metrics            `json:"metrics,omitempty"`
+	Pending          bool               `json:"pending,omitempty"`
+	Infeasible       bool               `json:"infeasible,omitempty"`
+	Replicates       Replicates         `json:"replicates,omitempty"`
+	ReplicateMetrics map[string]Metrics `json:"replicate_metrics,omitempty"`
+	Runs             []runRecord        `json:"runs,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t Trial) MarshalJSON() ([]byte, error) {
+	rec := trialRecord{
+		Values:     t.Values,
+		Metrics:    t.Metrics,
+		Pending:    t.Pending,
+		Infeasible: t.Infeasible,
+		Replicates: t.Replicates,
+	}
+	if len(t.ReplicateMetrics) > 0 {
+		rec.ReplicateMetrics = make(map[string]Metrics, len(t.ReplicateMetrics))
+		for rep, metrics := range t.ReplicateMetrics {
+			rec.ReplicateMetrics[strconv.Itoa(rep)] = metrics
+		}
+	}
+	for _, run := range t.Runs {
+		r, err := encodeRun(run)
+		if err != nil {
+			return nil, err
+		}
+		rec.Runs = append(rec.Runs, r)
+	}
+	return json.Marshal(rec)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Trial) UnmarshalJSON(p []byte) error {
+	var rec trialRecord
+	if err := json.Unmarshal(p, &rec); err != nil {
+		return err
+	}
+	trial := Trial{
+		Values:     rec.Values,
+		Metrics:    rec.Metrics,
+		Pending:    rec.Pending,
+		Infeasible: rec.Infeasible,
+		Replicates: rec.Replicates,
+	}
+	if len(rec.ReplicateMetrics) > 0 {
+		trial.ReplicateMetrics = make(map[int]Metrics, len(rec.ReplicateMetrics))
+		for rep, metrics := range rec.ReplicateMetrics {
+			i, err := strconv.Atoi(rep)
+			if err != nil {
+				return fmt.Errorf("encoding: invalid replicate %q", rep)
+			}
+			trial.ReplicateMetrics[i] = metrics
+		}
+	}
+	for _, r := range rec.Runs {
+		run, err := decodeRun(r)
+		if err != nil {
+			return err
+		}
+		trial.Runs = append(trial.Runs, run)
+	}
+	*t = trial
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (t Trial) MarshalYAML() (interface{}, error) { return marshalYAML(t) }
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (t *Trial) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return unmarshalYAML(unmarshal, t)
+}
+
+type rangeRecord struct {
+	Start json.RawMessage `json:"start"`
+	End   json.RawMessage `json:"end"`
+	Step  json.RawMessage `json:"step,omitempty"`
+}
+
+type discreteRecord struct {
+	Values  []json.RawMessage `json:"values"`
+	Weights []float64         `json:"weights,omitempty"`
+}
+
+type conditionalRecord struct {
+	Param json.RawMessage   `json:"param"`
+	On    string            `json:"on"`
+	In    []json.RawMessage `json:"in"`
+}
+
+func encodeParamJSON(param Param) (json.RawMessage, error) {
+	var (
+		kind string
+		elem interface{}
+		err  error
+	)
+	switch param := param.(type) {
+	case *Range:
+		kind = "range"
+		elem, err = encodeRangeJSON(param.Start, param.End, nil)
+	case *LogRange:
+		kind = "log_range"
+		elem, err = encodeRangeJSON(param.Start, param.End, nil)
+	case *QuantizedRange:
+		kind = "quantized_range"
+		elem, err = encodeRangeJSON(param.Start, param.End, param.Step)
+	case *Discrete:
+		rec := discreteRecord{Weights: param.Weights}
+		kind, elem = "discrete", &rec
+		rec.Values, err = encodeListJSON(param.Values())
+	case *Enum:
+		kind, elem = "enum", param.Names
+	case *Vector:
+		elems := make([]json.RawMessage, len(param.Elems))
+		for i := range elems {
+			if elems[i], err = encodeParamJSON(param.Elems[i]); err != nil {
+				break
+			}
+		}
+		kind, elem = "vector", elems
+	case *Conditional:
+		rec := conditionalRecord{On: param.On}
+		kind, elem = "conditional", &rec
+		if rec.Param, err = encodeParamJSON(param.Param); err == nil {
+			rec.In, err = encodeListJSON(param.In)
+		}
+	default:
+		return nil, fmt.Errorf("encoding: cannot encode parameter %v of type %T", param, param)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{kind: elem})
+}
+
+func encodeRangeJSON(start, end, step Value) (*rangeRecord, error) {
+	rec := new(rangeRecord)
+	var err error
+	if rec.Start, err = encodeValueJSON(start); err != nil {
+		return nil, err
+	}
+	if rec.End, err = encodeValueJSON(end); err != nil {
+		return nil, err
+	}
+	if step != nil {
+		if rec.Step, err = encodeValueJSON(step); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+func encodeListJSON(values []Value) ([]json.RawMessage, error) {
+	list := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		var err error
+		if list[i], err = encodeValueJSON(v); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func decodeListJSON(list []json.RawMessage) ([]Value, error) {
+	values := make([]Value, len(list))
+	for i, p := range list {
+		var err error
+		if values[i], err = decodeValueJSON(p); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func decodeParamJSON(p json.RawMessage) (Param, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(p, &m); err != nil {
+		return nil, err
+	}
+	if len(m) != 1 {
+		return nil, fmt.Errorf("encoding: invalid parameter %s", p)
+	}
+	for kind, p := range m {
+		switch kind {
+		case "range", "log_range", "quantized_range":
+			var rec rangeRecord
+			if err := json.Unmarshal(p, &rec); err != nil {
+				return nil, err
+			}
+			list := []json.RawMessage{rec.Start, rec.End}
+			if kind == "quantized_range" {
+				list = append(list, rec.Step)
+			}
+			values, err := decodeListJSON(list)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range values {
+				if k := v.Kind(); k != values[0].Kind() || (k != Integer && k != Real) {
+					return nil, fmt.Errorf("encoding: invalid %s bounds %v", kind, values)
+				}
+			}
+			switch kind {
+			case "range":
+				if values[1].Less(values[0]) {
+					return nil, fmt.Errorf("encoding: invalid range %v", values)
+				}
+				return &Range{Start: values[0], End: values[1]}, nil
+			case "log_range":
+				if values[0].Float() <= 0 || values[1].Less(values[0]) {
+					return nil, fmt.Errorf("encoding: invalid log range %v", values)
+				}
+				return &LogRange{Start: values[0], End: values[1]}, nil
+			default:
+				if values[2].Float() <= 0 || values[1].Less(values[0]) {
+					return nil, fmt.Errorf("encoding: invalid quantized range %v", values)
+				}
+				return &QuantizedRange{Start: values[0], End: values[1], Step: values[2]}, nil
+			}
+		case "discrete":
+			var rec discreteRecord
+			if err := json.Unmarshal(p, &rec); err != nil {
+				return nil, err
+			}
+			values, err := decodeListJSON(rec.Values)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) == 0 {
+				return nil, errors.New("encoding: discrete parameter has no values")
+			}
+			for _, v := range values {
+				if v.Kind() != values[0].Kind() {
+					return nil, fmt.Errorf("encoding: discrete parameter has mixed kinds %s and %s", values[0].Kind(), v.Kind())
+				}
+			}
+			d := &Discrete{DiscreteValues: values, DiscreteKind: values[0].Kind()}
+			if rec.Weights != nil {
+				if err := checkWeights(rec.Weights, len(values)); err != nil {
+					return nil, fmt.Errorf("encoding: %v", err)
+				}
+				d.Weights = rec.Weights
+			}
+			return d, nil
+		case "enum":
+			var names []string
+			if err := json.Unmarshal(p, &names); err != nil {
+				return nil, err
+			}
+			seen := make(map[string]bool, len(names))
+			for _, name := range names {
+				if seen[name] {
+					return nil, fmt.Errorf("encoding: enum name %s is repeated", name)
+				}
+				seen[name] = true
+			}
+			if len(names) == 0 {
+				return nil, errors.New("encoding: enum has no names")
+			}
+			return &Enum{Names: names}, nil
+		case "vector":
+			var list []json.RawMessage
+			if err := json.Unmarshal(p, &list); err != nil {
+				return nil, err
+			}
+			elems := make([]Param, len(list))
+			for i, p := range list {
+				var err error
+				if elems[i], err = decodeParamJSON(p); err != nil {
+					return nil, err
+				}
+			}
+			return &Vector{Elems: elems}, nil
+		case "conditional":
+			var rec conditionalRecord
+			if err := json.Unmarshal(p, &rec); err != nil {
+				return nil, err
+			}
+			param, err := decodeParamJSON(rec.Param)
+			if err != nil {
+				return nil, err
+			}
+			in, err := decodeListJSON(rec.In)
+			if err != nil {
+				return nil, err
+			}
+			if len(in) == 0 {
+				return nil, errors.New("encoding: conditional parameter has no values")
+			}
+			return &Conditional{Param: param, On: rec.On, In: in}, nil
+		default:
+			return nil, fmt.Errorf("encoding: invalid parameter type %s", kind)
+		}
+	}
+	panic("not reached")
+}
+
+// MarshalYAML returns the YAML representation of m: the generic
+// (maps, slices, and scalars) decoding of its JSON encoding.
+func marshalYAML(m json.Marshaler) (interface{}, error) {
+	p, err := m.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return fromJSONNumbers(v), nil
+}
+
+// UnmarshalYAML decodes the YAML representation of u, as produced by
+// marshalYAML, by way of its JSON encoding.
+func unmarshalYAML(unmarshal func(interface{}) error, u json.Unmarshaler) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	v, err := toJSONGeneric(v)
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalJSON(p)
+}
+
+// FromJSONNumbers replaces the json.Numbers in v with int64s, where
+// they are integral, and float64s, so that integers are not rounded.
+func fromJSONNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = fromJSONNumbers(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = fromJSONNumbers(elem)
+		}
+	}
+	return v
+}
+
+// ToJSONGeneric converts the generic YAML decoding v to a value that
+// encoding/json can marshal: YAML's maps are keyed by arbitrary
+// values.
+func toJSONGeneric(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			var err error
+			if m[fmt.Sprint(key)], err = toJSONGeneric(elem); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		for i, elem := range v {
+			var err error
+			if v[i], err = toJSONGeneric(elem); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case float64:
+		// YAML admits non-finite floats; JSON (and thus our encoding)
+		// represents them as strings.
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return jsonFloat(v), nil
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var testParams = diviner.Params{
+	"layers":    diviner.NewRange(diviner.Int(1), diviner.Int(10)),
+	"lr":        diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1)),
+	"batch":     diviner.NewQuantizedRange(diviner.Int(32), diviner.Int(256), diviner.Int(32)),
+	"optimizer": diviner.NewWeightedDiscrete([]diviner.Value{diviner.String("sgd"), diviner.String("adam")}, []float64{1, 3}),
+	"size":      diviner.NewEnum("small", "medium", "large"),
+	"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+	"widths":    diviner.NewVector(diviner.NewDiscrete(diviner.Int(32), diviner.Int(64)), diviner.NewRange(diviner.Float(0), diviner.Float(1))),
+}
+
+func TestValuesJSON(t *testing.T) {
+	p, err := json.Marshal(testValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(p, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := doc["int"], map[string]interface{}{"int": float64(-123)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var values diviner.Values
+	if err := json.Unmarshal(p, &values); err != nil {
+		t.Fatal(err)
+	}
+	if !values.Equal(testValues) {
+		t.Errorf("got %v, want %v", values, testValues)
+	}
+	if err := json.Unmarshal([]byte(`{"x": {"complex": 1}}`), &values); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestMetricsJSON(t *testing.T) {
+	metrics := diviner.Metrics{"acc": 0.5, "loss": math.Inf(1)}
+	p, err := json.Marshal(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), `{"acc":0.5,"loss":"Infinity"}`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var decoded diviner.Metrics
+	if err := json.Unmarshal(p, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, metrics) {
+		t.Errorf("got %v, want %v", decoded, metrics)
+	}
+}
+
+func TestParamsJSON(t *testing.T) {
+	p, err := json.Marshal(testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params diviner.Params
+	if err := json.Unmarshal(p, &params); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(params, testParams) {
+		t.Errorf("got %v, want %v", params, testParams)
+	}
+	for _, bad := range []string{
+		`{"x": {"range": {"start": {"int": 1}, "end": {"float": 2}}}}`,
+		`{"x": {"log_range": {"start": {"float": 0}, "end": {"float": 1}}}}`,
+		`{"x": {"discrete": {"values": []}}}`,
+		`{"x": {"discrete": {"values": [{"int": 1}], "weights": [1, 2]}}}`,
+		`{"x": {"enum": ["a", "a"]}}`,
+		`{"x": {"lattice": {}}}`,
+	} {
+		if err := json.Unmarshal([]byte(bad), &params); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func testTrial() diviner.Trial {
+	run := testRun()
+	trial := run.Trial()
+	trial.Metrics["loss"] = math.NaN()
+	trial.Infeasible = true
+	trial.ReplicateMetrics = map[int]diviner.Metrics{1: {"acc": 0.9}}
+	return trial
+}
+
+func checkTrial(t *testing.T, got, want diviner.Trial) {
+	t.Helper()
+	if !got.Values.Equal(want.Values) {
+		t.Errorf("got %v, want %v", got.Values, want.Values)
+	}
+	if !math.IsNaN(got.Metrics["loss"]) {
+		t.Errorf("got %v, want NaN", got.Metrics["loss"])
+	}
+	if got, want := got.Metrics["acc"], want.Metrics["acc"]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := got.Infeasible, want.Infeasible; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := got.Replicates, want.Replicates; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := got.ReplicateMetrics, want.ReplicateMetrics; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(got.Runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := got.Runs[0].ID(), want.Runs[0].ID(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := got.Runs[0].Config.Script, want.Runs[0].Config.Script; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTrialJSON(t *testing.T) {
+	trial := testTrial()
+	p, err := json.Marshal(trial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded diviner.Trial
+	if err := json.Unmarshal(p, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	checkTrial(t, decoded, trial)
+}
+
+func TestYAML(t *testing.T) {
+	p, err := yaml.Marshal(testValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values diviner.Values
+	if err := yaml.Unmarshal(p, &values); err != nil {
+		t.Fatal(err)
+	}
+	if !values.Equal(testValues) {
+		t.Errorf("got %v, want %v", values, testValues)
+	}
+
+	if p, err = yaml.Marshal(testParams); err != nil {
+		t.Fatal(err)
+	}
+	var params diviner.Params
+	if err := yaml.Unmarshal(p, &params); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(params, testParams) {
+		t.Errorf("got %v, want %v", params, testParams)
+	}
+
+	trial := testTrial()
+	if p, err = yaml.Marshal(trial); err != nil {
+		t.Fatal(err)
+	}
+	var decoded diviner.Trial
+	if err := yaml.Unmarshal(p, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	checkTrial(t, decoded, trial)
+
+	// Values may be written by hand.
+	var hand diviner.Values
+	if err := yaml.Unmarshal([]byte("lr: {float: .inf}\nlayers: {int: 3}\n"), &hand); err != nil {
+		t.Fatal(err)
+	}
+	if want := (diviner.Values{"lr": diviner.Float(math.Inf(1)), "layers": diviner.Int(3)}); !hand.Equal(want) {
+		t.Errorf("got %v, want %v", hand, want)
+	}
+}
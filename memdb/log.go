@@ -0,0 +1,102 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package memdb
+
+import (
+	"io"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+type logWriter struct {
+	db    *DB
+	study string
+	seq   uint64
+}
+
+// Logger implements diviner.Database. Writes are appended to the
+// run's log as they are made.
+func (d *DB) Logger(study string, seq uint64) io.WriteCloser {
+	return logWriter{d, study, seq}
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	entry := logEntry{time: time.Now(), p: append([]byte{}, p...)}
+	err := w.db.update(w.study, w.seq, func(r *run) {
+		r.log = append(r.log, entry)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op: writes are not buffered.
+func (logWriter) Flush() error { return nil }
+
+// Close is a no-op.
+func (logWriter) Close() error { return nil }
+
+type logReader struct {
+	db     *DB
+	study  string
+	seq    uint64
+	since  time.Time
+	follow bool
+	// Index is the index of the next log entry to be read.
+	index int
+	buf   []byte
+}
+
+// Log implements diviner.Database. If since is nonzero, only writes
+// made at or after since are read. Followed logs end when the run
+// completes.
+func (d *DB) Log(study string, seq uint64, since time.Time, follow bool) io.Reader {
+	return &logReader{db: d, study: study, seq: seq, since: since, follow: follow}
+}
+
+func (r *logReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		r.db.mu.Lock()
+		run, ok := r.db.lookup(r.study, r.seq)
+		if !ok {
+			r.db.mu.Unlock()
+			return 0, diviner.ErrNotExist
+		}
+		for r.index < len(run.log) && run.log[r.index].time.Before(r.since) {
+			r.index++
+		}
+		if r.index < len(run.log) {
+			r.buf = run.log[r.index].p
+			r.index++
+			r.db.mu.Unlock()
+			continue
+		}
+		// The run's logs are complete once it has completed: the
+		// runner closes its logger before updating the run's final
+		// state.
+		var (
+			done    = !r.follow || run.done()
+			changed = r.db.changed
+		)
+		r.db.mu.Unlock()
+		if done {
+			return 0, io.EOF
+		}
+		// The run's keepalive may expire without the database
+		// changing, so we wake up periodically.
+		select {
+		case <-changed:
+		case <-time.After(keepaliveInterval):
+		}
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return
+}
@@ -0,0 +1,564 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package memdb implements a diviner database entirely in memory.
+// Its semantics are those of localdb: pending runs whose keepalives
+// have expired are reported as failed, and followed logs end when
+// their runs complete. The database's contents are lost when the
+// process exits; memdb is thus suited to tests and throwaway
+// studies.
+//
+// Unlike localdb, memdb stores each write to a run's log as it is
+// made, and so supports reading logs since a given time.
+package memdb
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+const keepaliveInterval = 30 * time.Second
+
+// DB implements diviner.Database in memory. DBs are safe for
+// concurrent use.
+type DB struct {
+	mu       sync.Mutex
+	studies  map[string]*study
+	datasets map[string]diviner.DatasetRecord
+	// Changed is closed, and replaced, whenever a run or its log is
+	// updated, waking followers of the run's log.
+	changed chan struct{}
+}
+
+type study struct {
+	meta    diviner.Study
+	updated time.Time
+	seq     uint64
+	runs    map[uint64]*run
+	oracle  []byte
+	queue   []diviner.Values
+	lease   diviner.Lease
+}
+
+type run struct {
+	diviner.Run
+	log []logEntry
+}
+
+// A logEntry is a single write to a run's log.
+type logEntry struct {
+	time time.Time
+	p    []byte
+}
+
+var _ diviner.Database = (*DB)(nil)
+
+// New returns a new, empty database.
+func New() *DB {
+	return &DB{
+		studies:  make(map[string]*study),
+		datasets: make(map[string]diviner.DatasetRecord),
+		changed:  make(chan struct{}),
+	}
+}
+
+// CreateTable is a no-op.
+func (*DB) CreateTable(_ context.Context) error {
+	return nil
+}
+
+// CreateStudyIfNotExist implements diviner.Database.
+func (d *DB) CreateStudyIfNotExist(ctx context.Context, meta diviner.Study) (created bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.studies[meta.Name]; ok {
+		return false, nil
+	}
+	d.studies[meta.Name] = &study{
+		meta:    meta,
+		updated: time.Now(),
+		runs:    make(map[uint64]*run),
+	}
+	return true, nil
+}
+
+// LookupStudy implements diviner.Database.
+func (d *DB) LookupStudy(ctx context.Context, name string) (diviner.Study, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[name]
+	if !ok {
+		return diviner.Study{}, diviner.ErrNotExist
+	}
+	return s.meta, nil
+}
+
+// UpdateStudy implements diviner.Database.
+func (d *DB) UpdateStudy(ctx context.Context, meta diviner.Study) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[meta.Name]
+	if !ok {
+		return diviner.ErrNotExist
+	}
+	s.meta = meta
+	s.updated = time.Now()
+	return nil
+}
+
+// ListStudies implements diviner.Database. Studies are returned in
+// order of their names.
+func (d *DB) ListStudies(ctx context.Context, prefix string, since time.Time) ([]diviner.Study, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var studies []diviner.Study
+	for name, s := range d.studies {
+		if strings.HasPrefix(name, prefix) && !s.updated.Before(since) {
+			studies = append(studies, s.meta)
+		}
+	}
+	sort.Slice(studies, func(i, j int) bool { return studies[i].Name < studies[j].Name })
+	return studies, nil
+}
+
+// NextSeq implements diviner.Database.
+func (d *DB) NextSeq(ctx context.Context, name string) (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[name]
+	if !ok {
+		return 0, diviner.ErrNotExist
+	}
+	s.seq++
+	return s.seq, nil
+}
+
+// InsertRun implements diviner.Database.
+func (d *DB) InsertRun(ctx context.Context, r diviner.Run) (diviner.Run, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[r.Study]
+	if !ok {
+		return diviner.Run{}, diviner.ErrNotExist
+	}
+	if s.meta.Archived {
+		return diviner.Run{}, diviner.ErrArchived
+	}
+	if r.Seq == 0 {
+		s.seq++
+		r.Seq = s.seq
+	}
+	r.Created = time.Now()
+	r.Updated = r.Created
+	r.State = diviner.Pending
+	// As in localdb, reinserted runs retain their metrics and logs.
+	stored := &run{Run: copyRun(r)}
+	stored.Metrics = nil
+	if prev, ok := s.runs[r.Seq]; ok {
+		stored.Metrics, stored.log = prev.Metrics, prev.log
+	}
+	s.runs[r.Seq] = stored
+	s.updated = time.Now()
+	d.notify()
+	r.Metrics = copyMetrics(stored.Metrics)
+	return r, nil
+}
+
+// UpdateRun implements diviner.Database.
+func (d *DB) UpdateRun(ctx context.Context, study string, seq uint64, state diviner.RunState, message string, runtime time.Duration, retry int) error {
+	return d.update(study, seq, func(r *run) {
+		r.Updated = time.Now()
+		if r.Started.IsZero() && runtime > 0 {
+			r.Started = r.Updated.Add(-runtime)
+		}
+		if state != diviner.Pending && r.Completed.IsZero() {
+			r.Completed = r.Updated
+		}
+		r.State = state
+		r.Status = message
+		r.Runtime = runtime
+		r.Retries = retry
+		// Update the study time as well, so that it shows up properly
+		// in listings.
+		d.studies[study].updated = time.Now()
+	})
+}
+
+// AppendRunMetrics implements diviner.Database.
+func (d *DB) AppendRunMetrics(ctx context.Context, study string, seq uint64, metrics diviner.Metrics) error {
+	return d.update(study, seq, func(r *run) {
+		r.Metrics = append(r.Metrics, copyMetrics([]diviner.Metrics{metrics})...)
+	})
+}
+
+// ListRuns implements diviner.Database. Runs are returned in sequence
+// order.
+func (d *DB) ListRuns(ctx context.Context, study string, states diviner.RunState, since time.Time) ([]diviner.Run, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok {
+		return nil, diviner.ErrNotExist
+	}
+	var runs []diviner.Run
+	for _, r := range s.runs {
+		if r.Updated.Before(since) {
+			continue
+		}
+		run := r.get()
+		if run.State&states == run.State {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Seq < runs[j].Seq })
+	return runs, nil
+}
+
+// Scan implements diviner.Database. The study's runs are read all at
+// once, when the iterator is first advanced.
+func (d *DB) Scan(ctx context.Context, study string, states diviner.RunState) diviner.RunIterator {
+	return diviner.NewRunIterator(func() ([]diviner.Run, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		runs, err := d.ListRuns(ctx, study, states, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		return runs, io.EOF
+	})
+}
+
+// Query implements diviner.Database. As runs' metrics are not
+// indexed, all of the study's runs in the queried states are
+// scanned.
+func (d *DB) Query(ctx context.Context, study string, query diviner.Query) ([]diviner.Run, error) {
+	return diviner.QueryRuns(ctx, d, study, query)
+}
+
+// BestRuns implements diviner.Database. As with Query, the study's
+// successful runs are scanned.
+func (d *DB) BestRuns(ctx context.Context, study string, objective diviner.Objective, k int) ([]diviner.Run, error) {
+	return diviner.RankRuns(ctx, d, study, objective, k)
+}
+
+// LookupRun implements diviner.Database.
+func (d *DB) LookupRun(ctx context.Context, study string, seq uint64) (diviner.Run, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.lookup(study, seq)
+	if !ok {
+		return diviner.Run{}, diviner.ErrNotExist
+	}
+	return r.get(), nil
+}
+
+// SetRunLabels implements diviner.Database.
+func (d *DB) SetRunLabels(ctx context.Context, study string, seq uint64, labels diviner.Labels) error {
+	return d.update(study, seq, func(r *run) {
+		r.Labels = copyLabels(labels)
+	})
+}
+
+// SetRunArtifacts implements diviner.Database.
+func (d *DB) SetRunArtifacts(ctx context.Context, study string, seq uint64, artifacts []diviner.Artifact) error {
+	return d.update(study, seq, func(r *run) {
+		r.Artifacts = append([]diviner.Artifact(nil), artifacts...)
+	})
+}
+
+// SetRunExit implements diviner.Database.
+func (d *DB) SetRunExit(ctx context.Context, study string, seq uint64, exit diviner.RunExit) error {
+	return d.update(study, seq, func(r *run) {
+		r.Exit = &exit
+	})
+}
+
+// SetRunCost implements diviner.Database.
+func (d *DB) SetRunCost(ctx context.Context, study string, seq uint64, cost diviner.RunCost) error {
+	return d.update(study, seq, func(r *run) {
+		r.Cost = &cost
+	})
+}
+
+// DeleteRun implements diviner.Database. The run's metrics and log
+// are removed along with it.
+func (d *DB) DeleteRun(ctx context.Context, study string, seq uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.lookup(study, seq); !ok {
+		return diviner.ErrNotExist
+	}
+	s := d.studies[study]
+	if s.meta.Archived {
+		return diviner.ErrArchived
+	}
+	delete(s.runs, seq)
+	s.updated = time.Now()
+	d.notify()
+	return nil
+}
+
+// DeleteRunLogs implements diviner.Database.
+func (d *DB) DeleteRunLogs(ctx context.Context, study string, seq uint64) error {
+	return d.update(study, seq, func(r *run) {
+		r.log = nil
+	})
+}
+
+// DeleteStudy implements diviner.Database. All of the study's runs,
+// and their metrics and logs, are removed along with it.
+func (d *DB) DeleteStudy(ctx context.Context, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.studies[name]; !ok {
+		return diviner.ErrNotExist
+	}
+	delete(d.studies, name)
+	d.notify()
+	return nil
+}
+
+// LookupDataset implements diviner.Database.
+func (d *DB) LookupDataset(ctx context.Context, name string) (diviner.DatasetRecord, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	record, ok := d.datasets[name]
+	if !ok {
+		return diviner.DatasetRecord{}, diviner.ErrNotExist
+	}
+	return record, nil
+}
+
+// SetDataset implements diviner.Database.
+func (d *DB) SetDataset(ctx context.Context, record diviner.DatasetRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.datasets[record.Name] = record
+	return nil
+}
+
+// InvalidateDataset implements diviner.Database.
+func (d *DB) InvalidateDataset(ctx context.Context, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.datasets[name]; !ok {
+		return diviner.ErrNotExist
+	}
+	delete(d.datasets, name)
+	return nil
+}
+
+// LookupOracleState implements diviner.Database.
+func (d *DB) LookupOracleState(ctx context.Context, study string) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok || s.oracle == nil {
+		return nil, diviner.ErrNotExist
+	}
+	return append([]byte{}, s.oracle...), nil
+}
+
+// SetOracleState implements diviner.Database.
+func (d *DB) SetOracleState(ctx context.Context, study string, state []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok {
+		return diviner.ErrNotExist
+	}
+	s.oracle = append([]byte{}, state...)
+	return nil
+}
+
+// AcquireLease implements diviner.Database.
+func (d *DB) AcquireLease(ctx context.Context, study string, lease diviner.Lease) (diviner.Lease, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok {
+		return diviner.Lease{}, false, diviner.ErrNotExist
+	}
+	held, acquired := lease.Take(s.lease, time.Now())
+	s.lease = held
+	return held, acquired, nil
+}
+
+// ReleaseLease implements diviner.Database.
+func (d *DB) ReleaseLease(ctx context.Context, study, owner string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok {
+		return diviner.ErrNotExist
+	}
+	if s.lease.Owner == owner {
+		s.lease = diviner.Lease{}
+	}
+	return nil
+}
+
+// EnqueueValues implements diviner.Database.
+func (d *DB) EnqueueValues(ctx context.Context, study string, values diviner.Values) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok {
+		return diviner.ErrNotExist
+	}
+	if s.meta.Archived {
+		return diviner.ErrArchived
+	}
+	copy := make(diviner.Values, len(values))
+	for name, v := range values {
+		copy[name] = v
+	}
+	s.queue = append(s.queue, copy)
+	return nil
+}
+
+// DequeueValues implements diviner.Database.
+func (d *DB) DequeueValues(ctx context.Context, study string, n int) ([]diviner.Values, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok {
+		return nil, diviner.ErrNotExist
+	}
+	if n <= 0 || n > len(s.queue) {
+		n = len(s.queue)
+	}
+	values := append([]diviner.Values{}, s.queue[:n]...)
+	s.queue = s.queue[n:]
+	return values, nil
+}
+
+// QueuedValues implements diviner.Database.
+func (d *DB) QueuedValues(ctx context.Context, study string) ([]diviner.Values, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.studies[study]
+	if !ok {
+		return nil, diviner.ErrNotExist
+	}
+	return append([]diviner.Values{}, s.queue...), nil
+}
+
+// Lookup returns the named run. It must be called with d.mu held.
+func (d *DB) lookup(study string, seq uint64) (*run, bool) {
+	s, ok := d.studies[study]
+	if !ok {
+		return nil, false
+	}
+	r, ok := s.runs[seq]
+	return r, ok
+}
+
+// Update applies the provided function to the named run, which is
+// called with d.mu held. Runs of archived studies may not be updated.
+func (d *DB) update(study string, seq uint64, fn func(r *run)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.lookup(study, seq)
+	if !ok {
+		return diviner.ErrNotExist
+	}
+	if d.studies[study].meta.Archived {
+		return diviner.ErrArchived
+	}
+	fn(r)
+	d.notify()
+	return nil
+}
+
+// Notify wakes the database's log followers. It must be called with
+// d.mu held.
+func (d *DB) notify() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// Get returns a copy of the run; pending runs whose keepalives have
+// expired are reported as failed.
+func (r *run) get() diviner.Run {
+	run := copyRun(r.Run)
+	if r.expired() {
+		run.State = diviner.Failure
+	}
+	return run
+}
+
+// Expired tells whether the run is pending, but its keepalive has
+// expired.
+func (r *run) expired() bool {
+	return r.State == diviner.Pending && time.Since(r.Updated) > 2*keepaliveInterval
+}
+
+// Done tells whether the run has completed, either because it is no
+// longer pending, or because its keepalive has expired.
+func (r *run) done() bool {
+	return r.State != diviner.Pending || r.expired()
+}
+
+// CopyRun returns a copy of the provided run that shares no mutable
+// state with it.
+func copyRun(r diviner.Run) diviner.Run {
+	if r.Values != nil {
+		values := make(diviner.Values, len(r.Values))
+		for k, v := range r.Values {
+			values[k] = v
+		}
+		r.Values = values
+	}
+	r.Labels = copyLabels(r.Labels)
+	if r.Artifacts != nil {
+		r.Artifacts = append([]diviner.Artifact(nil), r.Artifacts...)
+	}
+	if r.Exit != nil {
+		exit := *r.Exit
+		r.Exit = &exit
+	}
+	if r.Cost != nil {
+		cost := *r.Cost
+		r.Cost = &cost
+	}
+	if r.Source != nil {
+		source := *r.Source
+		r.Source = &source
+	}
+	r.Metrics = copyMetrics(r.Metrics)
+	return r
+}
+
+func copyLabels(labels diviner.Labels) diviner.Labels {
+	if labels == nil {
+		return nil
+	}
+	copy := make(diviner.Labels, len(labels))
+	for k, v := range labels {
+		copy[k] = v
+	}
+	return copy
+}
+
+func copyMetrics(list []diviner.Metrics) []diviner.Metrics {
+	if list == nil {
+		return nil
+	}
+	copies := make([]diviner.Metrics, len(list))
+	for i, metrics := range list {
+		copies[i] = make(diviner.Metrics, len(metrics))
+		for k, v := range metrics {
+			copies[i][k] = v
+		}
+	}
+	return copies
+}
@@ -0,0 +1,455 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package memdb_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestDB(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if _, err := db.LookupStudy(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	study := diviner.Study{
+		Name:      "test",
+		Params:    diviner.Params{"x": diviner.NewRange(diviner.Int(0), diviner.Int(10))},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+	}
+	for _, want := range []bool{true, false} {
+		created, err := db.CreateStudyIfNotExist(ctx, study)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := created; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.LookupStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, study) {
+		t.Errorf("got %v, want %v", got, study)
+	}
+	studies, err := db.ListStudies(ctx, "te", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Name, "test"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for i := 0; i < 3; i++ {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(int64(i))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := run.Seq, uint64(i+1); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := run.State, diviner.Pending; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		for _, acc := range []float64{0.1, float64(i)} {
+			if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": acc}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.UpdateRun(ctx, "test", 2, diviner.Success, "done", time.Minute, 1); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.LookupRun(ctx, "test", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.State, diviner.Success; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Status, "done"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Retries, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Completed.Sub(run.Started), time.Minute; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Metrics, []diviner.Metrics{{"acc": 0.1}, {"acc": 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Returned runs are copies.
+	run.Metrics[0]["acc"] = 100
+	run.Values["x"] = diviner.Int(100)
+	if run, err := db.LookupRun(ctx, "test", 2); err != nil {
+		t.Fatal(err)
+	} else if got, want := run.Metrics[0]["acc"], 0.1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	} else if got, want := run.Values["x"], diviner.Int(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, test := range []struct {
+		states diviner.RunState
+		want   []uint64
+	}{
+		{diviner.Any, []uint64{1, 2, 3}},
+		{diviner.Pending, []uint64{1, 3}},
+		{diviner.Success, []uint64{2}},
+		{diviner.Failure, nil},
+	} {
+		runs, err := db.ListRuns(ctx, "test", test.states, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var seqs []uint64
+		for _, run := range runs {
+			seqs = append(seqs, run.Seq)
+		}
+		if got, want := seqs, test.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", test.states, got, want)
+		}
+	}
+	var seqs []uint64
+	for it := db.Scan(ctx, "test", diviner.Pending); it.Next(); {
+		seqs = append(seqs, it.Run().Seq)
+	}
+	if got, want := seqs, []uint64{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	best, err := db.BestRuns(ctx, "test", study.Objective, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(best), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := best[0].Seq, uint64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var query []string
+	runs, err := db.Query(ctx, "test", diviner.Query{MetricAbove: map[string]float64{"acc": 0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range runs {
+		query = append(query, fmt.Sprint(run.Seq))
+	}
+	if got, want := strings.Join(query, ","), "2,3"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for i := 0; i < 2; i++ {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, run.Seq)
+	}
+	if err := db.DeleteRun(ctx, "test", seqs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.DeleteRun(ctx, "test", seqs[0]), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := ioutil.ReadAll(db.Log("test", seqs[0], time.Time{}, false)); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if err := db.DeleteStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupRun(ctx, "test", seqs[1]); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := db.ListRuns(ctx, "test", diviner.Any, time.Time{}); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if got, want := db.UpdateStudy(ctx, diviner.Study{Name: "test"}), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := db.Logger("test", run.Seq)
+	fmt.Fprintln(w, "hello world")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteRunLogs(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, false)); err != nil {
+		t.Fatal(err)
+	} else if len(p) != 0 {
+		t.Errorf("unexpected log %q", p)
+	}
+	if got, want := db.DeleteRunLogs(ctx, "test", run.Seq+1), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	retention := diviner.Retention{FailedLogs: time.Hour, KeepBest: 2}
+	if err := db.UpdateStudy(ctx, diviner.Study{Name: "test", Archived: true, Retention: retention}); err != nil {
+		t.Fatal(err)
+	}
+	study, err := db.LookupStudy(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !study.Archived {
+		t.Error("study is not archived")
+	}
+	if got, want := study.Retention, retention; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.InsertRun(ctx, diviner.Run{Study: "test"}); err != diviner.ErrArchived {
+		t.Errorf("got %v, want %v", err, diviner.ErrArchived)
+	}
+	for _, err := range []error{
+		db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Minute, 0),
+		db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 1}),
+		db.SetRunLabels(ctx, "test", run.Seq, diviner.Labels{"x": "y"}),
+		db.DeleteRunLogs(ctx, "test", run.Seq),
+		db.DeleteRun(ctx, "test", run.Seq),
+		db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(1)}),
+	} {
+		if err != diviner.ErrArchived {
+			t.Errorf("got %v, want %v", err, diviner.ErrArchived)
+		}
+	}
+	if err := db.UpdateStudy(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logc := make(chan string)
+	go func() {
+		p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, true))
+		if err != nil {
+			t.Error(err)
+		}
+		logc <- string(p)
+	}()
+	w := db.Logger("test", run.Seq)
+	for _, line := range []string{"one\n", "two\n"} {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case log := <-logc:
+		t.Fatalf("log of pending run ended: %q", log)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "ok", time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-logc, "one\ntwo\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogSince(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := db.Logger("test", run.Seq)
+	if _, err := io.WriteString(w, "before\n"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	if _, err := io.WriteString(w, "after\n"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		since time.Time
+		want  string
+	}{
+		{time.Time{}, "before\nafter\n"},
+		{since, "after\n"},
+		{time.Now().Add(time.Hour), ""},
+	} {
+		p, err := ioutil.ReadAll(db.Log("test", run.Seq, test.since, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(p), test.want; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestOracleState(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if got, want := db.SetOracleState(ctx, "test", []byte("state")), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupOracleState(ctx, "test"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if err := db.SetOracleState(ctx, "test", []byte("state")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.LookupOracleState(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if string(got) != "state" {
+		t.Errorf("got %s, want state", got)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if got, want := db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(0)}), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(int64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if values, err := db.DequeueValues(ctx, "test", 2); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{{"x": diviner.Int(0)}, {"x": diviner.Int(1)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := db.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := db.QueuedValues(ctx, "test"); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{{"x": diviner.Int(2)}, {"x": diviner.Int(3)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.DequeueValues(ctx, "test", 10); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(values), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.DequeueValues(ctx, "test", 10); err != nil {
+		t.Fatal(err)
+	} else if len(values) != 0 {
+		t.Errorf("unexpected values %v", values)
+	}
+}
+
+func TestLease(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	lease := diviner.Lease{Owner: "a", Expires: time.Now().Add(time.Minute)}
+	if _, _, err := db.AcquireLease(ctx, "test", lease); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.AcquireLease(ctx, "test", lease); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	}
+	other := diviner.Lease{Owner: "b", Expires: time.Now().Add(time.Minute)}
+	if held, ok, err := db.AcquireLease(ctx, "test", other); err != nil {
+		t.Fatal(err)
+	} else if ok || held.Owner != "a" {
+		t.Errorf("got %v, %v, want lease of a", held, ok)
+	}
+	// Only the lease's owner may release it.
+	if err := db.ReleaseLease(ctx, "test", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.AcquireLease(ctx, "test", other); err != nil || ok {
+		t.Errorf("got %v, %v, want false", ok, err)
+	}
+	if err := db.ReleaseLease(ctx, "test", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.AcquireLease(ctx, "test", other); err != nil || !ok {
+		t.Errorf("got %v, %v, want true", ok, err)
+	}
+}
+
+func TestDatasets(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if _, err := db.LookupDataset(ctx, "data"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	record := diviner.DatasetRecord{Name: "data", Digest: "digest", Completed: time.Now()}
+	if err := db.SetDataset(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.LookupDataset(ctx, "data"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, record) {
+		t.Errorf("got %v, want %v", got, record)
+	}
+	if err := db.InvalidateDataset(ctx, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupDataset(ctx, "data"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mlflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+// DefaultTimeout is the default timeout of a request to the tracking
+// server.
+const DefaultTimeout = time.Minute
+
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Run statuses, as defined by MLflow.
+const (
+	Running  = "RUNNING"
+	Finished = "FINISHED"
+	Failed   = "FAILED"
+	Killed   = "KILLED"
+)
+
+// The maximum number of entries in a single LogBatch request, as
+// limited by the MLflow REST API.
+const (
+	maxBatchMetrics = 1000
+	maxBatchParams  = 100
+	maxBatchTags    = 100
+)
+
+// An Experiment is an MLflow experiment.
+type Experiment struct {
+	ID               string `json:"experiment_id"`
+	Name             string `json:"name"`
+	ArtifactLocation string `json:"artifact_location,omitempty"`
+	LifecycleStage   string `json:"lifecycle_stage,omitempty"`
+	Tags             []Tag  `json:"tags,omitempty"`
+}
+
+// RunInfo is the metadata of an MLflow run.
+type RunInfo struct {
+	RunID        string `json:"run_id"`
+	RunName      string `json:"run_name,omitempty"`
+	ExperimentID string `json:"experiment_id"`
+	Status       string `json:"status"`
+	// StartTime and EndTime are in milliseconds since the Unix epoch.
+	StartTime   int64  `json:"start_time,omitempty"`
+	EndTime     int64  `json:"end_time,omitempty"`
+	ArtifactURI string `json:"artifact_uri,omitempty"`
+}
+
+// A Metric is a single observation of an MLflow metric.
+type Metric struct {
+	Key   string  `json:"key"`
+	Value float64 `json:"value"`
+	// Timestamp is in milliseconds since the Unix epoch.
+	Timestamp int64 `json:"timestamp"`
+	Step      int64 `json:"step"`
+}
+
+// A Param is an MLflow run parameter. MLflow parameters are strings,
+// and cannot be changed once logged.
+type Param struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// A Tag is a key-value tag of an MLflow experiment or run.
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// RunData contains an MLflow run's latest metrics, its parameters,
+// and its tags.
+type RunData struct {
+	Metrics []Metric `json:"metrics,omitempty"`
+	Params  []Param  `json:"params,omitempty"`
+	Tags    []Tag    `json:"tags,omitempty"`
+}
+
+// A Run is an MLflow run.
+type Run struct {
+	Info RunInfo `json:"info"`
+	Data RunData `json:"data"`
+}
+
+// Tag returns the value of the run's tag with the provided key, and
+// whether the run has the tag.
+func (r Run) Tag(key string) (string, bool) {
+	for _, tag := range r.Data.Tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// Error is an error returned by the tracking server.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"-"`
+	// Code is MLflow's error code, e.g., "RESOURCE_DOES_NOT_EXIST".
+	Code    string `json:"error_code"`
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("mlflow: %s: %s", e.Code, e.Message)
+}
+
+// A Client issues requests to an MLflow tracking server through its
+// REST API [1].
+//
+// [1] https://mlflow.org/docs/latest/rest-api.html
+type Client struct {
+	// URL is the base URL of the tracking server, e.g.,
+	// "http://localhost:5000".
+	URL string
+	// Header contains additional headers (e.g., for authorization)
+	// that are included in each request.
+	Header http.Header
+	// Client is the HTTP client used to issue requests. If nil, a
+	// client with a timeout of DefaultTimeout is used.
+	Client *http.Client
+}
+
+// ExperimentByName returns the experiment with the provided name. It
+// returns diviner.ErrNotExist if there is no such experiment.
+func (c *Client) ExperimentByName(ctx context.Context, name string) (Experiment, error) {
+	var reply struct {
+		Experiment Experiment `json:"experiment"`
+	}
+	err := c.call(ctx, "GET", "experiments/get-by-name?experiment_name="+url.QueryEscape(name), nil, &reply)
+	return reply.Experiment, err
+}
+
+// CreateExperiment creates an experiment with the provided name and
+// tags, returning its ID.
+func (c *Client) CreateExperiment(ctx context.Context, name string, tags []Tag) (string, error) {
+	req := struct {
+		Name string `json:"name"`
+		Tags []Tag  `json:"tags,omitempty"`
+	}{name, tags}
+	var reply struct {
+		ID string `json:"experiment_id"`
+	}
+	err := c.call(ctx, "POST", "experiments/create", req, &reply)
+	return reply.ID, err
+}
+
+// CreateRun creates a run in the provided experiment, with the
+// provided name, start time, and tags.
+func (c *Client) CreateRun(ctx context.Context, experimentID, name string, start time.Time, tags []Tag) (RunInfo, error) {
+	req := struct {
+		ExperimentID string `json:"experiment_id"`
+		RunName      string `json:"run_name,omitempty"`
+		StartTime    int64  `json:"start_time"`
+		Tags         []Tag  `json:"tags,omitempty"`
+	}{experimentID, name, millis(start), tags}
+	var reply struct {
+		Run Run `json:"run"`
+	}
+	err := c.call(ctx, "POST", "runs/create", req, &reply)
+	return reply.Run.Info, err
+}
+
+// UpdateRun sets the status and end time of the run with the
+// provided ID. The end time is not updated if it is zero.
+func (c *Client) UpdateRun(ctx context.Context, runID, status string, end time.Time) error {
+	req := struct {
+		RunID   string `json:"run_id"`
+		Status  string `json:"status"`
+		EndTime int64  `json:"end_time,omitempty"`
+	}{runID, status, 0}
+	if !end.IsZero() {
+		req.EndTime = millis(end)
+	}
+	return c.call(ctx, "POST", "runs/update", req, nil)
+}
+
+// LogBatch logs the provided metrics, parameters, and tags to the run
+// with the provided ID. Large batches are split into several
+// requests, as required by the tracking server.
+func (c *Client) LogBatch(ctx context.Context, runID string, metrics []Metric, params []Param, tags []Tag) error {
+	for len(metrics) > 0 || len(params) > 0 || len(tags) > 0 {
+		req := struct {
+			RunID   string   `json:"run_id"`
+			Metrics []Metric `json:"metrics,omitempty"`
+			Params  []Param  `json:"params,omitempty"`
+			Tags    []Tag    `json:"tags,omitempty"`
+		}{RunID: runID}
+		n := len(metrics)
+		if n > maxBatchMetrics {
+			n = maxBatchMetrics
+		}
+		req.Metrics, metrics = metrics[:n], metrics[n:]
+		n = len(params)
+		if n > maxBatchParams {
+			n = maxBatchParams
+		}
+		req.Params, params = params[:n], params[n:]
+		n = len(tags)
+		if n > maxBatchTags {
+			n = maxBatchTags
+		}
+		req.Tags, tags = tags[:n], tags[n:]
+		if err := c.call(ctx, "POST", "runs/log-batch", req, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchRuns returns the runs of the provided experiments that match
+// the provided filter expression, which may be empty. See MLflow's
+// documentation for the syntax of filters.
+func (c *Client) SearchRuns(ctx context.Context, experimentIDs []string, filter string) ([]Run, error) {
+	var (
+		runs  []Run
+		token string
+	)
+	for {
+		req := struct {
+			ExperimentIDs []string `json:"experiment_ids"`
+			Filter        string   `json:"filter,omitempty"`
+			MaxResults    int      `json:"max_results"`
+			PageToken     string   `json:"page_token,omitempty"`
+		}{experimentIDs, filter, 1000, token}
+		var reply struct {
+			Runs          []Run  `json:"runs"`
+			NextPageToken string `json:"next_page_token"`
+		}
+		if err := c.call(ctx, "POST", "runs/search", req, &reply); err != nil {
+			return nil, err
+		}
+		runs = append(runs, reply.Runs...)
+		if reply.NextPageToken == "" {
+			return runs, nil
+		}
+		token = reply.NextPageToken
+	}
+}
+
+// UploadArtifact uploads the provided data as the artifact at the
+// provided path of a run, through the tracking server's artifact
+// proxy. UploadArtifact returns an error if the run's artifacts are
+// not stored by the proxy, i.e., if its artifact URI is not of the
+// form "mlflow-artifacts:/...".
+func (c *Client) UploadArtifact(ctx context.Context, info RunInfo, path string, data []byte) error {
+	const scheme = "mlflow-artifacts:"
+	if !strings.HasPrefix(info.ArtifactURI, scheme) {
+		return fmt.Errorf("mlflow: run %s: artifacts at %q are not served by the tracking server", info.RunID, info.ArtifactURI)
+	}
+	loc := strings.TrimPrefix(info.ArtifactURI, scheme)
+	// The URI may name the server, as in mlflow-artifacts://host/path.
+	if strings.HasPrefix(loc, "//") {
+		loc = loc[2:]
+		if i := strings.IndexByte(loc, '/'); i >= 0 {
+			loc = loc[i:]
+		} else {
+			loc = ""
+		}
+	}
+	loc = strings.Trim(loc, "/") + "/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequest("PUT", strings.TrimRight(c.URL, "/")+"/api/2.0/mlflow-artifacts/artifacts/"+loc, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, req, nil)
+}
+
+func (c *Client) call(ctx context.Context, method, endpoint string, body, reply interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(c.URL, "/")+"/api/2.0/mlflow/"+endpoint, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(ctx, req, reply)
+}
+
+func (c *Client) do(ctx context.Context, req *http.Request, reply interface{}) error {
+	req = req.WithContext(ctx)
+	for k, vs := range c.Header {
+		req.Header[k] = vs
+	}
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		e := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(msg, e) != nil || e.Code == "" {
+			e.Code = resp.Status
+			e.Message = string(bytes.TrimSpace(msg))
+		}
+		if e.Code == "RESOURCE_DOES_NOT_EXIST" {
+			return diviner.ErrNotExist
+		}
+		return e
+	}
+	if reply == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
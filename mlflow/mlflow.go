@@ -0,0 +1,380 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package mlflow bridges diviner and MLflow [1] tracking servers, so
+// that teams that track their experiments in MLflow may view diviner
+// studies there, and so that existing MLflow runs may warm-start
+// diviner studies.
+//
+// Export mirrors diviner studies into MLflow: each study becomes an
+// experiment, and each of its runs an MLflow run, with the run's
+// parameter values as parameters, its metric history as metrics
+// (stepped as by diviner.Run.History), and its state, status,
+// labels, and source commit (see diviner.Source) as tags. Artifacts that are stored externally are recorded
+// as tags containing their URLs; inline artifacts are uploaded when
+// the tracking server proxies artifact storage. Export is
+// incremental: it may be invoked repeatedly, in which case only the
+// metrics reported since the previous export are logged, and runs
+// that completed before the previous export are skipped.
+//
+// Import performs the reverse: it imports the finished runs of an
+// MLflow experiment as successful runs of a diviner study, whose
+// parameters determine how the MLflow runs' (string) parameters are
+// parsed. Studies that list the study in Study.Transfer are then
+// warm-started with the imported trials.
+//
+// [1] https://mlflow.org
+package mlflow
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+// Tags with which exported runs and experiments are annotated.
+const (
+	// TagStudy names the diviner study of an exported experiment or
+	// run.
+	TagStudy = "diviner.study"
+	// TagRun is the diviner ID (study:seq) of an exported run.
+	TagRun = "diviner.run"
+	// TagState is the diviner state of an exported run.
+	TagState = "diviner.state"
+	// TagStatus is the diviner status message of an exported run.
+	TagStatus = "diviner.status"
+	// TagReports is the number of metrics reports of an exported run
+	// that have been logged to MLflow.
+	TagReports = "diviner.reports"
+	// TagLabelPrefix prefixes the tags containing an exported run's
+	// labels.
+	TagLabelPrefix = "diviner.label."
+	// TagArtifactPrefix prefixes the tags containing the URLs of an
+	// exported run's artifacts.
+	TagArtifactPrefix = "diviner.artifact."
+)
+
+// LabelRun is the label of imported diviner runs that contains the
+// ID of the MLflow run from which they were imported.
+const LabelRun = "mlflow.run"
+
+// ArtifactDir is the directory of an MLflow run's artifacts into which
+// the inline artifacts of its diviner run are uploaded.
+const ArtifactDir = "diviner"
+
+// Export exports the runs of the named studies that have been updated
+// since the provided time to the tracking server of the provided
+// client. Each study is exported to the experiment named by the
+// study's name, prefixed by the provided prefix; experiments are
+// created as needed. Export returns the number of runs exported.
+// Studies that do not exist are skipped.
+func Export(ctx context.Context, db diviner.Database, client *Client, prefix string, studies []string, since time.Time) (int, error) {
+	var n int
+	for _, name := range studies {
+		study, err := db.LookupStudy(ctx, name)
+		if err == diviner.ErrNotExist {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		runs, err := db.ListRuns(ctx, name, diviner.Any, since)
+		if err != nil && err != diviner.ErrNotExist {
+			return n, err
+		}
+		if len(runs) == 0 {
+			continue
+		}
+		experimentID, err := experiment(ctx, client, prefix+name, study)
+		if err != nil {
+			return n, err
+		}
+		exported, err := client.SearchRuns(ctx, []string{experimentID}, "")
+		if err != nil {
+			return n, err
+		}
+		previous := make(map[string]Run)
+		for _, run := range exported {
+			if id, ok := run.Tag(TagRun); ok {
+				previous[id] = run
+			}
+		}
+		for _, run := range runs {
+			prev, ok := previous[run.ID()]
+			if ok && prev.Info.Status != Running {
+				// The run was completed by a previous export.
+				continue
+			}
+			if err := exportRun(ctx, client, experimentID, run, prev); err != nil {
+				return n, fmt.Errorf("export run %s: %v", run.ID(), err)
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Experiment returns the ID of the named experiment, creating it if
+// it does not exist.
+func experiment(ctx context.Context, client *Client, name string, study diviner.Study) (string, error) {
+	exp, err := client.ExperimentByName(ctx, name)
+	if err == nil {
+		return exp.ID, nil
+	} else if err != diviner.ErrNotExist {
+		return "", err
+	}
+	tags := []Tag{{TagStudy, study.Name}}
+	if study.Description != "" {
+		tags = append(tags, Tag{"mlflow.note.content", study.Description})
+	}
+	return client.CreateExperiment(ctx, name, tags)
+}
+
+// ExportRun exports the provided run to the provided experiment. The
+// MLflow run prev is the run to which the run was previously
+// exported; it is zero if the run has not been exported.
+func exportRun(ctx context.Context, client *Client, experimentID string, run diviner.Run, prev Run) error {
+	var (
+		info    RunInfo
+		reports int
+		params  []Param
+		err     error
+	)
+	if prev.Info.RunID != "" {
+		info = prev.Info
+		if v, ok := prev.Tag(TagReports); ok {
+			reports, _ = strconv.Atoi(v)
+		}
+	} else {
+		info, err = client.CreateRun(ctx, experimentID, run.ID(), run.Created, []Tag{
+			{TagStudy, run.Study},
+			{TagRun, run.ID()},
+		})
+		if err != nil {
+			return err
+		}
+		// MLflow parameters cannot be changed, so they are logged only
+		// when the run is created.
+		for _, v := range run.Values.Sorted() {
+			params = append(params, Param{v.Name, v.Value.String()})
+		}
+	}
+	var (
+		history = run.History()
+		metrics []Metric
+		start   = run.Created
+		end     = run.Completed
+	)
+	if end.IsZero() {
+		end = run.Updated
+	}
+	if end.Before(start) {
+		end = start
+	}
+	if reports > len(history) {
+		reports = len(history)
+	}
+	// Metrics reports are not timestamped, so they are spread evenly
+	// over the run's lifetime.
+	for i, step := range history[reports:] {
+		i += reports
+		t := start.Add(end.Sub(start) * time.Duration(i+1) / time.Duration(len(history)))
+		for _, m := range step.Metrics.Sorted() {
+			// JSON cannot represent non-finite values.
+			if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+				continue
+			}
+			metrics = append(metrics, Metric{m.Name, m.Value, millis(t), int64(step.Step)})
+		}
+	}
+	tags := []Tag{
+		{TagState, run.State.String()},
+		{TagStatus, run.Status},
+		{TagReports, strconv.Itoa(len(history))},
+	}
+	if run.Replicate > 0 {
+		tags = append(tags, Tag{"diviner.replicate", strconv.Itoa(run.Replicate)})
+	}
+	keys := make([]string, 0, len(run.Labels))
+	for key := range run.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tags = append(tags, Tag{TagLabelPrefix + key, run.Labels[key]})
+	}
+	for _, a := range run.Artifacts {
+		if a.URL != "" {
+			tags = append(tags, Tag{TagArtifactPrefix + a.Name, a.URL})
+		}
+	}
+	if source := run.Source; source != nil && source.Commit != "" {
+		tags = append(tags, Tag{"mlflow.source.git.commit", source.Commit})
+		if source.Repository != "" {
+			tags = append(tags, Tag{"mlflow.source.git.repoURL", source.Repository})
+		}
+	}
+	if err := client.LogBatch(ctx, info.RunID, metrics, params, tags); err != nil {
+		return err
+	}
+	if run.State == diviner.Pending {
+		return nil
+	}
+	if strings.HasPrefix(info.ArtifactURI, "mlflow-artifacts:") {
+		for _, a := range run.Artifacts {
+			if a.URL != "" {
+				continue
+			}
+			if err := client.UploadArtifact(ctx, info, ArtifactDir+"/"+a.Name, a.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return client.UpdateRun(ctx, info.RunID, status(run.State), run.Completed)
+}
+
+// Status returns the MLflow status corresponding to the provided run
+// state.
+func status(state diviner.RunState) string {
+	switch state {
+	case diviner.Success:
+		return Finished
+	case diviner.Failure, diviner.TimedOut:
+		return Failed
+	case diviner.Preempted:
+		return Killed
+	default:
+		return Running
+	}
+}
+
+// Import imports the finished runs of the named MLflow experiment as
+// successful runs of the provided study, which is created if it does
+// not exist. Each run's parameters are parsed according to the kinds
+// of the study's parameters; runs that lack a value for any of the
+// study's (active) parameters, or whose values are invalid, are
+// skipped, as are runs that were exported from diviner, and runs that
+// were imported previously (see LabelRun). The runs' metrics are
+// their latest MLflow metrics. Import returns the number of runs
+// imported and the number of runs skipped because of their values.
+func Import(ctx context.Context, client *Client, db diviner.Database, experiment string, study diviner.Study) (imported, skipped int, err error) {
+	exp, err := client.ExperimentByName(ctx, experiment)
+	if err != nil {
+		return 0, 0, fmt.Errorf("experiment %s: %v", experiment, err)
+	}
+	runs, err := client.SearchRuns(ctx, []string{exp.ID}, "attributes.status = 'FINISHED'")
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = db.CreateStudyIfNotExist(ctx, study); err != nil {
+		return 0, 0, err
+	}
+	existing, err := db.ListRuns(ctx, study.Name, diviner.Any, time.Time{})
+	if err != nil && err != diviner.ErrNotExist {
+		return 0, 0, err
+	}
+	done := make(map[string]bool)
+	for _, run := range existing {
+		if id, ok := run.Labels[LabelRun]; ok {
+			done[id] = true
+		}
+	}
+	for _, mrun := range runs {
+		if _, ok := mrun.Tag(TagRun); ok || mrun.Info.Status != Finished || done[mrun.Info.RunID] {
+			continue
+		}
+		values, err := parseValues(study.Params, mrun.Data.Params)
+		if err != nil {
+			skipped++
+			continue
+		}
+		metrics := make(diviner.Metrics)
+		for _, m := range mrun.Data.Metrics {
+			metrics[m.Key] = m.Value
+		}
+		run, err := db.InsertRun(ctx, diviner.Run{
+			Study:  study.Name,
+			Values: values,
+			Labels: diviner.Labels{LabelRun: mrun.Info.RunID},
+		})
+		if err != nil {
+			return imported, skipped, err
+		}
+		if len(metrics) > 0 {
+			if err := db.AppendRunMetrics(ctx, study.Name, run.Seq, metrics); err != nil {
+				return imported, skipped, err
+			}
+		}
+		var runtime time.Duration
+		if mrun.Info.EndTime > mrun.Info.StartTime {
+			runtime = time.Duration(mrun.Info.EndTime-mrun.Info.StartTime) * time.Millisecond
+		}
+		msg := fmt.Sprintf("imported from MLflow run %s", mrun.Info.RunID)
+		if err := db.UpdateRun(ctx, study.Name, run.Seq, diviner.Success, msg, runtime, 0); err != nil {
+			return imported, skipped, err
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+// ParseValues parses the provided MLflow parameters as values of the
+// provided diviner parameters.
+func parseValues(params diviner.Params, mparams []Param) (diviner.Values, error) {
+	strs := make(map[string]string)
+	for _, p := range mparams {
+		strs[p.Key] = p.Value
+	}
+	values := make(diviner.Values)
+	for _, param := range params.Ordered() {
+		if !diviner.IsActive(param.Param, values) {
+			continue
+		}
+		s, ok := strs[param.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter %s", param.Name)
+		}
+		v, err := parseValue(param.Kind(), s)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %v", param.Name, err)
+		}
+		values[param.Name] = v
+	}
+	return params.Coerce(values, false)
+}
+
+func parseValue(kind diviner.Kind, s string) (diviner.Value, error) {
+	switch kind {
+	case diviner.Integer:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			// Integral values may be logged as floats, e.g., "3.0".
+			f, ferr := strconv.ParseFloat(s, 64)
+			if ferr != nil || f != math.Trunc(f) {
+				return nil, err
+			}
+			v = int64(f)
+		}
+		return diviner.Int(v), nil
+	case diviner.Real:
+		v, err := strconv.ParseFloat(s, 64)
+		return diviner.Float(v), err
+	case diviner.Str:
+		return diviner.String(s), nil
+	case diviner.Boolean:
+		v, err := strconv.ParseBool(s)
+		return diviner.Bool(v), err
+	case diviner.Interval:
+		return diviner.ParseDuration(s)
+	case diviner.Timestamp:
+		return diviner.ParseTime(s)
+	default:
+		return nil, fmt.Errorf("cannot parse values of kind %s", kind)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mlflow_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+	"github.com/grailbio/diviner/mlflow"
+)
+
+// Server is a fake MLflow tracking server that implements the subset
+// of the REST API used by the package.
+type server struct {
+	mu          sync.Mutex
+	experiments []mlflow.Experiment
+	runs        []*mlflow.Run
+	history     map[string][]mlflow.Metric
+	artifacts   map[string]string
+}
+
+func newServer() *server {
+	return &server{
+		history:   make(map[string][]mlflow.Metric),
+		artifacts: make(map[string]string),
+	}
+}
+
+func (s *server) run(id string) *mlflow.Run {
+	for _, run := range s.runs {
+		if run.Info.RunID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.HasPrefix(r.URL.Path, "/api/2.0/mlflow-artifacts/artifacts/") {
+		b, _ := ioutil.ReadAll(r.Body)
+		s.artifacts[strings.TrimPrefix(r.URL.Path, "/api/2.0/mlflow-artifacts/artifacts/")] = string(b)
+		return
+	}
+	var req struct {
+		mlflow.Run
+		mlflow.RunInfo
+		mlflow.RunData
+		Name          string   `json:"name"`
+		ExperimentIDs []string `json:"experiment_ids"`
+	}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&req)
+	}
+	var reply interface{} = struct{}{}
+	switch strings.TrimPrefix(r.URL.Path, "/api/2.0/mlflow/") {
+	case "experiments/get-by-name":
+		for _, exp := range s.experiments {
+			if exp.Name == r.URL.Query().Get("experiment_name") {
+				reply = map[string]interface{}{"experiment": exp}
+			}
+		}
+		if _, ok := reply.(struct{}); ok {
+			w.WriteHeader(http.StatusNotFound)
+			reply = mlflow.Error{Code: "RESOURCE_DOES_NOT_EXIST", Message: "no such experiment"}
+		}
+	case "experiments/create":
+		exp := mlflow.Experiment{ID: fmt.Sprint(len(s.experiments)), Name: req.Name, Tags: req.Tags}
+		s.experiments = append(s.experiments, exp)
+		reply = map[string]string{"experiment_id": exp.ID}
+	case "runs/create":
+		id := fmt.Sprintf("run%d", len(s.runs))
+		run := &mlflow.Run{
+			Info: mlflow.RunInfo{
+				RunID:        id,
+				RunName:      req.RunInfo.RunName,
+				ExperimentID: req.RunInfo.ExperimentID,
+				Status:       mlflow.Running,
+				StartTime:    req.RunInfo.StartTime,
+				ArtifactURI:  fmt.Sprintf("mlflow-artifacts:/%s/%s/artifacts", req.RunInfo.ExperimentID, id),
+			},
+			Data: mlflow.RunData{Tags: req.Tags},
+		}
+		s.runs = append(s.runs, run)
+		reply = map[string]interface{}{"run": run}
+	case "runs/update":
+		run := s.run(req.RunInfo.RunID)
+		run.Info.Status = req.Status
+		run.Info.EndTime = req.EndTime
+	case "runs/log-batch":
+		run := s.run(req.RunInfo.RunID)
+		run.Data.Params = append(run.Data.Params, req.Params...)
+		for _, tag := range req.Tags {
+			var found bool
+			for i := range run.Data.Tags {
+				if run.Data.Tags[i].Key == tag.Key {
+					run.Data.Tags[i], found = tag, true
+				}
+			}
+			if !found {
+				run.Data.Tags = append(run.Data.Tags, tag)
+			}
+		}
+		for _, m := range req.Metrics {
+			s.history[run.Info.RunID] = append(s.history[run.Info.RunID], m)
+			var found bool
+			for i := range run.Data.Metrics {
+				if run.Data.Metrics[i].Key == m.Key {
+					run.Data.Metrics[i], found = m, true
+				}
+			}
+			if !found {
+				run.Data.Metrics = append(run.Data.Metrics, m)
+			}
+		}
+	case "runs/search":
+		var runs []*mlflow.Run
+		for _, run := range s.runs {
+			for _, id := range req.ExperimentIDs {
+				if run.Info.ExperimentID == id {
+					runs = append(runs, run)
+				}
+			}
+		}
+		reply = map[string]interface{}{"runs": runs}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+func TestExport(t *testing.T) {
+	var (
+		ctx = context.Background()
+		db  = memdb.New()
+		srv = newServer()
+	)
+	httpsrv := httptest.NewServer(srv)
+	defer httpsrv.Close()
+	client := &mlflow.Client{URL: httpsrv.URL}
+
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test", Description: "a test study"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"lr": diviner.Float(0.1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"step": 1, "acc": 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := mlflow.Export(ctx, db, client, "diviner/", []string{"test", "nonexistent"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(srv.experiments), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := srv.experiments[0].Name, "diviner/test"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Export again, after the run completes.
+	if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"step": 2, "acc": 0.75}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diviner.AddArtifact(ctx, db, "test", run.Seq, diviner.Artifact{Name: "model", URL: "s3://bucket/model.pt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diviner.AddArtifact(ctx, db, "test", run.Seq, diviner.Artifact{Name: "confusion", Data: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Success, "done", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mlflow.Export(ctx, db, client, "diviner/", []string{"test"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(srv.runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	mrun := srv.runs[0]
+	if got, want := mrun.Info.Status, mlflow.Finished; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := mrun.Data.Params, []mlflow.Param{{"lr", "0.1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var (
+		steps  []int64
+		values []float64
+	)
+	for _, m := range srv.history[mrun.Info.RunID] {
+		steps = append(steps, m.Step)
+		values = append(values, m.Value)
+	}
+	if got, want := steps, []int64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := values, []float64{0.5, 0.75}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for key, want := range map[string]string{
+		mlflow.TagRun:                      "test:1",
+		mlflow.TagState:                    "success",
+		mlflow.TagReports:                  "2",
+		mlflow.TagArtifactPrefix + "model": "s3://bucket/model.pt",
+	} {
+		if got, _ := mrun.Tag(key); got != want {
+			t.Errorf("tag %s: got %v, want %v", key, got, want)
+		}
+	}
+	if got, want := srv.artifacts["0/run0/artifacts/diviner/confusion"], "{}"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Completed runs are not exported again.
+	n, err = mlflow.Export(ctx, db, client, "diviner/", []string{"test"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	var (
+		ctx = context.Background()
+		db  = memdb.New()
+		srv = newServer()
+	)
+	httpsrv := httptest.NewServer(srv)
+	defer httpsrv.Close()
+	client := &mlflow.Client{URL: httpsrv.URL}
+	expID, err := client.CreateExperiment(ctx, "legacy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, params := range [][]mlflow.Param{
+		{{"lr", "0.01"}, {"layers", "2"}, {"optimizer", "adam"}},
+		{{"lr", "0.1"}, {"layers", "3.0"}, {"optimizer", "sgd"}},
+		// Layers is out of range.
+		{{"lr", "0.1"}, {"layers", "10"}, {"optimizer", "sgd"}},
+		// Optimizer is missing.
+		{{"lr", "0.1"}, {"layers", "1"}},
+	} {
+		info, err := client.CreateRun(ctx, expID, "", time.Now(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics := []mlflow.Metric{{Key: "acc", Value: 0.5 + float64(i)/10}}
+		if err := client.LogBatch(ctx, info.RunID, metrics, params, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.UpdateRun(ctx, info.RunID, mlflow.Finished, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unfinished runs are not imported.
+	if _, err := client.CreateRun(ctx, expID, "", time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	study := diviner.Study{
+		Name: "imported",
+		Params: diviner.Params{
+			"lr":        diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+			"layers":    diviner.NewRange(diviner.Int(1), diviner.Int(5)),
+			"optimizer": diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd")),
+		},
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+	}
+	imported, skipped, err := mlflow.Import(ctx, client, db, "legacy", study)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imported, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := skipped, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	trials, err := diviner.Trials(ctx, db, study, diviner.Success)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trials.Len(), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	v, ok := trials.Get(diviner.Values{"lr": diviner.Float(0.1), "layers": diviner.Int(3), "optimizer": diviner.String("sgd")})
+	if !ok {
+		t.Fatal("missing trial")
+	}
+	if got, want := v.(diviner.Trial).Metrics["acc"], 0.6; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Runs are imported only once.
+	imported, _, err = mlflow.Import(ctx, client, db, "legacy", study)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imported, 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, _, err := mlflow.Import(ctx, client, db, "nonexistent", study); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package notify implements diviner notifiers (see
+// diviner.Notifier) that deliver study and run events to external
+// services: Slack incoming webhooks and generic HTTP webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&Webhook{})
+	gob.Register(&Slack{})
+}
+
+// DefaultTimeout is the default timeout of a notification request.
+const DefaultTimeout = 10 * time.Second
+
+// A Webhook notifier posts a JSON description of each event (see
+// Payload) to a URL.
+type Webhook struct {
+	// URL is the URL to which events are posted.
+	URL string
+	// Events is the set of event kinds that are posted. If zero, all
+	// events are posted.
+	Events diviner.EventKind
+	// Header contains additional headers (e.g., for authorization)
+	// that are included in each request.
+	Header http.Header
+	// Client is the HTTP client used to post events. If nil, a
+	// client with a timeout of DefaultTimeout is used.
+	Client *http.Client
+}
+
+// Payload is the JSON object posted by Webhook notifiers.
+type Payload struct {
+	Event     string             `json:"event"`
+	Study     string             `json:"study"`
+	Objective string             `json:"objective,omitempty"`
+	Run       string             `json:"run,omitempty"`
+	State     string             `json:"state,omitempty"`
+	Values    map[string]string  `json:"values,omitempty"`
+	Metrics   map[string]float64 `json:"metrics,omitempty"`
+	Message   string             `json:"message"`
+	Time      time.Time          `json:"time"`
+}
+
+// NewPayload returns the payload describing the provided event.
+func NewPayload(event diviner.Event) Payload {
+	p := Payload{
+		Event:   event.Kind.String(),
+		Study:   event.Study,
+		Message: event.Message,
+		Time:    event.Time,
+	}
+	if event.Objective.Metric != "" {
+		p.Objective = event.Objective.String()
+	}
+	if event.Run.Seq == 0 {
+		return p
+	}
+	p.Run = event.Run.ID()
+	p.State = event.Run.State.String()
+	if len(event.Run.Values) > 0 {
+		p.Values = make(map[string]string)
+		for name, v := range event.Run.Values {
+			p.Values[name] = v.String()
+		}
+	}
+	p.Metrics = event.Run.Trial().Metrics
+	return p
+}
+
+// String returns a textual description of the webhook.
+func (w *Webhook) String() string {
+	return fmt.Sprintf("webhook(%s)", w.URL)
+}
+
+// Notify implements diviner.Notifier.
+func (w *Webhook) Notify(ctx context.Context, event diviner.Event) error {
+	if !w.Events.Contains(event.Kind) {
+		return nil
+	}
+	return post(ctx, w.Client, w.URL, w.Header, NewPayload(event))
+}
+
+// A Slack notifier posts a message describing each event to a Slack
+// incoming webhook.
+type Slack struct {
+	// URL is the URL of the incoming webhook.
+	URL string
+	// Events is the set of event kinds that are posted. If zero,
+	// failed runs, new best trials, and finished studies are posted.
+	Events diviner.EventKind
+	// Client is the HTTP client used to post messages. If nil, a
+	// client with a timeout of DefaultTimeout is used.
+	Client *http.Client
+}
+
+// DefaultSlackEvents are the events posted by Slack notifiers that
+// do not specify their events. Run starts and completions are
+// usually too frequent to be posted to a channel.
+const DefaultSlackEvents = diviner.RunFailed | diviner.StudyFinished | diviner.NewBest
+
+// String returns a textual description of the notifier.
+func (s *Slack) String() string {
+	return fmt.Sprintf("slack(%s)", s.URL)
+}
+
+// Notify implements diviner.Notifier.
+func (s *Slack) Notify(ctx context.Context, event diviner.Event) error {
+	events := s.Events
+	if events == 0 {
+		events = DefaultSlackEvents
+	}
+	if !events.Contains(event.Kind) {
+		return nil
+	}
+	msg := struct {
+		Text string `json:"text"`
+	}{SlackText(event)}
+	return post(ctx, s.Client, s.URL, nil, msg)
+}
+
+var slackIcons = map[diviner.EventKind]string{
+	diviner.RunStarted:    ":arrow_forward:",
+	diviner.RunFailed:     ":x:",
+	diviner.RunCompleted:  ":white_check_mark:",
+	diviner.StudyFinished: ":checkered_flag:",
+	diviner.NewBest:       ":trophy:",
+}
+
+// SlackText returns the text of the Slack message that describes
+// the provided event.
+func SlackText(event diviner.Event) string {
+	var b strings.Builder
+	if icon := slackIcons[event.Kind]; icon != "" {
+		b.WriteString(icon)
+		b.WriteString(" ")
+	}
+	fmt.Fprintf(&b, "*%s*", event)
+	if event.Run.Seq == 0 {
+		return b.String()
+	}
+	if values := event.Run.Values.Sorted(); len(values) > 0 {
+		elems := make([]string, len(values))
+		for i, v := range values {
+			elems[i] = fmt.Sprintf("%s=%s", v.Name, v.Value)
+		}
+		fmt.Fprintf(&b, "\nvalues: `%s`", strings.Join(elems, ","))
+	}
+	if metrics := event.Run.Trial().Metrics; len(metrics) > 0 {
+		names := make([]string, 0, len(metrics))
+		for name := range metrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		elems := make([]string, len(names))
+		for i, name := range names {
+			elems[i] = fmt.Sprintf("%s=%g", name, metrics[name])
+		}
+		fmt.Fprintf(&b, "\nmetrics: `%s`", strings.Join(elems, ","))
+	}
+	return b.String()
+}
+
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Post posts the JSON encoding of v to the provided URL.
+func post(ctx context.Context, client *http.Client, url string, header http.Header, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("post %s: %s: %s", url, resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/notify"
+)
+
+type recorder struct {
+	bodies  []map[string]interface{}
+	headers []http.Header
+	status  int
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header)
+	if r.status != 0 {
+		http.Error(w, "nope", r.status)
+	}
+}
+
+func testEvent(kind diviner.EventKind) diviner.Event {
+	return diviner.Event{
+		Kind:      kind,
+		Study:     "test",
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+		Run: diviner.Run{
+			Study:   "test",
+			Seq:     3,
+			State:   diviner.Success,
+			Values:  diviner.Values{"lr": diviner.Float(0.1)},
+			Metrics: []diviner.Metrics{{"acc": 0.9}},
+		},
+		Time:    time.Now(),
+		Message: "new best acc=0.9",
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var rec recorder
+	srv := httptest.NewServer(&rec)
+	defer srv.Close()
+	hook := &notify.Webhook{
+		URL:    srv.URL,
+		Events: diviner.NewBest | diviner.RunFailed,
+		Header: http.Header{"Authorization": {"Bearer secret"}},
+	}
+	ctx := context.Background()
+	for _, kind := range []diviner.EventKind{diviner.RunStarted, diviner.NewBest} {
+		if err := hook.Notify(ctx, testEvent(kind)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := len(rec.bodies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	body := rec.bodies[0]
+	for key, want := range map[string]interface{}{
+		"event":     "new_best",
+		"study":     "test",
+		"objective": "maximize(acc)",
+		"run":       "test:3",
+		"state":     "success",
+	} {
+		if got := body[key]; got != want {
+			t.Errorf("%s: got %v, want %v", key, got, want)
+		}
+	}
+	if got, want := body["metrics"].(map[string]interface{})["acc"], 0.9; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := rec.headers[0].Get("Authorization"), "Bearer secret"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	rec.status = http.StatusInternalServerError
+	err := hook.Notify(ctx, testEvent(diviner.RunFailed))
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("bad error %v", err)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	var rec recorder
+	srv := httptest.NewServer(&rec)
+	defer srv.Close()
+	slack := &notify.Slack{URL: srv.URL}
+	ctx := context.Background()
+	for _, kind := range []diviner.EventKind{diviner.RunStarted, diviner.RunCompleted, diviner.NewBest} {
+		if err := slack.Notify(ctx, testEvent(kind)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := len(rec.bodies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	text, _ := rec.bodies[0]["text"].(string)
+	for _, want := range []string{":trophy:", "run test:3: new best acc=0.9", "lr=0.1", "acc=0.9"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text %q does not contain %q", text, want)
+		}
+	}
+}
+
+func TestEventKind(t *testing.T) {
+	for _, kind := range []diviner.EventKind{diviner.RunStarted, diviner.RunFailed, diviner.RunCompleted, diviner.StudyFinished, diviner.NewBest} {
+		parsed, err := diviner.ParseEventKind(kind.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := parsed, kind; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, want := (diviner.RunFailed | diviner.NewBest).String(), "run_failed|new_best"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !diviner.EventKind(0).Contains(diviner.NewBest) {
+		t.Error("zero event kind does not contain all kinds")
+	}
+	if (diviner.RunFailed).Contains(diviner.NewBest) {
+		t.Error("unexpected containment")
+	}
+}
@@ -0,0 +1,619 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&CMAES{})
+}
+
+const (
+	defaultCMAESSigma       = 0.3
+	defaultCMAESMaxRestarts = 9
+	// cmaesTries is the number of times CMAES tries to sample a point
+	// that duplicates no previous trial before it considers the
+	// search converged.
+	cmaesTries = 100
+	// cmaesTolX is the step size (in the unit hypercube) below which
+	// the search is considered converged.
+	cmaesTolX = 1e-6
+	// cmaesTolFun is the range of objective values, over recent
+	// generations, below which the search is considered converged.
+	cmaesTolFun = 1e-12
+)
+
+// A CMAESRestart is a strategy by which CMAES restarts its search
+// once it has converged.
+type CMAESRestart int
+
+const (
+	// RestartIPOP restarts the search from a random point, doubling
+	// the population size at each restart (IPOP-CMA-ES [2]). Larger
+	// populations search more globally.
+	RestartIPOP CMAESRestart = iota
+	// RestartRandom restarts the search from a random point with the
+	// initial population size.
+	RestartRandom
+	// RestartNone does not restart the search: once it has converged,
+	// CMAES suggests no further points.
+	RestartNone
+)
+
+// ParseCMAESRestart returns the restart strategy with the provided
+// name: "ipop", "random", or "none".
+func ParseCMAESRestart(name string) (CMAESRestart, error) {
+	switch name {
+	case "ipop":
+		return RestartIPOP, nil
+	case "random":
+		return RestartRandom, nil
+	case "none":
+		return RestartNone, nil
+	}
+	return 0, fmt.Errorf("unknown restart strategy %q", name)
+}
+
+// String returns the name of the restart strategy.
+func (r CMAESRestart) String() string {
+	switch r {
+	case RestartIPOP:
+		return "ipop"
+	case RestartRandom:
+		return "random"
+	case RestartNone:
+		return "none"
+	}
+	return fmt.Sprintf("CMAESRestart(%d)", int(r))
+}
+
+// CMAES is an oracle implementing the covariance matrix adaptation
+// evolution strategy [1], a gradient-free optimizer for continuous
+// search spaces. CMAES samples generations of points from a
+// multivariate normal distribution over the unit hypercube into which
+// parameter values are embedded (as by GP); after each generation,
+// the distribution's mean moves toward the best points of the
+// generation, and its covariance and step size adapt to the objective's
+// landscape. Integer, quantized, and enum parameters are rounded to
+// their nearest values.
+//
+// CMAES supports integer and real (log or quantized) ranges and enums
+// only.
+// Studies dominated by discrete parameters are better served by other
+// oracles.
+//
+// A generation is complete once all of its points have been
+// suggested; the next generation is sampled only after the
+// distribution is updated from the generation's completed trials.
+// Thus CMAES is best used in studies run in rounds of the population
+// size, or of a divisor thereof: trials that have not completed (or
+// that have failed) when the next generation is sampled are
+// disregarded. The distribution is kept in memory; CMAES implements
+// diviner.StatefulOracle so that runners can save it to, and restore
+// it from, the study's database. An oracle that is restarted with
+// previous trials but without a saved state begins its search at the
+// best of them.
+//
+// Once the search converges, i.e., once its step size or the range of
+// the objective over recent generations becomes negligible, or all of
+// the points it samples duplicate previous trials, CMAES restarts
+// according to its restart strategy, at most MaxRestarts times.
+//
+// [1] N. Hansen, "The CMA Evolution Strategy: A Tutorial",
+// https://arxiv.org/abs/1604.00772
+//
+// [2] A. Auger and N. Hansen, "A Restart CMA Evolution Strategy With
+// Increasing Population Size," IEEE Congress on Evolutionary
+// Computation, 2005.
+type CMAES struct {
+	// Seed records the random seed that will be used to initialize
+	// random number generation for the next batch of points. It is
+	// exported so it can be serialized to preserve the oracle's state.
+	Seed int64
+	// PopulationSize is the number of points in each generation (of
+	// the first search, if restarted with RestartIPOP). Defaults to
+	// the larger of 4+⌊3 ln n⌋, where n is the number of parameters,
+	// and the number of points first requested from the oracle, i.e.,
+	// the study's parallelism.
+	PopulationSize int
+	// Sigma is the initial step size, relative to the unit hypercube.
+	// Defaults to 0.3.
+	Sigma float64
+	// Restart is the strategy by which the search is restarted once
+	// it converges. Defaults to RestartIPOP.
+	Restart CMAESRestart
+	// MaxRestarts is the maximum number of restarts. Defaults to 9.
+	MaxRestarts int
+
+	mutex sync.Mutex
+	state *cmaesState
+}
+
+var _ diviner.StatefulOracle = (*CMAES)(nil)
+
+// NewCMAES returns a new CMAES oracle with the given random seed and
+// default parameters.
+func NewCMAES(seed int64) *CMAES {
+	return &CMAES{Seed: seed}
+}
+
+// Next implements diviner.Oracle.
+func (o *CMAES) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	space, err := newCMAESSpace(params)
+	if err != nil {
+		return nil, err
+	}
+	random := rand.New(rand.NewSource(o.Seed))
+	o.Seed++
+	var (
+		observed []cmaesPoint
+		seen     = make([]diviner.Values, 0, len(previous)+howmany)
+	)
+	for _, trial := range previous {
+		seen = append(seen, trial.Values)
+		if trial.Pending || !params.IsValid(trial.Values) {
+			continue
+		}
+		f, ok := trial.Metrics[objective.Metric]
+		if !ok || math.IsNaN(f) {
+			continue
+		}
+		if objective.Direction == diviner.Maximize {
+			f = -f
+		}
+		observed = append(observed, cmaesPoint{trial.Values, space.encode(trial.Values), f})
+	}
+	s := o.state
+	if s == nil || s.dim != space.dim {
+		lambda := o.PopulationSize
+		if lambda <= 0 {
+			lambda = 4 + int(3*math.Log(float64(space.dim)))
+			if howmany > lambda {
+				lambda = howmany
+			}
+		}
+		var mean []float64
+		if len(observed) > 0 {
+			best := observed[0]
+			for _, p := range observed {
+				if p.f < best.f {
+					best = p
+				}
+			}
+			mean = append(mean, best.x...)
+		} else {
+			mean = randomPoint(space.dim, random)
+		}
+		s = newCMAESState(mean, lambda, o.sigma())
+		o.state = s
+	}
+	result := make([]diviner.Values, 0, howmany)
+	for len(result) < howmany && !s.done {
+		if len(s.candidates) == s.lambda {
+			o.tell(s, observed, random)
+			continue
+		}
+		var values diviner.Values
+		for try := 0; try < cmaesTries && values == nil; try++ {
+			v := space.decode(s.sample(random))
+			if !containsValues(seen, v) {
+				values = v
+			}
+		}
+		if values == nil {
+			// The distribution is (probably) concentrated on previous
+			// trials.
+			o.restart(s, random)
+			continue
+		}
+		s.candidates = append(s.candidates, values)
+		seen = append(seen, values)
+		result = append(result, values)
+	}
+	return result, nil
+}
+
+func (o *CMAES) sigma() float64 {
+	if o.Sigma > 0 {
+		return o.Sigma
+	}
+	return defaultCMAESSigma
+}
+
+// Tell updates the search distribution from the observed points of
+// its current generation, and begins the next generation, restarting
+// the search if it has converged.
+func (o *CMAES) tell(s *cmaesState, observed []cmaesPoint, random *rand.Rand) {
+	var points []cmaesPoint
+	for _, values := range s.candidates {
+		for _, p := range observed {
+			if p.values.Equal(values) {
+				points = append(points, p)
+				break
+			}
+		}
+	}
+	s.candidates = s.candidates[:0]
+	if len(points) == 0 {
+		// Nothing is known of the generation; sample another from the
+		// same distribution.
+		return
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].f < points[j].f })
+	s.update(points)
+	if s.converged(points) {
+		o.restart(s, random)
+	}
+}
+
+// Restart restarts the search from a random point, as dictated by
+// the oracle's restart strategy.
+func (o *CMAES) restart(s *cmaesState, random *rand.Rand) {
+	max := o.MaxRestarts
+	if max <= 0 {
+		max = defaultCMAESMaxRestarts
+	}
+	if o.Restart == RestartNone || s.restarts >= max {
+		s.done = true
+		return
+	}
+	lambda := s.lambda
+	if o.Restart == RestartIPOP {
+		lambda *= 2
+	}
+	restarts := s.restarts + 1
+	*s = *newCMAESState(randomPoint(s.dim, random), lambda, o.sigma())
+	s.restarts = restarts
+}
+
+// cmaesSavedState is the encoding of a CMAES oracle's state, as
+// returned by SaveState.
+type cmaesSavedState struct {
+	Seed int64
+	// State is false if the oracle has not yet begun its search, in
+	// which case the remaining fields are zero.
+	State       bool
+	Dim, Lambda int
+	Mean        []float64
+	Sigma       float64
+	Cov, Chol   [][]float64
+	Ps, Pc      []float64
+	Gen         int
+	Best        []float64
+	Restarts    int
+	Candidates  [][]byte
+	Done        bool
+}
+
+// SaveState implements diviner.StatefulOracle, encoding the oracle's
+// search distribution and its random seed.
+func (o *CMAES) SaveState() ([]byte, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	saved := cmaesSavedState{Seed: o.Seed}
+	if s := o.state; s != nil {
+		saved.State = true
+		saved.Dim, saved.Lambda = s.dim, s.lambda
+		saved.Mean, saved.Sigma = s.mean, s.sigma
+		saved.Cov, saved.Chol = s.cov, s.chol
+		saved.Ps, saved.Pc = s.ps, s.pc
+		saved.Gen, saved.Best, saved.Restarts = s.gen, s.best, s.restarts
+		saved.Done = s.done
+		for _, values := range s.candidates {
+			p, err := diviner.MarshalValues(values)
+			if err != nil {
+				return nil, err
+			}
+			saved.Candidates = append(saved.Candidates, p)
+		}
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(saved); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// LoadState implements diviner.StatefulOracle, restoring a state
+// returned by SaveState.
+func (o *CMAES) LoadState(state []byte) error {
+	var saved cmaesSavedState
+	if err := gob.NewDecoder(bytes.NewReader(state)).Decode(&saved); err != nil {
+		return fmt.Errorf("cmaes: decoding state: %v", err)
+	}
+	var s *cmaesState
+	if saved.State {
+		if len(saved.Mean) != saved.Dim || len(saved.Cov) != saved.Dim || len(saved.Ps) != saved.Dim || len(saved.Pc) != saved.Dim {
+			return errors.New("cmaes: inconsistent state")
+		}
+		s = &cmaesState{
+			dim:      saved.Dim,
+			lambda:   saved.Lambda,
+			mean:     saved.Mean,
+			sigma:    saved.Sigma,
+			cov:      saved.Cov,
+			chol:     saved.Chol,
+			ps:       saved.Ps,
+			pc:       saved.Pc,
+			gen:      saved.Gen,
+			best:     saved.Best,
+			restarts: saved.Restarts,
+			done:     saved.Done,
+		}
+		for _, p := range saved.Candidates {
+			values, err := diviner.UnmarshalValues(p)
+			if err != nil {
+				return fmt.Errorf("cmaes: decoding state: %v", err)
+			}
+			s.candidates = append(s.candidates, values)
+		}
+	}
+	o.mutex.Lock()
+	o.Seed, o.state = saved.Seed, s
+	o.mutex.Unlock()
+	return nil
+}
+
+// cmaesSpace embeds range parameter values into the unit hypercube,
+// as does gpSpace.
+type cmaesSpace struct {
+	*gpSpace
+}
+
+func newCMAESSpace(params diviner.Params) (*cmaesSpace, error) {
+	for name, param := range params {
+		switch param.(type) {
+		case *diviner.Range, *diviner.LogRange, *diviner.QuantizedRange, *diviner.Enum:
+		default:
+			return nil, fmt.Errorf("cmaes: parameter %s: unsupported parameter %s", name, param)
+		}
+	}
+	if len(params) == 0 {
+		return nil, errors.New("cmaes: study has no parameters")
+	}
+	space, err := newGPSpace(params)
+	if err != nil {
+		return nil, err
+	}
+	return &cmaesSpace{space}, nil
+}
+
+// Decode returns the parameter values nearest to the point x;
+// coordinates outside of the unit interval are clipped.
+func (s *cmaesSpace) decode(x []float64) diviner.Values {
+	values := make(diviner.Values, len(s.params))
+	for i, p := range s.params {
+		f := math.Max(0, math.Min(1, x[i]))
+		switch param := p.Param.(type) {
+		case *diviner.Range:
+			if param.Kind() == diviner.Integer {
+				lo, hi := float64(param.Start.Int()), float64(param.End.Int()-1)
+				values[p.Name] = diviner.Int(int64(math.Round(lo + f*(hi-lo))))
+			} else {
+				lo, hi := param.Start.Float(), param.End.Float()
+				values[p.Name] = diviner.Float(math.Min(lo+f*(hi-lo), math.Nextafter(hi, lo)))
+			}
+		case *diviner.LogRange:
+			if param.Kind() == diviner.Integer {
+				start, end := param.Start.Int(), param.End.Int()-1
+				lo, hi := math.Log(float64(start)), math.Log(float64(end))
+				v := int64(math.Round(math.Exp(lo + f*(hi-lo))))
+				if v < start {
+					v = start
+				} else if v > end {
+					v = end
+				}
+				values[p.Name] = diviner.Int(v)
+			} else {
+				start, end := param.Start.Float(), param.End.Float()
+				lo, hi := math.Log(start), math.Log(end)
+				v := math.Max(start, math.Min(math.Exp(lo+f*(hi-lo)), math.Nextafter(end, start)))
+				values[p.Name] = diviner.Float(v)
+			}
+		case diviner.Ordinal:
+			values[p.Name] = param.Value(int(math.Round(f * float64(param.Len()-1))))
+		}
+	}
+	return values
+}
+
+// A cmaesPoint is an observed point, with its objective value
+// oriented for minimization.
+type cmaesPoint struct {
+	values diviner.Values
+	x      []float64
+	f      float64
+}
+
+// cmaesState is the state of a CMA-ES search: the mean, step size,
+// and covariance of its sampling distribution, together with its
+// evolution paths.
+type cmaesState struct {
+	dim, lambda int
+	mean        []float64
+	sigma       float64
+	cov         [][]float64
+	// chol is the Cholesky factor of cov, or nil if cov is not
+	// positive definite.
+	chol   [][]float64
+	ps, pc []float64
+	// gen is the number of generations since the search was (re)started.
+	gen int
+	// best is the best objective value of each of these generations.
+	best     []float64
+	restarts int
+	// candidates are the points suggested from the current
+	// generation.
+	candidates []diviner.Values
+	// done is set once the search has converged and is not to be
+	// restarted.
+	done bool
+}
+
+func newCMAESState(mean []float64, lambda int, sigma float64) *cmaesState {
+	n := len(mean)
+	return &cmaesState{
+		dim:    n,
+		lambda: lambda,
+		mean:   mean,
+		sigma:  sigma,
+		cov:    identity(n),
+		chol:   identity(n),
+		ps:     make([]float64, n),
+		pc:     make([]float64, n),
+	}
+}
+
+// sample draws a point from the search distribution.
+func (s *cmaesState) sample(random *rand.Rand) []float64 {
+	z := make([]float64, s.dim)
+	for i := range z {
+		z[i] = random.NormFloat64()
+	}
+	x := make([]float64, s.dim)
+	for i := range x {
+		var d float64
+		for j := 0; j <= i; j++ {
+			d += s.chol[i][j] * z[j]
+		}
+		x[i] = s.mean[i] + s.sigma*d
+	}
+	return x
+}
+
+// update updates the search distribution from the provided points,
+// sorted by their objective values. The points' ranks determine the
+// weights of the best half of the points.
+func (s *cmaesState) update(points []cmaesPoint) {
+	var (
+		n  = float64(s.dim)
+		mu = s.lambda / 2
+	)
+	if mu > len(points) {
+		mu = len(points)
+	}
+	if mu < 1 {
+		mu = 1
+	}
+	var (
+		w          = make([]float64, mu)
+		sum, sumsq float64
+	)
+	for i := range w {
+		w[i] = math.Log(float64(mu)+0.5) - math.Log(float64(i+1))
+		sum += w[i]
+	}
+	for i := range w {
+		w[i] /= sum
+		sumsq += w[i] * w[i]
+	}
+	var (
+		mueff = 1 / sumsq
+		cs    = (mueff + 2) / (n + mueff + 5)
+		ds    = 1 + 2*math.Max(0, math.Sqrt((mueff-1)/(n+1))-1) + cs
+		cc    = (4 + mueff/n) / (n + 4 + 2*mueff/n)
+		c1    = 2 / ((n+1.3)*(n+1.3) + mueff)
+		cmu   = math.Min(1-c1, 2*(mueff-2+1/mueff)/((n+2)*(n+2)+mueff))
+		chiN  = math.Sqrt(n) * (1 - 1/(4*n) + 1/(21*n*n))
+	)
+	ys := make([][]float64, mu)
+	yw := make([]float64, s.dim)
+	for i := range ys {
+		ys[i] = make([]float64, s.dim)
+		for j := range ys[i] {
+			ys[i][j] = (points[i].x[j] - s.mean[j]) / s.sigma
+			yw[j] += w[i] * ys[i][j]
+		}
+	}
+	for j := range s.mean {
+		s.mean[j] += s.sigma * yw[j]
+	}
+	// The Cholesky factor's inverse stands in for the inverse square
+	// root of the covariance.
+	z := forwardSubst(s.chol, yw)
+	var psnorm float64
+	for j := range s.ps {
+		s.ps[j] = (1-cs)*s.ps[j] + math.Sqrt(cs*(2-cs)*mueff)*z[j]
+		psnorm += s.ps[j] * s.ps[j]
+	}
+	psnorm = math.Sqrt(psnorm)
+	s.gen++
+	var hs float64
+	if psnorm/math.Sqrt(1-math.Pow(1-cs, 2*float64(s.gen))) < (1.4+2/(n+1))*chiN {
+		hs = 1
+	}
+	for j := range s.pc {
+		s.pc[j] = (1-cc)*s.pc[j] + hs*math.Sqrt(cc*(2-cc)*mueff)*yw[j]
+	}
+	for j := range s.cov {
+		for k := range s.cov[j] {
+			c := (1-c1-cmu)*s.cov[j][k] + c1*(s.pc[j]*s.pc[k]+(1-hs)*cc*(2-cc)*s.cov[j][k])
+			for i := range ys {
+				c += cmu * w[i] * ys[i][j] * ys[i][k]
+			}
+			s.cov[j][k] = c
+		}
+	}
+	s.sigma *= math.Exp((cs / ds) * (psnorm/chiN - 1))
+	var err error
+	if s.chol, err = cholesky(s.cov); err != nil {
+		s.chol = nil
+	}
+	s.best = append(s.best, points[0].f)
+}
+
+// converged tells whether the search has converged, given the points
+// of its latest generation.
+func (s *cmaesState) converged(points []cmaesPoint) bool {
+	if s.chol == nil || math.IsNaN(s.sigma) || math.IsInf(s.sigma, 0) {
+		return true
+	}
+	var maxvar float64
+	for j := range s.cov {
+		maxvar = math.Max(maxvar, s.cov[j][j])
+	}
+	if s.sigma*math.Sqrt(maxvar) < cmaesTolX {
+		return true
+	}
+	h := 10 + int(math.Ceil(30*float64(s.dim)/float64(s.lambda)))
+	if len(s.best) < h {
+		return false
+	}
+	lo, hi := points[0].f, points[len(points)-1].f
+	for _, f := range s.best[len(s.best)-h:] {
+		lo, hi = math.Min(lo, f), math.Max(hi, f)
+	}
+	return hi-lo < cmaesTolFun
+}
+
+func identity(n int) [][]float64 {
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n)
+		m[i][i] = 1
+	}
+	return m
+}
+
+func randomPoint(n int, random *rand.Rand) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = random.Float64()
+	}
+	return x
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/oracle"
+)
+
+func TestCMAES(t *testing.T) {
+	params := diviner.Params{
+		"x":  diviner.NewRange(diviner.Float(-2), diviner.Float(2)),
+		"y":  diviner.NewRange(diviner.Float(-2), diviner.Float(2)),
+		"lr": diviner.NewLogRange(diviner.Float(1e-6), diviner.Float(1)),
+		"n":  diviner.NewRange(diviner.Int(0), diviner.Int(100)),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	loss := func(v diviner.Values) float64 {
+		x, y := v["x"].Float()-0.5, v["y"].Float()+1
+		lr, n := math.Log10(v["lr"].Float())+3, float64(v["n"].Int()-70)/10
+		// A rotated, ill-conditioned quadratic.
+		return (x+y)*(x+y) + 10*(x-y)*(x-y) + lr*lr + n*n
+	}
+	cmaes := oracle.NewCMAES(1)
+	var (
+		trials []diviner.Trial
+		best   = math.Inf(1)
+	)
+	for round := 0; round < 60; round++ {
+		values, err := cmaes.Next(trials, params, objective, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(values), 4; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for _, v := range values {
+			if !params.IsValid(v) {
+				t.Fatalf("invalid values %v", v)
+			}
+			for _, trial := range trials {
+				if trial.Values.Equal(v) {
+					t.Fatalf("duplicate trial %v", v)
+				}
+			}
+			l := loss(v)
+			best = math.Min(best, l)
+			trials = append(trials, diviner.Trial{Values: v, Metrics: diviner.Metrics{"loss": l}})
+		}
+	}
+	if best > 0.1 {
+		t.Errorf("best loss %v is too large", best)
+	}
+}
+
+func TestCMAESRestart(t *testing.T) {
+	params := diviner.Params{"n": diviner.NewRange(diviner.Int(0), diviner.Int(10))}
+	objective := diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+	for _, restart := range []oracle.CMAESRestart{oracle.RestartNone, oracle.RestartIPOP} {
+		cmaes := &oracle.CMAES{Restart: restart, PopulationSize: 4}
+		var trials []diviner.Trial
+		for round := 0; round < 100; round++ {
+			values, err := cmaes.Next(trials, params, objective, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(values) == 0 {
+				break
+			}
+			for _, v := range values {
+				n := float64(v["n"].Int())
+				trials = append(trials, diviner.Trial{Values: v, Metrics: diviner.Metrics{"acc": -(n - 3) * (n - 3)}})
+			}
+		}
+		// With restarts, the oracle eventually exhausts the (small)
+		// space; without them, it stops once it converges.
+		if restart == oracle.RestartIPOP {
+			if got, want := len(trials), 10; got != want {
+				t.Errorf("%s: got %v, want %v", restart, got, want)
+			}
+		} else if len(trials) == 0 || len(trials) > 10 {
+			t.Errorf("%s: got %v trials", restart, len(trials))
+		}
+		var found bool
+		for _, trial := range trials {
+			if trial.Values["n"].Int() == 3 {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: optimum not found", restart)
+		}
+	}
+}
+
+func TestCMAESUnsupported(t *testing.T) {
+	params := diviner.Params{"opt": diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd"))}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	if _, err := oracle.NewCMAES(0).Next(nil, params, objective, 1); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCMAESState(t *testing.T) {
+	params := diviner.Params{
+		"x": diviner.NewRange(diviner.Float(-2), diviner.Float(2)),
+		"y": diviner.NewRange(diviner.Float(-2), diviner.Float(2)),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	var trials []diviner.Trial
+	next := func(o *oracle.CMAES) []diviner.Values {
+		t.Helper()
+		values, err := o.Next(trials, params, objective, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+	observe := func(values []diviner.Values) {
+		for _, v := range values {
+			x, y := v["x"].Float(), v["y"].Float()
+			trials = append(trials, diviner.Trial{Values: v, Metrics: diviner.Metrics{"loss": x*x + y*y}})
+		}
+	}
+	cmaes := &oracle.CMAES{Seed: 1, PopulationSize: 6}
+	for round := 0; round < 5; round++ {
+		observe(next(cmaes))
+	}
+	state, err := cmaes.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An oracle restored from the saved state continues the search
+	// exactly as the original.
+	restored := &oracle.CMAES{PopulationSize: 6}
+	if err := restored.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	for round := 0; round < 5; round++ {
+		want := next(cmaes)
+		got := next(restored)
+		if len(got) != len(want) {
+			t.Fatalf("round %d: got %v, want %v", round, got, want)
+		}
+		for i := range got {
+			if !got[i].Equal(want[i]) {
+				t.Errorf("round %d: got %v, want %v", round, got[i], want[i])
+			}
+		}
+		observe(want)
+	}
+	if err := restored.LoadState([]byte("bogus")); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&Genetic{})
+}
+
+const (
+	defaultGeneticPopulationSize = 20
+	defaultGeneticTournamentSize = 2
+	defaultGeneticCrossoverRate  = 0.9
+	defaultGeneticMutationScale  = 0.1
+)
+
+// Genetic is a single-objective oracle implementing a steady-state
+// genetic algorithm. Since it makes no assumptions about the
+// smoothness of the objective, it is a robust choice for rugged,
+// noisy, or otherwise non-smooth objective landscapes.
+//
+// Genetic maintains a population of the PopulationSize best completed
+// trials. Each new point is bred from parents chosen from the
+// population by tournament selection: the best of TournamentSize
+// members drawn at random. With probability CrossoverRate, a point is
+// bred from two parents by uniform crossover, inheriting each
+// parameter value from either parent; otherwise it is a copy of a
+// single parent. Each of the point's parameter values is then mutated
+// with probability MutationRate: values of (integer, real, log, or
+// quantized) ranges are perturbed by Gaussian noise whose standard
+// deviation is MutationScale times the width of the range, as are
+// the positions of enum values; values of other parameters are
+// resampled.
+//
+// The first generation of PopulationSize points is sampled at
+// random. As with NSGA2, new points are bred from the current
+// population whenever they are requested, and Genetic never repeats
+// previous trials.
+type Genetic struct {
+	// Seed records the random seed that will be used to initialize
+	// random number generation for the next batch of points. It is
+	// exported so it can be serialized to preserve the oracle's state.
+	Seed int64
+	// PopulationSize is the number of trials in the population from
+	// which new points are bred. Defaults to 20.
+	PopulationSize int
+	// TournamentSize is the number of members of the population that
+	// compete to be selected as a parent. Larger tournaments select
+	// more strongly for the best trials. Defaults to 2.
+	TournamentSize int
+	// CrossoverRate is the probability with which a new point is bred
+	// from two parents, rather than copied from one. Defaults to 0.9.
+	CrossoverRate float64
+	// MutationRate is the probability with which each parameter
+	// value of a new point is mutated. Defaults to 1/n, where n is
+	// the number of parameters.
+	MutationRate float64
+	// MutationScale is the standard deviation of the Gaussian
+	// mutations of range values, relative to the width of the range.
+	// Defaults to 0.1.
+	MutationScale float64
+
+	mutex sync.Mutex
+}
+
+// NewGenetic returns a new Genetic oracle with the given random seed
+// and default parameters.
+func NewGenetic(seed int64) *Genetic {
+	return &Genetic{Seed: seed}
+}
+
+// String returns a textual description of the oracle.
+func (o *Genetic) String() string {
+	return fmt.Sprintf("genetic(seed=%d, population_size=%d, tournament_size=%d, crossover_rate=%v, mutation_rate=%v, mutation_scale=%v)",
+		o.Seed, o.PopulationSize, o.TournamentSize, o.CrossoverRate, o.MutationRate, o.MutationScale)
+}
+
+// Next implements diviner.Oracle.
+func (o *Genetic) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	random := rand.New(rand.NewSource(o.Seed))
+	o.Seed++
+	size := o.PopulationSize
+	if size <= 0 {
+		size = defaultGeneticPopulationSize
+	}
+	var (
+		complete []diviner.Trial
+		seen     = make([]diviner.Values, 0, len(previous)+howmany)
+	)
+	for _, trial := range previous {
+		seen = append(seen, trial.Values)
+		if !trial.Pending && params.IsValid(trial.Values) && hasObjectives(trial, []diviner.Objective{objective}) {
+			complete = append(complete, trial)
+		}
+	}
+	sortTrials(complete, objective)
+	if len(complete) > size {
+		complete = complete[:size]
+	}
+	var (
+		// Since the population is sorted from best to worst, members
+		// are compared by their indices.
+		population = complete
+		ordered    = params.Ordered()
+		result     = make([]diviner.Values, 0, howmany)
+	)
+	for len(result) < howmany {
+		var values diviner.Values
+		for try := 0; try < nsga2Tries && values == nil; try++ {
+			var v diviner.Values
+			if len(seen) < size || len(population) < 2 {
+				v = params.Sample(random)
+			} else {
+				p := population[o.tournament(len(population), random)]
+				q := p
+				if random.Float64() < o.crossoverRate() {
+					q = population[o.tournament(len(population), random)]
+				}
+				v = o.breed(p.Values, q.Values, ordered, random)
+			}
+			if !containsValues(seen, v) {
+				values = v
+			}
+		}
+		if values == nil {
+			// The space is (probably) exhausted.
+			break
+		}
+		seen = append(seen, values)
+		result = append(result, values)
+	}
+	return result, nil
+}
+
+func (o *Genetic) crossoverRate() float64 {
+	if o.CrossoverRate <= 0 {
+		return defaultGeneticCrossoverRate
+	}
+	return o.CrossoverRate
+}
+
+// Tournament selects a member of a population of size n, sorted from
+// best to worst, returning its index.
+func (o *Genetic) tournament(n int, random *rand.Rand) int {
+	k := o.TournamentSize
+	if k <= 0 {
+		k = defaultGeneticTournamentSize
+	}
+	best := random.Intn(n)
+	for i := 1; i < k; i++ {
+		if j := random.Intn(n); j < best {
+			best = j
+		}
+	}
+	return best
+}
+
+// Breed returns a child of the parents p and q by uniform crossover,
+// mutating each of its values with probability MutationRate. The
+// parameters must be ordered (see diviner.Params.Ordered);
+// conditional parameters that are inactive for the child are
+// omitted, and those that are active but missing from the chosen
+// parent are sampled.
+func (o *Genetic) breed(p, q diviner.Values, params []diviner.NamedParam, random *rand.Rand) diviner.Values {
+	rate := o.MutationRate
+	if rate <= 0 && len(params) > 0 {
+		rate = 1 / float64(len(params))
+	}
+	scale := o.MutationScale
+	if scale <= 0 {
+		scale = defaultGeneticMutationScale
+	}
+	child := make(diviner.Values)
+	for _, param := range params {
+		if !diviner.IsActive(param.Param, child) {
+			continue
+		}
+		v, ok := p[param.Name]
+		if random.Intn(2) == 1 {
+			v, ok = q[param.Name]
+		}
+		switch {
+		case !ok:
+			v = param.Sample(random)
+		case random.Float64() < rate:
+			v = mutate(param.Param, v, scale, random)
+		}
+		child[param.Name] = v
+	}
+	return child
+}
+
+// Mutate returns a mutation of the value v of the provided parameter.
+// Range values are perturbed by Gaussian noise with standard
+// deviation scale, relative to the width of the range, and clipped
+// to the range; values of other parameters are resampled.
+func mutate(param diviner.Param, v diviner.Value, scale float64, random *rand.Rand) diviner.Value {
+	if c, ok := param.(*diviner.Conditional); ok {
+		param = c.Param
+	}
+	noise := func(f, lo, hi float64) float64 {
+		f += random.NormFloat64() * scale * (hi - lo)
+		return math.Max(lo, math.Min(hi, f))
+	}
+	switch param := param.(type) {
+	case *diviner.Range:
+		switch param.Kind() {
+		case diviner.Integer:
+			lo, hi := float64(param.Start.Int()), float64(param.End.Int()-1)
+			return diviner.Int(int64(math.Round(noise(float64(v.Int()), lo, hi))))
+		case diviner.Real:
+			lo, hi := param.Start.Float(), param.End.Float()
+			return diviner.Float(math.Min(noise(v.Float(), lo, hi), math.Nextafter(hi, lo)))
+		}
+	case *diviner.LogRange:
+		switch param.Kind() {
+		case diviner.Integer:
+			start, end := param.Start.Int(), param.End.Int()-1
+			lo, hi := math.Log(float64(start)), math.Log(float64(end))
+			n := int64(math.Round(math.Exp(noise(math.Log(float64(v.Int())), lo, hi))))
+			if n < start {
+				n = start
+			} else if n > end {
+				n = end
+			}
+			return diviner.Int(n)
+		case diviner.Real:
+			start, end := param.Start.Float(), param.End.Float()
+			lo, hi := math.Log(start), math.Log(end)
+			f := math.Exp(noise(math.Log(v.Float()), lo, hi))
+			return diviner.Float(math.Max(start, math.Min(f, math.Nextafter(end, start))))
+		}
+	case diviner.Ordinal:
+		if n := param.Len(); n > 0 {
+			i := noise(float64(param.Index(v)), 0, float64(n-1))
+			return param.Value(int(math.Round(i)))
+		}
+	}
+	return param.Sample(random)
+}
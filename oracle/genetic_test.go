@@ -0,0 +1,90 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/oracle"
+)
+
+func TestGenetic(t *testing.T) {
+	// The Rastrigin function is highly multimodal; its global minimum
+	// is at x = y = 0, with opt = "on".
+	params := diviner.Params{
+		"x":   diviner.NewRange(diviner.Float(-5.12), diviner.Float(5.12)),
+		"y":   diviner.NewRange(diviner.Float(-5.12), diviner.Float(5.12)),
+		"n":   diviner.NewRange(diviner.Int(1), diviner.Int(100)),
+		"lr":  diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1)),
+		"opt": diviner.NewDiscrete(diviner.String("on"), diviner.String("off")),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "f"}
+	evaluate := func(v diviner.Values) diviner.Metrics {
+		f := 20.0
+		for _, x := range []float64{v["x"].Float(), v["y"].Float()} {
+			f += x*x - 10*math.Cos(2*math.Pi*x)
+		}
+		if v["opt"].Str() == "off" {
+			f += 10
+		}
+		return diviner.Metrics{"f": f}
+	}
+	var (
+		o      = &oracle.Genetic{Seed: 1, TournamentSize: 3, MutationRate: 0.5}
+		trials []diviner.Trial
+		best   = math.Inf(1)
+	)
+	for round := 0; round < 30; round++ {
+		values, err := o.Next(trials, params, objective, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(values), 10; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for _, v := range values {
+			if !params.IsValid(v) {
+				t.Fatalf("invalid values %v", v)
+			}
+			for _, trial := range trials {
+				if trial.Values.Equal(v) {
+					t.Fatalf("duplicate trial %v", v)
+				}
+			}
+			metrics := evaluate(v)
+			best = math.Min(best, metrics["f"])
+			trials = append(trials, diviner.Trial{Values: v, Metrics: metrics})
+		}
+	}
+	if best > 2 {
+		t.Errorf("best objective %v is too large", best)
+	}
+}
+
+func TestGeneticExhausted(t *testing.T) {
+	params := diviner.Params{
+		"x": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1)),
+		"y": diviner.NewQuantizedRange(diviner.Int(0), diviner.Int(4), diviner.Int(2)),
+	}
+	var (
+		o         = oracle.NewGenetic(1)
+		objective = diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+		trials    []diviner.Trial
+	)
+	for round := 0; round < 3; round++ {
+		values, err := o.Next(trials, params, objective, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			trials = append(trials, diviner.Trial{Values: v, Metrics: diviner.Metrics{"acc": float64(v["x"].Int())}})
+		}
+	}
+	if got, want := len(trials), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,515 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&GP{})
+}
+
+const (
+	defaultGPInitial    = 5
+	defaultGPCandidates = 1000
+	defaultGPXi         = 0.01
+)
+
+// gpLengthScales are the kernel length scales (in the unit
+// hypercube) considered when fitting the Gaussian process; the one
+// maximizing the marginal likelihood of the observations is used.
+var gpLengthScales = []float64{0.05, 0.1, 0.2, 0.3, 0.5, 1, 2}
+
+// GP is an oracle that performs Bayesian optimization using a
+// Gaussian process model of the objective. The model uses a Matérn
+// 5/2 kernel over the unit hypercube into which parameter values are
+// embedded: integer and real ranges are scaled to [0, 1], log ranges
+// in log space, and quantized ranges and enums by the index of their
+// values; discrete parameters are one-hot encoded. New points maximize the expected
+// improvement of the objective over a set of random candidates.
+//
+// When more than one point is requested, or when trials are pending,
+// GP uses the "constant liar" strategy: each suggested (or pending)
+// point is assumed to have produced the worst observed objective
+// value, so that subsequent suggestions in the batch are steered away
+// from it. GP never suggests points that duplicate previous trials,
+// so that parallel runners do not conduct the same trial twice.
+//
+// GP supports integer and real (log or quantized) ranges, enums, and
+// discrete parameters of any kind.
+type GP struct {
+	// Seed records the random seed that will be used to initialize
+	// random number generation for the next batch of points. It is
+	// exported so it can be serialized to preserve the oracle's state.
+	Seed int64
+	// NumInitial is the number of (random) trials that are conducted
+	// before the Gaussian process model is used. Defaults to 5.
+	NumInitial int
+	// NumCandidates is the number of random candidate points over
+	// which the expected improvement is maximized. Defaults to 1000.
+	NumCandidates int
+	// Xi is the exploration parameter of the expected improvement
+	// acquisition function, in units of the objective's standard
+	// deviation. Defaults to 0.01.
+	Xi float64
+
+	mutex sync.Mutex
+}
+
+// NewGP returns a new GP oracle with the given random seed and
+// default parameters.
+func NewGP(seed int64) *GP {
+	return &GP{Seed: seed}
+}
+
+// Next implements diviner.Oracle.
+func (g *GP) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	space, err := newGPSpace(params)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		random = rand.New(rand.NewSource(g.Seed))
+		obs    gpObservations
+	)
+	g.Seed++
+	for _, trial := range previous {
+		if !params.IsValid(trial.Values) {
+			continue
+		}
+		x := space.encode(trial.Values)
+		// Infeasible trials are assumed to attain the worst observed
+		// value, steering the search away from them.
+		if metric, ok := trial.Metrics[objective.Metric]; ok && !trial.Pending && !trial.Infeasible && !math.IsNaN(metric) {
+			if objective.Direction == diviner.Minimize {
+				metric = -metric
+			}
+			obs.add(trial.Values, x, metric)
+		} else {
+			obs.lie(trial.Values, x)
+		}
+	}
+	var (
+		numInitial    = g.NumInitial
+		numCandidates = g.NumCandidates
+		xi            = g.Xi
+	)
+	if numInitial <= 0 {
+		numInitial = defaultGPInitial
+	}
+	if numCandidates <= 0 {
+		numCandidates = defaultGPCandidates
+	}
+	if xi == 0 {
+		xi = defaultGPXi
+	}
+	result := make([]diviner.Values, 0, howmany)
+	for len(result) < howmany {
+		var next diviner.Values
+		if obs.numObserved() >= numInitial {
+			next = obs.suggest(space, params, random, numCandidates, xi)
+		}
+		if next == nil {
+			next = obs.sample(params, random, numCandidates)
+		}
+		if next == nil {
+			// The space is exhausted.
+			break
+		}
+		obs.lie(next, space.encode(next))
+		result = append(result, next)
+	}
+	return result, nil
+}
+
+// gpSpace embeds parameter values into the unit hypercube.
+type gpSpace struct {
+	params []diviner.NamedParam
+	dim    int
+}
+
+func newGPSpace(params diviner.Params) (*gpSpace, error) {
+	s := &gpSpace{params: params.Sorted()}
+	for _, p := range s.params {
+		switch param := p.Param.(type) {
+		case *diviner.Range:
+			switch param.Kind() {
+			case diviner.Integer, diviner.Real:
+			default:
+				return nil, fmt.Errorf("gp: parameter %s: unsupported range kind %s", p.Name, param.Kind())
+			}
+			s.dim++
+		case *diviner.LogRange:
+			switch param.Kind() {
+			case diviner.Integer, diviner.Real:
+			default:
+				return nil, fmt.Errorf("gp: parameter %s: unsupported range kind %s", p.Name, param.Kind())
+			}
+			s.dim++
+		case diviner.Ordinal:
+			if param.Len() == 0 {
+				return nil, fmt.Errorf("gp: parameter %s: empty ordinal parameter", p.Name)
+			}
+			s.dim++
+		case *diviner.Discrete:
+			n := len(param.Values())
+			if n == 0 {
+				return nil, fmt.Errorf("gp: parameter %s: empty discrete parameter", p.Name)
+			}
+			s.dim += n
+		default:
+			return nil, fmt.Errorf("gp: parameter %s: unsupported parameter %s", p.Name, p.Param)
+		}
+	}
+	return s, nil
+}
+
+func (s *gpSpace) encode(values diviner.Values) []float64 {
+	x := make([]float64, 0, s.dim)
+	for _, p := range s.params {
+		v := values[p.Name]
+		switch param := p.Param.(type) {
+		case *diviner.Range:
+			var lo, hi, f float64
+			if param.Kind() == diviner.Integer {
+				lo, hi, f = float64(param.Start.Int()), float64(param.End.Int()-1), float64(v.Int())
+			} else {
+				lo, hi, f = param.Start.Float(), param.End.Float(), v.Float()
+			}
+			x = append(x, scale(f, lo, hi))
+		case *diviner.LogRange:
+			var lo, hi, f float64
+			if param.Kind() == diviner.Integer {
+				lo, hi, f = float64(param.Start.Int()), float64(param.End.Int()-1), float64(v.Int())
+			} else {
+				lo, hi, f = param.Start.Float(), param.End.Float(), v.Float()
+			}
+			x = append(x, scale(math.Log(f), math.Log(lo), math.Log(hi)))
+		case diviner.Ordinal:
+			x = append(x, scale(float64(param.Index(v)), 0, float64(param.Len()-1)))
+		case *diviner.Discrete:
+			for _, w := range param.Values() {
+				if w.Equal(v) {
+					x = append(x, 1)
+				} else {
+					x = append(x, 0)
+				}
+			}
+		}
+	}
+	return x
+}
+
+// scale maps f in [lo, hi] to [0, 1].
+func scale(f, lo, hi float64) float64 {
+	if hi > lo {
+		return (f - lo) / (hi - lo)
+	}
+	return 0
+}
+
+// gpObservations is a set of observed (or assumed) points and their
+// objective values, oriented for maximization.
+type gpObservations struct {
+	values []diviner.Values
+	x      [][]float64
+	y      []float64
+	// lies are the indices of points whose value is assumed.
+	lies []int
+}
+
+func (o *gpObservations) add(values diviner.Values, x []float64, y float64) {
+	o.values = append(o.values, values)
+	o.x = append(o.x, x)
+	o.y = append(o.y, y)
+	o.updateLies()
+}
+
+// lie adds a point for which no objective value has been observed.
+// Its value is assumed to be the worst value observed so far.
+func (o *gpObservations) lie(values diviner.Values, x []float64) {
+	o.lies = append(o.lies, len(o.y))
+	o.values = append(o.values, values)
+	o.x = append(o.x, x)
+	o.y = append(o.y, 0)
+	o.updateLies()
+}
+
+func (o *gpObservations) updateLies() {
+	var (
+		worst = math.Inf(1)
+		j     int
+	)
+	for i, y := range o.y {
+		if j < len(o.lies) && o.lies[j] == i {
+			j++
+			continue
+		}
+		worst = math.Min(worst, y)
+	}
+	if math.IsInf(worst, 1) {
+		worst = 0
+	}
+	for _, i := range o.lies {
+		o.y[i] = worst
+	}
+}
+
+// numObserved returns the number of points with observed values.
+func (o *gpObservations) numObserved() int {
+	return len(o.y) - len(o.lies)
+}
+
+func (o *gpObservations) contains(values diviner.Values) bool {
+	for _, v := range o.values {
+		if v.Equal(values) {
+			return true
+		}
+	}
+	return false
+}
+
+// sample returns a random point that has not yet been observed, or nil
+// if none could be found after the provided number of tries.
+func (o *gpObservations) sample(params diviner.Params, random *rand.Rand, tries int) diviner.Values {
+	for i := 0; i < tries; i++ {
+		values := params.Sample(random)
+		if !o.contains(values) {
+			return values
+		}
+	}
+	return nil
+}
+
+// suggest returns the candidate point that maximizes the expected
+// improvement under a Gaussian process fit to the observations. It
+// returns nil if no model could be fit or no candidate is found.
+func (o *gpObservations) suggest(space *gpSpace, params diviner.Params, random *rand.Rand, ncandidates int, xi float64) diviner.Values {
+	if o.numObserved() == 0 {
+		return nil
+	}
+	model, err := fitGP(o.x, o.y)
+	if err != nil {
+		return nil
+	}
+	var (
+		best      diviner.Values
+		bestEI    = math.Inf(-1)
+		incumbent = model.max()
+	)
+	for i := 0; i < ncandidates; i++ {
+		values := params.Sample(random)
+		if o.contains(values) {
+			continue
+		}
+		mu, sigma := model.predict(space.encode(values))
+		if ei := expectedImprovement(mu, sigma, incumbent, xi); ei > bestEI {
+			best, bestEI = values, ei
+		}
+	}
+	return best
+}
+
+// expectedImprovement returns the expected improvement over the
+// incumbent value of a point with the provided predictive mean and
+// standard deviation.
+func expectedImprovement(mu, sigma, incumbent, xi float64) float64 {
+	d := mu - incumbent - xi
+	if sigma <= 0 {
+		return math.Max(d, 0)
+	}
+	z := d / sigma
+	return d*normCDF(z) + sigma*normPDF(z)
+}
+
+func normCDF(z float64) float64 { return 0.5 * math.Erfc(-z/math.Sqrt2) }
+
+func normPDF(z float64) float64 { return math.Exp(-z*z/2) / math.Sqrt(2*math.Pi) }
+
+var errNotPositiveDefinite = errors.New("gp: matrix is not positive definite")
+
+// gpModel is a Gaussian process fit to a set of observations. The
+// observations are standardized to zero mean and unit variance.
+type gpModel struct {
+	x           [][]float64
+	mean, scale float64
+	lengthScale float64
+	chol        [][]float64
+	alpha       []float64
+}
+
+// fitGP fits a Gaussian process to the provided observations,
+// selecting the kernel length scale that maximizes the marginal
+// likelihood.
+func fitGP(x [][]float64, y []float64) (*gpModel, error) {
+	var mean, scale float64
+	for _, v := range y {
+		mean += v
+	}
+	mean /= float64(len(y))
+	for _, v := range y {
+		scale += (v - mean) * (v - mean)
+	}
+	scale = math.Sqrt(scale / float64(len(y)))
+	if scale == 0 {
+		scale = 1
+	}
+	z := make([]float64, len(y))
+	for i, v := range y {
+		z[i] = (v - mean) / scale
+	}
+	var (
+		best   *gpModel
+		bestLL = math.Inf(-1)
+	)
+	for _, l := range gpLengthScales {
+		m := &gpModel{x: x, mean: mean, scale: scale, lengthScale: l}
+		ll, err := m.fit(z)
+		if err != nil {
+			continue
+		}
+		if ll > bestLL {
+			best, bestLL = m, ll
+		}
+	}
+	if best == nil {
+		return nil, errNotPositiveDefinite
+	}
+	return best, nil
+}
+
+// fit computes the model's Cholesky factorization for the provided
+// standardized observations, returning their log marginal
+// likelihood.
+func (m *gpModel) fit(z []float64) (float64, error) {
+	n := len(m.x)
+	k := make([][]float64, n)
+	for i := range k {
+		k[i] = make([]float64, n)
+		for j := range k[i] {
+			k[i][j] = m.kernel(m.x[i], m.x[j])
+		}
+	}
+	var err error
+	for jitter := 1e-6; jitter < 1; jitter *= 10 {
+		for i := range k {
+			k[i][i] = 1 + jitter
+		}
+		if m.chol, err = cholesky(k); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	m.alpha = cholSolve(m.chol, z)
+	ll := -0.5 * float64(n) * math.Log(2*math.Pi)
+	for i := range z {
+		ll -= 0.5*z[i]*m.alpha[i] + math.Log(m.chol[i][i])
+	}
+	return ll, nil
+}
+
+// kernel is the Matérn 5/2 kernel.
+func (m *gpModel) kernel(x, y []float64) float64 {
+	var d float64
+	for i := range x {
+		d += (x[i] - y[i]) * (x[i] - y[i])
+	}
+	r := math.Sqrt(5*d) / m.lengthScale
+	return (1 + r + r*r/3) * math.Exp(-r)
+}
+
+// predict returns the predictive mean and standard deviation of the
+// model at point x, in the units of the original observations.
+func (m *gpModel) predict(x []float64) (mu, sigma float64) {
+	ks := make([]float64, len(m.x))
+	for i := range m.x {
+		ks[i] = m.kernel(x, m.x[i])
+		mu += ks[i] * m.alpha[i]
+	}
+	v := forwardSubst(m.chol, ks)
+	variance := 1.0
+	for _, vi := range v {
+		variance -= vi * vi
+	}
+	if variance < 0 {
+		variance = 0
+	}
+	return m.mean + m.scale*mu, m.scale * math.Sqrt(variance)
+}
+
+// max returns the largest predicted mean at the observed points.
+func (m *gpModel) max() float64 {
+	max := math.Inf(-1)
+	for _, x := range m.x {
+		if mu, _ := m.predict(x); mu > max {
+			max = mu
+		}
+	}
+	return max
+}
+
+// cholesky returns the lower triangular Cholesky factor of the
+// symmetric positive definite matrix a.
+func cholesky(a [][]float64) ([][]float64, error) {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, errNotPositiveDefinite
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// forwardSubst solves l*x = b for lower triangular l.
+func forwardSubst(l [][]float64, b []float64) []float64 {
+	x := make([]float64, len(b))
+	for i := range b {
+		sum := b[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+// cholSolve solves (l*lᵀ)*x = b given the Cholesky factor l.
+func cholSolve(l [][]float64, b []float64) []float64 {
+	y := forwardSubst(l, b)
+	x := make([]float64, len(y))
+	for i := len(y) - 1; i >= 0; i-- {
+		sum := y[i]
+		for k := i + 1; k < len(y); k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
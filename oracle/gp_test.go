@@ -0,0 +1,149 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestGP(t *testing.T) {
+	params := diviner.Params{
+		"x":   diviner.NewRange(diviner.Float(-2), diviner.Float(2)),
+		"n":   diviner.NewRange(diviner.Int(0), diviner.Int(10)),
+		"opt": diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd")),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	loss := func(v diviner.Values) float64 {
+		x, n := v["x"].Float()-0.5, float64(v["n"].Int()-7)
+		l := x*x + n*n/10
+		if v["opt"].Str() == "sgd" {
+			l++
+		}
+		return l
+	}
+	gp := NewGP(1)
+	var (
+		trials []diviner.Trial
+		best   = math.Inf(1)
+	)
+	for round := 0; round < 8; round++ {
+		values, err := gp.Next(trials, params, objective, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(values), 4; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for _, v := range values {
+			if !params.IsValid(v) {
+				t.Fatalf("invalid values %v", v)
+			}
+			for _, trial := range trials {
+				if trial.Values.Equal(v) {
+					t.Fatalf("duplicate trial %v", v)
+				}
+			}
+			l := loss(v)
+			best = math.Min(best, l)
+			trials = append(trials, diviner.Trial{Values: v, Metrics: diviner.Metrics{"loss": l}})
+		}
+	}
+	if best > 0.2 {
+		t.Errorf("best loss %v is too large", best)
+	}
+}
+
+func TestGPEnum(t *testing.T) {
+	params := diviner.Params{"size": diviner.NewEnum("small", "medium", "large")}
+	space, err := newGPSpace(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enums are embedded in a single, ordered dimension.
+	if got, want := space.dim, 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, name := range []string{"small", "medium", "large"} {
+		x := space.encode(diviner.Values{"size": diviner.String(name)})
+		if got, want := x[0], float64(i)/2; got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	values, err := NewGP(1).Next(nil, params, objective, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range values {
+		if !params.IsValid(v) {
+			t.Errorf("invalid values %v", v)
+		}
+	}
+}
+
+func TestGPScaledRanges(t *testing.T) {
+	params := diviner.Params{
+		"lr":    diviner.NewLogRange(diviner.Float(1e-6), diviner.Float(1)),
+		"batch": diviner.NewQuantizedRange(diviner.Int(16), diviner.Int(257), diviner.Int(16)),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	loss := func(v diviner.Values) float64 {
+		lr, batch := math.Log10(v["lr"].Float())+3, float64(v["batch"].Int()-64)/64
+		return lr*lr + batch*batch
+	}
+	gp := NewGP(1)
+	var (
+		trials []diviner.Trial
+		best   = math.Inf(1)
+	)
+	for round := 0; round < 8; round++ {
+		values, err := gp.Next(trials, params, objective, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			if !params.IsValid(v) {
+				t.Fatalf("invalid values %v", v)
+			}
+			l := loss(v)
+			best = math.Min(best, l)
+			trials = append(trials, diviner.Trial{Values: v, Metrics: diviner.Metrics{"loss": l}})
+		}
+	}
+	if best > 0.2 {
+		t.Errorf("best loss %v is too large", best)
+	}
+}
+
+func TestGPExhausted(t *testing.T) {
+	params := diviner.Params{
+		"x": diviner.NewDiscrete(diviner.Int(1), diviner.Int(2), diviner.Int(3)),
+	}
+	objective := diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+	trials := []diviner.Trial{
+		{Values: diviner.Values{"x": diviner.Int(1)}, Metrics: diviner.Metrics{"acc": 0.1}},
+		{Values: diviner.Values{"x": diviner.Int(2)}, Pending: true},
+	}
+	values, err := NewGP(0).Next(trials, params, objective, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(values), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := values[0]["x"].Int(), int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGPInvalidParams(t *testing.T) {
+	params := diviner.Params{"x": &diviner.Range{Start: diviner.String("a"), End: diviner.String("z")}}
+	if _, err := NewGP(0).Next(nil, params, diviner.Objective{}, 1); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -34,10 +34,19 @@ type GridSearch struct{}
 // parameters are encountered. GridSearch is deterministic, always
 // returning parameters in the same order.
 //
+// Conditional parameters are expanded only for the points of the grid
+// at which they are active, so that the grid comprises one point for
+// each distinct assignment of the active parameters.
+//
 // [1] https://en.wikipedia.org/wiki/Hyperparameter_optimization
 func (_ *GridSearch) Next(previous []diviner.Trial,
 	params diviner.Params, objective diviner.Objective,
 	howmany int) ([]diviner.Values, error) {
+	for _, param := range params {
+		if _, ok := param.(*diviner.Conditional); ok {
+			return conditionalGrid(previous, params, howmany)
+		}
+	}
 	var (
 		keys    = make([]string, 0, len(params))
 		pvalues = make(map[string][]diviner.Value)
@@ -111,3 +120,30 @@ outer:
 	}
 	return values, nil
 }
+
+// ConditionalGrid returns up to howmany points of the grid defined by
+// the provided parameters, some of which are conditional, that are
+// not among the previous trials. The grid is laid out so that, as in
+// the unconditional case, the first parameter (in the order given by
+// diviner.Params.Ordered) varies fastest.
+func conditionalGrid(previous []diviner.Trial, params diviner.Params, howmany int) ([]diviner.Values, error) {
+	points, err := params.Enumerate()
+	if err != nil {
+		return nil, err
+	}
+	done := diviner.NewMap()
+	for _, trial := range previous {
+		done.Put(trial.Values, true)
+	}
+	var result []diviner.Values
+	for _, point := range points {
+		if _, ok := done.Get(point); ok {
+			continue
+		}
+		result = append(result, point)
+		if howmany > 0 && len(result) == howmany {
+			break
+		}
+	}
+	return result, nil
+}
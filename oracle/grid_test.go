@@ -76,6 +76,38 @@ func TestGridSearch(t *testing.T) {
 	}
 }
 
+func TestGridSearchVector(t *testing.T) {
+	params := diviner.Params{
+		"widths": diviner.NewVector(
+			diviner.NewDiscrete(diviner.Int(32), diviner.Int(64)),
+			diviner.NewDiscrete(diviner.Int(32), diviner.Int(64)),
+		),
+	}
+	var search oracle.GridSearch
+	values, err := search.Next(nil, params, diviner.Objective{}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(values), 4; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	trials := []diviner.Trial{{Values: diviner.Values{
+		"widths": diviner.List{diviner.Int(64), diviner.Int(32)},
+	}}}
+	next, err := search.Next(trials, params, diviner.Objective{}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(next), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, vs := range next {
+		if vs["widths"].Equal(trials[0].Values["widths"]) {
+			t.Errorf("repeated trial %v", vs)
+		}
+	}
+}
+
 func TestGridSearchRange(t *testing.T) {
 	params := diviner.Params{
 		"x": diviner.NewRange(diviner.Int(0), diviner.Int(100)),
@@ -132,6 +164,53 @@ func TestGridSearchList(t *testing.T) {
 	}
 }
 
+func TestGridSearchConditional(t *testing.T) {
+	params := diviner.Params{
+		"optimizer": diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd")),
+		"momentum": diviner.NewConditional(
+			diviner.NewDiscrete(diviner.Float(0.5), diviner.Float(0.9)),
+			"optimizer", diviner.String("sgd")),
+		"rate": diviner.NewDiscrete(diviner.Float(0.1), diviner.Float(0.01)),
+	}
+	var search oracle.GridSearch
+	values, err := search.Next(nil, params, diviner.Objective{}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (adam, or sgd with either momentum) x (either rate)
+	if got, want := len(values), 6; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	seen := diviner.NewMap()
+	for _, vs := range values {
+		if !params.IsValid(vs) {
+			t.Errorf("invalid values %v", vs)
+		}
+		if _, ok := seen.Get(vs); ok {
+			t.Errorf("repeated values %v", vs)
+		}
+		seen.Put(vs, true)
+	}
+	trials := make([]diviner.Trial, 4)
+	for i := range trials {
+		trials[i].Values = values[i]
+	}
+	next, err := search.Next(trials, params, diviner.Objective{}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := next, values[4:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	next, err = search.Next(nil, params, diviner.Objective{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := next, values[:2]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 // sortValues sorts the provided set of values by keys. It assumes
 // that all of the values have exactly the same sets of keys.
 func sortValues(vs []diviner.Values) {
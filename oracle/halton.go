@@ -0,0 +1,218 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&Halton{})
+}
+
+// Halton is an oracle that samples trials from a Halton sequence, a
+// low-discrepancy (quasi-random) sequence that covers the search
+// space more evenly than uniform random sampling: every prefix of the
+// sequence fills the unit hypercube with few gaps or clusters. This
+// makes Halton a good choice for the initial exploration of a study,
+// before a model-based oracle is used, or as a baseline.
+//
+// Each parameter (in sorted order) is assigned one dimension of the
+// sequence, using successive prime bases. Points in the unit
+// hypercube are mapped to parameter values as follows: ranges are
+// scaled to their extent, log ranges in log space, and quantized
+// ranges, enums, and discrete parameters by the index of their values.
+// Weighted discrete parameters allot each of their values a share of
+// the unit interval proportional to its weight.
+//
+// Unscrambled Halton sequences exhibit strong correlations between
+// dimensions with large bases; thus, by default, the digits of each
+// dimension are scrambled by a random permutation determined by Seed.
+// Like RandomSearch, the i'th trial of a study is always the i'th
+// point of the sequence, regardless of how trials are batched.
+type Halton struct {
+	// N is the total number of trials to sample. If N is not
+	// positive, trials are sampled indefinitely.
+	N int
+	// Seed is the seed of the random number generator used to
+	// scramble the sequence.
+	Seed int64
+	// Scramble determines whether the sequence's digits are
+	// scrambled.
+	Scramble bool
+}
+
+// NewHalton returns a new scrambled Halton oracle that samples n
+// trials, with the provided seed.
+func NewHalton(n int, seed int64) *Halton {
+	return &Halton{N: n, Seed: seed, Scramble: true}
+}
+
+// String returns a textual description of the oracle.
+func (h *Halton) String() string {
+	return fmt.Sprintf("halton(n=%d, seed=%d, scramble=%v)", h.N, h.Seed, h.Scramble)
+}
+
+// Next implements diviner.Oracle.
+func (h *Halton) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	skip := len(previous)
+	if h.N > 0 {
+		if skip >= h.N {
+			return nil, nil
+		}
+		if skip+howmany > h.N {
+			howmany = h.N - skip
+		}
+	}
+	sorted := params.Sorted()
+	for _, p := range sorted {
+		switch param := p.Param.(type) {
+		case *diviner.Range, *diviner.LogRange:
+			switch param.Kind() {
+			case diviner.Integer, diviner.Real:
+			default:
+				return nil, fmt.Errorf("halton: parameter %s: unsupported range kind %s", p.Name, param.Kind())
+			}
+		case diviner.Ordinal:
+			if param.Len() == 0 {
+				return nil, fmt.Errorf("halton: parameter %s: empty ordinal parameter", p.Name)
+			}
+		case *diviner.Discrete:
+			if len(param.Values()) == 0 {
+				return nil, fmt.Errorf("halton: parameter %s: empty discrete parameter", p.Name)
+			}
+		default:
+			return nil, fmt.Errorf("halton: parameter %s: unsupported parameter %s", p.Name, p.Param)
+		}
+	}
+	var (
+		bases = primes(len(sorted))
+		perms = make([][]int, len(sorted))
+	)
+	if h.Scramble {
+		random := rand.New(rand.NewSource(h.Seed))
+		for i, base := range bases {
+			perms[i] = digitPermutation(base, random)
+		}
+	}
+	result := make([]diviner.Values, howmany)
+	for i := range result {
+		// The sequence starts at index 1: point 0 is the origin in
+		// every dimension.
+		index := uint64(skip + i + 1)
+		values := make(diviner.Values, len(sorted))
+		for j, p := range sorted {
+			num, denom := radicalInverse(index, bases[j], perms[j])
+			values[p.Name] = haltonValue(p.Param, num, denom)
+		}
+		result[i] = values
+	}
+	return result, nil
+}
+
+// RadicalInverse returns the radical inverse of index in the
+// provided base, the base-b digits of index mirrored about the radix
+// point, as the fraction num/denom. If perm is non-nil, each digit d
+// is replaced by perm[d]. The fraction is exact, so that points on
+// the boundaries of strata are mapped to parameter values without
+// rounding errors.
+func radicalInverse(index uint64, base int, perm []int) (num, denom uint64) {
+	b := uint64(base)
+	denom = 1
+	for ; index > 0; index /= b {
+		d := index % b
+		if perm != nil {
+			d = uint64(perm[d])
+		}
+		num = num*b + d
+		denom *= b
+	}
+	return num, denom
+}
+
+// DigitPermutation returns a random permutation of the digits of the
+// provided base that maps 0 to itself, so that the infinitely many
+// leading zeros of an index remain zeros.
+func digitPermutation(base int, random *rand.Rand) []int {
+	perm := make([]int, base)
+	for i, d := range random.Perm(base - 1) {
+		perm[i+1] = d + 1
+	}
+	return perm
+}
+
+// HaltonValue returns the value of the provided parameter at the
+// point num/denom of the unit interval.
+func haltonValue(param diviner.Param, num, denom uint64) diviner.Value {
+	u := float64(num) / float64(denom)
+	switch param := param.(type) {
+	case *diviner.Range:
+		if param.Kind() == diviner.Integer {
+			start, end := param.Start.Int(), param.End.Int()
+			return diviner.Int(start + int64(stratum(num, denom, uint64(end-start))))
+		}
+		lo, hi := param.Start.Float(), param.End.Float()
+		return diviner.Float(math.Min(lo+u*(hi-lo), math.Nextafter(hi, lo)))
+	case *diviner.LogRange:
+		if param.Kind() == diviner.Integer {
+			start, end := param.Start.Int(), param.End.Int()
+			lo, hi := math.Log(float64(start)), math.Log(float64(end))
+			v := int64(math.Exp(lo + u*(hi-lo)))
+			if v < start {
+				v = start
+			} else if v >= end {
+				v = end - 1
+			}
+			return diviner.Int(v)
+		}
+		start, end := param.Start.Float(), param.End.Float()
+		lo, hi := math.Log(start), math.Log(end)
+		return diviner.Float(math.Max(start, math.Min(math.Exp(lo+u*(hi-lo)), math.Nextafter(end, start))))
+	case diviner.Ordinal:
+		return param.Value(int(stratum(num, denom, uint64(param.Len()))))
+	case *diviner.Discrete:
+		if param.Weights != nil {
+			return param.Quantile(u)
+		}
+		values := param.Values()
+		return values[stratum(num, denom, uint64(len(values)))]
+	}
+	panic(param)
+}
+
+// Stratum returns the index of the stratum, among n equal strata of
+// the unit interval, that contains the point num/denom.
+func stratum(num, denom, n uint64) uint64 {
+	hi, lo := bits.Mul64(num, n)
+	q, _ := bits.Div64(hi, lo, denom)
+	return q
+}
+
+// Primes returns the first n prime numbers.
+func primes(n int) []int {
+	ps := make([]int, 0, n)
+	for k := 2; len(ps) < n; k++ {
+		prime := true
+		for _, p := range ps {
+			if p*p > k {
+				break
+			}
+			if k%p == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			ps = append(ps, k)
+		}
+	}
+	return ps
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestHalton(t *testing.T) {
+	params := diviner.Params{
+		"a": diviner.NewDiscrete(diviner.String("x"), diviner.String("y"), diviner.String("z")),
+		"b": diviner.NewRange(diviner.Int(0), diviner.Int(100)),
+		"c": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+		"d": diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1)),
+		"e": diviner.NewQuantizedRange(diviner.Float(0), diviner.Float(1), diviner.Float(0.25)),
+	}
+	const N = 50
+	for _, scramble := range []bool{false, true} {
+		halton := &Halton{N: N, Seed: 123, Scramble: scramble}
+		all, err := halton.Next(nil, params, diviner.Objective{}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(all), N; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		seen := diviner.NewMap()
+		for _, values := range all {
+			if !params.IsValid(values) {
+				t.Errorf("invalid values %v", values)
+			}
+			seen.Put(values, true)
+		}
+		if got, want := seen.Len(), N; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		// Sampling in batches reproduces the same sequence.
+		var previous []diviner.Trial
+		for len(previous) < N {
+			values, err := halton.Next(previous, params, diviner.Objective{}, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range values {
+				if got, want := v, all[len(previous)]; !got.Equal(want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				previous = append(previous, diviner.Trial{Values: v})
+			}
+		}
+		if values, err := halton.Next(previous, params, diviner.Objective{}, 1); err != nil {
+			t.Fatal(err)
+		} else if len(values) != 0 {
+			t.Errorf("expected no values, got %v", values)
+		}
+	}
+}
+
+func TestHaltonWeighted(t *testing.T) {
+	params := diviner.Params{
+		"opt": diviner.NewWeightedDiscrete(
+			[]diviner.Value{diviner.String("adam"), diviner.String("sgd")},
+			[]float64{3, 1},
+		),
+	}
+	values, err := (&Halton{}).Next(nil, params, diviner.Objective{}, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, v := range values {
+		if v["opt"].Str() == "adam" {
+			n++
+		}
+	}
+	// The (unscrambled) base-2 sequence is evenly spread over the
+	// unit interval.
+	if got, want := n, 48; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHaltonStratified(t *testing.T) {
+	// The first 2^2*3^2 points of the (scrambled) Halton sequence in
+	// two dimensions occupy each of the 4x9 boxes of the unit square
+	// exactly once.
+	params := diviner.Params{
+		"x": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+		"y": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+	}
+	for _, halton := range []*Halton{{}, NewHalton(0, 1), NewHalton(0, 2)} {
+		values, err := halton.Next(nil, params, diviner.Objective{}, 36)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var boxes [4][9]int
+		for _, v := range values {
+			// Points may lie on box boundaries, up to rounding.
+			boxes[int(v["x"].Float()*4+1e-9)][int(v["y"].Float()*9+1e-9)]++
+		}
+		for i := range boxes {
+			for j, n := range boxes[i] {
+				if n != 1 {
+					t.Errorf("%s: box (%d, %d) has %d points", halton, i, j, n)
+				}
+			}
+		}
+	}
+	// Different seeds produce different sequences.
+	a, _ := NewHalton(0, 1).Next(nil, params, diviner.Objective{}, 10)
+	b, _ := NewHalton(0, 2).Next(nil, params, diviner.Objective{}, 10)
+	same := true
+	for i := range a {
+		same = same && a[i].Equal(b[i])
+	}
+	if same {
+		t.Error("seeds produced the same sequence")
+	}
+}
+
+func TestHaltonUnsupported(t *testing.T) {
+	params := diviner.Params{
+		"v": diviner.NewVector(diviner.NewRange(diviner.Int(0), diviner.Int(10))),
+	}
+	if _, err := NewHalton(10, 0).Next(nil, params, diviner.Objective{}, 1); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&SuccessiveHalving{})
+}
+
+const defaultHalvingEta = 3
+
+// SuccessiveHalving is a multi-fidelity oracle implementing
+// successive halving [1], the basis of Hyperband and BOHB. It
+// implements diviner.FidelityOracle: trials are sampled at random and
+// run at the study's minimum fidelity; the best 1/Eta of the trials
+// run at each fidelity (or rung) are then promoted to the next,
+// whose fidelity is Eta times larger, until the study's maximum
+// fidelity is reached.
+//
+// SuccessiveHalving derives its rungs from the previous trials alone.
+// Promotions are made as soon as a rung's completed trials warrant
+// them, as in asynchronous successive halving [2]; when a trial
+// cannot be promoted, a new trial is sampled. Studies using
+// SuccessiveHalving are best run in rounds, so that each round's
+// promotions consider the completed trials of the previous ones.
+//
+// As with RandomSearch, the i'th trial sampled is the i'th sample of a
+// sequence determined by Seed. Studies without a fidelity are run as
+// by RandomSearch.
+//
+// [1] Jamieson and Talwalkar, "Non-stochastic Best Arm Identification
+// and Hyperparameter Optimization", https://arxiv.org/abs/1502.07943
+// [2] Li et al., "A System for Massively Parallel Hyperparameter
+// Tuning", https://arxiv.org/abs/1810.05934
+type SuccessiveHalving struct {
+	// N is the total number of trials sampled at the minimum
+	// fidelity. If N is not positive, trials are sampled
+	// indefinitely.
+	N int
+	// Eta is the factor by which the number of trials is reduced, and
+	// their fidelity increased, at each rung. It defaults to 3.
+	Eta int
+	// Seed is the seed of the random number generator used to sample
+	// trials.
+	Seed int64
+}
+
+// String returns a textual description of the oracle.
+func (s *SuccessiveHalving) String() string {
+	return fmt.Sprintf("successive_halving(n=%d, eta=%d, seed=%d)", s.N, s.eta(), s.Seed)
+}
+
+func (s *SuccessiveHalving) eta() int {
+	if s.Eta < 2 {
+		return defaultHalvingEta
+	}
+	return s.Eta
+}
+
+// Next implements diviner.Oracle. Without a fidelity, trials are
+// sampled as by RandomSearch.
+func (s *SuccessiveHalving) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	return (&RandomSearch{N: s.N, Seed: s.Seed}).Next(previous, params, objective, howmany)
+}
+
+// NextFidelity implements diviner.FidelityOracle. If howmany is not
+// positive, NextFidelity returns all of the trials that can currently
+// be promoted, together with the remainder of the N trials to be
+// sampled.
+func (s *SuccessiveHalving) NextFidelity(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, fidelity diviner.Fidelity, howmany int) ([]diviner.Values, error) {
+	rungs := s.rungs(fidelity)
+	// Trials are assigned to the rungs at whose fidelities they were
+	// run; each rung stores the parameter values of its trials.
+	var (
+		trials = make([][]diviner.Trial, len(rungs))
+		seen   = make([]*diviner.Map, len(rungs))
+	)
+	for i := range rungs {
+		seen[i] = diviner.NewMap()
+	}
+	for _, trial := range previous {
+		f, ok := fidelity.Get(trial.Values)
+		if !ok {
+			continue
+		}
+		for i, rung := range rungs {
+			if f != rung {
+				continue
+			}
+			trial.Values = fidelity.Strip(trial.Values)
+			if _, ok := seen[i].Get(trial.Values); !ok {
+				seen[i].Put(trial.Values, true)
+				trials[i] = append(trials[i], trial)
+			}
+			break
+		}
+	}
+	var (
+		values  []diviner.Values
+		sampled = len(trials[0])
+	)
+	for howmany <= 0 || len(values) < howmany {
+		if promoted, rung, ok := s.promote(trials, seen, objective); ok {
+			seen[rung].Put(promoted, true)
+			values = append(values, fidelity.With(promoted, rungs[rung]))
+			continue
+		}
+		if s.N <= 0 && howmany <= 0 || s.N > 0 && sampled >= s.N {
+			break
+		}
+		sample := params.Sample(rand.New(rand.NewSource(s.Seed + int64(sampled))))
+		sampled++
+		seen[0].Put(sample, true)
+		values = append(values, fidelity.With(sample, rungs[0]))
+	}
+	return values, nil
+}
+
+// Rungs returns the fidelities of the oracle's rungs, from the
+// fidelity's minimum to its maximum. Integer fidelities are rounded.
+func (s *SuccessiveHalving) rungs(fidelity diviner.Fidelity) []float64 {
+	var (
+		rungs []float64
+		eta   = float64(s.eta())
+	)
+	for f := fidelity.Min; ; f *= eta {
+		if f > fidelity.Max {
+			f = fidelity.Max
+		}
+		rung := fidelity.Round(f)
+		if len(rungs) == 0 || rung != rungs[len(rungs)-1] {
+			rungs = append(rungs, rung)
+		}
+		if f == fidelity.Max {
+			return rungs
+		}
+	}
+}
+
+// Promote returns the parameter values of the next trial to be
+// promoted, together with the rung to which it is promoted. Trials
+// are promoted from the highest rungs first; a trial is promoted if
+// it is among the best 1/Eta of its rung's completed trials, and it
+// has not already been run in the next rung.
+func (s *SuccessiveHalving) promote(trials [][]diviner.Trial, seen []*diviner.Map, objective diviner.Objective) (diviner.Values, int, bool) {
+	for i := len(trials) - 2; i >= 0; i-- {
+		var completed []diviner.Trial
+		for _, trial := range trials[i] {
+			if v, ok := trial.Metrics[objective.Metric]; ok && !trial.Pending && !math.IsNaN(v) {
+				completed = append(completed, trial)
+			}
+		}
+		sortTrials(completed, objective)
+		for _, trial := range completed[:len(completed)/s.eta()] {
+			if _, ok := seen[i+1].Get(trial.Values); !ok {
+				return trial.Values, i + 1, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// SortTrials sorts the provided trials, all of which report the
+// objective's metric, from best to worst.
+func sortTrials(trials []diviner.Trial, objective diviner.Objective) {
+	sort.SliceStable(trials, func(i, j int) bool {
+		iv, jv := trials[i].Metrics[objective.Metric], trials[j].Metrics[objective.Metric]
+		if objective.Direction == diviner.Maximize {
+			return iv > jv
+		}
+		return iv < jv
+	})
+}
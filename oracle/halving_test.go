@@ -0,0 +1,84 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestSuccessiveHalving(t *testing.T) {
+	var (
+		params    = diviner.Params{"x": diviner.NewRange(diviner.Float(0), diviner.Float(1))}
+		objective = diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+		fidelity  = diviner.Fidelity{Name: "epochs", Min: 1, Max: 9, Integer: true}
+		halving   = &SuccessiveHalving{N: 9, Eta: 3}
+		previous  []diviner.Trial
+	)
+	// Each round runs the suggested trials to completion; the
+	// accuracy of a trial grows with both x and its fidelity.
+	var rounds [][]diviner.Values
+	for {
+		values, err := halving.NextFidelity(previous, params, objective, fidelity, 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) == 0 {
+			break
+		}
+		rounds = append(rounds, values)
+		for _, v := range values {
+			epochs, ok := fidelity.Get(v)
+			if !ok {
+				t.Fatalf("values %v have no fidelity", v)
+			}
+			if !params.IsValid(fidelity.Strip(v)) {
+				t.Errorf("invalid values %v", v)
+			}
+			previous = append(previous, diviner.Trial{
+				Values:  v,
+				Metrics: diviner.Metrics{"acc": v["x"].Float() * epochs},
+			})
+		}
+	}
+	if got, want := len(rounds), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, want := range []struct {
+		n      int
+		epochs int64
+	}{{9, 1}, {3, 3}, {1, 9}} {
+		if got, want := len(rounds[i]), want.n; got != want {
+			t.Errorf("round %d: got %v, want %v", i, got, want)
+		}
+		for _, v := range rounds[i] {
+			if got, want := v["epochs"].Int(), want.epochs; got != want {
+				t.Errorf("round %d: got %v, want %v", i, got, want)
+			}
+		}
+	}
+	// The trial run at full fidelity is the best of those sampled.
+	var best float64
+	for _, v := range rounds[0] {
+		if x := v["x"].Float(); x > best {
+			best = x
+		}
+	}
+	if got, want := rounds[2][0]["x"].Float(), best; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSuccessiveHalvingRungs(t *testing.T) {
+	halving := &SuccessiveHalving{Eta: 2}
+	rungs := halving.rungs(diviner.Fidelity{Name: "fraction", Min: 0.1, Max: 1})
+	if got, want := len(rungs), 5; got != want {
+		t.Fatalf("got %v, want %v", rungs, want)
+	}
+	if got, want := rungs[4], 1.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
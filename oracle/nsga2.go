@@ -0,0 +1,305 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"encoding/gob"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&NSGA2{})
+}
+
+const (
+	defaultNSGA2PopulationSize = 20
+	// nsga2Tries is the number of times NSGA2 tries to produce a point
+	// that duplicates no previous trial before giving up.
+	nsga2Tries = 100
+)
+
+// NSGA2 is a multi-objective oracle based on the NSGA-II genetic
+// algorithm [1]. It maintains a population of the best trials,
+// ranked first by the Pareto front to which they belong (trials in
+// the first front are dominated by no other trial; those in the
+// second only by trials in the first; and so on), and then by their
+// crowding distance, which favors trials in sparsely explored regions
+// of the front. New points are bred from parents chosen from the
+// population by binary tournament: each parameter value is inherited
+// from either parent, and then mutated (resampled) with a small
+// probability.
+//
+// The first generation of PopulationSize points is sampled at
+// random. Since NSGA2 is steady-state, new points are bred from the
+// current population whenever they are requested, so that the
+// population evolves as trials complete. NSGA2 never repeats previous
+// trials.
+//
+// NSGA2 also implements diviner.Oracle for single-objective studies,
+// where it performs as a simple genetic algorithm.
+//
+// Infeasible trials (those violating their study's constraints) are
+// ranked behind all feasible trials, as in the constrained domination
+// of [1].
+//
+// [1] K. Deb, A. Pratap, S. Agarwal, and T. Meyarivan, "A fast and
+// elitist multiobjective genetic algorithm: NSGA-II," IEEE
+// Transactions on Evolutionary Computation, 6(2), 2002.
+type NSGA2 struct {
+	// Seed records the random seed that will be used to initialize
+	// random number generation for the next batch of points. It is
+	// exported so it can be serialized to preserve the oracle's state.
+	Seed int64
+	// PopulationSize is the number of trials in the population from
+	// which new points are bred. Defaults to 20.
+	PopulationSize int
+	// MutationRate is the probability with which each parameter
+	// value of a new point is resampled. Defaults to 1/n, where n is
+	// the number of parameters.
+	MutationRate float64
+
+	mutex sync.Mutex
+}
+
+// NewNSGA2 returns a new NSGA2 oracle with the given random seed and
+// default parameters.
+func NewNSGA2(seed int64) *NSGA2 {
+	return &NSGA2{Seed: seed}
+}
+
+// Next implements diviner.Oracle, optimizing a single objective.
+func (o *NSGA2) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	return o.NextMulti(previous, params, []diviner.Objective{objective}, howmany)
+}
+
+// NextMulti implements diviner.MultiOracle.
+func (o *NSGA2) NextMulti(previous []diviner.Trial, params diviner.Params, objectives []diviner.Objective, howmany int) ([]diviner.Values, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	random := rand.New(rand.NewSource(o.Seed))
+	o.Seed++
+	size := o.PopulationSize
+	if size <= 0 {
+		size = defaultNSGA2PopulationSize
+	}
+	rate := o.MutationRate
+	if rate <= 0 && len(params) > 0 {
+		rate = 1 / float64(len(params))
+	}
+	var (
+		complete []diviner.Trial
+		seen     = make([]diviner.Values, 0, len(previous)+howmany)
+	)
+	for _, trial := range previous {
+		seen = append(seen, trial.Values)
+		if !trial.Pending && params.IsValid(trial.Values) && hasObjectives(trial, objectives) {
+			complete = append(complete, trial)
+		}
+	}
+	var (
+		population = selectPopulation(complete, objectives, size)
+		ordered    = params.Ordered()
+		result     = make([]diviner.Values, 0, howmany)
+	)
+	for len(result) < howmany {
+		var values diviner.Values
+		for try := 0; try < nsga2Tries && values == nil; try++ {
+			var v diviner.Values
+			if len(seen) < size || len(population) < 2 {
+				v = params.Sample(random)
+			} else {
+				v = breed(tournament(population, random), tournament(population, random), ordered, rate, random)
+			}
+			if !containsValues(seen, v) {
+				values = v
+			}
+		}
+		if values == nil {
+			// The space is (probably) exhausted.
+			break
+		}
+		seen = append(seen, values)
+		result = append(result, values)
+	}
+	return result, nil
+}
+
+// An nsga2Member is a member of an NSGA-II population.
+type nsga2Member struct {
+	diviner.Trial
+	// Rank is the index of the Pareto front to which the trial
+	// belongs.
+	rank int
+	// Crowding is the trial's crowding distance within its front.
+	crowding float64
+}
+
+// Better tells whether m is preferred to n by the crowded comparison
+// operator.
+func (m nsga2Member) better(n nsga2Member) bool {
+	return m.rank < n.rank || (m.rank == n.rank && m.crowding > n.crowding)
+}
+
+// SelectPopulation returns the best size trials, ranked by front and
+// crowding distance. The fronts of infeasible trials follow those of
+// feasible trials.
+func selectPopulation(trials []diviner.Trial, objectives []diviner.Objective, size int) []nsga2Member {
+	var feasible, infeasible []diviner.Trial
+	for _, trial := range trials {
+		if trial.Infeasible {
+			infeasible = append(infeasible, trial)
+		} else {
+			feasible = append(feasible, trial)
+		}
+	}
+	var fronts [][]diviner.Trial
+	for _, group := range [][]diviner.Trial{feasible, infeasible} {
+		for _, front := range paretoFronts(group, objectives) {
+			members := make([]diviner.Trial, len(front))
+			for i, index := range front {
+				members[i] = group[index]
+			}
+			fronts = append(fronts, members)
+		}
+	}
+	var population []nsga2Member
+	for rank, front := range fronts {
+		members := make([]nsga2Member, len(front))
+		for i, trial := range front {
+			members[i] = nsga2Member{Trial: trial, rank: rank}
+		}
+		crowding(members, objectives)
+		if len(population)+len(members) > size {
+			sort.SliceStable(members, func(i, j int) bool { return members[i].crowding > members[j].crowding })
+			members = members[:size-len(population)]
+		}
+		population = append(population, members...)
+		if len(population) == size {
+			break
+		}
+	}
+	return population
+}
+
+// ParetoFronts performs a non-dominated sort of the provided trials,
+// returning the indices of the trials in each successive Pareto
+// front.
+func paretoFronts(trials []diviner.Trial, objectives []diviner.Objective) [][]int {
+	var (
+		// Dominated[i] is the set of trials dominated by trial i.
+		dominated = make([][]int, len(trials))
+		// Count[i] is the number of trials dominating trial i.
+		count = make([]int, len(trials))
+		front []int
+	)
+	for i := range trials {
+		for j := range trials {
+			if diviner.Dominates(trials[i], trials[j], objectives) {
+				dominated[i] = append(dominated[i], j)
+				count[j]++
+			}
+		}
+	}
+	for i := range trials {
+		if count[i] == 0 {
+			front = append(front, i)
+		}
+	}
+	var fronts [][]int
+	for len(front) > 0 {
+		fronts = append(fronts, front)
+		var next []int
+		for _, i := range front {
+			for _, j := range dominated[i] {
+				if count[j]--; count[j] == 0 {
+					next = append(next, j)
+				}
+			}
+		}
+		front = next
+	}
+	return fronts
+}
+
+// Crowding computes the crowding distance of each member of a front:
+// the sum, over the objectives, of the normalized distance between
+// the member's neighbors. Members at the extremes of any objective
+// have infinite crowding distance.
+func crowding(front []nsga2Member, objectives []diviner.Objective) {
+	index := make([]int, len(front))
+	for _, obj := range objectives {
+		for i := range index {
+			index[i] = i
+		}
+		metric := func(i int) float64 { return front[index[i]].Metrics[obj.Metric] }
+		sort.SliceStable(index, func(i, j int) bool { return metric(i) < metric(j) })
+		n := len(index)
+		front[index[0]].crowding = math.Inf(1)
+		front[index[n-1]].crowding = math.Inf(1)
+		span := metric(n-1) - metric(0)
+		if span == 0 {
+			continue
+		}
+		for i := 1; i < n-1; i++ {
+			front[index[i]].crowding += (metric(i+1) - metric(i-1)) / span
+		}
+	}
+}
+
+// Tournament selects a member of the population by binary tournament.
+func tournament(population []nsga2Member, random *rand.Rand) nsga2Member {
+	m, n := population[random.Intn(len(population))], population[random.Intn(len(population))]
+	if n.better(m) {
+		return n
+	}
+	return m
+}
+
+// Breed returns a child of the provided parents: each parameter value
+// is inherited from either parent with equal probability, and then
+// resampled with probability rate. The parameters must be ordered
+// (see diviner.Params.Ordered); conditional parameters that are
+// inactive for the child are omitted, and those that are active but
+// missing from the chosen parent are sampled.
+func breed(p, q nsga2Member, params []diviner.NamedParam, rate float64, random *rand.Rand) diviner.Values {
+	child := make(diviner.Values)
+	for _, param := range params {
+		if !diviner.IsActive(param.Param, child) {
+			continue
+		}
+		v, ok := p.Values[param.Name]
+		if random.Intn(2) == 1 {
+			v, ok = q.Values[param.Name]
+		}
+		if !ok || random.Float64() < rate {
+			v = param.Sample(random)
+		}
+		child[param.Name] = v
+	}
+	return child
+}
+
+func hasObjectives(trial diviner.Trial, objectives []diviner.Objective) bool {
+	for _, obj := range objectives {
+		if v, ok := trial.Metrics[obj.Metric]; !ok || math.IsNaN(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsValues(list []diviner.Values, values diviner.Values) bool {
+	for _, v := range list {
+		if v.Equal(values) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/oracle"
+)
+
+func TestNSGA2(t *testing.T) {
+	// A two-objective problem whose Pareto front is y = 0: x trades
+	// off the objectives, while y worsens both.
+	params := diviner.Params{
+		"x": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+		"y": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+	}
+	objectives := []diviner.Objective{
+		{Direction: diviner.Minimize, Metric: "f1"},
+		{Direction: diviner.Minimize, Metric: "f2"},
+	}
+	evaluate := func(v diviner.Values) diviner.Metrics {
+		x, y := v["x"].Float(), v["y"].Float()
+		g := 1 + 9*y
+		return diviner.Metrics{"f1": x, "f2": g * (1 - math.Sqrt(x/g))}
+	}
+	var (
+		o      = oracle.NewNSGA2(1)
+		trials []diviner.Trial
+	)
+	for round := 0; round < 30; round++ {
+		values, err := o.NextMulti(trials, params, objectives, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(values), 10; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for _, v := range values {
+			if !params.IsValid(v) {
+				t.Fatalf("invalid values %v", v)
+			}
+			for _, trial := range trials {
+				if trial.Values.Equal(v) {
+					t.Fatalf("duplicate trial %v", v)
+				}
+			}
+			trials = append(trials, diviner.Trial{Values: v, Metrics: evaluate(v)})
+		}
+	}
+	front := diviner.ParetoFront(trials, objectives)
+	if len(front) < 10 {
+		t.Fatalf("front too small: %d trials", len(front))
+	}
+	var y float64
+	for _, trial := range front {
+		y += trial.Values["y"].Float()
+	}
+	if y /= float64(len(front)); y > 0.05 {
+		t.Errorf("mean y %v of the Pareto front is too large", y)
+	}
+}
+
+func TestNSGA2Constraints(t *testing.T) {
+	// The objective is minimized at x = 0, but only trials with
+	// x >= 0.5 are feasible.
+	params := diviner.Params{
+		"x": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+		"y": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "f"}
+	var (
+		o        = &oracle.NSGA2{Seed: 1, MutationRate: 0.2}
+		trials   []diviner.Trial
+		feasible int
+	)
+	for round := 0; round < 20; round++ {
+		values, err := o.Next(trials, params, objective, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			x := v["x"].Float()
+			if round >= 10 && x >= 0.5 {
+				feasible++
+			}
+			trials = append(trials, diviner.Trial{Values: v, Metrics: diviner.Metrics{"f": x}, Infeasible: x < 0.5})
+		}
+	}
+	if feasible < 50 {
+		t.Errorf("only %d of the last 100 points are feasible", feasible)
+	}
+}
+
+func TestNSGA2Exhausted(t *testing.T) {
+	params := diviner.Params{
+		"x": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2)),
+	}
+	var (
+		o         = oracle.NewNSGA2(1)
+		objective = diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+		trials    []diviner.Trial
+	)
+	values, err := o.Next(trials, params, objective, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(values), 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
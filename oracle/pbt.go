@@ -0,0 +1,217 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"encoding/gob"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&PBT{})
+}
+
+const (
+	defaultPBTQuantile            = 0.25
+	defaultPBTResampleProbability = 0.25
+)
+
+// pbtFactors are the factors by which PBT perturbs numeric
+// parameter values.
+var pbtFactors = [2]float64{0.8, 1.2}
+
+// PBT is an oracle implementing population based training [1]. A
+// population of Population runs is sampled at random and trained
+// concurrently; PBT then periodically replaces poorly performing runs
+// with mutated copies of better performing ones. PBT implements
+// diviner.Exploiter, through which runners apply these replacements
+// to live runs.
+//
+// Each time a run has reported Interval metrics since it was started
+// (or since it was last considered), PBT ranks the live population by
+// their latest objective values. If the run is in the bottom Quantile
+// of the population, it exploits a run chosen at random from the top
+// Quantile: it continues from that run's checkpoint, with its
+// parameter values explored. Each value is resampled with probability
+// ResampleProbability; otherwise numeric range values are multiplied
+// by 0.8 or 1.2, and values of other parameters are replaced by an
+// adjacent value. Explored values remain valid for their parameters.
+//
+// Runs must be checkpointed (see diviner.Checkpoint) for exploited
+// runs to continue the training of the runs they exploit. In order
+// for the population to be trained concurrently, studies should be
+// run with Population parallel trials, e.g., in a single round of
+// Population trials. Once Population trials have been started, Next
+// returns no further values.
+//
+// [1] Jaderberg et al., "Population Based Training of Neural
+// Networks", https://arxiv.org/abs/1711.09846
+type PBT struct {
+	// Population is the number of runs in the population.
+	Population int
+	// Interval is the number of metrics reports between successive
+	// exploit decisions for each run. Defaults to 1.
+	Interval int
+	// Quantile is the fraction of the population that is exploited
+	// by, and that exploits, the rest. Defaults to 0.25.
+	Quantile float64
+	// ResampleProbability is the probability with which each explored
+	// value is resampled instead of perturbed. Defaults to 0.25.
+	ResampleProbability float64
+	// Seed is the seed of the random number generator used to sample
+	// the population and to explore values.
+	Seed int64
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+var _ diviner.Exploiter = (*PBT)(nil)
+
+// NewPBT returns a new PBT oracle with the provided population size
+// and random seed, and default parameters.
+func NewPBT(population int, seed int64) *PBT {
+	return &PBT{Population: population, Seed: seed}
+}
+
+// Next implements diviner.Oracle. It samples the initial population
+// at random.
+func (p *PBT) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	return (&RandomSearch{N: p.Population, Seed: p.Seed}).Next(previous, params, objective, howmany)
+}
+
+// Exploit implements diviner.Exploiter.
+func (p *PBT) Exploit(objective diviner.Objective, params diviner.Params, run diviner.Member, population []diviner.Member) (diviner.Exploit, bool) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 1
+	}
+	if len(run.History) == 0 || len(run.History)%interval != 0 {
+		return diviner.Exploit{}, false
+	}
+	type ranked struct {
+		member diviner.Member
+		value  float64
+	}
+	var members []ranked
+	for _, member := range population {
+		if value, ok := latest(member.History, objective); ok {
+			members = append(members, ranked{member, value})
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		if objective.Direction == diviner.Maximize {
+			return members[i].value > members[j].value
+		}
+		return members[i].value < members[j].value
+	})
+	quantile := p.Quantile
+	if quantile <= 0 || quantile > 0.5 {
+		quantile = defaultPBTQuantile
+	}
+	n := int(math.Ceil(quantile * float64(len(members))))
+	if 2*n > len(members) {
+		n = len(members) / 2
+	}
+	if n == 0 {
+		return diviner.Exploit{}, false
+	}
+	var bottom bool
+	for _, member := range members[len(members)-n:] {
+		if member.member.ID == run.ID {
+			bottom = true
+		}
+	}
+	if !bottom {
+		return diviner.Exploit{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.random == nil {
+		p.random = rand.New(rand.NewSource(p.Seed))
+	}
+	from := members[p.random.Intn(n)].member
+	resample := p.ResampleProbability
+	if resample <= 0 {
+		resample = defaultPBTResampleProbability
+	}
+	values := make(diviner.Values, len(params))
+	for _, param := range params.Ordered() {
+		if !diviner.IsActive(param.Param, values) {
+			continue
+		}
+		v, ok := from.Values[param.Name]
+		if !ok || p.random.Float64() < resample {
+			values[param.Name] = param.Sample(p.random)
+		} else {
+			values[param.Name] = perturb(param.Param, v, p.random)
+		}
+	}
+	return diviner.Exploit{From: from.ID, Values: values}, true
+}
+
+// Latest returns the latest value of the objective metric in the
+// provided metrics history.
+func latest(history []diviner.MetricsStep, objective diviner.Objective) (float64, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		if v, ok := history[i].Metrics[objective.Metric]; ok && !math.IsNaN(v) {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// Perturb returns a value of the provided parameter near v. Numeric
+// range values are scaled by one of pbtFactors; values of other
+// parameters are replaced by a neighboring value. If no such value
+// is valid, v is returned.
+func perturb(param diviner.Param, v diviner.Value, random *rand.Rand) diviner.Value {
+	switch param.(type) {
+	case *diviner.Range, *diviner.LogRange:
+		factor := pbtFactors[random.Intn(len(pbtFactors))]
+		var scaled diviner.Value
+		switch v.Kind() {
+		case diviner.Integer:
+			n := int64(math.Round(float64(v.Int()) * factor))
+			if n == v.Int() {
+				if factor > 1 {
+					n++
+				} else {
+					n--
+				}
+			}
+			scaled = diviner.Int(n)
+		case diviner.Real:
+			scaled = diviner.Float(v.Float() * factor)
+		default:
+			return v
+		}
+		if param.IsValid(scaled) {
+			return scaled
+		}
+		return v
+	}
+	values := param.Values()
+	for i, w := range values {
+		if !w.Equal(v) {
+			continue
+		}
+		j := i - 1
+		if random.Intn(2) == 1 {
+			j = i + 1
+		}
+		if j < 0 || j >= len(values) {
+			return v
+		}
+		return values[j]
+	}
+	return v
+}
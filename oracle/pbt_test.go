@@ -0,0 +1,136 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oracle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/oracle"
+)
+
+func TestPBTNext(t *testing.T) {
+	params := diviner.Params{
+		"lr":    diviner.NewLogRange(diviner.Float(0.0001), diviner.Float(0.1)),
+		"batch": diviner.NewDiscrete(diviner.Int(32), diviner.Int(64), diviner.Int(128)),
+	}
+	objective := diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+	pbt := oracle.NewPBT(4, 1)
+	values, err := pbt.Next(nil, params, objective, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(values), 4; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var trials []diviner.Trial
+	for _, v := range values {
+		if !params.IsValid(v) {
+			t.Errorf("invalid values %v", v)
+		}
+		trials = append(trials, diviner.Trial{Values: v})
+	}
+	values, err = pbt.Next(trials, params, objective, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(values), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPBTExploit(t *testing.T) {
+	params := diviner.Params{
+		"lr":     diviner.NewRange(diviner.Float(0.001), diviner.Float(1)),
+		"layers": diviner.NewRange(diviner.Int(1), diviner.Int(100)),
+		"batch":  diviner.NewDiscrete(diviner.Int(32), diviner.Int(64), diviner.Int(128)),
+	}
+	objective := diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+	var population []diviner.Member
+	for i := 0; i < 8; i++ {
+		population = append(population, diviner.Member{
+			ID: fmt.Sprint("run", i),
+			Values: diviner.Values{
+				"lr":     diviner.Float(0.5),
+				"layers": diviner.Int(50),
+				"batch":  diviner.Int(64),
+			},
+			History: []diviner.MetricsStep{
+				{Step: 0, Metrics: diviner.Metrics{"acc": 0}},
+				{Step: 1, Metrics: diviner.Metrics{"acc": float64(i)}},
+			},
+		})
+	}
+	pbt := &oracle.PBT{Population: 8, Interval: 2, Seed: 1}
+	for i, member := range population {
+		e, ok := pbt.Exploit(objective, params, member, population)
+		// With a quantile of 0.25, the bottom two runs exploit the
+		// top two.
+		if got, want := ok, i < 2; got != want {
+			t.Errorf("run %d: got %v, want %v", i, got, want)
+		}
+		if !ok {
+			continue
+		}
+		if e.From != "run6" && e.From != "run7" {
+			t.Errorf("run %d exploited %s", i, e.From)
+		}
+		if !params.IsValid(e.Values) {
+			t.Errorf("run %d: invalid values %v", i, e.Values)
+		}
+	}
+
+	// Exploit decisions are made only every Interval reports.
+	member := population[0]
+	member.History = member.History[:1]
+	if _, ok := pbt.Exploit(objective, params, member, population); ok {
+		t.Error("unexpected exploit")
+	}
+}
+
+func TestPBTExplore(t *testing.T) {
+	params := diviner.Params{
+		"lr":     diviner.NewRange(diviner.Float(0.001), diviner.Float(1)),
+		"layers": diviner.NewRange(diviner.Int(1), diviner.Int(4)),
+		"batch":  diviner.NewDiscrete(diviner.Int(32), diviner.Int(64), diviner.Int(128)),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	population := []diviner.Member{
+		{
+			ID:      "best",
+			Values:  diviner.Values{"lr": diviner.Float(0.5), "layers": diviner.Int(3), "batch": diviner.Int(128)},
+			History: []diviner.MetricsStep{{Metrics: diviner.Metrics{"loss": 0.1}}},
+		},
+		{
+			ID:      "worst",
+			Values:  diviner.Values{"lr": diviner.Float(0.1), "layers": diviner.Int(1), "batch": diviner.Int(32)},
+			History: []diviner.MetricsStep{{Metrics: diviner.Metrics{"loss": 1}}},
+		},
+	}
+	pbt := &oracle.PBT{Population: 2, Seed: 3}
+	var perturbed bool
+	for i := 0; i < 100; i++ {
+		e, ok := pbt.Exploit(objective, params, population[1], population)
+		if !ok {
+			t.Fatal("expected exploit")
+		}
+		if got, want := e.From, "best"; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if !params.IsValid(e.Values) {
+			t.Fatalf("invalid values %v", e.Values)
+		}
+		if lr := e.Values["lr"].Float(); lr == 0.4 || lr == 0.6 {
+			perturbed = true
+		}
+	}
+	if !perturbed {
+		t.Error("values were never perturbed")
+	}
+	if _, ok := pbt.Exploit(objective, params, population[0], population); ok {
+		t.Error("best run exploited")
+	}
+}
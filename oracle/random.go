@@ -10,6 +10,7 @@ import (
 
 func init() {
 	gob.Register(&Random{})
+	gob.Register(&RandomSearch{})
 }
 
 // Random is an oracle that returns random points in the search space. This is typically much more
@@ -44,9 +45,55 @@ func (r *Random) Next(previous []diviner.Trial, params diviner.Params, objective
 func (r *Random) nextPoint(params diviner.Params) diviner.Values {
 	random := rand.New(rand.NewSource(r.Seed))
 	r.Seed++
-	result := make(diviner.Values)
-	for _, param := range params.Sorted() {
-		result[param.Name] = param.Sample(random)
+	return params.Sample(random)
+}
+
+// RandomSearch is an oracle that samples a fixed number of trials,
+// N, uniformly at random from the search space. Both discrete and
+// range parameters are supported. It is intended mainly to establish
+// a baseline against which other oracles can be compared.
+//
+// Trials are drawn from a single sequence of samples determined by
+// Seed; the i'th trial of a study is always the i'th sample of this
+// sequence, regardless of how trials are batched. Thus a study run
+// with the same seed reproduces the same trials, and a study that is
+// resumed continues the sequence where it left off.
+type RandomSearch struct {
+	// N is the total number of trials to sample. If N is not positive,
+	// trials are sampled indefinitely.
+	N int
+	// Seed is the seed of the random number generator used to sample
+	// trials.
+	Seed int64
+}
+
+// NewRandomSearch returns a new RandomSearch oracle that samples n
+// trials with the provided random seed.
+func NewRandomSearch(n int, seed int64) *RandomSearch {
+	return &RandomSearch{N: n, Seed: seed}
+}
+
+// Next implements Oracle.Next.
+func (r *RandomSearch) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective,
+	howmany int) ([]diviner.Values, error) {
+	skip := len(previous)
+	if r.N > 0 {
+		if skip >= r.N {
+			return nil, nil
+		}
+		if skip+howmany > r.N {
+			howmany = r.N - skip
+		}
 	}
-	return result
+	var (
+		random = rand.New(rand.NewSource(r.Seed))
+		result = make([]diviner.Values, 0, howmany)
+	)
+	for i := 0; i < skip+howmany; i++ {
+		values := params.Sample(random)
+		if i >= skip {
+			result = append(result, values)
+		}
+	}
+	return result, nil
 }
@@ -42,3 +42,74 @@ func TestRandom(t *testing.T) {
 		}
 	}
 }
+
+func TestRandomSearch(t *testing.T) {
+	params := diviner.Params{
+		"a": diviner.NewDiscrete(diviner.String("x"), diviner.String("y"), diviner.String("z")),
+		"b": diviner.NewRange(diviner.Int(0), diviner.Int(100)),
+		"c": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+	}
+	const N = 10
+	search := NewRandomSearch(N, 123)
+	all, err := search.Next(nil, params, diviner.Objective{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(all), N; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, values := range all {
+		if !params.IsValid(values) {
+			t.Errorf("invalid values %v", values)
+		}
+	}
+	// Sampling in batches reproduces the same sequence.
+	var previous []diviner.Trial
+	for len(previous) < N {
+		values, err := NewRandomSearch(N, 123).Next(previous, params, diviner.Objective{}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range values {
+			if got, want := v, all[len(previous)]; !got.Equal(want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			previous = append(previous, diviner.Trial{Values: v})
+		}
+	}
+	if values, err := search.Next(previous, params, diviner.Objective{}, 1); err != nil {
+		t.Fatal(err)
+	} else if len(values) != 0 {
+		t.Errorf("unexpected values %v after %d trials", values, N)
+	}
+	other, err := NewRandomSearch(N, 124).Next(nil, params, diviner.Objective{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other[0].Equal(all[0]) {
+		t.Error("different seeds produced the same trial")
+	}
+}
+
+func TestRandomSearchConditional(t *testing.T) {
+	params := diviner.Params{
+		"optimizer": diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd")),
+		"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+	}
+	values, err := NewRandomSearch(100, 123).Next(nil, params, diviner.Objective{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sgd int
+	for _, v := range values {
+		if !params.IsValid(v) {
+			t.Errorf("invalid values %v", v)
+		}
+		if _, ok := v["momentum"]; ok {
+			sgd++
+		}
+	}
+	if sgd == 0 || sgd == len(values) {
+		t.Errorf("got %d of %d trials with momentum", sgd, len(values))
+	}
+}
@@ -108,13 +108,38 @@ func (s *Skopt) Next(inputTrials []diviner.Trial, params diviner.Params, objecti
 			default:
 				panic(p)
 			}
+		case *diviner.LogRange:
+			switch p.Kind() {
+			case diviner.Integer:
+				skoptParams[i] = fmt.Sprintf("skopt.space.Integer(%d, %d, prior=\"log-uniform\")", p.Start.Int(), p.End.Int())
+			case diviner.Real:
+				skoptParams[i] = fmt.Sprintf("skopt.space.Real(%g, %g, prior=\"log-uniform\")", p.Start.Float(), p.End.Float())
+			default:
+				panic(p)
+			}
+		case diviner.Ordinal:
+			// Ordinal parameters (quantized ranges and enums) are optimized
+			// over the indices of their values.
+			skoptParams[i] = fmt.Sprintf("skopt.space.Integer(0, %d)", p.Len()-1)
 		case *diviner.Discrete:
 			values := p.Values()
 			categories := make([]string, len(values))
 			for i, v := range values {
 				categories[i] = fmt.Sprintf("%q", v.String())
 			}
-			skoptParams[i] = fmt.Sprintf("skopt.space.Categorical([%s])", strings.Join(categories, ", "))
+			if p.Weights == nil {
+				skoptParams[i] = fmt.Sprintf("skopt.space.Categorical([%s])", strings.Join(categories, ", "))
+				break
+			}
+			var total float64
+			for _, w := range p.Weights {
+				total += w
+			}
+			prior := make([]string, len(p.Weights))
+			for i, w := range p.Weights {
+				prior[i] = fmt.Sprint(w / total)
+			}
+			skoptParams[i] = fmt.Sprintf("skopt.space.Categorical([%s], prior=[%s])", strings.Join(categories, ", "), strings.Join(prior, ", "))
 		default:
 			panic(p)
 		}
@@ -129,9 +154,11 @@ func (s *Skopt) Next(inputTrials []diviner.Trial, params diviner.Params, objecti
 		x := make([]string, len(sortedParams))
 		for i, param := range sortedParams {
 			val := trial.Values[param.Name]
-			switch param.Param.(type) {
-			case *diviner.Range:
+			switch p := param.Param.(type) {
+			case *diviner.Range, *diviner.LogRange:
 				x[i] = val.String()
+			case diviner.Ordinal:
+				x[i] = fmt.Sprint(p.Index(val))
 			case *diviner.Discrete:
 				x[i] = fmt.Sprintf("%q", val)
 			}
@@ -205,6 +232,14 @@ func (s *Skopt) Next(inputTrials []diviner.Trial, params diviner.Params, objecti
 				val diviner.Value
 				str = record[j]
 			)
+			if q, ok := param.Param.(diviner.Ordinal); ok {
+				index, err := strconv.Atoi(str)
+				if err != nil || index < 0 || index >= q.Len() {
+					return nil, fmt.Errorf("invalid index %s for range %s", str, q)
+				}
+				vals[param.Name] = q.Value(index)
+				continue
+			}
 			switch param.Kind() {
 			case diviner.Integer:
 				v64, err := strconv.ParseInt(str, 10, 64)
@@ -224,6 +259,12 @@ func (s *Skopt) Next(inputTrials []diviner.Trial, params diviner.Params, objecti
 					return nil, fmt.Errorf("invalid string value %s: %v", str, err)
 				}
 				val = diviner.String(str)
+			case diviner.Boolean:
+				b, err := strconv.ParseBool(str)
+				if err != nil {
+					return nil, fmt.Errorf("invalid boolean value %s: %v", str, err)
+				}
+				val = diviner.Bool(b)
 			}
 			vals[sortedParams[j].Name] = val
 		}
@@ -27,6 +27,9 @@ func TestSkoptOracle(t *testing.T) {
 		"y":  diviner.NewRange(diviner.Int(-10), diviner.Int(20)),
 		"z":  diviner.NewDiscrete(diviner.String("a"), diviner.String("b")),
 		"zz": diviner.NewRange(diviner.Float(0), diviner.Float(0.5)),
+		"b":  diviner.NewDiscrete(diviner.Bool(false), diviner.Bool(true)),
+		"lr": diviner.NewLogRange(diviner.Float(1e-5), diviner.Float(1e-1)),
+		"q":  diviner.NewQuantizedRange(diviner.Int(16), diviner.Int(129), diviner.Int(16)),
 	}
 	var o oracle.Skopt
 	values, err := o.Next(nil, params, diviner.Objective{diviner.Maximize, "acc"}, 1)
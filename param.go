@@ -6,7 +6,9 @@ package diviner
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"strings"
 
@@ -19,6 +21,11 @@ func init() {
 	// so that these values decode successfully.
 	gob.RegisterName("github.com/grailbio/diviner.Range", &Range{})
 	gob.RegisterName("github.com/grailbio/diviner.Discrete", &Discrete{})
+	gob.Register(&Vector{})
+	gob.Register(&LogRange{})
+	gob.Register(&QuantizedRange{})
+	gob.Register(&Enum{})
+	gob.Register(&Conditional{})
 }
 
 // A Param is a kind of parameter. Params determine the range of
@@ -49,6 +56,12 @@ var _ Param = (*Discrete)(nil)
 type Discrete struct {
 	DiscreteValues []Value
 	DiscreteKind   Kind
+	// Weights, if non-nil, are the relative weights with which each
+	// of the (corresponding) values is sampled, so that random and
+	// Bayesian oracles draw some values more often than others (e.g.,
+	// known-good optimizers). Oracles that enumerate values, such as
+	// grid search, ignore them.
+	Weights []float64
 }
 
 // NewDiscrete returns a new discrete param comprising the
@@ -64,7 +77,40 @@ func NewDiscrete(values ...Value) *Discrete {
 			panic(fmt.Sprintf("diviner.NewDiscrete: mixed kinds: %s and %s", v.Kind(), kind))
 		}
 	}
-	return &Discrete{values, kind}
+	return &Discrete{DiscreteValues: values, DiscreteKind: kind}
+}
+
+// NewWeightedDiscrete returns a new discrete param comprising the
+// given values, which are sampled with the provided relative weights.
+// NewWeightedDiscrete panics under the same conditions as
+// NewDiscrete, or if the weights do not correspond to the values, are
+// negative or not finite, or are all zero.
+func NewWeightedDiscrete(values []Value, weights []float64) *Discrete {
+	d := NewDiscrete(values...)
+	if err := checkWeights(weights, len(values)); err != nil {
+		panic("diviner.NewWeightedDiscrete: " + err.Error())
+	}
+	d.Weights = weights
+	return d
+}
+
+// CheckWeights returns an error if the provided weights are not valid
+// sampling weights for n discrete values.
+func checkWeights(weights []float64, n int) error {
+	if len(weights) != n {
+		return fmt.Errorf("%d weights given for %d values", len(weights), n)
+	}
+	var total float64
+	for _, w := range weights {
+		if w < 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+			return fmt.Errorf("invalid weight %v", w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.New("weights are all zero")
+	}
+	return nil
 }
 
 // String returns a description of this parameter.
@@ -73,7 +119,14 @@ func (d *Discrete) String() string {
 	for i := range vals {
 		vals[i] = d.DiscreteValues[i].String()
 	}
-	return fmt.Sprintf("discrete(%s)", strings.Join(vals, ", "))
+	if d.Weights == nil {
+		return fmt.Sprintf("discrete(%s)", strings.Join(vals, ", "))
+	}
+	weights := make([]string, len(d.Weights))
+	for i, w := range d.Weights {
+		weights[i] = fmt.Sprint(w)
+	}
+	return fmt.Sprintf("discrete(%s, weights=[%s])", strings.Join(vals, ", "), strings.Join(weights, ", "))
 }
 
 // Kind returns the kind of values represented by this discrete param.
@@ -88,8 +141,49 @@ func (d *Discrete) Values() []Value {
 }
 
 // Sample draws a value set of parameter values and returns it.
+// Values are drawn uniformly unless the parameter is weighted.
 func (d *Discrete) Sample(r *rand.Rand) Value {
-	return d.DiscreteValues[r.Intn(len(d.DiscreteValues))]
+	if d.Weights == nil {
+		return d.DiscreteValues[r.Intn(len(d.DiscreteValues))]
+	}
+	return d.Quantile(r.Float64())
+}
+
+// Quantile returns the value at quantile u, in [0, 1), of the
+// parameter's sampling distribution: values occupy consecutive
+// intervals of the unit interval, in order, whose lengths are
+// proportional to their weights (or equal if the parameter is not
+// weighted).
+func (d *Discrete) Quantile(u float64) Value {
+	n := len(d.DiscreteValues)
+	if d.Weights == nil {
+		i := int(u * float64(n))
+		if i >= n {
+			i = n - 1
+		}
+		return d.DiscreteValues[i]
+	}
+	var total float64
+	for _, w := range d.Weights {
+		total += w
+	}
+	var (
+		target = u * total
+		sum    float64
+		last   int
+	)
+	for i, w := range d.Weights {
+		if w == 0 {
+			continue
+		}
+		sum += w
+		last = i
+		if target < sum {
+			return d.DiscreteValues[i]
+		}
+	}
+	// Rounding may leave the target at the total.
+	return d.DiscreteValues[last]
 }
 
 // IsValid tells whether the value v belongs to the set of
@@ -211,3 +305,497 @@ func (*Range) Truth() starlark.Bool { return true }
 
 // Hash implements starlark.Value.
 func (*Range) Hash() (uint32, error) { return 0, errNotHashable }
+
+var _ Param = (*LogRange)(nil)
+
+// LogRange is a parameter defined over a range of positive numbers
+// whose values are sampled uniformly in log space. This is the
+// appropriate scaling for parameters, like learning rates, whose
+// magnitude matters more than their value.
+type LogRange struct {
+	Start, End Value
+}
+
+// NewLogRange returns a log-scaled range parameter representing
+// the range of values [start, end). NewLogRange panics if start is
+// not positive.
+func NewLogRange(start, end Value) *LogRange {
+	r := NewRange(start, end)
+	if (start.Kind() == Integer && start.Int() <= 0) || (start.Kind() == Real && start.Float() <= 0) {
+		panic("log range must be positive")
+	}
+	return &LogRange{Start: r.Start, End: r.End}
+}
+
+// String returns a description of this log range parameter.
+func (r *LogRange) String() string {
+	return fmt.Sprintf("log_range(%s, %s)", r.Start, r.End)
+}
+
+// Kind returns the kind of the range's values.
+func (r *LogRange) Kind() Kind { return r.Start.Kind() }
+
+// Values returns nil for real ranges, and the set of values in an
+// integer range.
+func (r *LogRange) Values() []Value {
+	return (*Range)(r).Values()
+}
+
+// Sample draws a random sample from within the range, uniformly in
+// log space.
+func (r *LogRange) Sample(rnd *rand.Rand) Value {
+	switch r.Kind() {
+	case Integer:
+		start, end := r.Start.Int(), r.End.Int()
+		v := int64(math.Exp(r.sample(rnd, float64(start), float64(end))))
+		if v < start {
+			v = start
+		} else if v >= end {
+			v = end - 1
+		}
+		return Int(v)
+	case Real:
+		return Float(math.Exp(r.sample(rnd, r.Start.Float(), r.End.Float())))
+	default:
+		panic(r)
+	}
+}
+
+func (r *LogRange) sample(rnd *rand.Rand, start, end float64) float64 {
+	lo, hi := math.Log(start), math.Log(end)
+	return lo + rnd.Float64()*(hi-lo)
+}
+
+// IsValid tells whether the value v is inside the range r.
+func (r *LogRange) IsValid(v Value) bool {
+	return (*Range)(r).IsValid(v)
+}
+
+// Type implements starlark.Value.
+func (*LogRange) Type() string { return "log_range" }
+
+// Freeze implements starlark.Value.
+func (*LogRange) Freeze() {}
+
+// Truth implements starlark.Value.
+func (*LogRange) Truth() starlark.Bool { return true }
+
+// Hash implements starlark.Value.
+func (*LogRange) Hash() (uint32, error) { return 0, errNotHashable }
+
+var _ Param = (*QuantizedRange)(nil)
+
+// QuantizedRange is a parameter that takes on values in the range
+// [Start, End) at multiples of Step from Start. Unlike a discrete
+// parameter, a quantized range's values are ordered, which lets
+// oracles model them as numeric.
+type QuantizedRange struct {
+	Start, End, Step Value
+}
+
+// NewQuantizedRange returns a quantized range parameter representing
+// the values start, start+step, start+2*step, ... in [start, end).
+// NewQuantizedRange panics if step is not positive.
+func NewQuantizedRange(start, end, step Value) *QuantizedRange {
+	r := NewRange(start, end)
+	if step.Kind() != start.Kind() {
+		panic("mismatched kinds in range")
+	}
+	if (step.Kind() == Integer && step.Int() <= 0) || (step.Kind() == Real && step.Float() <= 0) {
+		panic("range step must be positive")
+	}
+	return &QuantizedRange{Start: r.Start, End: r.End, Step: step}
+}
+
+// String returns a description of this quantized range parameter.
+func (r *QuantizedRange) String() string {
+	return fmt.Sprintf("range(%s, %s, %s)", r.Start, r.End, r.Step)
+}
+
+// Kind returns the kind of the range's values.
+func (r *QuantizedRange) Kind() Kind { return r.Start.Kind() }
+
+// Len returns the number of values in the range.
+func (r *QuantizedRange) Len() int {
+	switch r.Kind() {
+	case Integer:
+		n := r.End.Int() - r.Start.Int()
+		return int((n + r.Step.Int() - 1) / r.Step.Int())
+	case Real:
+		n := (r.End.Float() - r.Start.Float()) / r.Step.Float()
+		// Allow for rounding errors so that end is excluded.
+		return int(math.Ceil(n - quantizeEpsilon))
+	default:
+		panic(r)
+	}
+}
+
+// quantizeEpsilon is the tolerance, relative to the step, with which
+// real values are matched to a quantized range.
+const quantizeEpsilon = 1e-9
+
+// Value returns the ith value of the range.
+func (r *QuantizedRange) Value(i int) Value {
+	switch r.Kind() {
+	case Integer:
+		return Int(r.Start.Int() + int64(i)*r.Step.Int())
+	case Real:
+		return Float(r.Start.Float() + float64(i)*r.Step.Float())
+	default:
+		panic(r)
+	}
+}
+
+// Index returns the index of value v in the range, or -1 if v is
+// not a value of the range.
+func (r *QuantizedRange) Index(v Value) int {
+	if v.Kind() != r.Kind() {
+		return -1
+	}
+	var i int
+	switch r.Kind() {
+	case Integer:
+		d := v.Int() - r.Start.Int()
+		if d < 0 || d%r.Step.Int() != 0 {
+			return -1
+		}
+		i = int(d / r.Step.Int())
+	case Real:
+		f := (v.Float() - r.Start.Float()) / r.Step.Float()
+		i = int(math.Round(f))
+		if math.Abs(f-float64(i)) > quantizeEpsilon {
+			return -1
+		}
+	default:
+		panic(r)
+	}
+	if i < 0 || i >= r.Len() {
+		return -1
+	}
+	return i
+}
+
+// Values returns the values of the range in order.
+func (r *QuantizedRange) Values() []Value {
+	vs := make([]Value, r.Len())
+	for i := range vs {
+		vs[i] = r.Value(i)
+	}
+	return vs
+}
+
+// Sample draws a value uniformly from the range's values.
+func (r *QuantizedRange) Sample(rnd *rand.Rand) Value {
+	return r.Value(rnd.Intn(r.Len()))
+}
+
+// IsValid tells whether the value v is a value of the range.
+func (r *QuantizedRange) IsValid(v Value) bool {
+	return r.Index(v) >= 0
+}
+
+// Type implements starlark.Value.
+func (*QuantizedRange) Type() string { return "quantized_range" }
+
+// Freeze implements starlark.Value.
+func (*QuantizedRange) Freeze() {}
+
+// Truth implements starlark.Value.
+func (*QuantizedRange) Truth() starlark.Bool { return true }
+
+// Hash implements starlark.Value.
+func (*QuantizedRange) Hash() (uint32, error) { return 0, errNotHashable }
+
+// An Ordinal is a parameter whose values form a finite, ordered
+// sequence, so that oracles may model its values by their indices in
+// the sequence. Quantized ranges and enums are ordinal.
+type Ordinal interface {
+	Param
+
+	// Len returns the number of values of the parameter.
+	Len() int
+
+	// Value returns the ith value of the parameter.
+	Value(i int) Value
+
+	// Index returns the index of value v, or -1 if v is not a value of
+	// the parameter.
+	Index(v Value) int
+}
+
+var (
+	_ Ordinal = (*QuantizedRange)(nil)
+	_ Ordinal = (*Enum)(nil)
+)
+
+// An Enum is a parameter that takes on one of an ordered set of
+// named (string) values, for example:
+//
+//	enum("small", "medium", "large")
+//
+// Unlike a discrete parameter of strings, whose values are unordered
+// categories, an enum's values are ordinal: oracles that model
+// parameter values (e.g., gp and cmaes) model enum values by their
+// positions, so that "medium" lies between "small" and "large".
+type Enum struct {
+	Names []string
+}
+
+// NewEnum returns a new enum parameter comprising the provided names,
+// in order. NewEnum panics if no names are provided, or if a name is
+// repeated.
+func NewEnum(names ...string) *Enum {
+	if len(names) == 0 {
+		panic("diviner.NewEnum: no names passed")
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			panic(fmt.Sprintf("diviner.NewEnum: duplicate name %s", name))
+		}
+		seen[name] = true
+	}
+	return &Enum{names}
+}
+
+// String returns a description of this parameter.
+func (e *Enum) String() string {
+	return fmt.Sprintf("enum(%s)", strings.Join(e.Names, ", "))
+}
+
+// Kind returns Str.
+func (*Enum) Kind() Kind { return Str }
+
+// Len returns the number of names in the enum.
+func (e *Enum) Len() int { return len(e.Names) }
+
+// Value returns the ith name of the enum.
+func (e *Enum) Value(i int) Value { return String(e.Names[i]) }
+
+// Index returns the position of value v in the enum, or -1 if v is
+// not one of its names.
+func (e *Enum) Index(v Value) int {
+	if v.Kind() != Str {
+		return -1
+	}
+	for i, name := range e.Names {
+		if name == v.Str() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Values returns the names of the enum in order.
+func (e *Enum) Values() []Value {
+	vs := make([]Value, len(e.Names))
+	for i := range vs {
+		vs[i] = e.Value(i)
+	}
+	return vs
+}
+
+// Sample draws a name uniformly from the enum.
+func (e *Enum) Sample(r *rand.Rand) Value {
+	return e.Value(r.Intn(len(e.Names)))
+}
+
+// IsValid tells whether the value v is one of the enum's names.
+func (e *Enum) IsValid(v Value) bool {
+	return e.Index(v) >= 0
+}
+
+// Type implements starlark.Value.
+func (*Enum) Type() string { return "enum" }
+
+// Freeze implements starlark.Value.
+func (*Enum) Freeze() {}
+
+// Truth implements starlark.Value.
+func (*Enum) Truth() starlark.Bool { return true }
+
+// Hash implements starlark.Value.
+func (*Enum) Hash() (uint32, error) { return 0, errNotHashable }
+
+var _ Param = (*Vector)(nil)
+
+// A Vector is a parameter whose values are lists, the elements of
+// which are drawn from the vector's element parameters. For example,
+// a vector of three discrete parameters encodes the widths of each
+// layer of a three-layer network. The values of a vector comprise
+// the Cartesian product of its elements' values.
+type Vector struct {
+	Elems []Param
+}
+
+// NewVector returns a new vector parameter with the provided
+// elements. NewVector panics if no elements are passed.
+func NewVector(elems ...Param) *Vector {
+	if len(elems) == 0 {
+		panic("diviner.NewVector: no elements passed")
+	}
+	return &Vector{elems}
+}
+
+// String returns a description of this vector parameter.
+func (v *Vector) String() string {
+	elems := make([]string, len(v.Elems))
+	for i := range elems {
+		elems[i] = v.Elems[i].String()
+	}
+	return fmt.Sprintf("vector(%s)", strings.Join(elems, ", "))
+}
+
+// Kind returns Seq.
+func (*Vector) Kind() Kind { return Seq }
+
+// Values returns the Cartesian product of the vector's element
+// values, or nil if any of its elements are not finite.
+func (v *Vector) Values() []Value {
+	n := 1
+	elems := make([][]Value, len(v.Elems))
+	for i, p := range v.Elems {
+		elems[i] = p.Values()
+		if len(elems[i]) == 0 {
+			return nil
+		}
+		n *= len(elems[i])
+	}
+	// Lay out the product so that the last element varies fastest.
+	vs := make([]Value, n)
+	for i := range vs {
+		var (
+			l = make(List, len(elems))
+			k = i
+		)
+		for j := len(elems) - 1; j >= 0; j-- {
+			l[j] = elems[j][k%len(elems[j])]
+			k /= len(elems[j])
+		}
+		vs[i] = l
+	}
+	return vs
+}
+
+// Sample draws a list of values, one sampled from each of the
+// vector's elements.
+func (v *Vector) Sample(r *rand.Rand) Value {
+	l := make(List, len(v.Elems))
+	for i, p := range v.Elems {
+		l[i] = p.Sample(r)
+	}
+	return l
+}
+
+// IsValid tells whether the value w is a list whose elements
+// are valid for the corresponding elements of the vector.
+func (v *Vector) IsValid(w Value) bool {
+	if w.Kind() != Seq || w.Len() != len(v.Elems) {
+		return false
+	}
+	for i, p := range v.Elems {
+		if !p.IsValid(w.Index(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Type implements starlark.Value.
+func (*Vector) Type() string { return "vector" }
+
+// Freeze implements starlark.Value.
+func (*Vector) Freeze() {}
+
+// Truth implements starlark.Value.
+func (*Vector) Truth() starlark.Bool { return true }
+
+// Hash implements starlark.Value.
+func (*Vector) Hash() (uint32, error) { return 0, errNotHashable }
+
+var _ Param = (*Conditional)(nil)
+
+// A Conditional is a parameter that exists only when another
+// parameter, named by On, takes on one of the values In. For example,
+// a "momentum" parameter may be defined only when the "optimizer"
+// parameter is "sgd". Trials omit the values of conditional
+// parameters that are inactive; see Params.Sample and Params.IsValid.
+//
+// Conditional parameters may depend on other conditional
+// parameters, forming a hierarchy of parameters.
+type Conditional struct {
+	// Param is the parameter that is defined when the condition holds.
+	Param Param
+	// On is the name of the parameter on which the condition depends.
+	On string
+	// In are the values of parameter On for which the condition holds.
+	In []Value
+}
+
+// NewConditional returns a new conditional parameter that takes on
+// the values of param when the parameter named on takes on one of the
+// provided values. NewConditional panics if no values are passed.
+func NewConditional(param Param, on string, values ...Value) *Conditional {
+	if len(values) == 0 {
+		panic("diviner.NewConditional: no values passed")
+	}
+	return &Conditional{Param: param, On: on, In: values}
+}
+
+// String returns a description of this conditional parameter.
+func (c *Conditional) String() string {
+	vals := make([]string, len(c.In))
+	for i := range vals {
+		vals[i] = c.In[i].String()
+	}
+	return fmt.Sprintf("conditional(%s, %q, %s)", c.Param, c.On, strings.Join(vals, ", "))
+}
+
+// Kind returns the kind of the underlying parameter.
+func (c *Conditional) Kind() Kind { return c.Param.Kind() }
+
+// Values returns the values of the underlying parameter.
+func (c *Conditional) Values() []Value { return c.Param.Values() }
+
+// Sample draws a value from the underlying parameter.
+func (c *Conditional) Sample(r *rand.Rand) Value { return c.Param.Sample(r) }
+
+// IsValid tells whether the value v is valid for the underlying
+// parameter.
+func (c *Conditional) IsValid(v Value) bool { return c.Param.IsValid(v) }
+
+// Active tells whether the condition holds for the provided
+// parameter values, i.e., whether parameter On has one of the values
+// In.
+func (c *Conditional) Active(values Values) bool {
+	v, ok := values[c.On]
+	if !ok {
+		return false
+	}
+	for _, w := range c.In {
+		if v.Equal(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Type implements starlark.Value.
+func (*Conditional) Type() string { return "conditional" }
+
+// Freeze implements starlark.Value.
+func (*Conditional) Freeze() {}
+
+// Truth implements starlark.Value.
+func (*Conditional) Truth() starlark.Bool { return true }
+
+// Hash implements starlark.Value.
+func (*Conditional) Hash() (uint32, error) { return 0, errNotHashable }
+
+// IsActive tells whether the provided parameter is defined for the
+// provided values: unconditional parameters are always active;
+// conditional parameters are active when their conditions hold.
+func IsActive(param Param, values Values) bool {
+	c, ok := param.(*Conditional)
+	return !ok || c.Active(values)
+}
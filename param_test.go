@@ -7,6 +7,7 @@ package diviner_test
 import (
 	"math"
 	"math/rand"
+	"strings"
 	"testing"
 
 	"github.com/grailbio/diviner"
@@ -27,6 +28,55 @@ func TestDiscrete(t *testing.T) {
 	}
 }
 
+func TestWeightedDiscrete(t *testing.T) {
+	d := diviner.NewWeightedDiscrete(
+		[]diviner.Value{diviner.String("adam"), diviner.String("sgd"), diviner.String("rmsprop")},
+		[]float64{3, 1, 0},
+	)
+	if got, want := d.String(), "discrete(adam, sgd, rmsprop, weights=[3, 1, 0])"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	rng := rand.New(rand.NewSource(0))
+	counts := make(map[string]int)
+	const N = 4000
+	for i := 0; i < N; i++ {
+		counts[d.Sample(rng).Str()]++
+	}
+	if got, want := counts["rmsprop"], 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if frac := float64(counts["adam"]) / N; math.Abs(frac-0.75) > 0.03 {
+		t.Errorf("adam sampled with frequency %v, want 0.75", frac)
+	}
+	for _, test := range []struct {
+		u    float64
+		want string
+	}{
+		{0, "adam"},
+		{0.74, "adam"},
+		{0.75, "sgd"},
+		{1, "sgd"},
+	} {
+		if got, want := d.Quantile(test.u).Str(), test.want; got != want {
+			t.Errorf("%v: got %v, want %v", test.u, got, want)
+		}
+	}
+	// Weights do not affect the parameter's values.
+	if got, want := len(d.Values()), 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, weights := range [][]float64{{1}, {1, -1, 1}, {0, 0, 0}, {1, math.NaN(), 1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected panic", weights)
+				}
+			}()
+			diviner.NewWeightedDiscrete(d.Values(), weights)
+		}()
+	}
+}
+
 func TestRange(t *testing.T) {
 	const (
 		beg = 0.2
@@ -64,6 +114,210 @@ func TestRange(t *testing.T) {
 	}
 }
 
+func TestLogRange(t *testing.T) {
+	const N = 10000
+	var (
+		rng   = rand.New(rand.NewSource(0))
+		r     = diviner.NewLogRange(diviner.Float(1e-5), diviner.Float(1e-1))
+		small int
+	)
+	for i := 0; i < N; i++ {
+		v := r.Sample(rng)
+		if !r.IsValid(v) {
+			t.Fatalf("invalid value %v", v)
+		}
+		if v.Float() < 1e-3 {
+			small++
+		}
+	}
+	// Half of the samples should be in the lower two decades.
+	if small < N*45/100 || small > N*55/100 {
+		t.Errorf("%d of %d samples below 1e-3", small, N)
+	}
+	ir := diviner.NewLogRange(diviner.Int(1), diviner.Int(1000))
+	for i := 0; i < N; i++ {
+		if v := ir.Sample(rng); !ir.IsValid(v) {
+			t.Fatalf("invalid value %v", v)
+		}
+	}
+	if got, want := len(ir.Values()), 999; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestQuantizedRange(t *testing.T) {
+	r := diviner.NewQuantizedRange(diviner.Float(0), diviner.Float(0.5), diviner.Float(0.1))
+	if got, want := r.Len(), 5; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, v := range r.Values() {
+		if got, want := r.Index(v), i; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	for _, v := range []diviner.Value{diviner.Float(0.3), diviner.Float(0.1 + 0.2)} {
+		if !r.IsValid(v) {
+			t.Errorf("%v should be valid", v)
+		}
+	}
+	for _, v := range []diviner.Value{diviner.Float(0.25), diviner.Float(0.5), diviner.Float(-0.1), diviner.Int(0)} {
+		if r.IsValid(v) {
+			t.Errorf("%v should not be valid", v)
+		}
+	}
+	ir := diviner.NewQuantizedRange(diviner.Int(16), diviner.Int(129), diviner.Int(16))
+	if got, want := ir.Len(), 8; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		v := ir.Sample(rng)
+		if !ir.IsValid(v) || v.Int()%16 != 0 {
+			t.Fatalf("invalid value %v", v)
+		}
+	}
+}
+
+func TestEnum(t *testing.T) {
+	e := diviner.NewEnum("small", "medium", "large")
+	if got, want := e.String(), "enum(small, medium, large)"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := e.Kind(), diviner.Str; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i, v := range e.Values() {
+		if got, want := e.Index(v), i; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := e.Value(i), v; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	for _, v := range []diviner.Value{diviner.String("huge"), diviner.Int(0)} {
+		if e.IsValid(v) || e.Index(v) != -1 {
+			t.Errorf("%v should not be valid", v)
+		}
+	}
+	var param diviner.Param = e
+	if _, ok := param.(diviner.Ordinal); !ok {
+		t.Error("enum is not ordinal")
+	}
+	if _, ok := diviner.Param(diviner.NewDiscrete(diviner.String("x"))).(diviner.Ordinal); ok {
+		t.Error("discrete is ordinal")
+	}
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 100; i++ {
+		if v := e.Sample(rng); !e.IsValid(v) {
+			t.Fatalf("invalid value %v", v)
+		}
+	}
+}
+
+func TestVector(t *testing.T) {
+	v := diviner.NewVector(
+		diviner.NewDiscrete(diviner.Int(32), diviner.Int(64)),
+		diviner.NewRange(diviner.Int(0), diviner.Int(3)),
+	)
+	if got, want := v.Kind(), diviner.Seq; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	values := v.Values()
+	if got, want := len(values), 6; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := values[4], (diviner.List{diviner.Int(64), diviner.Int(1)}); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 100; i++ {
+		if val := v.Sample(rng); !v.IsValid(val) {
+			t.Errorf("invalid value %v", val)
+		}
+	}
+	for _, val := range []diviner.Value{
+		diviner.List{diviner.Int(32)},
+		diviner.List{diviner.Int(16), diviner.Int(0)},
+		diviner.List{diviner.Int(32), diviner.Int(3)},
+		diviner.Int(32),
+	} {
+		if v.IsValid(val) {
+			t.Errorf("%v should not be valid", val)
+		}
+	}
+	r := diviner.NewVector(diviner.NewRange(diviner.Float(0), diviner.Float(1)))
+	if r.Values() != nil {
+		t.Error("expected infinite vector")
+	}
+}
+
+func TestConditional(t *testing.T) {
+	params := diviner.Params{
+		// Named so that the conditional parameters sort before the
+		// parameters on which they depend.
+		"a_nesterov": diviner.NewConditional(diviner.NewDiscrete(diviner.Bool(true), diviner.Bool(false)), "b_momentum", diviner.Float(0.9)),
+		"b_momentum": diviner.NewConditional(diviner.NewDiscrete(diviner.Float(0.5), diviner.Float(0.9)), "optimizer", diviner.String("sgd")),
+		"optimizer":  diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+	}
+	if err := params.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, param := range params.Ordered() {
+		names = append(names, param.Name)
+	}
+	if got, want := strings.Join(names, ","), "optimizer,b_momentum,a_nesterov"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	rng := rand.New(rand.NewSource(0))
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		values := params.Sample(rng)
+		if !params.IsValid(values) {
+			t.Fatalf("invalid values %v", values)
+		}
+		counts[len(values)]++
+	}
+	for n := 1; n <= 3; n++ {
+		if counts[n] == 0 {
+			t.Errorf("no samples with %d values", n)
+		}
+	}
+
+	for _, test := range []struct {
+		params diviner.Params
+		err    string
+	}{
+		{
+			diviner.Params{"a": diviner.NewConditional(diviner.NewRange(diviner.Int(0), diviner.Int(10)), "b", diviner.Int(1))},
+			"parameter a depends on nonexistent parameter b",
+		},
+		{
+			diviner.Params{
+				"a": diviner.NewConditional(diviner.NewRange(diviner.Int(0), diviner.Int(10)), "b", diviner.Int(1)),
+				"b": diviner.NewRange(diviner.Int(2), diviner.Int(10)),
+			},
+			"parameter a: value 1 is not valid for parameter b range(2, 10)",
+		},
+		{
+			diviner.Params{
+				"a": diviner.NewConditional(diviner.NewRange(diviner.Int(0), diviner.Int(10)), "b", diviner.Int(1)),
+				"b": diviner.NewConditional(diviner.NewRange(diviner.Int(0), diviner.Int(10)), "a", diviner.Int(1)),
+			},
+			"parameter a has cyclic conditions",
+		},
+	} {
+		err := test.params.Validate()
+		if err == nil {
+			t.Errorf("%v: expected error", test.params)
+			continue
+		}
+		if got, want := err.Error(), test.err; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
 func TestIsValid(t *testing.T) {
 	tests := []struct {
 		params diviner.Params
@@ -132,6 +386,22 @@ func TestIsValid(t *testing.T) {
 		},
 	}
 
+	conditional := diviner.Params{
+		"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+		"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+	}
+	tests = append(tests, []struct {
+		params diviner.Params
+		values diviner.Values
+		result bool
+	}{
+		{conditional, diviner.Values{"optimizer": diviner.String("adam")}, true},
+		{conditional, diviner.Values{"optimizer": diviner.String("adam"), "momentum": diviner.Float(0.9)}, false},
+		{conditional, diviner.Values{"optimizer": diviner.String("sgd"), "momentum": diviner.Float(0.9)}, true},
+		{conditional, diviner.Values{"optimizer": diviner.String("sgd")}, false},
+		{conditional, diviner.Values{"optimizer": diviner.String("sgd"), "momentum": diviner.Float(1.5)}, false},
+	}...)
+
 	for _, test := range tests {
 		if test.params.IsValid(test.values) != test.result {
 			t.Errorf("Wrong result for %v, %v: want %v", test.params, test.values, test.result)
@@ -0,0 +1,67 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+// Dominates tells whether trial a Pareto-dominates trial b with
+// respect to the provided objectives: that is, whether a is at least
+// as good as b in every objective, and strictly better in at least
+// one. Trials missing any of the objectives' metrics neither
+// dominate nor are dominated.
+func Dominates(a, b Trial, objectives []Objective) bool {
+	var better bool
+	for _, obj := range objectives {
+		x, ok := a.Metrics[obj.Metric]
+		if !ok {
+			return false
+		}
+		y, ok := b.Metrics[obj.Metric]
+		if !ok {
+			return false
+		}
+		if obj.Direction == Minimize {
+			x, y = -x, -y
+		}
+		switch {
+		case x < y:
+			return false
+		case x > y:
+			better = true
+		}
+	}
+	return better
+}
+
+// ParetoFront returns the Pareto front of the provided trials with
+// respect to the provided objectives: the trials that are not
+// dominated by any other trial. Trials missing any of the
+// objectives' metrics are excluded. The front is returned in the
+// order of the provided trials.
+func ParetoFront(trials []Trial, objectives []Objective) []Trial {
+	var front []Trial
+outer:
+	for i, t := range trials {
+		if !hasMetrics(t, objectives) {
+			continue
+		}
+		for j, u := range trials {
+			if i != j && Dominates(u, t, objectives) {
+				continue outer
+			}
+		}
+		front = append(front, t)
+	}
+	return front
+}
+
+// hasMetrics tells whether the trial t has metrics for each of the
+// provided objectives.
+func hasMetrics(t Trial, objectives []Objective) bool {
+	for _, obj := range objectives {
+		if _, ok := t.Metrics[obj.Metric]; !ok {
+			return false
+		}
+	}
+	return true
+}
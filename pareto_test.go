@@ -0,0 +1,48 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestParetoFront(t *testing.T) {
+	objectives := []diviner.Objective{
+		{Direction: diviner.Maximize, Metric: "acc"},
+		{Direction: diviner.Minimize, Metric: "latency"},
+	}
+	trial := func(id int64, metrics diviner.Metrics) diviner.Trial {
+		return diviner.Trial{Values: diviner.Values{"id": diviner.Int(id)}, Metrics: metrics}
+	}
+	trials := []diviner.Trial{
+		trial(0, diviner.Metrics{"acc": 0.9, "latency": 100}),
+		trial(1, diviner.Metrics{"acc": 0.8, "latency": 50}),
+		trial(2, diviner.Metrics{"acc": 0.8, "latency": 60}), // dominated by 1
+		trial(3, diviner.Metrics{"acc": 0.95, "latency": 200}),
+		trial(4, diviner.Metrics{"acc": 0.7, "latency": 100}), // dominated by 0, 1
+		trial(5, diviner.Metrics{"acc": 0.99}),                // missing latency
+		trial(6, diviner.Metrics{"acc": 0.9, "latency": 100}), // equal to 0
+	}
+	if !diviner.Dominates(trials[1], trials[2], objectives) {
+		t.Error("expected 1 to dominate 2")
+	}
+	if diviner.Dominates(trials[0], trials[6], objectives) {
+		t.Error("equal trials should not dominate each other")
+	}
+	if diviner.Dominates(trials[5], trials[4], objectives) {
+		t.Error("trials missing metrics should not dominate")
+	}
+	front := diviner.ParetoFront(trials, objectives)
+	var ids []int64
+	for _, trial := range front {
+		ids = append(ids, trial.Values["id"].Int())
+	}
+	if got, want := fmt.Sprint(ids), "[0 1 3 6]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
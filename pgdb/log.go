@@ -0,0 +1,108 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package pgdb
+
+import (
+	"database/sql"
+	"io"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/logchunk"
+)
+
+type runWriter struct {
+	db    *sql.DB
+	study string
+	seq   uint64
+}
+
+// Logger implements diviner.Database. Writes are coalesced into
+// chunks as configured by the database's LogOptions.
+func (d *DB) Logger(study string, seq uint64) io.WriteCloser {
+	return logchunk.NewWriter(runWriter{d.db, study, seq}.put, d.LogOptions)
+}
+
+func (w runWriter) put(chunk []byte) error {
+	_, err := w.db.Exec(
+		`INSERT INTO diviner_logs (study, seq, created, data) VALUES ($1, $2, $3, $4)`,
+		w.study, w.seq, time.Now(), chunk)
+	return err
+}
+
+type runReader struct {
+	db     *sql.DB
+	study  string
+	seq    uint64
+	since  time.Time
+	follow bool
+	// last is the ID of the last log entry read.
+	last int64
+	// final is set when the reader has observed the run's
+	// completion.
+	final bool
+	buf   []byte
+}
+
+// Log implements diviner.Database. Followed logs end when the run
+// completes.
+func (d *DB) Log(study string, seq uint64, since time.Time, follow bool) io.Reader {
+	return &runReader{db: d.db, study: study, seq: seq, since: since, follow: follow}
+}
+
+func (r *runReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		err = r.db.QueryRow(
+			`SELECT id, data FROM diviner_logs WHERE study = $1 AND seq = $2 AND id > $3 AND created >= $4
+			ORDER BY id LIMIT 1`,
+			r.study, r.seq, r.last, r.since).Scan(&r.last, &r.buf)
+		if err == nil {
+			r.buf, err = logchunk.Decode(r.buf)
+		}
+		if err == sql.ErrNoRows {
+			var done bool
+			if done, err = r.done(); err != nil {
+				return
+			}
+			if r.follow && !done {
+				time.Sleep(followInterval)
+				continue
+			}
+			if r.follow && !r.final {
+				// The run completed since we last looked for logs. Since
+				// the runner closes its logger before updating the run's
+				// final state, one more look finds any remaining entries.
+				r.final = true
+				continue
+			}
+			err = io.EOF
+		}
+		if err != nil {
+			return
+		}
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return
+}
+
+// done tells whether the reader's run has completed, either because
+// it is no longer pending, or because its keepalive has expired. It
+// returns diviner.ErrNotExist if the run does not exist.
+func (r *runReader) done() (bool, error) {
+	var (
+		state   diviner.RunState
+		updated time.Time
+	)
+	err := r.db.QueryRow(
+		`SELECT state, updated FROM diviner_runs WHERE study = $1 AND seq = $2`,
+		r.study, r.seq).Scan(&state, &updated)
+	if err == sql.ErrNoRows {
+		return false, diviner.ErrNotExist
+	} else if err != nil {
+		return false, err
+	}
+	return state != diviner.Pending || time.Since(updated) > 2*keepaliveInterval, nil
+}
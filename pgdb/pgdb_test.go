@@ -0,0 +1,345 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package pgdb_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/pgdb"
+)
+
+// open opens the test database given by the environment variable
+// DIVINER_POSTGRES_TEST_DSN, skipping the test if it is not set.
+func open(t *testing.T) *pgdb.DB {
+	t.Helper()
+	dsn := os.Getenv("DIVINER_POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("DIVINER_POSTGRES_TEST_DSN not set")
+	}
+	db, err := pgdb.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDB(t *testing.T) {
+	db := open(t)
+	defer db.Close()
+	ctx := context.Background()
+	// Tests may be run against a shared database, so we use a unique
+	// study name.
+	name := fmt.Sprintf("pgdb_test_%d", time.Now().UnixNano())
+	study := diviner.Study{
+		Name: name,
+		Params: diviner.Params{
+			"learning_rate": diviner.NewRange(diviner.Float(0.1), diviner.Float(1.0)),
+		},
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+	}
+	for _, want := range []bool{true, false} {
+		created, err := db.CreateStudyIfNotExist(ctx, study)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := created; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	studies, err := db.ListStudies(ctx, name, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := studies, []diviner.Study{study}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.LookupStudy(ctx, name+"x"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+
+	values := diviner.Values{"learning_rate": diviner.Float(0.5)}
+	source := &diviner.Source{Commit: "0123456789abcdef", Dirty: true, Diff: "+x\n", StudyDigest: "fedcba"}
+	inserted, err := db.InsertRun(ctx, diviner.Run{Study: name, Values: values, Source: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inserted.Seq, uint64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, acc := range []float64{0.5, 0.67} {
+		if err := db.AppendRunMetrics(ctx, name, inserted.Seq, diviner.Metrics{"acc": acc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := db.Logger(name, inserted.Seq)
+	if _, err := w.Write([]byte("hello, world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, name, inserted.Seq, diviner.Success, "ok", time.Minute, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := db.ListRuns(ctx, name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if scanned, err := diviner.ScanAll(db.Scan(ctx, name, diviner.Success)); err != nil {
+		t.Fatal(err)
+	} else if got, want := scanned, runs; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run := runs[0]
+	if got, want := run.Values, values; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Metrics, []diviner.Metrics{{"acc": 0.5}, {"acc": 0.67}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Status, "ok"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Source, source; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Runtime, time.Minute; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if run.Started.IsZero() || run.Completed.IsZero() {
+		t.Errorf("missing run times: %v, %v", run.Started, run.Completed)
+	}
+	if err := db.SetRunLabels(ctx, name, inserted.Seq, diviner.Labels{"baseline": "", "gpu": "a100"}); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := db.LookupRun(ctx, name, inserted.Seq); err != nil {
+		t.Fatal(err)
+	} else if got, want := run.Labels.String(), "baseline,gpu=a100"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := db.SetRunLabels(ctx, name, inserted.Seq+1, nil), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	artifacts := []diviner.Artifact{{Name: "model", URL: "s3://bucket/model"}, {Name: "plot", Data: []byte("png")}}
+	if err := db.SetRunArtifacts(ctx, name, inserted.Seq, artifacts); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := db.LookupRun(ctx, name, inserted.Seq); err != nil {
+		t.Fatal(err)
+	} else if got, want := run.Artifacts, artifacts; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	exit := diviner.RunExit{Code: -1, Signal: "killed", Reason: "exceeded timeout of 1h0m0s"}
+	if err := db.SetRunExit(ctx, name, inserted.Seq, exit); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := db.LookupRun(ctx, name, inserted.Seq); err != nil {
+		t.Fatal(err)
+	} else if run.Exit == nil || !reflect.DeepEqual(*run.Exit, exit) {
+		t.Errorf("got %v, want %v", run.Exit, exit)
+	}
+	if got, want := db.SetRunExit(ctx, name, inserted.Seq+100, exit), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	cost := diviner.RunCost{MachineType: "m5.large", Region: "us-west-2", Duration: time.Hour, HourlyPrice: 0.096, Dollars: 0.096}
+	if err := db.SetRunCost(ctx, name, inserted.Seq, cost); err != nil {
+		t.Fatal(err)
+	}
+	if run, err := db.LookupRun(ctx, name, inserted.Seq); err != nil {
+		t.Fatal(err)
+	} else if run.Cost == nil || *run.Cost != cost {
+		t.Errorf("got %v, want %v", run.Cost, cost)
+	}
+	record := diviner.DatasetRecord{Name: name, Digest: "abc", Completed: time.Now()}
+	if err := db.SetDataset(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.LookupDataset(ctx, name); err != nil {
+		t.Fatal(err)
+	} else if got.Digest != record.Digest || !got.Completed.Equal(record.Completed) {
+		t.Errorf("got %v, want %v", got, record)
+	}
+	if err := db.InvalidateDataset(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.InvalidateDataset(ctx, name), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.LookupOracleState(ctx, name); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if err := db.SetOracleState(ctx, name, []byte("state")); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := db.LookupOracleState(ctx, name); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(state), "state"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := db.SetOracleState(ctx, name+"x", []byte("state")), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.EnqueueValues(ctx, name, diviner.Values{"x": diviner.Int(int64(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := db.EnqueueValues(ctx, name+"x", diviner.Values{}), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.DequeueValues(ctx, name, 2); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{{"x": diviner.Int(0)}, {"x": diviner.Int(1)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.QueuedValues(ctx, name); err != nil {
+		t.Fatal(err)
+	} else if got, want := values, []diviner.Values{{"x": diviner.Int(2)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if runs, err := db.ListRuns(ctx, name, diviner.Pending, time.Time{}); err != nil {
+		t.Fatal(err)
+	} else if len(runs) != 0 {
+		t.Errorf("unexpected pending runs %v", runs)
+	}
+
+	p, err := ioutil.ReadAll(db.Log(name, inserted.Seq, time.Time{}, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), "hello, world\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	_, err = ioutil.ReadAll(db.Log(name, inserted.Seq+1, time.Time{}, false))
+	if got, want := err, diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := db.DeleteRun(ctx, name, inserted.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LookupRun(ctx, name, inserted.Seq); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if err := db.DeleteStudy(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := db.DeleteStudy(ctx, name), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	db := open(t)
+	defer db.Close()
+	ctx := context.Background()
+	name := fmt.Sprintf("pgdb_test_archive_%d", time.Now().UnixNano())
+	defer db.DeleteStudy(ctx, name)
+	if got, want := db.UpdateStudy(ctx, diviner.Study{Name: name}), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := db.Logger(name, run.Seq)
+	fmt.Fprintln(w, "hello world")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteRunLogs(ctx, name, run.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ioutil.ReadAll(db.Log(name, run.Seq, time.Time{}, false)); err != nil {
+		t.Fatal(err)
+	} else if len(p) != 0 {
+		t.Errorf("unexpected log %q", p)
+	}
+	if got, want := db.DeleteRunLogs(ctx, name, run.Seq+1), diviner.ErrNotExist; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	retention := diviner.Retention{FailedLogs: time.Hour, KeepBest: 2}
+	if err := db.UpdateStudy(ctx, diviner.Study{Name: name, Archived: true, Retention: retention}); err != nil {
+		t.Fatal(err)
+	}
+	study, err := db.LookupStudy(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !study.Archived {
+		t.Error("study is not archived")
+	}
+	if got, want := study.Retention, retention; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.InsertRun(ctx, diviner.Run{Study: name}); err != diviner.ErrArchived {
+		t.Errorf("got %v, want %v", err, diviner.ErrArchived)
+	}
+	for _, err := range []error{
+		db.UpdateRun(ctx, name, run.Seq, diviner.Success, "", time.Minute, 0),
+		db.AppendRunMetrics(ctx, name, run.Seq, diviner.Metrics{"acc": 1}),
+		db.SetRunLabels(ctx, name, run.Seq, diviner.Labels{"x": "y"}),
+		db.DeleteRunLogs(ctx, name, run.Seq),
+		db.DeleteRun(ctx, name, run.Seq),
+		db.EnqueueValues(ctx, name, diviner.Values{"x": diviner.Int(1)}),
+	} {
+		if err != diviner.ErrArchived {
+			t.Errorf("got %v, want %v", err, diviner.ErrArchived)
+		}
+	}
+	if err := db.UpdateStudy(ctx, diviner.Study{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, name, run.Seq, diviner.Success, "", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLease(t *testing.T) {
+	db := open(t)
+	defer db.Close()
+	ctx := context.Background()
+	name := fmt.Sprintf("pgdb_test_lease_%d", time.Now().UnixNano())
+	defer db.DeleteStudy(ctx, name)
+	lease := diviner.Lease{Owner: "a", Expires: time.Now().Add(time.Minute)}
+	if _, _, err := db.AcquireLease(ctx, name, lease); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.AcquireLease(ctx, name, lease); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	}
+	other := diviner.Lease{Owner: "b", Expires: time.Now().Add(time.Minute)}
+	if held, ok, err := db.AcquireLease(ctx, name, other); err != nil {
+		t.Fatal(err)
+	} else if ok || held.Owner != "a" {
+		t.Errorf("got %v, %v, want lease of a", held, ok)
+	}
+	if err := db.ReleaseLease(ctx, name, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := db.AcquireLease(ctx, name, other); err != nil || !ok {
+		t.Errorf("got %v, %v, want true", ok, err)
+	}
+}
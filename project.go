@@ -0,0 +1,252 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProjectSeparator separates the project of a project-qualified study
+// name from the study's name within the project, e.g., "vision/mnist".
+const ProjectSeparator = "/"
+
+// ProjectName returns the project-qualified name of the provided study
+// in the provided project. If the project is empty, the study's name
+// is returned unchanged.
+func ProjectName(project, study string) string {
+	if project == "" {
+		return study
+	}
+	return project + ProjectSeparator + study
+}
+
+// SplitProject splits a project-qualified study name into its project
+// and the study's name within the project. The project is empty if
+// the name is not qualified.
+func SplitProject(name string) (project, study string) {
+	i := strings.Index(name, ProjectSeparator)
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+len(ProjectSeparator):]
+}
+
+// Projects returns the projects of the studies in the provided
+// database, in lexicographic order, together with the number of
+// studies in each. Studies whose names are not qualified belong to
+// the project "".
+func Projects(ctx context.Context, db Database) ([]string, map[string]int, error) {
+	studies, err := db.ListStudies(ctx, "", time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	counts := make(map[string]int)
+	for _, study := range studies {
+		project, _ := SplitProject(study.Name)
+		counts[project]++
+	}
+	projects := make([]string, 0, len(counts))
+	for project := range counts {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	return projects, counts, nil
+}
+
+// InProject returns a view of the provided database that is confined
+// to the provided project, so that many teams may share a database
+// without collisions among the names of their studies. Studies and
+// datasets are named within the project: the view stores each under
+// its project-qualified name (see ProjectName) in the underlying
+// database, and it returns studies, runs, and datasets under their
+// unqualified names. Studies that belong to other projects are not
+// visible through the view. If the project is empty, InProject
+// returns the database itself.
+func InProject(db Database, project string) Database {
+	if project == "" {
+		return db
+	}
+	return &projectDB{db, project + ProjectSeparator}
+}
+
+type projectDB struct {
+	Database
+	prefix string
+}
+
+// Qualify returns the underlying database's name for the name.
+func (p *projectDB) qualify(name string) string { return p.prefix + name }
+
+// Unqualify returns the view's name for the underlying name.
+func (p *projectDB) unqualify(name string) string { return strings.TrimPrefix(name, p.prefix) }
+
+func (p *projectDB) unqualifyRuns(runs []Run) []Run {
+	for i := range runs {
+		runs[i].Study = p.unqualify(runs[i].Study)
+	}
+	return runs
+}
+
+func (p *projectDB) CreateStudyIfNotExist(ctx context.Context, study Study) (bool, error) {
+	study.Name = p.qualify(study.Name)
+	return p.Database.CreateStudyIfNotExist(ctx, study)
+}
+
+func (p *projectDB) LookupStudy(ctx context.Context, name string) (Study, error) {
+	study, err := p.Database.LookupStudy(ctx, p.qualify(name))
+	study.Name = p.unqualify(study.Name)
+	return study, err
+}
+
+func (p *projectDB) UpdateStudy(ctx context.Context, study Study) error {
+	study.Name = p.qualify(study.Name)
+	return p.Database.UpdateStudy(ctx, study)
+}
+
+func (p *projectDB) ListStudies(ctx context.Context, prefix string, since time.Time) ([]Study, error) {
+	studies, err := p.Database.ListStudies(ctx, p.qualify(prefix), since)
+	for i := range studies {
+		studies[i].Name = p.unqualify(studies[i].Name)
+	}
+	return studies, err
+}
+
+func (p *projectDB) NextSeq(ctx context.Context, study string) (uint64, error) {
+	return p.Database.NextSeq(ctx, p.qualify(study))
+}
+
+func (p *projectDB) InsertRun(ctx context.Context, run Run) (Run, error) {
+	run.Study = p.qualify(run.Study)
+	run, err := p.Database.InsertRun(ctx, run)
+	run.Study = p.unqualify(run.Study)
+	return run, err
+}
+
+func (p *projectDB) UpdateRun(ctx context.Context, study string, seq uint64, state RunState, message string, runtime time.Duration, retry int) error {
+	return p.Database.UpdateRun(ctx, p.qualify(study), seq, state, message, runtime, retry)
+}
+
+func (p *projectDB) AppendRunMetrics(ctx context.Context, study string, seq uint64, metrics Metrics) error {
+	return p.Database.AppendRunMetrics(ctx, p.qualify(study), seq, metrics)
+}
+
+func (p *projectDB) ListRuns(ctx context.Context, study string, states RunState, since time.Time) ([]Run, error) {
+	runs, err := p.Database.ListRuns(ctx, p.qualify(study), states, since)
+	return p.unqualifyRuns(runs), err
+}
+
+func (p *projectDB) Scan(ctx context.Context, study string, states RunState) RunIterator {
+	return &projectIterator{p.Database.Scan(ctx, p.qualify(study), states), p}
+}
+
+func (p *projectDB) Query(ctx context.Context, study string, query Query) ([]Run, error) {
+	runs, err := p.Database.Query(ctx, p.qualify(study), query)
+	return p.unqualifyRuns(runs), err
+}
+
+func (p *projectDB) BestRuns(ctx context.Context, study string, objective Objective, k int) ([]Run, error) {
+	runs, err := p.Database.BestRuns(ctx, p.qualify(study), objective, k)
+	return p.unqualifyRuns(runs), err
+}
+
+func (p *projectDB) LookupRun(ctx context.Context, study string, seq uint64) (Run, error) {
+	run, err := p.Database.LookupRun(ctx, p.qualify(study), seq)
+	run.Study = p.unqualify(run.Study)
+	return run, err
+}
+
+func (p *projectDB) SetRunLabels(ctx context.Context, study string, seq uint64, labels Labels) error {
+	return p.Database.SetRunLabels(ctx, p.qualify(study), seq, labels)
+}
+
+func (p *projectDB) SetRunArtifacts(ctx context.Context, study string, seq uint64, artifacts []Artifact) error {
+	return p.Database.SetRunArtifacts(ctx, p.qualify(study), seq, artifacts)
+}
+
+func (p *projectDB) SetRunExit(ctx context.Context, study string, seq uint64, exit RunExit) error {
+	return p.Database.SetRunExit(ctx, p.qualify(study), seq, exit)
+}
+
+func (p *projectDB) SetRunCost(ctx context.Context, study string, seq uint64, cost RunCost) error {
+	return p.Database.SetRunCost(ctx, p.qualify(study), seq, cost)
+}
+
+func (p *projectDB) DeleteRun(ctx context.Context, study string, seq uint64) error {
+	return p.Database.DeleteRun(ctx, p.qualify(study), seq)
+}
+
+func (p *projectDB) DeleteRunLogs(ctx context.Context, study string, seq uint64) error {
+	return p.Database.DeleteRunLogs(ctx, p.qualify(study), seq)
+}
+
+func (p *projectDB) DeleteStudy(ctx context.Context, name string) error {
+	return p.Database.DeleteStudy(ctx, p.qualify(name))
+}
+
+func (p *projectDB) LookupDataset(ctx context.Context, name string) (DatasetRecord, error) {
+	record, err := p.Database.LookupDataset(ctx, p.qualify(name))
+	record.Name = p.unqualify(record.Name)
+	return record, err
+}
+
+func (p *projectDB) SetDataset(ctx context.Context, record DatasetRecord) error {
+	record.Name = p.qualify(record.Name)
+	return p.Database.SetDataset(ctx, record)
+}
+
+func (p *projectDB) InvalidateDataset(ctx context.Context, name string) error {
+	return p.Database.InvalidateDataset(ctx, p.qualify(name))
+}
+
+func (p *projectDB) LookupOracleState(ctx context.Context, study string) ([]byte, error) {
+	return p.Database.LookupOracleState(ctx, p.qualify(study))
+}
+
+func (p *projectDB) SetOracleState(ctx context.Context, study string, state []byte) error {
+	return p.Database.SetOracleState(ctx, p.qualify(study), state)
+}
+
+func (p *projectDB) EnqueueValues(ctx context.Context, study string, values Values) error {
+	return p.Database.EnqueueValues(ctx, p.qualify(study), values)
+}
+
+func (p *projectDB) DequeueValues(ctx context.Context, study string, n int) ([]Values, error) {
+	return p.Database.DequeueValues(ctx, p.qualify(study), n)
+}
+
+func (p *projectDB) QueuedValues(ctx context.Context, study string) ([]Values, error) {
+	return p.Database.QueuedValues(ctx, p.qualify(study))
+}
+
+func (p *projectDB) AcquireLease(ctx context.Context, study string, lease Lease) (Lease, bool, error) {
+	return p.Database.AcquireLease(ctx, p.qualify(study), lease)
+}
+
+func (p *projectDB) ReleaseLease(ctx context.Context, study, owner string) error {
+	return p.Database.ReleaseLease(ctx, p.qualify(study), owner)
+}
+
+func (p *projectDB) Log(study string, seq uint64, since time.Time, follow bool) io.Reader {
+	return p.Database.Log(p.qualify(study), seq, since, follow)
+}
+
+func (p *projectDB) Logger(study string, seq uint64) io.WriteCloser {
+	return p.Database.Logger(p.qualify(study), seq)
+}
+
+type projectIterator struct {
+	RunIterator
+	db *projectDB
+}
+
+func (it *projectIterator) Run() Run {
+	run := it.RunIterator.Run()
+	run.Study = it.db.unqualify(run.Study)
+	return run
+}
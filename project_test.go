@@ -0,0 +1,121 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestSplitProject(t *testing.T) {
+	for _, test := range []struct {
+		name, project, study string
+	}{
+		{"mnist", "", "mnist"},
+		{"vision/mnist", "vision", "mnist"},
+		{"vision/sub/mnist", "vision", "sub/mnist"},
+	} {
+		project, study := diviner.SplitProject(test.name)
+		if project != test.project || study != test.study {
+			t.Errorf("%s: got %s, %s, want %s, %s", test.name, project, study, test.project, test.study)
+		}
+		if got, want := diviner.ProjectName(project, study), test.name; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestInProject(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		db     = memdb.New()
+		vision = diviner.InProject(db, "vision")
+		speech = diviner.InProject(db, "speech")
+	)
+	if got, want := diviner.InProject(db, ""), diviner.Database(db); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, pdb := range []diviner.Database{vision, speech} {
+		if _, err := pdb.CreateStudyIfNotExist(ctx, diviner.Study{Name: "mnist"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := vision.InsertRun(ctx, diviner.Run{Study: "mnist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.ID(), "mnist:1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := vision.AppendRunMetrics(ctx, "mnist", run.Seq, diviner.Metrics{"acc": 0.9}); err != nil {
+		t.Fatal(err)
+	}
+	// The projects' studies do not collide.
+	if runs, err := speech.ListRuns(ctx, "mnist", diviner.Any, time.Time{}); err != nil {
+		t.Fatal(err)
+	} else if len(runs) != 0 {
+		t.Errorf("unexpected runs %v", runs)
+	}
+	run, err = db.LookupRun(ctx, "vision/mnist", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Trial().Metrics, (diviner.Metrics{"acc": 0.9}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	it := vision.Scan(ctx, "mnist", diviner.Any)
+	for it.Next() {
+		if got, want := it.Run().Study, "mnist"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	it.Close()
+	studies, err := vision.ListStudies(ctx, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Name, "mnist"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := vision.LookupStudy(ctx, "other"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if err := speech.SetDataset(ctx, diviner.DatasetRecord{Name: "data", Digest: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vision.LookupDataset(ctx, "data"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+	if dataset, err := speech.LookupDataset(ctx, "data"); err != nil {
+		t.Fatal(err)
+	} else if got, want := dataset.Name, "data"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	projects, counts, err := diviner.Projects(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := projects, []string{"", "speech", "vision"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := counts, map[string]int{"": 1, "speech": 1, "vision": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,16 @@
+# Copyright 2019 GRAIL, Inc. All rights reserved.
+# Use of this source code is governed by the Apache 2.0
+# license that can be found in the LICENSE file.
+
+"""Diviner is a Python client of the diviner JSON API.
+
+For example, to load the successful runs of a study into pandas:
+
+    import diviner
+    client = diviner.Client("http://localhost:8080")
+    df = client.runs_frame("mnist", state="success")
+"""
+
+from .client import DEFAULT_URL, Client, Error
+
+__all__ = ["DEFAULT_URL", "Client", "Error"]
@@ -0,0 +1,188 @@
+# Copyright 2019 GRAIL, Inc. All rights reserved.
+# Use of this source code is governed by the Apache 2.0
+# license that can be found in the LICENSE file.
+
+"""A client of the diviner JSON API (see api/openapi.yaml)."""
+
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+
+DEFAULT_URL = "http://localhost:8080"
+
+
+class Error(Exception):
+    """An error reported by the API server."""
+
+    def __init__(self, status, message):
+        super().__init__("%d: %s" % (status, message))
+        self.status = status
+        self.message = message
+
+
+class Client:
+    """Client is a client of a diviner API server (diviner serve-api).
+
+    Methods that list entries iterate over all of their pages; they
+    return lists of dicts, as described by the API's OpenAPI
+    specification. The *_frame methods return the same entries as
+    pandas DataFrames, whose values and metrics are flattened into
+    columns named "values.<name>" and "metrics.<name>".
+    """
+
+    def __init__(self, url=DEFAULT_URL, timeout=60, page_size=1000):
+        self.url = url.rstrip("/")
+        self.timeout = timeout
+        self.page_size = page_size
+
+    def studies(self, prefix=None, since=None):
+        """Returns the studies, ordered by name."""
+        return self._list("/v1/studies", "studies", prefix=prefix, since=since)
+
+    def study(self, study):
+        """Returns the named study."""
+        return self._request("GET", _study_path(study))
+
+    def runs(self, study, state=None, values=None, metrics=None, labels=None, since=None):
+        """Returns the runs of a study, ordered by sequence number.
+
+        State is a comma-separated list of states (pending, success,
+        failure); values maps parameter names to the values that runs
+        must have; metrics is a list of bounds, such as "acc>0.9";
+        labels is a label selector, such as "team=ml,!debug".
+        """
+        if values is not None:
+            values = ["%s=%s" % (name, _format_value(v)) for name, v in sorted(values.items())]
+        return self._list(
+            _study_path(study) + "/runs",
+            "runs",
+            state=state,
+            value=values,
+            metric=metrics,
+            labels=labels,
+            since=since,
+        )
+
+    def run(self, study, seq):
+        """Returns the run of a study with the provided sequence number."""
+        return self._request("GET", "%s/runs/%d" % (_study_path(study), seq))
+
+    def metrics(self, study, seq):
+        """Returns a run's metric history, as a list of steps."""
+        return self._list("%s/runs/%d/metrics" % (_study_path(study), seq), "metrics")
+
+    def logs(self, study, seq, stream=None):
+        """Returns a run's logs.
+
+        Stream selects the output streams to return: "stdout",
+        "stderr", or "all" (the default).
+        """
+        return self._request(
+            "GET", "%s/runs/%d/logs" % (_study_path(study), seq), {"stream": stream}, raw=True
+        )
+
+    def leaderboard(self, study, objective=None, n=10):
+        """Returns the best n trials of a study (all if n is 0).
+
+        Objective is a metric name, prefixed by "-" to minimize it; it
+        defaults to the study's objective.
+        """
+        reply = self._request(
+            "GET", _study_path(study) + "/leaderboard", {"objective": objective, "n": n}
+        )
+        return reply["trials"]
+
+    def trials(self, study, state=None):
+        """Returns the trials of a study, ordered by their first runs."""
+        return self._list(_study_path(study) + "/trials", "trials", state=state)
+
+    def submit(self, study, values, metrics, labels=None, replicate=0):
+        """Submits a trial that was evaluated outside of diviner.
+
+        Values maps the study's parameters to the trial's values;
+        metrics is the trial's metric reports, either a dict or a list
+        of dicts, in order. The server must permit submissions
+        (diviner serve-api -submit). Returns the run that records the
+        trial.
+        """
+        if isinstance(metrics, dict):
+            metrics = [metrics]
+        body = {"values": values, "metrics": list(metrics)}
+        if labels:
+            body["labels"] = labels
+        if replicate:
+            body["replicate"] = replicate
+        return self._request("POST", _study_path(study) + "/trials", body=body)
+
+    def runs_frame(self, study, **kwargs):
+        """Returns the runs of a study (see runs) as a DataFrame."""
+        return _frame(self.runs(study, **kwargs))
+
+    def trials_frame(self, study, **kwargs):
+        """Returns the trials of a study (see trials) as a DataFrame."""
+        return _frame(self.trials(study, **kwargs))
+
+    def leaderboard_frame(self, study, **kwargs):
+        """Returns the leaderboard of a study (see leaderboard) as a DataFrame."""
+        return _frame(self.leaderboard(study, **kwargs))
+
+    def _list(self, path, key, **params):
+        params["page_size"] = self.page_size
+        entries = []
+        while True:
+            reply = self._request("GET", path, params)
+            entries.extend(reply[key])
+            token = reply.get("next_page_token")
+            if not token:
+                return entries
+            params["page_token"] = token
+
+    def _request(self, method, path, params=None, body=None, raw=False):
+        query = []
+        for name, value in (params or {}).items():
+            if value is None:
+                continue
+            if isinstance(value, (list, tuple)):
+                query.extend((name, v) for v in value)
+            else:
+                query.append((name, value))
+        url = self.url + path
+        if query:
+            url += "?" + urllib.parse.urlencode(query)
+        data, headers = None, {"Accept": "application/json"}
+        if body is not None:
+            data = json.dumps(body).encode("utf-8")
+            headers["Content-Type"] = "application/json"
+        req = urllib.request.Request(url, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                payload = resp.read()
+        except urllib.error.HTTPError as e:
+            payload = e.read()
+            try:
+                message = json.loads(payload)["error"]
+            except (ValueError, KeyError, TypeError):
+                message = payload.decode("utf-8", "replace")
+            raise Error(e.code, message) from None
+        if raw:
+            return payload.decode("utf-8", "replace")
+        return json.loads(payload)
+
+
+def _study_path(study):
+    return "/v1/studies/" + urllib.parse.quote(study, safe="")
+
+
+def _format_value(v):
+    if isinstance(v, bool):
+        return "true" if v else "false"
+    return str(v)
+
+
+def _frame(entries):
+    try:
+        import pandas
+    except ImportError:
+        raise ImportError("pandas is required for DataFrames: pip install diviner[pandas]") from None
+    return pandas.json_normalize(entries)
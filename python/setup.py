@@ -0,0 +1,16 @@
+# Copyright 2019 GRAIL, Inc. All rights reserved.
+# Use of this source code is governed by the Apache 2.0
+# license that can be found in the LICENSE file.
+
+from setuptools import setup
+
+setup(
+    name="diviner",
+    version="0.1.0",
+    description="A client of the diviner JSON API",
+    url="https://github.com/grailbio/diviner",
+    license="Apache 2.0",
+    packages=["diviner"],
+    python_requires=">=3.6",
+    extras_require={"pandas": ["pandas>=1.0"]},
+)
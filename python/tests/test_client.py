@@ -0,0 +1,87 @@
+# Copyright 2019 GRAIL, Inc. All rights reserved.
+# Use of this source code is governed by the Apache 2.0
+# license that can be found in the LICENSE file.
+
+import http.server
+import json
+import os
+import sys
+import threading
+import unittest
+import urllib.parse
+
+sys.path.insert(0, os.path.join(os.path.dirname(__file__), ".."))
+
+import diviner  # noqa: E402
+
+RUNS = [{"id": "a/test:%d" % i, "seq": i, "values": {"lr": i / 10}, "metrics": {"acc": i}} for i in range(1, 6)]
+
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    requests = []
+
+    def log_message(self, *args):
+        pass
+
+    def reply(self, code, body):
+        payload = json.dumps(body).encode("utf-8")
+        self.send_response(code)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(payload)))
+        self.end_headers()
+        self.wfile.write(payload)
+
+    def do_GET(self):
+        url = urllib.parse.urlsplit(self.path)
+        query = urllib.parse.parse_qs(url.query)
+        Handler.requests.append((url.path, query))
+        if url.path == "/v1/studies/a%2Ftest/runs":
+            size = int(query["page_size"][0])
+            offset = int(query.get("page_token", ["0"])[0])
+            token = str(offset + size) if offset + size < len(RUNS) else ""
+            self.reply(200, {"runs": RUNS[offset : offset + size], "next_page_token": token})
+        else:
+            self.reply(404, {"error": "no such resource"})
+
+    def do_POST(self):
+        body = json.loads(self.rfile.read(int(self.headers["Content-Length"])))
+        Handler.requests.append((self.path, body))
+        self.reply(201, {"id": "a/test:6", "seq": 6, "state": "success", "values": body["values"]})
+
+
+class ClientTest(unittest.TestCase):
+    def setUp(self):
+        Handler.requests = []
+        self.server = http.server.HTTPServer(("127.0.0.1", 0), Handler)
+        threading.Thread(target=self.server.serve_forever, daemon=True).start()
+        self.client = diviner.Client("http://127.0.0.1:%d" % self.server.server_port, page_size=2)
+
+    def tearDown(self):
+        self.server.shutdown()
+        self.server.server_close()
+
+    def test_runs(self):
+        runs = self.client.runs("a/test", state="success", values={"opt": "sgd"}, metrics=["acc>1"])
+        self.assertEqual([run["seq"] for run in runs], [1, 2, 3, 4, 5])
+        self.assertEqual(len(Handler.requests), 3)
+        path, query = Handler.requests[0]
+        self.assertEqual(query["state"], ["success"])
+        self.assertEqual(query["value"], ["opt=sgd"])
+        self.assertEqual(query["metric"], ["acc>1"])
+
+    def test_submit(self):
+        run = self.client.submit("a/test", {"lr": 0.5}, {"acc": 0.7}, labels={"source": "test"})
+        self.assertEqual(run["seq"], 6)
+        path, body = Handler.requests[0]
+        self.assertEqual(path, "/v1/studies/a%2Ftest/trials")
+        self.assertEqual(body, {"values": {"lr": 0.5}, "metrics": [{"acc": 0.7}], "labels": {"source": "test"}})
+
+    def test_error(self):
+        with self.assertRaises(diviner.Error) as cm:
+            self.client.study("nonexistent")
+        self.assertEqual(cm.exception.status, 404)
+        self.assertEqual(cm.exception.message, "no such resource")
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,118 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// A Query selects runs by their states, parameter values, and
+// metrics. Metric predicates apply to a run's latest metrics, i.e.,
+// those of its trial (see Run.Trial); runs that lack a metric, or
+// whose metric is NaN, do not satisfy predicates on it. A run is
+// selected only if it satisfies all of the query's predicates; the
+// zero Query selects all runs.
+//
+// For example, the query
+//
+//	Query{
+//		MetricAbove: map[string]float64{"acc": 0.9},
+//		Values:      Values{"optimizer": String("adam")},
+//	}
+//
+// selects the runs that used the Adam optimizer and that attained an
+// accuracy greater than 0.9.
+type Query struct {
+	// States are the states of the selected runs. If zero, runs in
+	// any state are selected.
+	States RunState
+	// Values are parameter values that selected runs must have.
+	Values Values
+	// MetricAbove selects runs whose metrics are greater than the
+	// provided values.
+	MetricAbove map[string]float64
+	// MetricBelow selects runs whose metrics are less than the
+	// provided values.
+	MetricBelow map[string]float64
+}
+
+// RunStates returns the run states selected by the query.
+func (q Query) RunStates() RunState {
+	if q.States == 0 {
+		return Any
+	}
+	return q.States
+}
+
+// Matches tells whether the provided run satisfies the query.
+func (q Query) Matches(run Run) bool {
+	if run.State&q.RunStates() != run.State {
+		return false
+	}
+	for name, v := range q.Values {
+		w, ok := run.Values[name]
+		if !ok || !w.Equal(v) {
+			return false
+		}
+	}
+	metrics := run.Trial().Metrics
+	for name, bound := range q.MetricAbove {
+		if v, ok := metrics[name]; !ok || math.IsNaN(v) || v <= bound {
+			return false
+		}
+	}
+	for name, bound := range q.MetricBelow {
+		if v, ok := metrics[name]; !ok || math.IsNaN(v) || v >= bound {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns a textual description of the query's value and
+// metric predicates.
+func (q Query) String() string {
+	var elems []string
+	for _, v := range q.Values.Sorted() {
+		elems = append(elems, fmt.Sprintf("%s=%s", v.Name, v.Value))
+	}
+	for _, name := range sortedMetricNames(q.MetricAbove) {
+		elems = append(elems, fmt.Sprintf("%s>%g", name, q.MetricAbove[name]))
+	}
+	for _, name := range sortedMetricNames(q.MetricBelow) {
+		elems = append(elems, fmt.Sprintf("%s<%g", name, q.MetricBelow[name]))
+	}
+	return strings.Join(elems, ",")
+}
+
+func sortedMetricNames(m map[string]float64) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// QueryRuns returns the runs in the provided study that satisfy the
+// provided query, in the order in which they are scanned from the
+// database. QueryRuns scans all of the study's runs in the queried
+// states; it is used by databases that do not index runs'
+// metrics.
+func QueryRuns(ctx context.Context, db Database, study string, query Query) ([]Run, error) {
+	it := db.Scan(ctx, study, query.RunStates())
+	defer it.Close()
+	var runs []Run
+	for it.Next() {
+		if run := it.Run(); query.Matches(run) {
+			runs = append(runs, run)
+		}
+	}
+	return runs, it.Err()
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestQueryMatches(t *testing.T) {
+	run := diviner.Run{
+		State:   diviner.Success,
+		Values:  diviner.Values{"optimizer": diviner.String("adam"), "layers": diviner.Int(3)},
+		Metrics: []diviner.Metrics{{"acc": 0.5}, {"acc": 0.93, "loss": math.NaN()}},
+	}
+	for _, test := range []struct {
+		query diviner.Query
+		match bool
+	}{
+		{diviner.Query{}, true},
+		{diviner.Query{States: diviner.Success | diviner.Failure}, true},
+		{diviner.Query{States: diviner.Pending}, false},
+		{diviner.Query{Values: diviner.Values{"optimizer": diviner.String("adam")}}, true},
+		{diviner.Query{Values: diviner.Values{"optimizer": diviner.String("sgd")}}, false},
+		{diviner.Query{Values: diviner.Values{"layers": diviner.Float(3)}}, false},
+		{diviner.Query{Values: diviner.Values{"momentum": diviner.Float(0.9)}}, false},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0.9}}, true},
+		{diviner.Query{MetricAbove: map[string]float64{"acc": 0.93}}, false},
+		{diviner.Query{MetricBelow: map[string]float64{"acc": 0.6}}, false},
+		{diviner.Query{MetricBelow: map[string]float64{"loss": 1}}, false},
+		{diviner.Query{MetricAbove: map[string]float64{"f1": 0}}, false},
+	} {
+		if got, want := test.query.Matches(run), test.match; got != want {
+			t.Errorf("%v: got %v, want %v", test.query, got, want)
+		}
+	}
+	query := diviner.Query{
+		Values:      diviner.Values{"optimizer": diviner.String("adam")},
+		MetricAbove: map[string]float64{"acc": 0.9},
+		MetricBelow: map[string]float64{"loss": 0.1},
+	}
+	if got, want := query.String(), "optimizer=adam,acc>0.9,loss<0.1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
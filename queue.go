@@ -0,0 +1,61 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"fmt"
+)
+
+// Enqueue requests a trial of the provided study with the provided
+// parameter values, bypassing the study's oracle; e.g., to try a
+// setting suggested by a colleague. Runners perform a study's queued
+// trials, in the order in which they were enqueued, before they
+// request new trials from its oracle; queued trials are then tracked
+// like any other, and are among the previous trials observed by the
+// oracle. Runners remove trials from the queue before they create
+// their runs, so that a trial whose runner dies before it starts the
+// trial's runs is not performed, and must be enqueued again.
+//
+// The values are coerced to the study's parameters (see
+// Params.Coerce), without clamping, and Enqueue fails if they are not
+// a valid assignment of the parameters. If the study has a fidelity,
+// the values may include it; otherwise the trial is performed at the
+// study's full fidelity. The study is created in the database if it
+// does not yet exist. Enqueue returns the enqueued values.
+func Enqueue(ctx context.Context, db Database, study Study, values Values) (Values, error) {
+	var (
+		fidelity    float64
+		hasFidelity bool
+	)
+	if study.Fidelity != nil {
+		if err := study.Fidelity.Validate(study.Params); err != nil {
+			return nil, fmt.Errorf("%s: %v", study.Name, err)
+		}
+		if _, ok := values[study.Fidelity.Name]; ok {
+			if fidelity, hasFidelity = study.Fidelity.Get(values); !hasFidelity {
+				return nil, fmt.Errorf("%s: invalid fidelity %s", study.Name, values[study.Fidelity.Name])
+			}
+		}
+		values = study.Fidelity.Strip(values)
+	}
+	values, err := study.Params.Coerce(values, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid values: %v", study.Name, err)
+	}
+	if study.Fidelity != nil {
+		if !hasFidelity {
+			fidelity = study.Fidelity.Max
+		}
+		values = study.Fidelity.With(values, fidelity)
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+		return nil, err
+	}
+	if err := db.EnqueueValues(ctx, study.Name, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestEnqueue(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		db    = memdb.New()
+		study = diviner.Study{
+			Name: "test",
+			Params: diviner.Params{
+				"lr": diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+				"bs": diviner.NewDiscrete(diviner.Int(256), diviner.Int(512)),
+			},
+			Fidelity: &diviner.Fidelity{Name: "epochs", Min: 1, Max: 10, Integer: true},
+		}
+	)
+	for _, values := range []diviner.Values{
+		{"lr": diviner.Float(3e-4)},
+		{"lr": diviner.Float(3e-4), "bs": diviner.Int(100)},
+		{"lr": diviner.Float(3e-4), "bs": diviner.Int(512), "depth": diviner.Int(3)},
+		{"lr": diviner.Float(3e-4), "bs": diviner.Int(512), "epochs": diviner.String("x")},
+	} {
+		if _, err := diviner.Enqueue(ctx, db, study, values); err == nil {
+			t.Errorf("%s: expected error", values)
+		}
+	}
+	// The study is created by the first successful enqueue.
+	if _, err := diviner.Enqueue(ctx, db, study, diviner.Values{"lr": diviner.Float(3e-4), "bs": diviner.Float(512)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diviner.Enqueue(ctx, db, study, diviner.Values{"lr": diviner.Int(0), "bs": diviner.Int(256), "epochs": diviner.Int(2)}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := db.QueuedValues(ctx, study.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []diviner.Values{
+		{"lr": diviner.Float(3e-4), "bs": diviner.Int(512), "epochs": diviner.Int(10)},
+		{"lr": diviner.Float(0), "bs": diviner.Int(256), "epochs": diviner.Int(2)},
+	}
+	if got := values; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
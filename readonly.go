@@ -0,0 +1,85 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrReadOnly is returned by writes to read-only databases.
+var ErrReadOnly = errors.New("database is read-only")
+
+// ReadOnly returns a database that reads from the provided database,
+// but whose writes fail with ErrReadOnly. The loggers of a read-only
+// database fail on write.
+func ReadOnly(db Database) Database {
+	return readOnly{db}
+}
+
+type readOnly struct{ Database }
+
+func (readOnly) CreateTable(context.Context) error { return ErrReadOnly }
+
+func (readOnly) CreateStudyIfNotExist(context.Context, Study) (bool, error) {
+	return false, ErrReadOnly
+}
+
+func (readOnly) UpdateStudy(context.Context, Study) error { return ErrReadOnly }
+
+func (readOnly) NextSeq(context.Context, string) (uint64, error) { return 0, ErrReadOnly }
+
+func (readOnly) InsertRun(context.Context, Run) (Run, error) { return Run{}, ErrReadOnly }
+
+func (readOnly) UpdateRun(context.Context, string, uint64, RunState, string, time.Duration, int) error {
+	return ErrReadOnly
+}
+
+func (readOnly) AppendRunMetrics(context.Context, string, uint64, Metrics) error {
+	return ErrReadOnly
+}
+
+func (readOnly) SetRunLabels(context.Context, string, uint64, Labels) error { return ErrReadOnly }
+
+func (readOnly) SetRunArtifacts(context.Context, string, uint64, []Artifact) error {
+	return ErrReadOnly
+}
+
+func (readOnly) SetRunExit(context.Context, string, uint64, RunExit) error { return ErrReadOnly }
+
+func (readOnly) SetRunCost(context.Context, string, uint64, RunCost) error { return ErrReadOnly }
+
+func (readOnly) DeleteRun(context.Context, string, uint64) error { return ErrReadOnly }
+
+func (readOnly) DeleteRunLogs(context.Context, string, uint64) error { return ErrReadOnly }
+
+func (readOnly) DeleteStudy(context.Context, string) error { return ErrReadOnly }
+
+func (readOnly) SetDataset(context.Context, DatasetRecord) error { return ErrReadOnly }
+
+func (readOnly) InvalidateDataset(context.Context, string) error { return ErrReadOnly }
+
+func (readOnly) SetOracleState(context.Context, string, []byte) error { return ErrReadOnly }
+
+func (readOnly) EnqueueValues(context.Context, string, Values) error { return ErrReadOnly }
+
+func (readOnly) DequeueValues(context.Context, string, int) ([]Values, error) {
+	return nil, ErrReadOnly
+}
+
+func (readOnly) AcquireLease(context.Context, string, Lease) (Lease, bool, error) {
+	return Lease{}, false, ErrReadOnly
+}
+
+func (readOnly) ReleaseLease(context.Context, string, string) error { return ErrReadOnly }
+
+func (readOnly) Logger(string, uint64) io.WriteCloser { return readOnlyLogger{} }
+
+type readOnlyLogger struct{}
+
+func (readOnlyLogger) Write([]byte) (int, error) { return 0, ErrReadOnly }
+func (readOnlyLogger) Close() error              { return nil }
@@ -0,0 +1,65 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	db := memdb.New()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ro := diviner.ReadOnly(db)
+	if _, err := ro.LookupStudy(ctx, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if runs, err := ro.ListRuns(ctx, "test", diviner.Any, time.Time{}); err != nil {
+		t.Fatal(err)
+	} else if got, want := len(runs), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, err := range []error{
+		func() error { _, err := ro.CreateStudyIfNotExist(ctx, diviner.Study{Name: "other"}); return err }(),
+		func() error { _, err := ro.InsertRun(ctx, diviner.Run{Study: "test"}); return err }(),
+		ro.UpdateRun(ctx, "test", run.Seq, diviner.Success, "", time.Second, 0),
+		ro.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 1}),
+		ro.UpdateStudy(ctx, diviner.Study{Name: "test", Archived: true}),
+		ro.DeleteRun(ctx, "test", run.Seq),
+		ro.DeleteRunLogs(ctx, "test", run.Seq),
+		ro.DeleteStudy(ctx, "test"),
+		ro.SetOracleState(ctx, "test", []byte("state")),
+		ro.EnqueueValues(ctx, "test", diviner.Values{"x": diviner.Int(1)}),
+		func() error { _, err := ro.DequeueValues(ctx, "test", 1); return err }(),
+		func() error { _, _, err := ro.AcquireLease(ctx, "test", diviner.Lease{Owner: "x"}); return err }(),
+		ro.ReleaseLease(ctx, "test", "x"),
+		func() error { _, err := io.WriteString(ro.Logger("test", run.Seq), "log\n"); return err }(),
+	} {
+		if got, want := err, diviner.ErrReadOnly; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	// The underlying database is unchanged.
+	if run, err := db.LookupRun(ctx, "test", run.Seq); err != nil {
+		t.Fatal(err)
+	} else if got, want := run.State, diviner.Pending; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := db.LookupStudy(ctx, "other"); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
@@ -0,0 +1,790 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/bigmachine"
+)
+
+// Run and study records are persisted in version 2 of the encoding
+// (see encodingMagic): the version byte is followed by a JSON
+// document, so that records can be read by tools not written in Go,
+// and remain readable as diviner's Go types evolve. The documents'
+// fields are named independently of the Go types' fields; new fields
+// may be added to records, but existing fields must not change their
+// meaning.
+//
+// Values are encoded as objects with a single member, named by the
+// value's kind: {"int": 1}, {"float": 0.5}, {"string": "adam"},
+// {"bool": true}, {"duration": "1h30m0s"}, {"time":
+// "2019-06-01T12:00:00Z"}, {"list": [...]}, and {"dict": {...}}.
+// Non-finite floats (in values and metrics) are encoded as the
+// strings "NaN", "Infinity", and "-Infinity". The records' own
+// durations are integers of nanoseconds; their times are RFC 3339
+// strings.
+//
+// Some parts of records are Go values without a language-neutral
+// representation: the bigmachine systems and backends of systems,
+// and the parameters, oracles, schedulers, and notifiers of studies.
+// These are carried, gob-encoded, in "gob" members, which are opaque
+// to other languages. Study records also describe their parameters
+// textually, for the benefit of such tools.
+const encodingV2 = 2
+
+// MarshalRun returns the versioned encoding of the provided run.
+func MarshalRun(run Run) ([]byte, error) {
+	rec, err := encodeRun(run)
+	if err != nil {
+		return nil, err
+	}
+	return marshalRecord(rec)
+}
+
+// UnmarshalRun decodes a run encoded by MarshalRun, or by legacy
+// (gob-based) versions of diviner.
+func UnmarshalRun(p []byte) (Run, error) {
+	var run Run
+	if !bytes.HasPrefix(p, encodingMagic) {
+		err := gob.NewDecoder(bytes.NewReader(p)).Decode(&run)
+		return run, err
+	}
+	var rec runRecord
+	if err := unmarshalRecord(p, &rec); err != nil {
+		return Run{}, err
+	}
+	return decodeRun(rec)
+}
+
+func encodeRun(run Run) (runRecord, error) {
+	rec := runRecord{
+		Study:     run.Study,
+		Seq:       run.Seq,
+		Replicate: run.Replicate,
+		State:     run.State.String(),
+		Status:    run.Status,
+		Created:   run.Created,
+		Updated:   run.Updated,
+		Started:   run.Started,
+		Completed: run.Completed,
+		Runtime:   run.Runtime,
+		Retries:   run.Retries,
+		RetryOf:   run.RetryOf,
+		Attempt:   run.Attempt,
+		Parent:    run.Parent,
+		Labels:    run.Labels,
+		Artifacts: run.Artifacts,
+		Exit:      run.Exit,
+		Cost:      run.Cost,
+		Source:    run.Source,
+	}
+	var err error
+	if rec.Values, err = encodeValuesJSON(run.Values); err != nil {
+		return runRecord{}, err
+	}
+	if !run.Config.IsZero() {
+		if rec.Config, err = encodeConfig(run.Config); err != nil {
+			return runRecord{}, err
+		}
+	}
+	for _, metrics := range run.Metrics {
+		rec.Metrics = append(rec.Metrics, encodeMetricsJSON(metrics))
+	}
+	return rec, nil
+}
+
+func decodeRun(rec runRecord) (Run, error) {
+	run := Run{
+		Study:     rec.Study,
+		Seq:       rec.Seq,
+		Replicate: rec.Replicate,
+		Status:    rec.Status,
+		Created:   rec.Created,
+		Updated:   rec.Updated,
+		Started:   rec.Started,
+		Completed: rec.Completed,
+		Runtime:   rec.Runtime,
+		Retries:   rec.Retries,
+		RetryOf:   rec.RetryOf,
+		Attempt:   rec.Attempt,
+		Parent:    rec.Parent,
+		Labels:    rec.Labels,
+		Artifacts: rec.Artifacts,
+		Exit:      rec.Exit,
+		Cost:      rec.Cost,
+		Source:    rec.Source,
+	}
+	var err error
+	if run.State, err = parseRunState(rec.State); err != nil {
+		return Run{}, err
+	}
+	if run.Values, err = decodeValuesJSON(rec.Values); err != nil {
+		return Run{}, err
+	}
+	if rec.Config != nil {
+		if run.Config, err = decodeConfig(*rec.Config); err != nil {
+			return Run{}, err
+		}
+	}
+	for _, metrics := range rec.Metrics {
+		run.Metrics = append(run.Metrics, decodeMetricsJSON(metrics))
+	}
+	return run, nil
+}
+
+// MarshalStudy returns the versioned encoding of the provided study.
+// The study's Run and Acquire functions are not encoded.
+func MarshalStudy(study Study) ([]byte, error) {
+	rec := studyRecord{
+		Name:        study.Name,
+		Objective:   encodeObjective(study.Objective),
+		Replicates:  study.Replicates,
+		Fidelity:    study.Fidelity,
+		Description: study.Description,
+		Transfer:    study.Transfer,
+		Timeout:     study.Timeout,
+		MaxParallel: study.MaxParallel,
+		Stop: stopRecord{
+			Target:      study.Stop.Target,
+			MaxTrials:   study.Stop.MaxTrials,
+			MaxDuration: study.Stop.MaxDuration,
+			MaxRuntime:  study.Stop.MaxRuntime,
+			Patience:    study.Stop.Patience,
+		},
+		Archived: study.Archived,
+		Retention: retentionRecord{
+			FailedLogs: study.Retention.FailedLogs,
+			KeepBest:   study.Retention.KeepBest,
+		},
+	}
+	for _, obj := range study.Objectives {
+		rec.Objectives = append(rec.Objectives, encodeObjective(obj))
+	}
+	if len(study.Aggregate) > 0 {
+		rec.Aggregate = make(map[string]string, len(study.Aggregate))
+		for name, agg := range study.Aggregate {
+			rec.Aggregate[name] = agg.String()
+		}
+	}
+	for _, c := range study.Constraints {
+		rec.Constraints = append(rec.Constraints, c.String())
+	}
+	if len(study.Params) > 0 {
+		rec.Params = make(map[string]string, len(study.Params))
+		for name, param := range study.Params {
+			rec.Params[name] = fmt.Sprint(param)
+		}
+	}
+	var err error
+	rec.Gob, err = gobEncode(studyGob{study.Params, study.Oracle, study.Scheduler, study.Notifiers})
+	if err != nil {
+		return nil, err
+	}
+	return marshalRecord(rec)
+}
+
+// UnmarshalStudy decodes a study encoded by MarshalStudy, or by
+// legacy (gob-based) versions of diviner.
+func UnmarshalStudy(p []byte) (Study, error) {
+	var study Study
+	if !bytes.HasPrefix(p, encodingMagic) {
+		err := gob.NewDecoder(bytes.NewReader(p)).Decode(&study)
+		return study, err
+	}
+	var rec studyRecord
+	if err := unmarshalRecord(p, &rec); err != nil {
+		return Study{}, err
+	}
+	study = Study{
+		Name:        rec.Name,
+		Replicates:  rec.Replicates,
+		Fidelity:    rec.Fidelity,
+		Description: rec.Description,
+		Transfer:    rec.Transfer,
+		Timeout:     rec.Timeout,
+		MaxParallel: rec.MaxParallel,
+		Stop: StopConditions{
+			Target:      rec.Stop.Target,
+			MaxTrials:   rec.Stop.MaxTrials,
+			MaxDuration: rec.Stop.MaxDuration,
+			MaxRuntime:  rec.Stop.MaxRuntime,
+			Patience:    rec.Stop.Patience,
+		},
+		Archived: rec.Archived,
+		Retention: Retention{
+			FailedLogs: rec.Retention.FailedLogs,
+			KeepBest:   rec.Retention.KeepBest,
+		},
+	}
+	var err error
+	if study.Objective, err = decodeObjective(rec.Objective); err != nil {
+		return Study{}, err
+	}
+	for _, obj := range rec.Objectives {
+		o, err := decodeObjective(obj)
+		if err != nil {
+			return Study{}, err
+		}
+		study.Objectives = append(study.Objectives, o)
+	}
+	if len(rec.Aggregate) > 0 {
+		study.Aggregate = make(map[string]Aggregation, len(rec.Aggregate))
+		for name, s := range rec.Aggregate {
+			if study.Aggregate[name], err = ParseAggregation(s); err != nil {
+				return Study{}, err
+			}
+		}
+	}
+	for _, s := range rec.Constraints {
+		c, err := ParseConstraint(s)
+		if err != nil {
+			return Study{}, err
+		}
+		study.Constraints = append(study.Constraints, c)
+	}
+	if len(rec.Gob) > 0 {
+		var g studyGob
+		if err := gobDecode(rec.Gob, &g); err != nil {
+			return Study{}, err
+		}
+		study.Params, study.Oracle, study.Scheduler, study.Notifiers = g.Params, g.Oracle, g.Scheduler, g.Notifiers
+	}
+	return study, nil
+}
+
+type runRecord struct {
+	Study     string                     `json:"study"`
+	Seq       uint64                     `json:"seq"`
+	Replicate int                        `json:"replicate,omitempty"`
+	Values    map[string]json.RawMessage `json:"values,omitempty"`
+	State     string                     `json:"state"`
+	Status    string                     `json:"status,omitempty"`
+	Config    *configRecord              `json:"config,omitempty"`
+	Created   time.Time                  `json:"created"`
+	Updated   time.Time                  `json:"updated"`
+	Started   time.Time                  `json:"started"`
+	Completed time.Time                  `json:"completed"`
+	Runtime   time.Duration              `json:"runtime_ns,omitempty"`
+	Retries   int                        `json:"retries,omitempty"`
+	RetryOf   uint64                     `json:"retry_of,omitempty"`
+	Attempt   int                        `json:"attempt,omitempty"`
+	Parent    uint64                     `json:"parent,omitempty"`
+	Labels    Labels                     `json:"labels,omitempty"`
+	Artifacts []Artifact                 `json:"artifacts,omitempty"`
+	Exit      *RunExit                   `json:"exit,omitempty"`
+	Cost      *RunCost                   `json:"cost,omitempty"`
+	Source    *Source                    `json:"source,omitempty"`
+	Metrics   []map[string]jsonFloat     `json:"metrics,omitempty"`
+}
+
+type configRecord struct {
+	Datasets         []datasetRecord   `json:"datasets,omitempty"`
+	Script           string            `json:"script,omitempty"`
+	LocalFiles       []string          `json:"local_files,omitempty"`
+	Systems          []systemRecord    `json:"systems,omitempty"`
+	Resources        resourcesRecord   `json:"resources"`
+	Retry            retryRecord       `json:"retry"`
+	Checkpoint       checkpointRecord  `json:"checkpoint"`
+	Timeout          time.Duration     `json:"timeout_ns,omitempty"`
+	Image            string            `json:"image,omitempty"`
+	Env              map[string]string `json:"env,omitempty"`
+	Seed             int64             `json:"seed,omitempty"`
+	LocalFileDigests map[string]string `json:"local_file_digests,omitempty"`
+}
+
+type datasetRecord struct {
+	Name       string         `json:"name"`
+	IfNotExist string         `json:"if_not_exist,omitempty"`
+	Inputs     []string       `json:"inputs,omitempty"`
+	LocalFiles []string       `json:"local_files,omitempty"`
+	Script     string         `json:"script,omitempty"`
+	Systems    []systemRecord `json:"systems,omitempty"`
+}
+
+type systemRecord struct {
+	ID          string          `json:"id"`
+	Parallelism int             `json:"parallelism,omitempty"`
+	Preamble    string          `json:"preamble,omitempty"`
+	Resources   resourcesRecord `json:"resources"`
+	Spot        bool            `json:"spot,omitempty"`
+	// Gob is the gob encoding of the system's bigmachine system and
+	// backend.
+	Gob []byte `json:"gob,omitempty"`
+}
+
+type resourcesRecord struct {
+	CPU    int   `json:"cpu,omitempty"`
+	Memory int64 `json:"memory,omitempty"`
+	GPU    int   `json:"gpu,omitempty"`
+}
+
+type retryRecord struct {
+	MaxAttempts    int           `json:"max_attempts,omitempty"`
+	Backoff        time.Duration `json:"backoff_ns,omitempty"`
+	MaxBackoff     time.Duration `json:"max_backoff_ns,omitempty"`
+	Retryable      []string      `json:"retryable,omitempty"`
+	RetryTimeouts  bool          `json:"retry_timeouts,omitempty"`
+	ResourceScale  float64       `json:"resource_scale,omitempty"`
+	MaxPreemptions int           `json:"max_preemptions,omitempty"`
+}
+
+type checkpointRecord struct {
+	Path     string        `json:"path,omitempty"`
+	URL      string        `json:"url,omitempty"`
+	Interval time.Duration `json:"interval_ns,omitempty"`
+}
+
+type studyRecord struct {
+	Name        string            `json:"name"`
+	Objective   objectiveRecord   `json:"objective"`
+	Objectives  []objectiveRecord `json:"objectives,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Replicates  int               `json:"replicates,omitempty"`
+	Aggregate   map[string]string `json:"aggregate,omitempty"`
+	Constraints []string          `json:"constraints,omitempty"`
+	Fidelity    *Fidelity         `json:"fidelity,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Transfer    []string          `json:"transfer,omitempty"`
+	Timeout     time.Duration     `json:"timeout_ns,omitempty"`
+	MaxParallel int               `json:"max_parallel,omitempty"`
+	Stop        stopRecord        `json:"stop"`
+	Archived    bool              `json:"archived,omitempty"`
+	Retention   retentionRecord   `json:"retention"`
+	// Gob is the gob encoding of the study's parameters, oracle,
+	// scheduler, and notifiers (see studyGob).
+	Gob []byte `json:"gob,omitempty"`
+}
+
+type objectiveRecord struct {
+	Direction string `json:"direction"`
+	Metric    string `json:"metric"`
+}
+
+type retentionRecord struct {
+	FailedLogs time.Duration `json:"failed_logs_ns,omitempty"`
+	KeepBest   int           `json:"keep_best,omitempty"`
+}
+
+type stopRecord struct {
+	Target      *float64      `json:"target,omitempty"`
+	MaxTrials   int           `json:"max_trials,omitempty"`
+	MaxDuration time.Duration `json:"max_duration_ns,omitempty"`
+	MaxRuntime  time.Duration `json:"max_runtime_ns,omitempty"`
+	Patience    int           `json:"patience,omitempty"`
+}
+
+// StudyGob holds the parts of a study that are encoded with gob.
+type studyGob struct {
+	Params    Params
+	Oracle    Oracle
+	Scheduler Scheduler
+	Notifiers []Notifier
+}
+
+// SystemGob holds the parts of a system that are encoded with gob.
+type systemGob struct {
+	System  bigmachine.System
+	Backend Backend
+}
+
+// JSONFloat is a float64 whose JSON encoding represents non-finite
+// values as strings.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	switch v := float64(f); {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Infinity"`), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+func (f *jsonFloat) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil {
+		return json.Unmarshal(p, (*float64)(f))
+	}
+	switch s {
+	case "NaN":
+		*f = jsonFloat(math.NaN())
+	case "Infinity":
+		*f = jsonFloat(math.Inf(1))
+	case "-Infinity":
+		*f = jsonFloat(math.Inf(-1))
+	default:
+		return fmt.Errorf("encoding: invalid float %q", s)
+	}
+	return nil
+}
+
+func marshalRecord(rec interface{}) ([]byte, error) {
+	p, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(append([]byte{}, encodingMagic...), encodingV2), p...), nil
+}
+
+func unmarshalRecord(p []byte, rec interface{}) error {
+	p = p[len(encodingMagic):]
+	if len(p) == 0 {
+		return errShortBuffer
+	}
+	if p[0] != encodingV2 {
+		return fmt.Errorf("encoding: unsupported record encoding version %d", p[0])
+	}
+	return json.Unmarshal(p[1:], rec)
+}
+
+func encodeValuesJSON(values Values) (map[string]json.RawMessage, error) {
+	if values == nil {
+		return nil, nil
+	}
+	m := make(map[string]json.RawMessage, len(values))
+	for name, v := range values {
+		p, err := encodeValueJSON(v)
+		if err != nil {
+			return nil, err
+		}
+		m[name] = p
+	}
+	return m, nil
+}
+
+func encodeValueJSON(v Value) (json.RawMessage, error) {
+	var (
+		kind string
+		elem interface{}
+	)
+	switch v := v.(type) {
+	case Int:
+		kind, elem = "int", int64(v)
+	case Float:
+		kind, elem = "float", jsonFloat(v)
+	case String:
+		kind, elem = "string", string(v)
+	case Bool:
+		kind, elem = "bool", bool(v)
+	case Duration:
+		kind, elem = "duration", v.String()
+	case Time:
+		kind, elem = "time", v.String()
+	case List:
+		list := make([]json.RawMessage, len(v))
+		for i := range v {
+			var err error
+			if list[i], err = encodeValueJSON(v[i]); err != nil {
+				return nil, err
+			}
+		}
+		kind, elem = "list", list
+	case Values:
+		dict, err := encodeValuesJSON(v)
+		if err != nil {
+			return nil, err
+		}
+		if dict == nil {
+			dict = map[string]json.RawMessage{}
+		}
+		kind, elem = "dict", dict
+	case *Values:
+		return encodeValueJSON(*v)
+	default:
+		return nil, fmt.Errorf("encoding: cannot encode value %v of type %T", v, v)
+	}
+	return json.Marshal(map[string]interface{}{kind: elem})
+}
+
+func decodeValuesJSON(m map[string]json.RawMessage) (Values, error) {
+	if m == nil {
+		return nil, nil
+	}
+	values := make(Values, len(m))
+	for name, p := range m {
+		v, err := decodeValueJSON(p)
+		if err != nil {
+			return nil, fmt.Errorf("value %s: %v", name, err)
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+func decodeValueJSON(p json.RawMessage) (Value, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(p, &m); err != nil {
+		return nil, err
+	}
+	if len(m) != 1 {
+		return nil, fmt.Errorf("encoding: invalid value %s", p)
+	}
+	for kind, p := range m {
+		switch kind {
+		case "int":
+			var v int64
+			err := json.Unmarshal(p, &v)
+			return Int(v), err
+		case "float":
+			var v jsonFloat
+			err := json.Unmarshal(p, &v)
+			return Float(v), err
+		case "string":
+			var v string
+			err := json.Unmarshal(p, &v)
+			return String(v), err
+		case "bool":
+			var v bool
+			err := json.Unmarshal(p, &v)
+			return Bool(v), err
+		case "duration":
+			var s string
+			if err := json.Unmarshal(p, &s); err != nil {
+				return nil, err
+			}
+			return ParseDuration(s)
+		case "time":
+			var s string
+			if err := json.Unmarshal(p, &s); err != nil {
+				return nil, err
+			}
+			return ParseTime(s)
+		case "list":
+			var elems []json.RawMessage
+			if err := json.Unmarshal(p, &elems); err != nil {
+				return nil, err
+			}
+			list := make(List, len(elems))
+			for i, elem := range elems {
+				var err error
+				if list[i], err = decodeValueJSON(elem); err != nil {
+					return nil, err
+				}
+			}
+			return list, nil
+		case "dict":
+			var elems map[string]json.RawMessage
+			if err := json.Unmarshal(p, &elems); err != nil {
+				return nil, err
+			}
+			return decodeValuesJSON(elems)
+		default:
+			return nil, fmt.Errorf("encoding: invalid value kind %s", kind)
+		}
+	}
+	panic("not reached")
+}
+
+func encodeMetricsJSON(metrics Metrics) map[string]jsonFloat {
+	m := make(map[string]jsonFloat, len(metrics))
+	for name, v := range metrics {
+		m[name] = jsonFloat(v)
+	}
+	return m
+}
+
+func decodeMetricsJSON(m map[string]jsonFloat) Metrics {
+	metrics := make(Metrics, len(m))
+	for name, v := range m {
+		metrics[name] = float64(v)
+	}
+	return metrics
+}
+
+func encodeConfig(config RunConfig) (*configRecord, error) {
+	rec := &configRecord{
+		Script:     config.Script,
+		LocalFiles: config.LocalFiles,
+		Resources:  encodeResources(config.Resources),
+		Retry: retryRecord{
+			MaxAttempts:    config.Retry.MaxAttempts,
+			Backoff:        config.Retry.Backoff,
+			MaxBackoff:     config.Retry.MaxBackoff,
+			Retryable:      config.Retry.Retryable,
+			RetryTimeouts:  config.Retry.RetryTimeouts,
+			ResourceScale:  config.Retry.ResourceScale,
+			MaxPreemptions: config.Retry.MaxPreemptions,
+		},
+		Checkpoint: checkpointRecord{
+			Path:     config.Checkpoint.Path,
+			URL:      config.Checkpoint.URL,
+			Interval: config.Checkpoint.Interval,
+		},
+		Timeout:          config.Timeout,
+		Image:            config.Image,
+		Env:              config.Env,
+		Seed:             config.Seed,
+		LocalFileDigests: config.LocalFileDigests,
+	}
+	var err error
+	if rec.Systems, err = encodeSystems(config.Systems); err != nil {
+		return nil, err
+	}
+	for _, dataset := range config.Datasets {
+		d := datasetRecord{
+			Name:       dataset.Name,
+			IfNotExist: dataset.IfNotExist,
+			Inputs:     dataset.Inputs,
+			LocalFiles: dataset.LocalFiles,
+			Script:     dataset.Script,
+		}
+		if d.Systems, err = encodeSystems(dataset.Systems); err != nil {
+			return nil, err
+		}
+		rec.Datasets = append(rec.Datasets, d)
+	}
+	return rec, nil
+}
+
+func decodeConfig(rec configRecord) (RunConfig, error) {
+	config := RunConfig{
+		Script:     rec.Script,
+		LocalFiles: rec.LocalFiles,
+		Resources:  decodeResources(rec.Resources),
+		Retry: RetryPolicy{
+			MaxAttempts:    rec.Retry.MaxAttempts,
+			Backoff:        rec.Retry.Backoff,
+			MaxBackoff:     rec.Retry.MaxBackoff,
+			Retryable:      rec.Retry.Retryable,
+			RetryTimeouts:  rec.Retry.RetryTimeouts,
+			ResourceScale:  rec.Retry.ResourceScale,
+			MaxPreemptions: rec.Retry.MaxPreemptions,
+		},
+		Checkpoint: Checkpoint{
+			Path:     rec.Checkpoint.Path,
+			URL:      rec.Checkpoint.URL,
+			Interval: rec.Checkpoint.Interval,
+		},
+		Timeout:          rec.Timeout,
+		Image:            rec.Image,
+		Env:              rec.Env,
+		Seed:             rec.Seed,
+		LocalFileDigests: rec.LocalFileDigests,
+	}
+	var err error
+	if config.Systems, err = decodeSystems(rec.Systems); err != nil {
+		return RunConfig{}, err
+	}
+	for _, d := range rec.Datasets {
+		dataset := Dataset{
+			Name:       d.Name,
+			IfNotExist: d.IfNotExist,
+			Inputs:     d.Inputs,
+			LocalFiles: d.LocalFiles,
+			Script:     d.Script,
+		}
+		if dataset.Systems, err = decodeSystems(d.Systems); err != nil {
+			return RunConfig{}, err
+		}
+		config.Datasets = append(config.Datasets, dataset)
+	}
+	return config, nil
+}
+
+func encodeSystems(systems []*System) ([]systemRecord, error) {
+	var recs []systemRecord
+	for _, sys := range systems {
+		rec := systemRecord{
+			ID:          sys.ID,
+			Parallelism: sys.Parallelism,
+			Preamble:    sys.Preamble,
+			Resources:   encodeResources(sys.Resources),
+			Spot:        sys.Spot,
+		}
+		if sys.System != nil || sys.Backend != nil {
+			var err error
+			if rec.Gob, err = gobEncode(systemGob{sys.System, sys.Backend}); err != nil {
+				return nil, fmt.Errorf("system %s: %v", sys.ID, err)
+			}
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+func decodeSystems(recs []systemRecord) ([]*System, error) {
+	var systems []*System
+	for _, rec := range recs {
+		sys := &System{
+			ID:          rec.ID,
+			Parallelism: rec.Parallelism,
+			Preamble:    rec.Preamble,
+			Resources:   decodeResources(rec.Resources),
+			Spot:        rec.Spot,
+		}
+		if len(rec.Gob) > 0 {
+			var g systemGob
+			if err := gobDecode(rec.Gob, &g); err != nil {
+				return nil, fmt.Errorf("system %s: %v", rec.ID, err)
+			}
+			sys.System, sys.Backend = g.System, g.Backend
+		}
+		systems = append(systems, sys)
+	}
+	return systems, nil
+}
+
+func encodeResources(r Resources) resourcesRecord {
+	return resourcesRecord{CPU: r.CPU, Memory: int64(r.Memory), GPU: r.GPU}
+}
+
+func decodeResources(rec resourcesRecord) Resources {
+	return Resources{CPU: rec.CPU, Memory: data.Size(rec.Memory), GPU: rec.GPU}
+}
+
+func encodeObjective(obj Objective) objectiveRecord {
+	return objectiveRecord{Direction: obj.Direction.String(), Metric: obj.Metric}
+}
+
+func decodeObjective(rec objectiveRecord) (Objective, error) {
+	obj := Objective{Metric: rec.Metric}
+	switch rec.Direction {
+	case "maximize":
+		obj.Direction = Maximize
+	case "minimize":
+		obj.Direction = Minimize
+	default:
+		return Objective{}, fmt.Errorf("encoding: invalid objective direction %q", rec.Direction)
+	}
+	return obj, nil
+}
+
+var errInvalidRunState = errors.New("encoding: invalid run state")
+
+func parseRunState(s string) (RunState, error) {
+	for _, state := range []RunState{Pending, Success, Failure, TimedOut, Preempted} {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+	if s == "unknown" {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%v %q", errInvalidRunState, s)
+}
+
+func gobEncode(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func gobDecode(p []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(p)).Decode(v)
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func testRun() diviner.Run {
+	now := time.Unix(1570000000, 0).UTC()
+	return diviner.Run{
+		Study:     "test",
+		Seq:       3,
+		Replicate: 1,
+		Values:    testValues,
+		State:     diviner.Success,
+		Status:    "done",
+		Config: diviner.RunConfig{
+			Datasets: []diviner.Dataset{{Name: "data", Script: "echo data"}},
+			Script:   "echo run",
+			Systems:  []*diviner.System{{ID: "local", Parallelism: 2}},
+			Retry:    diviner.RetryPolicy{MaxAttempts: 3, Backoff: time.Second},
+			Env:      map[string]string{"LEARNING_RATE": "0.1"},
+			Seed:     12345,
+		},
+		Created:   now,
+		Updated:   now.Add(time.Minute),
+		Started:   now,
+		Completed: now.Add(time.Minute),
+		Runtime:   time.Minute,
+		Attempt:   2,
+		RetryOf:   1,
+		Parent:    2,
+		Labels:    diviner.Labels{"owner": "test"},
+		Artifacts: []diviner.Artifact{{Name: "model", URL: "s3://bucket/model"}},
+		Exit:      &diviner.RunExit{Code: 1},
+		Source:    &diviner.Source{Commit: "0123456789abcdef", Dirty: true, Diff: "+x\n", StudyDigest: "fedcba"},
+		Metrics: []diviner.Metrics{
+			{"acc": 0.5},
+			{"acc": 0.9, "loss": math.Inf(1), "lower": math.Inf(-1)},
+		},
+	}
+}
+
+func TestRunRecord(t *testing.T) {
+	run := testRun()
+	p, err := diviner.MarshalRun(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p[:4], []byte{0, 'd', 'v', 2}; !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(p[4:], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := doc["state"], "success"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := doc["values"].(map[string]interface{})["int"], map[string]interface{}{"int": float64(-123)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := doc["metrics"].([]interface{})[1].(map[string]interface{})["loss"], "Infinity"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	decoded, err := diviner.UnmarshalRun(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(decoded.Config.Systems), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := decoded.Config.Systems[0].ID, "local"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := decoded.Config.Systems[0].Parallelism, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !decoded.Values.Equal(run.Values) {
+		t.Errorf("got %v, want %v", decoded.Values, run.Values)
+	}
+	// Systems are compared above; values are compared with Equal.
+	decoded.Config.Systems, run.Config.Systems = nil, nil
+	decoded.Values, run.Values = nil, nil
+	if !reflect.DeepEqual(decoded, run) {
+		t.Errorf("got %+v, want %+v", decoded, run)
+	}
+}
+
+func TestRunRecordNaN(t *testing.T) {
+	run := diviner.Run{
+		Study:   "test",
+		Values:  diviner.Values{"x": diviner.Float(math.NaN())},
+		Metrics: []diviner.Metrics{{"loss": math.NaN()}},
+	}
+	p, err := diviner.MarshalRun(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := diviner.UnmarshalRun(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Values["x"].Float(); !math.IsNaN(got) {
+		t.Errorf("got %v, want NaN", got)
+	}
+	if got := decoded.Metrics[0]["loss"]; !math.IsNaN(got) {
+		t.Errorf("got %v, want NaN", got)
+	}
+}
+
+func TestStudyRecord(t *testing.T) {
+	target := 0.95
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"lr":     diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1)),
+			"layers": diviner.NewDiscrete(diviner.Int(1), diviner.Int(2)),
+		},
+		Objective:   diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+		Objectives:  []diviner.Objective{{Direction: diviner.Minimize, Metric: "loss"}},
+		Replicates:  2,
+		Aggregate:   map[string]diviner.Aggregation{"acc": {Reduce: diviner.ReduceMean, Window: 3}},
+		Constraints: []diviner.Constraint{{Metric: "latency_ms", Op: "<", Value: 50}},
+		Description: "a test study",
+		Timeout:     time.Hour,
+		MaxParallel: 4,
+		Stop:        diviner.StopConditions{Target: &target, MaxTrials: 10},
+		Archived:    true,
+		Retention:   diviner.Retention{FailedLogs: 30 * 24 * time.Hour, KeepBest: 5},
+	}
+	p, err := diviner.MarshalStudy(study)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(p[4:], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := doc["objective"], map[string]interface{}{"direction": "maximize", "metric": "acc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := doc["aggregate"], map[string]interface{}{"acc": "mean:3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := doc["constraints"], []interface{}{"latency_ms < 50"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := doc["params"].(map[string]interface{})["lr"].(string); !ok {
+		t.Errorf("missing textual parameter: %v", doc["params"])
+	}
+	decoded, err := diviner.UnmarshalStudy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, study) {
+		t.Errorf("got %+v, want %+v", decoded, study)
+	}
+}
+
+func TestRecordLegacy(t *testing.T) {
+	run := diviner.Run{Study: "test", Seq: 1, State: diviner.Failure, Values: diviner.Values{"x": diviner.Int(1)}}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(run); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := diviner.UnmarshalRun(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decoded, run; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	study := diviner.Study{Name: "test", Objective: diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}}
+	b.Reset()
+	if err := gob.NewEncoder(&b).Encode(study); err != nil {
+		t.Fatal(err)
+	}
+	decodedStudy, err := diviner.UnmarshalStudy(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decodedStudy, study; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRecordNewerVersion(t *testing.T) {
+	if _, err := diviner.UnmarshalRun([]byte{0, 'd', 'v', 99, '{', '}'}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"sort"
+	"sync"
+)
+
+var registry struct {
+	sync.Mutex
+	studies map[string]Study
+}
+
+// Register registers the provided study so that it may be discovered
+// by tools, such as the diviner command line interface (package
+// github.com/grailbio/diviner/cli), built into the same binary. This
+// permits studies to be defined directly in Go instead of in
+// Starlark. Register is typically called from an init function.
+//
+// Register panics if the study is invalid or if a study with the
+// same name has already been registered.
+func Register(study Study) {
+	if study.Name == "" {
+		panic("diviner.Register: study has no name")
+	}
+	if study.Run == nil && study.Acquire == nil {
+		panic("diviner.Register: study " + study.Name + " has neither a run nor an acquire function")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.studies == nil {
+		registry.studies = make(map[string]Study)
+	}
+	if _, ok := registry.studies[study.Name]; ok {
+		panic("diviner.Register: study " + study.Name + " registered twice")
+	}
+	registry.studies[study.Name] = study
+}
+
+// Registered returns the studies registered by Register, ordered by
+// name.
+func Registered() []Study {
+	registry.Lock()
+	defer registry.Unlock()
+	studies := make([]Study, 0, len(registry.studies))
+	for _, study := range registry.studies {
+		studies = append(studies, study)
+	}
+	sort.Slice(studies, func(i, j int) bool { return studies[i].Name < studies[j].Name })
+	return studies
+}
+
+// LookupRegistered returns the registered study with the provided
+// name.
+func LookupRegistered(name string) (study Study, ok bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	study, ok = registry.studies[name]
+	return
+}
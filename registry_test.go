@@ -0,0 +1,51 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestRegister(t *testing.T) {
+	run := func(diviner.Values, int, string) (diviner.RunConfig, error) {
+		return diviner.RunConfig{Script: "true"}, nil
+	}
+	diviner.Register(diviner.Study{Name: "registry_b", Run: run})
+	diviner.Register(diviner.Study{Name: "registry_a", Run: run})
+
+	var names []string
+	for _, study := range diviner.Registered() {
+		names = append(names, study.Name)
+	}
+	if got, want := len(names), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := names[0], "registry_a"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := diviner.LookupRegistered("registry_b"); !ok {
+		t.Error("study registry_b not found")
+	}
+	if _, ok := diviner.LookupRegistered("registry_c"); ok {
+		t.Error("study registry_c found")
+	}
+
+	for _, study := range []diviner.Study{
+		{Name: "registry_a", Run: run},
+		{Name: "registry_c"},
+		{Run: run},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected panic", study.Name)
+				}
+			}()
+			diviner.Register(study)
+		}()
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/analysis"
+)
+
+// HTMLOptions configures the reports written by WriteHTML.
+type HTMLOptions struct {
+	// Objective overrides the study's objective if its metric is
+	// nonempty.
+	Objective diviner.Objective
+	// Top is the number of best trials listed in the report. If it is
+	// not positive, ten trials are listed.
+	Top int
+	// Failures is the number of most recent failed runs listed in the
+	// report. If it is not positive, ten runs are listed.
+	Failures int
+}
+
+// WriteHTML writes a report of the named study to w as a
+// self-contained HTML page: its scripts and styles are embedded, so
+// that the page may be viewed offline, or shared as a single file.
+// The report comprises the study's definition and run counts; its
+// best trials according to the objective, together with the sources
+// (see diviner.Source) of their runs; a chart of the objective
+// reported by each successful run over time, together with the best
+// value attained so far; the importance of each parameter to the
+// objective (see analysis.ParamImportance); a parallel coordinates
+// plot of the successful trials' parameter values and objective; and
+// a summary of the study's failed runs, grouped by the reasons for
+// their failure.
+func WriteHTML(ctx context.Context, db diviner.Database, w io.Writer, study string, opts HTMLOptions) error {
+	s, err := db.LookupStudy(ctx, study)
+	if err != nil {
+		return err
+	}
+	objective := s.Objective
+	if opts.Objective.Metric != "" {
+		objective = opts.Objective
+	}
+	if opts.Top <= 0 {
+		opts.Top = 10
+	}
+	if opts.Failures <= 0 {
+		opts.Failures = 10
+	}
+	runs, err := db.ListRuns(ctx, study, diviner.Any, time.Time{})
+	if err != nil && err != diviner.ErrNotExist {
+		return err
+	}
+	best, err := Best(ctx, db, study, objective, opts.Top)
+	if err != nil {
+		return err
+	}
+	m, err := diviner.Trials(ctx, db, s, diviner.Success)
+	if err != nil {
+		return err
+	}
+	var trials []diviner.Trial
+	m.Range(func(_ diviner.Value, v interface{}) {
+		trials = append(trials, v.(diviner.Trial))
+	})
+	sort.SliceStable(trials, func(i, j int) bool {
+		return firstSeq(trials[i]) < firstSeq(trials[j])
+	})
+
+	page := htmlPage{
+		Study:     s,
+		Objective: objective,
+		Generated: time.Now().UTC().Format(time.RFC3339),
+		States:    make(map[string]int),
+		Table:     analysis.NewTable(s.Params, trials),
+	}
+	page.Convergence = diviner.NewConvergence(s, objective, runs)
+	if point, ok := page.Convergence.Best(); ok {
+		page.BestPoint = &point
+	}
+	for _, point := range page.Convergence.Points {
+		page.Progress = append(page.Progress, htmlPoint{
+			Time:  point.Completed.UnixNano() / int64(time.Millisecond),
+			Value: point.Value,
+			Run:   fmt.Sprintf("%s:%d", s.Name, point.Seq),
+		})
+	}
+	for _, param := range s.Params.Sorted() {
+		page.Params = append(page.Params, param.Name)
+	}
+	for _, trial := range best {
+		page.Best = append(page.Best, newHTMLTrial(trial, page.Params))
+	}
+	if len(best) > 0 {
+		for _, metric := range best[0].Metrics.Sorted() {
+			page.Metrics = append(page.Metrics, metric.Name)
+		}
+	}
+	if page.Importance, err = analysis.ParamImportance(trials, objective); err != nil {
+		page.ImportanceError = err.Error()
+	}
+	failures := make(map[string]*htmlFailure)
+	for _, run := range runs {
+		page.States[run.State.String()]++
+		switch run.State {
+		case diviner.Failure, diviner.TimedOut:
+			reason := failureReason(run)
+			f := failures[reason]
+			if f == nil {
+				f = &htmlFailure{Reason: reason}
+				failures[reason] = f
+			}
+			f.Count++
+			page.Failed = append(page.Failed, run)
+		}
+	}
+	for _, f := range failures {
+		page.Failures = append(page.Failures, *f)
+	}
+	sort.Slice(page.Failures, func(i, j int) bool {
+		if page.Failures[i].Count != page.Failures[j].Count {
+			return page.Failures[i].Count > page.Failures[j].Count
+		}
+		return page.Failures[i].Reason < page.Failures[j].Reason
+	})
+	sort.Slice(page.Failed, func(i, j int) bool {
+		return page.Failed[i].Updated.After(page.Failed[j].Updated)
+	})
+	if len(page.Failed) > opts.Failures {
+		page.Failed = page.Failed[:opts.Failures]
+	}
+	return htmlTemplate.Execute(w, page)
+}
+
+// FailureReason returns a one-line description of the reason for
+// which the provided run failed.
+func failureReason(run diviner.Run) string {
+	var reason string
+	switch {
+	case run.State == diviner.TimedOut:
+		reason = "timed out"
+	case run.Exit != nil:
+		reason = run.Exit.String()
+	default:
+		reason = run.Status
+	}
+	if i := strings.IndexByte(reason, '\n'); i >= 0 {
+		reason = reason[:i]
+	}
+	if reason == "" {
+		reason = "unknown"
+	}
+	return reason
+}
+
+// HtmlPage is the data from which an HTML report is rendered.
+type htmlPage struct {
+	Study           diviner.Study
+	Objective       diviner.Objective
+	Generated       string
+	States          map[string]int
+	Params          []string
+	Metrics         []string
+	Best            []htmlTrial
+	Importance      []analysis.Importance
+	ImportanceError string
+	Convergence     diviner.Convergence
+	BestPoint       *diviner.ConvergencePoint
+	Progress        []htmlPoint
+	Table           *analysis.Table
+	Failures        []htmlFailure
+	Failed          []diviner.Run
+}
+
+// HtmlTrial is a trial listed in an HTML report.
+type htmlTrial struct {
+	Runs    string
+	Source  string
+	Values  []string
+	Metrics diviner.Metrics
+}
+
+func newHTMLTrial(trial diviner.Trial, params []string) htmlTrial {
+	t := htmlTrial{Metrics: trial.Metrics}
+	var (
+		ids     = make([]string, len(trial.Runs))
+		sources []string
+		seen    = make(map[string]bool)
+	)
+	for i, run := range trial.Runs {
+		ids[i] = run.ID()
+		if run.Source == nil {
+			continue
+		}
+		if source := run.Source.String(); !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+	t.Runs = strings.Join(ids, ", ")
+	t.Source = strings.Join(sources, ", ")
+	for _, name := range params {
+		if v, ok := trial.Values[name]; ok {
+			t.Values = append(t.Values, v.String())
+		} else {
+			t.Values = append(t.Values, "")
+		}
+	}
+	return t
+}
+
+// HtmlPoint is a point in the objective-over-time chart. Times are
+// in milliseconds since the Unix epoch, as in JavaScript.
+type htmlPoint struct {
+	Time  int64   `json:"t"`
+	Value float64 `json:"v"`
+	Run   string  `json:"run"`
+}
+
+// HtmlFailure is a group of failed runs that failed for the same
+// reason.
+type htmlFailure struct {
+	Reason string
+	Count  int
+}
+
+var htmlFuncs = template.FuncMap{
+	"metric": func(m diviner.Metrics, name string) string {
+		v, ok := m[name]
+		if !ok {
+			return ""
+		}
+		return formatFloat(v)
+	},
+	"float": formatFloat,
+	"percent": func(v float64) string {
+		return strconv.FormatFloat(100*v, 'f', 1, 64) + "%"
+	},
+	"maximize": func(obj diviner.Objective) bool {
+		return obj.Direction == diviner.Maximize
+	},
+}
+
+// FormatFloat formats v with up to 6 significant digits, which
+// suffices for reports.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
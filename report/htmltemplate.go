@@ -0,0 +1,188 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package report
+
+import "html/template"
+
+var htmlTemplate = template.Must(template.New("report").Funcs(htmlFuncs).Parse(htmlSource))
+
+// HtmlSource is the template of HTML reports. Charts are drawn as
+// SVG by the embedded script, from data that are embedded as JSON,
+// so that reports have no external dependencies.
+const htmlSource = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>diviner study {{.Study.Name}}</title>
+<style>
+body { font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; margin: 2em auto; max-width: 1000px; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; border-bottom: 1px solid #ddd; padding-bottom: 0.2em; margin-top: 2em; }
+table { border-collapse: collapse; font-size: 0.9em; }
+th, td { text-align: left; padding: 0.2em 0.8em 0.2em 0; vertical-align: top; }
+th { border-bottom: 1px solid #ccc; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.muted { color: #888; }
+.bar { background: #4a7bd0; height: 0.8em; display: inline-block; }
+pre { font-size: 0.8em; margin: 0; white-space: pre-wrap; }
+svg text { font-size: 11px; fill: #444; }
+svg .axis { stroke: #999; }
+</style>
+</head>
+<body>
+<h1>Study {{.Study.Name}}</h1>
+{{with .Study.Description}}<p>{{.}}</p>{{end}}
+<p class="muted">Generated {{.Generated}}.</p>
+<table>
+<tr><th>objective</th><td>{{.Objective}}</td></tr>
+{{range $_, $p := .Study.Params.Sorted}}<tr><th>{{$p.Name}}</th><td>{{$p.Param}}</td></tr>
+{{end}}<tr><th>runs</th><td>{{range $state, $n := .States}}{{$state}}: {{$n}} {{else}}none{{end}}</td></tr>
+</table>
+
+<h2>Best trials</h2>
+{{if .Best}}<table>
+<tr><th>#</th><th>runs</th><th>source</th>{{range .Params}}<th>{{.}}</th>{{end}}{{range .Metrics}}<th>{{.}}</th>{{end}}</tr>
+{{range $i, $t := .Best}}<tr><td class="num">{{$i}}</td><td>{{$t.Runs}}</td><td>{{$t.Source}}</td>{{range $t.Values}}<td>{{.}}</td>{{end}}{{range $.Metrics}}<td class="num">{{metric $t.Metrics .}}</td>{{end}}</tr>
+{{end}}</table>
+{{else}}<p class="muted">No successful trials report {{.Objective.Metric}}.</p>{{end}}
+
+<h2>{{.Objective.Metric}} over time</h2>
+{{with .BestPoint}}<p>The best {{$.Objective.Metric}}, {{float .Best}}, was attained by run {{$.Study.Name}}:{{.Seq}} after {{.Runs}} of {{$.Convergence.Runs}} successful runs; {{$.Convergence.Stalled}} runs have completed since.</p>{{end}}
+<div id="progress">{{if not .Progress}}<p class="muted">No successful runs report {{.Objective.Metric}}.</p>{{end}}</div>
+
+<h2>Parameter importance</h2>
+{{if .Importance}}<table>
+<tr><th>param</th><th>importance</th><th></th><th>mse increase</th></tr>
+{{range .Importance}}<tr><td>{{.Param}}</td><td class="num">{{percent .Importance}}</td><td><span class="bar" style="width: {{percent .Importance}}"></span></td><td class="num">{{printf "%.3f" .MSEIncrease}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">{{.ImportanceError}}</p>{{end}}
+
+<h2>Parallel coordinates</h2>
+<div id="parallel">{{if not .Table.Rows}}<p class="muted">No successful trials.</p>{{end}}</div>
+
+<h2>Failures</h2>
+{{if .Failures}}<table>
+<tr><th>runs</th><th>reason</th></tr>
+{{range .Failures}}<tr><td class="num">{{.Count}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+<p>Most recent failures:</p>
+<table>
+<tr><th>run</th><th>state</th><th>updated</th><th>status</th></tr>
+{{range .Failed}}<tr><td>{{.ID}}</td><td>{{.State}}</td><td>{{.Updated.UTC.Format "2006-01-02 15:04:05"}}</td><td>{{.Status}}{{with .Exit}}<br>{{.}}{{with .Stderr}}<pre>{{range .}}{{.}}
+{{end}}</pre>{{end}}{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">No runs failed.</p>{{end}}
+
+<script>
+(function() {
+var progress = {{.Progress}};
+var table = {{.Table}};
+var objective = {{.Objective.Metric}};
+var maximize = {{maximize .Objective}};
+var ns = "http://www.w3.org/2000/svg";
+
+function el(name, attrs, parent) {
+	var e = document.createElementNS(ns, name);
+	for (var k in attrs) e.setAttribute(k, attrs[k]);
+	if (parent) parent.appendChild(e);
+	return e;
+}
+function text(s, attrs, parent) {
+	var t = el("text", attrs, parent);
+	t.textContent = s;
+	return t;
+}
+function fmt(v) { return Number(v.toPrecision(4)).toString(); }
+function extent(vs) {
+	var lo = Infinity, hi = -Infinity;
+	vs.forEach(function(v) { if (v !== null) { lo = Math.min(lo, v); hi = Math.max(hi, v); } });
+	if (lo === hi) { lo -= 0.5; hi += 0.5; }
+	return [lo, hi];
+}
+function scale(dom, lo, hi, log) {
+	if (log && dom[0] > 0) {
+		var a = Math.log(dom[0]), b = Math.log(dom[1]);
+		return function(v) { return lo + (Math.log(v) - a) / (b - a) * (hi - lo); };
+	}
+	return function(v) { return lo + (v - dom[0]) / (dom[1] - dom[0]) * (hi - lo); };
+}
+function color(u) {
+	// From blue (worst) to red (best).
+	var r = Math.round(40 + 200 * u), b = Math.round(220 - 180 * u);
+	return "rgb(" + r + ",80," + b + ")";
+}
+
+if (progress && progress.length) {
+	var W = 960, H = 300, L = 60, R = 20, T = 10, B = 40;
+	var svg = el("svg", {width: W, height: H}, document.getElementById("progress"));
+	var x = scale(extent(progress.map(function(p) { return p.t; })), L, W - R);
+	var y = scale(extent(progress.map(function(p) { return p.v; })), H - B, T);
+	el("line", {x1: L, y1: H - B, x2: W - R, y2: H - B, "class": "axis"}, svg);
+	el("line", {x1: L, y1: T, x2: L, y2: H - B, "class": "axis"}, svg);
+	var times = extent(progress.map(function(p) { return p.t; }));
+	text(new Date(times[0]).toISOString().slice(0, 16).replace("T", " "), {x: L, y: H - B + 16}, svg);
+	text(new Date(times[1]).toISOString().slice(0, 16).replace("T", " "), {x: W - R, y: H - B + 16, "text-anchor": "end"}, svg);
+	var vals = extent(progress.map(function(p) { return p.v; }));
+	text(fmt(vals[0]), {x: L - 6, y: H - B, "text-anchor": "end"}, svg);
+	text(fmt(vals[1]), {x: L - 6, y: T + 10, "text-anchor": "end"}, svg);
+	text(objective, {x: L - 6, y: T + 24, "text-anchor": "end"}, svg);
+	var best = null, path = "";
+	progress.forEach(function(p) {
+		if (best === null || (maximize ? p.v > best : p.v < best)) {
+			path += (best === null ? "M" : "H" + x(p.t) + "V") + (best === null ? x(p.t) + "," + y(p.v) : y(p.v));
+			best = p.v;
+		}
+		var c = el("circle", {cx: x(p.t), cy: y(p.v), r: 3, fill: "#4a7bd0", "fill-opacity": 0.6}, svg);
+		el("title", {}, c).textContent = p.run + ": " + fmt(p.v);
+	});
+	path += "H" + (W - R);
+	el("path", {d: path, fill: "none", stroke: "#d04a4a", "stroke-width": 2}, svg);
+}
+
+if (table && table.rows) {
+	var cols = table.columns.filter(function(c) { return c.source === "param"; });
+	var obj = table.columns.filter(function(c) { return c.source === "metric" && c.name === objective; })[0];
+	if (obj) cols.push(obj);
+	var W = 960, H = 360, T = 30, B = 30, P = 60;
+	var svg = el("svg", {width: W, height: H}, document.getElementById("parallel"));
+	var step = cols.length > 1 ? (W - 2 * P) / (cols.length - 1) : 0;
+	var axes = cols.map(function(c, i) {
+		var xi = P + i * step, f;
+		el("line", {x1: xi, y1: T, x2: xi, y2: H - B, "class": "axis"}, svg);
+		text(c.name, {x: xi, y: T - 12, "text-anchor": "middle"}, svg);
+		if (c.levels) {
+			var n = c.levels.length;
+			f = function(v) { return n > 1 ? H - B - v / (n - 1) * (H - B - T) : (H - B + T) / 2; };
+			c.levels.forEach(function(l, j) { text(l, {x: xi + 4, y: f(j) + 4}, svg); });
+		} else {
+			var dom = extent(c.coords);
+			f = scale(dom, H - B, T, c.log);
+			text(fmt(dom[0]), {x: xi + 4, y: H - B + 14}, svg);
+			text(fmt(dom[1]), {x: xi + 4, y: T - 2}, svg);
+		}
+		return {x: xi, y: f, col: c};
+	});
+	var range = obj ? extent(obj.coords) : null;
+	for (var r = 0; r < table.rows; r++) {
+		var d = "", pen = "M";
+		axes.forEach(function(a) {
+			var v = a.col.coords[r];
+			if (v === null) { pen = "M"; return; }
+			d += pen + a.x + "," + a.y(v);
+			pen = "L";
+		});
+		var u = 0.5;
+		if (obj && obj.coords[r] !== null) {
+			u = (obj.coords[r] - range[0]) / (range[1] - range[0]);
+			if (!maximize) u = 1 - u;
+		}
+		el("path", {d: d, fill: "none", stroke: color(u), "stroke-opacity": 0.5, "stroke-width": 1.5}, svg);
+	}
+}
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,238 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package report provides high-level functions for analyzing diviner
+// studies from Go programs and notebooks. The functions in this
+// package operate over any diviner.Database, and relieve callers from
+// iterating over runs and assembling trials themselves.
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+// ErrNoTrials is returned when a study does not contain any trials
+// that report the requested objective.
+var ErrNoTrials = errors.New("no trials with the objective metric")
+
+// Trials returns the (replicated) trials of the named study that are
+// in the provided run states. Trials are returned in the order of
+// their earliest run.
+func Trials(ctx context.Context, db diviner.Database, study string, states diviner.RunState) ([]diviner.Trial, error) {
+	m, err := diviner.Trials(ctx, db, diviner.Study{Name: study}, states)
+	if err != nil {
+		return nil, err
+	}
+	trials := make([]diviner.Trial, 0, m.Len())
+	m.Range(func(_ diviner.Value, v interface{}) {
+		trials = append(trials, v.(diviner.Trial))
+	})
+	sort.SliceStable(trials, func(i, j int) bool {
+		return firstSeq(trials[i]) < firstSeq(trials[j])
+	})
+	return trials, nil
+}
+
+// Best returns the n best successful trials of the named study,
+// ordered according to the provided objective. Trials that do not
+// report the objective's metric are omitted. If n <= 0, all trials
+// are returned. Best is computed by diviner.Leaderboard, so that
+// databases that index runs' metrics need not read every run.
+func Best(ctx context.Context, db diviner.Database, study string, objective diviner.Objective, n int) ([]diviner.Trial, error) {
+	return diviner.Leaderboard(ctx, db, study, objective, n)
+}
+
+// BestTrial returns the best successful trial of the named study
+// according to the provided objective. ErrNoTrials is returned if
+// there are no trials reporting the objective's metric.
+func BestTrial(ctx context.Context, db diviner.Database, study string, objective diviner.Objective) (diviner.Trial, error) {
+	trials, err := Best(ctx, db, study, objective, 1)
+	if err != nil {
+		return diviner.Trial{}, err
+	}
+	if len(trials) == 0 {
+		return diviner.Trial{}, ErrNoTrials
+	}
+	return trials[0], nil
+}
+
+// SortTrials sorts the provided trials from best to worst according
+// to the provided objective. Trials missing the objective metric are
+// ordered last.
+func SortTrials(trials []diviner.Trial, objective diviner.Objective) {
+	sort.SliceStable(trials, func(i, j int) bool {
+		iv, iok := trials[i].Metrics[objective.Metric]
+		jv, jok := trials[j].Metrics[objective.Metric]
+		switch {
+		case !iok || !jok:
+			return iok && !jok
+		case objective.Direction == diviner.Maximize:
+			return jv < iv
+		default:
+			return iv < jv
+		}
+	})
+}
+
+// A Frame is a dataframe-style tabulation of trials: each trial is a
+// row; each parameter value and metric is a column.
+type Frame struct {
+	// Columns names the frame's columns. The first columns are
+	// "study", "runs", and "replicates"; these are followed by the
+	// parameter names, and then the metric names, each sorted.
+	Columns []string
+	// NumValues is the number of parameter value columns.
+	NumValues int
+	// Rows contains the frame's rows, each of len(Columns).
+	// Missing entries are empty.
+	Rows [][]string
+}
+
+const numFixedColumns = 3
+
+// NewFrame tabulates the provided trials of the named study into a
+// Frame.
+func NewFrame(study string, trials []diviner.Trial) *Frame {
+	var (
+		values  = make(map[string]bool)
+		metrics = make(map[string]bool)
+	)
+	for _, trial := range trials {
+		for name := range trial.Values {
+			values[name] = true
+		}
+		for name := range trial.Metrics {
+			metrics[name] = true
+		}
+	}
+	f := &Frame{Columns: []string{"study", "runs", "replicates"}}
+	valueNames, metricNames := sortedKeys(values), sortedKeys(metrics)
+	f.Columns = append(f.Columns, valueNames...)
+	f.Columns = append(f.Columns, metricNames...)
+	f.NumValues = len(valueNames)
+	for _, trial := range trials {
+		row := make([]string, len(f.Columns))
+		row[0] = study
+		for _, run := range trial.Runs {
+			if row[1] != "" {
+				row[1] += ","
+			}
+			row[1] += strconv.FormatUint(run.Seq, 10)
+		}
+		row[2] = strconv.Itoa(trial.Replicates.Count())
+		for i, name := range valueNames {
+			if v, ok := trial.Values[name]; ok {
+				row[numFixedColumns+i] = v.String()
+			}
+		}
+		for i, name := range metricNames {
+			if v, ok := trial.Metrics[name]; ok {
+				row[numFixedColumns+len(valueNames)+i] = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+		}
+		f.Rows = append(f.Rows, row)
+	}
+	return f
+}
+
+// StudyFrame returns a Frame of the trials of the named study that are in
+// the provided run states.
+func StudyFrame(ctx context.Context, db diviner.Database, study string, states diviner.RunState) (*Frame, error) {
+	trials, err := Trials(ctx, db, study, states)
+	if err != nil {
+		return nil, err
+	}
+	return NewFrame(study, trials), nil
+}
+
+// WriteCSV writes the frame, including a header row, in CSV format
+// to the provided writer.
+func (f *Frame) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(f.Columns); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(f.Rows); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// A Point is a single observation of a metric in a metric curve.
+type Point struct {
+	// Index is the step at which the metric was observed: this is
+	// the step with which the metrics report was tagged (see
+	// diviner.StepMetric), or else the index of the report in the
+	// run's history.
+	Index int
+	// Value is the observed metric value.
+	Value float64
+}
+
+// Curve returns the history of the named metric, as reported by the
+// run with the provided study and sequence number.
+func Curve(ctx context.Context, db diviner.Database, study string, seq uint64, metric string) ([]Point, error) {
+	run, err := db.LookupRun(ctx, study, seq)
+	if err != nil {
+		return nil, err
+	}
+	return RunCurve(run, metric), nil
+}
+
+// RunCurve returns the history of the named metric as reported by
+// the provided run.
+func RunCurve(run diviner.Run, metric string) []Point {
+	var points []Point
+	for _, step := range run.History() {
+		if v, ok := step.Metrics[metric]; ok {
+			points = append(points, Point{step.Step, v})
+		}
+	}
+	return points
+}
+
+// Curves returns the history of the named metric for every run in
+// the named study, keyed by run sequence number. Only runs updated
+// since the provided time are considered.
+func Curves(ctx context.Context, db diviner.Database, study, metric string, since time.Time) (map[uint64][]Point, error) {
+	runs, err := db.ListRuns(ctx, study, diviner.Any, since)
+	if err != nil {
+		return nil, err
+	}
+	curves := make(map[uint64][]Point)
+	for _, run := range runs {
+		if points := RunCurve(run, metric); len(points) > 0 {
+			curves[run.Seq] = points
+		}
+	}
+	return curves, nil
+}
+
+func firstSeq(trial diviner.Trial) uint64 {
+	var seq uint64
+	for i, run := range trial.Runs {
+		if i == 0 || run.Seq < seq {
+			seq = run.Seq
+		}
+	}
+	return seq
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
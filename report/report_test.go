@@ -0,0 +1,166 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package report_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/diviner/report"
+	"github.com/grailbio/testutil"
+)
+
+func TestReport(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	study := diviner.Study{
+		Name:      "test",
+		Params:    diviner.Params{"x": diviner.NewRange(diviner.Int(0), diviner.Int(10))},
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+		t.Fatal(err)
+	}
+	for i, acc := range []float64{0.5, 0.9, 0.7} {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: study.Name, Values: diviner.Values{"x": diviner.Int(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []float64{acc / 4, acc / 2, acc} {
+			if err := db.AppendRunMetrics(ctx, study.Name, run.Seq, diviner.Metrics{"acc": v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.UpdateRun(ctx, study.Name, run.Seq, diviner.Success, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	best, err := report.BestTrial(ctx, db, study.Name, study.Objective)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := best.Values["x"].Int(), int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	top, err := report.Best(ctx, db, study.Name, diviner.Objective{Direction: diviner.Minimize, Metric: "acc"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(top), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := top[0].Values["x"].Int(), int64(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := top[1].Values["x"].Int(), int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	frame, err := report.StudyFrame(ctx, db, study.Name, diviner.Success)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := frame.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "study,runs,replicates,x,acc\ntest,1,1,0,0.5\ntest,2,1,1,0.9\ntest,3,1,2,0.7\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	curve, err := report.Curve(ctx, db, study.Name, 2, "acc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(curve), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := curve[2].Value, 0.9; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"x":   diviner.NewRange(diviner.Int(0), diviner.Int(10)),
+			"opt": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+		},
+		Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+	}
+	if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+		t.Fatal(err)
+	}
+	for i, acc := range []float64{0.5, 0.9, 0.7, 0.6, 0.8} {
+		values := diviner.Values{"x": diviner.Int(i), "opt": diviner.String("sgd")}
+		source := &diviner.Source{Commit: "0123456789abcdef", Dirty: i == 1}
+		run, err := db.InsertRun(ctx, diviner.Run{Study: study.Name, Values: values, Source: source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, study.Name, run.Seq, diviner.Metrics{"acc": acc}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, study.Name, run.Seq, diviner.Success, "", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: study.Name, Values: diviner.Values{"x": diviner.Int(9), "opt": diviner.String("adam")}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateRun(ctx, study.Name, run.Seq, diviner.Failure, "out of <memory>", time.Second, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := report.WriteHTML(ctx, db, &b, study.Name, report.HTMLOptions{Top: 3}); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	for _, want := range []string{
+		"<title>diviner study test</title>",
+		"<td>test:2</td><td>0123456789ab (dirty)</td>",
+		"<td class=\"num\">0.9</td>",
+		"<td class=\"num\">2</td><td>out of &lt;memory&gt;</td>",
+		"The best acc, 0.9, was attained by run test:2 after 2 of 5 successful runs; 3 runs have completed since.",
+		"var objective = \"acc\";",
+		"var maximize =  true ;",
+		"\"name\":\"opt\",\"source\":\"param\",\"kind\":\"string\"",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report does not contain %q", want)
+		}
+	}
+	// Only the best three trials are listed.
+	if strings.Contains(html, "<td>test:1</td>") {
+		t.Error("report lists too many trials")
+	}
+	if err := report.WriteHTML(ctx, db, &b, "nonexistent", report.HTMLOptions{}); err != diviner.ErrNotExist {
+		t.Errorf("got %v, want %v", err, diviner.ErrNotExist)
+	}
+}
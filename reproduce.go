@@ -0,0 +1,91 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// IsZero tells whether c is the zero run config, e.g., because it
+// belongs to a run that was created before run configs were
+// recorded, or to a study whose trials are acquired natively.
+func (c RunConfig) IsZero() bool {
+	return c.Script == "" && len(c.Datasets) == 0 && len(c.LocalFiles) == 0 && len(c.Systems) == 0
+}
+
+// DigestLocalFiles records the digests of the run config's local
+// files in LocalFileDigests.
+func (c *RunConfig) DigestLocalFiles() error {
+	if len(c.LocalFiles) == 0 {
+		return nil
+	}
+	digests := make(map[string]string, len(c.LocalFiles))
+	for _, path := range c.LocalFiles {
+		digest, err := digestFile(path)
+		if err != nil {
+			return err
+		}
+		digests[path] = digest
+	}
+	c.LocalFileDigests = digests
+	return nil
+}
+
+// ChangedLocalFiles returns the local files of the run config whose
+// contents have changed, or that no longer exist, since their
+// digests were recorded by DigestLocalFiles. Files without recorded
+// digests are not reported.
+func (c RunConfig) ChangedLocalFiles() ([]string, error) {
+	var changed []string
+	for path, want := range c.LocalFileDigests {
+		switch got, err := digestFile(path); {
+		case os.IsNotExist(err):
+			changed = append(changed, path)
+		case err != nil:
+			return nil, err
+		case got != want:
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func digestFile(path string) (string, error) {
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(p)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// ResolveConfig returns the run config with which the run may be
+// reproduced: the config recorded with the run, if any; otherwise
+// the config produced by the provided study (e.g., as loaded from
+// the script that defined it) for the run's values and replicate.
+// Regenerated configs reflect the study's current definition, which
+// may differ from the one with which the run was performed.
+func (r Run) ResolveConfig(study Study) (RunConfig, error) {
+	if !r.Config.IsZero() {
+		return r.Config, nil
+	}
+	if study.Run == nil {
+		return RunConfig{}, fmt.Errorf("run %s has no recorded config, and study %s does not define one", r.ID(), study.Name)
+	}
+	config, err := study.Run(r.Values, r.Replicate, r.ID())
+	if err != nil {
+		return RunConfig{}, err
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = study.Timeout
+	}
+	return config, nil
+}
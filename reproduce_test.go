@@ -0,0 +1,48 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestResolveConfig(t *testing.T) {
+	study := diviner.Study{
+		Name:    "test",
+		Timeout: time.Hour,
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{Script: "echo " + id + " " + values["x"].String()}, nil
+		},
+	}
+	run := diviner.Run{Study: "test", Seq: 3, Values: diviner.Values{"x": diviner.Int(5)}}
+	if !run.Config.IsZero() {
+		t.Fatal("expected zero config")
+	}
+	config, err := run.ResolveConfig(study)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "echo test:3 5"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := config.Timeout, time.Hour; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run.Config = diviner.RunConfig{Script: "echo recorded"}
+	config, err = run.ResolveConfig(study)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "echo recorded"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run.Config = diviner.RunConfig{}
+	if _, err := run.ResolveConfig(diviner.Study{Name: "test"}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/grailbio/base/data"
+	"go.starlark.net/starlark"
+)
+
+// Resources describes a set of compute resources: those required by
+// a run, or those provided by each of a system's machines. Zero-valued
+// fields indicate no requirement (or, for systems, an unknown
+// quantity).
+type Resources struct {
+	// CPU is the number of (virtual) CPUs.
+	CPU int
+	// Memory is the amount of memory.
+	Memory data.Size
+	// GPU is the number of GPUs.
+	GPU int
+}
+
+// IsZero tells whether r is the zero set of resources.
+func (r Resources) IsZero() bool {
+	return r == Resources{}
+}
+
+// Satisfies tells whether the resources r are sufficient to meet the
+// requirements need.
+func (r Resources) Satisfies(need Resources) bool {
+	return r.CPU >= need.CPU && r.Memory >= need.Memory && r.GPU >= need.GPU
+}
+
+// Scale returns the resources r scaled by the provided factor.
+// Quantities are rounded up, so that nonzero requirements grow with
+// any factor greater than 1.
+func (r Resources) Scale(factor float64) Resources {
+	return Resources{
+		CPU:    int(math.Ceil(float64(r.CPU) * factor)),
+		Memory: data.Size(math.Ceil(float64(r.Memory) * factor)),
+		GPU:    int(math.Ceil(float64(r.GPU) * factor)),
+	}
+}
+
+// String returns a textual description of the resources.
+func (r Resources) String() string {
+	return fmt.Sprintf("resources(cpu=%d, memory=%s, gpu=%d)", r.CPU, r.Memory, r.GPU)
+}
+
+// Type implements starlark.Value.
+func (Resources) Type() string { return "resources" }
+
+// Freeze implements starlark.Value.
+func (Resources) Freeze() {}
+
+// Truth implements starlark.Value.
+func (r Resources) Truth() starlark.Bool { return starlark.Bool(!r.IsZero()) }
+
+// Hash implements starlark.Value.
+func (Resources) Hash() (uint32, error) { return 0, errors.New("resources are not hashable") }
@@ -0,0 +1,101 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/bigmachine"
+	"github.com/grailbio/diviner"
+)
+
+// ConfigurableSystem is a bigmachine system whose resources are
+// scaled to meet requirements.
+type configurableSystem struct {
+	bigmachine.System
+	resources diviner.Resources
+}
+
+func (c *configurableSystem) Configure(need diviner.Resources) (bigmachine.System, diviner.Resources, error) {
+	provided := c.resources
+	for !provided.Satisfies(need) {
+		provided.CPU *= 2
+		provided.Memory *= 2
+	}
+	return &configurableSystem{c.System, provided}, provided, nil
+}
+
+func TestResources(t *testing.T) {
+	r := diviner.Resources{CPU: 8, Memory: 32 * data.GiB, GPU: 1}
+	for _, c := range []struct {
+		need      diviner.Resources
+		satisfies bool
+	}{
+		{diviner.Resources{}, true},
+		{diviner.Resources{CPU: 8}, true},
+		{diviner.Resources{CPU: 4, Memory: 16 * data.GiB, GPU: 1}, true},
+		{diviner.Resources{CPU: 16}, false},
+		{diviner.Resources{Memory: 64 * data.GiB}, false},
+		{diviner.Resources{GPU: 2}, false},
+	} {
+		if got, want := r.Satisfies(c.need), c.satisfies; got != want {
+			t.Errorf("%s: got %v, want %v", c.need, got, want)
+		}
+	}
+}
+
+func TestResourcesScale(t *testing.T) {
+	r := diviner.Resources{CPU: 3, Memory: 10 * data.GiB, GPU: 1}
+	if got, want := r.Scale(1.5), (diviner.Resources{CPU: 5, Memory: 15 * data.GiB, GPU: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := (diviner.Resources{}).Scale(2), (diviner.Resources{}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSystemConfigure(t *testing.T) {
+	var (
+		small = diviner.Resources{CPU: 2, Memory: 4 * data.GiB}
+		sys   = &diviner.System{
+			System:    &configurableSystem{bigmachine.Local, small},
+			ID:        "test",
+			Resources: small,
+		}
+	)
+	if got, err := sys.Configure(diviner.Resources{CPU: 1}); err != nil {
+		t.Fatal(err)
+	} else if got != sys {
+		t.Errorf("got %v, want %v", got, sys)
+	}
+	big, err := sys.Configure(diviner.Resources{CPU: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := big.Resources, (diviner.Resources{CPU: 8, Memory: 16 * data.GiB}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := big.ID, "test.1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Requirements satisfied by the same configuration share a system.
+	if got, err := sys.Configure(diviner.Resources{CPU: 5, Memory: 8 * data.GiB}); err != nil {
+		t.Fatal(err)
+	} else if got != big {
+		t.Errorf("got %v, want %v", got, big)
+	}
+
+	fixed := &diviner.System{System: bigmachine.Local, ID: "fixed", Resources: small}
+	if _, err := fixed.Configure(diviner.Resources{GPU: 1}); err == nil {
+		t.Error("expected error")
+	}
+	unknown := &diviner.System{System: bigmachine.Local, ID: "unknown"}
+	if got, err := unknown.Configure(diviner.Resources{GPU: 1}); err != nil {
+		t.Fatal(err)
+	} else if got != unknown {
+		t.Errorf("got %v, want %v", got, unknown)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// LogsDeletedLabel is the label, with the value "true", with which
+// Maintain marks the runs whose logs it deleted.
+const LogsDeletedLabel = "logs_deleted"
+
+// A Retention is a study's policy for removing old data from the
+// database. Retention policies are applied by Maintain; the zero
+// Retention retains everything.
+type Retention struct {
+	// FailedLogs, if positive, is the duration for which the logs of
+	// the study's failed runs (including runs that timed out or were
+	// preempted) are retained after their last update. The runs
+	// themselves, and their metrics, are retained.
+	FailedLogs time.Duration
+	// KeepBest, if positive, is the number of the study's best
+	// successful runs, according to its objective, that are
+	// retained; its other completed runs are deleted. Pending runs
+	// are always retained. Note that deleted runs are no longer
+	// observed by the study's oracle.
+	KeepBest int
+}
+
+// IsZero tells whether the retention policy retains everything.
+func (r Retention) IsZero() bool {
+	return r.FailedLogs <= 0 && r.KeepBest <= 0
+}
+
+// String returns a textual description of the retention policy.
+func (r Retention) String() string {
+	var parts []string
+	if r.FailedLogs > 0 {
+		parts = append(parts, fmt.Sprintf("failed_logs=%s", r.FailedLogs))
+	}
+	if r.KeepBest > 0 {
+		parts = append(parts, fmt.Sprintf("keep_best=%d", r.KeepBest))
+	}
+	if len(parts) == 0 {
+		return "retain all"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ArchiveStudy archives the named study, or, if archived is false,
+// restores an archived study. Archived studies are omitted from
+// default listings, their runs become immutable (see ErrArchived),
+// and they are skipped by Maintain. Runs that are pending when their
+// study is archived can no longer report their progress.
+func ArchiveStudy(ctx context.Context, db Database, name string, archived bool) error {
+	study, err := db.LookupStudy(ctx, name)
+	if err != nil {
+		return err
+	}
+	study.Archived = archived
+	return db.UpdateStudy(ctx, study)
+}
+
+// SetRetention sets the retention policy of the named study.
+func SetRetention(ctx context.Context, db Database, name string, retention Retention) error {
+	study, err := db.LookupStudy(ctx, name)
+	if err != nil {
+		return err
+	}
+	study.Retention = retention
+	return db.UpdateStudy(ctx, study)
+}
+
+// A MaintenanceReport summarizes the data removed by Maintain.
+type MaintenanceReport struct {
+	// Studies is the number of studies whose retention policies
+	// were applied.
+	Studies int
+	// DeletedLogs is the number of runs whose logs were deleted.
+	DeletedLogs int
+	// DeletedRuns is the number of runs that were deleted.
+	DeletedRuns int
+}
+
+// Add adds the counts of the provided report to r.
+func (r *MaintenanceReport) Add(other MaintenanceReport) {
+	r.Studies += other.Studies
+	r.DeletedLogs += other.DeletedLogs
+	r.DeletedRuns += other.DeletedRuns
+}
+
+// String returns a textual summary of the report.
+func (r MaintenanceReport) String() string {
+	return fmt.Sprintf("%d studies: deleted logs of %d runs, deleted %d runs", r.Studies, r.DeletedLogs, r.DeletedRuns)
+}
+
+// Maintain applies the retention policies of the database's
+// (unarchived) studies, as of the provided time: it deletes the logs
+// of failed runs that are older than their study's FailedLogs,
+// labeling them with LogsDeletedLabel, and deletes the runs that are
+// not among the best KeepBest runs of their study. Maintain is
+// idempotent, and should be performed periodically (see
+// MaintainEvery).
+func Maintain(ctx context.Context, db Database, now time.Time) (MaintenanceReport, error) {
+	var report MaintenanceReport
+	studies, err := db.ListStudies(ctx, "", time.Time{})
+	if err != nil {
+		return report, err
+	}
+	for _, study := range studies {
+		if study.Archived || study.Retention.IsZero() {
+			continue
+		}
+		r, err := maintainStudy(ctx, db, study, now)
+		report.Add(r)
+		if err != nil {
+			return report, fmt.Errorf("study %s: %v", study.Name, err)
+		}
+	}
+	return report, nil
+}
+
+func maintainStudy(ctx context.Context, db Database, study Study, now time.Time) (MaintenanceReport, error) {
+	report := MaintenanceReport{Studies: 1}
+	if study.Retention.KeepBest > 0 && study.Objective.Metric != "" {
+		best, err := db.BestRuns(ctx, study.Name, study.Objective, study.Retention.KeepBest)
+		if err != nil {
+			return report, err
+		}
+		keep := make(map[uint64]bool, len(best))
+		for _, run := range best {
+			keep[run.Seq] = true
+		}
+		runs, err := db.ListRuns(ctx, study.Name, Any&^Pending, time.Time{})
+		if err != nil {
+			return report, err
+		}
+		for _, run := range runs {
+			if keep[run.Seq] {
+				continue
+			}
+			if err := db.DeleteRun(ctx, study.Name, run.Seq); err != nil && err != ErrNotExist {
+				return report, err
+			}
+			report.DeletedRuns++
+		}
+	}
+	if study.Retention.FailedLogs > 0 {
+		runs, err := db.ListRuns(ctx, study.Name, Failure|TimedOut|Preempted, time.Time{})
+		if err != nil {
+			return report, err
+		}
+		cutoff := now.Add(-study.Retention.FailedLogs)
+		for _, run := range runs {
+			if run.Labels[LogsDeletedLabel] == "true" || !run.Updated.Before(cutoff) {
+				continue
+			}
+			if err := db.DeleteRunLogs(ctx, study.Name, run.Seq); err != nil {
+				return report, err
+			}
+			if _, err := UpdateLabels(ctx, db, study.Name, run.Seq, Labels{LogsDeletedLabel: "true"}, nil); err != nil {
+				return report, err
+			}
+			report.DeletedLogs++
+		}
+	}
+	return report, nil
+}
+
+// MaintainEvery performs database maintenance (see Maintain) at the
+// provided interval, until the provided context is done. Failures are
+// logged, and retried at the next interval.
+func MaintainEvery(ctx context.Context, db Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := Maintain(ctx, db, time.Now())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("maintenance: %v", err)
+		case report.DeletedLogs > 0 || report.DeletedRuns > 0:
+			log.Printf("maintenance: %s", report)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+)
+
+func TestMaintain(t *testing.T) {
+	var (
+		ctx = context.Background()
+		db  = memdb.New()
+	)
+	for _, name := range []string{"test", "archived", "unretained"} {
+		study := diviner.Study{
+			Name:      name,
+			Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+			Retention: diviner.Retention{FailedLogs: time.Hour, KeepBest: 2},
+		}
+		if name == "unretained" {
+			study.Retention = diviner.Retention{}
+		}
+		if _, err := db.CreateStudyIfNotExist(ctx, study); err != nil {
+			t.Fatal(err)
+		}
+		// Runs 1-3 succeed with increasing accuracy; 4 fails; 5 is pending.
+		for i := 1; i <= 5; i++ {
+			run, err := db.InsertRun(ctx, diviner.Run{Study: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := db.Logger(name, run.Seq)
+			fmt.Fprintf(w, "run %d\n", run.Seq)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			state := diviner.Success
+			switch i {
+			case 4:
+				state = diviner.Failure
+			case 5:
+				continue
+			}
+			if err := db.AppendRunMetrics(ctx, name, run.Seq, diviner.Metrics{"acc": float64(i)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.UpdateRun(ctx, name, run.Seq, state, "", time.Minute, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := diviner.ArchiveStudy(ctx, db, "archived", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the best two completed runs, and the pending run, are
+	// retained.
+	report, err := diviner.Maintain(ctx, db, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report, (diviner.MaintenanceReport{Studies: 1, DeletedRuns: 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	seqs := func(study string) []uint64 {
+		t.Helper()
+		runs, err := db.ListRuns(ctx, study, diviner.Any, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs := make([]uint64, len(runs))
+		for i, run := range runs {
+			seqs[i] = run.Seq
+		}
+		return seqs
+	}
+	if got, want := seqs("test"), []uint64{2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, study := range []string{"archived", "unretained"} {
+		if got, want := len(seqs(study)), 5; got != want {
+			t.Errorf("%s: got %v, want %v", study, got, want)
+		}
+	}
+
+	// The logs of failed runs are deleted once they expire.
+	if err := diviner.SetRetention(ctx, db, "test", diviner.Retention{FailedLogs: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := db.InsertRun(ctx, diviner.Run{Study: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := db.Logger("test", run.Seq)
+	fmt.Fprintln(w, "failed")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "test", run.Seq, diviner.Failure, "", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		now  time.Time
+		want diviner.MaintenanceReport
+	}{
+		{time.Now(), diviner.MaintenanceReport{Studies: 1}},
+		{time.Now().Add(2 * time.Hour), diviner.MaintenanceReport{Studies: 1, DeletedLogs: 1}},
+		// Maintenance is idempotent.
+		{time.Now().Add(2 * time.Hour), diviner.MaintenanceReport{Studies: 1}},
+	} {
+		report, err := diviner.Maintain(ctx, db, c.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := report, c.want; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, want := seqs("test"), []uint64{2, 3, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run, err = db.LookupRun(ctx, "test", run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Labels[diviner.LogsDeletedLabel], "true"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if p, err := ioutil.ReadAll(db.Log("test", run.Seq, time.Time{}, false)); err != nil {
+		t.Fatal(err)
+	} else if len(p) != 0 {
+		t.Errorf("unexpected log %q", p)
+	}
+}
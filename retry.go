@@ -0,0 +1,150 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// DefaultBackoff is the delay before the first retry of a run when a
+// RetryPolicy does not specify one.
+const DefaultBackoff = 10 * time.Second
+
+// DefaultMaxPreemptions is the maximum number of times a preempted run
+// is rescheduled when a RetryPolicy does not specify it.
+const DefaultMaxPreemptions = 5
+
+// A RetryPolicy determines whether and how failed runs are retried by
+// the runner. Each retry is performed as a new run, linked to the
+// original through Run.RetryOf and Run.Attempt.
+//
+// Failures due to the infrastructure (e.g., machine allocation or
+// dataset errors) are considered transient, and are always retried
+// when the policy permits further attempts. Failures of the run
+// itself are retried only if their error message matches one of the
+// policy's Retryable patterns. Runs that are preempted (see
+// Preempted) are rescheduled regardless of their failures, up to
+// MaxPreemptions times; rescheduled runs that are checkpointed resume
+// from the original run's checkpoint.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts made for each
+	// run, including the first. Runs are not retried if MaxAttempts
+	// is less than 2.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; the delay doubles
+	// with each subsequent retry. Defaults to DefaultBackoff.
+	Backoff time.Duration
+	// MaxBackoff, if positive, bounds the delay between retries.
+	MaxBackoff time.Duration
+	// Retryable is a set of regular expressions matching the error
+	// messages of run failures that should be retried.
+	Retryable []string
+	// RetryTimeouts tells whether runs that exceed their timeout (see
+	// RunConfig.Timeout) are retried.
+	RetryTimeouts bool
+	// ResourceScale, if positive, is the factor by which the
+	// resources of a run that timed out are scaled for its retry,
+	// e.g., so that the retry is performed on a larger machine.
+	ResourceScale float64
+	// MaxPreemptions is the maximum number of times a run is
+	// rescheduled after it has been preempted. Preemptions do not
+	// count against MaxAttempts. Defaults to DefaultMaxPreemptions;
+	// preempted runs are not rescheduled if MaxPreemptions is
+	// negative.
+	MaxPreemptions int
+}
+
+// Retry tells whether a run that failed with the provided message on
+// the provided attempt (starting at 1) should be retried. Transient
+// indicates whether the failure was due to the infrastructure rather
+// than the run itself.
+func (p RetryPolicy) Retry(attempt int, message string, transient bool) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if transient {
+		return true
+	}
+	for _, pattern := range p.Retryable {
+		// Patterns are validated by Validate; invalid ones never match.
+		if re, err := regexp.Compile(pattern); err == nil && re.MatchString(message) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryTimeout tells whether a run that timed out on the provided
+// attempt (starting at 1) should be retried.
+func (p RetryPolicy) RetryTimeout(attempt int) bool {
+	return p.RetryTimeouts && attempt < p.MaxAttempts
+}
+
+// RetryPreemption tells whether a run that has been preempted the
+// provided number of times should be rescheduled.
+func (p RetryPolicy) RetryPreemption(preemptions int) bool {
+	max := p.MaxPreemptions
+	if max == 0 {
+		max = DefaultMaxPreemptions
+	}
+	return preemptions <= max
+}
+
+// Delay returns the delay before the retry following the provided
+// attempt (starting at 1).
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	if delay <= 0 {
+		delay = DefaultBackoff
+	}
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Validate returns an error if the policy's retryable patterns are
+// not valid regular expressions, or if its resource scale would
+// shrink resources.
+func (p RetryPolicy) Validate() error {
+	for _, pattern := range p.Retryable {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("retryable pattern %q: %v", pattern, err)
+		}
+	}
+	if p.ResourceScale < 0 || (p.ResourceScale > 0 && p.ResourceScale < 1) {
+		return fmt.Errorf("resource scale %v is less than 1", p.ResourceScale)
+	}
+	return nil
+}
+
+// String returns a textual description of the retry policy.
+func (p RetryPolicy) String() string {
+	return fmt.Sprintf("retry(max_attempts=%d, backoff=%s, max_backoff=%s, retryable=%q, retry_timeouts=%t, resource_scale=%v, max_preemptions=%d)",
+		p.MaxAttempts, p.Backoff, p.MaxBackoff, p.Retryable, p.RetryTimeouts, p.ResourceScale, p.MaxPreemptions)
+}
+
+// Type implements starlark.Value.
+func (RetryPolicy) Type() string { return "retry" }
+
+// Freeze implements starlark.Value.
+func (RetryPolicy) Freeze() {}
+
+// Truth implements starlark.Value.
+func (RetryPolicy) Truth() starlark.Bool { return true }
+
+// Hash implements starlark.Value.
+func (RetryPolicy) Hash() (uint32, error) { return 0, errors.New("retry is not hashable") }
@@ -0,0 +1,71 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := diviner.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		MaxBackoff:  3 * time.Second,
+		Retryable:   []string{"out of memory", "^connection reset"},
+	}
+	for _, c := range []struct {
+		attempt   int
+		message   string
+		transient bool
+		retry     bool
+	}{
+		{1, "run failed: out of memory", false, true},
+		{1, "connection reset by peer", false, true},
+		{1, "run failed: connection reset by peer", false, false},
+		{1, "exit status 1", false, false},
+		{1, "exit status 1", true, true},
+		{2, "out of memory", false, true},
+		{3, "out of memory", false, false},
+		{3, "exit status 1", true, false},
+	} {
+		if got, want := policy.Retry(c.attempt, c.message, c.transient), c.retry; got != want {
+			t.Errorf("retry(%d, %q, %v): got %v, want %v", c.attempt, c.message, c.transient, got, want)
+		}
+	}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if got := policy.Delay(attempt + 1); got != want {
+			t.Errorf("delay(%d): got %v, want %v", attempt+1, got, want)
+		}
+	}
+	if got, want := (diviner.RetryPolicy{}).Delay(1), diviner.DefaultBackoff; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if (diviner.RetryPolicy{}).Retry(1, "", true) {
+		t.Error("zero policy retried")
+	}
+	if err := (diviner.RetryPolicy{Retryable: []string{"("}}).Validate(); err == nil {
+		t.Error("expected error")
+	}
+	if err := (diviner.RetryPolicy{ResourceScale: 0.5}).Validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	policy := diviner.RetryPolicy{MaxAttempts: 2}
+	if policy.RetryTimeout(1) {
+		t.Error("timeout retried")
+	}
+	policy.RetryTimeouts = true
+	if !policy.RetryTimeout(1) {
+		t.Error("timeout not retried")
+	}
+	if policy.RetryTimeout(2) {
+		t.Error("timeout retried past max attempts")
+	}
+}
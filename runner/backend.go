@@ -0,0 +1,72 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/grailbio/diviner"
+)
+
+// BackendSystem returns the first of the provided systems that
+// executes runs through a backend, or nil if there is none.
+func backendSystem(systems []*diviner.System) *diviner.System {
+	for _, sys := range systems {
+		if sys.Backend != nil {
+			return sys
+		}
+	}
+	return nil
+}
+
+// AcquireBackend waits for a slot in the provided backend system,
+// whose parallelism limits the number of jobs that may be run
+// concurrently. The returned function must be called to release the
+// slot.
+func (r *Runner) acquireBackend(ctx context.Context, sys *diviner.System) (release func(), err error) {
+	if sys.Parallelism <= 0 {
+		return func() {}, nil
+	}
+	r.mu.Lock()
+	slots, ok := r.backends[sys]
+	if !ok {
+		slots = make(chan struct{}, sys.Parallelism)
+		r.backends[sys] = slots
+	}
+	r.mu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// NewJob returns a job that runs the provided script, with the
+// system's preamble, in a working directory populated with the
+// provided local files.
+func newJob(name, script string, files []string, resources diviner.Resources, sys *diviner.System) (diviner.Job, error) {
+	preamble := sys.Preamble
+	if preamble == "" {
+		preamble = DefaultPreamble
+	}
+	job := diviner.Job{
+		Name:      name,
+		Script:    preamble + script,
+		Files:     make(map[string][]byte),
+		Resources: resources,
+	}
+	for _, path := range files {
+		p, err := ioutil.ReadFile(path)
+		if err != nil {
+			return diviner.Job{}, fmt.Errorf("failed to read local file %s: %v", path, err)
+		}
+		job.Files[filepath.Base(path)] = p
+	}
+	return job, nil
+}
@@ -0,0 +1,338 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package runner_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/localexec"
+	"github.com/grailbio/diviner/oracle"
+	"github.com/grailbio/diviner/runner"
+)
+
+// ShellBackend implements diviner.Backend by running jobs in local
+// Bash processes.
+type shellBackend struct {
+	// Dir is the directory in which job working directories are
+	// created.
+	Dir string
+}
+
+// running counts the jobs running in shell backends.
+var running int64
+
+func init() {
+	gob.Register(new(shellBackend))
+}
+
+func (b *shellBackend) Run(ctx context.Context, job diviner.Job) (io.ReadCloser, error) {
+	dir, err := ioutil.TempDir(b.Dir, "job")
+	if err != nil {
+		return nil, err
+	}
+	for name, p := range job.Files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), p, 0644); err != nil {
+			return nil, err
+		}
+	}
+	r, w := io.Pipe()
+	cmd := exec.CommandContext(ctx, "bash", "-c", job.Script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), job.Env...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	n := atomic.AddInt64(&running, 1)
+	go func() {
+		// Writes to the pipe block until it is read by the caller.
+		if n > 1 {
+			fmt.Fprintf(w, "%d jobs running\n", n)
+		}
+		err := cmd.Wait()
+		atomic.AddInt64(&running, -1)
+		w.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+func TestBackend(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	systems := []*diviner.System{{
+		ID:          "shell",
+		Backend:     &shellBackend{Dir: dir},
+		Parallelism: 1,
+	}}
+	localFile := filepath.Join(dir, "greeting")
+	if err := ioutil.WriteFile(localFile, []byte("hello world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	datasetFile := filepath.Join(dir, "dataset")
+	dataset := diviner.Dataset{
+		Name:       "testset",
+		IfNotExist: datasetFile,
+		Systems:    systems,
+		LocalFiles: []string{localFile},
+		Script:     fmt.Sprintf("cp greeting %s", datasetFile),
+	}
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2)),
+		},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems:    systems,
+				Datasets:   []diviner.Dataset{dataset},
+				LocalFiles: []string{localFile},
+				Script: fmt.Sprintf(`
+					test -f %s || exit 1
+					cat greeting
+					echo METRICS: paramvalue=%s
+				`, datasetFile, values["param"]),
+			}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "paramvalue"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	if done := testRun(t, db, study); !done {
+		t.Fatal("not done")
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, run := range runs {
+		metrics, ok := run.Trial().Metrics["paramvalue"]
+		if !ok {
+			t.Errorf("run %s: missing metrics", run.ID())
+		}
+		if got, want := metrics, float64(run.Values["param"].Int()); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		var b bytes.Buffer
+		if _, err := io.Copy(&b, db.Log(run.Study, run.Seq, time.Time{}, false)); err != nil {
+			t.Fatal(err)
+		}
+		log := b.String()
+		if !strings.Contains(log, "hello world") {
+			t.Errorf("run %s: log missing output: %s", run.ID(), log)
+		}
+		if strings.Contains(log, "jobs running") {
+			t.Errorf("run %s: parallelism exceeded: %s", run.ID(), log)
+		}
+	}
+}
+
+func TestLocalExec(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	systems := []*diviner.System{{
+		ID:          "local",
+		Backend:     &localexec.Backend{Dir: dir},
+		Parallelism: 2,
+	}}
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2)),
+		},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems: systems,
+				Script: fmt.Sprintf(`
+					echo running %s
+					echo METRICS: paramvalue=%s
+				`, id, values["param"]),
+			}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "paramvalue"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	if done := testRun(t, db, study); !done {
+		t.Fatal("not done")
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, run := range runs {
+		if got, want := run.Trial().Metrics["paramvalue"], float64(run.Values["param"].Int()); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		var b bytes.Buffer
+		if _, err := io.Copy(&b, db.Log(run.Study, run.Seq, time.Time{}, false)); err != nil {
+			t.Fatal(err)
+		}
+		if want := "running " + run.ID(); !strings.Contains(b.String(), want) {
+			t.Errorf("run %s: log missing output: %s", run.ID(), b.String())
+		}
+	}
+}
+
+func TestDatasetDigest(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	systems := []*diviner.System{{
+		ID:          "shell",
+		Backend:     &shellBackend{Dir: dir},
+		Parallelism: 1,
+	}}
+	counter := filepath.Join(dir, "counter")
+	dataset := diviner.Dataset{
+		Name:    "testset",
+		Systems: systems,
+		Script:  fmt.Sprintf("echo ran >> %s", counter),
+	}
+	produced := func() int {
+		p, err := ioutil.ReadFile(counter)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return strings.Count(string(p), "ran")
+	}
+	for i, test := range []struct {
+		script     string
+		invalidate bool
+		produced   int
+	}{
+		{"", false, 1},
+		// The dataset is up to date.
+		{"", false, 1},
+		// The dataset's script changed.
+		{"\n# v2", false, 2},
+		{"\n# v2", true, 3},
+	} {
+		dataset := dataset
+		dataset.Script += test.script
+		if test.invalidate {
+			if err := db.InvalidateDataset(ctx, dataset.Name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		study := diviner.Study{
+			Name:   fmt.Sprintf("test%d", i),
+			Params: diviner.Params{"param": diviner.NewDiscrete(diviner.Int(0))},
+			Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+				return diviner.RunConfig{
+					Systems:  systems,
+					Datasets: []diviner.Dataset{dataset},
+					Script:   "echo METRICS: acc=1",
+				}, nil
+			},
+			Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+			Oracle:    &oracle.GridSearch{},
+		}
+		if done := testRun(t, db, study); !done {
+			t.Fatal("not done")
+		}
+		if got, want := produced(), test.produced; got != want {
+			t.Errorf("%d: got %v, want %v", i, got, want)
+		}
+		digest, err := dataset.Digest(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, err := db.LookupDataset(ctx, dataset.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := record.Digest, digest; got != want {
+			t.Errorf("%d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestDatasetVersions(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := runner.New(db)
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Error(err)
+		}
+	}()
+	systems := []*diviner.System{{
+		ID:          "shell",
+		Backend:     &shellBackend{Dir: dir},
+		Parallelism: 4,
+	}}
+	trace := filepath.Join(dir, "trace")
+	// Two definitions of the same dataset are requested at once; they
+	// are processed one after the other.
+	var wg sync.WaitGroup
+	for _, version := range []string{"v1", "v2"} {
+		dataset := diviner.Dataset{
+			Name:    "testset",
+			Systems: systems,
+			Script:  fmt.Sprintf("echo start %[1]s >> %[2]s; sleep 0.5; echo end %[1]s >> %[2]s", version, trace),
+		}
+		study := diviner.Study{
+			Name:   "test" + version,
+			Params: diviner.Params{"param": diviner.NewDiscrete(diviner.Int(0))},
+			Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+				return diviner.RunConfig{
+					Systems:  systems,
+					Datasets: []diviner.Dataset{dataset},
+					Script:   "echo METRICS: acc=1",
+				}, nil
+			},
+			Objective: diviner.Objective{Direction: diviner.Maximize, Metric: "acc"},
+			Oracle:    &oracle.GridSearch{},
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Round(ctx, study, 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	p, err := ioutil.ReadFile(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if got, want := len(lines), 4; got != want {
+		t.Fatalf("got %v, want %v: %q", got, want, lines)
+	}
+	for i := 0; i < len(lines); i += 2 {
+		if !strings.HasPrefix(lines[i], "start ") || lines[i+1] != "end "+strings.TrimPrefix(lines[i], "start ") {
+			t.Errorf("datasets processed concurrently: %q", lines)
+			break
+		}
+	}
+}
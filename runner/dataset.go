@@ -12,17 +12,21 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/grailbio/base/errors"
-	"github.com/grailbio/base/file"
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/storage"
 )
 
 // A dataset represents a process that creates a datataset, which
 // runs may depend on.
 type dataset struct {
 	diviner.Dataset
+	// Digest is the dataset's digest, with which its completion is
+	// recorded.
+	digest string
 
 	donec chan struct{}
 
@@ -33,21 +37,36 @@ type dataset struct {
 
 // NewDataset creates a new runnable dataset from a diviner dataset
 // configuration.
-func newDataset(d diviner.Dataset) *dataset {
+func newDataset(d diviner.Dataset, digest string) *dataset {
 	return &dataset{
 		Dataset: d,
+		digest:  digest,
 		donec:   make(chan struct{}),
 	}
 }
 
 // Do processes the dataset, possibly allocating a worker from the
 // provided runner. Upon return, the dataset's status must be done.
+// Datasets that were completed with the same digest are not
+// processed again; those whose digests have changed are processed
+// even if their IfNotExist URLs exist.
 func (d *dataset) Do(ctx context.Context, runner *Runner) {
 	// First check if the dataset already exists.
-	if url := d.IfNotExist; url != "" {
-		if stat, err := file.Stat(ctx, url); err == nil {
-			Logger.Printf("dataset %s: found %s, with modtime %v", d.Name, url, stat.ModTime())
-			d.setStatus(statusOk)
+	switch record, err := runner.db.LookupDataset(ctx, d.Name); {
+	case err == nil && record.Digest == d.digest:
+		Logger.Printf("dataset %s: up to date, completed at %v", d.Name, record.Completed)
+		d.setStatus(statusOk)
+		return
+	case err == nil:
+		Logger.Printf("dataset %s: definition changed since its completion at %v", d.Name, record.Completed)
+	case err != diviner.ErrNotExist:
+		d.error(errors.E("dataset: lookup", d.Name, err))
+		return
+	case d.IfNotExist != "":
+		url := d.IfNotExist
+		if info, err := storage.Stat(ctx, url); err == nil {
+			Logger.Printf("dataset %s: found %s, with modtime %v", d.Name, url, info.ModTime)
+			d.complete(ctx, runner)
 			return
 		} else if !errors.Is(errors.NotExist, err) {
 			d.error(errors.E("dataset: ifnotexist", url, err))
@@ -55,27 +74,21 @@ func (d *dataset) Do(ctx context.Context, runner *Runner) {
 		}
 	}
 	Logger.Printf("dataset %s: %s not found, start data generation", d.Name, d.IfNotExist)
-	w, err := runner.allocate(ctx, d.Systems)
-	if err != nil {
-		d.error(errors.E("dataset: allocate", d.Systems, err))
-		return
-	}
-	defer w.Return()
-	d.setStatus(statusRunning)
-	// First clean up the workspace.
-	if err := w.Reset(ctx); err != nil {
-		d.error(err)
-		return
-	}
-	if err := w.CopyFiles(ctx, d.LocalFiles); err != nil {
-		d.error(errors.E(fmt.Sprintf("dataset copyfiles %+v: %v", d.LocalFiles, err)))
-		return
+	var (
+		out     io.ReadCloser
+		release func()
+		err     error
+	)
+	if sys := backendSystem(d.Systems); sys != nil {
+		out, release, err = d.startBackend(ctx, runner, sys)
+	} else {
+		out, release, err = d.startWorker(ctx, runner)
 	}
-	out, err := w.Run(ctx, d.Script, nil)
 	if err != nil {
-		d.error(errors.E(fmt.Sprintf("dataset: failed to start script '%s'", d.Script), err))
+		d.error(err)
 		return
 	}
+	defer release()
 	var writer io.Writer = ioutil.Discard
 	path := fmt.Sprintf("dataset.%s.log", d.Name)
 	path = strings.Replace(path, "/", "_", -1)
@@ -90,12 +103,70 @@ func (d *dataset) Do(ctx context.Context, runner *Runner) {
 		err = e
 	}
 	if err == nil {
-		d.setStatus(statusOk)
+		d.complete(ctx, runner)
 	} else {
 		d.error(err)
 	}
 }
 
+// Complete records the dataset's completion in the runner's
+// database, and marks it done.
+func (d *dataset) complete(ctx context.Context, runner *Runner) {
+	record := diviner.DatasetRecord{Name: d.Name, Digest: d.digest, Completed: time.Now()}
+	if err := runner.db.SetDataset(ctx, record); err != nil {
+		d.error(errors.E("dataset: record", d.Name, err))
+		return
+	}
+	d.setStatus(statusOk)
+}
+
+// StartWorker starts the dataset's script on a worker allocated from
+// the runner. The returned function returns the worker.
+func (d *dataset) startWorker(ctx context.Context, runner *Runner) (io.ReadCloser, func(), error) {
+	w, err := runner.allocate(ctx, "", d.Systems)
+	if err != nil {
+		return nil, nil, errors.E("dataset: allocate", d.Systems, err)
+	}
+	d.setStatus(statusRunning)
+	// First clean up the workspace.
+	if err := w.Reset(ctx); err != nil {
+		w.Return()
+		return nil, nil, err
+	}
+	if err := w.CopyFiles(ctx, d.LocalFiles); err != nil {
+		w.Return()
+		return nil, nil, errors.E(fmt.Sprintf("dataset copyfiles %+v: %v", d.LocalFiles, err))
+	}
+	out, err := w.Run(ctx, d.Script, nil, container{})
+	if err != nil {
+		w.Return()
+		return nil, nil, errors.E(fmt.Sprintf("dataset: failed to start script '%s'", d.Script), err)
+	}
+	return out, w.Return, nil
+}
+
+// StartBackend submits the dataset's script as a job to the provided
+// system's backend. The returned function releases the job's slot in
+// the system.
+func (d *dataset) startBackend(ctx context.Context, runner *Runner, sys *diviner.System) (io.ReadCloser, func(), error) {
+	release, err := runner.acquireBackend(ctx, sys)
+	if err != nil {
+		return nil, nil, errors.E("dataset: allocate", d.Systems, err)
+	}
+	d.setStatus(statusRunning)
+	job, err := newJob("dataset-"+d.Name, d.Script, d.LocalFiles, diviner.Resources{}, sys)
+	if err != nil {
+		release()
+		return nil, nil, errors.E(fmt.Sprintf("dataset copyfiles %+v: %v", d.LocalFiles, err))
+	}
+	out, err := sys.Backend.Run(ctx, job)
+	if err != nil {
+		release()
+		return nil, nil, errors.E(fmt.Sprintf("dataset: failed to start script '%s'", d.Script), err)
+	}
+	return out, release, nil
+}
+
 // Done returns a channel that is closed when the dataset run
 // completes.
 func (d *dataset) Done() <-chan struct{} {
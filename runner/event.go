@@ -0,0 +1,133 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+)
+
+// NotifyTimeout is the maximum amount of time the runner waits for a
+// notifier to accept an event.
+const notifyTimeout = 30 * time.Second
+
+// Notify delivers an event of the provided kind to the study's
+// notifiers. Notifier errors are logged.
+func (r *Runner) notify(ctx context.Context, study diviner.Study, kind diviner.EventKind, run diviner.Run, message string) {
+	if len(study.Notifiers) == 0 {
+		return
+	}
+	event := diviner.Event{
+		Kind:      kind,
+		Study:     study.Name,
+		Objective: study.Objective,
+		Run:       run,
+		Time:      time.Now(),
+		Message:   message,
+	}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	for _, n := range study.Notifiers {
+		if err := n.Notify(ctx, event); err != nil {
+			log.Error.Printf("%s: notifier %v: error delivering event %s: %v", study.Name, n, kind, err)
+		}
+	}
+}
+
+// NotifyRun delivers the events for the provided completed run:
+// RunCompleted or RunFailed, and NewBest if the run attained the best
+// objective value of any run in its study.
+func (r *Runner) notifyRun(ctx context.Context, run *run) {
+	study := run.Study
+	if len(study.Notifiers) == 0 {
+		return
+	}
+	_, message, elapsed := run.Status()
+	if run.Run.State != diviner.Success {
+		if message == "" {
+			message = run.Run.State.String()
+		}
+		r.notify(ctx, study, diviner.RunFailed, run.Run, fmt.Sprintf("%s after %s: %s", run.Run.State, elapsed, message))
+		return
+	}
+	r.notify(ctx, study, diviner.RunCompleted, run.Run, fmt.Sprintf("completed in %s", elapsed))
+	objective := study.Objective
+	trial := study.Trial(run.Run)
+	v, ok := trial.Metrics[objective.Metric]
+	if !ok || math.IsNaN(v) || trial.Infeasible {
+		return
+	}
+	best, err := r.improve(ctx, study, run.Run.Seq, v)
+	if err != nil {
+		log.Error.Printf("%s: error determining best trial: %v", study.Name, err)
+		return
+	}
+	if best {
+		r.notify(ctx, study, diviner.NewBest, run.Run, fmt.Sprintf("new best %s=%g", objective.Metric, v))
+	}
+}
+
+// Improve tells whether the objective value v, attained by run seq,
+// improves on the best value of the study's objective attained thus
+// far. If so, v becomes the study's best value. The best value of a
+// study is initialized from its successful runs in the database that
+// completed before the runner started.
+func (r *Runner) improve(ctx context.Context, study diviner.Study, seq uint64, v float64) (bool, error) {
+	r.mu.Lock()
+	_, ok := r.best[study.Name]
+	r.mu.Unlock()
+	if !ok {
+		runs, err := r.db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+		if err != nil {
+			return false, err
+		}
+		best := math.NaN()
+		for _, run := range runs {
+			// Runs completed by this runner are judged as they complete;
+			// counting them here would deny concurrently completed runs
+			// their notifications.
+			if run.Seq == seq || !run.Completed.Before(r.time) {
+				continue
+			}
+			trial := study.Trial(run)
+			w, ok := trial.Metrics[study.Objective.Metric]
+			if ok && !trial.Infeasible && better(study.Objective, w, best) {
+				best = w
+			}
+		}
+		r.mu.Lock()
+		if _, ok := r.best[study.Name]; !ok {
+			r.best[study.Name] = best
+		}
+		r.mu.Unlock()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !better(study.Objective, v, r.best[study.Name]) {
+		return false, nil
+	}
+	r.best[study.Name] = v
+	return true, nil
+}
+
+// Better tells whether the objective value v is better than w. Any
+// value is better than NaN.
+func better(objective diviner.Objective, v, w float64) bool {
+	switch {
+	case math.IsNaN(v):
+		return false
+	case math.IsNaN(w):
+		return true
+	case objective.Direction == diviner.Minimize:
+		return v < w
+	default:
+		return v > w
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+)
+
+// LeaseTTL is the time after which the runner's study leases expire
+// if they are not renewed. Leases are renewed every
+// keepaliveInterval, so that a few renewals may fail (e.g., because
+// the database is briefly unavailable) before the lease is lost.
+const leaseTTL = 4 * keepaliveInterval
+
+// A lease is a study lease held by the runner on behalf of its
+// callers (Round and Stream) that perform the study's trials.
+type lease struct {
+	// N is the number of callers that hold the lease.
+	n int
+	// Lost is the error with which the lease was lost, if it was
+	// taken over by another runner.
+	lost error
+	done chan struct{}
+}
+
+// HoldLease holds the provided study's lease, acquiring it from the
+// database if it is not already held by the runner, until the returned
+// release function is called. A study is run by at most one runner at
+// a time: holdLease fails if another runner holds an unexpired lease
+// of the study. (Runners that die hold their leases until they
+// expire.) Release returns an error if the lease was lost while it was
+// held.
+func (r *Runner) holdLease(ctx context.Context, study diviner.Study) (release func() error, err error) {
+	release = func() error { return r.releaseLease(study.Name) }
+	r.mu.Lock()
+	if l := r.leases[study.Name]; l != nil {
+		l.n++
+		r.mu.Unlock()
+		return release, nil
+	}
+	if r.owner.Owner == "" {
+		if r.owner, err = diviner.NewLease(leaseTTL); err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+	}
+	owner := r.owner
+	r.mu.Unlock()
+
+	if _, err := r.db.CreateStudyIfNotExist(ctx, study); err != nil {
+		return nil, err
+	}
+	held, acquired, err := r.db.AcquireLease(ctx, study.Name, newLease(owner))
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, fmt.Errorf("study %s is being run by another runner, %s: "+
+			"wait for that runner to finish, or, if it died, for its lease to expire", study.Name, held)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The lease may have been acquired concurrently by another of
+	// the runner's callers.
+	if l := r.leases[study.Name]; l != nil {
+		l.n++
+		return release, nil
+	}
+	l := &lease{n: 1, done: make(chan struct{})}
+	r.leases[study.Name] = l
+	go r.renewLease(study.Name, owner, l)
+	Logger.Printf("%s: acquired lease %s", study.Name, owner.Owner)
+	return release, nil
+}
+
+// ReleaseLease releases a hold on the named study's lease, releasing
+// the lease itself in the database once it is no longer held.
+func (r *Runner) releaseLease(study string) error {
+	r.mu.Lock()
+	l := r.leases[study]
+	l.n--
+	if l.n > 0 {
+		r.mu.Unlock()
+		return l.lost
+	}
+	delete(r.leases, study)
+	close(l.done)
+	owner := r.owner.Owner
+	r.mu.Unlock()
+	if l.lost != nil {
+		return l.lost
+	}
+	if err := r.db.ReleaseLease(context.Background(), study, owner); err != nil {
+		log.Error.Printf("%s: release lease: %v", study, err)
+	}
+	return nil
+}
+
+// LeaseLost returns the error with which the named study's lease was
+// lost, if any.
+func (r *Runner) leaseLost(study string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l := r.leases[study]; l != nil {
+		return l.lost
+	}
+	return nil
+}
+
+// RenewLease renews the lease l of the named study every
+// keepaliveInterval until it is released, or until it is lost to
+// another runner. Failed renewals are retried at the next interval.
+func (r *Runner) renewLease(study string, owner diviner.Lease, l *lease) {
+	tick := time.NewTicker(keepaliveInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-tick.C:
+		}
+		held, acquired, err := r.db.AcquireLease(context.Background(), study, newLease(owner))
+		if err != nil {
+			log.Error.Printf("%s: renew lease: %v", study, err)
+			continue
+		}
+		if !acquired {
+			err = fmt.Errorf("study %s: lease lost to another runner, %s", study, held)
+			log.Error.Print(err)
+			r.mu.Lock()
+			l.lost = err
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// NewLease returns a lease of the provided owner that is acquired
+// now, and that expires after leaseTTL.
+func newLease(owner diviner.Lease) diviner.Lease {
+	owner.Acquired = time.Now()
+	return owner.Renew(leaseTTL)
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+)
+
+// SetParallelism sets the runner's concurrency limits, which apply
+// independently of the parallelism of the runs' systems. If total is
+// positive, it limits the number of runs that the runner performs
+// concurrently, over all of its studies. If perStudy is positive, it
+// limits the number of concurrent runs of each study that does not
+// set its own limit (see diviner.Study.MaxParallel). Runs that are
+// waiting for datasets do not count against these limits.
+// SetParallelism must be called before the runner's loop is started.
+func (r *Runner) SetParallelism(total, perStudy int) {
+	r.slots = nil
+	if total > 0 {
+		r.slots = make(chan struct{}, total)
+	}
+	r.studyParallelism = perStudy
+}
+
+// AcquireSlots waits until the run may be performed within the
+// concurrency limits of its study and its runner. The returned
+// function must be called to release the run's slots.
+func (r *run) acquireSlots(ctx context.Context, runner *Runner) (release func(), err error) {
+	limit := r.Study.MaxParallel
+	if limit <= 0 {
+		limit = runner.studyParallelism
+	}
+	var study chan struct{}
+	if limit > 0 {
+		runner.mu.Lock()
+		study = runner.limits[r.Study.Name]
+		if study == nil {
+			study = make(chan struct{}, limit)
+			runner.limits[r.Study.Name] = study
+		}
+		runner.mu.Unlock()
+	}
+	// The study's slot is acquired first, so that runs that are held
+	// back by their study's limit do not occupy the runner's slots.
+	for _, slots := range []chan struct{}{study, runner.slots} {
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			continue
+		default:
+		}
+		if slots == study {
+			r.setStatus(statusWaiting, fmt.Sprintf("waiting for one of the study's %d concurrent runs to complete", cap(slots)))
+		} else {
+			r.setStatus(statusWaiting, fmt.Sprintf("waiting for one of the runner's %d concurrent runs to complete", cap(slots)))
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			if slots == runner.slots && study != nil {
+				<-study
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		if runner.slots != nil {
+			<-runner.slots
+		}
+		if study != nil {
+			<-study
+		}
+	}, nil
+}
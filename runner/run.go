@@ -8,8 +8,11 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,12 +29,16 @@ import (
 var Logger = log.Debug
 
 var (
-	metricsPrefix = []byte("METRICS: ")
-	divinerPrefix = []byte("DIVINER: ")
+	metricsPrefix     = []byte("METRICS: ")
+	jsonMetricsPrefix = []byte("DIVINER_METRICS ")
+	divinerPrefix     = []byte("DIVINER: ")
 )
 
 const timeLayout = "20060102.150405"
 
+// MaxLineSize is the maximum size of a line of a script's output.
+const maxLineSize = bufio.MaxScanTokenSize
+
 // Status describes the current status of a run.
 type status int
 
@@ -47,12 +54,18 @@ const (
 	statusTimeout
 	// StatusErr indicates that the run failed.
 	statusErr
+	// StatusDeadline indicates that the run was killed because it
+	// exceeded its timeout.
+	statusDeadline
+	// StatusPreempted indicates that the run was interrupted because
+	// its machine was preempted.
+	statusPreempted
 )
 
 // Done tells whether the status indicatest that the process
 // has completed.
 func (s status) Done() bool {
-	return s == statusOk || s == statusErr
+	return s == statusOk || s == statusErr || s == statusDeadline || s == statusPreempted
 }
 
 // String returns a simple string describing the status s.
@@ -68,6 +81,10 @@ func (s status) String() string {
 		return "timeout"
 	case statusErr:
 		return "error"
+	case statusDeadline:
+		return "deadline exceeded"
+	case statusPreempted:
+		return "preempted"
 	default:
 		panic(s)
 	}
@@ -92,23 +109,55 @@ type run struct {
 	Acquire func(vals diviner.Values, replicate int, id string) (diviner.Metrics, error)
 
 	count int
+	// Restored is the URL of the checkpoint from which the current
+	// attempt was resumed, if any.
+	restored string
+	// Exploit is the exploit decided upon by the study's exploiter,
+	// if any, which stopped the run's current attempt.
+	exploit *exploit
+	// Origin describes the run from which this run was forked by an
+	// exploit, if any.
+	origin string
 
 	mu            sync.Mutex
 	status        status
 	statusMessage string
+	// transient indicates that the run failed due to an
+	// infrastructure error, and not the run itself.
+	transient bool
 	// Metrics stores the last reported metrics for the run.
 	metrics diviner.Metrics
+	// History stores every metrics report made by the run, in the
+	// order reported.
+	history []diviner.Metrics
 	// Time when the run first entered running state.
 	start time.Time
+	// Exit describes how the script of the run's last try exited.
+	exit *diviner.RunExit
+	// MachineType and region describe the machine on which the run
+	// was last performed, if its system describes them.
+	machineType, region string
+	// Duration is the total time for which the run's script has
+	// executed, over all of its tries.
+	duration time.Duration
 }
 
 // Do performs the run using the provided runner after first coordinating
 // that its dataset dependencies are satisfied through the same.
 func (r *run) Do(ctx context.Context, runner *Runner) {
 	if r.Acquire != nil {
+		release, err := r.acquireSlots(ctx, runner)
+		if err != nil {
+			r.error(err)
+			return
+		}
+		defer release()
 		r.doAcquire(ctx, runner)
 		return
 	}
+	r.mu.Lock()
+	r.exit = nil
+	r.mu.Unlock()
 	// First, make sure that our dependent datasets have completed
 	// without error.
 	datasets := make([]*dataset, len(r.Config.Datasets))
@@ -126,17 +175,34 @@ func (r *run) Do(ctx context.Context, runner *Runner) {
 			return
 		case <-dataset.Done():
 			if err := dataset.Err(); err != nil {
-				r.errorf("failed to process dataset %s: %v", dataset.Name, err)
+				r.transientf("failed to process dataset %s: %v", dataset.Name, err)
 				return
 			}
 		}
 	}
-	r.setStatus(statusWaiting, "waiting for worker")
-	w, err := runner.allocate(ctx, r.Config.Systems)
+	release, err := r.acquireSlots(ctx, runner)
 	if err != nil {
 		r.error(err)
 		return
 	}
+	defer release()
+	systems, err := configureSystems(r.Config.Systems, r.Config.Resources)
+	if err != nil {
+		r.error(err)
+		return
+	}
+	if sys := backendSystem(systems); sys != nil {
+		r.setMachine(sys.Backend)
+		r.doBackend(ctx, runner, sys)
+		return
+	}
+	r.setStatus(statusWaiting, "waiting for worker")
+	w, err := runner.allocate(ctx, r.Study.Name, systems)
+	if err != nil {
+		r.transientf("%v", err)
+		return
+	}
+	r.setMachine(w.Session.System.System)
 	ctx, cancel := context.WithCancel(ctx)
 	var canceled int64
 	alarm := newAlarm(func() {
@@ -150,31 +216,121 @@ func (r *run) Do(ctx context.Context, runner *Runner) {
 			w.err = errors.New("worker task timed out")
 			r.setStatus(statusTimeout, "task timed out from its own keepalive")
 		}
+		// Runs on spot systems whose machines disappeared were
+		// preempted, whatever error they observed.
+		if w.Session.System.Spot && r.interrupted() && w.Lost() {
+			w.err = errors.New("machine was lost")
+			r.setStatus(statusPreempted, fmt.Sprintf("machine %s was lost", w.Addr))
+		}
 		w.Return()
 	}()
 
 	r.setStatus(statusRunning, "")
 	if err := w.Reset(ctx); err != nil {
-		r.error(err)
+		r.transientf("%v", err)
 		return
 	}
 	if err := w.CopyFiles(ctx, r.Config.LocalFiles); err != nil {
-		r.error(err)
+		r.transientf("%v", err)
 		return
 	}
+	r.restored = ""
+	ckpt := r.checkpointURL()
+	if ckpt != "" {
+		restored, err := w.RestoreCheckpoint(ctx, r.Config.Checkpoint.Path, ckpt)
+		if err != nil {
+			r.transientf("failed to restore checkpoint %s: %v", ckpt, err)
+			return
+		}
+		if restored {
+			r.restored = ckpt
+		}
+	}
 	r.mu.Lock()
 	r.start = time.Now()
 	r.mu.Unlock()
 
-	// This is to enable unit-testing of the keeaplive/retry mechanism.
-	env := []string{fmt.Sprintf("DIVINER_TEST_COUNT=%d", r.count)}
-	r.count++
+	out, err := w.Run(ctx, r.Config.Script, r.env(), container{
+		Image: r.Config.Image,
+		GPU:   r.Config.Resources.GPU > 0,
+	})
+	if err != nil {
+		r.transientf("failed to start script: %s", err)
+		return
+	}
+	if ckpt != "" {
+		var (
+			done = make(chan struct{})
+			wg   sync.WaitGroup
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.saveCheckpoints(ctx, w, ckpt, done)
+		}()
+		defer func() {
+			close(done)
+			wg.Wait()
+		}()
+	}
+	r.process(ctx, runner, out, w.Addr, alarm, cancel)
+}
+
+// DoBackend performs the run by submitting it as a job to the
+// provided system's backend.
+func (r *run) doBackend(ctx context.Context, runner *Runner, sys *diviner.System) {
+	r.setStatus(statusWaiting, "waiting for worker")
+	release, err := runner.acquireBackend(ctx, sys)
+	if err != nil {
+		r.transientf("%v", err)
+		return
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var canceled int64
+	alarm := newAlarm(func() {
+		atomic.StoreInt64(&canceled, 1)
+		cancel()
+	})
+	go alarm.Do(ctx)
+
+	defer func() {
+		if atomic.LoadInt64(&canceled) == 1 {
+			r.setStatus(statusTimeout, "task timed out from its own keepalive")
+		}
+	}()
 
-	out, err := w.Run(ctx, r.Config.Script, env)
+	r.setStatus(statusRunning, "")
+	job, err := newJob(r.Run.ID(), r.Config.Script, r.Config.LocalFiles, r.Config.Resources, sys)
 	if err != nil {
-		r.errorf("failed to start script: %s", err)
+		r.transientf("%v", err)
 		return
 	}
+	r.restored = ""
+	r.mu.Lock()
+	r.start = time.Now()
+	r.mu.Unlock()
+
+	job.Env = r.env()
+	job.Image = r.Config.Image
+
+	out, err := sys.Backend.Run(ctx, job)
+	if err != nil {
+		r.transientf("failed to start script: %s", err)
+		return
+	}
+	defer out.Close()
+	r.process(ctx, runner, out, sys.ID, alarm, cancel)
+}
+
+// Process processes the output of the run's script, which is
+// executing on the host addr: metrics are reported, directives are
+// interpreted, and the remainder is written to the run's log, where
+// lines from the script's standard error remain tagged with
+// diviner.StderrTag. The run's status and exit are set upon
+// completion. Cancel terminates the script.
+func (r *run) process(ctx context.Context, runner *Runner, out io.Reader, addr string, alarm *alarm, cancel func()) {
 	r.setStatus(statusRunning, "")
 
 	logger := runner.db.Logger(r.Run.Study, r.Run.Seq)
@@ -183,9 +339,35 @@ func (r *run) Do(ctx context.Context, runner *Runner) {
 			log.Error.Printf("%s:%d: error closing logger: %v", r.Run.Study, r.Run.Seq, err)
 		}
 	}()
-	fmt.Fprintf(logger, "diviner: started run (try %d) at %s on %s\n", r.count, r.start.Local(), w.Addr)
+	fmt.Fprintf(logger, "diviner: started run (try %d) at %s on %s\n", r.count, r.start.Local(), addr)
+	if r.origin != "" {
+		fmt.Fprintf(logger, "diviner: %s\n", r.origin)
+	}
+	if r.restored != "" {
+		fmt.Fprintf(logger, "diviner: resumed from checkpoint %s\n", r.restored)
+	}
+	if r.Config.Image != "" {
+		fmt.Fprintf(logger, "diviner: running in container image %s\n", r.Config.Image)
+	}
+
+	var deadline int32
+	if timeout := r.Config.Timeout; timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&deadline, 1)
+			cancel()
+		})
+		defer timer.Stop()
+	}
 
+	var (
+		stopped, exploited bool
+		code               = -1
+		signal             string
+		stderr             []string
+	)
 	scan := bufio.NewScanner(out)
+	// Lines may carry a stream tag.
+	scan.Buffer(nil, maxLineSize+1)
 	// ScanProgress tells us how to scan "progress bar" output from
 	// the likes of Tensorflow. This allows us to properly separate these
 	// out as lines for status output and also filter them when persisting
@@ -193,22 +375,45 @@ func (r *run) Do(ctx context.Context, runner *Runner) {
 	scan.Split(scanProgress)
 	for scan.Scan() {
 		line := scan.Bytes()
-		// TODO: make the prefix configurable, or perhaps even
-		// different ways of communicating metrics.
-		if bytes.HasPrefix(line, metricsPrefix) {
-			line := string(line)
-			metrics, err := parseMetrics(strings.TrimPrefix(line, "METRICS: "))
+		fromStderr := len(line) > 0 && line[0] == diviner.StderrTag
+		if fromStderr {
+			line = line[1:]
+		}
+		if !fromStderr && len(line) > 0 && line[0] == exitTag {
+			code, signal = parseExitTrailer(string(line[1:]))
+		} else if metrics, labels, ok, err := parseMetricsLine(line); ok {
+			// TODO: make the prefix configurable, or perhaps even
+			// different ways of communicating metrics.
 			if err != nil {
 				log.Error.Printf("%s:%d: error parsing metrics: %v", r.Run.Study, r.Run.Seq, err)
 			} else {
-				r.report(metrics)
-				if err := runner.db.AppendRunMetrics(ctx, r.Run.Study, r.Run.Seq, metrics); err != nil {
-					log.Error.Printf("%s:%d: failed to report metrics to DB: %v", r.Run.Study, r.Run.Seq, err)
+				if len(labels) > 0 {
+					if _, err := diviner.UpdateLabels(ctx, runner.db, r.Run.Study, r.Run.Seq, labels, nil); err != nil {
+						log.Error.Printf("%s:%d: failed to record string metrics as labels: %v", r.Run.Study, r.Run.Seq, err)
+					}
+				}
+				if len(metrics) > 0 {
+					r.report(metrics)
+					if err := runner.db.AppendRunMetrics(ctx, r.Run.Study, r.Run.Seq, metrics); err != nil {
+						log.Error.Printf("%s:%d: failed to report metrics to DB: %v", r.Run.Study, r.Run.Seq, err)
+					}
+					if step, ok := r.shouldStop(); ok {
+						stopped = true
+						fmt.Fprintf(logger, "diviner: run stopped early by scheduler at step %d\n", step)
+						// Canceling the context also terminates the process.
+						cancel()
+					} else if e, ok := r.shouldExploit(runner); ok {
+						exploited = true
+						fmt.Fprintf(logger, "diviner: run exploited run %s; continuing with values %s\n", e.From, e.Values)
+						cancel()
+					}
 				}
 			}
 		} else if bytes.HasPrefix(line, divinerPrefix) {
 			line := string(bytes.TrimPrefix(line, divinerPrefix))
-			if !strings.HasPrefix(line, "keepalive=") {
+			if strings.HasPrefix(line, "artifact=") {
+				r.addArtifact(ctx, runner, logger, strings.TrimPrefix(line, "artifact="))
+			} else if !strings.HasPrefix(line, "keepalive=") {
 				log.Error.Printf("unknown diviner directive %s", line)
 			} else if dur, err := time.ParseDuration(strings.TrimPrefix(line, "keepalive=")); err != nil {
 				log.Error.Printf("%s:%d: error parsing directive %s: %v", r.Run.Study, r.Run.Seq, line, err)
@@ -227,6 +432,15 @@ func (r *run) Do(ctx context.Context, runner *Runner) {
 
 			r.setStatus(statusRunning, string(line))
 			if !progress {
+				if fromStderr {
+					if len(stderr) == diviner.MaxExitStderr {
+						stderr = stderr[1:]
+					}
+					stderr = append(stderr, string(line))
+					if _, err := logger.Write([]byte{diviner.StderrTag}); err != nil {
+						log.Error.Printf("%s: write: %v", r, err)
+					}
+				}
 				if _, err := logger.Write(line); err != nil {
 					log.Error.Printf("%s: write: %v", r, err)
 				}
@@ -242,11 +456,63 @@ func (r *run) Do(ctx context.Context, runner *Runner) {
 		}
 	}
 	elapsed := time.Since(r.start)
-	if err := scan.Err(); err == nil {
+	r.mu.Lock()
+	r.duration += elapsed
+	r.mu.Unlock()
+	exit := &diviner.RunExit{Code: code, Signal: signal, Stderr: stderr}
+	if atomic.LoadInt32(&deadline) == 1 {
+		fmt.Fprintf(logger, "diviner: run killed after exceeding its timeout of %s\n", r.Config.Timeout)
+		r.setStatus(statusDeadline, fmt.Sprintf("run timed out after %s", elapsed))
+		exit.Reason = fmt.Sprintf("exceeded timeout of %s", r.Config.Timeout)
+	} else if stopped {
+		r.setStatus(statusOk, fmt.Sprintf("%s (stopped early)", elapsed))
+	} else if exploited {
+		r.setStatus(statusOk, fmt.Sprintf("%s (exploited)", elapsed))
+	} else if err := scan.Err(); err == nil {
 		r.setStatus(statusOk, elapsed.String())
+	} else if errors.Is(err, diviner.ErrPreempted) {
+		fmt.Fprintf(logger, "diviner: run preempted: %v\n", err)
+		r.setStatus(statusPreempted, fmt.Sprintf("run preempted after %s: %v", elapsed, err))
+		exit.Reason = err.Error()
 	} else {
 		r.errorf("run failed after %s: %v", elapsed, err)
+		exit.Reason = err.Error()
 	}
+	r.mu.Lock()
+	r.exit = exit
+	r.mu.Unlock()
+}
+
+// ParseExitTrailer parses the exit status reported by a worker's
+// trailer line (see exitTrailer), returning an exit code of -1 if the
+// script was terminated by a signal.
+func parseExitTrailer(s string) (code int, signal string) {
+	code = -1
+	switch {
+	case strings.HasPrefix(s, "exit="):
+		if c, err := strconv.Atoi(strings.TrimPrefix(s, "exit=")); err == nil {
+			code = c
+		}
+	case strings.HasPrefix(s, "signal="):
+		signal = strings.TrimPrefix(s, "signal=")
+	}
+	return
+}
+
+// AddArtifact registers the artifact described by the provided
+// directive argument with the run, noting it in the run's log.
+func (r *run) addArtifact(ctx context.Context, runner *Runner, logger io.Writer, arg string) {
+	artifact, err := diviner.ParseArtifact(arg)
+	if err != nil {
+		log.Error.Printf("%s: %v", r, err)
+		fmt.Fprintf(logger, "diviner: invalid artifact: %v\n", err)
+		return
+	}
+	if _, err := diviner.AddArtifact(ctx, runner.db, r.Run.Study, r.Run.Seq, artifact); err != nil {
+		log.Error.Printf("%s: failed to register artifact %s: %v", r, artifact.Name, err)
+		return
+	}
+	fmt.Fprintf(logger, "diviner: registered artifact %s\n", artifact)
 }
 
 func (r *run) doAcquire(ctx context.Context, runner *Runner) {
@@ -266,6 +532,91 @@ func (r *run) doAcquire(ctx context.Context, runner *Runner) {
 	r.setStatus(statusOk, elapsed.String())
 }
 
+// Env returns the environment of the run's next try: the variables
+// of the run's config, followed by those defined by diviner.
+func (r *run) env() []string {
+	env := make([]string, 0, len(r.Config.Env)+3)
+	for name, value := range r.Config.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	if r.Config.Seed != 0 {
+		env = append(env, fmt.Sprintf("DIVINER_SEED=%d", r.Config.Seed))
+	}
+	// This is to enable unit-testing of the keeaplive/retry mechanism.
+	env = append(env, fmt.Sprintf("DIVINER_TEST_COUNT=%d", r.count))
+	r.count++
+	if ckpt := r.checkpointURL(); ckpt != "" {
+		env = append(env, "DIVINER_CHECKPOINT_URL="+ckpt)
+	}
+	return env
+}
+
+// CheckpointURL returns the URL at which the run's checkpoint is
+// stored, or an empty string if the run is not checkpointed. Retries
+// of a run share the checkpoint of the original run.
+func (r *run) checkpointURL() string {
+	if r.Config.Checkpoint.IsZero() {
+		return ""
+	}
+	seq := r.Run.RetryOf
+	if seq == 0 {
+		seq = r.Run.Seq
+	}
+	return r.Config.Checkpoint.Location(r.Run.Study, seq)
+}
+
+// SaveCheckpoints periodically saves the run's checkpoint from the
+// provided worker to the given URL, until done is closed.
+func (r *run) saveCheckpoints(ctx context.Context, w *worker, url string, done <-chan struct{}) {
+	interval := r.Config.Checkpoint.Interval
+	if interval <= 0 {
+		interval = diviner.DefaultCheckpointInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+		if err := w.SaveCheckpoint(ctx, r.Config.Checkpoint.Path, url); err != nil {
+			log.Error.Printf("%s: failed to save checkpoint %s: %v", r, url, err)
+		} else {
+			Logger.Printf("%s: saved checkpoint %s", r, url)
+		}
+	}
+}
+
+// ConfigureSystems returns the systems, configured from the provided
+// list, whose machines provide the required resources. Systems that
+// cannot be configured are skipped; configureSystems returns an error
+// if none remain.
+func configureSystems(systems []*diviner.System, need diviner.Resources) ([]*diviner.System, error) {
+	if need.IsZero() {
+		return systems, nil
+	}
+	var (
+		configured = make([]*diviner.System, 0, len(systems))
+		errs       []string
+	)
+	for _, sys := range systems {
+		c, err := sys.Configure(need)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		configured = append(configured, c)
+	}
+	if len(configured) == 0 {
+		return nil, fmt.Errorf("no system satisfies %s: %s", need, strings.Join(errs, "; "))
+	}
+	return configured, nil
+}
+
 // String returns a textual description of this run.
 func (r *run) String() string {
 	return fmt.Sprintf("%s:%d", r.Run.Study, r.Run.Seq)
@@ -276,6 +627,7 @@ func (r *run) report(metrics diviner.Metrics) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.metrics.Merge(metrics)
+	r.history = append(r.history, metrics)
 }
 
 // Metrics returns the last reported metrics for this run.
@@ -292,12 +644,160 @@ func (r *run) Metrics() diviner.Metrics {
 	return copy
 }
 
+// History returns the full history of metrics reported by this
+// run, in the order reported. Reports may be tagged with a step;
+// see diviner.StepMetric.
+func (r *run) History() []diviner.Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	history := make([]diviner.Metrics, len(r.history))
+	copy(history, r.history)
+	return history
+}
+
+// shouldStop consults the study's scheduler, if any, to decide
+// whether the run should be stopped given the metrics it has reported
+// so far. It returns the run's last step if so.
+func (r *run) shouldStop() (step int, ok bool) {
+	if r.Study.Scheduler == nil {
+		return 0, false
+	}
+	history := diviner.Run{Metrics: r.History()}.History()
+	if len(history) == 0 {
+		return 0, false
+	}
+	if !r.Study.Scheduler.Stop(r.Study.Objective, r.Run.ID(), history) {
+		return 0, false
+	}
+	return history[len(history)-1].Step, true
+}
+
+// An exploit is an exploit decided upon for a run.
+type exploit struct {
+	diviner.Exploit
+	// From is the sequence number of the exploited run.
+	from uint64
+	// Checkpoint is the URL of the checkpoint of the exploited run,
+	// or empty if it is not checkpointed.
+	checkpoint string
+}
+
+// ShouldExploit consults the study's exploiter, if any, to decide
+// whether the run should be exploited given the metrics it has
+// reported so far, and the metrics reported by the study's other
+// live runs. If so, the run's exploit is recorded and returned.
+func (r *run) shouldExploit(runner *Runner) (diviner.Exploit, bool) {
+	exploiter, ok := r.Study.Oracle.(diviner.Exploiter)
+	if !ok {
+		return diviner.Exploit{}, false
+	}
+	var (
+		member, _, _ = r.member()
+		population   []diviner.Member
+		seqs         = make(map[string]uint64)
+		checkpoints  = make(map[string]string)
+	)
+	runner.mu.Lock()
+	for _, other := range runner.runs[r.Study.Name] {
+		if other == r {
+			population = append(population, member)
+			continue
+		}
+		m, seq, checkpoint := other.member()
+		population = append(population, m)
+		seqs[m.ID] = seq
+		checkpoints[m.ID] = checkpoint
+	}
+	runner.mu.Unlock()
+	e, ok := exploiter.Exploit(r.Study.Objective, r.Study.Params, member, population)
+	if !ok || e.From == member.ID {
+		return diviner.Exploit{}, false
+	}
+	checkpoint, ok := checkpoints[e.From]
+	if !ok {
+		log.Error.Printf("%s: exploiter chose unknown run %s", r, e.From)
+		return diviner.Exploit{}, false
+	}
+	r.mu.Lock()
+	r.exploit = &exploit{Exploit: e, from: seqs[e.From], checkpoint: checkpoint}
+	r.mu.Unlock()
+	return e, true
+}
+
+// Member returns the run as a member of its study's population,
+// together with its sequence number and the URL of its checkpoint, if
+// any.
+func (r *run) member() (diviner.Member, uint64, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return diviner.Member{
+		ID:      r.Run.ID(),
+		Values:  r.Values,
+		History: diviner.Run{Metrics: r.history}.History(),
+	}, r.Run.Seq, r.checkpointURL()
+}
+
+// TakeExploit returns and clears the run's exploit, if any.
+func (r *run) takeExploit() *exploit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.exploit
+	r.exploit = nil
+	return e
+}
+
 // SetStatus sets the status for the run.
 func (r *run) setStatus(status status, message string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.status = status
 	r.statusMessage = message
+	r.transient = false
+}
+
+// Transientf sets the run's status to statusErr, as errorf, and
+// marks the failure as transient: it was caused by the
+// infrastructure and not the run itself.
+func (r *run) transientf(format string, v ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = statusErr
+	r.statusMessage = fmt.Sprintf(format, v...)
+	r.transient = true
+}
+
+// Interrupted tells whether the run's last try failed or timed out
+// from its own keepalive: i.e., whether it may have been interrupted
+// by the loss of its machine.
+func (r *run) interrupted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status == statusErr || r.status == statusTimeout
+}
+
+// Transient tells whether the run's last failure was transient.
+func (r *run) Transient() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status == statusErr && r.transient
+}
+
+// Reset resets the run to begin a new attempt as the provided
+// database run, with the given values and config.
+func (r *run) reset(run diviner.Run, values diviner.Values, config diviner.RunConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Run = run
+	r.Values = values
+	r.Config = config
+	r.exploit = nil
+	r.origin = ""
+	r.status = statusWaiting
+	r.statusMessage = ""
+	r.transient = false
+	r.metrics = nil
+	r.history = nil
+	r.start = time.Time{}
 }
 
 // Errorf sets the run's status to statusErr and formats the
@@ -312,6 +812,38 @@ func (r *run) error(v ...interface{}) {
 	r.setStatus(statusErr, fmt.Sprint(v...))
 }
 
+// Exit returns how the script of the run's last try exited, or nil if
+// it is not known.
+func (r *run) Exit() *diviner.RunExit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.exit
+}
+
+// SetMachine records the machine on which the run is performed, as
+// described by the provided system or backend. The machine is left
+// undescribed if it does not implement diviner.MachineDescriber.
+func (r *run) setMachine(v interface{}) {
+	var machineType, region string
+	if d, ok := v.(diviner.MachineDescriber); ok {
+		machineType, region = d.Machine()
+	}
+	r.mu.Lock()
+	r.machineType, r.region = machineType, region
+	r.mu.Unlock()
+}
+
+// Cost returns the run's cost, estimated by the provided pricing. It
+// returns false if the run's script never executed.
+func (r *run) Cost(pricing diviner.Pricing) (diviner.RunCost, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.duration == 0 {
+		return diviner.RunCost{}, false
+	}
+	return diviner.EstimateCost(pricing, r.machineType, r.region, r.duration), true
+}
+
 // Status returns the run's current status and message, and elapsed runtime.
 func (r *run) Status() (status, string, time.Duration) {
 	r.mu.Lock()
@@ -396,6 +928,85 @@ func scanProgress(data []byte, atEOF bool) (advance int, token []byte, err error
 	return 0, nil, nil
 }
 
+// ParseMetricsLine parses the metrics reported by a line of a run's
+// output, which may be either a "METRICS: " line of comma-separated
+// key-value pairs or a "DIVINER_METRICS " line carrying a JSON object.
+// The returned labels are the string-valued metrics of a JSON line.
+// ParseMetricsLine returns ok=false if the line reports no metrics.
+func parseMetricsLine(line []byte) (metrics diviner.Metrics, labels diviner.Labels, ok bool, err error) {
+	switch {
+	case bytes.HasPrefix(line, metricsPrefix):
+		metrics, err = parseMetrics(string(bytes.TrimPrefix(line, metricsPrefix)))
+		return metrics, nil, true, err
+	case bytes.HasPrefix(line, jsonMetricsPrefix):
+		metrics, labels, err = parseJSONMetrics(bytes.TrimPrefix(line, jsonMetricsPrefix))
+		return metrics, labels, true, err
+	}
+	return nil, nil, false, nil
+}
+
+// maxExactInt is the largest integer magnitude that is represented
+// exactly by a float64 metric.
+const maxExactInt = 1 << 53
+
+// ParseJSONMetrics parses a JSON object of metrics. Numbers are
+// metrics; integers must be represented exactly as metric values.
+// The step (diviner.StepMetric), if present, must be a non-negative
+// integer. Strings are returned as labels (see diviner.Labels), since
+// metrics are numeric. Other JSON values are not permitted.
+func parseJSONMetrics(p []byte) (diviner.Metrics, diviner.Labels, error) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON metrics: %v", err)
+	}
+	if obj == nil {
+		return nil, nil, errors.New("JSON metrics must be an object")
+	}
+	if dec.More() {
+		return nil, nil, errors.New("trailing data after JSON metrics")
+	}
+	var (
+		metrics = make(diviner.Metrics)
+		labels  diviner.Labels
+	)
+	for key, v := range obj {
+		switch v := v.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				if n > maxExactInt || n < -maxExactInt {
+					return nil, nil, fmt.Errorf("integer metric %s=%s cannot be represented exactly", key, v)
+				}
+				metrics[key] = float64(n)
+				break
+			}
+			if key == diviner.StepMetric {
+				return nil, nil, fmt.Errorf("step %s is not an integer", v)
+			}
+			f, err := v.Float64()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing metric %s: %v", key, err)
+			}
+			metrics[key] = f
+		case string:
+			if key == diviner.StepMetric {
+				return nil, nil, fmt.Errorf("step %q is not an integer", v)
+			}
+			if labels == nil {
+				labels = make(diviner.Labels)
+			}
+			labels[key] = v
+		default:
+			return nil, nil, fmt.Errorf("metric %s: unsupported value %v", key, v)
+		}
+	}
+	if step, ok := metrics[diviner.StepMetric]; ok && step < 0 {
+		return nil, nil, fmt.Errorf("negative step %v", step)
+	}
+	return metrics, labels, nil
+}
+
 func parseMetrics(line string) (diviner.Metrics, error) {
 	elems := strings.Split(line, ",")
 	metrics := make(diviner.Metrics)
@@ -10,8 +10,12 @@ package runner
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"sort"
 	"strings"
@@ -20,16 +24,20 @@ import (
 	"text/tabwriter"
 	"time"
 
+	"github.com/grailbio/base/errors"
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/bigmachine"
 	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/analysis"
+	"github.com/grailbio/diviner/stats"
+	"github.com/grailbio/diviner/storage"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
-	// IdleTime is the amount of time workers are allowed to remain idle
-	// before being stopped.
-	idleTime = 5 * time.Minute
+	// DefaultIdleTimeout is the default amount of time workers are
+	// allowed to remain idle before being stopped.
+	defaultIdleTimeout = 5 * time.Minute
 
 	keepaliveInterval = 15 * time.Second
 
@@ -44,6 +52,39 @@ const (
 type Runner struct {
 	db diviner.Database
 
+	// Stats is the sink to which the runner reports operational
+	// statistics.
+	stats stats.Sink
+
+	// Dedup tells whether the runner reuses previously completed runs
+	// instead of launching runs with the same values.
+	dedup bool
+
+	// Pricing prices the machines of runs, by which their costs are
+	// estimated.
+	pricing diviner.Pricing
+
+	// Source, if non-nil, is recorded with the runs created by the
+	// runner.
+	source *diviner.Source
+
+	// IdleTimeout, if positive, is the amount of time workers are
+	// allowed to remain idle before being stopped.
+	idleTimeout time.Duration
+
+	// Backup, if non-nil, is backed up to backupURL every
+	// backupInterval while the runner's loop is running.
+	backup         Backuper
+	backupURL      string
+	backupInterval time.Duration
+
+	// Slots, if non-nil, limits the number of runs performed
+	// concurrently by the runner; studyParallelism, if positive,
+	// limits the concurrent runs of studies without a MaxParallel.
+	// See SetParallelism.
+	slots            chan struct{}
+	studyParallelism int
+
 	requestc chan *request
 
 	// Time is the timestamp of runner.
@@ -55,8 +96,37 @@ type Runner struct {
 	mu       sync.Mutex
 	counters map[string]int
 	// Runs maps study names to the list of runs for this study.
-	runs     map[string][]*run
+	runs map[string][]*run
+	// Datasets stores the latest dataset processed for each dataset
+	// name; digests caches the digests of dataset definitions, keyed
+	// by datasetKey.
 	datasets map[string]*dataset
+	digests  map[string]string
+	// Best stores the best objective value attained thus far in
+	// each study with notifiers.
+	best map[string]float64
+	// Backends stores the slots used to limit the parallelism of
+	// backend systems.
+	backends map[*diviner.System]chan struct{}
+	// Limits stores the slots used to limit the number of
+	// concurrent runs of each study, keyed by study name.
+	limits map[string]chan struct{}
+	// Paused stores the studies that are paused. Each study's
+	// channel is closed when the study is resumed.
+	paused map[string]chan struct{}
+	// Oracles stores the studies whose (stateful) oracles' states
+	// have been restored from the database.
+	oracles map[string]bool
+	// Usage accounts for the runs completed by each study (see
+	// Usage); busy stores the number of workers held by each
+	// study's runs, as last published by the runner's loop.
+	usage map[string]*StudyUsage
+	busy  map[string]int
+	// Owner is the lease with which the runner holds the studies
+	// that it runs (see holdLease); leases stores the study leases
+	// currently held by the runner, keyed by study name.
+	owner  diviner.Lease
+	leases map[string]*lease
 
 	nrun int
 }
@@ -69,11 +139,188 @@ type Runner struct {
 func New(db diviner.Database) *Runner {
 	return &Runner{
 		db:       db,
+		stats:    stats.Nop,
+		pricing:  diviner.EC2Pricing,
 		time:     time.Now(),
 		counters: make(map[string]int),
 		requestc: make(chan *request),
 		datasets: make(map[string]*dataset),
+		digests:  make(map[string]string),
+		best:     make(map[string]float64),
+		backends: make(map[*diviner.System]chan struct{}),
+		limits:   make(map[string]chan struct{}),
 		runs:     make(map[string][]*run),
+		paused:   make(map[string]chan struct{}),
+		oracles:  make(map[string]bool),
+		usage:    make(map[string]*StudyUsage),
+		leases:   make(map[string]*lease),
+	}
+}
+
+// SetStats sets the sink to which the runner reports its operational
+// statistics: its counters (as gauges), run durations, run outcomes,
+// the objective values of successful runs, and the outcomes of
+// database backups (see SetBackup). SetStats must be called before the
+// runner's loop is started.
+func (r *Runner) SetStats(sink stats.Sink) {
+	r.stats = sink
+}
+
+// SetDedup sets whether the runner deduplicates runs: if dedup is
+// true, then before launching a new run in a study round or stream,
+// the runner consults its database for a successful run of the
+// study with exactly the same values and replicate number. If there
+// is one, its results are reused, and no new run is launched. This
+// prevents restarted studies, and oracles that suggest the same
+// values more than once, from repeating completed trials. SetDedup
+// must be called before the runner's loop is started.
+func (r *Runner) SetDedup(dedup bool) {
+	r.dedup = dedup
+}
+
+// SetPricing sets the pricing by which the runner estimates the costs
+// of runs, which are recorded with them (see diviner.Run.Cost). By
+// default, runs on EC2 are priced by diviner.EC2Pricing; runs on
+// systems that do not describe their machines (see
+// diviner.MachineDescriber), or whose prices are unknown, are
+// recorded with their durations only. SetPricing must be called
+// before the runner's loop is started.
+func (r *Runner) SetPricing(pricing diviner.Pricing) {
+	r.pricing = pricing
+}
+
+// SetIdleTimeout sets the amount of time for which the runner keeps
+// idle workers alive. Until they are stopped, idle workers are reused
+// by subsequent runs (of any study) on the same systems, avoiding the
+// cost of starting new machines; the contents of the machines' cache
+// directories (see DIVINER_CACHE in diviner.RunConfig) are retained
+// between such runs. If timeout is not positive, the default is used:
+// idle workers are stopped after 5 minutes. SetIdleTimeout must be
+// called before the runner's loop is started.
+func (r *Runner) SetIdleTimeout(timeout time.Duration) {
+	r.idleTimeout = timeout
+}
+
+// SetSource sets the source (e.g., as captured by
+// diviner.CaptureSource from the script that defines the runner's
+// studies) that is recorded with each run created by the runner.
+// Retries are recorded with the source of the run they retry.
+// SetSource must be called before the runner's loop is started.
+func (r *Runner) SetSource(source diviner.Source) {
+	r.source = &source
+}
+
+// A Backuper is a database that can write a snapshot of itself to a
+// URL, e.g., a localdb.DB.
+type Backuper interface {
+	Backup(ctx context.Context, url string) error
+}
+
+// SetBackup sets the runner to back up the provided database, which
+// is typically the database underlying the runner's (e.g., a local
+// database), to the provided URL (e.g., on S3) at the provided
+// interval while the runner's loop is running, so that the loss of
+// the machine hosting the database does not lose the studies' runs.
+// Each backup replaces the previous one; failed backups are logged,
+// and retried at the next interval. SetBackup must be called before
+// the runner's loop is started.
+func (r *Runner) SetBackup(db Backuper, url string, interval time.Duration) {
+	r.backup, r.backupURL, r.backupInterval = db, url, interval
+}
+
+// BackupEvery backs up the runner's database at the backup interval
+// until the provided context is done.
+func (r *Runner) backupEvery(ctx context.Context) {
+	tick := time.NewTicker(r.backupInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		start := time.Now()
+		if err := r.backup.Backup(ctx, r.backupURL); err != nil {
+			if ctx.Err() == nil {
+				log.Error.Printf("backup to %s failed: %v", r.backupURL, err)
+			}
+			r.stats.Count("runner.backups", 1, stats.Tag("state", "failure"))
+			continue
+		}
+		Logger.Printf("backed up database to %s in %s", r.backupURL, time.Since(start))
+		r.stats.Count("runner.backups", 1, stats.Tag("state", "success"))
+	}
+}
+
+// StudyCost returns the aggregate cost of the named study's runs,
+// as recorded in the runner's database.
+func (r *Runner) StudyCost(ctx context.Context, study string) (diviner.CostSummary, error) {
+	return diviner.StudyCost(ctx, r.db, study)
+}
+
+// Pause pauses the named study: the runner stops launching new
+// trials for the study, while its in-flight runs are allowed to
+// complete. Rounds (see Round) started while the study is paused,
+// and streaming studies (see Stream), wait until the study is
+// resumed. A paused study whose in-flight runs have completed may be
+// safely abandoned, for example to survive a maintenance window: the
+// state of a study is kept in the runner's database, and so the study
+// may be resumed later by another runner, whose oracle is given all
+// of the study's previous trials. Pausing a study that is already
+// paused has no effect.
+func (r *Runner) Pause(study string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.paused[study]; ok {
+		return
+	}
+	r.paused[study] = make(chan struct{})
+	Logger.Printf("%s: study paused", study)
+}
+
+// Resume resumes the named study after it was paused by Pause.
+// Resuming a study that is not paused has no effect.
+func (r *Runner) Resume(study string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.paused[study]
+	if !ok {
+		return
+	}
+	close(c)
+	delete(r.paused, study)
+	Logger.Printf("%s: study resumed", study)
+}
+
+// Paused tells whether the named study is paused.
+func (r *Runner) Paused(study string) bool {
+	return r.pausedc(study) != nil
+}
+
+// Pausedc returns the channel that is closed when the named study is
+// resumed, or nil if the study is not paused.
+func (r *Runner) pausedc(study string) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.paused[study]; ok {
+		return c
+	}
+	return nil
+}
+
+// WaitResumed blocks until the provided study is not paused, or
+// until the context is done.
+func (r *Runner) waitResumed(ctx context.Context, study diviner.Study) error {
+	for {
+		c := r.pausedc(study.Name)
+		if c == nil {
+			return nil
+		}
+		select {
+		case <-c:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
@@ -82,10 +329,116 @@ func (r *Runner) StartTime() time.Time {
 	return r.time
 }
 
+// A RunStatus describes the progress of a run that is currently
+// managed by a runner.
+type RunStatus struct {
+	// Study is the name of the run's study.
+	Study string
+	// Seq is the run's sequence number in its study.
+	Seq uint64
+	// Replicate is the run's replicate number.
+	Replicate int
+	// Attempt is the run's attempt number (see diviner.Run.Attempt).
+	Attempt int
+	// Values are the run's parameter values.
+	Values diviner.Values
+	// Status is the run's current status: "waiting", "running", and
+	// so on.
+	Status string
+	// Message is the run's last status message.
+	Message string
+	// Elapsed is the time elapsed since the run started executing,
+	// or zero if it has not yet started.
+	Elapsed time.Duration
+	// Metrics are the latest metrics reported by the run.
+	Metrics diviner.Metrics
+}
+
+// ID returns the run's ID (see diviner.Run.ID).
+func (s RunStatus) ID() string {
+	return fmt.Sprintf("%s:%d", s.Study, s.Seq)
+}
+
+// Status returns the progress of each of the runs currently managed
+// by the runner, ordered by study name and sequence number.
+func (r *Runner) Status() []RunStatus {
+	r.mu.Lock()
+	var statuses []RunStatus
+	for _, runs := range r.runs {
+		for _, run := range runs {
+			status, message, elapsed := run.Status()
+			statuses = append(statuses, RunStatus{
+				Study:     run.Study.Name,
+				Seq:       run.Run.Seq,
+				Replicate: run.Run.Replicate,
+				Attempt:   run.Run.Attempt,
+				Values:    run.Values,
+				Status:    status.String(),
+				Message:   message,
+				Elapsed:   elapsed,
+				Metrics:   run.Metrics(),
+			})
+		}
+	}
+	r.mu.Unlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Study != statuses[j].Study {
+			return statuses[i].Study < statuses[j].Study
+		}
+		return statuses[i].Seq < statuses[j].Seq
+	})
+	return statuses
+}
+
 // ServeHTTP implements http.Handler, providing a simple status page used
-// to examine the currently running trials, organized by study.
+// to examine the currently running trials, organized by study. If the
+// request's query includes "format=json", the status of each run
+// (see Status) is instead served as a JSON array; metrics that are
+// NaN or infinite are omitted.
+//
+// Studies may be paused and resumed (see Pause and Resume) by POST
+// requests to the paths /pause and /resume, with the study named by
+// the query parameter "study". The path /table serves the successful
+// and pending trials of the study named by the query parameter
+// "study" as a JSON-encoded analysis.Table, for plotting; the path
+// /convergence serves the study's diviner.Convergence, the best
+// objective value it attained as its runs completed, as JSON.
 func (r *Runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/pause", "/resume":
+		r.servePause(w, req)
+		return
+	case "/table":
+		r.serveTable(w, req)
+		return
+	case "/convergence":
+		r.serveConvergence(w, req)
+		return
+	}
+	if req.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		statuses := r.Status()
+		if statuses == nil {
+			statuses = []RunStatus{}
+		}
+		// NaN metrics cannot be represented in JSON.
+		for _, status := range statuses {
+			for name, v := range status.Metrics {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					delete(status.Metrics, name)
+				}
+			}
+		}
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			log.Error.Printf("error encoding run statuses: %v", err)
+		}
+		return
+	}
 	var buf bytes.Buffer // so we don't hold the lock while waiting for clients
+	usage := make(map[string]StudyUsage)
+	for _, u := range r.Usage() {
+		usage[u.Study] = u
+	}
 	r.mu.Lock()
 	names := make([]string, 0, len(r.runs))
 	for name := range r.runs {
@@ -101,7 +454,14 @@ func (r *Runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		var tw tabwriter.Writer
 		tw.Init(&buf, 4, 4, 1, ' ', 0)
 
-		fmt.Fprintf(&tw, "study %s:\n", study.Name)
+		if _, ok := r.paused[name]; ok {
+			fmt.Fprintf(&tw, "study %s (paused):\n", study.Name)
+		} else {
+			fmt.Fprintf(&tw, "study %s:\n", study.Name)
+		}
+		u := usage[name]
+		fmt.Fprintf(&tw, "\tusage:\t%d running, %d pending, %d workers; %d completed, %d failed, runtime %s\n",
+			u.Running, u.Pending, u.Workers, u.Completed, u.Failed, u.Runtime)
 		fmt.Fprintln(&tw, "\tparams:")
 		for _, param := range study.Params.Sorted() {
 			fmt.Fprintf(&tw, "\t\t%s:\t%s\n", param.Name, param)
@@ -159,6 +519,95 @@ func (r *Runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	_, _ = io.Copy(w, &buf)
 }
 
+// ServePause serves requests to pause or resume a study. The
+// response reports the number of the study's runs that are still
+// in flight.
+func (r *Runner) servePause(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	study := req.URL.Query().Get("study")
+	if study == "" {
+		http.Error(w, "missing study", http.StatusBadRequest)
+		return
+	}
+	if req.URL.Path == "/pause" {
+		r.Pause(study)
+	} else {
+		r.Resume(study)
+	}
+	r.mu.Lock()
+	n := len(r.runs[study])
+	r.mu.Unlock()
+	state := "resumed"
+	if r.Paused(study) {
+		state = "paused"
+	}
+	fmt.Fprintf(w, "%s: %s; %d runs in flight\n", study, state, n)
+}
+
+func (r *Runner) serveTable(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("study")
+	if name == "" {
+		http.Error(w, "missing study", http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	r.mu.Lock()
+	var (
+		study diviner.Study
+		ok    bool
+	)
+	if runs := r.runs[name]; len(runs) > 0 {
+		study, ok = runs[0].Study, true
+	}
+	r.mu.Unlock()
+	if !ok {
+		var err error
+		if study, err = r.db.LookupStudy(ctx, name); err == diviner.ErrNotExist {
+			http.Error(w, fmt.Sprintf("study %s does not exist", name), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	m, err := diviner.Trials(ctx, r.db, study, diviner.Success|diviner.Pending)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var trials []diviner.Trial
+	m.Range(func(_ diviner.Value, v interface{}) {
+		trials = append(trials, v.(diviner.Trial))
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(analysis.NewTable(study.Params, trials)); err != nil {
+		log.Error.Printf("error encoding table of study %s: %v", name, err)
+	}
+}
+
+func (r *Runner) serveConvergence(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("study")
+	if name == "" {
+		http.Error(w, "missing study", http.StatusBadRequest)
+		return
+	}
+	c, err := diviner.StudyConvergence(req.Context(), r.db, name, diviner.Objective{})
+	if err == diviner.ErrNotExist {
+		http.Error(w, fmt.Sprintf("study %s does not exist", name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		log.Error.Printf("error encoding convergence of study %s: %v", name, err)
+	}
+}
+
 // Counters returns a set of runtime counters from this runner's Do loop.
 func (r *Runner) Counters() map[string]int {
 	r.mu.Lock()
@@ -170,6 +619,23 @@ func (r *Runner) Counters() map[string]int {
 	return counters
 }
 
+// CountRuns returns the number of the runner's runs that are
+// pending (i.e., waiting for datasets or machines) and running. It
+// must be called with r.mu held.
+func (r *Runner) countRuns() (npending, nrunning int) {
+	for _, runs := range r.runs {
+		for _, run := range runs {
+			switch status, _, _ := run.Status(); status {
+			case statusRunning:
+				nrunning++
+			case statusWaiting:
+				npending++
+			}
+		}
+	}
+	return
+}
+
 // Loop is the runner's main run loop, managing clusters of machines
 // and allocating workers among the runs. The runner stops doing work
 // when the provided context is canceled. All errors are fatal: the
@@ -177,9 +643,17 @@ func (r *Runner) Counters() map[string]int {
 //
 // BUG(marius): the runner should re-create failed machines.
 func (r *Runner) Loop(ctx context.Context) error {
+	idleTimeout := r.idleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	interval := 10 * time.Second
+	if interval > idleTimeout/2 {
+		interval = idleTimeout / 2
+	}
 	var (
-		tick                   = time.NewTicker(10 * time.Second)
-		nworker                int
+		tick                   = time.NewTicker(interval)
+		nworker, nreused       int
 		ndone, nfail, nstarted int
 		workerc                = make(chan *worker)
 
@@ -187,7 +661,16 @@ func (r *Runner) Loop(ctx context.Context) error {
 		// sessions maintained by the runner, keyed by the
 		// diviner system represented by the session.
 		sessions = make(map[*diviner.System]*session)
+
+		// Share tracks the workers held by each study's runs,
+		// by which workers are shared among the studies. Nrequest
+		// numbers requests in order of arrival.
+		share    = newShare()
+		nrequest uint64
 	)
+	if r.backup != nil && r.backupInterval > 0 {
+		go r.backupEvery(ctx)
+	}
 	defer func() {
 		for _, sess := range sessions {
 			for _, w := range sess.Idle {
@@ -197,18 +680,52 @@ func (r *Runner) Loop(ctx context.Context) error {
 		}
 	}()
 	updateCounters := func() {
+		var nidle int
+		for _, sess := range sessions {
+			nidle += len(sess.Idle)
+		}
 		r.mu.Lock()
+		npending, nrunning := r.countRuns()
 		r.counters["nworker"] = nworker
+		r.counters["nidle"] = nidle
 		r.counters["ndone"] = ndone
 		r.counters["nfail"] = nfail
 		r.counters["nstarted"] = nstarted
+		r.counters["nreused"] = nreused
+		r.counters["npending"] = npending
+		r.counters["nrunning"] = nrunning
+		r.busy = make(map[string]int, len(share.busy))
+		for study, n := range share.busy {
+			r.busy[study] = n
+		}
 		r.mu.Unlock()
+		r.stats.Gauge("runner.nworker", float64(nworker))
+		r.stats.Gauge("runner.nidle", float64(nidle))
+		r.stats.Gauge("runner.ndone", float64(ndone))
+		r.stats.Gauge("runner.nfail", float64(nfail))
+		r.stats.Gauge("runner.nstarted", float64(nstarted))
+		r.stats.Gauge("runner.nreused", float64(nreused))
+		r.stats.Gauge("runner.npending", float64(npending))
+		r.stats.Gauge("runner.nrunning", float64(nrunning))
+		for study := range share.served {
+			if study != "" {
+				r.stats.Gauge("study.workers", float64(share.busy[study]), stats.Tag("study", study))
+			}
+		}
 	}
 	reply := func(r *request, w *worker) {
-		select {
-		case <-ctx.Done():
-		case r.replyc <- w:
+		w.holder, w.held = r.study, true
+		share.acquire(r.study)
+		if w.uses > 0 {
+			nreused++
 		}
+		w.uses++
+		go func() {
+			select {
+			case <-ctx.Done():
+			case r.replyc <- w:
+			}
+		}()
 	}
 outer:
 	for {
@@ -218,7 +735,7 @@ outer:
 			return ctx.Err()
 		case <-tick.C:
 			for _, sess := range sessions {
-				for len(sess.Idle) > 0 && time.Since(sess.Idle[0].IdleTime) > idleTime {
+				for len(sess.Idle) > 0 && time.Since(sess.Idle[0].IdleTime) > idleTimeout {
 					var w *worker
 					w, sess.Idle = sess.Idle[0], sess.Idle[1:]
 					Logger.Printf("worker %s idled out from pool", w)
@@ -234,6 +751,8 @@ outer:
 			if len(req.sessions) > 0 {
 				panic(req)
 			}
+			nrequest++
+			req.seq = nrequest
 			var reqSessions []*session
 			for _, sys := range req.sys {
 				sess, ok := sessions[sys]
@@ -253,7 +772,7 @@ outer:
 					var w *worker
 					w, sess.Idle = sess.Idle[0], sess.Idle[1:]
 					req.detach()
-					go reply(req, w)
+					reply(req, w)
 					continue outer
 				}
 				reqSessions = append(reqSessions, sess)
@@ -269,6 +788,10 @@ outer:
 			nstarted++
 			go w.Start(ctx)
 		case w := <-workerc:
+			if w.held {
+				share.release(w.holder)
+				w.holder, w.held = "", false
+			}
 			if err := w.Err(); err != nil {
 				if w.Session != nil {
 					w.Session.release()
@@ -281,13 +804,10 @@ outer:
 				panic(fmt.Sprintf("nil session, %v %v", w, w.Err()))
 			}
 			sess := w.Session
-			if len(sess.Requests) > 0 {
-				for req := range sess.Requests {
-					req.detach()
-					go reply(req, w)
-					continue outer
-				}
-				panic("should not reach here")
+			if req := share.next(sess.Requests); req != nil {
+				req.detach()
+				reply(req, w)
+				continue
 			}
 			// Otherwise we put it on a watch list. We don't reap the instance
 			// right away because of the race between dataset completion and
@@ -308,7 +828,35 @@ outer:
 // of the run itself. The run is registered with the runner and will
 // show up in the various introspection facilities.
 func (r *Runner) Run(ctx context.Context, study diviner.Study, values diviner.Values, replicate int) (diviner.Run, error) {
-	run, err := r.create(ctx, study, values, replicate)
+	run, err := r.create(ctx, study, values, replicate, false)
+	if err != nil {
+		return diviner.Run{}, err
+	}
+	if err := r.do(ctx, run); err != nil {
+		return diviner.Run{}, err
+	}
+	return run.Run, nil
+}
+
+// QueuedLabel is the label, with the value "true", with which the
+// runner marks the runs of trials that were requested through a
+// study's queue (see diviner.Enqueue) rather than by its oracle.
+const QueuedLabel = "queued"
+
+// ReproducesLabel is the label with which Reproduce marks
+// reproductions with the ID of the run that they reproduce.
+const ReproducesLabel = "reproduces"
+
+// Reproduce performs a new run of the provided study that reproduces
+// the provided (historical) run: it has the same values and
+// replicate, and it is performed with the provided config (e.g., as
+// returned by diviner.Run.ResolveConfig) instead of the one defined
+// by the study. The new run is labeled with the ID of the original
+// run (see ReproducesLabel). Like Run, Reproduce returns when the
+// reproduction is complete.
+func (r *Runner) Reproduce(ctx context.Context, study diviner.Study, orig diviner.Run, config diviner.RunConfig) (diviner.Run, error) {
+	labels := diviner.Labels{ReproducesLabel: orig.ID()}
+	run, err := r.createWith(ctx, study, orig.Values, orig.Replicate, &config, labels)
 	if err != nil {
 		return diviner.Run{}, err
 	}
@@ -319,17 +867,35 @@ func (r *Runner) Run(ctx context.Context, study diviner.Study, values diviner.Va
 }
 
 func (r *Runner) Round(ctx context.Context, study diviner.Study, ntrials int) (done bool, err error) {
+	release, err := r.holdLease(ctx, study)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if lost := release(); lost != nil && err == nil {
+			done, err = false, lost
+		}
+	}()
+	if err := r.waitResumed(ctx, study); err != nil {
+		return false, err
+	}
+	if stopped, err := r.stopped(ctx, study); err != nil || stopped {
+		return stopped, err
+	}
 	trials, err := diviner.Trials(ctx, r.db, study, diviner.Success|diviner.Pending)
 	if err != nil {
 		return false, err
 	}
-	failed, err := diviner.Trials(ctx, r.db, study, diviner.Failure)
+	failed, err := diviner.Trials(ctx, r.db, study, diviner.Failure|diviner.TimedOut)
 	if err != nil {
 		return false, err
 	}
-	Logger.Printf("%s: requesting new points from oracle from %d trials (%d failed)", study.Name, trials.Len(), failed.Len())
+	complete, err := r.transferTrials(ctx, study, trials)
+	if err != nil {
+		return false, err
+	}
+	Logger.Printf("%s: requesting new points from oracle from %d trials (%d failed, %d transferred)", study.Name, trials.Len(), failed.Len(), len(complete))
 
-	var complete []diviner.Trial
 	trials.Range(func(_ diviner.Value, v interface{}) {
 		trial := v.(diviner.Trial)
 		if trial.Replicates.Completed(study.Replicates) {
@@ -337,11 +903,12 @@ func (r *Runner) Round(ctx context.Context, study diviner.Study, ntrials int) (d
 		}
 	})
 
-	values, err := study.Oracle.Next(complete, study.Params, study.Objective, ntrials)
+	values, nqueued, err := r.nextValues(ctx, study, complete, ntrials)
 	if err != nil {
 		return false, err
 	}
 	if len(values) == 0 {
+		r.notify(ctx, study, diviner.StudyFinished, diviner.Run{}, "oracle exhausted")
 		return true, nil
 	}
 	g, ctx := errgroup.WithContext(ctx)
@@ -351,8 +918,9 @@ func (r *Runner) Round(ctx context.Context, study diviner.Study, ntrials int) (d
 	)
 	for i := range values {
 		var (
-			vals = values[i]
-			ran  diviner.Replicates
+			vals   = values[i]
+			queued = i < nqueued
+			ran    diviner.Replicates
 		)
 		if v, ok := trials.Get(vals); ok {
 			ran = v.(diviner.Trial).Replicates
@@ -375,10 +943,9 @@ func (r *Runner) Round(ctx context.Context, study diviner.Study, ntrials int) (d
 					err  error
 				)
 				if trial, ok := failed.Get(vals); ok && trial.(diviner.Trial).Replicates.Contains(replicate) {
-					// Populate a run from the previous (failed) run. Note that
-					// there is a race here: two competing diviner processes could
-					// attempt to restore the run. We should implement locking in
-					// the database. (e.g., by compare-and-swap the keepalive time).
+					// Populate a run from the previous (failed) run. Only the
+					// holder of the study's lease restores its runs, so that
+					// competing diviner processes do not both restore the run.
 					for _, result := range trial.(diviner.Trial).Runs {
 						if result.Replicate != replicate {
 							continue
@@ -397,13 +964,25 @@ func (r *Runner) Round(ctx context.Context, study diviner.Study, ntrials int) (d
 						if err != nil {
 							return err
 						}
+						// Resumed runs keep their recorded seeds.
+						if seed := run0.Run.Config.Seed; seed != 0 {
+							run0.Config.Seed = seed
+						}
 						break
 					}
 					if run0 == nil {
 						panic("replicate set but not present")
 					}
 				} else {
-					if run0, err = r.create(ctx, study, vals, replicate); err != nil {
+					if prev, ok, err := r.completed(ctx, study, vals, replicate); err != nil {
+						return err
+					} else if ok {
+						mu.Lock()
+						runs = append(runs, prev)
+						mu.Unlock()
+						return nil
+					}
+					if run0, err = r.create(ctx, study, vals, replicate, queued); err != nil {
 						return err
 					}
 				}
@@ -420,17 +999,93 @@ func (r *Runner) Round(ctx context.Context, study diviner.Study, ntrials int) (d
 	if err := g.Wait(); err != nil {
 		return false, err
 	}
+	if stopped, err := r.stopped(ctx, study); err != nil || stopped {
+		return stopped, err
+	}
 	for _, run := range runs {
 		if run.State != diviner.Success {
 			return false, nil
 		}
 	}
-	return ntrials == 0 || (len(values) < ntrials), nil
+	if done = oracleExhausted(study, ntrials, len(values), 0); done {
+		r.notify(ctx, study, diviner.StudyFinished, diviner.Run{}, "oracle exhausted")
+	}
+	return done, nil
+}
+
+// OracleExhausted tells whether the provided study's oracle is exhausted,
+// given that it returned got of the n values requested from it while
+// npending of the study's trials were pending. Multi-fidelity oracles
+// may return fewer values than requested while they await the results
+// of trials that may be promoted; they are exhausted only once they
+// return no values while no trials are pending.
+func oracleExhausted(study diviner.Study, n, got, npending int) bool {
+	if _, ok := study.Oracle.(diviner.FidelityOracle); ok && study.Fidelity != nil {
+		return got == 0 && npending == 0
+	}
+	return n == 0 || got < n
+}
+
+// Stopped tells whether the provided study has met one of its stop
+// conditions (see diviner.StopConditions). If so, the reason is
+// logged and delivered to the study's notifiers.
+func (r *Runner) stopped(ctx context.Context, study diviner.Study) (bool, error) {
+	if study.Stop.IsZero() {
+		return false, nil
+	}
+	runs, err := r.db.ListRuns(ctx, study.Name, diviner.Any, time.Time{})
+	if err == diviner.ErrNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	reason := study.Stop.Check(study.Objective, runs, time.Now())
+	if reason == "" {
+		return false, nil
+	}
+	Logger.Printf("%s: stopping study: %s", study.Name, reason)
+	r.notify(ctx, study, diviner.StudyFinished, diviner.Run{}, reason)
+	return true, nil
+}
+
+// Completed returns a successful run of the provided study with
+// exactly the provided values and replicate number, if the runner
+// deduplicates runs and there is one.
+func (r *Runner) completed(ctx context.Context, study diviner.Study, values diviner.Values, replicate int) (diviner.Run, bool, error) {
+	if !r.dedup {
+		return diviner.Run{}, false, nil
+	}
+	runs, err := r.db.Query(ctx, study.Name, diviner.Query{States: diviner.Success, Values: values})
+	if err == diviner.ErrNotExist {
+		return diviner.Run{}, false, nil
+	} else if err != nil {
+		return diviner.Run{}, false, err
+	}
+	for _, run := range runs {
+		if run.Replicate == replicate && len(run.Values) == len(values) {
+			Logger.Printf("%s: reusing run %s for values %s (replicate %d)", study.Name, run.ID(), values, replicate)
+			r.stats.Count("run.reused", 1, stats.Tag("study", study.Name))
+			return run, true, nil
+		}
+	}
+	return diviner.Run{}, false, nil
 }
 
 // create creates a new run from a study definition, allocating a new run sequence number
-// and inserts it into the database.
-func (r *Runner) create(ctx context.Context, study diviner.Study, values diviner.Values, replicate int) (*run, error) {
+// and inserts it into the database. Runs of values that were dequeued
+// from the study's queue (see nextValues) are labeled with QueuedLabel.
+func (r *Runner) create(ctx context.Context, study diviner.Study, values diviner.Values, replicate int, queued bool) (*run, error) {
+	var labels diviner.Labels
+	if queued {
+		labels = diviner.Labels{QueuedLabel: "true"}
+	}
+	return r.createWith(ctx, study, values, replicate, nil, labels)
+}
+
+// createWith creates a new run like create, with the provided config
+// (instead of the one defined by the study) if it is non-nil, and
+// with the provided labels.
+func (r *Runner) createWith(ctx context.Context, study diviner.Study, values diviner.Values, replicate int, config *diviner.RunConfig, labels diviner.Labels) (*run, error) {
 	if _, err := r.db.CreateStudyIfNotExist(ctx, study); err != nil {
 		return nil, err
 	}
@@ -446,8 +1101,16 @@ func (r *Runner) create(ctx context.Context, study diviner.Study, values diviner
 		Values:  values,
 		Acquire: study.Acquire,
 	}
-	run.Config, err = r.configure(study, values, replicate, int(seq))
-	if err != nil {
+	if config != nil {
+		run.Config = *config
+		// Reproductions of runs that were created before seeds were
+		// recorded are seeded anew.
+		if run.Config.Seed == 0 {
+			if run.Config.Seed, err = newSeed(); err != nil {
+				return nil, err
+			}
+		}
+	} else if run.Config, err = r.configure(study, values, replicate, int(seq)); err != nil {
 		return nil, err
 	}
 	run.Run, err = r.db.InsertRun(ctx, diviner.Run{
@@ -456,6 +1119,9 @@ func (r *Runner) create(ctx context.Context, study diviner.Study, values diviner
 		Replicate: replicate,
 		Values:    values,
 		Config:    run.Config,
+		Attempt:   1,
+		Labels:    labels,
+		Source:    r.source,
 	})
 	if err != nil {
 		return nil, err
@@ -467,16 +1133,189 @@ func (r *Runner) configure(study diviner.Study, values diviner.Values, replicate
 	if study.Run == nil {
 		return diviner.RunConfig{}, nil
 	}
-	return study.Run(values, replicate, fmt.Sprintf("%s:%d", study.Name, seq))
+	config, err := study.Run(values, replicate, fmt.Sprintf("%s:%d", study.Name, seq))
+	if err != nil {
+		return config, err
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = study.Timeout
+	}
+	if config.Seed == 0 {
+		if config.Seed, err = newSeed(); err != nil {
+			return config, err
+		}
+	}
+	// Record the local files' digests so that reproductions can tell
+	// whether they changed. Unreadable files fail the run later.
+	if err := config.DigestLocalFiles(); err != nil {
+		log.Error.Printf("%s:%d: digest local files: %v", study.Name, seq, err)
+	}
+	return config, nil
+}
+
+// NewSeed returns a random run seed (see diviner.RunConfig.Seed).
+func newSeed() (int64, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	// Seeds are positive, and less than 2^31.
+	return int64(binary.LittleEndian.Uint32(b[:])%math.MaxInt32) + 1, nil
 }
 
-// do executes the provided run in the runner. The run's status is
-// updated in the runner's database; the run is retried up to
-// maxRetries times. If the run is successful, then run.Run contains
-// the results of the run.
-func (r *Runner) do(origctx context.Context, run *run) error {
+// do executes the provided run in the runner. Failed and timed-out
+// runs are retried, and preempted runs rescheduled, according to the
+// run config's retry policy; each retry is a new run, linked to the
+// original one. When do returns,
+// run.Run contains the results of the last attempt.
+func (r *Runner) do(ctx context.Context, run *run) error {
+	policy := run.Config.Retry
+	var preemptions int
+	for {
+		if err := r.attempt(ctx, run); err != nil {
+			return err
+		}
+		if e := run.takeExploit(); e != nil && run.Run.State == diviner.Success {
+			if err := r.fork(ctx, run, e); err != nil {
+				return err
+			}
+			continue
+		}
+		attempt := run.Run.Attempt
+		if attempt == 0 {
+			attempt = 1
+		}
+		if run.Run.State == diviner.Preempted {
+			preemptions++
+			if !policy.RetryPreemption(preemptions) {
+				return nil
+			}
+			Logger.Printf("run %s: preempted; rescheduling (preemption %d)", run, preemptions)
+			if err := r.retry(ctx, run, attempt+1, false); err != nil {
+				return err
+			}
+			continue
+		}
+		timedOut := run.Run.State == diviner.TimedOut
+		if run.Run.State != diviner.Failure && !timedOut {
+			return nil
+		}
+		if timedOut {
+			if !policy.RetryTimeout(attempt) {
+				return nil
+			}
+		} else if _, message, _ := run.Status(); !policy.Retry(attempt, message, run.Transient()) {
+			return nil
+		}
+		delay := policy.Delay(attempt)
+		Logger.Printf("run %s: retrying in %s (attempt %d of %d)", run, delay, attempt+1, policy.MaxAttempts)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if err := r.retry(ctx, run, attempt+1, timedOut); err != nil {
+			return err
+		}
+	}
+}
+
+// retry replaces the provided (failed) run with a new run, recorded in
+// the database as the given attempt of the original run, whose parent
+// is the failed run. If the run
+// timed out, the retry's resources are scaled by the retry policy's
+// ResourceScale.
+func (r *Runner) retry(ctx context.Context, run *run, attempt int, timedOut bool) error {
+	original := run.Run.RetryOf
+	if original == 0 {
+		original = run.Run.Seq
+	}
+	seq, err := r.db.NextSeq(ctx, run.Study.Name)
+	if err != nil {
+		return err
+	}
+	config, err := r.configure(run.Study, run.Values, run.Run.Replicate, int(seq))
+	if err != nil {
+		return err
+	}
+	// Retries reuse the seed of the original run.
+	config.Seed = run.Config.Seed
+	if scale := run.Config.Retry.ResourceScale; timedOut && scale > 0 {
+		config.Resources = run.Config.Resources.Scale(scale)
+		Logger.Printf("run %s: timed out; retrying with %s", run, config.Resources)
+	}
+	inserted, err := r.db.InsertRun(ctx, diviner.Run{
+		Study:     run.Study.Name,
+		Seq:       seq,
+		Replicate: run.Run.Replicate,
+		Values:    run.Values,
+		Config:    config,
+		RetryOf:   original,
+		Attempt:   attempt,
+		Parent:    run.Run.Seq,
+		Source:    run.Run.Source,
+	})
+	if err != nil {
+		return err
+	}
+	Logger.Printf("run %s: retrying as %s", run, inserted.ID())
+	run.reset(inserted, run.Values, config)
+	return nil
+}
+
+// fork replaces the provided (exploited) run with a new run that
+// continues it with the exploit's values. The new run's parent is
+// the run from which it exploits. The checkpoint of the
+// exploited run, if any, is copied to the new run's checkpoint
+// location, so that the new run resumes from it.
+func (r *Runner) fork(ctx context.Context, run *run, e *exploit) error {
+	seq, err := r.db.NextSeq(ctx, run.Study.Name)
+	if err != nil {
+		return err
+	}
+	config, err := r.configure(run.Study, e.Values, run.Run.Replicate, int(seq))
+	if err != nil {
+		return err
+	}
+	origin := fmt.Sprintf("forked from run %s, exploiting run %s", run, e.From)
+	if e.checkpoint != "" && !config.Checkpoint.IsZero() {
+		dst := config.Checkpoint.Location(run.Study.Name, seq)
+		switch err := storage.Copy(ctx, e.checkpoint, dst); {
+		case err == nil:
+			origin += fmt.Sprintf(" (checkpoint %s)", e.checkpoint)
+		case errors.Is(errors.NotExist, err):
+			Logger.Printf("run %s: run %s has not yet saved a checkpoint", run, e.From)
+		default:
+			return fmt.Errorf("copy checkpoint %s: %v", e.checkpoint, err)
+		}
+	}
+	inserted, err := r.db.InsertRun(ctx, diviner.Run{
+		Study:     run.Study.Name,
+		Seq:       seq,
+		Replicate: run.Run.Replicate,
+		Values:    e.Values,
+		Config:    config,
+		Attempt:   1,
+		Parent:    e.from,
+		Source:    r.source,
+	})
+	if err != nil {
+		return err
+	}
+	Logger.Printf("run %s: exploited run %s; continuing as %s with values %s", run, e.From, inserted.ID(), e.Values)
+	run.reset(inserted, e.Values, config)
+	run.origin = origin
+	return nil
+}
+
+// attempt executes the provided run in the runner. The run's status
+// is updated in the runner's database; the run is restarted up to
+// maxRetries times if it times out. If the run is successful, then
+// run.Run contains the results of the run.
+func (r *Runner) attempt(origctx context.Context, run *run) error {
 	r.add(run)
 	defer r.remove(run)
+	r.notify(origctx, run.Study, diviner.RunStarted, run.Run, "started")
 	var wg sync.WaitGroup
 	wg.Add(1)
 	newctx, cancel := context.WithCancel(origctx)
@@ -513,6 +1352,10 @@ loop:
 			state = diviner.Success
 		case statusTimeout:
 			continue loop
+		case statusDeadline:
+			state = diviner.TimedOut
+		case statusPreempted:
+			state = diviner.Preempted
 		case statusErr:
 			log.Error.Printf("run %s error: %v", run, message)
 		}
@@ -520,21 +1363,266 @@ loop:
 	}
 	cancel()
 	wg.Wait() // wait for the last database update
-	_, message, elapsed := run.Status()
-	if err := r.db.UpdateRun(origctx, run.Study.Name, run.Run.Seq, state, "", elapsed, int(retries)); err != nil {
+	status, message, elapsed := run.Status()
+	if err := r.db.UpdateRun(origctx, run.Study.Name, run.Run.Seq, state, fmt.Sprintf("%s: %s", status, message), elapsed, int(retries)); err != nil {
 		log.Error.Printf("run %s:%d: error setting status: %v", run.Run.Study, run.Run.Seq, message)
 		return err
 	}
+	if exit := run.Exit(); exit != nil {
+		if err := r.db.SetRunExit(origctx, run.Study.Name, run.Run.Seq, *exit); err != nil {
+			log.Error.Printf("run %s:%d: error setting exit: %v", run.Run.Study, run.Run.Seq, err)
+		}
+	}
+	if cost, ok := run.Cost(r.pricing); ok {
+		if err := r.db.SetRunCost(origctx, run.Study.Name, run.Run.Seq, cost); err != nil {
+			log.Error.Printf("run %s:%d: error setting cost: %v", run.Run.Study, run.Run.Seq, err)
+		}
+	}
 	// Refresh the run status before we return it.
 	var err error
 	run.Run, err = r.db.LookupRun(origctx, run.Study.Name, run.Run.Seq)
+	if err == nil {
+		r.report(run)
+		r.notifyRun(origctx, run)
+		if observer, ok := run.Study.Scheduler.(diviner.CompletionObserver); ok && run.Run.State == diviner.Success {
+			observer.Completed(run.Study.Objective, run.Run.ID(), run.Run.History())
+		}
+	}
 	return err
 }
 
+// report reports statistics for the completed run to the
+// runner's stats sink, and accounts for it in its study's usage.
+func (r *Runner) report(run *run) {
+	r.mu.Lock()
+	r.account(run.Run)
+	r.mu.Unlock()
+	var (
+		study = stats.Tag("study", run.Study.Name)
+		state = stats.Tag("state", run.Run.State)
+	)
+	r.stats.Count("run.completed", 1, study, state)
+	r.stats.Timing("run.duration", run.Run.Runtime, study, state)
+	if run.Run.State != diviner.Success {
+		return
+	}
+	objective := run.Study.Objective
+	if v, ok := run.Study.Trial(run.Run).Metrics[objective.Metric]; ok {
+		r.stats.Gauge("study.objective", v, study, stats.Tag("metric", objective.Metric))
+	}
+}
+
+// NextValues returns the next n parameter values for the study: the
+// values at the front of the study's queue (see diviner.Enqueue),
+// followed by values suggested by its oracle (see suggest), which is
+// consulted only if the queue holds fewer than n values. As with
+// suggest, all values are returned if n <= 0. The first nqueued of
+// the returned values are those that were dequeued. Queued values
+// that are no longer valid for the study's parameters (e.g., because
+// the study was redefined) are logged and discarded.
+//
+// Values are removed from the queue before their runs are created:
+// they are returned to the queue if the oracle fails, but they are
+// lost if the runner fails (or dies) before it creates their runs.
+func (r *Runner) nextValues(ctx context.Context, study diviner.Study, trials []diviner.Trial, n int) (values []diviner.Values, nqueued int, err error) {
+	dequeued, err := r.db.DequeueValues(ctx, study.Name, n)
+	if err != nil && err != diviner.ErrNotExist {
+		return nil, 0, err
+	}
+	var queued []diviner.Values
+	for _, values := range dequeued {
+		v := values
+		if study.Fidelity != nil {
+			v = study.Fidelity.Strip(v)
+		}
+		if err := study.Params.Check(v); err != nil {
+			Logger.Printf("%s: discarding queued values %s: %v", study.Name, values, err)
+			continue
+		}
+		queued = append(queued, values)
+	}
+	if len(queued) > 0 {
+		Logger.Printf("%s: dequeued %d requested trials", study.Name, len(queued))
+		r.stats.Count("queue.dequeued", int64(len(queued)), stats.Tag("study", study.Name))
+	}
+	if n > 0 && len(queued) == n {
+		return queued, len(queued), nil
+	}
+	m := n
+	if n > 0 {
+		m -= len(queued)
+	}
+	values, err = r.suggest(ctx, study, trials, m)
+	if err != nil {
+		// Dequeued values are returned to the queue, so that they are
+		// performed once the oracle recovers.
+		for _, values := range queued {
+			if err := r.db.EnqueueValues(ctx, study.Name, values); err != nil {
+				Logger.Printf("%s: failed to requeue values %s: %v", study.Name, values, err)
+			}
+		}
+		return nil, 0, err
+	}
+	return append(queued, values...), len(queued), nil
+}
+
+// Suggest returns the next n parameter values for the study from
+// its oracle. Multi-objective studies use their oracle's NextMulti, if
+// it is a MultiOracle. Studies with a fidelity use their oracle's
+// NextFidelity, if it is a FidelityOracle; other oracles' values are
+// run at the study's full fidelity. The state of the oracle (the
+// number of trials from which it suggested values, the number of
+// values it suggested, and whether it is exhausted) is reported to
+// the runner's stats sink.
+func (r *Runner) suggest(ctx context.Context, study diviner.Study, trials []diviner.Trial, n int) ([]diviner.Values, error) {
+	stateful, _ := study.Oracle.(diviner.StatefulOracle)
+	if stateful != nil {
+		if err := r.loadOracleState(ctx, study, stateful); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		values []diviner.Values
+		err    error
+		start  = time.Now()
+	)
+	fidelity := study.Fidelity
+	if fidelity != nil {
+		if err := fidelity.Validate(study.Params); err != nil {
+			return nil, fmt.Errorf("%s: %v", study.Name, err)
+		}
+	}
+	fidelityOracle, _ := study.Oracle.(diviner.FidelityOracle)
+	if fidelity != nil && fidelityOracle == nil {
+		// Oracles that do not choose fidelities are presented with
+		// the trials' parameter values alone; their trials are run at
+		// full fidelity.
+		stripped := make([]diviner.Trial, len(trials))
+		for i, trial := range trials {
+			stripped[i] = trial
+			stripped[i].Values = fidelity.Strip(trial.Values)
+		}
+		trials = stripped
+	}
+	if fidelity != nil && fidelityOracle != nil {
+		values, err = fidelityOracle.NextFidelity(trials, study.Params, study.Objective, *fidelity, n)
+	} else if oracle, ok := study.Oracle.(diviner.MultiOracle); ok && len(study.Objectives) > 1 {
+		values, err = oracle.NextMulti(trials, study.Params, study.Objectives, n)
+	} else {
+		values, err = study.Oracle.Next(trials, study.Params, study.Objective, n)
+	}
+	if fidelity != nil && fidelityOracle == nil {
+		for i := range values {
+			values[i] = fidelity.With(values[i], fidelity.Max)
+		}
+	}
+	tag := stats.Tag("study", study.Name)
+	if err != nil {
+		r.stats.Count("oracle.errors", 1, tag)
+		return nil, err
+	}
+	// Values are coerced to their parameters' kinds and ranges, so
+	// that oracles' numerical imprecision does not fail runs; other
+	// invalid values are reported as errors of the oracle. Fidelities
+	// are rounded to the study's fidelity range.
+	for i := range values {
+		v := values[i]
+		if fidelity != nil {
+			v = fidelity.Strip(v)
+		}
+		v, err := study.Params.Coerce(v, true)
+		if err != nil {
+			r.stats.Count("oracle.errors", 1, tag)
+			return nil, fmt.Errorf("%s: oracle %T suggested invalid values %s: %v", study.Name, study.Oracle, values[i], err)
+		}
+		if fidelity != nil {
+			f, ok := fidelity.Get(values[i])
+			if !ok {
+				f = fidelity.Max
+			}
+			v = fidelity.With(v, f)
+		}
+		values[i] = v
+	}
+	r.stats.Timing("oracle.duration", time.Since(start), tag)
+	r.stats.Gauge("oracle.trials", float64(len(trials)), tag)
+	r.stats.Count("oracle.suggested", int64(len(values)), tag)
+	exhausted := 0.0
+	if oracleExhausted(study, n, len(values), 0) {
+		exhausted = 1
+	}
+	r.stats.Gauge("oracle.exhausted", exhausted, tag)
+	if stateful != nil {
+		state, err := stateful.SaveState()
+		if err != nil {
+			return nil, fmt.Errorf("%s: saving oracle state: %v", study.Name, err)
+		}
+		if err := r.db.SetOracleState(ctx, study.Name, state); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// LoadOracleState restores the state of the provided study's oracle
+// from the database, the first time that the oracle is used by the
+// runner. Oracles that fail to restore their state are logged and
+// start afresh.
+func (r *Runner) loadOracleState(ctx context.Context, study diviner.Study, oracle diviner.StatefulOracle) error {
+	r.mu.Lock()
+	loaded := r.oracles[study.Name]
+	r.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	// The oracle's state is saved with the study, which thus must
+	// exist.
+	if _, err := r.db.CreateStudyIfNotExist(ctx, study); err != nil {
+		return err
+	}
+	state, err := r.db.LookupOracleState(ctx, study.Name)
+	switch {
+	case err == diviner.ErrNotExist:
+	case err != nil:
+		return err
+	default:
+		if err := oracle.LoadState(state); err != nil {
+			log.Error.Printf("%s: failed to restore oracle state: %v; starting afresh", study.Name, err)
+		} else {
+			Logger.Printf("%s: restored oracle state from database", study.Name)
+		}
+	}
+	r.mu.Lock()
+	r.oracles[study.Name] = true
+	r.mu.Unlock()
+	return nil
+}
+
+// TransferTrials returns the trials transferred to the provided study
+// from previous studies (see diviner.TransferTrials), excluding those
+// whose values have also been tried in the study itself.
+func (r *Runner) transferTrials(ctx context.Context, study diviner.Study, trials *diviner.Map) ([]diviner.Trial, error) {
+	if len(study.Transfer) == 0 {
+		return nil, nil
+	}
+	transferred, err := diviner.TransferTrials(ctx, r.db, study)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, trial := range transferred {
+		if _, ok := trials.Get(trial.Values); !ok {
+			transferred[n] = trial
+			n++
+		}
+	}
+	return transferred[:n], nil
+}
+
 // Allocate allocates a new worker and returns it. Workers must
 // be returned after they are done by calling w.Return.
-func (r *Runner) allocate(ctx context.Context, sys []*diviner.System) (*worker, error) {
-	req := newRequest(sys)
+func (r *Runner) allocate(ctx context.Context, study string, sys []*diviner.System) (*worker, error) {
+	req := newRequest(study, sys)
 	select {
 	case r.requestc <- req:
 	case <-ctx.Done():
@@ -551,19 +1639,69 @@ func (r *Runner) allocate(ctx context.Context, sys []*diviner.System) (*worker,
 // Dataset returns a named dataset as managed by this runner.
 // If this is the first time the dataset is encountered, then the
 // runner also begins dataset processing. Datasets are de-duped
-// based on their IfNotExist url, but only if that URL is nonempty.
+// based on their names and digests; datasets whose processing failed
+// are processed anew. Only one definition of each named dataset is
+// processed at a time: a dataset whose digest differs from that of
+// the dataset last processed with its name is processed once the
+// latter is done.
 func (r *Runner) dataset(ctx context.Context, dataset diviner.Dataset) *dataset {
+	digest, err := r.datasetDigest(ctx, dataset)
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if d, ok := r.datasets[dataset.IfNotExist]; dataset.IfNotExist != "" && ok {
+	d := newDataset(dataset, digest)
+	if err != nil {
+		d.error(err)
 		return d
 	}
-	d := newDataset(dataset)
-	r.datasets[d.IfNotExist] = d
-	go d.Do(ctx, r)
+	prev := r.datasets[dataset.Name]
+	if prev != nil && prev.digest == digest && prev.Err() == nil {
+		return prev
+	}
+	r.datasets[dataset.Name] = d
+	go func() {
+		if prev != nil {
+			select {
+			case <-prev.Done():
+			case <-ctx.Done():
+				d.error(ctx.Err())
+				return
+			}
+		}
+		d.Do(ctx, r)
+	}()
 	return d
 }
 
+// DatasetDigest returns the digest of the provided dataset (see
+// diviner.Dataset.Digest). Digests are computed once for each
+// definition, so that the inputs of datasets shared by many runs are
+// not hashed anew for each: changes to a definition's local files or
+// inputs are noticed only by new runners. Failures to compute digests
+// are not cached.
+func (r *Runner) datasetDigest(ctx context.Context, dataset diviner.Dataset) (string, error) {
+	key := datasetKey(dataset)
+	r.mu.Lock()
+	digest, ok := r.digests[key]
+	r.mu.Unlock()
+	if ok {
+		return digest, nil
+	}
+	digest, err := dataset.Digest(ctx)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.digests[key] = digest
+	r.mu.Unlock()
+	return digest, nil
+}
+
+// DatasetKey returns the key of the provided dataset's definition in
+// Runner.digests: the fields from which its digest is computed.
+func datasetKey(d diviner.Dataset) string {
+	return fmt.Sprintf("%q %q %q %q %q", d.Name, d.Script, d.IfNotExist, d.LocalFiles, d.Inputs)
+}
+
 func (r *Runner) add(run *run) {
 	r.mu.Lock()
 	r.runs[run.Study.Name] = append(r.runs[run.Study.Name], run)
@@ -588,6 +1726,13 @@ func (r *Runner) remove(run *run) {
 type request struct {
 	replyc chan *worker
 
+	// Study is the name of the study whose run made the request, or
+	// empty if the request was made to compute a dataset.
+	study string
+	// Seq numbers requests in order of arrival; it is assigned by
+	// the runner's loop.
+	seq uint64
+
 	// Sys is the list of systems from which a new machine may be allocated.
 	sys []*diviner.System
 
@@ -597,8 +1742,8 @@ type request struct {
 	sessions []*session
 }
 
-func newRequest(sys []*diviner.System) *request {
-	return &request{make(chan *worker), sys, nil}
+func newRequest(study string, sys []*diviner.System) *request {
+	return &request{replyc: make(chan *worker), study: study, sys: sys}
 }
 
 // Remove this request from all the sessions that it's waiting on.
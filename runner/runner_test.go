@@ -8,12 +8,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -25,6 +33,7 @@ import (
 	"github.com/grailbio/diviner/localdb"
 	"github.com/grailbio/diviner/oracle"
 	"github.com/grailbio/diviner/runner"
+	"github.com/grailbio/diviner/scheduler"
 	"github.com/grailbio/testutil"
 )
 
@@ -44,6 +53,7 @@ func (s *errorSystem) Start(_ context.Context, count int) ([]*bigmachine.Machine
 
 func init() {
 	gob.Register(new(errorSystem))
+	gob.Register(stopAt(0))
 	runner.TestSetRetryBackoff(1 * time.Second)
 }
 
@@ -371,30 +381,159 @@ func TestRunnerError(t *testing.T) {
 		if run.Values["param"].Int() == 0 {
 			continue
 		}
-		var b bytes.Buffer
-		if _, err := io.Copy(&b, db.Log(run.Study, run.Seq, time.Time{}, false)); err != nil {
+		// Standard output and error are stored as separate streams,
+		// whose relative order is not preserved.
+		var stdout, stderr bytes.Buffer
+		if _, err := io.Copy(&stdout, diviner.FilterLog(db.Log(run.Study, run.Seq, time.Time{}, false), diviner.Stdout)); err != nil {
 			t.Error(err)
 			continue
 		}
-		lines := strings.Split(b.String(), "\n")
-		if got, want := len(lines), 5; got != want {
+		if _, err := io.Copy(&stderr, diviner.FilterLog(db.Log(run.Study, run.Seq, time.Time{}, false), diviner.Stderr)); err != nil {
+			t.Error(err)
+			continue
+		}
+		lines := strings.Split(stdout.String(), "\n")
+		if got, want := len(lines), 3; got != want {
 			t.Fatalf("got %v, want %v", got, want)
 		}
 		if !strings.HasPrefix(lines[0], "diviner: ") {
 			t.Errorf("bad lines[0]: %v", lines[0])
 		}
-		expect := []string{
-			"+ echo the_status",
-			"the_status",
-			"+ exit 1",
-			"",
+		if got, want := lines[1], "the_status"; got != want {
+			t.Errorf("bad line 1: got %v, want %v", got, want)
 		}
-		for i := range expect {
-			if got, want := lines[i+1], expect[i]; got != want {
-				t.Errorf("bad line %d: got %v, want %v", i+1, got, want)
-			}
+		if got, want := stderr.String(), "+ echo the_status\n+ exit 1\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if run.Exit == nil {
+			t.Fatal("run has no exit")
+		}
+		if got, want := run.Exit.Code, 1; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := strings.Join(run.Exit.Stderr, "\n"), "+ echo the_status\n+ exit 1"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if run.Exit.Reason == "" {
+			t.Error("exit has no reason")
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := testStudy(`
+		echo METRICS: acc=0.5
+		sleep 5
+	`)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(1)}, 0)
+		errc <- err
+	}()
+	var status runner.RunStatus
+	ok := eventually(func() bool {
+		statuses := r.Status()
+		if len(statuses) != 1 {
+			return false
+		}
+		status = statuses[0]
+		return status.Status == "running" && status.Metrics["acc"] == 0.5
+	})
+	if !ok {
+		t.Fatalf("run did not report progress: %+v", status)
+	}
+	if got, want := status.ID(), "test:1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := status.Values["param"], diviner.Int(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/?format=json", nil))
+	var statuses []struct {
+		Study   string
+		Seq     uint64
+		Status  string
+		Metrics map[string]float64
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(statuses), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := statuses[0].Metrics["acc"], 0.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(r.Status()), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/table?study=test", nil))
+	var table struct {
+		Rows    int
+		Columns []struct {
+			Name   string
+			Coords []float64
 		}
 	}
+	if err := json.Unmarshal(w.Body.Bytes(), &table); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := table.Rows, 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := len(table.Columns), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := table.Columns[1].Name, "acc"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := table.Columns[1].Coords, []float64{0.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/table?study=nonexistent", nil))
+	if got, want := w.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/convergence?study=test", nil))
+	var c diviner.Convergence
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Runs, 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := len(c.Points), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := c.Points[0].Best, 0.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/convergence?study=nonexistent", nil))
+	if got, want := w.Code, http.StatusNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
 }
 
 func TestKeepalive(t *testing.T) {
@@ -477,89 +616,1895 @@ func TestRunnerGo(t *testing.T) {
 	}
 }
 
-func runnerTest(t *testing.T) (dir string, database diviner.Database, cleanup func()) {
-	t.Helper()
-	dir, cleanup = testutil.TempDir(t, "", "")
-	var err error
-	database, err = localdb.Open(filepath.Join(dir, "test.ddb"))
-	if err != nil {
-		t.Fatal(err)
+func init() {
+	gob.Register(eventRecorder(""))
+	gob.Register(&repeatOracle{})
+	gob.Register(&countingOracle{})
+}
+
+// recordedEvents stores the events recorded by each eventRecorder.
+var recordedEvents struct {
+	sync.Mutex
+	events map[eventRecorder][]diviner.Event
+}
+
+// eventRecorder is a notifier that records the events it is notified
+// of in recordedEvents. (Notifiers are stored in the database along
+// with their studies, and thus must be gob-encodable.)
+type eventRecorder string
+
+func (e eventRecorder) Notify(_ context.Context, event diviner.Event) error {
+	recordedEvents.Lock()
+	if recordedEvents.events == nil {
+		recordedEvents.events = make(map[eventRecorder][]diviner.Event)
 	}
-	return dir, database, cleanup
+	recordedEvents.events[e] = append(recordedEvents.events[e], event)
+	recordedEvents.Unlock()
+	return nil
 }
 
-func testScript(t *testing.T, db diviner.Database, retries int, state diviner.RunState, script string) {
-	t.Helper()
+func (e eventRecorder) take() []diviner.Event {
+	recordedEvents.Lock()
+	defer recordedEvents.Unlock()
+	events := recordedEvents.events[e]
+	delete(recordedEvents.events, e)
+	return events
+}
+
+func countEvents(events []diviner.Event, kind diviner.EventKind) int {
+	var n int
+	for _, event := range events {
+		if event.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func TestNotify(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+
+	rec := eventRecorder("TestNotify")
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2), diviner.Int(3)),
+		},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			if values["param"].Int() == 3 {
+				return nil, fmt.Errorf("failed")
+			}
+			return diviner.Metrics{"acc": float64(values["param"].Int())}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+		Notifiers: []diviner.Notifier{rec},
+	}
+	testRun(t, db, study)
+	events := rec.take()
+	for _, c := range []struct {
+		kind diviner.EventKind
+		n    int
+	}{
+		{diviner.RunStarted, 4},
+		{diviner.RunCompleted, 3},
+		{diviner.RunFailed, 1},
+	} {
+		if got, want := countEvents(events, c.kind), c.n; got != want {
+			t.Errorf("%s: got %v, want %v", c.kind, got, want)
+		}
+	}
+	var last diviner.Event
+	for _, event := range events {
+		if event.Kind == diviner.NewBest {
+			last = event
+		}
+	}
+	if got, want := last.Run.Values["param"].Int(), int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The failed run is retried; the study completes once the
+	// oracle is exhausted, and the retried run is not a new best.
+	study.Acquire = func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+		return diviner.Metrics{"acc": 0.5}, nil
+	}
+	if done := testRun(t, db, study); !done {
+		t.Fatal("not done")
+	}
+	events = rec.take()
+	if got, want := countEvents(events, diviner.NewBest), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := countEvents(events, diviner.StudyFinished), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// statsRecorder is a stats sink that records gauges and counts.
+type statsRecorder struct {
+	mu     sync.Mutex
+	gauges map[string]float64
+	counts map[string]int64
+}
+
+func (s *statsRecorder) Gauge(name string, value float64, tags ...string) {
+	s.mu.Lock()
+	s.gauges[name] = value
+	s.mu.Unlock()
+}
+
+func (s *statsRecorder) Count(name string, delta int64, tags ...string) {
+	s.mu.Lock()
+	s.counts[name] += delta
+	s.mu.Unlock()
+}
+
+func (*statsRecorder) Timing(string, time.Duration, ...string) {}
+
+func TestStats(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+
+	sink := &statsRecorder{gauges: make(map[string]float64), counts: make(map[string]int64)}
 	r := runner.New(db)
+	r.SetStats(sink)
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
 		if err := r.Loop(ctx); err != context.Canceled {
 			t.Fatal(err)
 		}
 	}()
-	study := testStudy(script)
-	run, err := r.Run(ctx, study, nil, 0)
-	cancel()
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2)),
+		},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			return diviner.Metrics{"acc": float64(values["param"].Int())}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	done, err := r.Round(ctx, study, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if got, want := run.Retries, retries; got != want {
+	if !done {
+		t.Fatal("not done")
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if got, want := sink.counts["oracle.suggested"], int64(3); got != want {
 		t.Errorf("got %v, want %v", got, want)
 	}
-	if got, want := run.State, state; got != want {
+	if got, want := sink.counts["run.completed"], int64(3); got != want {
 		t.Errorf("got %v, want %v", got, want)
 	}
-	return
+	if got, want := sink.gauges["oracle.exhausted"], 1.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := sink.gauges["runner.nrunning"]; !ok {
+		t.Error("runner.nrunning not reported")
+	}
 }
 
-func testStudy(script string) diviner.Study {
-	test := testsystem.New()
-	systems := []*diviner.System{&diviner.System{ID: "test", System: test}}
-	return diviner.Study{
+func TestBackup(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	backup := filepath.Join(dir, "backup.ddb")
+	r.SetBackup(db.(*localdb.DB), backup, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Error(err)
+		}
+	}()
+	study := testStudy("echo METRICS: acc=0.5")
+	if _, err := r.Run(ctx, study, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Backups are periodic: the completed run appears in a later one.
+	restored := filepath.Join(dir, "restored.ddb")
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		os.Remove(restored)
+		if err := localdb.Restore(ctx, backup, restored); err == nil {
+			restoredDB, err := localdb.OpenReadOnly(restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runs, err := restoredDB.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+			restoredDB.Close()
+			if err == nil && len(runs) == 1 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run was not backed up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStopConditions(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	rec := eventRecorder("TestStopConditions")
+	study := diviner.Study{
 		Name: "test",
 		Params: diviner.Params{
-			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1)),
+			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2), diviner.Int(3)),
 		},
-		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
-			config := diviner.RunConfig{
-				Systems: systems,
-				Script:  script,
-			}
-			return config, nil
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			return diviner.Metrics{"acc": float64(values["param"].Int())}, nil
 		},
 		Objective: diviner.Objective{diviner.Maximize, "acc"},
 		Oracle:    &oracle.GridSearch{},
+		Stop:      diviner.StopConditions{MaxTrials: 2},
+		Notifiers: []diviner.Notifier{rec},
+	}
+	var rounds int
+	for done := false; !done; rounds++ {
+		var err error
+		if done, err = r.Round(ctx, study, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := rounds, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var reason string
+	for _, event := range rec.take() {
+		if event.Kind == diviner.StudyFinished {
+			reason = event.Message
+		}
+	}
+	if got, want := reason, "completed 2 trials (max 2)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
 	}
 }
 
-func testRun(t *testing.T, db diviner.Database, study diviner.Study) (done bool) {
-	t.Helper()
+// repeatOracle is an oracle that suggests the same values N times,
+// regardless of previous trials.
+type repeatOracle struct {
+	Values diviner.Values
+	N      int
+}
+
+func (o *repeatOracle) Next(_ []diviner.Trial, _ diviner.Params, _ diviner.Objective, n int) ([]diviner.Values, error) {
+	if o.N == 0 {
+		return nil, nil
+	}
+	o.N--
+	return []diviner.Values{o.Values}, nil
+}
+
+func TestOracleValues(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
 	r := runner.New(db)
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
 		if err := r.Loop(ctx); err != context.Canceled {
 			t.Fatal(err)
 		}
 	}()
-	var err error
-	done, err = r.Round(ctx, study, 0)
-	cancel()
+	study := diviner.Study{
+		Name:   "test",
+		Params: diviner.Params{"param": diviner.NewRange(diviner.Int(0), diviner.Int(10))},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			return diviner.Metrics{"acc": float64(values["param"].Int())}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		// Integral reals are coerced to integers.
+		Oracle: &repeatOracle{Values: diviner.Values{"param": diviner.Float(3)}, N: 1},
+	}
+	if _, err := r.Round(ctx, study, 1); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	return
-}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := runs[0].Values["param"], diviner.Value(diviner.Int(3)); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
 
-var policy = retry.Backoff(time.Second, 5*time.Second, 1.5)
+	study.Oracle = &repeatOracle{Values: diviner.Values{"param": diviner.String("three")}, N: 1}
+	_, err = r.Round(ctx, study, 1)
+	if err == nil || !strings.Contains(err.Error(), "parameter param: value three is of kind string") {
+		t.Errorf("bad error %v", err)
+	}
+}
 
-func eventually(cond func() bool) bool {
-	for try := 0; try < 10; try++ {
-		if cond() {
-			return true
+func TestDedup(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		_, db, cleanup := runnerTest(t)
+		r := runner.New(db)
+		r.SetDedup(dedup)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			if err := r.Loop(ctx); err != context.Canceled {
+				t.Fatal(err)
+			}
+		}()
+		var nacquire int32
+		study := diviner.Study{
+			Name: "test",
+			Params: diviner.Params{
+				"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1)),
+			},
+			Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+				atomic.AddInt32(&nacquire, 1)
+				return diviner.Metrics{"acc": 0.5}, nil
+			},
+			Objective: diviner.Objective{diviner.Maximize, "acc"},
+			Oracle:    &repeatOracle{Values: diviner.Values{"param": diviner.Int(1)}, N: 3},
 		}
-		if err := retry.Wait(context.Background(), policy, try); err != nil {
-			panic(err)
+		if err := r.Stream(ctx, study, 1).Wait(); err != nil {
+			t.Fatal(err)
+		}
+		want := int32(3)
+		if dedup {
+			want = 1
+		}
+		if got := atomic.LoadInt32(&nacquire); got != want {
+			t.Errorf("dedup %v: got %v, want %v", dedup, got, want)
+		}
+		cancel()
+		cleanup()
+	}
+}
+
+func TestJSONMetrics(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := testStudy(`
+		echo 'DIVINER_METRICS {"step":1,"acc":0.5,"model":"a"}'
+		echo 'DIVINER_METRICS {"step":2.5,"acc":0.7}'
+		echo 'METRICS: step=2,acc=0.6'
+		echo 'DIVINER_METRICS {"step":3,"acc":0.9,"n":12,"model":"b"}'
+		echo 'DIVINER_METRICS {"acc":0.9,"n":9007199254740993}'
+		echo 'DIVINER_METRICS [1,2]'
+	`)
+	run, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.State, diviner.Success; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	run, err = db.LookupRun(ctx, study.Name, run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []diviner.Metrics{
+		{"step": 1, "acc": 0.5},
+		{"step": 2, "acc": 0.6},
+		{"step": 3, "acc": 0.9, "n": 12},
+	}
+	if got := run.Metrics; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Labels.String(), "model=b"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := testStudy(`echo METRICS: acc=$ACC,count=$DIVINER_TEST_COUNT`)
+	run := study.Run
+	study.Run = func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+		config, err := run(values, replicate, id)
+		config.Env = map[string]string{"ACC": "0." + values["param"].String(), "DIVINER_TEST_COUNT": "bogus"}
+		return config, err
+	}
+	trial, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(1)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := trial.State, diviner.Success; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	trial, err = db.LookupRun(ctx, study.Name, trial.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Variables defined by diviner take precedence.
+	if got, want := trial.Metrics, []diviner.Metrics{{"acc": 0.1, "count": 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := trial.Config.Env["ACC"], "0.1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := testStudy(`echo METRICS: seed=$DIVINER_SEED`)
+	var runs []diviner.Run
+	for replicate := 0; replicate < 2; replicate++ {
+		run, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(1)}, replicate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run, err = db.LookupRun(ctx, study.Name, run.Seq); err != nil {
+			t.Fatal(err)
+		}
+		seed := run.Config.Seed
+		if seed <= 0 || seed > math.MaxInt32 {
+			t.Fatalf("invalid seed %d", seed)
+		}
+		if got, want := run.Trial().Metrics["seed"], float64(seed); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		runs = append(runs, run)
+	}
+	if runs[0].Config.Seed == runs[1].Config.Seed {
+		t.Errorf("replicates share seed %d", runs[0].Config.Seed)
+	}
+	// Reproductions reuse the original run's seed. Test systems cannot
+	// be restored from the database.
+	fresh, err := study.Run(runs[0].Values, 0, runs[0].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := runs[0].Config
+	config.Systems = fresh.Systems
+	repro, err := r.Reproduce(ctx, study, runs[0], config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := repro.Trial().Metrics["seed"], float64(runs[0].Config.Seed); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Fixed seeds are used as given.
+	run := study.Run
+	study.Run = func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+		config, err := run(values, replicate, id)
+		config.Seed = 42
+		return config, err
+	}
+	fixed, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(1)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fixed.Trial().Metrics["seed"], 42.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Retries reuse the failed run's seed.
+	seeds := filepath.Join(dir, "seeds")
+	study = testStudy(fmt.Sprintf(`
+		echo $DIVINER_SEED >> %[1]s
+		test $(wc -l < %[1]s) -ge 2 || exit 1
+		echo METRICS: seed=$DIVINER_SEED
+	`, seeds))
+	run = study.Run
+	study.Run = func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+		config, err := run(values, replicate, id)
+		config.Retry = diviner.RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     10 * time.Millisecond,
+			Retryable:   []string{"."},
+		}
+		return config, err
+	}
+	retried, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(1)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := retried.Attempt, 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	original, err := db.LookupRun(ctx, study.Name, retried.RetryOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried, err = db.LookupRun(ctx, study.Name, retried.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := retried.Config.Seed, original.Config.Seed; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	p, err := ioutil.ReadFile(seeds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%[1]d\n%[1]d\n", original.Config.Seed)
+	if got := string(p); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSource(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	source := diviner.Source{Commit: "0123456789abcdef", StudyDigest: "fedcba"}
+	r.SetSource(source)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := testStudy(`echo METRICS: acc=0.5`)
+	run, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err = db.LookupRun(ctx, study.Name, run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Source == nil || *run.Source != source {
+		t.Errorf("got %v, want %v", run.Source, source)
+	}
+}
+
+// countingOracle is a stateful oracle that suggests the values
+// 0, 1, 2, ... of its parameter, regardless of previous trials.
+type countingOracle struct {
+	// N is the next value to suggest. It is exported so that the
+	// oracle may be gob-encoded with its study.
+	N int
+}
+
+func (o *countingOracle) Next(_ []diviner.Trial, _ diviner.Params, _ diviner.Objective, n int) ([]diviner.Values, error) {
+	values := make([]diviner.Values, n)
+	for i := range values {
+		values[i] = diviner.Values{"param": diviner.Int(int64(o.N))}
+		o.N++
+	}
+	return values, nil
+}
+
+func (o *countingOracle) SaveState() ([]byte, error) {
+	return []byte(fmt.Sprint(o.N)), nil
+}
+
+func (o *countingOracle) LoadState(state []byte) error {
+	_, err := fmt.Sscan(string(state), &o.N)
+	return err
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewRange(diviner.Int(0), diviner.Int(100)),
+		},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			return diviner.Metrics{"acc": float64(values["param"].Int())}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    new(countingOracle),
+	}
+	if _, err := diviner.Enqueue(ctx, db, study, diviner.Values{"param": diviner.Int(200)}); err == nil {
+		t.Error("expected error")
+	}
+	for _, param := range []float64{50, 60, 70} {
+		// Reals are coerced to the parameter's kind.
+		if _, err := diviner.Enqueue(ctx, db, study, diviner.Values{"param": diviner.Float(param)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	// The first round performs two of the queued trials; the second
+	// performs the last, together with the oracle's first suggestion.
+	for round := 0; round < 2; round++ {
+		if done, err := r.Round(ctx, study, 2); err != nil {
+			t.Fatal(err)
+		} else if done {
+			t.Fatal("done")
+		}
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := make(map[int]bool)
+	for _, run := range runs {
+		queued[int(run.Values["param"].Int())] = run.Labels[runner.QueuedLabel] == "true"
+	}
+	if got, want := queued, map[int]bool{50: true, 60: true, 70: true, 0: false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if values, err := db.QueuedValues(ctx, study.Name); err != nil {
+		t.Fatal(err)
+	} else if len(values) != 0 {
+		t.Errorf("unexpected queued values %v", values)
+	}
+	// Later runs of values that were once queued are not labeled.
+	run, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(50)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := run.Labels[runner.QueuedLabel]; ok {
+		t.Errorf("run %s: unexpected label %s", run.ID(), runner.QueuedLabel)
+	}
+}
+
+func TestOracleState(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewRange(diviner.Int(0), diviner.Int(100)),
+		},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			return diviner.Metrics{"acc": 0.5}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+	}
+	// Each round is performed by a new runner with a new oracle, as
+	// if the process were restarted.
+	for round := 0; round < 3; round++ {
+		study.Oracle = new(countingOracle)
+		r := runner.New(db)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			if err := r.Loop(ctx); err != context.Canceled {
+				t.Fatal(err)
+			}
+		}()
+		_, err := r.Round(ctx, study, 2)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := db.ListRuns(context.Background(), study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params []int
+	for _, run := range runs {
+		params = append(params, int(run.Values["param"].Int()))
+	}
+	sort.Ints(params)
+	if got, want := params, []int{0, 1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if state, err := db.LookupOracleState(context.Background(), study.Name); err != nil {
+		t.Fatal(err)
+	} else if got, want := string(state), "6"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// stopAt is a scheduler that stops runs at a fixed step.
+type stopAt int
+
+func (s stopAt) Stop(_ diviner.Objective, _ string, history []diviner.MetricsStep) bool {
+	return history[len(history)-1].Step >= int(s)
+}
+
+func TestReproduce(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	local := filepath.Join(dir, "value")
+	if err := ioutil.WriteFile(local, []byte("7"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	test := testsystem.New()
+	newStudy := func(metric string) diviner.Study {
+		return diviner.Study{
+			Name:   "test",
+			Params: diviner.Params{"param": diviner.NewDiscrete(diviner.Int(1))},
+			Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+				return diviner.RunConfig{
+					Systems:    []*diviner.System{{ID: "test", System: test}},
+					LocalFiles: []string{local},
+					Script:     fmt.Sprintf("echo METRICS: %s=$(cat value),param=%s", metric, values["param"]),
+				}, nil
+			},
+			Objective: diviner.Objective{diviner.Maximize, "acc"},
+			Oracle:    &oracle.GridSearch{},
+		}
+	}
+	if done := testRun(t, db, newStudy("acc")); !done {
+		t.Fatal("not done")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	orig, err := db.LookupRun(ctx, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := orig.Config.LocalFileDigests[local]; !ok {
+		t.Errorf("local file digests not recorded: %v", orig.Config.LocalFileDigests)
+	}
+	// The study's definition has since changed; the reproduction
+	// uses the run's recorded config.
+	study := newStudy("other")
+	config, err := orig.ResolveConfig(study)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := config.ChangedLocalFiles(); err != nil {
+		t.Fatal(err)
+	} else if len(changed) != 0 {
+		t.Errorf("unexpected changed files %v", changed)
+	}
+	// Test systems cannot be restored from the database.
+	config.Systems = []*diviner.System{{ID: "test", System: test}}
+	r := runner.New(db)
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	run, err := r.Reproduce(ctx, study, orig, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.State, diviner.Success; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	run, err = db.LookupRun(ctx, "test", run.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Seq, uint64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Labels[runner.ReproducesLabel], orig.ID(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !run.Values.Equal(orig.Values) {
+		t.Errorf("got %v, want %v", run.Values, orig.Values)
+	}
+	if got, want := run.Trial().Metrics, orig.Trial().Metrics; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := ioutil.WriteFile(local, []byte("8"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := config.ChangedLocalFiles(); err != nil {
+		t.Fatal(err)
+	} else if got, want := changed, []string{local}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := testStudy(`
+		for step in 1 2 3 4 5; do
+			echo METRICS: step=$step,acc=0.$step
+			sleep 1
+		done
+	`)
+	study.Scheduler = stopAt(2)
+	run, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.State, diviner.Success; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(run.Metrics), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := run.Trial().Metrics["acc"], 0.2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMedianStopping(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	median := &scheduler.MedianStopping{MinRuns: 1}
+	good := testStudy(`
+		for step in 5 6 7; do
+			echo METRICS: step=$step,acc=0.$step
+		done
+	`)
+	good.Scheduler = median
+	run, err := r.Run(ctx, good, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(run.Metrics), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// The completed run is the reference against which the next run
+	// is stopped.
+	bad := testStudy(`
+		for step in 5 6 7; do
+			echo METRICS: step=$step,acc=0.1
+			sleep 1
+		done
+	`)
+	bad.Scheduler = median
+	run, err = r.Run(ctx, bad, diviner.Values{"param": diviner.Int(1)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.State, diviner.Success; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(run.Metrics), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func runnerTest(t *testing.T) (dir string, database diviner.Database, cleanup func()) {
+	t.Helper()
+	dir, cleanupDir := testutil.TempDir(t, "", "")
+	// Runners write dataset logs to the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Error(err)
+		}
+		cleanupDir()
+	}
+	database, err = localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return dir, database, cleanup
+}
+
+func testScript(t *testing.T, db diviner.Database, retries int, state diviner.RunState, script string) {
+	t.Helper()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := testStudy(script)
+	run, err := r.Run(ctx, study, nil, 0)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.Retries, retries; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.State, state; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	return
+}
+
+func testStudy(script string) diviner.Study {
+	test := testsystem.New()
+	systems := []*diviner.System{&diviner.System{ID: "test", System: test}}
+	return diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1)),
+		},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			config := diviner.RunConfig{
+				Systems: systems,
+				Script:  script,
+			}
+			return config, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+}
+
+func testRun(t *testing.T, db diviner.Database, study diviner.Study) (done bool) {
+	t.Helper()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	var err error
+	done, err = r.Round(ctx, study, 0)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+var policy = retry.Backoff(time.Second, 5*time.Second, 1.5)
+
+func eventually(cond func() bool) bool {
+	for try := 0; try < 10; try++ {
+		if cond() {
+			return true
+		}
+		if err := retry.Wait(context.Background(), policy, try); err != nil {
+			panic(err)
+		}
+	}
+	return cond()
+}
+
+// ExclusiveStudy returns a study of three trials whose runs fail if
+// they are performed concurrently with another run that uses the
+// same lock directory.
+func exclusiveStudy(name, lock string) diviner.Study {
+	study := testStudy(fmt.Sprintf(`
+		mkdir %[1]s || exit 1
+		sleep 0.2
+		rmdir %[1]s
+		echo METRICS: acc=1
+	`, lock))
+	study.Name = name
+	study.Params = diviner.Params{"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2))}
+	return study
+}
+
+func TestMaxParallel(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+	for _, test := range []struct {
+		studyLimit, total int
+	}{
+		{1, 0},
+		{0, 1},
+	} {
+		r := runner.New(db)
+		r.SetParallelism(test.total, 0)
+		ctx, cancel := context.WithCancel(ctx)
+		go func() {
+			if err := r.Loop(ctx); err != context.Canceled {
+				t.Fatal(err)
+			}
+		}()
+		var (
+			lock    = filepath.Join(dir, "lock")
+			studies []diviner.Study
+		)
+		if test.studyLimit > 0 {
+			study := exclusiveStudy(fmt.Sprintf("limit%d", test.studyLimit), lock)
+			study.MaxParallel = test.studyLimit
+			studies = append(studies, study)
+		} else {
+			// Studies that share the runner share its limit.
+			studies = append(studies, exclusiveStudy("a", lock), exclusiveStudy("b", lock))
+		}
+		var wg sync.WaitGroup
+		for _, study := range studies {
+			wg.Add(1)
+			go func(study diviner.Study) {
+				defer wg.Done()
+				if _, err := r.Round(ctx, study, 0); err != nil {
+					t.Error(err)
+				}
+			}(study)
+		}
+		wg.Wait()
+		cancel()
+		for _, study := range studies {
+			runs, err := db.ListRuns(context.Background(), study.Name, diviner.Any, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(runs), 3; got != want {
+				t.Errorf("%s: got %v, want %v", study.Name, got, want)
+			}
+			for _, run := range runs {
+				if got, want := run.State, diviner.Success; got != want {
+					t.Errorf("run %s: got %v, want %v", run.ID(), got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSharedSystem(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	var (
+		order  = filepath.Join(dir, "order")
+		system = &diviner.System{ID: "shared", System: testsystem.New(), Parallelism: 1}
+	)
+	newStudy := func(name string, n int) diviner.Study {
+		var values []diviner.Value
+		for i := 0; i < n; i++ {
+			values = append(values, diviner.Int(int64(i)))
+		}
+		return diviner.Study{
+			Name:   name,
+			Params: diviner.Params{"param": diviner.NewDiscrete(values...)},
+			Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+				return diviner.RunConfig{
+					Systems: []*diviner.System{system},
+					Script:  fmt.Sprintf("echo %s >> %s; sleep 0.1; echo METRICS: acc=1", name, order),
+				}, nil
+			},
+			Objective: diviner.Objective{diviner.Maximize, "acc"},
+			Oracle:    &oracle.GridSearch{},
+		}
+	}
+	var wg sync.WaitGroup
+	for _, study := range []diviner.Study{newStudy("big", 6), newStudy("small", 2)} {
+		wg.Add(1)
+		go func(study diviner.Study) {
+			defer wg.Done()
+			if _, err := r.Round(ctx, study, 0); err != nil {
+				t.Error(err)
+			}
+		}(study)
+		// The small study starts once the big study's first run has
+		// started, and its other runs are waiting for the machine.
+		for {
+			if _, err := os.Stat(order); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+	p, err := ioutil.ReadFile(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The studies take turns on the single machine, and so the small
+	// study completes before the big one.
+	lines := strings.Fields(string(p))
+	if got, want := len(lines), 8; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := strings.Join(lines[5:], ","), "big,big,big"; got != want {
+		t.Errorf("got %v, want %v (order %v)", got, want, lines)
+	}
+
+	usage := r.Usage()
+	if got, want := len(usage), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, want := range []struct {
+		study     string
+		completed int
+	}{
+		{"big", 6},
+		{"small", 2},
+	} {
+		u := usage[i]
+		if got := u.Study; got != want.study {
+			t.Errorf("got %v, want %v", got, want.study)
+		}
+		if got := u.Completed; got != want.completed {
+			t.Errorf("%s: got %v, want %v", u.Study, got, want.completed)
+		}
+		if got, want := u.Failed, 0; got != want {
+			t.Errorf("%s: got %v, want %v", u.Study, got, want)
+		}
+		if u.Runtime <= 0 {
+			t.Errorf("%s: no runtime", u.Study)
+		}
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	r.SetIdleTimeout(500 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	system := &diviner.System{ID: "pool", System: testsystem.New(), Parallelism: 1}
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2)),
+		},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems: []*diviner.System{system},
+				// Each run counts the runs that used the machine's cache
+				// before it.
+				Script: `
+					echo METRICS: cached=$(ls $DIVINER_CACHE | wc -l)
+					touch $DIVINER_CACHE/` + id,
+			}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "cached"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	if done, err := r.Round(ctx, study, 0); err != nil {
+		t.Fatal(err)
+	} else if !done {
+		t.Fatal("not done")
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cached []float64
+	for _, run := range runs {
+		cached = append(cached, run.Trial().Metrics["cached"])
+	}
+	sort.Float64s(cached)
+	if got, want := cached, []float64{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := r.Counters()["nreused"], 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The now idle machine is stopped once the timeout expires.
+	if !eventually(func() bool { return r.Counters()["nworker"] == 0 }) {
+		t.Error("idle worker was not stopped")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	// The script fails on its first two attempts.
+	counter := filepath.Join(dir, "counter")
+	study := testStudy(fmt.Sprintf(`
+		echo x >> %s
+		test $(wc -l < %s) -ge 3 || exit 1
+		echo METRICS: acc=1
+	`, counter, counter))
+	run := study.Run
+	study.Run = func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+		config, err := run(values, replicate, id)
+		config.Retry = diviner.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     10 * time.Millisecond,
+			Retryable:   []string{"."},
+		}
+		return config, err
+	}
+	final, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := final.State, diviner.Success; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := final.Attempt, 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	original := runs[0]
+	if got, want := original.State, diviner.Failure; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i, run := range runs[1:] {
+		if got, want := run.RetryOf, original.Seq; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := run.Attempt, i+2; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := run.Parent, runs[i].Seq; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	lineage, err := diviner.Lineage(ctx, db, study.Name, final.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(lineage), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := lineage[0].Seq, original.Seq; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Failures that do not match the policy are not retried.
+	study.Name = "noretry"
+	study.Run = func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+		config, err := run(values, replicate, id)
+		config.Retry = diviner.RetryPolicy{MaxAttempts: 3, Retryable: []string{"no match"}}
+		return config, err
+	}
+	if err := ioutil.WriteFile(counter, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	final, err = r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := final.State, diviner.Failure; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := final.Attempt, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	test := testsystem.New()
+	study := diviner.Study{
+		Name:   "test",
+		Params: diviner.Params{"param": diviner.NewDiscrete(diviner.Int(0))},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems: []*diviner.System{{ID: "test", System: test}},
+				Script: `
+					if [ -f state ]
+					then
+						echo METRICS: resumed=$(cat state)
+						exit 0
+					fi
+					echo 7 > state.tmp
+					mv state.tmp state
+					echo 'DIVINER: keepalive=1s'
+					sleep 10
+				`,
+				Checkpoint: diviner.Checkpoint{
+					Path:     "state",
+					URL:      filepath.Join(dir, "checkpoints"),
+					Interval: 100 * time.Millisecond,
+				},
+			}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "resumed"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	if done := testRun(t, db, study); !done {
+		t.Fatal("not done")
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	run := runs[0]
+	if got, want := run.Retries, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := run.Trial().Metrics["resumed"], 7.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, db.Log(run.Study, run.Seq, time.Time{}, false)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "diviner: resumed from checkpoint") {
+		t.Errorf("run was not resumed: %s", b.String())
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "checkpoints", "test", fmt.Sprint(run.Seq))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	acquire := func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+		return diviner.Metrics{"acc": float64(values["x"].Int())}, nil
+	}
+	prior := diviner.Study{
+		Name: "prior",
+		Params: diviner.Params{
+			"x": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2), diviner.Int(6)),
+			"y": diviner.NewDiscrete(diviner.String("a")),
+		},
+		Acquire:   acquire,
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	if done := testRun(t, db, prior); !done {
+		t.Fatal("not done")
+	}
+	study := diviner.Study{
+		Name: "warm",
+		Params: diviner.Params{
+			"x": diviner.NewDiscrete(diviner.Int(0), diviner.Int(1), diviner.Int(2), diviner.Int(3), diviner.Int(4)),
+		},
+		Transfer:  []string{"prior"},
+		Acquire:   acquire,
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	transferred, err := diviner.TransferTrials(ctx, db, study)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// x=6 is not valid in the new study.
+	if got, want := len(transferred), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, trial := range transferred {
+		if _, ok := trial.Values["y"]; ok {
+			t.Errorf("trial %v: values not restricted to study parameters", trial)
+		}
+	}
+	if done := testRun(t, db, study); !done {
+		t.Fatal("not done")
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Grid search does not repeat the transferred trials.
+	var xs []int
+	for _, run := range runs {
+		xs = append(xs, int(run.Values["x"].Int()))
+	}
+	sort.Ints(xs)
+	if got, want := fmt.Sprint(xs), "[3 4]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	study.Transfer = []string{"nonexistent"}
+	if _, err := diviner.TransferTrials(ctx, db, study); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestPBT(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if err := os.Mkdir(filepath.Join(dir, "started"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	test := testsystem.New()
+	study := diviner.Study{
+		Name:   "test",
+		Params: diviner.Params{"x": diviner.NewRange(diviner.Float(0), diviner.Float(1))},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems: []*diviner.System{{ID: "test", System: test}},
+				// Runs wait until the whole population has started, so
+				// that they are ranked together.
+				Script: fmt.Sprintf(`
+					touch %[1]s/started/%[2]v
+					while [ $(ls %[1]s/started | wc -l) -lt 4 ]
+					do
+						sleep 0.1
+					done
+					echo checkpoint > state
+					for i in 1 2 3 4 5 6
+					do
+						echo METRICS: acc=%[2]v
+						sleep 0.3
+					done
+				`, dir, values["x"]),
+				Checkpoint: diviner.Checkpoint{
+					Path:     "state",
+					URL:      filepath.Join(dir, "checkpoints"),
+					Interval: 100 * time.Millisecond,
+				},
+			}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.PBT{Population: 4, Interval: 2, Seed: 1},
+	}
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	_, err := r.Round(ctx, study, 4)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.ListRuns(context.Background(), study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exploited int
+	for _, run := range runs {
+		if strings.HasSuffix(run.Status, "(exploited)") {
+			exploited++
+		}
+	}
+	if exploited == 0 {
+		t.Fatal("no runs were exploited")
+	}
+	if got, want := len(runs), 4+exploited; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var forked int
+	for _, run := range runs {
+		if run.Derivation() != "fork" {
+			continue
+		}
+		forked++
+		if run.Parent == run.Seq {
+			t.Errorf("run %s: invalid parent %d", run.ID(), run.Parent)
+		}
+	}
+	if got, want := forked, exploited; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var b bytes.Buffer
+	for _, run := range runs {
+		b.Reset()
+		if _, err := io.Copy(&b, db.Log(study.Name, run.Seq, time.Time{}, false)); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(b.String(), "diviner: forked from run") {
+			return
+		}
+	}
+	t.Error("no forked runs")
+}
+
+func TestTimeout(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	test := testsystem.New()
+	study := diviner.Study{
+		Name:   "test",
+		Params: diviner.Params{"param": diviner.NewDiscrete(diviner.Int(0))},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems:   []*diviner.System{{ID: "test", System: test}},
+				Script:    "echo started; sleep 10",
+				Resources: diviner.Resources{CPU: 1},
+				Retry: diviner.RetryPolicy{
+					MaxAttempts:   2,
+					Backoff:       10 * time.Millisecond,
+					RetryTimeouts: true,
+					ResourceScale: 2,
+				},
+			}, nil
+		},
+		Timeout:   500 * time.Millisecond,
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	testRun(t, db, study)
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Seq < runs[j].Seq })
+	for i, run := range runs {
+		if got, want := run.State, diviner.TimedOut; got != want {
+			t.Errorf("run %d: got %v, want %v", i, got, want)
+		}
+		if got, want := run.Config.Timeout, 500*time.Millisecond; got != want {
+			t.Errorf("run %d: got %v, want %v", i, got, want)
+		}
+		if got, want := run.Config.Resources.CPU, 1<<uint(i); got != want {
+			t.Errorf("run %d: got %v, want %v", i, got, want)
+		}
+	}
+	if got, want := runs[1].Attempt, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPreemption(t *testing.T) {
+	dir, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	// The script waits to be preempted on its first attempt. (Killed
+	// test machines wait for their scripts to exit.)
+	started := filepath.Join(dir, "started")
+	test := testsystem.New()
+	study := diviner.Study{
+		Name:   "test",
+		Params: diviner.Params{"param": diviner.NewDiscrete(diviner.Int(0))},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems: []*diviner.System{{ID: "spot", System: test, Spot: true}},
+				Script: fmt.Sprintf(`
+					if [ -f %s ]
+					then
+						echo METRICS: acc=1
+						exit 0
+					fi
+					touch %s
+					sleep 5
+				`, started, started),
+			}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	go func() {
+		for {
+			if _, err := os.Stat(started); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		test.Kill(nil)
+	}()
+	final, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := final.State, diviner.Success; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Any, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Seq < runs[j].Seq })
+	if got, want := runs[0].State, diviner.Preempted; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := runs[1].RetryOf, runs[0].Seq; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// MachineSystem is a test system whose machines are described as
+// having the type "test".
+type machineSystem struct{ *testsystem.System }
+
+func (machineSystem) Machine() (machineType, region string) { return "test", "local" }
+
+func init() {
+	gob.Register(machineSystem{})
+}
+
+func TestCost(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	r := runner.New(db)
+	r.SetPricing(diviner.PriceTable{"test": {"local": 3600}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	study := diviner.Study{
+		Name:   "test",
+		Params: diviner.Params{"param": diviner.NewDiscrete(diviner.Int(0))},
+		Run: func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+			return diviner.RunConfig{
+				Systems: []*diviner.System{{ID: "test", System: machineSystem{testsystem.New()}}},
+				Script:  "sleep 1; echo METRICS: acc=1",
+			}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	run, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Cost == nil {
+		t.Fatal("run has no cost")
+	}
+	cost := *run.Cost
+	if got, want := cost.MachineType, "test"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The test machine costs a dollar per second.
+	if cost.Duration < time.Second || math.Abs(cost.Dollars-cost.Duration.Seconds()) > 1e-9 {
+		t.Errorf("bad cost %v", cost)
+	}
+	summary, err := r.StudyCost(ctx, study.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summary.Runs, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := summary.Dollars, cost.Dollars; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFidelity(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+
+	acquire := func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+		return diviner.Metrics{"acc": values["param"].Float() * float64(values["epochs"].Int())}, nil
+	}
+	fidelity := &diviner.Fidelity{Name: "epochs", Min: 1, Max: 9, Integer: true}
+	ctx := context.Background()
+
+	// Oracles that do not choose fidelities run their trials at full
+	// fidelity.
+	study := diviner.Study{
+		Name: "grid",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Float(0), diviner.Float(1)),
+		},
+		Fidelity:  fidelity,
+		Acquire:   acquire,
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	testRun(t, db, study)
+	if done := testRun(t, db, study); !done {
+		t.Error("grid search not done")
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, run := range runs {
+		if got, want := run.Values["epochs"], diviner.Value(diviner.Int(9)); !got.Equal(want) {
+			t.Errorf("run %d: got %v, want %v", run.Seq, got, want)
+		}
+	}
+
+	study.Name = "halving"
+	study.Params = diviner.Params{"param": diviner.NewRange(diviner.Float(0), diviner.Float(1))}
+	study.Oracle = &oracle.SuccessiveHalving{N: 9}
+	for round := 0; !testRun(t, db, study); round++ {
+		if round == 3 {
+			t.Fatal("successive halving not done")
+		}
+	}
+	runs, err = db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	epochs := make(map[int64]int)
+	for _, run := range runs {
+		epochs[run.Values["epochs"].Int()]++
+	}
+	if got, want := epochs, map[int64]int{1: 9, 3: 3, 9: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLease(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var runners [2]*runner.Runner
+	for i := range runners {
+		runners[i] = runner.New(db)
+		go func(r *runner.Runner) {
+			if err := r.Loop(ctx); err != context.Canceled {
+				t.Error(err)
+			}
+		}(runners[i])
+	}
+	var (
+		started = make(chan struct{})
+		finish  = make(chan struct{})
+		once    sync.Once
+	)
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewDiscrete(diviner.Int(0)),
+		},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			once.Do(func() { close(started) })
+			<-finish
+			return diviner.Metrics{"acc": 0.5}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    &oracle.GridSearch{},
+	}
+	errc := make(chan error)
+	go func() {
+		_, err := runners[0].Round(ctx, study, 1)
+		errc <- err
+	}()
+	<-started
+	// The study is held by the first runner, so the second fails
+	// right away, naming the first runner's process.
+	_, err := runners[1].Round(ctx, study, 1)
+	if err == nil || !strings.Contains(err.Error(), "being run by another runner") ||
+		!strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("bad error %v", err)
+	}
+	close(finish)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	// The lease is released when the round completes.
+	if done, err := runners[1].Round(ctx, study, 1); err != nil {
+		t.Fatal(err)
+	} else if !done {
+		t.Error("study not done")
+	}
+
+	// Expired leases, e.g., of runners that died, are taken over.
+	dead := diviner.Lease{Owner: "dead", PID: 1, Expires: time.Now().Add(-time.Second)}
+	if _, ok, err := db.AcquireLease(ctx, study.Name, dead); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	}
+	if _, err := runners[0].Round(ctx, study, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Unexpired leases are not.
+	dead.Expires = time.Now().Add(time.Hour)
+	if _, ok, err := db.AcquireLease(ctx, study.Name, dead); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true", ok, err)
+	}
+	if _, err := runners[0].Round(ctx, study, 1); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := runner.New(db)
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Error(err)
+		}
+	}()
+	// Lines longer than the scanners' buffers are split, rather than
+	// ending the run's output.
+	const n = 200000
+	study := testStudy(fmt.Sprintf(`
+		head -c %[1]d /dev/zero | tr '\0' x
+		head -c %[1]d /dev/zero | tr '\0' y >&2
+		echo
+		echo METRICS: acc=0.5
+	`, n))
+	run, err := r.Run(ctx, study, diviner.Values{"param": diviner.Int(0)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run.State, diviner.Success; got != want {
+		t.Fatalf("got %v, want %v (%s)", got, want, run.Status)
+	}
+	if got, want := run.Trial().Metrics["acc"], 0.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, stream := range []struct {
+		stream diviner.LogStream
+		c      string
+	}{{diviner.Stdout, "x"}, {diviner.Stderr, "y"}} {
+		p, err := ioutil.ReadAll(diviner.FilterLog(db.Log(run.Study, run.Seq, time.Time{}, false), stream.stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Count(string(p), stream.c), n; got < want {
+			t.Errorf("%s: got %v, want at least %v", stream.c, got, want)
 		}
 	}
-	return cond()
 }
@@ -0,0 +1,167 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package runner
+
+import (
+	"sort"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+// A runner's studies share its machines: its sessions are keyed by
+// system, and so workers started for one study's runs are reused by
+// the runs of other studies on the same systems. When a worker
+// becomes available, it is given to the waiting request of the study
+// whose runs currently hold the fewest workers, so that a study with
+// many pending runs does not starve the others. Ties are broken in
+// favor of the study that was least recently given a worker, and
+// then by the requests' order of arrival. Requests made to compute
+// datasets are served first, since the studies' runs wait on them.
+
+// A share tracks the workers held by, and given to, each study. It
+// is maintained by the runner's loop.
+type share struct {
+	// Busy stores the number of workers held by each study's runs.
+	busy map[string]int
+	// Served stores, for each study, the value of n when the study
+	// was last given a worker.
+	served map[string]uint64
+	n      uint64
+}
+
+func newShare() *share {
+	return &share{busy: make(map[string]int), served: make(map[string]uint64)}
+}
+
+// Acquire records that a worker was given to the named study.
+func (s *share) acquire(study string) {
+	s.n++
+	s.busy[study]++
+	s.served[study] = s.n
+}
+
+// Release records that a worker held by the named study was returned.
+func (s *share) release(study string) {
+	if s.busy[study]--; s.busy[study] <= 0 {
+		delete(s.busy, study)
+	}
+}
+
+// Next returns the request among the provided ones that should be
+// given the next available worker, or nil if there are none.
+func (s *share) next(requests map[*request]struct{}) *request {
+	var next *request
+	for req := range requests {
+		if next == nil || s.before(req, next) {
+			next = req
+		}
+	}
+	return next
+}
+
+// Before tells whether the request r should be served before the
+// request q.
+func (s *share) before(r, q *request) bool {
+	if (r.study == "") != (q.study == "") {
+		return r.study == ""
+	}
+	if nr, nq := s.busy[r.study], s.busy[q.study]; nr != nq {
+		return nr < nq
+	}
+	if sr, sq := s.served[r.study], s.served[q.study]; sr != sq {
+		return sr < sq
+	}
+	return r.seq < q.seq
+}
+
+// StudyUsage accounts for a study's use of a runner.
+type StudyUsage struct {
+	// Study is the name of the study.
+	Study string
+	// Pending is the number of the study's runs that are waiting for
+	// datasets, machines, or concurrency slots.
+	Pending int
+	// Running is the number of the study's runs that are running.
+	Running int
+	// Workers is the number of machines held by the study's runs.
+	Workers int
+	// Completed is the number of the study's runs that were
+	// completed successfully by the runner.
+	Completed int
+	// Failed is the number of the study's runs that were completed
+	// unsuccessfully (e.g., they failed, or timed out) by the runner.
+	Failed int
+	// Runtime is the total runtime of the study's completed runs.
+	Runtime time.Duration
+	// Dollars is the estimated cost of the study's completed runs
+	// whose machines are priced (see SetPricing).
+	Dollars float64
+}
+
+// Usage returns the usage of the runner by each of the studies it
+// has run, ordered by study name.
+func (r *Runner) Usage() []StudyUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage := make(map[string]*StudyUsage)
+	get := func(study string) *StudyUsage {
+		if u, ok := usage[study]; ok {
+			return u
+		}
+		u := &StudyUsage{Study: study}
+		if prev, ok := r.usage[study]; ok {
+			*u = *prev
+		}
+		usage[study] = u
+		return u
+	}
+	for study := range r.usage {
+		get(study)
+	}
+	for study, runs := range r.runs {
+		u := get(study)
+		for _, run := range runs {
+			switch status, _, _ := run.Status(); status {
+			case statusRunning:
+				u.Running++
+			case statusWaiting:
+				u.Pending++
+			}
+		}
+	}
+	for study, n := range r.busy {
+		if study != "" {
+			get(study).Workers = n
+		}
+	}
+	studies := make([]StudyUsage, 0, len(usage))
+	for _, u := range usage {
+		studies = append(studies, *u)
+	}
+	sort.Slice(studies, func(i, j int) bool {
+		return studies[i].Study < studies[j].Study
+	})
+	return studies
+}
+
+// Account adds the provided completed run to its study's usage. It
+// must be called with r.mu held.
+func (r *Runner) account(run diviner.Run) {
+	u, ok := r.usage[run.Study]
+	if !ok {
+		u = &StudyUsage{Study: run.Study}
+		r.usage[run.Study] = u
+	}
+	if run.State == diviner.Success {
+		u.Completed++
+	} else {
+		u.Failed++
+	}
+	u.Runtime += run.Runtime
+	if run.Cost != nil && run.Cost.Priced() {
+		u.Dollars += run.Cost.Dollars
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package runner
+
+import "testing"
+
+func TestShare(t *testing.T) {
+	s := newShare()
+	var (
+		requests = make(map[*request]struct{})
+		seq      uint64
+	)
+	add := func(study string) *request {
+		seq++
+		req := newRequest(study, nil)
+		req.seq = seq
+		requests[req] = struct{}{}
+		return req
+	}
+	serve := func() string {
+		req := s.next(requests)
+		delete(requests, req)
+		s.acquire(req.study)
+		return req.study
+	}
+	for i := 0; i < 4; i++ {
+		add("big")
+	}
+	add("small")
+	// The oldest request is served first; then the study that holds
+	// no workers.
+	for _, want := range []string{"big", "small", "big"} {
+		if got := serve(); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	add("small")
+	add("")
+	// Datasets are served first.
+	if got, want := serve(), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	s.release("")
+	// Small holds fewer workers.
+	if got, want := serve(), "small"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// With a single worker, studies take turns.
+	s.release("big")
+	s.release("big")
+	s.release("small")
+	s.release("small")
+	add("small")
+	if got, want := serve(), "big"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	s.release("big")
+	if got, want := serve(), "small"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	s.release("small")
+	if got, want := serve(), "big"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(s.busy), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -26,7 +26,9 @@ type Streamer struct {
 // study. Streamers maintain the target parallelism, requesting new
 // points from the underlying oracle as they are needed. Streaming
 // studies stop when they are requested by the caller, or after running
-// out of points to explore, as determined by the study's oracle.
+// out of points to explore, as determined by the study's oracle. While
+// the study is paused (see Runner.Pause), the streamer launches no new
+// trials.
 func (r *Runner) Stream(ctx context.Context, study diviner.Study, nparallel int) *Streamer {
 	s := &Streamer{
 		runner:    r,
@@ -46,6 +48,7 @@ type runRequest struct {
 	diviner.Values                 // run values
 	diviner.Replicates             // already computed replicates
 	Failed             map[int]int // of the uncomputed replicates, maps replicate to previous failed run for restarts
+	Queued             bool        // whether the values were dequeued from the study's queue
 }
 
 type runResponse struct {
@@ -54,7 +57,16 @@ type runResponse struct {
 	Err error
 }
 
-func (s *Streamer) do(ctx context.Context) error {
+func (s *Streamer) do(ctx context.Context) (err error) {
+	release, err := s.runner.holdLease(ctx, s.study)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lost := release(); lost != nil && err == nil {
+			err = lost
+		}
+	}()
 	nreplicate := s.study.Replicates
 	if nreplicate == 0 {
 		nreplicate = 1
@@ -100,10 +112,23 @@ func (s *Streamer) do(ctx context.Context) error {
 							if err != nil {
 								return err
 							}
+							// Resumed runs keep their recorded seeds.
+							if seed := run0.Run.Config.Seed; seed != 0 {
+								run0.Config.Seed = seed
+							}
 							Logger.Printf("%s: resuming run %s (replicate %d)", s.study.Name, run0, replicate)
 						} else {
-							var err error
-							if run0, err = s.runner.create(ctx, s.study, req.Values, replicate); err != nil {
+							prev, ok, err := s.runner.completed(ctx, s.study, req.Values, replicate)
+							if err != nil {
+								return err
+							}
+							if ok {
+								mu.Lock()
+								runs = append(runs, prev)
+								mu.Unlock()
+								return nil
+							}
+							if run0, err = s.runner.create(ctx, s.study, req.Values, replicate, req.Queued); err != nil {
 								return err
 							}
 						}
@@ -122,9 +147,11 @@ func (s *Streamer) do(ctx context.Context) error {
 				}
 				trials := make([]diviner.Trial, len(runs))
 				for i := range trials {
-					trials[i] = runs[i].Trial()
+					trials[i] = s.study.Trial(runs[i])
 				}
-				resps <- runResponse{Index: req.Index, Trial: diviner.ReplicatedTrial(trials)}
+				trial := diviner.ReplicatedTrial(trials)
+				trial.Infeasible = !trial.Pending && !s.study.Feasible(trial.Metrics)
+				resps <- runResponse{Index: req.Index, Trial: trial}
 			}
 		}()
 	}
@@ -132,9 +159,14 @@ func (s *Streamer) do(ctx context.Context) error {
 	var (
 		npending int
 		valueq   []diviner.Values
-		trials   []diviner.Trial
-		done     bool
-		stopc    = s.stopc
+		// Nqueued is the number of values at the front of valueq
+		// that were dequeued from the study's queue.
+		nqueued int
+		trials  []diviner.Trial
+		done    bool
+		stopc   = s.stopc
+		// Exhausted tells whether the study's oracle is exhausted.
+		exhausted bool
 	)
 	// We query the database once at the beginning and then maintain our
 	// own set of running trials. This helps us reduce database load but
@@ -144,13 +176,18 @@ func (s *Streamer) do(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	// Trials transferred from previous studies are given to the
+	// oracle along with the study's own.
+	if trials, err = s.runner.transferTrials(ctx, s.study, initTrials); err != nil {
+		return err
+	}
 	initTrials.Range(func(_ diviner.Value, v interface{}) {
 		trial := v.(diviner.Trial)
 		if trial.Replicates.Completed(s.study.Replicates) {
 			trials = append(trials, trial)
 		}
 	})
-	failed, err := diviner.Trials(ctx, s.runner.db, s.study, diviner.Failure)
+	failed, err := diviner.Trials(ctx, s.runner.db, s.study, diviner.Failure|diviner.TimedOut)
 	if err != nil {
 		return err
 	}
@@ -167,27 +204,47 @@ func (s *Streamer) do(ctx context.Context) error {
 		default:
 		}
 
-		if n := s.nparallel - npending; !done && len(valueq) == 0 && n > 0 {
+		// While the study is paused, no new points are requested and
+		// no new runs are launched; pending runs are left to complete.
+		pausec := s.runner.pausedc(s.study.Name)
+		if n := s.nparallel - npending; !done && pausec == nil && len(valueq) == 0 && n > 0 {
+			// Runners that lose their leases launch no new runs.
+			if err := s.runner.leaseLost(s.study.Name); err != nil {
+				return err
+			}
+			if stopped, err := s.runner.stopped(ctx, s.study); err != nil {
+				return err
+			} else if stopped {
+				done = true
+				continue
+			}
 			Logger.Printf("%s: requesting %d new points from oracle from %d trials (streaming, %d failed)", s.study.Name, n, len(trials), failed.Len())
 			// TODO(marius): it may be useful to request more points
 			// than we can immediately fill, especially for expensive oracles.
 			// Alternatively, we could make oracle stateful.
 			var err error
-			valueq, err = s.study.Oracle.Next(trials, s.study.Params, s.study.Objective, n)
+			valueq, nqueued, err = s.runner.nextValues(ctx, s.study, trials, n)
 			if err != nil {
 				return err
 			}
-			done = len(valueq) < n
+			done = oracleExhausted(s.study, n, len(valueq), npending)
+			exhausted = done
+			if len(valueq) == 0 && done {
+				// Nothing to request; the loop ends if no runs are
+				// pending.
+				continue
+			}
 		}
 
 		var (
 			reqc chan runRequest
 			req  runRequest
 		)
-		if len(valueq) > 0 {
+		if len(valueq) > 0 && pausec == nil {
 			reqc = reqs
 			req.Index = len(trials)
 			req.Values = valueq[0]
+			req.Queued = nqueued > 0
 			if v, ok := initTrials.Get(valueq[0]); ok {
 				req.Replicates = v.(diviner.Trial).Replicates
 			}
@@ -205,6 +262,9 @@ func (s *Streamer) do(ctx context.Context) error {
 		case reqc <- req:
 			trials = append(trials, diviner.Trial{Values: valueq[0], Pending: true})
 			valueq = valueq[1:]
+			if nqueued > 0 {
+				nqueued--
+			}
 			npending++
 		case resp := <-resps:
 			npending--
@@ -215,8 +275,12 @@ func (s *Streamer) do(ctx context.Context) error {
 		case <-stopc:
 			done = true
 			stopc = nil
+		case <-pausec:
 		}
 	}
+	if exhausted {
+		s.runner.notify(ctx, s.study, diviner.StudyFinished, diviner.Run{}, "oracle exhausted")
+	}
 	return nil
 }
 
@@ -8,6 +8,8 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -189,3 +191,110 @@ func TestStreamError(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestStreamPause(t *testing.T) {
+	_, db, cleanup := runnerTest(t)
+	defer cleanup()
+	const N = 4
+	var (
+		enterc = make(chan int)
+		exitc  = make(chan struct{})
+	)
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"param": diviner.NewRange(diviner.Int(0), diviner.Int(N)),
+		},
+		Acquire: func(values diviner.Values, replicate int, id string) (diviner.Metrics, error) {
+			enterc <- int(values["param"].Int())
+			<-exitc
+			return diviner.Metrics{"acc": float64(values["param"].Int())}, nil
+		},
+		Objective: diviner.Objective{diviner.Maximize, "acc"},
+		Oracle:    new(oracle.GridSearch),
+	}
+	r := runner.New(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := r.Loop(ctx); err != context.Canceled {
+			t.Fatal(err)
+		}
+	}()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	post := func(path string) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path+"?study=test", "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("%s: got %v, want %v", path, got, want)
+		}
+	}
+
+	streamer := r.Stream(ctx, study, 1)
+	<-enterc
+	post("/pause")
+	if !r.Paused("test") {
+		t.Fatal("study not paused")
+	}
+	// The in-flight run completes, but no new runs are launched.
+	exitc <- struct{}{}
+	select {
+	case param := <-enterc:
+		t.Fatalf("run %d launched while paused", param)
+	case <-time.After(time.Second):
+	}
+	runs, err := db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	post("/resume")
+	if r.Paused("test") {
+		t.Fatal("study still paused")
+	}
+	for i := 1; i < N; i++ {
+		<-enterc
+		exitc <- struct{}{}
+	}
+	if err := streamer.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = db.ListRuns(ctx, study.Name, diviner.Success, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(runs), N; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// A round started while the study is paused waits until the
+	// study is resumed. Its run has a new value, which must be
+	// valid for the study's parameters.
+	study.Params = diviner.Params{"param": diviner.NewRange(diviner.Int(0), diviner.Int(N+1))}
+	study.Oracle = &repeatOracle{Values: diviner.Values{"param": diviner.Int(N)}, N: 1}
+	r.Pause("test")
+	donec := make(chan error)
+	go func() {
+		_, err := r.Round(ctx, study, 1)
+		donec <- err
+	}()
+	select {
+	case <-enterc:
+		t.Fatal("round launched run while paused")
+	case <-time.After(time.Second):
+	}
+	r.Resume("test")
+	<-enterc
+	exitc <- struct{}{}
+	if err := <-donec; err != nil {
+		t.Fatal(err)
+	}
+}
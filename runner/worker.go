@@ -5,9 +5,9 @@
 package runner
 
 import (
+	"bufio"
 	"context"
 	"encoding/gob"
-	"errors"
 	"expvar"
 	"fmt"
 	"io"
@@ -15,12 +15,19 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/grailbio/base/errors"
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/base/retry"
 	"github.com/grailbio/bigmachine"
 	"github.com/grailbio/bigmachine/rpc"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/storage"
 	"github.com/kr/pty"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/time/rate"
@@ -63,6 +70,15 @@ type worker struct {
 	// List of sessions from which a machine may be allocated.
 	Candidates []*session
 
+	// Holder is the study whose run holds the worker, or empty if
+	// it is held to compute a dataset. Held tells whether the worker
+	// is held. These are maintained by the runner loop.
+	holder string
+	held   bool
+	// Uses is the number of requests to which the worker has been
+	// given.
+	uses int
+
 	returnc chan<- *worker
 	err     error
 }
@@ -164,11 +180,27 @@ func (w *worker) CopyFiles(ctx context.Context, files []string) error {
 	return nil
 }
 
-// Run runs the provided script using the Bash shell interpreter. The
-// current working directory is set to the worker's command working
-// space. The returned io.ReadCloser is the processes' standard
-// output and standard error.
-func (w *worker) Run(ctx context.Context, script string, env []string) (io.ReadCloser, error) {
+// SaveCheckpoint saves the provided checkpoint file from the worker's
+// command working space to its URL.
+func (w *worker) SaveCheckpoint(ctx context.Context, path, url string) error {
+	return w.Call(ctx, "Cmd.SaveCheckpoint", checkpointFile{path, url}, nil)
+}
+
+// RestoreCheckpoint restores the provided checkpoint file from its
+// URL to the worker's command working space. It tells whether a
+// checkpoint was restored.
+func (w *worker) RestoreCheckpoint(ctx context.Context, path, url string) (bool, error) {
+	var restored bool
+	err := w.Call(ctx, "Cmd.RestoreCheckpoint", checkpointFile{path, url}, &restored)
+	return restored, err
+}
+
+// Run runs the provided script using the Bash shell interpreter,
+// inside the provided container if its image is set. The current
+// working directory is set to the worker's command working space.
+// The returned io.ReadCloser is the processes' standard output and
+// standard error.
+func (w *worker) Run(ctx context.Context, script string, env []string, container container) (io.ReadCloser, error) {
 	var out io.ReadCloser
 	preamble := w.Session.System.Preamble
 	if preamble == "" {
@@ -176,8 +208,9 @@ func (w *worker) Run(ctx context.Context, script string, env []string) (io.ReadC
 	}
 	script = preamble + script
 	c := cmd{
-		Args: []string{"bash", "-c", script},
-		Env:  env,
+		Args:      []string{"bash", "-c", script},
+		Env:       env,
+		Container: container,
 	}
 	err := w.Call(ctx, "Cmd.Run", c, &out)
 	return out, err
@@ -188,6 +221,18 @@ func (w *worker) Return() {
 	w.returnc <- w
 }
 
+// Lost tells whether the worker's machine has been lost: i.e., it has
+// stopped, or it does not respond to pings.
+func (w *worker) Lost() bool {
+	if w.State() == bigmachine.Stopped {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	var seq int
+	return w.Call(ctx, "Supervisor.Ping", 1, &seq) != nil
+}
+
 // Err returns the worker's error condition. If non-nil, the worker
 // is unhealthy and should be discarded.
 func (w *worker) Err() error {
@@ -214,8 +259,23 @@ type cmd struct {
 	// processes' default environment: variables that appear in Env
 	// override those in the default environment.
 	Env []string
+	// Container is the container in which the command is run.
+	Container container
 }
 
+// A container describes the container in which a command is run.
+type container struct {
+	// Image is the container image. Commands are run directly on the
+	// worker machine if it is empty.
+	Image string
+	// GPU tells whether the container is given access to the
+	// machine's GPUs.
+	GPU bool
+}
+
+// ContainerSeq is used to name the containers run by commandService.
+var containerSeq int64
+
 // CommandService is a simple bigmachine service to run commands. A
 // commandService always has a workspace, which may be reset by
 // calling Reset.
@@ -223,10 +283,18 @@ type commandService struct {
 	// Gob needs at least one exported field.
 	ExportedForGob int
 	dir            string
+	// Cache is the machine's cache directory, which is provided to
+	// commands as DIVINER_CACHE. It is retained when the workspace is
+	// reset, so that files cached there by one run (e.g., downloaded
+	// datasets) may be reused by later runs on the same machine.
+	cache string
 }
 
 func (c *commandService) Init(_ *bigmachine.B) error {
 	var err error
+	if c.cache, err = ioutil.TempDir("", "cache"); err != nil {
+		return err
+	}
 	c.dir, err = ioutil.TempDir("", "command")
 	return err
 }
@@ -253,37 +321,144 @@ func (c *commandService) WriteFile(ctx context.Context, file fileLiteral, _ *str
 	return ioutil.WriteFile(filepath.Join(c.dir, filepath.Base(file.Name)), file.Contents, 0644)
 }
 
+// A checkpointFile names a checkpoint file in the workspace and the
+// URL at which it is stored.
+type checkpointFile struct {
+	Path string
+	URL  string
+}
+
+// SaveCheckpoint copies the provided checkpoint file from the
+// workspace to its URL. The file is not saved if it does not (yet)
+// exist.
+func (c *commandService) SaveCheckpoint(ctx context.Context, ckpt checkpointFile, _ *struct{}) error {
+	f, err := os.Open(filepath.Join(c.dir, ckpt.Path))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return storage.Put(ctx, ckpt.URL, f)
+}
+
+// RestoreCheckpoint copies the provided checkpoint file from its URL
+// into the workspace. The reply tells whether the checkpoint existed.
+func (c *commandService) RestoreCheckpoint(ctx context.Context, ckpt checkpointFile, restored *bool) error {
+	r, err := storage.Open(ctx, ckpt.URL)
+	if errors.Is(errors.NotExist, err) {
+		*restored = false
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+	path := filepath.Join(c.dir, ckpt.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	*restored = true
+	return f.Close()
+}
+
+// ExitTag prefixes the trailer line with which the worker reports how
+// a command exited, of the form "exit=<code>" or "signal=<name>".
+const exitTag = '\x03'
+
+// pingTimeout is the amount of time to wait for a worker to respond
+// to a ping before its machine is considered lost.
+const pingTimeout = 30 * time.Second
+
 // Run runs a command in the workspace. Its standard output and error are
-// streamed to the provided ReadCloser.
-//
-// TODO(marius): multiplex the streams to separate standard output from
-// standard error.
+// streamed to the provided ReadCloser, line by line; lines from standard
+// error are prefixed with diviner.StderrTag. Once the command exits,
+// a trailer line prefixed with exitTag reports its exit status.
 func (c *commandService) Run(ctx context.Context, command cmd, reply *io.ReadCloser) error {
 	if len(command.Args) == 0 {
 		return errors.New("empty command")
 	}
-	// Connect the child's stdout/stderr to a terminal.  This will prevent the
-	// process from buffering outputs (e.g., C FILE* does so by default).
-	pty, tty, err := pty.Open()
+	// Connect the child's stdout and stderr to (separate) terminals.
+	// This will prevent the process from buffering outputs (e.g., C
+	// FILE* does so by default).
+	stdoutPty, stdoutTty, err := pty.Open()
 	if err != nil {
 		return err
 	}
+	stderrPty, stderrTty, err := pty.Open()
+	if err != nil {
+		stdoutPty.Close()
+		stdoutTty.Close()
+		return err
+	}
 	piper, pipew := io.Pipe()
-	eg := errgroup.Group{}
+	var (
+		mu     sync.Mutex
+		eg     errgroup.Group
+		copies sync.WaitGroup
+	)
+	args := command.Args
+	var name string
+	if command.Container.Image != "" {
+		name = fmt.Sprintf("diviner-%d-%d", os.Getpid(), atomic.AddInt64(&containerSeq, 1))
+		args = c.dockerArgs(command, name)
+	}
 	eg.Go(func() error {
-		defer tty.Close()
-		cmd := exec.CommandContext(ctx, command.Args[0], command.Args[1:]...)
+		defer stdoutTty.Close()
+		defer stderrTty.Close()
+		if name != "" {
+			// Killing the docker client does not stop its container.
+			defer func() {
+				if ctx.Err() != nil {
+					if err := exec.Command("docker", "rm", "-f", name).Run(); err != nil {
+						log.Error.Printf("remove container %s: %v", name, err)
+					}
+				}
+			}()
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 		cmd.Env = append(os.Environ(), command.Env...)
 		cmd.Env = append(cmd.Env, "DIVINER=1")
-		cmd.Stdout = tty
-		cmd.Stderr = tty
+		if c.cache != "" {
+			cmd.Env = append(cmd.Env, "DIVINER_CACHE="+c.cache)
+		}
+		cmd.Stdout = stdoutTty
+		cmd.Stderr = stderrTty
 		cmd.Dir = c.dir
 		return cmd.Run()
 	})
+	var stdoutErr, stderrErr error
+	copies.Add(2)
+	go func() {
+		defer copies.Done()
+		stdoutErr = copyLines(pipew, &mu, stdoutPty, nil)
+	}()
+	go func() {
+		defer copies.Done()
+		stderrErr = copyLines(pipew, &mu, stderrPty, []byte{diviner.StderrTag})
+	}()
 	go func() {
-		_, _ = io.Copy(pipew, pty)
+		copies.Wait()
 		err := eg.Wait()
-		pty.Close()
+		if trailer := exitTrailer(err); trailer != "" {
+			_, _ = io.WriteString(pipew, trailer)
+		}
+		// Output that could not be read is reported as the run's
+		// error, so that it is not lost silently.
+		if err == nil && stdoutErr != nil {
+			err = fmt.Errorf("read standard output: %v", stdoutErr)
+		} else if err == nil && stderrErr != nil {
+			err = fmt.Errorf("read standard error: %v", stderrErr)
+		}
+		stdoutPty.Close()
+		stderrPty.Close()
 		pipew.CloseWithError(err)
 	}()
 
@@ -293,3 +468,99 @@ func (c *commandService) Run(ctx context.Context, command cmd, reply *io.ReadClo
 	*reply = rpc.Flush(piper)
 	return nil
 }
+
+// DockerArgs returns the arguments of the docker command that runs the
+// provided command in its container, with the provided name. Docker
+// pulls the container's image if it is not already present on the
+// machine. The workspace is mounted at the same path in the
+// container, where it is the working directory, and the command's
+// environment is passed through to the container.
+func (c *commandService) dockerArgs(command cmd, name string) []string {
+	args := []string{
+		"docker", "run", "--rm", "--init",
+		"--name", name,
+		"--network", "host",
+	}
+	if c.cache != "" {
+		args = append(args, "--volume", c.cache+":"+c.cache, "--env", "DIVINER_CACHE")
+	}
+	args = append(args,
+		"--volume", c.dir+":"+c.dir,
+		"--workdir", c.dir,
+		"--env", "DIVINER",
+	)
+	if command.Container.GPU {
+		args = append(args, "--gpus", "all")
+	}
+	for _, kv := range command.Env {
+		args = append(args, "--env", strings.SplitN(kv, "=", 2)[0])
+	}
+	args = append(args, command.Container.Image)
+	return append(args, command.Args...)
+}
+
+// CopyLines copies the lines (and progress updates, see scanProgress)
+// read from r to w, prefixing each with tag. Mu serializes writes to w
+// so that lines from different streams are not interleaved. Lines
+// that, tagged, would be longer than maxLineSize are split into
+// chunks (see scanChunks), so that they do not end the copy. CopyLines
+// returns the error, if any, encountered while reading r, other than
+// that with which the terminal reports that it was closed.
+func copyLines(w io.Writer, mu *sync.Mutex, r io.Reader, tag []byte) error {
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, maxLineSize)
+	scan.Split(scanChunks(maxLineSize - len(tag)))
+	for scan.Scan() {
+		token := scan.Bytes()
+		line := make([]byte, 0, len(tag)+len(token)+1)
+		line = append(append(line, tag...), token...)
+		if len(token) == 0 || token[len(token)-1] != '\r' {
+			line = append(line, '\n')
+		}
+		mu.Lock()
+		_, err := w.Write(line)
+		mu.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	// The terminal reports an error once the command has exited and
+	// closed it; keep draining if we stopped early, so that the
+	// command never blocks on a full terminal.
+	_, _ = io.Copy(ioutil.Discard, r)
+	err := scan.Err()
+	if e, ok := err.(*os.PathError); ok && e.Err == syscall.EIO {
+		err = nil
+	}
+	return err
+}
+
+// ScanChunks returns a split function that splits its input as
+// scanProgress does, except that lines longer than max bytes are split
+// into chunks of max bytes, each of which is returned as a line.
+func scanChunks(max int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = scanProgress(data, atEOF)
+		if err == nil && advance == 0 && token == nil && len(data) >= max {
+			return max, data[:max], nil
+		}
+		return
+	}
+}
+
+// ExitTrailer returns the trailer line that reports the exit status
+// described by the provided error, as returned by exec.Cmd.Run. It
+// returns an empty string if the command did not run to completion.
+func exitTrailer(err error) string {
+	if err == nil {
+		return string(exitTag) + "exit=0\n"
+	}
+	e, ok := err.(*exec.ExitError)
+	if !ok {
+		return ""
+	}
+	if status, ok := e.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return fmt.Sprintf("%csignal=%s\n", exitTag, status.Signal())
+	}
+	return fmt.Sprintf("%cexit=%d\n", exitTag, e.ExitCode())
+}
@@ -8,8 +8,12 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/grailbio/testutil"
 )
 
 func TestCommand(t *testing.T) {
@@ -31,3 +35,85 @@ func TestCommand(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCommandCache(t *testing.T) {
+	ctx := context.Background()
+	var c commandService
+	if err := c.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	run := func(script string) string {
+		t.Helper()
+		var out io.ReadCloser
+		if err := c.Run(ctx, cmd{Args: []string{"bash", "-c", script}}, &out); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ioutil.ReadAll(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return strings.SplitN(string(p), "\n", 2)[0]
+	}
+	run(`echo cached > $DIVINER_CACHE/data; echo scratch > data`)
+	if err := c.Reset(ctx, struct{}{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := run(`cat $DIVINER_CACHE/data`), "cached"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := run(`test -e data && echo exists || echo reset`), "reset"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCommandContainer(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	// The fake docker prints its arguments.
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker"), []byte("#!/bin/sh\necho \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir+":"+path)
+
+	ctx := context.Background()
+	var c commandService
+	if err := c.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+	command := cmd{
+		Args:      []string{"bash", "-c", "echo ok"},
+		Env:       []string{"FOO=bar"},
+		Container: container{Image: "pytorch/pytorch:1.4", GPU: true},
+	}
+	var out io.ReadCloser
+	if err := c.Run(ctx, command, &out); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(p), "\n")
+	if got, want := len(lines), 3; got != want {
+		t.Fatalf("got %v, want %v: %q", got, want, p)
+	}
+	if got, want := lines[1], string(exitTag)+"exit=0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	args := lines[0]
+	for _, want := range []string{
+		"run --rm --init --name diviner-",
+		"--volume " + c.cache + ":" + c.cache + " --env DIVINER_CACHE ",
+		"--volume " + c.dir + ":" + c.dir + " --workdir " + c.dir + " ",
+		"--env DIVINER --gpus all --env FOO pytorch/pytorch:1.4 bash -c echo ok",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("docker arguments %q do not contain %q", args, want)
+		}
+	}
+}
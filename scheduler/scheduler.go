@@ -0,0 +1,289 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package scheduler implements early-stopping schedulers
+// (diviner.Scheduler) that terminate underperforming runs based on
+// the intermediate metrics they report. Runs report their progress
+// by tagging metrics with a step (see diviner.StepMetric), e.g., the
+// number of training epochs completed; schedulers compare runs at
+// the same step.
+package scheduler
+
+import (
+	"encoding/gob"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/grailbio/diviner"
+)
+
+func init() {
+	gob.Register(&ASHA{})
+	gob.Register(&Hyperband{})
+	gob.Register(&MedianStopping{})
+}
+
+const defaultEta = 3
+
+// ASHA implements the asynchronous successive halving algorithm [1].
+// Runs are evaluated at a geometric sequence of steps, called rungs:
+// MinStep, MinStep*Eta, MinStep*Eta², and so on. When a run reaches a
+// rung, it is stopped unless its objective value is among the top
+// 1/Eta of the values observed by runs that previously reached the
+// same rung. Decisions are made as soon as a run reaches a rung:
+// runs never wait for others to complete.
+//
+// [1] Li et al., "A System for Massively Parallel Hyperparameter
+// Tuning", https://arxiv.org/abs/1810.05934
+type ASHA struct {
+	// MinStep is the step of the first rung: runs are never stopped
+	// before they reach it. Defaults to 1.
+	MinStep int
+	// MaxStep, if positive, is the step at which runs complete; rungs
+	// are placed only below MaxStep.
+	MaxStep int
+	// Eta is the reduction factor: only the top 1/Eta of runs are
+	// permitted to continue at each rung. Defaults to 3.
+	Eta int
+
+	mu sync.Mutex
+	// rungs stores, for each rung, the objective values (oriented so
+	// that larger is better) of the runs that have reached it.
+	rungs []map[string]float64
+}
+
+// Stop implements diviner.Scheduler.
+func (a *ASHA) Stop(objective diviner.Objective, id string, history []diviner.MetricsStep) bool {
+	last := history[len(history)-1]
+	value, ok := last.Metrics[objective.Metric]
+	if !ok || math.IsNaN(value) {
+		return false
+	}
+	if objective.Direction == diviner.Minimize {
+		value = -value
+	}
+	var (
+		minStep, eta = a.params()
+		budget       = minStep
+		stop         bool
+	)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k := 0; budget <= last.Step && (a.MaxStep <= 0 || budget < a.MaxStep); k++ {
+		if k == len(a.rungs) {
+			a.rungs = append(a.rungs, make(map[string]float64))
+		}
+		if _, ok := a.rungs[k][id]; !ok {
+			a.rungs[k][id] = value
+			stop = stop || !top(a.rungs[k], value, eta)
+		}
+		budget *= eta
+	}
+	return stop
+}
+
+func (a *ASHA) params() (minStep, eta int) {
+	minStep, eta = a.MinStep, a.Eta
+	if minStep <= 0 {
+		minStep = 1
+	}
+	if eta <= 1 {
+		eta = defaultEta
+	}
+	return
+}
+
+// top tells whether the provided value is among the top 1/eta of
+// values in the rung. The first eta runs to reach a rung are always
+// permitted to continue.
+func top(rung map[string]float64, value float64, eta int) bool {
+	if len(rung) <= eta {
+		return true
+	}
+	values := make([]float64, 0, len(rung))
+	for _, v := range rung {
+		values = append(values, v)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(values)))
+	return value >= values[len(values)/eta-1]
+}
+
+// Hyperband implements asynchronous Hyperband [1], which hedges
+// against ASHA's aggressiveness by running several ASHA brackets,
+// each of which begins at a successively later rung. Runs are
+// assigned to brackets by their IDs.
+//
+// [1] Li et al., "Hyperband: A Novel Bandit-Based Approach to
+// Hyperparameter Optimization", https://arxiv.org/abs/1603.06560
+type Hyperband struct {
+	// MinStep is the step of the first rung of the most aggressive
+	// bracket. Defaults to 1.
+	MinStep int
+	// MaxStep is the step at which runs complete.
+	MaxStep int
+	// Eta is the reduction factor of each bracket. Defaults to 3.
+	Eta int
+	// Brackets is the number of brackets. It defaults to the maximum
+	// number of brackets that fit between MinStep and MaxStep.
+	Brackets int
+
+	once     sync.Once
+	brackets []*ASHA
+}
+
+// Stop implements diviner.Scheduler.
+func (h *Hyperband) Stop(objective diviner.Objective, id string, history []diviner.MetricsStep) bool {
+	h.once.Do(h.init)
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(id))
+	return h.brackets[hash.Sum32()%uint32(len(h.brackets))].Stop(objective, id, history)
+}
+
+func (h *Hyperband) init() {
+	proto := ASHA{MinStep: h.MinStep, MaxStep: h.MaxStep, Eta: h.Eta}
+	minStep, eta := proto.params()
+	n := h.Brackets
+	if n <= 0 {
+		n = 1
+		for step := minStep * eta; step < h.MaxStep; step *= eta {
+			n++
+		}
+	}
+	h.brackets = make([]*ASHA, n)
+	for i := range h.brackets {
+		h.brackets[i] = &ASHA{MinStep: minStep, MaxStep: h.MaxStep, Eta: eta}
+		minStep *= eta
+	}
+}
+
+const defaultMinRuns = 3
+
+// MedianStopping implements the median stopping rule [1]: a run is
+// stopped at step s if the best objective value it has reported by
+// step s is worse than the median of the running averages of the
+// objective values reported up to step s by the study's completed
+// runs. Only completed runs that reached step s are compared. The
+// rule is independent of the study's oracle.
+//
+// MedianStopping learns of completed runs through
+// diviner.CompletionObserver; it compares runs only with those
+// completed by the current runner.
+//
+// [1] Golovin et al., "Google Vizier: A Service for Black-Box
+// Optimization", KDD 2017
+type MedianStopping struct {
+	// MinStep is the step before which runs are never stopped.
+	// Defaults to 1.
+	MinStep int
+	// MinRuns is the minimum number of completed runs with which a
+	// run must be compared at a step before it may be stopped.
+	// Defaults to 3.
+	MinRuns int
+
+	mu sync.Mutex
+	// completed stores, for each completed run, its objective values
+	// (oriented so that larger is better) in step order.
+	completed map[string][]stepValue
+}
+
+type stepValue struct {
+	step  int
+	value float64
+}
+
+// Stop implements diviner.Scheduler.
+func (m *MedianStopping) Stop(objective diviner.Objective, id string, history []diviner.MetricsStep) bool {
+	values := orient(objective, history)
+	if len(values) == 0 {
+		return false
+	}
+	step := history[len(history)-1].Step
+	minStep, minRuns := m.params()
+	if step < minStep {
+		return false
+	}
+	best := math.Inf(-1)
+	for _, v := range values {
+		best = math.Max(best, v.value)
+	}
+	m.mu.Lock()
+	var averages []float64
+	for other, values := range m.completed {
+		if other == id || values[len(values)-1].step < step {
+			continue
+		}
+		var sum float64
+		var n int
+		for _, v := range values {
+			if v.step > step {
+				break
+			}
+			sum += v.value
+			n++
+		}
+		if n > 0 {
+			averages = append(averages, sum/float64(n))
+		}
+	}
+	m.mu.Unlock()
+	if len(averages) < minRuns {
+		return false
+	}
+	return best < median(averages)
+}
+
+// Completed implements diviner.CompletionObserver.
+func (m *MedianStopping) Completed(objective diviner.Objective, id string, history []diviner.MetricsStep) {
+	values := orient(objective, history)
+	if len(values) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.completed == nil {
+		m.completed = make(map[string][]stepValue)
+	}
+	m.completed[id] = values
+}
+
+func (m *MedianStopping) params() (minStep, minRuns int) {
+	minStep, minRuns = m.MinStep, m.MinRuns
+	if minStep <= 0 {
+		minStep = 1
+	}
+	if minRuns <= 0 {
+		minRuns = defaultMinRuns
+	}
+	return
+}
+
+// Orient returns the objective values in the provided history,
+// oriented so that larger values are better, in step order. Steps
+// that do not report the objective, or that report NaN, are omitted.
+func orient(objective diviner.Objective, history []diviner.MetricsStep) []stepValue {
+	var values []stepValue
+	for _, h := range history {
+		v, ok := h.Metrics[objective.Metric]
+		if !ok || math.IsNaN(v) {
+			continue
+		}
+		if objective.Direction == diviner.Minimize {
+			v = -v
+		}
+		values = append(values, stepValue{h.Step, v})
+	}
+	sort.SliceStable(values, func(i, j int) bool { return values[i].step < values[j].step })
+	return values
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
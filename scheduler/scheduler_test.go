@@ -0,0 +1,100 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package scheduler_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/scheduler"
+)
+
+var objective = diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+
+func history(losses ...float64) []diviner.MetricsStep {
+	h := make([]diviner.MetricsStep, len(losses))
+	for i, loss := range losses {
+		h[i] = diviner.MetricsStep{Step: i + 1, Metrics: diviner.Metrics{"loss": loss}}
+	}
+	return h
+}
+
+func TestASHA(t *testing.T) {
+	asha := &scheduler.ASHA{MinStep: 1, MaxStep: 9, Eta: 3}
+	// The first eta runs are never stopped at the first rung.
+	for i, loss := range []float64{0.5, 0.4, 0.6} {
+		if asha.Stop(objective, fmt.Sprint(i), history(loss)) {
+			t.Errorf("run %d stopped", i)
+		}
+	}
+	if !asha.Stop(objective, "3", history(0.7)) {
+		t.Error("bad run not stopped")
+	}
+	if asha.Stop(objective, "4", history(0.1)) {
+		t.Error("good run stopped")
+	}
+	// Decisions are made only when reaching rungs.
+	if asha.Stop(objective, "4", history(0.1, 0.9)) {
+		t.Error("run stopped between rungs")
+	}
+	// Runs that reach MaxStep are not stopped.
+	if asha.Stop(objective, "1", history(0.4, 0.4, 0.4, 0.4, 0.4, 0.4, 0.4, 0.4, 0.4)) {
+		t.Error("run stopped at max step")
+	}
+	// Runs that do not report the objective are not stopped.
+	if asha.Stop(objective, "5", []diviner.MetricsStep{{Step: 1, Metrics: diviner.Metrics{"acc": 0}}}) {
+		t.Error("run stopped without objective")
+	}
+}
+
+func TestHyperband(t *testing.T) {
+	hb := &scheduler.Hyperband{MinStep: 1, MaxStep: 27, Eta: 3}
+	var stopped int
+	for i := 0; i < 100; i++ {
+		if hb.Stop(objective, fmt.Sprint(i), history(float64(i))) {
+			stopped++
+		}
+	}
+	// Only runs in the most aggressive bracket are evaluated at step 1.
+	if stopped == 0 || stopped > 50 {
+		t.Errorf("unexpected number of stopped runs %d", stopped)
+	}
+}
+
+func TestMedianStopping(t *testing.T) {
+	m := &scheduler.MedianStopping{MinStep: 2, MinRuns: 3}
+	// Until enough runs have completed, no runs are stopped.
+	if m.Stop(objective, "bad", history(0.9, 0.9)) {
+		t.Error("run stopped without completed runs")
+	}
+	m.Completed(objective, "0", history(0.5, 0.4, 0.3))
+	m.Completed(objective, "1", history(0.6, 0.5, 0.4))
+	if m.Stop(objective, "bad", history(0.9, 0.9)) {
+		t.Error("run stopped with too few completed runs")
+	}
+	m.Completed(objective, "2", history(0.7, 0.6, 0.5))
+	// The running averages of the completed runs at step 2 are 0.45,
+	// 0.55, and 0.65.
+	if !m.Stop(objective, "bad", history(0.9, 0.6)) {
+		t.Error("bad run not stopped")
+	}
+	if m.Stop(objective, "good", history(0.9, 0.5)) {
+		t.Error("good run stopped")
+	}
+	// Runs are not stopped before MinStep.
+	if m.Stop(objective, "early", history(0.9)) {
+		t.Error("run stopped before min step")
+	}
+	// Runs are compared only with completed runs that reached the
+	// same step.
+	if m.Stop(objective, "long", history(0.9, 0.9, 0.9, 0.9)) {
+		t.Error("run stopped without comparable runs")
+	}
+	// Runs that do not report the objective are not stopped.
+	if m.Stop(objective, "none", []diviner.MetricsStep{{Step: 2, Metrics: diviner.Metrics{"acc": 0}}}) {
+		t.Error("run stopped without objective")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package script
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/bigmachine"
+	"github.com/grailbio/bigmachine/ec2system/instances"
+	"github.com/grailbio/diviner"
+)
+
+const (
+	// defaultEC2InstanceType is the instance type used by ec2system
+	// when none is specified.
+	defaultEC2InstanceType = "m3.medium"
+	// defaultEC2Region is the region used by ec2system when none is
+	// specified.
+	defaultEC2Region = "us-west-2"
+)
+
+// ec2GPUs stores the number of GPUs provided by EC2 GPU instance
+// types. (Bigmachine's instance table does not include them.)
+var ec2GPUs = map[string]int{
+	"g2.2xlarge":    1,
+	"g2.8xlarge":    4,
+	"g3s.xlarge":    1,
+	"g3.4xlarge":    1,
+	"g3.8xlarge":    2,
+	"g3.16xlarge":   4,
+	"g4dn.xlarge":   1,
+	"g4dn.2xlarge":  1,
+	"g4dn.4xlarge":  1,
+	"g4dn.8xlarge":  1,
+	"g4dn.12xlarge": 4,
+	"g4dn.16xlarge": 1,
+	"p2.xlarge":     1,
+	"p2.8xlarge":    8,
+	"p2.16xlarge":   16,
+	"p3.2xlarge":    1,
+	"p3.8xlarge":    4,
+	"p3.16xlarge":   8,
+	"p3dn.24xlarge": 8,
+}
+
+// ec2Resources returns the resources provided by an EC2 instance
+// type.
+func ec2Resources(typ instances.Type) diviner.Resources {
+	return diviner.Resources{
+		CPU:    int(typ.VCPU),
+		Memory: data.Size(typ.Memory * float64(data.GiB)),
+		GPU:    ec2GPUs[typ.Name],
+	}
+}
+
+// lookupEC2Instance returns the instance type with the provided name.
+func lookupEC2Instance(name string) (instances.Type, bool) {
+	for _, typ := range instances.Types {
+		if typ.Name == name {
+			return typ, true
+		}
+	}
+	return instances.Type{}, false
+}
+
+func (s *ec2System) region() string {
+	if s.Region == "" {
+		return defaultEC2Region
+	}
+	return s.Region
+}
+
+// Machine implements diviner.MachineDescriber.
+func (s *ec2System) Machine() (machineType, region string) {
+	machineType = s.InstanceType
+	if machineType == "" {
+		machineType = defaultEC2InstanceType
+	}
+	return machineType, s.region()
+}
+
+// Configure implements diviner.Configurer. It returns a system that
+// uses the cheapest current-generation instance type (by on-demand
+// price in the system's region) that satisfies the required
+// resources.
+func (s *ec2System) Configure(need diviner.Resources) (bigmachine.System, diviner.Resources, error) {
+	var (
+		best  instances.Type
+		price = math.Inf(1)
+	)
+	for _, typ := range instances.Types {
+		p, ok := typ.Price[s.region()]
+		if !ok || typ.Generation != "current" || !ec2Resources(typ).Satisfies(need) {
+			continue
+		}
+		if p < price || (p == price && typ.Name < best.Name) {
+			best, price = typ, p
+		}
+	}
+	if math.IsInf(price, 1) {
+		return nil, diviner.Resources{}, fmt.Errorf("no instance type in region %s satisfies %s", s.region(), need)
+	}
+	return &ec2System{
+		OnDemand:        s.OnDemand,
+		InstanceType:    best.Name,
+		AMI:             s.AMI,
+		Flavor:          s.Flavor,
+		Region:          s.Region,
+		SecurityGroup:   s.SecurityGroup,
+		Diskspace:       s.Diskspace,
+		Dataspace:       s.Dataspace,
+		InstanceProfile: s.InstanceProfile,
+	}, ec2Resources(best), nil
+}
@@ -11,10 +11,42 @@
 //
 //	discrete(v1, v2, v3...)
 //		Defines a discrete parameter that takes on the provided set
-//		set of values (types string, float, or int).
+//		set of values (types string, float, int, bool, duration, or
+//		time). If weights (a list of non-negative numbers, one for
+//		each value) are given, as in discrete("adam", "sgd",
+//		weights=[3, 1]), random and Bayesian oracles sample values in
+//		proportion to their weights; grid search ignores them.
 //
-//	range(beg, end)
+//	enum(name1, name2, name3...)
+//		Defines an enum parameter that takes on one of the provided
+//		(string) names, which are ordered: unlike discrete, oracles
+//		that model parameter values (e.g., gp and cmaes) model enum
+//		values by their positions, so that in enum("small", "medium",
+//		"large"), "medium" lies between "small" and "large".
+//
+//	range(beg, end, step?)
 //		Defines a range parameter with the given range. (Integers or floats.)
+//		If a step is given, the parameter takes on only the values beg,
+//		beg+step, beg+2*step, etc.
+//
+//	log_range(beg, end)
+//		Defines a range parameter whose values are sampled uniformly in
+//		log space, as is appropriate for, e.g., learning rates. (Positive
+//		integers or floats.)
+//
+//	vector(p1, p2, p3...)
+//		Defines a vector parameter whose values are lists, each element
+//		of which is drawn from the corresponding parameter. For example,
+//		vector(*[discrete(32, 64, 128)]*3) defines the widths of
+//		a three-layer network.
+//
+//	conditional(param, on, v1, v2...)
+//		Defines a parameter that takes on the values of param, but that
+//		exists only when the parameter named on (string) takes on one of
+//		the provided values. For example, conditional(range(0.0, 1.0),
+//		"optimizer", "sgd") defines a momentum parameter that is used
+//		only with the SGD optimizer. Inactive parameters are omitted
+//		from the values passed to a study's run function.
 //
 //	minimize(metric)
 //		Defines an objective that minimizes a metric (string).
@@ -28,32 +60,108 @@
 //		that run on this system simultaneously.  If parallelism is unset, it
 //		defaults to ∞.
 //
-//	ec2system(name, ami, instance_profile, instance_type, disk_space?, data_space?, on_demand?, flavor?)
+//	ec2system(name, ami, instance_profile, instance_type?, disk_space?, data_space?, on_demand?, flavor?)
 //		Defines a new EC2-based system of the given name, and configuration.
 //		The provided name is used to identify the system in tools.
 //		- ami:              the EC2 AMI to use when launching new instances;
 //		- instance_profile: the IAM instance profile assigned to new instances;
-//		- instance_type:    the instance type used;
+//		- instance_type:    the instance type used (default "m3.medium"). Runs
+//		                    requiring more resources than the instance type
+//		                    provides use instead the cheapest instance type
+//		                    that satisfies them;
 //		- disk_space:       the amount of root disk space created;
 //		- data_space:       the amount of data/scratch space created;
 //		- on_demand:        (bool) whether to launch on-demand instance types;
+//		                    otherwise spot instances are launched, and runs
+//		                    whose instances are reclaimed are marked
+//		                    preempted and rescheduled;
 //		- flavor:           the flavor of AMI: "ubuntu" or "coreos".
 //		See package github.com/grailbio/bigmachine/ec2system for more details on these
 //		parameters.
 //
-//	dataset(name, system, if_not_exist?, local_files?, script)
-//		Defines a dataset (diviner.Dataset):
+//	k8ssystem(name, image, namespace?, context?, node_selector?, service_account?, parallelism?, kubectl?)
+//		Defines a new system of the given name that runs scripts as
+//		Kubernetes jobs, through kubectl. The provided name is used to
+//		identify the system in tools.
+//		- image:           the container image in which scripts are run;
+//		- namespace:       the namespace in which jobs are created;
+//		- context:         the kubectl context used to access the cluster;
+//		- node_selector:   a dict of node labels constraining the nodes on
+//		                   which jobs are scheduled;
+//		- service_account: the service account under which jobs are run;
+//		- parallelism:     the maximum number of jobs run simultaneously
+//		                   (default ∞);
+//		- kubectl:         the path of the kubectl binary.
+//		The resources required by runs are requested from the cluster.
+//		See package github.com/grailbio/diviner/kubernetes for more details.
+//
+//	batchsystem(name, job_queue, image?, region?, job_role?, log_group?, parallelism?)
+//		Defines a new system of the given name that runs scripts as AWS
+//		Batch jobs. The provided name is used to identify the system in
+//		tools.
+//		- job_queue:   the name or ARN of the job queue to which jobs are
+//		               submitted;
+//		- image:       the container image in which scripts are run,
+//		               unless overridden by a run's image;
+//		- region:      the AWS region of the job queue;
+//		- job_role:    the ARN of the IAM role assumed by jobs;
+//		- log_group:   the CloudWatch log group of jobs' logs
+//		               (default "/aws/batch/job");
+//		- parallelism: the maximum number of jobs run simultaneously
+//		               (default ∞).
+//		The resources required by runs are requested from AWS Batch.
+//		See package github.com/grailbio/diviner/awsbatch for more details.
+//
+//	slurmsystem(name, dir?, partition?, account?, directives?, parallelism?, bin?, keep?)
+//		Defines a new system of the given name that runs scripts as
+//		Slurm batch jobs, through sbatch. The provided name is used to
+//		identify the system in tools.
+//		- dir:         the directory in which job directories are
+//		               created; it must be shared with the cluster's
+//		               compute nodes (default $HOME/.diviner/slurm);
+//		- partition:   the partition to which jobs are submitted;
+//		- account:     the account charged for jobs' resources;
+//		- directives:  a Go text/template of the #SBATCH directives that
+//		               request a job's resources, executed with the
+//		               fields Name, CPU, Memory (in MiB), and GPU;
+//		- parallelism: the maximum number of jobs run simultaneously
+//		               (default ∞);
+//		- bin:         the directory containing Slurm's tools;
+//		- keep:        (bool) whether to retain job directories.
+//		See package github.com/grailbio/diviner/slurm for more details.
+//
+//	localexec(name, parallelism?, dir?, keep?)
+//		Defines a new system of the given name that runs scripts as
+//		subprocesses of the diviner process, without bigmachine. It is
+//		useful for small studies and for debugging.
+//		- parallelism: the maximum number of scripts run simultaneously
+//		               (default: the number of CPUs);
+//		- dir:         the directory in which the scripts' working
+//		               directories are created (default: the system's
+//		               temporary directory);
+//		- keep:        (bool) whether to keep working directories after
+//		               their scripts complete.
+//		See package github.com/grailbio/diviner/localexec for more details.
+//
+//	dataset(name, system, if_not_exist?, local_files?, inputs?, script)
+//		Defines a dataset (diviner.Dataset). A dataset is produced once
+//		for each version of its script, local files, and inputs; its
+//		completion is recorded in the database:
 //		- name:         the name of the dataset, which must be unique;
 //		- system:       the system(s) to be used for run execution. The value is either
 //                    a single system or a list of systems. In the latter case,
 //                    the run will use any one of systems can allocate resources.
 //		- if_not_exist: a URL that is checked for conditional execution;
-//		                dataset invocations are de-duped based on this URL.
+//		                the dataset is assumed to exist if the URL exists,
+//		                unless its definition has changed since it was
+//		                produced.
 //		- local_files:  a list of local files that must be made available
 // 		                in the script's execution environment;
+//		- inputs:       a list of URLs of the dataset's inputs; the dataset
+//		                is produced again if they change;
 //		- script:       the script that is run to produce the dataset.
 //
-//	run_config(script, system, local_files?, datasets?)
+//	run_config(script, system, local_files?, datasets?, retry?, resources?, checkpoint?, timeout?, image?, env?, seed?)
 //		Defines a run config (diviner.RunConfig) representing a single
 //		trial:
 //		- script:      the script that is executed for this trial;
@@ -63,13 +171,94 @@
 //		- local_files: a list of local files that must be made available
 //		               in the script's execution environment;
 //		- datasets:    a list of datasets that must be available before
-//		               the trial can proceed.
+//		               the trial can proceed;
+//		- retry:       the policy used to retry failed runs, as defined by
+//		               retry;
+//		- resources:   the resources required by the run, as defined by
+//		               resources;
+//		- checkpoint:  the checkpoint from which interrupted runs are
+//		               resumed, as defined by checkpoint;
+//		- timeout:     the maximum duration of the trial's script, as a
+//		               duration string (e.g., "2h"); runs that exceed it
+//		               are killed, and marked as timed out;
+//		- image:       the container image (e.g., "pytorch/pytorch:latest")
+//		               in which the trial's script is run; the image is
+//		               pulled onto the worker machine as needed;
+//		- env:         a dictionary of environment variables, and their
+//		               (string) values, that are exported to the trial's
+//		               script, e.g., env={"LEARNING_RATE": str(values["lr"])};
+//		               names beginning with "DIVINER" are reserved;
+//		- seed:        a fixed seed for the trial's random number generators,
+//		               a positive integer less than 2**31 (e.g.,
+//		               seed=replicate+1); by default, each run is given a
+//		               random seed. The seed is exported to the trial's
+//		               script in the environment variable DIVINER_SEED.
 //
-//	study(name, params, objective, run, replicates?, oracle?)
+//	resources(cpu?, memory?, gpu?)
+//		Defines the resources (diviner.Resources) required by a run:
+//		- cpu:    the number of (virtual) CPUs;
+//		- memory: the amount of memory, in GiB;
+//		- gpu:    the number of GPUs.
+//		Runs are performed only on systems whose machines provide the
+//		required resources; EC2 systems select an instance type
+//		accordingly.
+//
+//	retry(max_attempts, backoff?, max_backoff?, retryable?, retry_timeouts?, resource_scale?, max_preemptions?)
+//		Defines a retry policy (diviner.RetryPolicy) for run configs.
+//		Failed runs are retried as new runs, linked to the original:
+//		- max_attempts: the maximum number of attempts made for each run,
+//		                including the first;
+//		- backoff:      the delay before the first retry, as a duration
+//		                string (e.g., "30s"); it is doubled with each
+//		                subsequent retry (default "10s");
+//		- max_backoff:  the maximum delay between retries;
+//		- retryable:    a list of regular expressions matching the errors
+//		                of run failures that should be retried. Failures
+//		                due to machine or dataset errors are always
+//		                retried;
+//		- retry_timeouts: whether runs that exceed their timeout are
+//		                retried (default False);
+//		- resource_scale: the factor by which the resources of runs
+//		                that timed out are scaled for their retries
+//		                (e.g., 2 doubles the required CPUs, memory, and
+//		                GPUs);
+//		- max_preemptions: the maximum number of times a run is
+//		                rescheduled after its machine is preempted
+//		                (default 5; negative disables rescheduling).
+//		                Preemptions do not count against max_attempts.
+//
+//	checkpoint(path, url, interval?)
+//		Defines a checkpoint (diviner.Checkpoint) for run configs. The
+//		runner periodically saves the run's checkpoint file, and restores
+//		it when the run is restarted after a timeout or retried:
+//		- path:     the path of the checkpoint file, relative to the
+//		            script's working directory;
+//		- url:      the URL of the directory in which checkpoints are
+//		            stored (e.g., "s3://bucket/checkpoints", or gs:// and
+//		            az:// URLs for GCS and Azure Blob Storage);
+//		- interval: the interval at which the checkpoint is saved, as a
+//		            duration string (default "10m").
+//		Scripts find the location of their checkpoint in the environment
+//		variable DIVINER_CHECKPOINT_URL. Scripts run on bigmachine
+//		systems also find a cache directory in DIVINER_CACHE, which is
+//		retained when the machine is reused by later runs.
+//
+//	fidelity(name, min, max)
+//		Defines a fidelity (diviner.Fidelity): a study's budget knob,
+//		such as a number of training epochs, which multi-fidelity
+//		oracles (e.g., halving) set for each trial separately from its
+//		parameter values. Each run receives its fidelity among its
+//		values, under the provided name. Fidelities range from min to
+//		max (which is full fidelity); they are integers if min and max
+//		are.
+//
+//	study(name, params, objective, run, replicates?, oracle?, scheduler?, transfer?, timeout?, notify?, stop?, fidelity?, max_parallel?, aggregate?, constraints?)
 //		A toplevel function that declares a named study with the provided
 //		parameters, runner, and objectives.
 //		- name:       a string specifying the name of the study;
-//		- objective:  the optimization objective;
+//		- objective:  the optimization objective, or a list of objectives
+//		              for multi-objective studies; the first objective is
+//		              used by oracles and schedulers that support only one;
 //		- params:     a dictionary with naming a set of parameters
 //		              to be optimized;
 //		- run:        a function that returns a run_config for a set
@@ -83,10 +272,211 @@
 // 		              combination.
 //    - description:an optional string describing the study.
 //		- oracle:     the oracle to use (grid search by default).
+//		- scheduler:  an early-stopping scheduler (e.g., asha, hyperband,
+//		              or median_stopping)
+//		              that may stop underperforming runs early.
+//		- transfer:   a list of previous studies (or their names) whose
+//		              successful trials are used to warm-start the oracle;
+//		              only trials whose values are valid for all of the
+//		              study's parameters are used.
+//		- timeout:    the default timeout of the study's runs, as a
+//		              duration string; see run_config.
+//		- notify:     a list of notifiers (e.g., slack or webhook) that
+//		              are notified of the study's events.
+//		- stop:       the conditions under which the study is stopped
+//		              before its oracle is exhausted; see stop_when.
+//		- fidelity:   the study's fidelity, as defined by fidelity;
+//		              oracles that do not set fidelities run every
+//		              trial at full fidelity.
+//		- max_parallel: the maximum number of the study's runs that are
+//		              performed concurrently, independently of the
+//		              parallelism of their systems.
+//		- aggregate:  a dictionary mapping metric names to the policies
+//		              by which the values reported by each run are
+//		              aggregated into the run's value of the metric:
+//		              "last" (the default), "max", "min", or "mean",
+//		              optionally restricted to the final k values
+//		              (e.g., "mean:5"). For example, {"acc": "max"}
+//		              scores runs by their best-so-far accuracy.
+//		- constraints: a list of feasibility conditions on the trials'
+//		              metrics, each comparing a metric with a number
+//		              (e.g., "latency_ms < 50" or "params <= 10M").
+//		              Trials that violate them are infeasible: they are
+//		              never the study's best trials, and oracles that
+//		              support constraints (gp, nsga2, vizier) steer
+//		              away from them.
+//
+//	stop_when(target?, max_trials?, max_duration?, max_runtime?, patience?)
+//		Conditions under which a study is stopped; the study stops when
+//		any of them is met.
+//		- target:       an objective value; the study stops once a run
+//		                attains a value at least as good;
+//		- max_trials:   the number of trials (sets of parameter values)
+//		                after whose completion the study stops;
+//		- max_duration: the wall-clock duration, as a duration string,
+//		                after which the study stops, measured from the
+//		                creation of its first run;
+//		- max_runtime:  the total runtime of the study's runs, as a
+//		                duration string, after which the study stops;
+//		- patience:     the number of successful runs without an
+//		                improvement in the objective after which the
+//		                study stops.
+//
+//	slack(url, events?)
+//		A notifier that posts messages describing a study's events to a
+//		Slack incoming webhook.
+//		- url:    the URL of the incoming webhook;
+//		- events: a list of the events to post, among "run_started",
+//		          "run_failed", "run_completed", "study_finished", and
+//		          "new_best" (default: run_failed, study_finished, and
+//		          new_best).
+//
+//	webhook(url, events?, headers?)
+//		A notifier that posts a JSON object describing each of a
+//		study's events to a URL.
+//		- url:     the URL to which events are posted;
+//		- events:  a list of the events to post, as in slack (default:
+//		           all events);
+//		- headers: a dictionary of additional HTTP headers to include
+//		           in each request.
+//
+//	asha(min_step?, max_step?, eta?)
+//		An early-stopping scheduler implementing asynchronous successive
+//		halving. Runs report their progress by tagging metrics with a
+//		step (e.g., "METRICS: step=3,acc=0.5"); runs are compared at
+//		steps min_step, min_step*eta, min_step*eta^2, ... (below max_step,
+//		if given), and only the top 1/eta of runs continue past each.
+//		By default, min_step is 1 and eta is 3.
+//
+//	hyperband(min_step?, max_step, eta?, brackets?)
+//		An early-stopping scheduler implementing asynchronous Hyperband:
+//		runs are divided among a number of asha brackets, each
+//		starting at a successively later step.
+//
+//	median_stopping(min_step?, min_runs?)
+//		An early-stopping scheduler implementing the median stopping
+//		rule: a run is stopped at a step if its best objective value so
+//		far is worse than the median of the running averages of the
+//		study's completed runs at the same step. Runs are not stopped
+//		before min_step (default 1), nor before they can be compared
+//		with at least min_runs (default 3) completed runs.
 //
 //	grid_search
 //		The grid search oracle
 //
+//	random_search(n, seed?)
+//		An oracle that samples n trials uniformly at random from the
+//		study's parameters. Trials are reproducible for a given seed
+//		(default 0).
+//
+//	halton(n?, seed?, scramble?)
+//		A quasi-random oracle that samples trials from a Halton
+//		sequence, which covers the study's parameters more evenly than
+//		random_search; it is well suited for initial exploration.
+//		- n:        the number of trials to sample; if omitted, trials
+//		            are sampled indefinitely;
+//		- seed:     the seed that determines the sequence's scrambling
+//		            (default 0);
+//		- scramble: whether the sequence is scrambled (default True);
+//		            unscrambled sequences are correlated across many
+//		            parameters.
+//
+//	halving(n?, eta?, seed?)
+//		A multi-fidelity oracle implementing successive halving, for
+//		studies with a fidelity. Trials are sampled at random and run
+//		at the minimum fidelity; the best 1/eta of the trials run at
+//		each fidelity are promoted to a fidelity eta times larger,
+//		up to full fidelity. Studies using halving are best run in
+//		rounds.
+//		- n:    the number of trials sampled at the minimum fidelity;
+//		        if omitted, trials are sampled indefinitely;
+//		- eta:  the factor by which trials are reduced, and fidelities
+//		        increased, at each step (default 3);
+//		- seed: the random seed used to sample trials (default 0).
+//
+//	gp(seed?, n_initial_points?, n_candidates?, xi?)
+//		A Bayesian optimization oracle based on Gaussian processes,
+//		implemented natively by diviner. It supports batches of
+//		suggestions, from which duplicate trials are omitted.
+//		- seed:             the random seed used by the oracle (default 0);
+//		- n_initial_points: number of random trials to perform before
+//		                    modeling the objective (default 5);
+//		- n_candidates:     number of random candidate points over which
+//		                    expected improvement is maximized (default 1000);
+//		- xi:               the exploration parameter of the expected
+//		                    improvement acquisition (default 0.01).
+//
+//	nsga2(seed?, population_size?, mutation_rate?)
+//		A multi-objective oracle implementing the NSGA-II genetic
+//		algorithm, which explores the Pareto front of a study's
+//		objectives. New trials are bred from the best trials, ranked
+//		by Pareto front and crowding distance.
+//		- seed:            the random seed used by the oracle (default 0);
+//		- population_size: the number of trials from which new trials
+//		                   are bred (default 20);
+//		- mutation_rate:   the probability with which each parameter
+//		                   value of a new trial is resampled (default
+//		                   1/number of parameters).
+//
+//	genetic(seed?, population_size?, tournament_size?, crossover_rate?, mutation_rate?, mutation_scale?)
+//		A single-objective oracle implementing a genetic algorithm,
+//		which is robust to rugged, non-smooth objectives. New trials
+//		are bred by uniform crossover from parents chosen from the
+//		best trials by tournament selection, and then mutated:
+//		range values are perturbed by Gaussian noise, and other
+//		values are resampled.
+//		- seed:            the random seed used by the oracle (default 0);
+//		- population_size: the number of trials from which new trials
+//		                   are bred (default 20);
+//		- tournament_size: the number of trials that compete to be
+//		                   selected as a parent (default 2);
+//		- crossover_rate:  the probability with which a new trial is
+//		                   bred from two parents rather than copied
+//		                   from one (default 0.9);
+//		- mutation_rate:   the probability with which each parameter
+//		                   value of a new trial is mutated (default
+//		                   1/number of parameters);
+//		- mutation_scale:  the standard deviation of the mutations of
+//		                   range values, relative to the width of the
+//		                   range (default 0.1).
+//
+//	cmaes(seed?, population_size?, sigma?, restart?, max_restarts?)
+//		An oracle implementing the CMA-ES evolution strategy, for
+//		studies whose parameters are (integer or real) ranges or
+//		enums. Trials are sampled in generations from a normal
+//		distribution that adapts to the objective; studies using cmaes
+//		should be run in rounds of the population size.
+//		- seed:            the random seed used by the oracle (default 0);
+//		- population_size: the number of trials in each generation
+//		                   (default: the larger of 4+3*ln(number of
+//		                   parameters) and the study's parallelism);
+//		- sigma:           the initial step size, relative to the
+//		                   parameters' ranges (default 0.3);
+//		- restart:         the strategy by which the search restarts
+//		                   once converged: "ipop" (doubling the
+//		                   population size), "random", or "none"
+//		                   (default "ipop");
+//		- max_restarts:    the maximum number of restarts (default 9).
+//
+//	pbt(population, interval?, quantile?, resample_probability?, seed?)
+//		An oracle implementing population based training. A random
+//		population of trials is trained concurrently; poorly performing
+//		trials periodically continue from the checkpoints of better
+//		performing ones, with perturbed parameter values. Studies using
+//		pbt should run all of the population's trials in parallel, and
+//		should be checkpointed.
+//		- population:           the number of trials in the population;
+//		- interval:             the number of metrics reports between
+//		                        successive exploit decisions (default 1);
+//		- quantile:             the fraction of the population that
+//		                        exploits, and is exploited by, the rest
+//		                        (default 0.25);
+//		- resample_probability: the probability with which each
+//		                        explored value is resampled instead of
+//		                        perturbed (default 0.25);
+//		- seed:                 the random seed used by the oracle
+//		                        (default 0).
+//
 //	skopt(base_estimator?, n_initial_points?, acq_func?, acq_optimizer?)
 //		A Bayesian optimization oracle based on skopt. The arguments
 //		are as in skopt.Optimizer, documented at
@@ -102,6 +492,35 @@
 //		                    one of "sampling", "lgbfs" (by default it is automatically
 //		                    selected).
 //
+//	vizier(addr, study?, algorithm?, timeout?)
+//		An oracle that requests trials from an external suggestion
+//		service, modeled on the OSS Vizier API, so that optimizers
+//		implemented in other languages (e.g., Python's Ax, Optuna, or
+//		Nevergrad) may be used. See package
+//		github.com/grailbio/diviner/vizier for the service's protocol.
+//		- addr:      the address (host:port) of the service;
+//		- study:     the name of the study presented to the service
+//		             (default "diviner");
+//		- algorithm: the name of the algorithm that the service should
+//		             use; its interpretation is up to the service;
+//		- timeout:   the maximum duration of each request, e.g., "30s"
+//		             (default 5 minutes).
+//
+//	wandb_sweep(entity, project, sweep, url?, timeout?)
+//		An oracle that consumes suggestions from a Weights & Biases
+//		sweep controller, acting as an agent of the sweep: trials are
+//		logged as the sweep's runs, whose results are reported back
+//		to the controller. See package github.com/grailbio/diviner/wandb.
+//		Requests are authorized by $WANDB_API_KEY.
+//		- entity:  the user or team that owns the sweep's project;
+//		- project: the sweep's project;
+//		- sweep:   the ID of the sweep, whose parameters must match
+//		           the study's (see "diviner wandb sweep");
+//		- url:     the base URL of the W&B service (default
+//		           "https://api.wandb.ai");
+//		- timeout: the maximum duration to wait for the controller's
+//		           suggestions, e.g., "10m" (default 5 minutes).
+//
 //  	command(script, interpreter?="bash -c", strip?=False)
 //		Run a subprocess and return its standard output as a string.
 //		- script: the script to run; a string.
@@ -119,11 +538,37 @@
 //		Internal representation of a protocol buffer enumeration value.
 //		(See to_proto).
 //
+//	duration(str)
+//		A duration parameter value, parsed from a string such as "30m"
+//		or "1h30m". Durations may be given to discrete parameters, so
+//		that schedule-style parameters need not be encoded as integers.
+//		A duration is formatted as in str(duration("90m")) == "1h30m0s",
+//		and its seconds attribute is its (integer) number of seconds.
+//
+//	time(str)
+//		A time parameter value, parsed from an RFC 3339 timestamp such
+//		as "2019-06-01T12:00:00Z", or a date such as "2019-06-01".
+//		Times are formatted in UTC in the RFC 3339 format, and their
+//		unix attribute is their Unix time in seconds.
+//
 //	to_proto(dict):
 //		Render a string-keyed dictionary to the text protocol buffer format.
 //		Dictionaries cannot currently be nested. Enumeration values as created
 //		by enum_value are rendered as protocol buffer enumeration, not strings.
 //
+//	template(text, values, id?, replicate?)
+//		Render a run script template (diviner.Template), in which
+//		{{name}} is replaced by values[name], quoted for Bash according
+//		to its kind, and {{id}} and {{replicate}} by the provided run ID
+//		and replicate number. For example:
+//		run=lambda values, id: run_config(
+//		    system=local,
+//		    script=template("python train.py --lr={{lr}} --run={{id}}", values, id=id))
+//
+//	quote(value)
+//		Render a value as Bash words, as in template; e.g.,
+//		quote("a b") == "'a b'", and quote([1, 2]) == "1 2".
+//
 //  panic(messages...)
 //    Print the messages and crash the process.
 //
@@ -131,6 +576,10 @@
 // Global starlark objects are frozen after initial evaluation to prevent functions
 // from modifying shared state.
 //
+// Simple studies may also be defined without Starlark, by YAML study
+// specifications in which studies, parameters, and systems are defined
+// by calls to the above builtins; see LoadYAML.
+//
 // [1] https://docs.bazel.build/versions/master/skylark/language.html
 package script
 
@@ -141,10 +590,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -152,11 +604,20 @@ import (
 	"encoding/gob"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grailbio/base/data"
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/bigmachine"
 	"github.com/grailbio/bigmachine/ec2system"
 	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/awsbatch"
+	"github.com/grailbio/diviner/kubernetes"
+	"github.com/grailbio/diviner/localexec"
+	"github.com/grailbio/diviner/notify"
 	"github.com/grailbio/diviner/oracle"
+	"github.com/grailbio/diviner/scheduler"
+	"github.com/grailbio/diviner/slurm"
+	"github.com/grailbio/diviner/vizier"
+	"github.com/grailbio/diviner/wandb"
 	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
 )
@@ -171,23 +632,55 @@ func init() {
 }
 
 var builtins = starlark.StringDict{
-	"discrete":    starlark.NewBuiltin("discrete", makeDiscrete),
-	"range":       starlark.NewBuiltin("range", makeRange),
-	"minimize":    starlark.NewBuiltin("minimize", makeObjective(diviner.Minimize)),
-	"maximize":    starlark.NewBuiltin("maximize", makeObjective(diviner.Maximize)),
-	"dataset":     starlark.NewBuiltin("dataset", makeDataset),
-	"run_config":  starlark.NewBuiltin("run_config", makeRunConfig),
-	"study":       starlark.NewBuiltin("study", makeStudy),
-	"grid_search": &oracleValue{&oracle.GridSearch{}},
-	"skopt":       starlark.NewBuiltin("skopt", makeSkopt),
-	"config":      starlark.NewBuiltin("config", makeConfig),
-	"localsystem": starlark.NewBuiltin("localsystem", makeLocalSystem),
-	"ec2system":   starlark.NewBuiltin("ec2system", makeEC2System),
-	"command":     starlark.NewBuiltin("command", makeCommand),
-	"temp_file":   starlark.NewBuiltin("temp_file", makeTempFile),
-	"enum_value":  starlark.NewBuiltin("enum_value", makeEnumValue),
-	"to_proto":    starlark.NewBuiltin("to_proto", makeToProto),
-	"panic":       starlark.NewBuiltin("panic", makePanic),
+	"discrete":        starlark.NewBuiltin("discrete", makeDiscrete),
+	"enum":            starlark.NewBuiltin("enum", makeEnum),
+	"range":           starlark.NewBuiltin("range", makeRange),
+	"log_range":       starlark.NewBuiltin("log_range", makeLogRange),
+	"vector":          starlark.NewBuiltin("vector", makeVector),
+	"conditional":     starlark.NewBuiltin("conditional", makeConditional),
+	"minimize":        starlark.NewBuiltin("minimize", makeObjective(diviner.Minimize)),
+	"maximize":        starlark.NewBuiltin("maximize", makeObjective(diviner.Maximize)),
+	"dataset":         starlark.NewBuiltin("dataset", makeDataset),
+	"run_config":      starlark.NewBuiltin("run_config", makeRunConfig),
+	"retry":           starlark.NewBuiltin("retry", makeRetry),
+	"resources":       starlark.NewBuiltin("resources", makeResources),
+	"checkpoint":      starlark.NewBuiltin("checkpoint", makeCheckpoint),
+	"fidelity":        starlark.NewBuiltin("fidelity", makeFidelity),
+	"study":           starlark.NewBuiltin("study", makeStudy),
+	"stop_when":       starlark.NewBuiltin("stop_when", makeStopWhen),
+	"grid_search":     &oracleValue{&oracle.GridSearch{}},
+	"skopt":           starlark.NewBuiltin("skopt", makeSkopt),
+	"vizier":          starlark.NewBuiltin("vizier", makeVizier),
+	"wandb_sweep":     starlark.NewBuiltin("wandb_sweep", makeWandbSweep),
+	"random_search":   starlark.NewBuiltin("random_search", makeRandomSearch),
+	"halton":          starlark.NewBuiltin("halton", makeHalton),
+	"halving":         starlark.NewBuiltin("halving", makeHalving),
+	"gp":              starlark.NewBuiltin("gp", makeGP),
+	"nsga2":           starlark.NewBuiltin("nsga2", makeNSGA2),
+	"genetic":         starlark.NewBuiltin("genetic", makeGenetic),
+	"cmaes":           starlark.NewBuiltin("cmaes", makeCMAES),
+	"pbt":             starlark.NewBuiltin("pbt", makePBT),
+	"asha":            starlark.NewBuiltin("asha", makeASHA),
+	"hyperband":       starlark.NewBuiltin("hyperband", makeHyperband),
+	"median_stopping": starlark.NewBuiltin("median_stopping", makeMedianStopping),
+	"slack":           starlark.NewBuiltin("slack", makeSlack),
+	"webhook":         starlark.NewBuiltin("webhook", makeWebhook),
+	"config":          starlark.NewBuiltin("config", makeConfig),
+	"localsystem":     starlark.NewBuiltin("localsystem", makeLocalSystem),
+	"ec2system":       starlark.NewBuiltin("ec2system", makeEC2System),
+	"k8ssystem":       starlark.NewBuiltin("k8ssystem", makeK8sSystem),
+	"batchsystem":     starlark.NewBuiltin("batchsystem", makeBatchSystem),
+	"slurmsystem":     starlark.NewBuiltin("slurmsystem", makeSlurmSystem),
+	"localexec":       starlark.NewBuiltin("localexec", makeLocalExec),
+	"command":         starlark.NewBuiltin("command", makeCommand),
+	"temp_file":       starlark.NewBuiltin("temp_file", makeTempFile),
+	"enum_value":      starlark.NewBuiltin("enum_value", makeEnumValue),
+	"duration":        starlark.NewBuiltin("duration", makeDuration),
+	"time":            starlark.NewBuiltin("time", makeTime),
+	"to_proto":        starlark.NewBuiltin("to_proto", makeToProto),
+	"template":        starlark.NewBuiltin("template", makeTemplate),
+	"quote":           starlark.NewBuiltin("quote", makeQuote),
+	"panic":           starlark.NewBuiltin("panic", makePanic),
 }
 
 func makeLoader(entrypoint string) func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
@@ -261,9 +754,50 @@ func (*oracleValue) Truth() starlark.Bool { return true }
 
 func (*oracleValue) Hash() (uint32, error) { return 0, errors.New("oracles not hashable") }
 
+type schedulerValue struct{ diviner.Scheduler }
+
+func (s *schedulerValue) String() string { return fmt.Sprint(s.Scheduler) }
+
+func (*schedulerValue) Type() string { return "scheduler" }
+
+func (*schedulerValue) Freeze() {}
+
+func (*schedulerValue) Truth() starlark.Bool { return true }
+
+func (*schedulerValue) Hash() (uint32, error) { return 0, errors.New("schedulers not hashable") }
+
+type stopValue struct{ diviner.StopConditions }
+
+func (*stopValue) Type() string { return "stop_when" }
+
+func (*stopValue) Freeze() {}
+
+func (*stopValue) Truth() starlark.Bool { return true }
+
+func (*stopValue) Hash() (uint32, error) { return 0, errors.New("stop conditions not hashable") }
+
+type notifierValue struct{ diviner.Notifier }
+
+func (n *notifierValue) String() string { return fmt.Sprint(n.Notifier) }
+
+func (*notifierValue) Type() string { return "notifier" }
+
+func (*notifierValue) Freeze() {}
+
+func (*notifierValue) Truth() starlark.Bool { return true }
+
+func (*notifierValue) Hash() (uint32, error) { return 0, errors.New("notifiers not hashable") }
+
 func makeDiscrete(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	if len(kwargs) != 0 {
-		return nil, errors.New("discrete does not accept any kwargs")
+	var weightList *starlark.List
+	for _, kv := range kwargs {
+		if name := string(kv[0].(starlark.String)); name != "weights" {
+			return nil, fmt.Errorf("discrete: unexpected keyword argument %s", name)
+		}
+		var ok bool
+		if weightList, ok = kv[1].(*starlark.List); !ok {
+			return nil, fmt.Errorf("discrete: weights must be a list, not %s", kv[1].Type())
+		}
 	}
 	if len(args) == 0 {
 		return nil, errors.New("discrete with empty list")
@@ -275,40 +809,164 @@ func makeDiscrete(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tu
 			return nil, fmt.Errorf("argument %s (%s) is not a valid diviner value", arg, arg.Type())
 		}
 	}
-	return diviner.NewDiscrete(vals...), nil
+	if weightList == nil {
+		return diviner.NewDiscrete(vals...), nil
+	}
+	if weightList.Len() != len(vals) {
+		return nil, fmt.Errorf("discrete: %d weights given for %d values", weightList.Len(), len(vals))
+	}
+	var (
+		weights = make([]float64, weightList.Len())
+		total   float64
+	)
+	for i := range weights {
+		w, ok := starlark.AsFloat(weightList.Index(i))
+		if !ok || w < 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+			return nil, fmt.Errorf("discrete: invalid weight %s", weightList.Index(i))
+		}
+		weights[i] = w
+		total += w
+	}
+	if total == 0 {
+		return nil, errors.New("discrete: weights are all zero")
+	}
+	return diviner.NewWeightedDiscrete(vals, weights), nil
+}
+
+func makeEnum(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) != 0 {
+		return nil, errors.New("enum does not accept any kwargs")
+	}
+	if len(args) == 0 {
+		return nil, errors.New("enum with no names")
+	}
+	var (
+		names = make([]string, len(args))
+		seen  = make(map[string]bool)
+	)
+	for i, arg := range args {
+		name, ok := arg.(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("enum name %s (%s) is not a string", arg, arg.Type())
+		}
+		if seen[string(name)] {
+			return nil, fmt.Errorf("enum name %s is repeated", name)
+		}
+		seen[string(name)] = true
+		names[i] = string(name)
+	}
+	return diviner.NewEnum(names...), nil
+}
+
+func makeVector(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) != 0 {
+		return nil, errors.New("vector does not accept any kwargs")
+	}
+	if len(args) == 0 {
+		return nil, errors.New("vector with no elements")
+	}
+	elems := make([]diviner.Param, len(args))
+	for i, arg := range args {
+		var ok bool
+		elems[i], ok = arg.(diviner.Param)
+		if !ok {
+			return nil, fmt.Errorf("argument %s (%s) is not a valid diviner parameter", arg, arg.Type())
+		}
+	}
+	return diviner.NewVector(elems...), nil
+}
+
+func makeConditional(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) != 0 {
+		return nil, errors.New("conditional does not accept any kwargs")
+	}
+	if len(args) < 3 {
+		return nil, errors.New("conditional requires a parameter, a parameter name, and at least one value")
+	}
+	param, ok := args[0].(diviner.Param)
+	if !ok {
+		return nil, fmt.Errorf("argument %s (%s) is not a valid diviner parameter", args[0], args[0].Type())
+	}
+	on, ok := args[1].(starlark.String)
+	if !ok {
+		return nil, fmt.Errorf("argument %s (%s) is not a parameter name", args[1], args[1].Type())
+	}
+	vals := make([]diviner.Value, len(args)-2)
+	for i, arg := range args[2:] {
+		vals[i] = starlark2diviner(arg)
+		if vals[i] == nil {
+			return nil, fmt.Errorf("argument %s (%s) is not a valid diviner value", arg, arg.Type())
+		}
+	}
+	return diviner.NewConditional(param, string(on), vals...), nil
 }
 
 func makeRange(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	if len(kwargs) != 0 {
 		return nil, errors.New("range does not accept any kwargs")
 	}
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("range requires two or three arguments")
+	}
+	vals, err := rangeValues("range", args)
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) == 2 {
+		return diviner.NewRange(vals[0], vals[1]), nil
+	}
+	if step := vals[2]; (step.Kind() == diviner.Integer && step.Int() <= 0) || (step.Kind() == diviner.Real && step.Float() <= 0) {
+		return nil, fmt.Errorf("range step %s is not positive", step)
+	}
+	return diviner.NewQuantizedRange(vals[0], vals[1], vals[2]), nil
+}
+
+func makeLogRange(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) != 0 {
+		return nil, errors.New("log_range does not accept any kwargs")
+	}
 	if len(args) != 2 {
-		return nil, errors.New("range requires two arguments")
+		return nil, errors.New("log_range requires two arguments")
+	}
+	vals, err := rangeValues("log_range", args)
+	if err != nil {
+		return nil, err
+	}
+	if beg := vals[0]; (beg.Kind() == diviner.Integer && beg.Int() <= 0) || (beg.Kind() == diviner.Real && beg.Float() <= 0) {
+		return nil, fmt.Errorf("log_range start %s is not positive", beg)
 	}
+	return diviner.NewLogRange(vals[0], vals[1]), nil
+}
+
+// rangeValues converts the arguments of the named range builtin to
+// diviner values. The arguments must all be ints or all be floats.
+func rangeValues(name string, args starlark.Tuple) ([]diviner.Value, error) {
+	vals := make([]diviner.Value, len(args))
 	switch beg := args[0].(type) {
 	case starlark.Int:
-		beg64, ok := beg.Int64()
-		if !ok {
-			return nil, fmt.Errorf("argument %s overflows int64", beg)
-		}
-		end, ok := args[1].(starlark.Int)
-		if !ok {
-			return nil, fmt.Errorf("argument mismatch: %s is int, %s is %s", beg, args[1], args[1].Type())
-		}
-		end64, ok := end.Int64()
-		if !ok {
-			return nil, fmt.Errorf("argument %s overflows int64", end)
+		for i, arg := range args {
+			v, ok := arg.(starlark.Int)
+			if !ok {
+				return nil, fmt.Errorf("argument mismatch: %s is int, %s is %s", beg, arg, arg.Type())
+			}
+			v64, ok := v.Int64()
+			if !ok {
+				return nil, fmt.Errorf("argument %s overflows int64", v)
+			}
+			vals[i] = diviner.Int(v64)
 		}
-		return diviner.NewRange(diviner.Int(beg64), diviner.Int(end64)), nil
 	case starlark.Float:
-		end, ok := args[1].(starlark.Float)
-		if !ok {
-			return nil, fmt.Errorf("argument mismatch: %s is float, %s is %s", beg, args[1], args[1].Type())
+		for i, arg := range args {
+			v, ok := arg.(starlark.Float)
+			if !ok {
+				return nil, fmt.Errorf("argument mismatch: %s is float, %s is %s", beg, arg, arg.Type())
+			}
+			vals[i] = diviner.Float(v)
 		}
-		return diviner.NewRange(diviner.Float(beg), diviner.Float(end)), nil
 	default:
-		return nil, fmt.Errorf("arguments %s, %s (types %s, %s) invalid for range", args[0], args[1], args[0].Type(), args[1].Type())
+		return nil, fmt.Errorf("arguments %s, %s (types %s, %s) invalid for %s", args[0], args[1], args[0].Type(), args[1].Type(), name)
 	}
+	return vals, nil
 }
 
 func coerceToFloat(v starlark.Value) (float64, bool) {
@@ -329,24 +987,84 @@ func coerceToFloat(v starlark.Value) (float64, bool) {
 func makeStudy(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var (
 		study  diviner.Study
-		oracle = new(oracleValue)
-		params = new(starlark.Dict)
+		oracle    = new(oracleValue)
+		scheduler = new(schedulerValue)
+		params    = new(starlark.Dict)
 		runner = new(starlark.Function)
+		objective starlark.Value
+		transfer  = new(starlark.List)
+		timeout   string
+		notifiers = new(starlark.List)
+		stop      = new(stopValue)
+		fidelity  = starlark.Value(starlark.None)
+		aggregate = new(starlark.Dict)
+		constraints = new(starlark.List)
 	)
 	err := starlark.UnpackArgs(
 		"study", args, kwargs,
 		"name", &study.Name,
 		"params", &params,
 		"run", &runner,
-		"objective", &study.Objective,
+		"objective", &objective,
 		"oracle?", &oracle,
+		"scheduler?", &scheduler,
 		"replicates?", &study.Replicates,
 		"description?", &study.Description,
+		"transfer?", &transfer,
+		"timeout?", &timeout,
+		"notify?", &notifiers,
+		"stop?", &stop,
+		"fidelity?", &fidelity,
+		"max_parallel?", &study.MaxParallel,
+		"aggregate?", &aggregate,
+		"constraints?", &constraints,
 	)
 	if err != nil {
 		return nil, err
 	}
+	study.Stop = stop.StopConditions
+	for i := 0; i < notifiers.Len(); i++ {
+		n, ok := notifiers.Index(i).(*notifierValue)
+		if !ok {
+			return nil, fmt.Errorf("study: %s is not a notifier", notifiers.Index(i))
+		}
+		study.Notifiers = append(study.Notifiers, n.Notifier)
+	}
+	if timeout != "" {
+		if study.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("study: timeout: %v", err)
+		}
+	}
+	for i := 0; i < transfer.Len(); i++ {
+		switch prev := transfer.Index(i).(type) {
+		case starlark.String:
+			study.Transfer = append(study.Transfer, string(prev))
+		case diviner.Study:
+			study.Transfer = append(study.Transfer, prev.Name)
+		default:
+			return nil, fmt.Errorf("transfer %s is not a study or a study name", prev)
+		}
+	}
+	switch obj := objective.(type) {
+	case diviner.Objective:
+		study.Objective = obj
+	case *starlark.List:
+		if obj.Len() == 0 {
+			return nil, errors.New("study: empty list of objectives")
+		}
+		study.Objectives = make([]diviner.Objective, obj.Len())
+		for i := range study.Objectives {
+			var ok bool
+			if study.Objectives[i], ok = obj.Index(i).(diviner.Objective); !ok {
+				return nil, fmt.Errorf("objective %s is not an objective", obj.Index(i))
+			}
+		}
+		study.Objective = study.Objectives[0]
+	default:
+		return nil, fmt.Errorf("objective %s is not an objective or a list of objectives", objective)
+	}
 	study.Oracle = oracle.Oracle
+	study.Scheduler = scheduler.Scheduler
 	study.Params = make(diviner.Params)
 	for _, tup := range params.Items() {
 		keystr, ok := tup.Index(0).(starlark.String)
@@ -358,6 +1076,48 @@ func makeStudy(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple
 			return nil, fmt.Errorf("parameter %s is not a valid parameter", string(keystr))
 		}
 	}
+	if err := study.Params.Validate(); err != nil {
+		return nil, fmt.Errorf("study %s: %v", study.Name, err)
+	}
+	for _, tup := range aggregate.Items() {
+		metric, ok := tup.Index(0).(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("study %s: aggregated metric %s is not named by a string", study.Name, tup.Index(0))
+		}
+		policy, ok := tup.Index(1).(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("study %s: aggregation %s of metric %s is not a string", study.Name, tup.Index(1), metric)
+		}
+		agg, err := diviner.ParseAggregation(string(policy))
+		if err != nil {
+			return nil, fmt.Errorf("study %s: metric %s: %v", study.Name, metric, err)
+		}
+		if study.Aggregate == nil {
+			study.Aggregate = make(map[string]diviner.Aggregation)
+		}
+		study.Aggregate[string(metric)] = agg
+	}
+	for i := 0; i < constraints.Len(); i++ {
+		s, ok := constraints.Index(i).(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("study %s: constraint %s is not a string", study.Name, constraints.Index(i))
+		}
+		c, err := diviner.ParseConstraint(string(s))
+		if err != nil {
+			return nil, fmt.Errorf("study %s: %v", study.Name, err)
+		}
+		study.Constraints = append(study.Constraints, c)
+	}
+	switch f := fidelity.(type) {
+	case starlark.NoneType:
+	case diviner.Fidelity:
+		if err := f.Validate(study.Params); err != nil {
+			return nil, fmt.Errorf("study %s: %v", study.Name, err)
+		}
+		study.Fidelity = &f
+	default:
+		return nil, fmt.Errorf("study %s: %s is not a fidelity", study.Name, fidelity)
+	}
 	for i := 1; i < runner.NumParams(); i++ {
 		switch name, _ := runner.Param(i); name {
 		default:
@@ -423,12 +1183,21 @@ func extractSystems(val starlark.Value) (systems []*diviner.System, err error) {
 	}
 }
 
+// EnvName matches valid environment variable names.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 func makeRunConfig(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var (
-		config   diviner.RunConfig
-		files    = new(starlark.List)
-		datasets = new(starlark.List)
-		systems  = new(starlark.Value)
+		config     diviner.RunConfig
+		files      = new(starlark.List)
+		datasets   = new(starlark.List)
+		systems    = new(starlark.Value)
+		retry      starlark.Value
+		resources  starlark.Value
+		checkpoint starlark.Value
+		timeout    string
+		env        *starlark.Dict
+		seed       int
 	)
 	err := starlark.UnpackArgs(
 		"run_config", args, kwargs,
@@ -436,10 +1205,61 @@ func makeRunConfig(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 		"script", &config.Script,
 		"local_files?", &files,
 		"datasets?", &datasets,
+		"retry?", &retry,
+		"resources?", &resources,
+		"checkpoint?", &checkpoint,
+		"timeout?", &timeout,
+		"image?", &config.Image,
+		"env?", &env,
+		"seed?", &seed,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if seed < 0 || seed > math.MaxInt32 {
+		return nil, fmt.Errorf("run_config: seed %d is out of range", seed)
+	}
+	config.Seed = int64(seed)
+	if env != nil {
+		config.Env = make(map[string]string)
+		for _, item := range env.Items() {
+			name, ok := starlark.AsString(item[0])
+			if !ok || !envName.MatchString(name) {
+				return nil, fmt.Errorf("run_config: env: %s is not a valid variable name", item[0])
+			}
+			if strings.HasPrefix(name, "DIVINER") {
+				return nil, fmt.Errorf("run_config: env: variable %s is reserved", name)
+			}
+			value, ok := starlark.AsString(item[1])
+			if !ok {
+				return nil, fmt.Errorf("run_config: env: value %s of variable %s is not a string", item[1], name)
+			}
+			config.Env[name] = value
+		}
+	}
+	if timeout != "" {
+		if config.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("run_config: timeout: %v", err)
+		}
+	}
+	if checkpoint != nil {
+		var ok bool
+		if config.Checkpoint, ok = checkpoint.(diviner.Checkpoint); !ok {
+			return nil, fmt.Errorf("checkpoint %s is not a checkpoint", checkpoint)
+		}
+	}
+	if resources != nil {
+		var ok bool
+		if config.Resources, ok = resources.(diviner.Resources); !ok {
+			return nil, fmt.Errorf("resources %s are not resources", resources)
+		}
+	}
+	if retry != nil {
+		var ok bool
+		if config.Retry, ok = retry.(diviner.RetryPolicy); !ok {
+			return nil, fmt.Errorf("retry %s is not a retry policy", retry)
+		}
+	}
 	config.LocalFiles = make([]string, files.Len())
 	for i := range config.LocalFiles {
 		str, ok := files.Index(i).(starlark.String)
@@ -464,10 +1284,132 @@ func makeRunConfig(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 	return config, nil
 }
 
+func makeResources(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		resources diviner.Resources
+		memory    int // in GiB
+	)
+	err := starlark.UnpackArgs(
+		"resources", args, kwargs,
+		"cpu?", &resources.CPU,
+		"memory?", &memory,
+		"gpu?", &resources.GPU,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if resources.CPU < 0 || memory < 0 || resources.GPU < 0 {
+		return nil, errors.New("resources: negative resources not allowed")
+	}
+	resources.Memory = data.Size(memory) * data.GiB
+	return resources, nil
+}
+
+func makeCheckpoint(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		checkpoint diviner.Checkpoint
+		interval   string
+	)
+	err := starlark.UnpackArgs(
+		"checkpoint", args, kwargs,
+		"path", &checkpoint.Path,
+		"url", &checkpoint.URL,
+		"interval?", &interval,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if interval != "" {
+		if checkpoint.Interval, err = time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("checkpoint: interval: %v", err)
+		}
+	}
+	if err := checkpoint.Validate(); err != nil {
+		return nil, fmt.Errorf("checkpoint: %v", err)
+	}
+	return checkpoint, nil
+}
+
+func makeFidelity(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		fidelity diviner.Fidelity
+		min, max starlark.Value
+	)
+	if err := starlark.UnpackArgs("fidelity", args, kwargs, "name", &fidelity.Name, "min", &min, "max", &max); err != nil {
+		return nil, err
+	}
+	var ok bool
+	if fidelity.Min, ok = coerceToFloat(min); !ok {
+		return nil, fmt.Errorf("fidelity: min %s is not a number", min)
+	}
+	if fidelity.Max, ok = coerceToFloat(max); !ok {
+		return nil, fmt.Errorf("fidelity: max %s is not a number", max)
+	}
+	_, minInt := min.(starlark.Int)
+	_, maxInt := max.(starlark.Int)
+	fidelity.Integer = minInt && maxInt
+	if err := fidelity.Validate(nil); err != nil {
+		return nil, fmt.Errorf("fidelity: %v", err)
+	}
+	return fidelity, nil
+}
+
+func makeRetry(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		policy     diviner.RetryPolicy
+		backoff    string
+		maxBackoff string
+		retryable  = new(starlark.List)
+		scale      starlark.Value = starlark.Float(0)
+	)
+	err := starlark.UnpackArgs(
+		"retry", args, kwargs,
+		"max_attempts", &policy.MaxAttempts,
+		"backoff?", &backoff,
+		"max_backoff?", &maxBackoff,
+		"retryable?", &retryable,
+		"retry_timeouts?", &policy.RetryTimeouts,
+		"resource_scale?", &scale,
+		"max_preemptions?", &policy.MaxPreemptions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	if policy.ResourceScale, ok = starlark.AsFloat(scale); !ok {
+		return nil, fmt.Errorf("retry: resource_scale must be a number, not %s", scale.Type())
+	}
+	if backoff != "" {
+		if policy.Backoff, err = time.ParseDuration(backoff); err != nil {
+			return nil, fmt.Errorf("retry: backoff: %v", err)
+		}
+	}
+	if maxBackoff != "" {
+		if policy.MaxBackoff, err = time.ParseDuration(maxBackoff); err != nil {
+			return nil, fmt.Errorf("retry: max_backoff: %v", err)
+		}
+	}
+	if retryable.Len() > 0 {
+		policy.Retryable = make([]string, retryable.Len())
+	}
+	for i := range policy.Retryable {
+		str, ok := retryable.Index(i).(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("retryable pattern %s is not a string", retryable.Index(i))
+		}
+		policy.Retryable[i] = string(str)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("retry: %v", err)
+	}
+	return policy, nil
+}
+
 func makeDataset(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var (
 		dataset diviner.Dataset
 		files   = new(starlark.List)
+		inputs  = new(starlark.List)
 		systems = new(starlark.Value)
 	)
 	err := starlark.UnpackArgs(
@@ -476,6 +1418,7 @@ func makeDataset(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 		"system", systems,
 		"if_not_exist?", &dataset.IfNotExist,
 		"local_files?", &files,
+		"inputs?", &inputs,
 		"script", &dataset.Script,
 	)
 	if err != nil {
@@ -489,6 +1432,16 @@ func makeDataset(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 		}
 		dataset.LocalFiles[i] = string(str)
 	}
+	if inputs.Len() > 0 {
+		dataset.Inputs = make([]string, inputs.Len())
+		for i := range dataset.Inputs {
+			str, ok := inputs.Index(i).(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("input %s is not a string", inputs.Index(i))
+			}
+			dataset.Inputs[i] = string(str)
+		}
+	}
 	if dataset.Systems, err = extractSystems(*systems); err != nil {
 		return nil, err
 	}
@@ -514,6 +1467,406 @@ func makeSkopt(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple
 	)
 }
 
+func makeVizier(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		o       = new(vizier.Oracle)
+		timeout string
+	)
+	if err := starlark.UnpackArgs(
+		"vizier", args, kwargs,
+		"addr", &o.Addr,
+		"study?", &o.Study,
+		"algorithm?", &o.Algorithm,
+		"timeout?", &timeout,
+	); err != nil {
+		return nil, err
+	}
+	if o.Addr == "" {
+		return nil, errors.New("vizier: empty addr")
+	}
+	if timeout != "" {
+		var err error
+		if o.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("vizier: invalid timeout %q: %v", timeout, err)
+		}
+	}
+	return &oracleValue{o}, nil
+}
+
+func makeWandbSweep(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		o       = new(wandb.Sweep)
+		timeout string
+	)
+	if err := starlark.UnpackArgs(
+		"wandb_sweep", args, kwargs,
+		"entity", &o.Entity,
+		"project", &o.Project,
+		"sweep", &o.Sweep,
+		"url?", &o.URL,
+		"timeout?", &timeout,
+	); err != nil {
+		return nil, err
+	}
+	if o.Entity == "" || o.Project == "" || o.Sweep == "" {
+		return nil, errors.New("wandb_sweep: entity, project, and sweep must be provided")
+	}
+	if timeout != "" {
+		var err error
+		if o.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("wandb_sweep: invalid timeout %q: %v", timeout, err)
+		}
+	}
+	return &oracleValue{o}, nil
+}
+
+func makeRandomSearch(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n, seed int
+	if err := starlark.UnpackArgs("random_search", args, kwargs, "n", &n, "seed?", &seed); err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("random_search: n must be positive, got %d", n)
+	}
+	return &oracleValue{oracle.NewRandomSearch(n, int64(seed))}, nil
+}
+
+func makeHalton(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		n, seed  int
+		scramble = true
+	)
+	if err := starlark.UnpackArgs("halton", args, kwargs, "n?", &n, "seed?", &seed, "scramble?", &scramble); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("halton: n must be non-negative, got %d", n)
+	}
+	return &oracleValue{&oracle.Halton{N: n, Seed: int64(seed), Scramble: scramble}}, nil
+}
+
+func makeHalving(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n, eta, seed int
+	if err := starlark.UnpackArgs("halving", args, kwargs, "n?", &n, "eta?", &eta, "seed?", &seed); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("halving: n must be non-negative, got %d", n)
+	}
+	if eta != 0 && eta < 2 {
+		return nil, fmt.Errorf("halving: eta must be at least 2, got %d", eta)
+	}
+	return &oracleValue{&oracle.SuccessiveHalving{N: n, Eta: eta, Seed: int64(seed)}}, nil
+}
+
+func makeGP(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		gp   = new(oracle.GP)
+		seed int
+		xi   starlark.Value = starlark.Float(0)
+	)
+	if err := starlark.UnpackArgs(
+		"gp", args, kwargs,
+		"seed?", &seed,
+		"n_initial_points?", &gp.NumInitial,
+		"n_candidates?", &gp.NumCandidates,
+		"xi?", &xi,
+	); err != nil {
+		return nil, err
+	}
+	gp.Seed = int64(seed)
+	var ok bool
+	if gp.Xi, ok = starlark.AsFloat(xi); !ok {
+		return nil, fmt.Errorf("gp: xi must be a number, not %s", xi.Type())
+	}
+	return &oracleValue{gp}, nil
+}
+
+func makeNSGA2(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		nsga2 = new(oracle.NSGA2)
+		seed  int
+		rate  starlark.Value = starlark.Float(0)
+	)
+	if err := starlark.UnpackArgs(
+		"nsga2", args, kwargs,
+		"seed?", &seed,
+		"population_size?", &nsga2.PopulationSize,
+		"mutation_rate?", &rate,
+	); err != nil {
+		return nil, err
+	}
+	nsga2.Seed = int64(seed)
+	var ok bool
+	if nsga2.MutationRate, ok = starlark.AsFloat(rate); !ok {
+		return nil, fmt.Errorf("nsga2: mutation_rate must be a number, not %s", rate.Type())
+	}
+	if nsga2.MutationRate < 0 || nsga2.MutationRate > 1 {
+		return nil, fmt.Errorf("nsga2: mutation_rate %v is not a probability", nsga2.MutationRate)
+	}
+	return &oracleValue{nsga2}, nil
+}
+
+func makeGenetic(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		genetic                = new(oracle.Genetic)
+		seed                   int
+		crossover, rate, scale starlark.Value = starlark.Float(0), starlark.Float(0), starlark.Float(0)
+	)
+	if err := starlark.UnpackArgs(
+		"genetic", args, kwargs,
+		"seed?", &seed,
+		"population_size?", &genetic.PopulationSize,
+		"tournament_size?", &genetic.TournamentSize,
+		"crossover_rate?", &crossover,
+		"mutation_rate?", &rate,
+		"mutation_scale?", &scale,
+	); err != nil {
+		return nil, err
+	}
+	genetic.Seed = int64(seed)
+	if genetic.PopulationSize < 0 {
+		return nil, fmt.Errorf("genetic: negative population_size %d", genetic.PopulationSize)
+	}
+	if genetic.TournamentSize < 0 {
+		return nil, fmt.Errorf("genetic: negative tournament_size %d", genetic.TournamentSize)
+	}
+	var ok bool
+	if genetic.CrossoverRate, ok = starlark.AsFloat(crossover); !ok {
+		return nil, fmt.Errorf("genetic: crossover_rate must be a number, not %s", crossover.Type())
+	}
+	if genetic.CrossoverRate < 0 || genetic.CrossoverRate > 1 {
+		return nil, fmt.Errorf("genetic: crossover_rate %v is not a probability", genetic.CrossoverRate)
+	}
+	if genetic.MutationRate, ok = starlark.AsFloat(rate); !ok {
+		return nil, fmt.Errorf("genetic: mutation_rate must be a number, not %s", rate.Type())
+	}
+	if genetic.MutationRate < 0 || genetic.MutationRate > 1 {
+		return nil, fmt.Errorf("genetic: mutation_rate %v is not a probability", genetic.MutationRate)
+	}
+	if genetic.MutationScale, ok = starlark.AsFloat(scale); !ok {
+		return nil, fmt.Errorf("genetic: mutation_scale must be a number, not %s", scale.Type())
+	}
+	if genetic.MutationScale < 0 {
+		return nil, fmt.Errorf("genetic: negative mutation_scale %v", genetic.MutationScale)
+	}
+	return &oracleValue{genetic}, nil
+}
+
+func makeCMAES(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		cmaes   = new(oracle.CMAES)
+		seed    int
+		sigma   starlark.Value = starlark.Float(0)
+		restart = "ipop"
+	)
+	if err := starlark.UnpackArgs(
+		"cmaes", args, kwargs,
+		"seed?", &seed,
+		"population_size?", &cmaes.PopulationSize,
+		"sigma?", &sigma,
+		"restart?", &restart,
+		"max_restarts?", &cmaes.MaxRestarts,
+	); err != nil {
+		return nil, err
+	}
+	cmaes.Seed = int64(seed)
+	if cmaes.PopulationSize < 0 {
+		return nil, fmt.Errorf("cmaes: negative population_size %d", cmaes.PopulationSize)
+	}
+	var ok bool
+	if cmaes.Sigma, ok = starlark.AsFloat(sigma); !ok {
+		return nil, fmt.Errorf("cmaes: sigma must be a number, not %s", sigma.Type())
+	}
+	if cmaes.Sigma < 0 {
+		return nil, fmt.Errorf("cmaes: negative sigma %v", cmaes.Sigma)
+	}
+	var err error
+	if cmaes.Restart, err = oracle.ParseCMAESRestart(restart); err != nil {
+		return nil, fmt.Errorf("cmaes: %v", err)
+	}
+	return &oracleValue{cmaes}, nil
+}
+
+func makePBT(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		pbt                = new(oracle.PBT)
+		seed               int
+		quantile, resample starlark.Value = starlark.Float(0), starlark.Float(0)
+	)
+	if err := starlark.UnpackArgs(
+		"pbt", args, kwargs,
+		"population", &pbt.Population,
+		"interval?", &pbt.Interval,
+		"quantile?", &quantile,
+		"resample_probability?", &resample,
+		"seed?", &seed,
+	); err != nil {
+		return nil, err
+	}
+	pbt.Seed = int64(seed)
+	if pbt.Population <= 0 {
+		return nil, fmt.Errorf("pbt: population %d is not positive", pbt.Population)
+	}
+	if pbt.Interval < 0 {
+		return nil, fmt.Errorf("pbt: negative interval %d", pbt.Interval)
+	}
+	var ok bool
+	if pbt.Quantile, ok = starlark.AsFloat(quantile); !ok {
+		return nil, fmt.Errorf("pbt: quantile must be a number, not %s", quantile.Type())
+	}
+	if pbt.Quantile < 0 || pbt.Quantile > 0.5 {
+		return nil, fmt.Errorf("pbt: quantile %v is not in [0, 0.5]", pbt.Quantile)
+	}
+	if pbt.ResampleProbability, ok = starlark.AsFloat(resample); !ok {
+		return nil, fmt.Errorf("pbt: resample_probability must be a number, not %s", resample.Type())
+	}
+	if pbt.ResampleProbability < 0 || pbt.ResampleProbability > 1 {
+		return nil, fmt.Errorf("pbt: resample_probability %v is not a probability", pbt.ResampleProbability)
+	}
+	return &oracleValue{pbt}, nil
+}
+
+func makeASHA(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	asha := new(scheduler.ASHA)
+	return &schedulerValue{asha}, starlark.UnpackArgs(
+		"asha", args, kwargs,
+		"min_step?", &asha.MinStep,
+		"max_step?", &asha.MaxStep,
+		"eta?", &asha.Eta,
+	)
+}
+
+func makeHyperband(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	hyperband := new(scheduler.Hyperband)
+	return &schedulerValue{hyperband}, starlark.UnpackArgs(
+		"hyperband", args, kwargs,
+		"min_step?", &hyperband.MinStep,
+		"max_step", &hyperband.MaxStep,
+		"eta?", &hyperband.Eta,
+		"brackets?", &hyperband.Brackets,
+	)
+}
+
+func makeStopWhen(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		stop        = new(stopValue)
+		target      = starlark.Value(starlark.None)
+		maxDuration string
+		maxRuntime  string
+	)
+	err := starlark.UnpackArgs(
+		"stop_when", args, kwargs,
+		"target?", &target,
+		"max_trials?", &stop.MaxTrials,
+		"max_duration?", &maxDuration,
+		"max_runtime?", &maxRuntime,
+		"patience?", &stop.Patience,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if target != starlark.None {
+		v, ok := coerceToFloat(target)
+		if !ok {
+			return nil, fmt.Errorf("stop_when: target %s is not a number", target)
+		}
+		stop.Target = &v
+	}
+	if maxDuration != "" {
+		if stop.MaxDuration, err = time.ParseDuration(maxDuration); err != nil {
+			return nil, fmt.Errorf("stop_when: max_duration: %v", err)
+		}
+	}
+	if maxRuntime != "" {
+		if stop.MaxRuntime, err = time.ParseDuration(maxRuntime); err != nil {
+			return nil, fmt.Errorf("stop_when: max_runtime: %v", err)
+		}
+	}
+	return stop, nil
+}
+
+func makeMedianStopping(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	median := new(scheduler.MedianStopping)
+	return &schedulerValue{median}, starlark.UnpackArgs(
+		"median_stopping", args, kwargs,
+		"min_step?", &median.MinStep,
+		"min_runs?", &median.MinRuns,
+	)
+}
+
+func makeSlack(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		slack  = new(notify.Slack)
+		events = new(starlark.List)
+	)
+	err := starlark.UnpackArgs(
+		"slack", args, kwargs,
+		"url", &slack.URL,
+		"events?", &events,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if slack.Events, err = eventKinds(events); err != nil {
+		return nil, fmt.Errorf("slack: %v", err)
+	}
+	return &notifierValue{slack}, nil
+}
+
+func makeWebhook(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		webhook = new(notify.Webhook)
+		events  = new(starlark.List)
+		headers = new(starlark.Dict)
+	)
+	err := starlark.UnpackArgs(
+		"webhook", args, kwargs,
+		"url", &webhook.URL,
+		"events?", &events,
+		"headers?", &headers,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.Events, err = eventKinds(events); err != nil {
+		return nil, fmt.Errorf("webhook: %v", err)
+	}
+	for _, tup := range headers.Items() {
+		key, ok := starlark.AsString(tup.Index(0))
+		if !ok {
+			return nil, fmt.Errorf("webhook: header %s is not a string", tup.Index(0))
+		}
+		value, ok := starlark.AsString(tup.Index(1))
+		if !ok {
+			return nil, fmt.Errorf("webhook: value of header %s is not a string", key)
+		}
+		if webhook.Header == nil {
+			webhook.Header = make(http.Header)
+		}
+		webhook.Header.Add(key, value)
+	}
+	return &notifierValue{webhook}, nil
+}
+
+// EventKinds returns the set of event kinds named in the provided
+// list.
+func eventKinds(list *starlark.List) (diviner.EventKind, error) {
+	var kinds diviner.EventKind
+	for i := 0; i < list.Len(); i++ {
+		name, ok := starlark.AsString(list.Index(i))
+		if !ok {
+			return 0, fmt.Errorf("event %s is not a string", list.Index(i))
+		}
+		kind, err := diviner.ParseEventKind(name)
+		if err != nil {
+			return 0, err
+		}
+		kinds |= kind
+	}
+	return kinds, nil
+}
+
 func makeConfig(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	log.Error.Printf("%s: config is deprecated and will be ignored", thread.Caller().Position())
 	return starlark.None, nil
@@ -614,7 +1967,7 @@ func makeEC2System(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 		"region?", &ec2.Region,
 		"security_group?", &ec2.SecurityGroup,
 		"instance_profile", &ec2.InstanceProfile,
-		"instance_type", &ec2.InstanceType,
+		"instance_type?", &ec2.InstanceType,
 		"disk_space?", &diskspace,
 		"data_space?", &dataspace,
 		"on_demand?", &ec2.OnDemand,
@@ -640,6 +1993,116 @@ func makeEC2System(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 	}
 	ec2.Diskspace = uint(diskspace)
 	ec2.Dataspace = uint(dataspace)
+	system.Spot = !ec2.OnDemand
+	instanceType := ec2.InstanceType
+	if instanceType == "" {
+		instanceType = defaultEC2InstanceType
+	}
+	if typ, ok := lookupEC2Instance(instanceType); ok {
+		system.Resources = ec2Resources(typ)
+	}
+	return system, nil
+}
+
+func makeK8sSystem(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		system       = new(diviner.System)
+		backend      = new(kubernetes.Backend)
+		nodeSelector *starlark.Dict
+	)
+	system.Backend = backend
+	err := starlark.UnpackArgs(
+		"k8ssystem", args, kwargs,
+		"name", &system.ID,
+		"image", &backend.Image,
+		"namespace?", &backend.Namespace,
+		"context?", &backend.Context,
+		"node_selector?", &nodeSelector,
+		"service_account?", &backend.ServiceAccount,
+		"parallelism?", &system.Parallelism,
+		"kubectl?", &backend.Kubectl,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if nodeSelector != nil {
+		backend.NodeSelector = make(map[string]string)
+		for _, item := range nodeSelector.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("k8ssystem: node selector key %s is not a string", item[0])
+			}
+			value, ok := starlark.AsString(item[1])
+			if !ok {
+				return nil, fmt.Errorf("k8ssystem: node selector value %s is not a string", item[1])
+			}
+			backend.NodeSelector[key] = value
+		}
+	}
+	return system, nil
+}
+
+func makeBatchSystem(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		system  = new(diviner.System)
+		backend = new(awsbatch.Backend)
+	)
+	system.Backend = backend
+	err := starlark.UnpackArgs(
+		"batchsystem", args, kwargs,
+		"name", &system.ID,
+		"job_queue", &backend.JobQueue,
+		"image?", &backend.Image,
+		"region?", &backend.Region,
+		"job_role?", &backend.JobRole,
+		"log_group?", &backend.LogGroup,
+		"parallelism?", &system.Parallelism,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return system, nil
+}
+
+func makeSlurmSystem(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		system  = new(diviner.System)
+		backend = new(slurm.Backend)
+	)
+	system.Backend = backend
+	err := starlark.UnpackArgs(
+		"slurmsystem", args, kwargs,
+		"name", &system.ID,
+		"dir?", &backend.Dir,
+		"partition?", &backend.Partition,
+		"account?", &backend.Account,
+		"directives?", &backend.Directives,
+		"parallelism?", &system.Parallelism,
+		"bin?", &backend.Bin,
+		"keep?", &backend.Keep,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return system, nil
+}
+
+func makeLocalExec(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		system  = &diviner.System{Parallelism: runtime.NumCPU()}
+		backend = new(localexec.Backend)
+	)
+	system.Backend = backend
+	err := starlark.UnpackArgs(
+		"localexec", args, kwargs,
+		"name", &system.ID,
+		"parallelism?", &system.Parallelism,
+		"dir?", &backend.Dir,
+		"keep?", &backend.Keep,
+	)
+	if err != nil {
+		return nil, err
+	}
 	return system, nil
 }
 
@@ -723,6 +2186,80 @@ func (v enumValue) Hash() (uint32, error) {
 	return starlark.String(v).Hash()
 }
 
+// DurationValue is the Starlark representation of a diviner.Duration.
+type durationValue diviner.Duration
+
+func makeDuration(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var str string
+	err := starlark.UnpackArgs(
+		"duration", args, kwargs,
+		"value", &str,
+	)
+	if err != nil {
+		return nil, err
+	}
+	d, err := diviner.ParseDuration(str)
+	if err != nil {
+		return nil, fmt.Errorf("duration: %v", err)
+	}
+	return durationValue(d), nil
+}
+
+func (v durationValue) String() string       { return diviner.Duration(v).String() }
+func (durationValue) Type() string           { return "duration" }
+func (durationValue) Freeze()                {}
+func (v durationValue) Truth() starlark.Bool { return v != 0 }
+func (v durationValue) Hash() (uint32, error) {
+	return starlark.MakeInt64(int64(v)).Hash()
+}
+
+func (v durationValue) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "seconds":
+		return starlark.MakeInt64(int64(time.Duration(v) / time.Second)), nil
+	}
+	return nil, nil
+}
+
+func (durationValue) AttrNames() []string { return []string{"seconds"} }
+
+// TimeValue is the Starlark representation of a diviner.Time.
+type timeValue diviner.Time
+
+func makeTime(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var str string
+	err := starlark.UnpackArgs(
+		"time", args, kwargs,
+		"value", &str,
+	)
+	if err != nil {
+		return nil, err
+	}
+	t, err := diviner.ParseTime(str)
+	if err != nil {
+		return nil, fmt.Errorf("time: %v", err)
+	}
+	return timeValue(t), nil
+}
+
+func (v timeValue) String() string     { return diviner.Time(v).String() }
+func (timeValue) Type() string         { return "time" }
+func (timeValue) Freeze()              {}
+func (timeValue) Truth() starlark.Bool { return true }
+func (v timeValue) Hash() (uint32, error) {
+	return starlark.MakeInt64(int64(v)).Hash()
+}
+
+func (v timeValue) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "unix":
+		return starlark.MakeInt64(diviner.Time(v).Time().Unix()), nil
+	}
+	return nil, nil
+}
+
+func (timeValue) AttrNames() []string { return []string{"unix"} }
+
 func dictKey(v starlark.Value) (string, error) {
 	keyValue, ok := v.(starlark.String)
 	if !ok {
@@ -809,6 +2346,48 @@ func makeToProto(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 	return starlark.String(buf.String()), nil
 }
 
+func makeTemplate(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		text      string
+		dict      = new(starlark.Dict)
+		id        string
+		replicate int
+	)
+	err := starlark.UnpackArgs(
+		"template", args, kwargs,
+		"text", &text,
+		"values", &dict,
+		"id?", &id,
+		"replicate?", &replicate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	values := make(diviner.Values, dict.Len())
+	for _, kv := range dict.Items() {
+		name, ok := starlark.AsString(kv[0])
+		if !ok {
+			return nil, fmt.Errorf("template: value name %s is not a string", kv[0])
+		}
+		if values[name] = starlark2diviner(kv[1]); values[name] == nil {
+			return nil, fmt.Errorf("template: invalid value %s of %s", kv[1], name)
+		}
+	}
+	return starlark.String(diviner.NewTemplate(text).Execute(values, replicate, id)), nil
+}
+
+func makeQuote(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var val starlark.Value
+	if err := starlark.UnpackArgs("quote", args, kwargs, "value", &val); err != nil {
+		return nil, err
+	}
+	v := starlark2diviner(val)
+	if v == nil {
+		return nil, fmt.Errorf("quote: invalid value %s", val)
+	}
+	return starlark.String(diviner.Quote(v)), nil
+}
+
 func makePanic(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	if len(kwargs) > 0 {
 		log.Panicf("panic: panic takes no keyword args, but got %+v", kwargs)
@@ -840,6 +2419,10 @@ func starlark2diviner(val starlark.Value) diviner.Value {
 		return diviner.Int(v64)
 	case starlark.Bool:
 		return diviner.Bool(bool(val))
+	case durationValue:
+		return diviner.Duration(val)
+	case timeValue:
+		return diviner.Time(val)
 	case *starlark.List:
 		list := make(diviner.List, val.Len())
 		for i := range list {
@@ -880,6 +2463,14 @@ func diviner2starlark(val diviner.Value) starlark.Value {
 		return starlark.String(val.String())
 	case diviner.Bool, *diviner.Bool:
 		return starlark.Bool(val.Bool())
+	case diviner.Duration:
+		return durationValue(v)
+	case *diviner.Duration:
+		return durationValue(*v)
+	case diviner.Time:
+		return timeValue(v)
+	case *diviner.Time:
+		return timeValue(*v)
 	case *diviner.List:
 		elems := make([]starlark.Value, val.Len())
 		for i := range elems {
@@ -5,14 +5,25 @@
 package script_test
 
 import (
+	"net/http"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/grailbio/base/data"
 	"github.com/grailbio/bigmachine"
 	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/awsbatch"
+	"github.com/grailbio/diviner/kubernetes"
+	"github.com/grailbio/diviner/localexec"
+	"github.com/grailbio/diviner/notify"
 	"github.com/grailbio/diviner/oracle"
+	"github.com/grailbio/diviner/scheduler"
 	"github.com/grailbio/diviner/script"
+	"github.com/grailbio/diviner/slurm"
+	"github.com/grailbio/diviner/vizier"
+	"github.com/grailbio/diviner/wandb"
 )
 
 func TestScript(t *testing.T) {
@@ -199,6 +210,748 @@ func TestCommand(t *testing.T) {
 	}
 }
 
+func TestGP(t *testing.T) {
+	studies, err := script.Load("testdata/gp.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Oracle, (&oracle.GP{Seed: 3, NumInitial: 8, Xi: 0.1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRandomSearch(t *testing.T) {
+	studies, err := script.Load("testdata/random_search.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Oracle, (&oracle.RandomSearch{N: 20, Seed: 7}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHalton(t *testing.T) {
+	studies, err := script.Load("testdata/halton.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Oracle, (&oracle.Halton{N: 16, Seed: 3, Scramble: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNSGA2(t *testing.T) {
+	studies, err := script.Load("testdata/nsga2.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	study := studies[0]
+	objectives := []diviner.Objective{
+		{Direction: diviner.Maximize, Metric: "acc"},
+		{Direction: diviner.Minimize, Metric: "latency"},
+	}
+	if got, want := study.Objectives, objectives; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Objective, objectives[0]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Oracle, (&oracle.NSGA2{Seed: 3, PopulationSize: 10, MutationRate: 0.2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGenetic(t *testing.T) {
+	studies, err := script.Load("testdata/genetic.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := &oracle.Genetic{
+		Seed:           5,
+		PopulationSize: 30,
+		TournamentSize: 4,
+		CrossoverRate:  0.8,
+		MutationRate:   0.25,
+		MutationScale:  0.2,
+	}
+	if got := studies[0].Oracle; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestVizier(t *testing.T) {
+	studies, err := script.Load("testdata/vizier.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := &vizier.Oracle{Addr: "localhost:6002", Study: "mnist", Algorithm: "tpe", Timeout: 30 * time.Second}
+	if got := studies[0].Oracle; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWandbSweep(t *testing.T) {
+	studies, err := script.Load("testdata/wandb.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := &wandb.Sweep{Entity: "grail", Project: "mnist", Sweep: "a1b2c3d4", Timeout: 10 * time.Minute}
+	if got := studies[0].Oracle; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCMAES(t *testing.T) {
+	studies, err := script.Load("testdata/cmaes.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := &oracle.CMAES{Seed: 2, PopulationSize: 12, Sigma: 0.2, Restart: oracle.RestartRandom, MaxRestarts: 3}
+	if got := studies[0].Oracle; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	studies, err := script.Load("testdata/notify.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := []diviner.Notifier{
+		&notify.Slack{URL: "https://hooks.slack.com/services/T0/B0/X"},
+		&notify.Webhook{
+			URL:    "https://example.com/hook",
+			Events: diviner.RunFailed | diviner.NewBest,
+			Header: http.Header{"Authorization": {"Bearer token"}},
+		},
+	}
+	if got := studies[0].Notifiers; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	_, err = script.Load("bad.dv", `slack("https://example.com", events=["run_exploded"])`)
+	if err == nil || !strings.Contains(err.Error(), "unknown event kind") {
+		t.Errorf("bad error %v", err)
+	}
+}
+
+func TestPBT(t *testing.T) {
+	studies, err := script.Load("testdata/pbt.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := &oracle.PBT{Population: 8, Interval: 2, Quantile: 0.2, Seed: 5}
+	if got := studies[0].Oracle; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := studies[0].Oracle.(diviner.Exploiter); !ok {
+		t.Error("pbt oracle is not an exploiter")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	studies, err := script.Load("testdata/retry.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	config, err := studies[0].Run(diviner.Values{"optimizer": diviner.String("adam")}, 0, "retry:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := diviner.RetryPolicy{
+		MaxAttempts:    3,
+		Backoff:        30 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Retryable:      []string{"out of memory"},
+		MaxPreemptions: 10,
+	}
+	if got := config.Retry; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFidelity(t *testing.T) {
+	studies, err := script.Load("testdata/fidelity.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	study := studies[0]
+	if got, want := study.Fidelity, (&diviner.Fidelity{Name: "epochs", Min: 1, Max: 27, Integer: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := study.Oracle.(diviner.FidelityOracle); !ok {
+		t.Errorf("oracle %v does not set fidelities", study.Oracle)
+	}
+	config, err := study.Run(diviner.Values{"lr": diviner.Float(0.1), "epochs": diviner.Int(9)}, 0, "fidelity:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "train --lr=0.1 --epochs=9"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	studies, err := script.Load("testdata/timeout.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	study := studies[0]
+	if got, want := study.Timeout, 6*time.Hour; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, c := range []struct {
+		optimizer string
+		timeout   time.Duration
+	}{
+		{"adam", 0},
+		{"sgd", 2 * time.Hour},
+	} {
+		config, err := study.Run(diviner.Values{"optimizer": diviner.String(c.optimizer)}, 0, "timeout:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := config.Timeout, c.timeout; got != want {
+			t.Errorf("%s: got %v, want %v", c.optimizer, got, want)
+		}
+		if !config.Retry.RetryTimeouts {
+			t.Error("timeouts not retried")
+		}
+		if got, want := config.Retry.ResourceScale, 2.0; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMaxParallel(t *testing.T) {
+	studies, err := script.Load("testdata/parallel.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].MaxParallel, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	studies, err := script.Load("testdata/aggregate.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := map[string]diviner.Aggregation{
+		"acc":  {Reduce: diviner.ReduceMax},
+		"loss": {Reduce: diviner.ReduceMean, Window: 3},
+	}
+	if got := studies[0].Aggregate; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	constraints := []diviner.Constraint{
+		{Metric: "latency_ms", Op: "<", Value: 50},
+		{Metric: "params", Op: "<=", Value: 10e6},
+	}
+	if got, want := studies[0].Constraints, constraints; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEnum(t *testing.T) {
+	studies, err := script.Load("testdata/enum.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := diviner.NewEnum("small", "medium", "large")
+	if got := studies[0].Params["size"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	values, err := studies[0].Oracle.Next(nil, studies[0].Params, studies[0].Objective, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range values {
+		if !studies[0].Params.IsValid(v) {
+			t.Errorf("invalid values %v", v)
+		}
+	}
+}
+
+func TestWeightedDiscrete(t *testing.T) {
+	studies, err := script.Load("testdata/weighted.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	params := studies[0].Params
+	if got, want := params["optimizer"].(*diviner.Discrete).Weights, []float64{3, 1, 0.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := params["layers"].(*diviner.Discrete).Weights; got != nil {
+		t.Errorf("unexpected weights %v", got)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	studies, err := script.Load("testdata/schedule.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	study := studies[0]
+	if got, want := study.Params["interval"].String(), "discrete(30m0s, 1h30m0s)"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Params["start"].Kind(), diviner.Timestamp; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	start, err := diviner.ParseTime("2019-06-01T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := diviner.Values{
+		"interval": diviner.Duration(90 * time.Minute),
+		"start":    start,
+	}
+	config, err := study.Run(values, 0, "schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "train --interval=1h30m0s --interval_seconds=5400 --start=2019-06-01T12:00:00Z"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	studies, err := script.Load("testdata/checkpoint.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	config, err := studies[0].Run(diviner.Values{"optimizer": diviner.String("adam")}, 0, "checkpoint:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := diviner.Checkpoint{
+		Path:     "model.ckpt",
+		URL:      "s3://bucket/checkpoints",
+		Interval: 5 * time.Minute,
+	}
+	if got := config.Checkpoint; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	studies, err := script.Load("testdata/transfer.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := studies[0].Transfer; len(got) != 0 {
+		t.Errorf("got %v, want []", got)
+	}
+	if got, want := studies[1].Transfer, []string{"prior", "archived"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResources(t *testing.T) {
+	studies, err := script.Load("testdata/resources.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	config, err := studies[0].Run(diviner.Values{"optimizer": diviner.String("adam")}, 0, "resources:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	need := diviner.Resources{CPU: 4, Memory: 16 * data.GiB, GPU: 1}
+	if got, want := config.Resources, need; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(config.Systems), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sys := config.Systems[0]
+	if got, want := sys.Resources, (diviner.Resources{CPU: 2, Memory: 8 * data.GiB}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	gpu, err := sys.Configure(need)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gpu.Resources.Satisfies(need) {
+		t.Errorf("%s does not satisfy %s", gpu.Resources, need)
+	}
+	if gpu.Resources.GPU == 0 {
+		t.Errorf("no GPU in %s", gpu.Resources)
+	}
+	// Configured machines are priced by their instance types.
+	machineType, region := gpu.System.(diviner.MachineDescriber).Machine()
+	if _, ok := diviner.EC2Pricing.HourlyPrice(machineType, region); !ok {
+		t.Errorf("instance type %s is not priced in region %s", machineType, region)
+	}
+}
+
+func TestLocalExec(t *testing.T) {
+	studies, err := script.Load("testdata/localexec.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	config, err := studies[0].Run(diviner.Values{"optimizer": diviner.String("adam")}, 0, "localexec:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(config.Systems), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sys := config.Systems[0]
+	if got, want := sys.ID, "local"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := sys.Parallelism, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := sys.Backend, (&localexec.Backend{Keep: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestK8sSystem(t *testing.T) {
+	studies, err := script.Load("testdata/k8s.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	config, err := studies[0].Run(diviner.Values{"optimizer": diviner.String("adam")}, 0, "k8s:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(config.Systems), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sys := config.Systems[0]
+	if got, want := sys.ID, "k8s"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := sys.Parallelism, 8; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	backend, ok := sys.Backend.(*kubernetes.Backend)
+	if !ok {
+		t.Fatalf("bad backend %T", sys.Backend)
+	}
+	want := &kubernetes.Backend{
+		Image:        "tensorflow/tensorflow:latest-gpu",
+		Namespace:    "ml",
+		NodeSelector: map[string]string{"accelerator": "nvidia-tesla-v100"},
+	}
+	if !reflect.DeepEqual(backend, want) {
+		t.Errorf("got %+v, want %+v", backend, want)
+	}
+	// Backend systems are used as is; the cluster is responsible for
+	// providing the requested resources.
+	configured, err := sys.Configure(config.Resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured != sys {
+		t.Errorf("system was reconfigured: %v", configured)
+	}
+}
+
+func TestBatchSystem(t *testing.T) {
+	studies, err := script.Load("testdata/batch.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	config, err := studies[0].Run(diviner.Values{"optimizer": diviner.String("adam")}, 0, "batch:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(config.Systems), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sys := config.Systems[0]
+	if got, want := sys.ID, "batch"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := sys.Parallelism, 16; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	backend, ok := sys.Backend.(*awsbatch.Backend)
+	if !ok {
+		t.Fatalf("bad backend %T", sys.Backend)
+	}
+	if got, want := backend.JobQueue, "gpu-queue"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := backend.Image, "tensorflow/tensorflow:latest-gpu"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := backend.Region, "us-west-2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := backend.JobRole, "arn:aws:iam::123456789012:role/diviner"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSlurmSystem(t *testing.T) {
+	studies, err := script.Load("testdata/slurm.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	config, err := studies[0].Run(diviner.Values{"lr": diviner.Float(0.1)}, 0, "slurm:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(config.Systems), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sys := config.Systems[0]
+	if got, want := sys.Parallelism, 32; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	backend, ok := sys.Backend.(*slurm.Backend)
+	if !ok {
+		t.Fatalf("bad backend %T", sys.Backend)
+	}
+	want := &slurm.Backend{
+		Dir:        "/scratch/diviner",
+		Partition:  "gpu",
+		Account:    "ml-lab",
+		Directives: "#SBATCH --gres=gpu:{{.GPU}}\n#SBATCH --time=12:00:00\n",
+	}
+	if !reflect.DeepEqual(backend, want) {
+		t.Errorf("got %+v, want %+v", backend, want)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	studies, err := script.Load("testdata/ranges.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	params := studies[0].Params
+	want := diviner.Params{
+		"learning_rate": diviner.NewLogRange(diviner.Float(1e-5), diviner.Float(1e-1)),
+		"layers":        diviner.NewLogRange(diviner.Int(1), diviner.Int(64)),
+		"batch_size":    diviner.NewQuantizedRange(diviner.Int(16), diviner.Int(129), diviner.Int(16)),
+		"dropout":       diviner.NewQuantizedRange(diviner.Float(0), diviner.Float(0.5), diviner.Float(0.1)),
+	}
+	if got := params; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestVector(t *testing.T) {
+	studies, err := script.Load("testdata/vector.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	param := studies[0].Params["widths"]
+	if got, want := param.Kind(), diviner.Seq; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := len(param.Values()), 27; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	widths := diviner.List{diviner.Int(128), diviner.Int(64), diviner.Int(32)}
+	if !param.IsValid(widths) {
+		t.Errorf("%v not valid for %v", widths, param)
+	}
+	config, err := studies[0].Run(diviner.Values{"widths": widths}, 0, "vector:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "train --widths=128,64,32"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	studies, err := script.Load("testdata/conditional.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	study := studies[0]
+	param, ok := study.Params["momentum"].(*diviner.Conditional)
+	if !ok {
+		t.Fatalf("got %T, want *diviner.Conditional", study.Params["momentum"])
+	}
+	if got, want := param.On, "optimizer"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := param.String(), `conditional(discrete(0.5, 0.9), "optimizer", sgd)`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !study.Params.IsValid(diviner.Values{"optimizer": diviner.String("adam")}) {
+		t.Error("expected values to be valid")
+	}
+	config, err := study.Run(diviner.Values{"optimizer": diviner.String("adam")}, 0, "conditional:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "train --optimizer=adam --momentum=none"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	config, err = study.Run(diviner.Values{"optimizer": diviner.String("sgd"), "momentum": diviner.Float(0.9), "nesterov": diviner.Bool(true)}, 0, "conditional:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "train --optimizer=sgd --momentum=0.9"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	values, err := study.Oracle.Next(nil, study.Params, study.Objective, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// adam; sgd, momentum=0.5; sgd, momentum=0.9, nesterov=true|false.
+	if got, want := len(values), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestConditionalInvalid(t *testing.T) {
+	_, err := script.Load("invalid.dv", []byte(`
+study(
+    name="invalid",
+    objective=minimize("loss"),
+    params={"momentum": conditional(discrete(0.5, 0.9), "optimizer", "sgd")},
+    run=lambda values: None,
+)
+`))
+	if err == nil || !strings.Contains(err.Error(), "nonexistent parameter optimizer") {
+		t.Errorf("got %v, want nonexistent parameter error", err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	studies, err := script.Load("testdata/scheduler.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Scheduler, (&scheduler.ASHA{MinStep: 2, Eta: 4}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMedianStopping(t *testing.T) {
+	studies, err := script.Load("testdata/median_stopping.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := studies[0].Scheduler, (&scheduler.MedianStopping{MinStep: 5, MinRuns: 4}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := studies[0].Scheduler.(diviner.CompletionObserver); !ok {
+		t.Error("median stopping is not a completion observer")
+	}
+}
+
+func TestStopWhen(t *testing.T) {
+	studies, err := script.Load("testdata/stop.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	target := 0.1
+	want := diviner.StopConditions{
+		Target:      &target,
+		MaxTrials:   100,
+		MaxDuration: 12 * time.Hour,
+		MaxRuntime:  100 * time.Hour,
+		Patience:    20,
+	}
+	if got := studies[0].Stop; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 func TestLoad(t *testing.T) {
 	studies, err := script.Load("testdata/load.dv", nil)
 	if err != nil {
@@ -225,3 +978,97 @@ func TestLoadCycle(t *testing.T) {
 func dequal(v, w diviner.Value) bool {
 	return !v.Less(w) && !w.Less(v)
 }
+
+func TestImage(t *testing.T) {
+	studies, err := script.Load("testdata/image.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, c := range []struct {
+		framework, image string
+	}{
+		{"torch1.4", "pytorch/pytorch:1.4"},
+		{"torch1.5", "pytorch/pytorch:1.5"},
+	} {
+		config, err := studies[0].Run(diviner.Values{"framework": diviner.String(c.framework)}, 0, "image:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := config.Image, c.image; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestEnv(t *testing.T) {
+	studies, err := script.Load("testdata/env.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := studies[0].Run(diviner.Values{"lr": diviner.Float(0.01)}, 0, "env:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Env, map[string]string{"LEARNING_RATE": "0.01", "RUN_ID": "env:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, env := range []string{`{"DIVINER_CACHE": "x"}`, `{"1X": "x"}`, `{"X": 1}`} {
+		_, err := script.Load("test.dv", `run_config(system=localsystem("local"), script="true", env=`+env+`)`)
+		if err == nil {
+			t.Errorf("%s: expected error", env)
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	studies, err := script.Load("test.dv", `
+study(
+    name="seed",
+    objective=maximize("acc"),
+    params={"lr": discrete(0.1, 0.01)},
+    run=lambda values, replicate: run_config(
+        system=localsystem("local"),
+        script="python train.py",
+        seed=replicate+1,
+    ),
+)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := studies[0].Run(diviner.Values{"lr": diviner.Float(0.01)}, 2, "seed:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Seed, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, seed := range []string{"-1", "2147483648"} {
+		_, err := script.Load("test.dv", `run_config(system=localsystem("local"), script="true", seed=`+seed+`)`)
+		if err == nil {
+			t.Errorf("%s: expected error", seed)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	studies, err := script.Load("testdata/template.dv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := diviner.Values{
+		"lr":        diviner.Float(0.01),
+		"optimizer": diviner.String("adam w"),
+		"layers":    diviner.List{diviner.Int(64), diviner.Int(128)},
+	}
+	config, err := studies[0].Run(values, 0, "template:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, `python train.py --lr=0.01 --optimizer='adam w' --layers 64 128 --run=template:1 --tag='it'\''s'`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package script
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+	"go.starlark.net/starlark"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LoadYAML loads studies from a YAML study specification, for simple
+// studies that do not need the full generality of Starlark. Arguments
+// are as in Load: if src is not nil, it must be a byte source
+// (string, []byte, or io.Reader); if src is nil, the specification is
+// read from the provided filename.
+//
+// A specification defines a list of systems and a list of studies.
+// Each study is a map whose keys are the arguments of the Starlark
+// study builtin; the keys run, objective, oracle, scheduler, stop,
+// fidelity, and notify, as well as each parameter in params, are
+// given as calls to the package's builtins: either the name of a
+// builtin, which is called without arguments, or a map with a single
+// key naming the builtin, whose value provides its arguments. A list
+// value gives positional arguments, a map gives keyword arguments, and
+// any other value gives a single positional argument. Arguments may
+// themselves be calls. Systems are given as calls, too. For example:
+//
+//	systems:
+//	  - localsystem: {name: local, parallelism: 4}
+//	studies:
+//	  - name: mnist
+//	    objective: {maximize: acc}
+//	    oracle: {random_search: 10}
+//	    params:
+//	      lr: {log_range: [0.0001, 0.1]}
+//	      optimizer: {discrete: [sgd, adam]}
+//	      momentum: {conditional: [{range: [0.0, 1.0]}, optimizer, sgd]}
+//	    run:
+//	      system: local
+//	      resources: {cpu: 2}
+//	      script: |
+//	        python train.py --lr={{lr}} --optimizer={{optimizer}} \
+//	          --momentum={{momentum}} --run={{id}}
+//
+// A study's run is a map of the arguments of run_config, except that
+// the system is named (by a system's name, or a list of names; it may
+// be omitted if only one system is defined), and that retry,
+// resources, and checkpoint are given as the arguments of their
+// respective builtins. The run's script is a template (see
+// diviner.Template) in which {{name}} is replaced by the value of the
+// parameter name, quoted for Bash; {{id}} and {{replicate}} are
+// replaced by the run's ID and replicate number. Parameters that are
+// inactive in a run are replaced by the empty string. The values of
+// the run's environment variables (env) are templates, too, whose
+// values are substituted without quoting:
+//
+//	env:
+//	  LEARNING_RATE: "{{lr}}"
+//	  RUN_ID: "{{id}}"
+//
+// Scripts find the run's seed (see diviner.RunConfig.Seed) in the
+// environment variable DIVINER_SEED, e.g., --seed=$DIVINER_SEED; a
+// fixed seed is given by the run's seed key.
+func LoadYAML(filename string, src interface{}) ([]diviner.Study, error) {
+	var (
+		p   []byte
+		err error
+	)
+	switch src := src.(type) {
+	case nil:
+		p, err = ioutil.ReadFile(filename)
+	case string:
+		p = []byte(src)
+	case []byte:
+		p = src
+	case io.Reader:
+		p, err = ioutil.ReadAll(src)
+	default:
+		return nil, fmt.Errorf("%s: invalid source type %T", filename, src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var spec struct {
+		Systems []interface{}            `yaml:"systems"`
+		Studies []map[string]interface{} `yaml:"studies"`
+	}
+	if err := yaml.UnmarshalStrict(p, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	thread := &starlark.Thread{
+		Name:  "diviner",
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("%s: %s", filename, msg) },
+	}
+	var studies []diviner.Study
+	thread.SetLocal("studies", &studies)
+	systems := make(map[string]*diviner.System)
+	var systemNames []string
+	for i, s := range spec.Systems {
+		val, err := yamlCall(thread, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: system %d: %v", filename, i, err)
+		}
+		system, ok := val.(*diviner.System)
+		if !ok {
+			return nil, fmt.Errorf("%s: system %d: %s is not a system", filename, i, val)
+		}
+		if _, ok := systems[system.ID]; ok {
+			return nil, fmt.Errorf("%s: system %s defined multiple times", filename, system.ID)
+		}
+		systems[system.ID] = system
+		systemNames = append(systemNames, system.ID)
+	}
+	// The study builtin requires a run function; the studies' run
+	// functions are replaced below by ones that render the run
+	// templates.
+	globals, err := starlark.ExecFile(thread, filename, "run = lambda values: None", nil)
+	if err != nil {
+		return nil, err
+	}
+	placeholder := globals["run"]
+	for i, s := range spec.Studies {
+		name, _ := s["name"].(string)
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		run, ok := s["run"]
+		if !ok {
+			return nil, fmt.Errorf("%s: study %s: missing run", filename, name)
+		}
+		kwargs := []starlark.Tuple{{starlark.String("run"), placeholder}}
+		for _, key := range sortedKeys(s) {
+			var val starlark.Value
+			switch key {
+			case "run":
+				continue
+			case "objective", "notify":
+				val, err = yamlCalls(thread, s[key])
+			case "oracle", "scheduler", "stop", "fidelity":
+				val, err = yamlCall(thread, s[key])
+			case "params":
+				val, err = yamlParams(thread, s[key])
+			default:
+				val, err = yamlValue(thread, s[key], false)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: study %s: %s: %v", filename, name, key, err)
+			}
+			kwargs = append(kwargs, starlark.Tuple{starlark.String(key), val})
+		}
+		val, err := starlark.Call(thread, builtins["study"], nil, kwargs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: study %s: %v", filename, name, err)
+		}
+		study := val.(diviner.Study)
+		if study.Run, err = yamlRun(thread, study.Params, run, systems, systemNames); err != nil {
+			return nil, fmt.Errorf("%s: study %s: run: %v", filename, name, err)
+		}
+		study.Freeze()
+		studies[len(studies)-1] = study
+	}
+	return studies, nil
+}
+
+// YamlRun returns a study's run function from its YAML run
+// specification. The run config is constructed once, with the
+// template as its script; the returned function renders the
+// template into the script of each run.
+func yamlRun(thread *starlark.Thread, params diviner.Params, spec interface{}, systems map[string]*diviner.System, systemNames []string) (func(diviner.Values, int, string) (diviner.RunConfig, error), error) {
+	m, ok := stringMap(spec)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a map", spec)
+	}
+	text, ok := m["script"].(string)
+	if !ok {
+		return nil, errors.New("missing script")
+	}
+	tmpl := diviner.NewTemplate(text)
+	if err := tmpl.Check(params); err != nil {
+		return nil, fmt.Errorf("script: %v", err)
+	}
+	if env, ok := stringMap(m["env"]); ok {
+		for _, name := range sortedKeys(env) {
+			if err := diviner.NewTemplate(fmt.Sprint(env[name])).Check(params); err != nil {
+				return nil, fmt.Errorf("env %s: %v", name, err)
+			}
+		}
+	}
+	var system starlark.Value
+	switch names := m["system"].(type) {
+	case nil:
+		if len(systemNames) != 1 {
+			return nil, errors.New("missing system")
+		}
+		system = systems[systemNames[0]]
+	case string:
+		s, ok := systems[names]
+		if !ok {
+			return nil, fmt.Errorf("undefined system %s", names)
+		}
+		system = s
+	case []interface{}:
+		list := make([]starlark.Value, len(names))
+		for i, name := range names {
+			s, ok := systems[fmt.Sprint(name)]
+			if !ok {
+				return nil, fmt.Errorf("undefined system %v", name)
+			}
+			list[i] = s
+		}
+		system = starlark.NewList(list)
+	default:
+		return nil, fmt.Errorf("system %v is not a system name or a list of system names", names)
+	}
+	kwargs := []starlark.Tuple{{starlark.String("system"), system}}
+	for _, key := range sortedKeys(m) {
+		var (
+			val starlark.Value
+			err error
+		)
+		switch key {
+		case "system":
+			continue
+		case "retry", "resources", "checkpoint":
+			val, err = yamlCall(thread, map[interface{}]interface{}{key: m[key]})
+		default:
+			val, err = yamlValue(thread, m[key], true)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		kwargs = append(kwargs, starlark.Tuple{starlark.String(key), val})
+	}
+	val, err := starlark.Call(thread, builtins["run_config"], nil, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	config := val.(diviner.RunConfig)
+	return func(values diviner.Values, replicate int, id string) (diviner.RunConfig, error) {
+		config := config
+		config.Script = tmpl.Execute(values, replicate, id)
+		if config.Env != nil {
+			env := make(map[string]string, len(config.Env))
+			for name, value := range config.Env {
+				env[name] = diviner.NewTemplate(value).Expand(values, replicate, id)
+			}
+			config.Env = env
+		}
+		return config, nil
+	}, nil
+}
+
+// YamlParams converts a YAML map of parameter calls into a
+// Starlark dictionary of parameters.
+func yamlParams(thread *starlark.Thread, spec interface{}) (starlark.Value, error) {
+	m, ok := stringMap(spec)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a map", spec)
+	}
+	params := new(starlark.Dict)
+	for _, key := range sortedKeys(m) {
+		val, err := yamlCall(thread, m[key])
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %v", key, err)
+		}
+		if err := params.SetKey(starlark.String(key), val); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// YamlCalls interprets a call, or a list of calls, returning a
+// Starlark list in the latter case.
+func yamlCalls(thread *starlark.Thread, spec interface{}) (starlark.Value, error) {
+	list, ok := spec.([]interface{})
+	if !ok {
+		return yamlCall(thread, spec)
+	}
+	vals := make([]starlark.Value, len(list))
+	for i, elem := range list {
+		var err error
+		if vals[i], err = yamlCall(thread, elem); err != nil {
+			return nil, err
+		}
+	}
+	return starlark.NewList(vals), nil
+}
+
+// YamlCall interprets a YAML call specification: either the name of
+// a builtin, or a single-key map from the name of a builtin to its
+// arguments.
+func yamlCall(thread *starlark.Thread, spec interface{}) (starlark.Value, error) {
+	var (
+		name string
+		args interface{}
+	)
+	switch spec := spec.(type) {
+	case string:
+		name = spec
+		val, ok := builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined builtin %s", name)
+		}
+		if _, ok := val.(*starlark.Builtin); !ok {
+			return val, nil
+		}
+	case map[interface{}]interface{}:
+		if len(spec) != 1 {
+			return nil, fmt.Errorf("call %v must have exactly one key", spec)
+		}
+		for key, val := range spec {
+			name, args = fmt.Sprint(key), val
+		}
+	default:
+		return nil, fmt.Errorf("%v is not a call", spec)
+	}
+	fn, ok := builtins[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined builtin %s", name)
+	}
+	var (
+		posargs starlark.Tuple
+		kwargs  []starlark.Tuple
+	)
+	switch args := args.(type) {
+	case nil:
+	case []interface{}:
+		for _, arg := range args {
+			val, err := yamlValue(thread, arg, true)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			posargs = append(posargs, val)
+		}
+	case map[interface{}]interface{}:
+		m, ok := stringMap(args)
+		if !ok {
+			return nil, fmt.Errorf("%s: arguments %v are not keyed by strings", name, args)
+		}
+		for _, key := range sortedKeys(m) {
+			val, err := yamlValue(thread, m[key], true)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", name, key, err)
+			}
+			kwargs = append(kwargs, starlark.Tuple{starlark.String(key), val})
+		}
+	default:
+		val, err := yamlValue(thread, args, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		posargs = starlark.Tuple{val}
+	}
+	return starlark.Call(thread, fn, posargs, kwargs)
+}
+
+// YamlValue converts a decoded YAML value to a Starlark value. If
+// calls is true, single-key maps whose key names a builtin are
+// interpreted as calls.
+func yamlValue(thread *starlark.Thread, v interface{}, calls bool) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		vals := make([]starlark.Value, len(v))
+		for i, elem := range v {
+			var err error
+			if vals[i], err = yamlValue(thread, elem, calls); err != nil {
+				return nil, err
+			}
+		}
+		return starlark.NewList(vals), nil
+	case map[interface{}]interface{}:
+		if calls && len(v) == 1 {
+			for key := range v {
+				if name, ok := key.(string); ok && builtins[name] != nil {
+					return yamlCall(thread, v)
+				}
+			}
+		}
+		m, ok := stringMap(v)
+		if !ok {
+			return nil, fmt.Errorf("map %v is not keyed by strings", v)
+		}
+		dict := new(starlark.Dict)
+		for _, key := range sortedKeys(m) {
+			val, err := yamlValue(thread, m[key], calls)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(key), val); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v of type %T", v, v)
+	}
+}
+
+// StringMap returns the provided YAML map with its keys as strings.
+// StringMap returns false if v is not a map, or if any of its keys
+// are not strings.
+func stringMap(v interface{}) (map[string]interface{}, bool) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+	sm := make(map[string]interface{}, len(m))
+	for key, val := range m {
+		str, ok := key.(string)
+		if !ok {
+			return nil, false
+		}
+		sm[str] = val
+	}
+	return sm, true
+}
+
+// SortedKeys returns the keys of the provided map in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package script_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/oracle"
+	"github.com/grailbio/diviner/script"
+)
+
+func TestLoadYAML(t *testing.T) {
+	studies, err := script.LoadYAML("testdata/study.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(studies), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	study := studies[0]
+	if got, want := study.Name, "mnist"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Objective, (diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Oracle, diviner.Oracle(&oracle.RandomSearch{N: 8}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Replicates, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Timeout, 2*time.Hour; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Aggregate["acc"], (diviner.Aggregation{Reduce: diviner.ReduceMax}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := study.Constraints, []diviner.Constraint{{Metric: "latency", Op: "<", Value: 50}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	params := diviner.Params{
+		"lr":        diviner.NewLogRange(diviner.Float(0.0001), diviner.Float(0.1)),
+		"layers":    diviner.NewRange(diviner.Int(1), diviner.Int(4)),
+		"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+		"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+		"size":      diviner.NewEnum("small", "medium", "large"),
+	}
+	if got, want := study.Params, params; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	values := diviner.Values{
+		"lr":        diviner.Float(0.01),
+		"layers":    diviner.Int(2),
+		"optimizer": diviner.String("adam"),
+		"size":      diviner.String("medium"),
+	}
+	config, err := study.Run(values, 1, "mnist/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "python train.py --lr=0.01 --layers=2 --size=medium \\\n  --optimizer=adam --momentum= --run=mnist/3/1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := len(config.Systems), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := config.Systems[0].ID, "local"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := config.Systems[0].Parallelism, 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := config.Resources, (diviner.Resources{CPU: 2, Memory: 4 * data.GiB}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := config.Retry.MaxAttempts, 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := config.LocalFiles, []string{"train.py"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := config.Env, map[string]string{"LEARNING_RATE": "0.01", "DATA": "/data"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The template is rendered anew for each run.
+	values["optimizer"] = diviner.String("sgd")
+	values["momentum"] = diviner.Float(0.5)
+	config, err = study.Run(values, 0, "mnist/4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(config.Script, "--optimizer=sgd --momentum=0.5 --run=mnist/4/0") {
+		t.Errorf("bad script %q", config.Script)
+	}
+
+	grid := studies[1]
+	if got, want := grid.Oracle, diviner.Oracle(&oracle.GridSearch{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(grid.Objectives), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := grid.Objectives[0], (diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	config, err = grid.Run(diviner.Values{"batch": diviner.Int(64)}, 0, "grid/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.Script, "echo 64"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := config.Seed, int64(7); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadYAMLErrors(t *testing.T) {
+	const prefix = `
+systems:
+  - localsystem: {name: local}
+studies:
+  - name: test
+    objective: {minimize: loss}
+    params:
+      x: {range: [0, 10]}
+`
+	for _, test := range []struct {
+		spec, want string
+	}{
+		{`    run: {script: "echo {{y}}"}`, "script: template refers to undefined parameter y"},
+		{`    run: {script: echo, env: {Z: "{{y}}"}}`, "env Z: template refers to undefined parameter y"},
+		{"    run: {script: echo, env: {DIVINER_X: x}}", "variable DIVINER_X is reserved"},
+		{"    run: {script: echo, env: {X-Y: x}}", `"X-Y" is not a valid variable name`},
+		{"    run: {script: echo, env: {X: 1}}", "is not a string"},
+		{"    run: {script: echo, seed: -1}", "seed -1 is out of range"},
+		{"    run: {script: echo, system: remote}", "undefined system remote"},
+		{"    run: {system: local}", "missing script"},
+		{"    oracle: bogus\n    run: {script: echo}", "undefined builtin bogus"},
+		{"    run: {script: echo, resources: {cpu: -1}}", "negative resources not allowed"},
+		{"    bogus: 1\n    run: {script: echo}", "unexpected keyword argument"},
+		{"", "missing run"},
+	} {
+		_, err := script.LoadYAML("test.yaml", prefix+test.spec)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %v", test.spec, err, test.want)
+		}
+	}
+	if _, err := script.LoadYAML("test.yaml", "studes: []"); err == nil {
+		t.Error("expected error")
+	}
+}
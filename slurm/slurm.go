@@ -0,0 +1,411 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package slurm implements a diviner.Backend that runs scripts as
+// Slurm batch jobs, as found on many HPC clusters. The backend drives
+// the cluster through Slurm's command line tools (sbatch, squeue,
+// sacct, and scancel), which must be installed and configured on the
+// host running diviner, typically a cluster's login node.
+//
+// Each script is run by a batch script in a fresh job directory,
+// into which the job's files are written. Job directories must be on
+// a file system that is shared with the cluster's compute nodes.
+// The job's resource requirements are translated into #SBATCH
+// directives by a template (see DefaultDirectives), which may be
+// replaced to suit a cluster's conventions. The job's combined
+// standard output and standard error are written to a file in the
+// job directory, which is streamed back to the caller as the job's
+// output.
+//
+// Jobs are monitored with squeue while they are queued or running,
+// and their final states and exit codes are retrieved with sacct.
+// Canceled jobs are cancelled with scancel. Job directories are
+// removed once their jobs complete, unless the backend keeps them.
+package slurm
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner"
+)
+
+// DefaultDirectives is the template of the resource directives of
+// jobs' batch scripts that is used unless a backend provides its own.
+// Templates are executed with a DirectiveData.
+const DefaultDirectives = `{{if .CPU}}#SBATCH --cpus-per-task={{.CPU}}
+{{end}}{{if .Memory}}#SBATCH --mem={{.Memory}}M
+{{end}}{{if .GPU}}#SBATCH --gres=gpu:{{.GPU}}
+{{end}}`
+
+const (
+	// outputFile is the name of the file in the job directory to
+	// which the job's output is written.
+	outputFile = "slurm.out"
+	// scriptFile is the name of the job's batch script.
+	scriptFile = "job.sh"
+
+	defaultPollInterval = 10 * time.Second
+	// accountingTimeout is the amount of time to wait for the
+	// accounting records of jobs that have left the queue.
+	accountingTimeout = 2 * time.Minute
+)
+
+func init() {
+	gob.Register(new(Backend))
+}
+
+// DirectiveData is the data with which directive templates are
+// executed.
+type DirectiveData struct {
+	// Name is the name of the diviner job.
+	Name string
+	// CPU is the number of CPUs required by the job, or 0 if
+	// unspecified.
+	CPU int
+	// Memory is the amount of memory required by the job, in
+	// mebibytes, or 0 if unspecified.
+	Memory int64
+	// GPU is the number of GPUs required by the job.
+	GPU int
+}
+
+// Backend implements diviner.Backend by running jobs on a Slurm
+// cluster.
+type Backend struct {
+	// Dir is the directory in which the jobs' directories are
+	// created. It must be shared with the cluster's compute nodes.
+	// Defaults to $HOME/.diviner/slurm.
+	Dir string
+	// Partition is the partition to which jobs are submitted. If
+	// empty, the cluster's default partition is used.
+	Partition string
+	// Account is the account to which jobs' resource usage is
+	// charged. If empty, the user's default account is used.
+	Account string
+	// Directives is the text/template of the #SBATCH directives of
+	// jobs' batch scripts, with which the jobs' resources are
+	// requested. Defaults to DefaultDirectives.
+	Directives string
+	// Bin is the directory containing Slurm's command line tools. If
+	// empty, they are looked up in $PATH.
+	Bin string
+	// Keep retains the jobs' directories after they complete, so
+	// that their outputs may be inspected.
+	Keep bool
+	// PollInterval is the interval at which jobs' states and outputs
+	// are polled. Defaults to 10 seconds.
+	PollInterval time.Duration
+}
+
+var _ diviner.Backend = (*Backend)(nil)
+
+// Run implements diviner.Backend. It submits the job's batch script
+// with sbatch and returns a reader of its output. Reads return an
+// error, carrying the script's exit code, if the job failed.
+func (b *Backend) Run(ctx context.Context, job diviner.Job) (io.ReadCloser, error) {
+	if job.Image != "" {
+		return nil, fmt.Errorf("slurm: job %s: container images are not supported", job.Name)
+	}
+	script, err := b.script(job)
+	if err != nil {
+		return nil, err
+	}
+	root := b.Dir
+	if root == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("slurm: %v", err)
+		}
+		root = filepath.Join(home, ".diviner", "slurm")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("slurm: %v", err)
+	}
+	name := jobName(job.Name)
+	dir, err := ioutil.TempDir(root, name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("slurm: create job directory: %v", err)
+	}
+	files := map[string][]byte{scriptFile: []byte(script)}
+	for file, p := range job.Files {
+		files[file] = p
+	}
+	for file, p := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), p, 0644); err != nil {
+			b.cleanup(dir)
+			return nil, fmt.Errorf("slurm: write file %s: %v", file, err)
+		}
+	}
+	args := []string{
+		"--parsable",
+		"--job-name=" + name,
+		"--output=" + filepath.Join(dir, outputFile),
+		"--chdir=" + dir,
+	}
+	if b.Partition != "" {
+		args = append(args, "--partition="+b.Partition)
+	}
+	if b.Account != "" {
+		args = append(args, "--account="+b.Account)
+	}
+	out, err := b.command(ctx, "sbatch", append(args, filepath.Join(dir, scriptFile))...)
+	if err != nil {
+		b.cleanup(dir)
+		return nil, fmt.Errorf("slurm: submit job %s: %v", job.Name, err)
+	}
+	// Parsable output is of the form "id[;cluster]".
+	id := strings.TrimSpace(strings.SplitN(out, ";", 2)[0])
+	if id == "" {
+		b.cleanup(dir)
+		return nil, fmt.Errorf("slurm: submit job %s: sbatch returned no job ID", job.Name)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r, w := io.Pipe()
+	go func() {
+		defer cancel()
+		err := b.wait(ctx, job.Name, id, filepath.Join(dir, outputFile), w)
+		if ctx.Err() != nil {
+			b.cancel(id)
+		}
+		b.cleanup(dir)
+		w.CloseWithError(err)
+	}()
+	return &outputReader{r, cancel}, nil
+}
+
+// Script returns the batch script that runs the provided job.
+func (b *Backend) script(job diviner.Job) (string, error) {
+	text := b.Directives
+	if text == "" {
+		text = DefaultDirectives
+	}
+	tmpl, err := template.New("directives").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("slurm: parse directives: %v", err)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("#!/bin/bash\n")
+	directives := DirectiveData{
+		Name:   job.Name,
+		CPU:    job.Resources.CPU,
+		Memory: int64((job.Resources.Memory + data.MiB - 1) / data.MiB),
+		GPU:    job.Resources.GPU,
+	}
+	if err := tmpl.Execute(&buf, directives); err != nil {
+		return "", fmt.Errorf("slurm: job %s: execute directives: %v", job.Name, err)
+	}
+	if n := buf.Len(); buf.Bytes()[n-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	for _, kv := range job.Env {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		fmt.Fprintf(&buf, "export %s=%s\n", kv[0], quote(kv[1]))
+	}
+	buf.WriteString(job.Script)
+	buf.WriteByte('\n')
+	return buf.String(), nil
+}
+
+// Wait polls the job with the provided ID until it completes,
+// streaming its output file to w. It returns an error if the job
+// failed.
+func (b *Backend) wait(ctx context.Context, name, id, output string, w io.Writer) error {
+	poll := b.PollInterval
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+	var (
+		out  *os.File
+		gone time.Time
+	)
+	defer func() {
+		if out != nil {
+			out.Close()
+		}
+	}()
+	for {
+		state := b.queueState(ctx, id)
+		var code string
+		if state == "" || finished(state) {
+			accounted, accountedCode, err := b.accountingState(ctx, id)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				log.Error.Printf("slurm: sacct %s: %v", id, err)
+			case accounted != "":
+				state, code = accounted, accountedCode
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Output is copied after the job's state is retrieved, so that
+		// the job's complete output is copied before it is reported
+		// as done. The output file is created once the job starts.
+		if out == nil {
+			out, _ = os.Open(output)
+		}
+		if out != nil {
+			if _, err := io.Copy(w, out); err != nil {
+				return err
+			}
+		}
+		switch {
+		case state == "COMPLETED":
+			return nil
+		case finished(state):
+			if code != "" {
+				return fmt.Errorf("job %s failed with exit code %s: %s", name, code, strings.ToLower(state))
+			}
+			return fmt.Errorf("job %s failed: %s", name, strings.ToLower(state))
+		case state == "":
+			// The job has left the queue, but its accounting record is
+			// not (yet) available.
+			if gone.IsZero() {
+				gone = time.Now()
+			} else if time.Since(gone) > accountingTimeout {
+				return fmt.Errorf("slurm: job %s (%s): state unknown", name, id)
+			}
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// QueueState returns the state of the job with the provided ID, as
+// reported by squeue, or an empty string if the job is not in the
+// queue. Squeue fails for jobs that have been purged from the queue;
+// such failures are not distinguished from jobs that are not queued.
+func (b *Backend) queueState(ctx context.Context, id string) string {
+	out, err := b.command(ctx, "squeue", "--noheader", "--format=%T", "--jobs="+id)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// AccountingState returns the state and exit code of the job with
+// the provided ID, as reported by sacct. It returns an empty state if
+// the job has no accounting record.
+func (b *Backend) accountingState(ctx context.Context, id string) (state, code string, err error) {
+	out, err := b.command(ctx, "sacct", "--noheader", "--parsable2", "--allocations", "--format=State,ExitCode", "--jobs="+id)
+	if err != nil {
+		return "", "", err
+	}
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(out), "\n", 2)[0])
+	if line == "" {
+		return "", "", nil
+	}
+	parts := strings.SplitN(line, "|", 2)
+	// States may be qualified, as in "CANCELLED by 1000".
+	if fields := strings.Fields(parts[0]); len(fields) > 0 {
+		state = fields[0]
+	}
+	if len(parts) > 1 {
+		// Exit codes are of the form "code:signal".
+		code = strings.SplitN(parts[1], ":", 2)[0]
+	}
+	return state, code, nil
+}
+
+// Finished tells whether the provided job state is terminal.
+func finished(state string) bool {
+	switch state {
+	case "COMPLETED", "FAILED", "CANCELLED", "TIMEOUT", "OUT_OF_MEMORY",
+		"NODE_FAIL", "PREEMPTED", "BOOT_FAIL", "DEADLINE":
+		return true
+	}
+	return false
+}
+
+// Cancel cancels the job with the provided ID. Cancel is called for
+// jobs whose context is done, and so uses a fresh context.
+func (b *Backend) cancel(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := b.command(ctx, "scancel", id); err != nil {
+		log.Error.Printf("slurm: scancel %s: %v", id, err)
+	}
+}
+
+// Cleanup removes the provided job directory, unless the backend
+// keeps them.
+func (b *Backend) cleanup(dir string) {
+	if b.Keep {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Error.Printf("slurm: remove %s: %v", dir, err)
+	}
+}
+
+// Command runs the named Slurm tool with the provided arguments,
+// returning its standard output.
+func (b *Backend) command(ctx context.Context, name string, args ...string) (string, error) {
+	path := name
+	if b.Bin != "" {
+		path = filepath.Join(b.Bin, name)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return stdout.String(), nil
+}
+
+// Quote quotes the provided string for Bash.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// JobName returns a Slurm job name derived from the provided job
+// name, with unusual characters replaced.
+func jobName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '-'
+		}
+	}, name)
+	if name == "" {
+		name = "diviner"
+	}
+	return name
+}
+
+type outputReader struct {
+	*io.PipeReader
+	cancel func()
+}
+
+func (r *outputReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package slurm_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/data"
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/slurm"
+	"github.com/grailbio/testutil"
+)
+
+// FakeTools are replacements for Slurm's command line tools. The fake
+// sbatch records its arguments and batch script, and runs the script
+// to completion; squeue reports that the job has left the queue, and
+// sacct reports the script's exit code. If the file "hold" exists,
+// the job is instead reported as running until it is cancelled.
+var fakeTools = map[string]string{
+	"sbatch": `#!/bin/bash
+dir=%s
+echo "$@" > $dir/sbatch.args
+for arg; do
+	case "$arg" in
+	--output=*) output=${arg#--output=} ;;
+	--chdir=*) chdir=${arg#--chdir=} ;;
+	esac
+	script=$arg
+done
+cp $script $dir/job.sh
+if [ -e $dir/hold ]; then
+	echo started > $output
+else
+	(cd $chdir && bash $script > $output 2>&1; echo $? > $dir/code)
+fi
+echo "42;cluster"
+`,
+	"squeue": `#!/bin/bash
+dir=%s
+if [ -e $dir/hold ]; then echo RUNNING; fi
+`,
+	"sacct": `#!/bin/bash
+dir=%s
+if [ -e $dir/hold ]; then echo "RUNNING|0:0"; exit; fi
+code=$(cat $dir/code)
+if [ $code = 0 ]; then echo "COMPLETED|0:0"; else echo "FAILED|$code:0"; fi
+`,
+	"scancel": `#!/bin/bash
+dir=%s
+echo "$@" > $dir/scancel.args
+rm -f $dir/hold
+`,
+}
+
+func newBackend(t *testing.T) (b *slurm.Backend, dir string, cleanup func()) {
+	t.Helper()
+	dir, cleanup = testutil.TempDir(t, "", "")
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, tool := range fakeTools {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(fmt.Sprintf(tool, dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs := filepath.Join(dir, "jobs")
+	b = &slurm.Backend{
+		Dir:          jobs,
+		Partition:    "gpu",
+		Bin:          bin,
+		PollInterval: time.Millisecond,
+	}
+	return b, dir, cleanup
+}
+
+func TestBackend(t *testing.T) {
+	b, dir, cleanup := newBackend(t)
+	defer cleanup()
+	job := diviner.Job{
+		Name:      "study=test,seq=1",
+		Script:    "cat data.txt; echo METRICS: count=$DIVINER_TEST_COUNT",
+		Env:       []string{"DIVINER_TEST_COUNT=3"},
+		Files:     map[string][]byte{"data.txt": []byte("hello world\n")},
+		Resources: diviner.Resources{CPU: 4, Memory: 2 * data.GiB, GPU: 1},
+	}
+	out, err := b.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), "hello world\nMETRICS: count=3\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	script, err := ioutil.ReadFile(filepath.Join(dir, "job.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, directive := range []string{
+		"#SBATCH --cpus-per-task=4\n",
+		"#SBATCH --mem=2048M\n",
+		"#SBATCH --gres=gpu:1\n",
+	} {
+		if !strings.Contains(string(script), directive) {
+			t.Errorf("script %q is missing directive %q", script, directive)
+		}
+	}
+	args, err := ioutil.ReadFile(filepath.Join(dir, "sbatch.args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(args), "--partition=gpu") || !strings.Contains(string(args), "--job-name=study-test-seq-1") {
+		t.Errorf("bad sbatch arguments %q", args)
+	}
+	// Job directories are removed once their jobs complete.
+	infos, err := ioutil.ReadDir(b.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(infos), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBackendDirectives(t *testing.T) {
+	b, dir, cleanup := newBackend(t)
+	defer cleanup()
+	b.Directives = "#SBATCH --time=1:00:00\n{{if .GPU}}#SBATCH --gpus={{.GPU}}{{end}}"
+	out, err := b.Run(context.Background(), diviner.Job{Name: "test", Script: "true", Resources: diviner.Resources{GPU: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(out); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	script, err := ioutil.ReadFile(filepath.Join(dir, "job.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(script), "#!/bin/bash\n#SBATCH --time=1:00:00\n#SBATCH --gpus=2\ntrue\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBackendFailure(t *testing.T) {
+	b, _, cleanup := newBackend(t)
+	defer cleanup()
+	out, err := b.Run(context.Background(), diviner.Job{Name: "test", Script: "echo failing; exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	p, err := ioutil.ReadAll(out)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := string(p), "failing\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := err.Error(), "job test failed with exit code 3: failed"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBackendCancel(t *testing.T) {
+	b, dir, cleanup := newBackend(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(filepath.Join(dir, "hold"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	out, err := b.Run(ctx, diviner.Job{Name: "test", Script: "sleep 100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, len("started\n"))
+	if _, err := out.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(out); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	args, err := ioutil.ReadFile(filepath.Join(dir, "scancel.args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(args), "42\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBackendImage(t *testing.T) {
+	b, _, cleanup := newBackend(t)
+	defer cleanup()
+	if _, err := b.Run(context.Background(), diviner.Job{Name: "test", Image: "ubuntu"}); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// MaxSourceDiff is the maximum size of the diff recorded by
+// CaptureSource. Larger diffs are truncated.
+const MaxSourceDiff = 1 << 20
+
+// A Source describes the code from which runs were produced: the
+// state of the git repository that contains the study's script, as
+// of the time the runs were created, and a digest of the script
+// itself. Sources are recorded with runs (see Run.Source), so that
+// any result can be traced back to the code that produced it.
+type Source struct {
+	// Repository is the URL of the repository's "origin" remote, if
+	// any; otherwise the path of its working tree.
+	Repository string `json:"repository,omitempty"`
+	// Commit is the SHA of the repository's checked out commit.
+	Commit string `json:"commit,omitempty"`
+	// Dirty tells whether the working tree had changes, including
+	// untracked files, that were not committed.
+	Dirty bool `json:"dirty,omitempty"`
+	// Diff is the diff of the working tree's tracked files against
+	// the commit, truncated to MaxSourceDiff bytes. It is empty if
+	// the working tree was clean.
+	Diff string `json:"diff,omitempty"`
+	// StudyDigest is the SHA-256 digest, in hex, of the script
+	// (e.g., a Starlark or YAML file) that defined the study.
+	StudyDigest string `json:"study_digest,omitempty"`
+}
+
+// String returns an abbreviated description of the source, e.g.,
+// "1f2e3d4c5b6a (dirty)".
+func (s Source) String() string {
+	var b strings.Builder
+	b.WriteString(abbrev(s.Commit, 12))
+	if s.Dirty {
+		b.WriteString(" (dirty)")
+	}
+	if s.StudyDigest != "" {
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString("study:" + abbrev(s.StudyDigest, 12))
+	}
+	return b.String()
+}
+
+// CaptureSource returns the source of the studies defined by the
+// script at the provided path: the state of the git repository that
+// contains it, and the script's digest. If the script is not in a
+// git repository, or git is not installed, only the digest is
+// recorded.
+func CaptureSource(path string) (Source, error) {
+	var (
+		source Source
+		err    error
+	)
+	if source.StudyDigest, err = digestFile(path); err != nil {
+		return Source{}, err
+	}
+	dir := filepath.Dir(path)
+	commit, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		// Not a repository (or a repository without commits).
+		return source, nil
+	}
+	source.Commit = strings.TrimSpace(commit)
+	if url, err := git(dir, "config", "--get", "remote.origin.url"); err == nil {
+		source.Repository = strings.TrimSpace(url)
+	} else if top, err := git(dir, "rev-parse", "--show-toplevel"); err == nil {
+		source.Repository = strings.TrimSpace(top)
+	}
+	status, err := git(dir, "status", "--porcelain")
+	if err != nil {
+		return Source{}, err
+	}
+	if source.Dirty = strings.TrimSpace(status) != ""; !source.Dirty {
+		return source, nil
+	}
+	if source.Diff, err = git(dir, "diff", "HEAD"); err != nil {
+		return Source{}, err
+	}
+	if len(source.Diff) > MaxSourceDiff {
+		source.Diff = source.Diff[:MaxSourceDiff]
+	}
+	return source, nil
+}
+
+// Git runs git with the provided arguments in dir, and returns its
+// standard output.
+func git(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func abbrev(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
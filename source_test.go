@@ -0,0 +1,83 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestCaptureSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "study.dv")
+	if err := ioutil.WriteFile(path, []byte("study()\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Outside of a repository, only the digest is captured.
+	source, err := diviner.CaptureSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := source, (diviner.Source{StudyDigest: source.StudyDigest}); got != want || len(source.StudyDigest) != 64 {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	digest := source.StudyDigest
+
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("add", "study.dv")
+	git("commit", "-q", "-m", "study")
+	git("remote", "add", "origin", "https://example.com/repo.git")
+	commit := git("rev-parse", "HEAD")
+	source, err = diviner.CaptureSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := diviner.Source{Repository: "https://example.com/repo.git", Commit: commit, StudyDigest: digest}
+	if got := source; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := source.String(), commit[:12]+" study:"+digest[:12]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("study(name=\"x\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source, err = diviner.CaptureSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !source.Dirty {
+		t.Error("expected dirty source")
+	}
+	if !strings.Contains(source.Diff, `+study(name="x")`) {
+		t.Errorf("bad diff %q", source.Diff)
+	}
+	if source.StudyDigest == digest {
+		t.Error("digest did not change")
+	}
+}
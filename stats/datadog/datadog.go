@@ -0,0 +1,113 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package datadog implements a stats.Sink that emits statistics to a
+// Datadog agent using the DogStatsD protocol [1]. Importing this
+// package registers the sink kind "datadog", configured with the
+// agent's address (default 127.0.0.1:8125):
+//
+//	datadog,127.0.0.1:8125
+//
+// [1] https://docs.datadoghq.com/developers/dogstatsd/datagram_shell/
+package datadog
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner/stats"
+)
+
+// DefaultAddr is the default address of the DogStatsD agent.
+const DefaultAddr = "127.0.0.1:8125"
+
+// Prefix is prepended to the names of all statistics emitted
+// by the sink.
+const Prefix = "diviner."
+
+func init() {
+	stats.Register("datadog", func(config string) (stats.Sink, error) {
+		if config == "" {
+			config = DefaultAddr
+		}
+		return Dial(config)
+	})
+}
+
+// Sink is a stats.Sink that writes DogStatsD datagrams to a UDP
+// address. Write errors are logged and otherwise ignored: statistics
+// are best-effort.
+type Sink struct {
+	conn net.Conn
+	// Tags are global tags added to every statistic.
+	Tags []string
+}
+
+// Dial returns a new Sink that sends datagrams to the agent at the
+// provided UDP address.
+func Dial(addr string) (*Sink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{conn: conn}, nil
+}
+
+// Gauge implements stats.Sink.
+func (s *Sink) Gauge(name string, value float64, tags ...string) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Count implements stats.Sink.
+func (s *Sink) Count(name string, delta int64, tags ...string) {
+	s.send(name, strconv.FormatInt(delta, 10), "c", tags)
+}
+
+// Timing implements stats.Sink. Durations are reported in
+// milliseconds.
+func (s *Sink) Timing(name string, d time.Duration, tags ...string) {
+	ms := float64(d) / float64(time.Millisecond)
+	s.send(name, strconv.FormatFloat(ms, 'f', -1, 64), "ms", tags)
+}
+
+// Close closes the sink's underlying connection.
+func (s *Sink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Sink) send(name, value, typ string, tags []string) {
+	var b bytes.Buffer
+	b.WriteString(Prefix)
+	b.WriteString(strings.Replace(sanitize(name), ":", "_", -1))
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if len(s.Tags)+len(tags) > 0 {
+		b.WriteString("|#")
+		all := append(append([]string{}, s.Tags...), tags...)
+		for i := range all {
+			all[i] = sanitize(all[i])
+		}
+		b.WriteString(strings.Join(all, ","))
+	}
+	if _, err := s.conn.Write(b.Bytes()); err != nil {
+		log.Debug.Printf("datadog: write %s: %v", b.String(), err)
+	}
+}
+
+// Sanitize replaces characters reserved by the DogStatsD protocol.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', '#', ',', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datadog_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner/stats"
+	"github.com/grailbio/diviner/stats/datadog"
+)
+
+func TestSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err := stats.Open("datadog," + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.(*datadog.Sink).Close()
+
+	sink.Gauge("runner.nworker", 3)
+	sink.Count("run.completed", 1, stats.Tag("study", "a|b"), stats.Tag("state", "success"))
+	sink.Timing("run.duration", 1500*time.Millisecond, stats.Tag("study", "test"))
+
+	for _, want := range []string{
+		"diviner.runner.nworker:3|g",
+		"diviner.run.completed:1|c|#study:a_b,state:success",
+		"diviner.run.duration:1500|ms|#study:test",
+	} {
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(p[:n]); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package prometheus implements a stats.Sink that exposes statistics
+// as Prometheus metrics, in the Prometheus text exposition format
+// [1]. Importing this package registers the sink kind "prometheus",
+// configured with the address of an HTTP listener on which metrics
+// are served at the path /metrics:
+//
+//	prometheus,:9464
+//
+// If no address is given, metrics are served at /metrics by
+// http.DefaultServeMux, e.g., on the diviner runner's diagnostic
+// HTTP server.
+//
+// Gauges are exposed as Prometheus gauges, counts as counters (with
+// the suffix "_total"), and timings as summaries (with the suffix
+// "_seconds"). Tags of the form "key:value" become labels.
+//
+// [1] https://prometheus.io/docs/instrumenting/exposition_formats/
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/diviner/stats"
+)
+
+// Prefix is prepended to the names of all metrics exposed by the
+// sink.
+const Prefix = "diviner_"
+
+// Path is the path at which metrics are served.
+const Path = "/metrics"
+
+func init() {
+	stats.Register("prometheus", func(config string) (stats.Sink, error) {
+		if config == "" {
+			s := New()
+			http.Handle(Path, s)
+			return s, nil
+		}
+		return Listen(config)
+	})
+}
+
+type kind int
+
+const (
+	gauge kind = iota
+	counter
+	summary
+)
+
+func (k kind) String() string {
+	switch k {
+	case gauge:
+		return "gauge"
+	case counter:
+		return "counter"
+	default:
+		return "summary"
+	}
+}
+
+// A family is a set of metrics with the same name, distinguished by
+// their labels.
+type family struct {
+	kind kind
+	// Series maps the (rendered) labels of each metric in the family
+	// to its value: the sum and count of samples, for summaries.
+	series map[string]*[2]float64
+}
+
+// Sink is a stats.Sink that maintains the current values of the
+// statistics reported to it, and serves them to Prometheus over
+// HTTP. Sink is an http.Handler.
+type Sink struct {
+	mu       sync.Mutex
+	families map[string]*family
+	listener net.Listener
+}
+
+// New returns a new Sink. The caller is responsible for serving it.
+func New() *Sink {
+	return &Sink{families: make(map[string]*family)}
+}
+
+// Listen returns a new Sink that serves its metrics at Path on an
+// HTTP listener at the provided address.
+func Listen(addr string) (*Sink, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := New()
+	s.listener = l
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Error.Printf("prometheus: serve %s: %v", addr, err)
+		}
+	}()
+	return s, nil
+}
+
+// Addr returns the address of the sink's HTTP listener, or nil if it
+// was not created by Listen.
+func (s *Sink) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close closes the sink's HTTP listener, if any.
+func (s *Sink) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Gauge implements stats.Sink.
+func (s *Sink) Gauge(name string, value float64, tags ...string) {
+	s.update(gauge, name, tags, func(v *[2]float64) { v[0] = value })
+}
+
+// Count implements stats.Sink.
+func (s *Sink) Count(name string, delta int64, tags ...string) {
+	s.update(counter, name+"_total", tags, func(v *[2]float64) { v[0] += float64(delta) })
+}
+
+// Timing implements stats.Sink.
+func (s *Sink) Timing(name string, d time.Duration, tags ...string) {
+	s.update(summary, name+"_seconds", tags, func(v *[2]float64) {
+		v[0] += d.Seconds()
+		v[1]++
+	})
+}
+
+func (s *Sink) update(k kind, name string, tags []string, update func(*[2]float64)) {
+	name = Prefix + sanitize(name)
+	labels := renderLabels(tags)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.families[name]
+	if f == nil {
+		f = &family{kind: k, series: make(map[string]*[2]float64)}
+		s.families[name] = f
+	} else if f.kind != k {
+		log.Debug.Printf("prometheus: metric %s reported as both %s and %s", name, f.kind, k)
+		return
+	}
+	v := f.series[labels]
+	if v == nil {
+		v = new([2]float64)
+		f.series[labels] = v
+	}
+	update(v)
+}
+
+// ServeHTTP implements http.Handler, serving the sink's metrics in
+// the Prometheus text exposition format.
+func (s *Sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	s.WriteTo(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = io.Copy(w, &b)
+}
+
+// WriteTo writes the sink's metrics, in the Prometheus text
+// exposition format, to the provided writer.
+func (s *Sink) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	s.mu.Lock()
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := s.families[name]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.kind)
+		series := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			v := f.series[labels]
+			switch f.kind {
+			case summary:
+				fmt.Fprintf(&b, "%s_sum%s %s\n", name, labels, formatFloat(v[0]))
+				fmt.Fprintf(&b, "%s_count%s %s\n", name, labels, formatFloat(v[1]))
+			default:
+				fmt.Fprintf(&b, "%s%s %s\n", name, labels, formatFloat(v[0]))
+			}
+		}
+	}
+	s.mu.Unlock()
+	return b.WriteTo(w)
+}
+
+// RenderLabels renders the provided tags as a Prometheus label set.
+// Tags without a key are given the key "tag".
+func renderLabels(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	labels := make([]string, len(tags))
+	for i, tag := range tags {
+		key, value := "tag", tag
+		if j := strings.Index(tag, ":"); j > 0 {
+			key, value = tag[:j], tag[j+1:]
+		}
+		labels[i] = fmt.Sprintf("%s=\"%s\"", sanitize(key), labelEscaper.Replace(value))
+	}
+	sort.Strings(labels)
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Sanitize replaces the characters that are not permitted in
+// Prometheus metric and label names.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package prometheus_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner/stats"
+	"github.com/grailbio/diviner/stats/prometheus"
+)
+
+func TestSink(t *testing.T) {
+	sink, err := stats.Open("prometheus,127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sink.(*prometheus.Sink)
+	defer s.Close()
+
+	sink.Gauge("runner.nworker", 3)
+	sink.Gauge("runner.nworker", 4)
+	sink.Count("run.completed", 1, stats.Tag("study", `a"b`), stats.Tag("state", "success"))
+	sink.Count("run.completed", 2, stats.Tag("study", `a"b`), stats.Tag("state", "success"))
+	sink.Count("run.completed", 1, stats.Tag("study", "test"), stats.Tag("state", "failure"))
+	sink.Timing("run.duration", 1500*time.Millisecond, stats.Tag("study", "test"))
+	sink.Timing("run.duration", 500*time.Millisecond, stats.Tag("study", "test"))
+
+	resp, err := http.Get("http://" + s.Addr().String() + prometheus.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	want := `# TYPE diviner_run_completed_total counter
+diviner_run_completed_total{state="failure",study="test"} 1
+diviner_run_completed_total{state="success",study="a\"b"} 3
+# TYPE diviner_run_duration_seconds summary
+diviner_run_duration_seconds_sum{study="test"} 2
+diviner_run_duration_seconds_count{study="test"} 2
+# TYPE diviner_runner_nworker gauge
+diviner_runner_nworker 4
+`
+	if got := string(body); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := resp.Header.Get("Content-Type"), "text/plain"; !strings.HasPrefix(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
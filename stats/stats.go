@@ -0,0 +1,127 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package stats defines an interface for exporting operational
+// statistics (runner counters, run durations, study objectives) from
+// diviner to external monitoring systems. Implementations of Sink
+// for particular monitoring systems live in subpackages.
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Sink receives operational statistics. Sinks must be safe for
+// concurrent use. Tags are given as "key:value" strings; sinks that
+// do not support tagging may ignore them.
+type Sink interface {
+	// Gauge sets the current value of the named gauge.
+	Gauge(name string, value float64, tags ...string)
+	// Count adds delta to the named counter.
+	Count(name string, delta int64, tags ...string)
+	// Timing records a single duration sample for the named timer.
+	Timing(name string, d time.Duration, tags ...string)
+}
+
+// Nop is a Sink that discards all statistics.
+var Nop Sink = nop{}
+
+type nop struct{}
+
+func (nop) Gauge(string, float64, ...string)        {}
+func (nop) Count(string, int64, ...string)          {}
+func (nop) Timing(string, time.Duration, ...string) {}
+
+// Tag returns a tag string for the provided key and value.
+func Tag(key string, value interface{}) string {
+	return fmt.Sprintf("%s:%v", key, value)
+}
+
+// Tee returns a Sink that forwards all statistics to each of the
+// provided sinks.
+func Tee(sinks ...Sink) Sink {
+	return tee(sinks)
+}
+
+type tee []Sink
+
+func (t tee) Gauge(name string, value float64, tags ...string) {
+	for _, s := range t {
+		s.Gauge(name, value, tags...)
+	}
+}
+
+func (t tee) Count(name string, delta int64, tags ...string) {
+	for _, s := range t {
+		s.Count(name, delta, tags...)
+	}
+}
+
+func (t tee) Timing(name string, d time.Duration, tags ...string) {
+	for _, s := range t {
+		s.Timing(name, d, tags...)
+	}
+}
+
+// Opener opens a sink from a configuration string. The format of
+// the configuration string is defined by each sink implementation.
+type Opener func(config string) (Sink, error)
+
+var (
+	mu      sync.Mutex
+	openers = make(map[string]Opener)
+)
+
+// Register registers a sink implementation under the provided kind,
+// so that it may be opened by Open. Register panics if the kind is
+// registered twice.
+func Register(kind string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := openers[kind]; ok {
+		panic(fmt.Sprintf("stats: sink %s registered twice", kind))
+	}
+	openers[kind] = open
+}
+
+// Open opens a sink from a configuration of the form "kind,config",
+// where kind names a registered sink implementation and config is
+// passed to its opener. The special kind "none" returns Nop. The
+// configuration may contain multiple sinks separated by semicolons,
+// in which case statistics are sent to all of them.
+func Open(spec string) (Sink, error) {
+	var sinks []Sink
+	for _, spec := range strings.Split(spec, ";") {
+		if spec == "" || spec == "none" {
+			continue
+		}
+		parts := strings.SplitN(spec, ",", 2)
+		kind, config := parts[0], ""
+		if len(parts) == 2 {
+			config = parts[1]
+		}
+		mu.Lock()
+		open, ok := openers[kind]
+		mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("stats: unknown sink kind %s", kind)
+		}
+		sink, err := open(config)
+		if err != nil {
+			return nil, fmt.Errorf("stats: open %s: %v", spec, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	switch len(sinks) {
+	case 0:
+		return Nop, nil
+	case 1:
+		return sinks[0], nil
+	default:
+		return Tee(sinks...), nil
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StopConditions are conditions under which a study is stopped
+// before its oracle is exhausted. A study stops when any of its
+// conditions is met; zero-valued conditions are disabled.
+type StopConditions struct {
+	// Target, if non-nil, stops the study once a run attains an
+	// objective value at least as good as *Target.
+	Target *float64
+	// MaxTrials, if positive, stops the study once this many trials
+	// (distinct sets of parameter values) have completed, successfully
+	// or not.
+	MaxTrials int
+	// MaxDuration, if positive, stops the study once this much time
+	// has elapsed since its first run was created.
+	MaxDuration time.Duration
+	// MaxRuntime, if positive, stops the study once the total runtime
+	// of its runs exceeds it. It is a budget of machine time.
+	MaxRuntime time.Duration
+	// Patience, if positive, stops the study once this many
+	// successful runs have completed without improving on the best
+	// objective value.
+	Patience int
+}
+
+// IsZero tells whether no stop conditions are set.
+func (c StopConditions) IsZero() bool {
+	return c.Target == nil && c.MaxTrials <= 0 && c.MaxDuration <= 0 && c.MaxRuntime <= 0 && c.Patience <= 0
+}
+
+// String returns a textual description of the stop conditions.
+func (c StopConditions) String() string {
+	var elems []string
+	if c.Target != nil {
+		elems = append(elems, fmt.Sprintf("target=%g", *c.Target))
+	}
+	if c.MaxTrials > 0 {
+		elems = append(elems, fmt.Sprintf("max_trials=%d", c.MaxTrials))
+	}
+	if c.MaxDuration > 0 {
+		elems = append(elems, fmt.Sprintf("max_duration=%s", c.MaxDuration))
+	}
+	if c.MaxRuntime > 0 {
+		elems = append(elems, fmt.Sprintf("max_runtime=%s", c.MaxRuntime))
+	}
+	if c.Patience > 0 {
+		elems = append(elems, fmt.Sprintf("patience=%d", c.Patience))
+	}
+	return fmt.Sprintf("stop(%s)", strings.Join(elems, ","))
+}
+
+// Check checks the stop conditions against the provided runs of a
+// study with the provided objective, at time now. It returns a
+// description of the first condition that is met, or an empty string
+// if the study should continue.
+func (c StopConditions) Check(objective Objective, runs []Run, now time.Time) string {
+	if c.IsZero() || len(runs) == 0 {
+		return ""
+	}
+	var (
+		created time.Time
+		runtime time.Duration
+		trials  = NewMap()
+		success []Run
+	)
+	for _, run := range runs {
+		if created.IsZero() || run.Created.Before(created) {
+			created = run.Created
+		}
+		runtime += run.Runtime
+		if run.State&(Success|Failure|TimedOut) != 0 {
+			trials.Put(run.Values, true)
+		}
+		if run.State == Success {
+			success = append(success, run)
+		}
+	}
+	sort.SliceStable(success, func(i, j int) bool {
+		return success[i].Completed.Before(success[j].Completed)
+	})
+	var (
+		best     = math.NaN()
+		sinceImp int
+	)
+	for _, run := range success {
+		v, ok := run.Trial().Metrics[objective.Metric]
+		if !ok || math.IsNaN(v) {
+			sinceImp++
+			continue
+		}
+		if math.IsNaN(best) || better(objective, v, best) {
+			best = v
+			sinceImp = 0
+		} else {
+			sinceImp++
+		}
+	}
+	switch {
+	case c.Target != nil && !math.IsNaN(best) && !better(objective, *c.Target, best):
+		return fmt.Sprintf("reached target %s=%g (best %g)", objective.Metric, *c.Target, best)
+	case c.MaxTrials > 0 && trials.Len() >= c.MaxTrials:
+		return fmt.Sprintf("completed %d trials (max %d)", trials.Len(), c.MaxTrials)
+	case c.MaxDuration > 0 && !created.IsZero() && now.Sub(created) >= c.MaxDuration:
+		return fmt.Sprintf("ran for %s (max %s)", now.Sub(created).Round(time.Second), c.MaxDuration)
+	case c.MaxRuntime > 0 && runtime >= c.MaxRuntime:
+		return fmt.Sprintf("used %s of runtime (max %s)", runtime.Round(time.Second), c.MaxRuntime)
+	case c.Patience > 0 && sinceImp >= c.Patience:
+		return fmt.Sprintf("no improvement in %s in %d runs (best %g)", objective.Metric, sinceImp, best)
+	}
+	return ""
+}
+
+// Better tells whether v is a strictly better value of the objective
+// than w.
+func better(objective Objective, v, w float64) bool {
+	if objective.Direction == Minimize {
+		return v < w
+	}
+	return v > w
+}
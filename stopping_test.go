@@ -0,0 +1,60 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestStopConditions(t *testing.T) {
+	var (
+		now       = time.Now()
+		objective = diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+		runs      []diviner.Run
+	)
+	for i, loss := range []float64{0.5, 0.3, 0.4, 0.35, 0.6} {
+		runs = append(runs, diviner.Run{
+			Seq:       uint64(i + 1),
+			Values:    diviner.Values{"x": diviner.Int(int64(i))},
+			State:     diviner.Success,
+			Created:   now.Add(-time.Duration(10-i) * time.Hour),
+			Completed: now.Add(-time.Duration(9-i) * time.Hour),
+			Runtime:   time.Hour,
+			Metrics:   []diviner.Metrics{{"loss": loss}},
+		})
+	}
+	runs = append(runs, diviner.Run{
+		Seq:     6,
+		Values:  diviner.Values{"x": diviner.Int(5)},
+		State:   diviner.Pending,
+		Created: now,
+	})
+	low, high := 0.1, 0.3
+	for _, test := range []struct {
+		stop diviner.StopConditions
+		want string
+	}{
+		{diviner.StopConditions{}, ""},
+		{diviner.StopConditions{Target: &low}, ""},
+		{diviner.StopConditions{Target: &high}, "reached target"},
+		{diviner.StopConditions{MaxTrials: 6}, ""},
+		{diviner.StopConditions{MaxTrials: 5}, "completed 5 trials"},
+		{diviner.StopConditions{MaxDuration: 11 * time.Hour}, ""},
+		{diviner.StopConditions{MaxDuration: 10 * time.Hour}, "ran for"},
+		{diviner.StopConditions{MaxRuntime: 6 * time.Hour}, ""},
+		{diviner.StopConditions{MaxRuntime: 5 * time.Hour}, "runtime"},
+		{diviner.StopConditions{Patience: 4}, ""},
+		{diviner.StopConditions{Patience: 3}, "no improvement"},
+	} {
+		got := test.stop.Check(objective, runs, now)
+		if test.want == "" && got != "" || !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.stop, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+// azureVersion is the version of the Azure Blob Storage REST API
+// used by Azure.
+const azureVersion = "2019-12-12"
+
+// Azure is a Storage for Azure Blob Storage URLs of the form
+// az://container/blob. The zero Azure is ready to use: it reads the
+// storage account name from the environment variable
+// AZURE_STORAGE_ACCOUNT, and authorizes requests with the shared
+// access signature in AZURE_STORAGE_SAS_TOKEN or, if that is unset,
+// with the account key in AZURE_STORAGE_KEY.
+type Azure struct {
+	// Account is the name of the storage account. If empty, the
+	// environment variable AZURE_STORAGE_ACCOUNT is used.
+	Account string
+	// Key is the base64-encoded account key used to sign requests
+	// (Shared Key authorization).
+	Key string
+	// SAS is a shared access signature (a URL query string) used to
+	// authorize requests instead of the account key.
+	SAS string
+	// Endpoint is the blob service endpoint of the storage account.
+	// If empty, https://account.blob.core.windows.net is used.
+	Endpoint string
+	// Client is the HTTP client used to issue requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Stat implements Storage.
+func (a *Azure) Stat(ctx context.Context, rawurl string) (Info, error) {
+	req, err := a.request(ctx, "HEAD", rawurl, nil, 0)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := do(a.Client, req)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return Info{}, errors.E("stat", rawurl, "bad modification time", err)
+	}
+	return Info{Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Open implements Storage.
+func (a *Azure) Open(ctx context.Context, rawurl string) (io.ReadCloser, error) {
+	req, err := a.request(ctx, "GET", rawurl, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(a.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put implements Storage. Blobs are uploaded as block blobs in a
+// single request, and are thus limited to 5000 MiB.
+func (a *Azure) Put(ctx context.Context, rawurl string, r io.Reader) error {
+	body, size, cleanup, err := sized(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	req, err := a.request(ctx, "PUT", rawurl, body, size)
+	if err != nil {
+		return err
+	}
+	resp, err := do(a.Client, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Request returns an authorized request for the blob named by the
+// provided URL.
+func (a *Azure) request(ctx context.Context, method, rawurl string, body io.Reader, size int64) (*http.Request, error) {
+	container, blob, err := parse("az", rawurl)
+	if err != nil {
+		return nil, err
+	}
+	account := a.Account
+	if account == "" {
+		account = os.Getenv("AZURE_STORAGE_ACCOUNT")
+	}
+	if account == "" {
+		return nil, errors.E(errors.Invalid, rawurl, "azure: no storage account configured")
+	}
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+	u, err := url.Parse(fmt.Sprintf("%s/%s/%s", endpoint, url.PathEscape(container), escapeBlob(blob)))
+	if err != nil {
+		return nil, err
+	}
+	sas, key := a.SAS, a.Key
+	if sas == "" && key == "" {
+		sas, key = os.Getenv("AZURE_STORAGE_SAS_TOKEN"), os.Getenv("AZURE_STORAGE_KEY")
+	}
+	if sas != "" {
+		u.RawQuery = strings.TrimPrefix(sas, "?")
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureVersion)
+	if body != nil {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	switch {
+	case sas != "":
+	case key != "":
+		signature, err := azureSignature(req, account, key)
+		if err != nil {
+			return nil, errors.E(errors.Invalid, "azure: sign request", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", account, signature))
+	default:
+		return nil, errors.E(errors.NotAllowed, rawurl, "azure: no credentials configured")
+	}
+	return req, nil
+}
+
+// AzureSignature computes the Shared Key signature of the provided
+// request with the provided account key, as described in
+// https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key.
+func azureSignature(req *http.Request, account, key string) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	var length string
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var b strings.Builder
+	for _, value := range []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date; x-ms-date is used instead.
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	} {
+		b.WriteString(value)
+		b.WriteString("\n")
+	}
+	var names []string
+	for name := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s:%s\n", name, req.Header.Get(name))
+	}
+	fmt.Fprintf(&b, "/%s%s", account, req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		fmt.Fprintf(&b, "\n%s:%s", strings.ToLower(name), strings.Join(values, ","))
+	}
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, b.String())
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// EscapeBlob escapes the provided blob name for use in a URL path,
+// preserving its path separators.
+func escapeBlob(blob string) string {
+	elems := strings.Split(blob, "/")
+	for i := range elems {
+		elems[i] = url.PathEscape(elems[i])
+	}
+	return strings.Join(elems, "/")
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/file/s3file"
+)
+
+// File is a Storage backed by github.com/grailbio/base/file.
+type File struct {
+	// Impl is the file implementation used to access objects. If
+	// nil, objects are accessed through the implementation that is
+	// registered with package file for their URLs' schemes.
+	Impl file.Implementation
+}
+
+// NewS3 returns a Storage for Amazon S3 URLs (s3://bucket/key),
+// backed by an s3file implementation that uses AWS sessions with the
+// provided options.
+func NewS3(opts session.Options) File {
+	return File{s3file.NewImplementation(s3file.NewDefaultProvider(opts), s3file.Options{})}
+}
+
+// Stat implements Storage.
+func (f File) Stat(ctx context.Context, url string) (Info, error) {
+	var (
+		info file.Info
+		err  error
+	)
+	if f.Impl != nil {
+		info, err = f.Impl.Stat(ctx, url)
+	} else {
+		info, err = file.Stat(ctx, url)
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Open implements Storage.
+func (f File) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	var (
+		r   file.File
+		err error
+	)
+	if f.Impl != nil {
+		r, err = f.Impl.Open(ctx, url)
+	} else {
+		r, err = file.Open(ctx, url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader{ctx, r, r.Reader(ctx)}, nil
+}
+
+// Put implements Storage.
+func (f File) Put(ctx context.Context, url string, r io.Reader) error {
+	var (
+		w   file.File
+		err error
+	)
+	if f.Impl != nil {
+		w, err = f.Impl.Create(ctx, url)
+	} else {
+		w, err = file.Create(ctx, url)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w.Writer(ctx), r); err != nil {
+		w.Discard(ctx)
+		return err
+	}
+	return w.Close(ctx)
+}
+
+type fileReader struct {
+	ctx context.Context
+	f   file.File
+	io.Reader
+}
+
+func (r *fileReader) Close() error {
+	return r.f.Close(r.ctx)
+}
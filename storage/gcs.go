@@ -0,0 +1,186 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+// DefaultGCSEndpoint is the endpoint of the Google Cloud Storage JSON
+// API.
+const DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+// gceTokenURL is the URL from which GCE instances obtain access
+// tokens for their default service accounts.
+const gceTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCS is a Storage for Google Cloud Storage URLs of the form
+// gs://bucket/object. It uses the GCS JSON API. The zero GCS is
+// ready to use: it uses the OAuth2 access token in the environment
+// variable GOOGLE_OAUTH_ACCESS_TOKEN if it is set; otherwise it
+// obtains tokens for the default service account from the GCE
+// metadata server.
+type GCS struct {
+	// Endpoint is the endpoint of the GCS JSON API. If empty, the
+	// endpoint in the environment variable STORAGE_EMULATOR_HOST is
+	// used if it is set; otherwise DefaultGCSEndpoint is used.
+	Endpoint string
+	// Token returns the OAuth2 access token used to authorize
+	// requests. If nil, tokens are obtained from the environment as
+	// described above.
+	Token func(ctx context.Context) (string, error)
+	// Client is the HTTP client used to issue requests. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Stat implements Storage.
+func (g *GCS) Stat(ctx context.Context, rawurl string) (Info, error) {
+	req, err := g.request(ctx, "GET", rawurl, "", nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := do(g.Client, req)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	var object struct {
+		Size    string    `json:"size"`
+		Updated time.Time `json:"updated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return Info{}, errors.E("stat", rawurl, err)
+	}
+	size, err := strconv.ParseInt(object.Size, 10, 64)
+	if err != nil {
+		return Info{}, errors.E("stat", rawurl, "bad object size", err)
+	}
+	return Info{Size: size, ModTime: object.Updated}, nil
+}
+
+// Open implements Storage.
+func (g *GCS) Open(ctx context.Context, rawurl string) (io.ReadCloser, error) {
+	req, err := g.request(ctx, "GET", rawurl, "alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := do(g.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put implements Storage. Objects are uploaded in a single request;
+// GCS does not make them visible until the upload completes.
+func (g *GCS) Put(ctx context.Context, rawurl string, r io.Reader) error {
+	body, size, cleanup, err := sized(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	req, err := g.request(ctx, "POST", rawurl, "", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := do(g.Client, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Request returns an authorized request for the object named by the
+// provided URL. Requests with bodies are media uploads.
+func (g *GCS) request(ctx context.Context, method, rawurl, query string, body io.Reader) (*http.Request, error) {
+	bucket, object, err := parse("gs", rawurl)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultGCSEndpoint
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+			endpoint = "http://" + host
+		}
+	}
+	var u string
+	if body != nil {
+		u = fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+			endpoint, url.PathEscape(bucket), url.QueryEscape(object))
+	} else {
+		u = fmt.Sprintf("%s/storage/v1/b/%s/o/%s", endpoint, url.PathEscape(bucket), url.PathEscape(object))
+		if query != "" {
+			u += "?" + query
+		}
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return nil, errors.E(errors.NotAllowed, "gcs: access token", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// AccessToken returns an access token with which to authorize
+// requests.
+func (g *GCS) accessToken(ctx context.Context) (string, error) {
+	if g.Token != nil {
+		return g.Token(ctx)
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// Refresh tokens a minute before they expire.
+	if g.token != "" && time.Now().Add(time.Minute).Before(g.expires) {
+		return g.token, nil
+	}
+	req, err := http.NewRequest("GET", gceTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := do(g.Client, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	g.token = token.AccessToken
+	g.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return g.token, nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package storage provides pluggable access to the blob stores in
+// which diviner's datasets, artifacts, and checkpoints are kept.
+// Blob stores implement the Storage interface and are registered by
+// URL scheme:
+//
+//	s3://bucket/key              Amazon S3 (see NewS3)
+//	gs://bucket/object           Google Cloud Storage (see GCS)
+//	az://container/blob          Azure Blob Storage (see Azure)
+//
+// GCS and Azure are registered by default, and authenticate using
+// credentials from the environment. URLs with other schemes, and
+// plain paths, are accessed through github.com/grailbio/base/file
+// (see File); S3 is thus available whenever an s3file implementation
+// is registered with that package. Missing objects are reported by
+// errors that satisfy errors.Is(errors.NotExist, err), where errors
+// is github.com/grailbio/base/errors.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+)
+
+// Info describes a stored object.
+type Info struct {
+	// Size is the size of the object, in bytes.
+	Size int64
+	// ModTime is the time at which the object was last modified.
+	ModTime time.Time
+}
+
+// Storage is a blob store that stores objects named by URLs.
+type Storage interface {
+	// Stat returns information about the object at the provided URL.
+	Stat(ctx context.Context, url string) (Info, error)
+	// Open returns a reader of the contents of the object at the
+	// provided URL. The caller must close the reader.
+	Open(ctx context.Context, url string) (io.ReadCloser, error)
+	// Put stores the contents of the provided reader at the provided
+	// URL, replacing any existing object. The object is not modified
+	// if Put fails.
+	Put(ctx context.Context, url string, r io.Reader) error
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]Storage{
+		"gs": new(GCS),
+		"az": new(Azure),
+	}
+)
+
+// DefaultStorage is the storage used for URLs whose schemes are not
+// registered.
+var DefaultStorage Storage = File{}
+
+// Register registers the provided storage for URLs with the provided
+// scheme, replacing any storage previously registered for it.
+func Register(scheme string, storage Storage) {
+	mu.Lock()
+	registry[scheme] = storage
+	mu.Unlock()
+}
+
+// For returns the storage that is registered for the provided URL's
+// scheme, or DefaultStorage if none is.
+func For(url string) Storage {
+	scheme := url
+	if i := strings.Index(url, "://"); i > 0 {
+		scheme = url[:i]
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if s := registry[scheme]; s != nil {
+		return s
+	}
+	return DefaultStorage
+}
+
+// Stat returns information about the object at the provided URL
+// using the storage registered for it.
+func Stat(ctx context.Context, url string) (Info, error) {
+	return For(url).Stat(ctx, url)
+}
+
+// Open opens the object at the provided URL using the storage
+// registered for it.
+func Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	return For(url).Open(ctx, url)
+}
+
+// Put stores the contents of the provided reader at the provided URL
+// using the storage registered for it.
+func Put(ctx context.Context, url string, r io.Reader) error {
+	return For(url).Put(ctx, url, r)
+}
+
+// Copy copies the object at URL src to URL dst.
+func Copy(ctx context.Context, src, dst string) error {
+	r, err := Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return Put(ctx, dst, r)
+}
+
+// Parse splits a URL of the form scheme://bucket/key into its bucket
+// and key, checking that it has the provided scheme.
+func parse(scheme, rawurl string) (bucket, key string, err error) {
+	prefix := scheme + "://"
+	if !strings.HasPrefix(rawurl, prefix) {
+		return "", "", errors.E(errors.Invalid, fmt.Sprintf("%s: not a %s URL", rawurl, prefix))
+	}
+	path := strings.TrimPrefix(rawurl, prefix)
+	i := strings.Index(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return "", "", errors.E(errors.Invalid, fmt.Sprintf("%s: URL must name a bucket and an object", rawurl))
+	}
+	return path[:i], path[i+1:], nil
+}
+
+// Sized returns a reader with the contents of r whose length is
+// is known, together with its length. Readers of unknown
+// length are spooled to a temporary file, which is removed by the
+// returned cleanup function.
+func sized(r io.Reader) (io.Reader, int64, func(), error) {
+	nop := func() {}
+	switch r := r.(type) {
+	case interface {
+		io.Reader
+		Len() int
+	}:
+		if r.Len() == 0 {
+			return http.NoBody, 0, nop, nil
+		}
+		return r, int64(r.Len()), nop, nil
+	case *os.File:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			if off, err := r.Seek(0, io.SeekCurrent); err == nil {
+				if n := info.Size() - off; n > 0 {
+					return io.LimitReader(r, n), n, nop, nil
+				}
+				return http.NoBody, 0, nop, nil
+			}
+		}
+	}
+	f, err := ioutil.TempFile("", "storage")
+	if err != nil {
+		return nil, 0, nop, err
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nop, err
+	}
+	if n == 0 {
+		cleanup()
+		return http.NoBody, 0, nop, nil
+	}
+	return io.LimitReader(f, n), n, cleanup, nil
+}
+
+// Do performs the provided request, returning an error describing
+// unsuccessful responses. Responses with status 404 are reported as
+// errors.NotExist.
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	what := fmt.Sprintf("%s %s", req.Method, redact(req.URL))
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.E(errors.NotExist, what)
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	kind := errors.Other
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		kind = errors.NotAllowed
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		kind = errors.Unavailable
+	}
+	return nil, errors.E(kind, what, fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(msg))))
+}
+
+// Redact returns the provided URL without its query parameters,
+// which may contain credentials.
+func redact(u *url.URL) string {
+	v := *u
+	v.RawQuery = ""
+	return v.String()
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/diviner/storage"
+)
+
+// BlobServer is a fake blob store that stores objects by path.
+type blobServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	auth    []string
+}
+
+func (s *blobServer) put(key string, p []byte, auth string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+	}
+	s.objects[key] = p
+	s.auth = append(s.auth, auth)
+}
+
+func (s *blobServer) get(key, auth string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = append(s.auth, auth)
+	p, ok := s.objects[key]
+	return p, ok
+}
+
+var modTime = time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func newGCSServer(s *blobServer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/") {
+			bucket := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/upload/storage/v1/b/"), "/o")
+			p, _ := ioutil.ReadAll(r.Body)
+			s.put(bucket+"/"+r.URL.Query().Get("name"), p, auth)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/")
+		key := strings.Replace(path, "/o/", "/", 1)
+		p, ok := s.get(key, auth)
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case r.URL.Query().Get("alt") == "media":
+			w.Write(p)
+		default:
+			json.NewEncoder(w).Encode(map[string]string{
+				"size":    fmt.Sprint(len(p)),
+				"updated": modTime.Format(time.RFC3339),
+			})
+		}
+	}))
+}
+
+func newAzureServer(s *blobServer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			auth = r.URL.RawQuery
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if r.Method == "PUT" {
+			if got, want := r.Header.Get("x-ms-blob-type"), "BlockBlob"; got != want {
+				http.Error(w, "bad blob type", http.StatusBadRequest)
+				return
+			}
+			p, _ := ioutil.ReadAll(r.Body)
+			s.put(key, p, auth)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		p, ok := s.get(key, auth)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(p)))
+		if r.Method == "GET" {
+			w.Write(p)
+		}
+	}))
+}
+
+func testStorage(t *testing.T, s storage.Storage, url, missing string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.Put(ctx, url, strings.NewReader("hello, world")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Stat(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Size, int64(12); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if info.ModTime.IsZero() {
+		t.Error("zero modtime")
+	}
+	r, err := s.Open(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p), "hello, world"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := s.Stat(ctx, missing); !errors.Is(errors.NotExist, err) {
+		t.Errorf("stat %s: expected NotExist, got %v", missing, err)
+	}
+	if _, err := s.Open(ctx, missing); !errors.Is(errors.NotExist, err) {
+		t.Errorf("open %s: expected NotExist, got %v", missing, err)
+	}
+}
+
+func TestGCS(t *testing.T) {
+	var blobs blobServer
+	srv := newGCSServer(&blobs)
+	defer srv.Close()
+	gcs := &storage.GCS{
+		Endpoint: srv.URL,
+		Token:    func(context.Context) (string, error) { return "secret", nil },
+	}
+	testStorage(t, gcs, "gs://bucket/path/to/object", "gs://bucket/missing")
+	if _, ok := blobs.objects["bucket/path/to/object"]; !ok {
+		t.Errorf("object not stored: %v", blobs.objects)
+	}
+	for _, auth := range blobs.auth {
+		if got, want := auth, "Bearer secret"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAzure(t *testing.T) {
+	var blobs blobServer
+	srv := newAzureServer(&blobs)
+	defer srv.Close()
+	key := base64.StdEncoding.EncodeToString([]byte("account key"))
+	az := &storage.Azure{Account: "acct", Key: key, Endpoint: srv.URL}
+	testStorage(t, az, "az://container/path/to/blob", "az://container/missing")
+	if _, ok := blobs.objects["container/path/to/blob"]; !ok {
+		t.Errorf("blob not stored: %v", blobs.objects)
+	}
+	for _, auth := range blobs.auth {
+		if got, want := auth, "SharedKey acct:"; !strings.HasPrefix(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	blobs.auth = nil
+	az = &storage.Azure{Account: "acct", SAS: "?sv=2019-12-12&sig=abc", Endpoint: srv.URL}
+	testStorage(t, az, "az://container/sas", "az://container/missing")
+	for _, auth := range blobs.auth {
+		if got, want := auth, "sv=2019-12-12&sig=abc"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStorage(t, storage.File{}, filepath.Join(dir, "object"), filepath.Join(dir, "missing"))
+}
+
+func TestRegister(t *testing.T) {
+	var blobs blobServer
+	srv := newGCSServer(&blobs)
+	defer srv.Close()
+	storage.Register("test", &storage.GCS{Endpoint: srv.URL})
+	if _, ok := storage.For("test://bucket/object").(*storage.GCS); !ok {
+		t.Error("test storage not registered")
+	}
+	if _, ok := storage.For("/local/path").(storage.File); !ok {
+		t.Error("expected file storage for local paths")
+	}
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("checkpoint"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gs := storage.For("gs://bucket/object")
+	if _, ok := gs.(*storage.GCS); !ok {
+		t.Error("gs storage not registered")
+	}
+	defer storage.Register("gs", gs)
+	storage.Register("gs", &storage.GCS{
+		Endpoint: srv.URL,
+		Token:    func(context.Context) (string, error) { return "secret", nil },
+	})
+	if err := storage.Copy(ctx, src, "gs://bucket/ckpt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := blobs.objects["bucket/ckpt"], []byte("checkpoint"); !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -5,14 +5,73 @@
 package diviner
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
 	"github.com/grailbio/bigmachine"
 	"go.starlark.net/starlark"
 )
 
+// A Configurer is a bigmachine system that can be reconfigured to
+// provide a set of resources; for example, by selecting an instance
+// type that satisfies them.
+type Configurer interface {
+	// Configure returns a system, derived from this one, whose
+	// machines provide at least the required resources, along with
+	// the resources that they provide.
+	Configure(need Resources) (bigmachine.System, Resources, error)
+}
+
+// A Backend executes scripts directly, in place of a bigmachine
+// system; for example, by submitting them to a cluster scheduler.
+type Backend interface {
+	// Run starts the provided job, returning a reader of its combined
+	// standard output and standard error. Reads return an error once
+	// the job's output is exhausted if the job failed. The job is
+	// terminated when the context is canceled or the reader is
+	// closed.
+	Run(ctx context.Context, job Job) (io.ReadCloser, error)
+}
+
+// ErrPreempted indicates that a job was interrupted because the
+// machine on which it ran was reclaimed by its provider. Backends
+// report preemptions by failing reads of their jobs' output with
+// errors that wrap ErrPreempted; the runner then marks the job's run
+// Preempted.
+var ErrPreempted = errors.New("machine was preempted")
+
+// A Job is a script to be executed by a Backend.
+type Job struct {
+	// Name identifies the job, e.g., by the ID of the run that it
+	// performs. It is not necessarily unique.
+	Name string
+	// Script is the Bash script to run, including the system's
+	// preamble.
+	Script string
+	// Env is a list of environment variables, in the form
+	// "key=value", in addition to those provided by the backend.
+	Env []string
+	// Files maps names of files to their contents. The files must be
+	// made available in the script's working directory.
+	Files map[string][]byte
+	// Resources are the resources required by the job.
+	Resources Resources
+	// Image, if non-empty, is the container image in which the job's
+	// script must be run, overriding the backend's default image.
+	// Backends that do not run containers reject jobs with images.
+	Image string
+}
+
 // A System describes a configuration of a machine. It is part of SystemPool.
 type System struct {
 	// System is the bigmachine system configured by this system.
 	bigmachine.System
+	// Backend, if non-nil, executes runs directly, in place of the
+	// bigmachine system.
+	Backend Backend
 	// ID is a unique identifier for this system.
 	ID string
 	// Parallelism specifies the maximum level of job parallelism allowable for
@@ -21,10 +80,68 @@ type System struct {
 	// Bash snippet to be prepended to the user script.
 	// If empty, runner.DefaultPreamble is used.
 	Preamble string
+	// Resources describes the resources provided by each of the
+	// system's machines. If zero, the system's resources are unknown,
+	// and it is assumed to satisfy any requirement.
+	Resources Resources
+	// Spot indicates that the system's machines are spot (or
+	// preemptible) instances, which their provider may reclaim at any
+	// time. Runs whose machines are lost on spot systems are marked
+	// Preempted, rather than Failure, and are rescheduled.
+	Spot bool
+
+	mu sync.Mutex
+	// configured stores the systems derived by Configure, keyed by
+	// the resources they provide.
+	configured map[Resources]*System
+}
+
+// Configure returns a system whose machines provide at least the
+// required resources. This is the system itself if its resources
+// already satisfy the requirements; otherwise, if the underlying
+// bigmachine system implements Configurer, a system derived from it.
+// Derived systems are reused across calls, so that runs with similar
+// requirements share machines.
+func (s *System) Configure(need Resources) (*System, error) {
+	if need.IsZero() || s.Resources.IsZero() || s.Resources.Satisfies(need) {
+		return s, nil
+	}
+	c, ok := s.System.(Configurer)
+	if !ok {
+		return nil, fmt.Errorf("system %s provides %s, which does not satisfy %s", s.ID, s.Resources, need)
+	}
+	sys, provided, err := c.Configure(need)
+	if err != nil {
+		return nil, fmt.Errorf("system %s: %v", s.ID, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if derived, ok := s.configured[provided]; ok {
+		return derived, nil
+	}
+	if s.configured == nil {
+		s.configured = make(map[Resources]*System)
+	}
+	derived := &System{
+		System:      sys,
+		Backend:     s.Backend,
+		ID:          fmt.Sprintf("%s.%d", s.ID, len(s.configured)+1),
+		Parallelism: s.Parallelism,
+		Preamble:    s.Preamble,
+		Resources:   provided,
+		Spot:        s.Spot,
+	}
+	s.configured[provided] = derived
+	return derived, nil
 }
 
 // String implements starlark.Value.
-func (s *System) String() string { return s.System.Name() }
+func (s *System) String() string {
+	if s.System == nil {
+		return s.ID
+	}
+	return s.System.Name()
+}
 
 // Type implements starlark.Value.
 func (*System) Type() string { return "system" }
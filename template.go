@@ -0,0 +1,144 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TemplateVar matches a template's references to variables.
+var templateVar = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// A Template is a run script in which the run's parameter values are
+// substituted, so that Study.Run need not assemble scripts by string
+// formatting. A template refers to the value of the parameter name
+// by {{name}}, and to the run's ID and replicate number by {{id}}
+// and {{replicate}}. For example:
+//
+//	python train.py --lr={{lr}} --optimizer={{optimizer}} --run={{id}}
+//
+// Values are rendered according to their kind, and quoted for Bash
+// (see Quote), so that each value is interpreted as a single word,
+// or, for lists, a word for each element. References should thus not
+// appear within quotes. Parameters that are missing from a run's
+// values (e.g., inactive conditional parameters) are replaced by the
+// empty string.
+type Template struct {
+	text string
+}
+
+// NewTemplate returns a template with the provided text.
+func NewTemplate(text string) *Template {
+	return &Template{text}
+}
+
+// String returns the template's text.
+func (t *Template) String() string { return t.text }
+
+// Names returns the names referred to by the template, in the order
+// of their first reference.
+func (t *Template) Names() []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	for _, match := range templateVar.FindAllStringSubmatch(t.text, -1) {
+		if name := match[1]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Check returns an error if the template refers to a name that is
+// neither one of the provided parameters nor "id" or "replicate".
+func (t *Template) Check(params Params) error {
+	for _, name := range t.Names() {
+		switch name {
+		case "id", "replicate":
+		default:
+			if _, ok := params[name]; !ok {
+				return fmt.Errorf("template refers to undefined parameter %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Execute renders the template for the run with the provided values,
+// replicate, and ID, quoting each value for Bash.
+func (t *Template) Execute(values Values, replicate int, id string) string {
+	return t.render(values, replicate, id, true)
+}
+
+// Expand renders the template like Execute, but substitutes values
+// without quoting them; e.g., for text that is not interpreted by
+// Bash, like the values of environment variables.
+func (t *Template) Expand(values Values, replicate int, id string) string {
+	return t.render(values, replicate, id, false)
+}
+
+func (t *Template) render(values Values, replicate int, id string, quote bool) string {
+	return templateVar.ReplaceAllStringFunc(t.text, func(match string) string {
+		name := templateVar.FindStringSubmatch(match)[1]
+		if v, ok := values[name]; ok {
+			if quote {
+				return Quote(v)
+			}
+			return v.String()
+		}
+		switch name {
+		case "id":
+			if quote {
+				return shellWord(id)
+			}
+			return id
+		case "replicate":
+			return strconv.Itoa(replicate)
+		}
+		return ""
+	})
+}
+
+// Quote renders a value as Bash words: lists are rendered as a word
+// for each of their elements, and other values as a single word of
+// their textual representation (see Value.String). Words are quoted
+// only if they contain characters that are special to Bash.
+func Quote(v Value) string {
+	if v.Kind() != Seq {
+		return shellWord(v.String())
+	}
+	words := make([]string, v.Len())
+	for i := range words {
+		words[i] = Quote(v.Index(i))
+	}
+	return strings.Join(words, " ")
+}
+
+// ShellWord quotes s, if needed, so that it is interpreted by Bash as
+// a single word with the literal value s.
+func shellWord(s string) string {
+	if s == "" {
+		return "''"
+	}
+	for _, r := range s {
+		if !isShellSafe(r) {
+			return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+		}
+	}
+	return s
+}
+
+func isShellSafe(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("-_.,:/+=@%", r)
+}
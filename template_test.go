@@ -0,0 +1,61 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+func TestQuote(t *testing.T) {
+	for _, c := range []struct {
+		value diviner.Value
+		want  string
+	}{
+		{diviner.Int(-3), "-3"},
+		{diviner.Float(1e-5), "1e-05"},
+		{diviner.Bool(true), "true"},
+		{diviner.String("adam"), "adam"},
+		{diviner.String(""), "''"},
+		{diviner.String("a b"), "'a b'"},
+		{diviner.String("it's $HOME"), `'it'\''s $HOME'`},
+		{diviner.String("s3://bucket/path-1.txt"), "s3://bucket/path-1.txt"},
+		{diviner.Duration(90 * time.Minute), "1h30m0s"},
+		{diviner.List{diviner.Int(64), diviner.String("x;y")}, "64 'x;y'"},
+	} {
+		if got, want := diviner.Quote(c.value), c.want; got != want {
+			t.Errorf("%v: got %v, want %v", c.value, got, want)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl := diviner.NewTemplate("train --lr={{lr}} --opt={{ opt }} --momentum={{momentum}} --run={{id}}/{{replicate}} --lr2={{lr}}")
+	if got, want := tmpl.Names(), []string{"lr", "opt", "momentum", "id", "replicate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	params := diviner.Params{
+		"lr":       diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+		"opt":      diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam w")),
+		"momentum": diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "opt", diviner.String("sgd")),
+	}
+	if err := tmpl.Check(params); err != nil {
+		t.Error(err)
+	}
+	delete(params, "momentum")
+	if err := tmpl.Check(params); err == nil {
+		t.Error("expected error")
+	}
+	values := diviner.Values{"lr": diviner.Float(0.5), "opt": diviner.String("adam w")}
+	if got, want := tmpl.Execute(values, 1, "study:3"), "train --lr=0.5 --opt='adam w' --momentum= --run=study:3/1 --lr2=0.5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := tmpl.Expand(values, 1, "study:3"), "train --lr=0.5 --opt=adam w --momentum= --run=study:3/1 --lr2=0.5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
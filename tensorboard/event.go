@@ -0,0 +1,132 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tensorboard
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// FileVersion is the version of the event file format, recorded in
+// the first event of each file.
+const fileVersion = "brain.Event:2"
+
+// DtString is the TensorFlow data type of string tensors.
+const dtString = 7
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// MaskedCRC returns the masked CRC-32C checksum of b, as used by the
+// TFRecord format.
+func maskedCRC(b []byte) uint32 {
+	crc := crc32.Checksum(b, crcTable)
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
+
+// WriteRecord writes data to w as a single TFRecord: its length and
+// the length's checksum, followed by the data and its checksum.
+func writeRecord(w io.Writer, data []byte) error {
+	var (
+		header [12]byte
+		footer [4]byte
+	)
+	binary.LittleEndian.PutUint64(header[:8], uint64(len(data)))
+	binary.LittleEndian.PutUint32(header[8:], maskedCRC(header[:8]))
+	binary.LittleEndian.PutUint32(footer[:], maskedCRC(data))
+	for _, b := range [][]byte{header[:], data, footer[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The following functions encode the subset of TensorFlow's Event
+// protocol buffer (tensorflow/core/util/event.proto) that is needed to
+// write scalar and text summaries. Messages are encoded directly in
+// the protocol buffer wire format, so that the package need not
+// depend on TensorFlow's generated code.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendKey(b []byte, field, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var p [8]byte
+	binary.LittleEndian.PutUint64(p[:], v)
+	return append(b, p[:]...)
+}
+
+func appendFixed32(b []byte, v uint32) []byte {
+	var p [4]byte
+	binary.LittleEndian.PutUint32(p[:], v)
+	return append(b, p[:]...)
+}
+
+func appendBytes(b []byte, field int, p []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+// EncodeEvent encodes an Event message with the provided wall time (in
+// seconds since the Unix epoch) and step. The event contains either
+// the file version, if summary is nil, or else the encoded summary.
+func encodeEvent(wallTime float64, step int64, summary []byte) []byte {
+	b := appendKey(nil, 1, wireFixed64)
+	b = appendFixed64(b, math.Float64bits(wallTime))
+	if step != 0 {
+		b = appendKey(b, 2, wireVarint)
+		b = appendVarint(b, uint64(step))
+	}
+	if summary == nil {
+		return appendBytes(b, 3, []byte(fileVersion))
+	}
+	return appendBytes(b, 5, summary)
+}
+
+// AppendScalar appends to the encoded Summary message b a value
+// containing a scalar with the provided tag.
+func appendScalar(b []byte, tag string, v float64) []byte {
+	value := appendBytes(nil, 1, []byte(tag))
+	value = appendKey(value, 2, wireFixed32)
+	value = appendFixed32(value, math.Float32bits(float32(v)))
+	return appendBytes(b, 1, value)
+}
+
+// AppendText appends to the encoded Summary message b a value
+// containing a text summary with the provided tag, to be rendered
+// (as Markdown) by TensorBoard's text plugin.
+func appendText(b []byte, tag, text string) []byte {
+	// A string tensor with an empty (scalar) shape.
+	tensor := appendKey(nil, 1, wireVarint)
+	tensor = appendVarint(tensor, dtString)
+	tensor = appendBytes(tensor, 2, nil)
+	tensor = appendBytes(tensor, 8, []byte(text))
+	// SummaryMetadata{plugin_data: PluginData{plugin_name: "text"}}.
+	metadata := appendBytes(nil, 1, appendBytes(nil, 1, []byte("text")))
+
+	value := appendBytes(nil, 1, []byte(tag))
+	value = appendBytes(value, 8, tensor)
+	value = appendBytes(value, 9, metadata)
+	return appendBytes(b, 1, value)
+}
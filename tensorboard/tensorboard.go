@@ -0,0 +1,144 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package tensorboard mirrors the metric histories of diviner runs
+// into TensorBoard [1] event files, so that trials may be compared in
+// TensorBoard alongside other experiments.
+//
+// Each run is written to its own log directory, dir/study/seq, where
+// dir is the root log directory given to TensorBoard (a local path or
+// any URL supported by github.com/grailbio/base/file, e.g., an S3
+// URL), so that TensorBoard displays each run separately and groups
+// them by study:
+//
+//	tensorboard --logdir dir
+//
+// Each of a run's metrics reports is written as a set of scalar
+// summaries, one per metric, at the step of the report (see
+// diviner.Run.History). Reports are not timestamped, so their wall
+// times are spread evenly over the run's lifetime. The run's
+// parameter values are written as a text summary with the tag
+// "values".
+//
+// [1] https://www.tensorflow.org/tensorboard
+package tensorboard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/diviner"
+)
+
+// ValuesTag is the tag of the text summary containing a run's
+// parameter values.
+const ValuesTag = "values"
+
+// Dir returns the log directory of the provided run, relative to the
+// provided root log directory.
+func Dir(root string, run diviner.Run) string {
+	return file.Join(root, run.Study, strconv.FormatUint(run.Seq, 10))
+}
+
+// Filename returns the name of the provided run's event file. The
+// name is a function of the run only, so that files are overwritten
+// when runs are exported repeatedly, as their histories grow.
+func Filename(run diviner.Run) string {
+	return fmt.Sprintf("events.out.tfevents.%d.diviner", run.Created.Unix())
+}
+
+// WriteRun writes the provided run's parameter values and metric
+// history to w as a TensorBoard event file.
+func WriteRun(w io.Writer, run diviner.Run) error {
+	var (
+		history = run.History()
+		start   = run.Created
+		end     = run.Completed
+	)
+	if end.IsZero() {
+		end = run.Updated
+	}
+	if end.Before(start) {
+		end = start
+	}
+	if err := writeRecord(w, encodeEvent(wallTime(start), 0, nil)); err != nil {
+		return err
+	}
+	if len(run.Values) > 0 {
+		summary := appendText(nil, ValuesTag, valuesText(run.Values))
+		if err := writeRecord(w, encodeEvent(wallTime(start), 0, summary)); err != nil {
+			return err
+		}
+	}
+	for i, step := range history {
+		if len(step.Metrics) == 0 {
+			continue
+		}
+		var summary []byte
+		for _, m := range step.Metrics.Sorted() {
+			summary = appendScalar(summary, m.Name, m.Value)
+		}
+		t := start.Add(end.Sub(start) * time.Duration(i+1) / time.Duration(len(history)))
+		if err := writeRecord(w, encodeEvent(wallTime(t), int64(step.Step), summary)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportRun writes the provided run's event file into its log
+// directory under the provided root (see Dir), replacing any event
+// file previously exported for the run.
+func ExportRun(ctx context.Context, root string, run diviner.Run) error {
+	var b bytes.Buffer
+	if err := WriteRun(&b, run); err != nil {
+		return err
+	}
+	return file.WriteFile(ctx, file.Join(Dir(root, run), Filename(run)), b.Bytes())
+}
+
+// Export writes an event file for every run in the named studies
+// that has been updated since the provided time, including pending
+// runs, into the provided root log directory. It returns the number
+// of runs exported.
+func Export(ctx context.Context, db diviner.Database, root string, studies []string, since time.Time) (int, error) {
+	var n int
+	for _, study := range studies {
+		runs, err := db.ListRuns(ctx, study, diviner.Any, since)
+		if err != nil && err != diviner.ErrNotExist {
+			return n, err
+		}
+		for _, run := range runs {
+			if err := ExportRun(ctx, root, run); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ValuesText renders the provided values as a Markdown table.
+func valuesText(values diviner.Values) string {
+	var b strings.Builder
+	b.WriteString("| parameter | value |\n|---|---|\n")
+	for _, v := range values.Sorted() {
+		fmt.Fprintf(&b, "| %s | %s |\n", escapeCell(v.Name), escapeCell(v.Value.String()))
+	}
+	return b.String()
+}
+
+func escapeCell(s string) string {
+	return strings.Replace(s, "|", `\|`, -1)
+}
+
+func wallTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
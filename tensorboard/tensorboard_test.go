@@ -0,0 +1,183 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package tensorboard_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/localdb"
+	"github.com/grailbio/diviner/tensorboard"
+	"github.com/grailbio/testutil"
+)
+
+// Fields decodes the protocol buffer message b into its fields,
+// keyed by field number. Varint and fixed-width fields are returned
+// as their little-endian encodings.
+func fields(t *testing.T, b []byte) map[int][][]byte {
+	t.Helper()
+	m := make(map[int][][]byte)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		var v []byte
+		switch key & 7 {
+		case 0:
+			x, n := binary.Uvarint(b)
+			v = make([]byte, 8)
+			binary.LittleEndian.PutUint64(v, x)
+			b = b[n:]
+		case 1:
+			v, b = b[:8], b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			v, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			v, b = b[:4], b[4:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		m[int(key>>3)] = append(m[int(key>>3)], v)
+	}
+	return m
+}
+
+func records(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+	table := crc32.MakeTable(crc32.Castagnoli)
+	masked := func(p []byte) uint32 {
+		crc := crc32.Checksum(p, table)
+		return (crc>>15 | crc<<17) + 0xa282ead8
+	}
+	var recs [][]byte
+	for len(b) > 0 {
+		n := binary.LittleEndian.Uint64(b)
+		if got, want := binary.LittleEndian.Uint32(b[8:]), masked(b[:8]); got != want {
+			t.Fatalf("got length checksum %x, want %x", got, want)
+		}
+		data := b[12 : 12+n]
+		if got, want := binary.LittleEndian.Uint32(b[12+n:]), masked(data); got != want {
+			t.Fatalf("got data checksum %x, want %x", got, want)
+		}
+		recs = append(recs, data)
+		b = b[16+n:]
+	}
+	return recs
+}
+
+func TestWriteRun(t *testing.T) {
+	created := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	run := diviner.Run{
+		Study:     "test",
+		Seq:       2,
+		State:     diviner.Success,
+		Values:    diviner.Values{"lr": diviner.Float(0.1), "opt": diviner.String("adam")},
+		Created:   created,
+		Completed: created.Add(2 * time.Minute),
+		Metrics:   []diviner.Metrics{{"step": 10, "acc": 0.5}, {"step": 20, "acc": 0.75, "loss": 0.25}},
+	}
+	var b bytes.Buffer
+	if err := tensorboard.WriteRun(&b, run); err != nil {
+		t.Fatal(err)
+	}
+	recs := records(t, b.Bytes())
+	if got, want := len(recs), 4; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := string(fields(t, recs[0])[3][0]), "brain.Event:2"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	event := fields(t, recs[1])
+	value := fields(t, fields(t, event[5][0])[1][0])
+	if got, want := string(value[1][0]), tensorboard.ValuesTag; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	text := string(fields(t, value[8][0])[8][0])
+	if !strings.Contains(text, "| lr | 0.1 |\n| opt | adam |") {
+		t.Errorf("unexpected values text %q", text)
+	}
+
+	type scalar struct {
+		Time float64
+		Step uint64
+		Tag  string
+		V    float32
+	}
+	var scalars []scalar
+	for _, rec := range recs[2:] {
+		event := fields(t, rec)
+		for _, v := range fields(t, event[5][0])[1] {
+			value := fields(t, v)
+			scalars = append(scalars, scalar{
+				math.Float64frombits(binary.LittleEndian.Uint64(event[1][0])),
+				binary.LittleEndian.Uint64(event[2][0]),
+				string(value[1][0]),
+				math.Float32frombits(binary.LittleEndian.Uint32(value[2][0])),
+			})
+		}
+	}
+	start := float64(created.Unix())
+	want := []scalar{
+		{start + 60, 10, "acc", 0.5},
+		{start + 120, 20, "acc", 0.75},
+		{start + 120, 20, "loss", 0.25},
+	}
+	if got := scalars; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	db, err := localdb.Open(filepath.Join(dir, "test.ddb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		run, err := db.InsertRun(ctx, diviner.Run{Study: "test", Values: diviner.Values{"x": diviner.Int(int64(i))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AppendRunMetrics(ctx, "test", run.Seq, diviner.Metrics{"acc": 0.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logdir := filepath.Join(dir, "logs")
+	n, err := tensorboard.Export(ctx, db, logdir, []string{"test", "nonexistent"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run, err := db.LookupRun(ctx, "test", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(logdir, "test", "2", tensorboard.Filename(run))
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(records(t, b)), 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner
+
+import (
+	"fmt"
+	"math"
+)
+
+// Check returns an error describing the first problem, by parameter
+// name, that prevents the provided values from being a valid
+// assignment of the parameters (see IsValid): a missing, unknown, or
+// inactive parameter, or a value that is of the wrong kind or outside
+// of its parameter's range. Check returns nil if the values are
+// valid.
+func (p Params) Check(values Values) error {
+	for _, param := range p.Sorted() {
+		v, ok := values[param.Name]
+		if !IsActive(param.Param, values) {
+			if ok {
+				return fmt.Errorf("parameter %s: value %s given for inactive parameter", param.Name, v)
+			}
+			continue
+		}
+		if !ok {
+			return fmt.Errorf("parameter %s: missing value", param.Name)
+		}
+		if err := checkValue(param.Param, v); err != nil {
+			return fmt.Errorf("parameter %s: %v", param.Name, err)
+		}
+	}
+	for _, v := range values.Sorted() {
+		if _, ok := p[v.Name]; !ok {
+			return fmt.Errorf("parameter %s: no such parameter", v.Name)
+		}
+	}
+	return nil
+}
+
+// Coerce returns a copy of the provided values in which each value
+// is converted to the kind of its parameter, where this is lossless:
+// integer values of real parameters become reals, and integral real
+// values of integer parameters become integers. If clamp is true,
+// numeric values that lie outside of their parameters' ranges are
+// clamped to the nearest value within them. Coerce then checks the
+// values as Check does, returning an error that names the first
+// offending parameter.
+//
+// Coerce is used to validate values that originate outside of
+// diviner, e.g., from oracles and from users, before they reach
+// code that assumes values are of their parameters' kinds.
+func (p Params) Coerce(values Values, clamp bool) (Values, error) {
+	coerced := make(Values, len(values))
+	for name, v := range values {
+		if param, ok := p[name]; ok && v != nil {
+			v = coerceValue(param, v, clamp)
+		}
+		coerced[name] = v
+	}
+	if err := p.Check(coerced); err != nil {
+		return nil, err
+	}
+	return coerced, nil
+}
+
+// CheckValue returns an error if v is not a valid value of param.
+func checkValue(param Param, v Value) error {
+	if v == nil {
+		return fmt.Errorf("missing value")
+	}
+	if kind := param.Kind(); v.Kind() != kind {
+		return fmt.Errorf("value %s is of kind %s, not %s", v, v.Kind(), kind)
+	}
+	if c, ok := param.(*Conditional); ok {
+		param = c.Param
+	}
+	if vec, ok := param.(*Vector); ok {
+		if v.Len() != len(vec.Elems) {
+			return fmt.Errorf("value %s has %d elements, not %d", v, v.Len(), len(vec.Elems))
+		}
+		for i, elem := range vec.Elems {
+			if err := checkValue(elem, v.Index(i)); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		return nil
+	}
+	if !param.IsValid(v) {
+		return fmt.Errorf("value %s is not in %s", v, param)
+	}
+	return nil
+}
+
+// CoerceValue converts v to the kind of param, and clamps it to
+// param's range if clamp is true, where possible. Values that cannot
+// be coerced are returned unchanged.
+func coerceValue(param Param, v Value, clamp bool) Value {
+	if c, ok := param.(*Conditional); ok {
+		param = c.Param
+	}
+	if vec, ok := param.(*Vector); ok {
+		if v.Kind() != Seq || v.Len() != len(vec.Elems) {
+			return v
+		}
+		list := make(List, v.Len())
+		for i, elem := range vec.Elems {
+			list[i] = coerceValue(elem, v.Index(i), clamp)
+		}
+		return list
+	}
+	switch kind := param.Kind(); {
+	case kind == Real && v.Kind() == Integer:
+		v = Float(v.Int())
+	case kind == Integer && v.Kind() == Real:
+		i, err := TryInt(v)
+		if err != nil {
+			return v
+		}
+		v = Int(i)
+	}
+	if !clamp || param.IsValid(v) {
+		return v
+	}
+	switch r := param.(type) {
+	case *Range:
+		return clampRange(r.Start, r.End, v)
+	case *LogRange:
+		return clampRange(r.Start, r.End, v)
+	case *QuantizedRange:
+		if v.Kind() != r.Kind() || r.Len() == 0 {
+			return v
+		}
+		if v.Less(r.Start) {
+			return r.Value(0)
+		}
+		if last := r.Value(r.Len() - 1); last.Less(v) {
+			return last
+		}
+	}
+	return v
+}
+
+// ClampRange clamps v to the half-open range [start, end).
+func clampRange(start, end, v Value) Value {
+	if v.Kind() != start.Kind() {
+		return v
+	}
+	switch v.Kind() {
+	case Integer:
+		if v.Int() < start.Int() {
+			return start
+		}
+		if v.Int() >= end.Int() {
+			return Int(end.Int() - 1)
+		}
+	case Real:
+		if math.IsNaN(v.Float()) {
+			return v
+		}
+		if v.Float() < start.Float() {
+			return start
+		}
+		if v.Float() >= end.Float() {
+			return Float(math.Nextafter(end.Float(), start.Float()))
+		}
+	}
+	return v
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package diviner_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/diviner"
+)
+
+var validateParams = diviner.Params{
+	"layers":    diviner.NewRange(diviner.Int(1), diviner.Int(10)),
+	"lr":        diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1)),
+	"batch":     diviner.NewQuantizedRange(diviner.Int(32), diviner.Int(256), diviner.Int(32)),
+	"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+	"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+	"widths":    diviner.NewVector(diviner.NewRange(diviner.Int(1), diviner.Int(4)), diviner.NewRange(diviner.Float(0), diviner.Float(1))),
+}
+
+func validValues() diviner.Values {
+	return diviner.Values{
+		"layers":    diviner.Int(2),
+		"lr":        diviner.Float(0.01),
+		"batch":     diviner.Int(64),
+		"optimizer": diviner.String("adam"),
+		"widths":    diviner.List{diviner.Int(2), diviner.Float(0.5)},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := validateParams.Check(validValues()); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		value diviner.Value
+		want  string
+	}{
+		{"layers", diviner.Float(2.5), "parameter layers: value 2.5 is of kind real, not integer"},
+		{"layers", diviner.Int(10), "parameter layers: value 10 is not in range(1, 10)"},
+		{"lr", diviner.String("fast"), "parameter lr: value fast is of kind string, not real"},
+		{"batch", diviner.Int(50), "parameter batch: value 50 is not in"},
+		{"optimizer", diviner.String("rmsprop"), "parameter optimizer: value rmsprop is not in"},
+		{"momentum", diviner.Float(0.5), "parameter momentum: value 0.5 given for inactive parameter"},
+		{"widths", diviner.List{diviner.Int(2)}, "parameter widths: value [2] has 1 elements, not 2"},
+		{"widths", diviner.List{diviner.Int(2), diviner.Int(0)}, "parameter widths: element 1: value 0 is of kind integer, not real"},
+		{"other", diviner.Int(1), "parameter other: no such parameter"},
+		{"layers", nil, "parameter layers: missing value"},
+	} {
+		values := validValues()
+		values[test.name] = test.value
+		err := validateParams.Check(values)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%s=%v: got %v, want %v", test.name, test.value, err, test.want)
+		}
+	}
+	values := validValues()
+	delete(values, "lr")
+	if err := validateParams.Check(values); err == nil || err.Error() != "parameter lr: missing value" {
+		t.Errorf("bad error %v", err)
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	values := validValues()
+	values["layers"] = diviner.Float(3)
+	values["lr"] = diviner.Int(1)
+	values["widths"] = diviner.List{diviner.Float(1), diviner.Int(0)}
+	if _, err := validateParams.Coerce(values, false); err == nil || !strings.HasPrefix(err.Error(), "parameter lr:") {
+		t.Errorf("bad error %v", err)
+	}
+	values["lr"] = diviner.Int(0)
+	if _, err := validateParams.Coerce(values, false); err == nil {
+		t.Error("expected error")
+	}
+	coerced, err := validateParams.Coerce(values, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := validValues()
+	want["layers"] = diviner.Int(3)
+	want["lr"] = diviner.Float(1e-4)
+	want["widths"] = diviner.List{diviner.Int(1), diviner.Float(0)}
+	if got := coerced; !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The provided values are not modified.
+	if got, want := values["layers"], diviner.Value(diviner.Float(3)); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, test := range []struct {
+		name       string
+		value      diviner.Value
+		want       diviner.Value
+		wantReject bool
+	}{
+		{"layers", diviner.Int(100), diviner.Int(9), false},
+		{"layers", diviner.Float(-1), diviner.Int(1), false},
+		{"lr", diviner.Float(2), diviner.Float(math.Nextafter(1, 0)), false},
+		{"batch", diviner.Int(300), diviner.Int(224), false},
+		{"batch", diviner.Int(0), diviner.Int(32), false},
+		{"layers", diviner.Float(2.5), nil, true},
+		{"batch", diviner.Int(50), nil, true},
+		{"optimizer", diviner.String("rmsprop"), nil, true},
+	} {
+		values := validValues()
+		values[test.name] = test.value
+		coerced, err := validateParams.Coerce(values, true)
+		if test.wantReject {
+			if err == nil || !strings.Contains(err.Error(), "parameter "+test.name) {
+				t.Errorf("%s=%v: bad error %v", test.name, test.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s=%v: %v", test.name, test.value, err)
+			continue
+		}
+		if got, want := coerced[test.name], test.want; !got.Equal(want) {
+			t.Errorf("%s=%v: got %v, want %v", test.name, test.value, got, want)
+		}
+	}
+}
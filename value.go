@@ -6,11 +6,14 @@ package diviner
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"hash"
 	"hash/fnv"
+	"math"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/grailbio/base/writehash"
 )
@@ -20,6 +23,8 @@ func init() {
 	gob.Register(Int(0))
 	gob.Register(String(""))
 	gob.Register(Bool(false))
+	gob.Register(Duration(0))
+	gob.Register(Time(0))
 	gob.Register(List{})
 	gob.Register(Dict{})
 	gob.Register(&Map{})
@@ -35,6 +40,8 @@ const (
 	Seq
 	ValueDict
 	Boolean
+	Interval
+	Timestamp
 )
 
 func (k Kind) String() string {
@@ -51,6 +58,10 @@ func (k Kind) String() string {
 		return "valuedict"
 	case Boolean:
 		return "boolean"
+	case Interval:
+		return "duration"
+	case Timestamp:
+		return "time"
 	default:
 		panic(k)
 	}
@@ -70,13 +81,18 @@ type Value interface {
 	Equal(Value) bool
 
 	// Less returns true if the value is less than the provided value.
-	// Less is defined only for values of the same type.
+	// Values of the same kind are ordered naturally. Numeric values
+	// (integers and reals) are ordered by their numeric values, with
+	// integers ordered before equal reals; values of other, different
+	// kinds are ordered by their kinds.
 	Less(Value) bool
 
-	// Float returns the floating point value of float-typed values.
+	// Float returns the floating point value of numeric values:
+	// integer values are converted. See TryFloat.
 	Float() float64
 
-	// Int returns the integer value of integer-typed values.
+	// Int returns the integer value of integer-typed values. See
+	// TryInt.
 	Int() int64
 
 	// Str returns the string of string-typed values.
@@ -110,11 +126,65 @@ func Zero(kind Kind) Value {
 		return new(Values)
 	case Boolean:
 		return Bool(false)
+	case Interval:
+		return Duration(0)
+	case Timestamp:
+		return Time(0)
 	case Seq:
 		return new(List)
 	}
 }
 
+// TryInt returns the integer value of v. Integral real values are
+// converted; TryInt returns an error if v is not numeric, or is a
+// real value that is not integral.
+func TryInt(v Value) (int64, error) {
+	if v == nil {
+		return 0, errors.New("nil value is not an integer")
+	}
+	switch v.Kind() {
+	case Integer:
+		return v.Int(), nil
+	case Real:
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("real value %s is not an integer", v)
+		}
+		return int64(f), nil
+	}
+	return 0, fmt.Errorf("%s value %s is not an integer", v.Kind(), v)
+}
+
+// TryFloat returns the floating point value of v, converting
+// integer values. TryFloat returns an error if v is not numeric.
+func TryFloat(v Value) (float64, error) {
+	if v == nil {
+		return 0, errors.New("nil value is not a number")
+	}
+	switch v.Kind() {
+	case Integer, Real:
+		return v.Float(), nil
+	}
+	return 0, fmt.Errorf("%s value %s is not a number", v.Kind(), v)
+}
+
+// KindLess orders a value of the provided kind before the value w of
+// a different kind. Integers and reals are compared numerically by
+// their Less methods; the remaining kinds are ordered by their
+// declaration.
+func kindLess(kind Kind, w Value) bool {
+	return kind < w.Kind()
+}
+
+// ValuesOf returns the value set represented by the ValueDict-kinded
+// value v, which may be a Values or (in older diviner) a *Values.
+func valuesOf(v Value) Values {
+	if p, ok := v.(*Values); ok {
+		return *p
+	}
+	return v.(Values)
+}
+
 // Int is an integer-typed value.
 type Int int64
 
@@ -133,11 +203,17 @@ func (v Int) Equal(w Value) bool {
 
 // Less implements Value.
 func (v Int) Less(w Value) bool {
-	return v.Int() < w.Int()
+	switch w.Kind() {
+	case Integer:
+		return v.Int() < w.Int()
+	case Real:
+		return float64(v) <= w.Float()
+	}
+	return kindLess(Integer, w)
 }
 
-// Float implements Value.
-func (Int) Float() float64 { panic("Float on Int") }
+// Float implements Value. The integer is converted to a float.
+func (v Int) Float() float64 { return float64(v) }
 
 // Str implements Value.
 func (Int) Str() string { panic("Str on Int") }
@@ -175,7 +251,11 @@ func (v Float) Equal(w Value) bool {
 
 // Less implements Value.
 func (v Float) Less(w Value) bool {
-	return v.Float() < w.Float()
+	switch w.Kind() {
+	case Integer, Real:
+		return v.Float() < w.Float()
+	}
+	return kindLess(Real, w)
 }
 
 // Float implements Value.
@@ -211,6 +291,9 @@ func (v String) Equal(w Value) bool {
 
 // Less implements Value.
 func (v String) Less(w Value) bool {
+	if w.Kind() != Str {
+		return kindLess(Str, w)
+	}
 	return v.Str() < w.Str()
 }
 
@@ -259,6 +342,9 @@ func (v Bool) Equal(w Value) bool {
 
 // Less implements Value.
 func (v Bool) Less(w Value) bool {
+	if w.Kind() != Boolean {
+		return kindLess(Boolean, w)
+	}
 	return !v.Bool() && w.Bool()
 }
 
@@ -284,6 +370,137 @@ func (v Bool) Hash(h hash.Hash) {
 	writehash.Bool(h, bool(v))
 }
 
+// Duration is a duration-typed value, for example the interval
+// between checkpoints of a schedule.
+type Duration time.Duration
+
+// ParseDuration parses a duration value, as in time.ParseDuration:
+// e.g., "30m" or "1h30m".
+func ParseDuration(s string) (Duration, error) {
+	d, err := time.ParseDuration(s)
+	return Duration(d), err
+}
+
+// String implements Value. Durations are formatted as by
+// time.Duration.String, and may be parsed by ParseDuration.
+func (v Duration) String() string { return time.Duration(v).String() }
+
+// Kind implements Value.
+func (Duration) Kind() Kind { return Interval }
+
+func (v Duration) Equal(w Value) bool {
+	if w.Kind() != Interval {
+		return false
+	}
+	return v == durationOf(w)
+}
+
+// Less implements Value.
+func (v Duration) Less(w Value) bool {
+	if w.Kind() != Interval {
+		return kindLess(Interval, w)
+	}
+	return v < durationOf(w)
+}
+
+// DurationOf returns the duration of the Interval-kinded value v,
+// which may be a Duration or a *Duration.
+func durationOf(v Value) Duration {
+	if p, ok := v.(*Duration); ok {
+		return *p
+	}
+	return v.(Duration)
+}
+
+// Duration returns the duration of the value.
+func (v Duration) Duration() time.Duration { return time.Duration(v) }
+
+func (Duration) Float() float64                      { panic("Float on Duration") }
+func (Duration) Int() int64                          { panic("Int on Duration") }
+func (Duration) Str() string                         { panic("Str on Duration") }
+func (Duration) Bool() bool                          { panic("Bool on Duration") }
+func (Duration) Len() int                            { panic("Len on Duration") }
+func (Duration) Index(int) Value                     { panic("Index on Duration") }
+func (Duration) Put(key string, value Value)         { panic("Put on Duration") }
+func (Duration) Get(key string) Value                { panic("Get on Duration") }
+func (Duration) Range(func(key string, value Value)) { panic("Range on Duration") }
+
+func (v Duration) Hash(h hash.Hash) {
+	writehash.String(h, "duration")
+	writehash.Int64(h, int64(v))
+}
+
+// Time is a timestamp-typed value, for example the time at which a
+// schedule begins. Times are represented by the number of nanoseconds
+// elapsed since the Unix epoch, so that they are directly comparable.
+type Time int64
+
+// NewTime returns the time value representing t.
+func NewTime(t time.Time) Time { return Time(t.UnixNano()) }
+
+// ParseTime parses a time value in the RFC 3339 format, e.g.,
+// "2019-06-01T12:00:00Z", or a date in the format "2006-01-02",
+// which denotes midnight UTC.
+func ParseTime(s string) (Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		var err1 error
+		if t, err1 = time.Parse("2006-01-02", s); err1 != nil {
+			return 0, err
+		}
+	}
+	return NewTime(t), nil
+}
+
+// String implements Value. Times are formatted in UTC in the RFC 3339
+// format, and may be parsed by ParseTime.
+func (v Time) String() string { return v.Time().Format(time.RFC3339Nano) }
+
+// Kind implements Value.
+func (Time) Kind() Kind { return Timestamp }
+
+func (v Time) Equal(w Value) bool {
+	if w.Kind() != Timestamp {
+		return false
+	}
+	return v == timeOf(w)
+}
+
+// Less implements Value.
+func (v Time) Less(w Value) bool {
+	if w.Kind() != Timestamp {
+		return kindLess(Timestamp, w)
+	}
+	return v < timeOf(w)
+}
+
+// TimeOf returns the time of the Timestamp-kinded value v, which may
+// be a Time or a *Time.
+func timeOf(v Value) Time {
+	if p, ok := v.(*Time); ok {
+		return *p
+	}
+	return v.(Time)
+}
+
+// Time returns the (UTC) time of the value.
+func (v Time) Time() time.Time { return time.Unix(0, int64(v)).UTC() }
+
+func (Time) Float() float64                      { panic("Float on Time") }
+func (Time) Int() int64                          { panic("Int on Time") }
+func (Time) Str() string                         { panic("Str on Time") }
+func (Time) Bool() bool                          { panic("Bool on Time") }
+func (Time) Len() int                            { panic("Len on Time") }
+func (Time) Index(int) Value                     { panic("Index on Time") }
+func (Time) Put(key string, value Value)         { panic("Put on Time") }
+func (Time) Get(key string) Value                { panic("Get on Time") }
+func (Time) Range(func(key string, value Value)) { panic("Range on Time") }
+
+func (v Time) Hash(h hash.Hash) {
+	writehash.String(h, "time")
+	writehash.Int64(h, int64(v))
+}
+
 // List is a list-typed value.
 type List []Value
 
@@ -304,6 +521,9 @@ func (l List) Equal(m Value) bool {
 
 // Less implements Value.
 func (l List) Less(m Value) bool {
+	if m.Kind() != Seq {
+		return kindLess(Seq, m)
+	}
 	for i := 0; i < l.Len(); i++ {
 		if m.Len() <= i {
 			break
@@ -409,11 +629,33 @@ func (v Values) Equal(wv Value) bool {
 	return true
 }
 
-func (Values) Less(Value) bool { panic("Less on Values") }
-func (Values) Float() float64  { panic("Float on Values") }
-func (Values) Int() int64      { panic("Int on Values") }
-func (Values) Str() string     { panic("Str on Values") }
-func (Values) Bool() bool      { panic("Bool on Values") }
+// Less implements Value. Value sets are ordered lexicographically by
+// their sorted names and values.
+func (v Values) Less(wv Value) bool {
+	if wv.Kind() != ValueDict {
+		return kindLess(ValueDict, wv)
+	}
+	vlist, wlist := v.Sorted(), valuesOf(wv).Sorted()
+	for i := range vlist {
+		if i == len(wlist) {
+			return false
+		}
+		switch {
+		case vlist[i].Name != wlist[i].Name:
+			return vlist[i].Name < wlist[i].Name
+		case vlist[i].Value.Less(wlist[i].Value):
+			return true
+		case wlist[i].Value.Less(vlist[i].Value):
+			return false
+		}
+	}
+	return len(vlist) < len(wlist)
+}
+
+func (Values) Float() float64 { panic("Float on Values") }
+func (Values) Int() int64     { panic("Int on Values") }
+func (Values) Str() string    { panic("Str on Values") }
+func (Values) Bool() bool     { panic("Bool on Values") }
 
 func (v Values) Len() int { return len(v) }
 
@@ -5,7 +5,9 @@
 package diviner_test
 
 import (
+	"math"
 	"testing"
+	"time"
 
 	"github.com/grailbio/diviner"
 )
@@ -33,6 +35,160 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestBool(t *testing.T) {
+	f, tr := diviner.Bool(false), diviner.Bool(true)
+	if !f.Less(tr) || tr.Less(f) || f.Less(f) {
+		t.Error("wrong boolean order")
+	}
+	if !tr.Equal(diviner.Bool(true)) || tr.Equal(f) || tr.Equal(diviner.Int(1)) {
+		t.Error("wrong boolean equality")
+	}
+	if diviner.Hash(f) == diviner.Hash(tr) {
+		t.Error("boolean hash collision")
+	}
+	values := diviner.Values{"batchnorm": tr, "dropout": f}
+	if got, want := values.String(), "batchnorm=true,dropout=false"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	if got, want := diviner.Int(3).Float(), 3.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, test := range []struct {
+		v    diviner.Value
+		want int64
+		err  string
+	}{
+		{diviner.Int(-4), -4, ""},
+		{diviner.Float(12), 12, ""},
+		{diviner.Float(1.5), 0, "real value 1.5 is not an integer"},
+		{diviner.Float(math.Inf(1)), 0, "real value +Inf is not an integer"},
+		{diviner.String("x"), 0, "string value x is not an integer"},
+		{nil, 0, "nil value is not an integer"},
+	} {
+		got, err := diviner.TryInt(test.v)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: got %v, want %v", test.v, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.v, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+	if got, err := diviner.TryFloat(diviner.Int(2)); err != nil || got != 2 {
+		t.Errorf("got %v, %v, want 2", got, err)
+	}
+	if got, err := diviner.TryFloat(diviner.Float(0.5)); err != nil || got != 0.5 {
+		t.Errorf("got %v, %v, want 0.5", got, err)
+	}
+	if _, err := diviner.TryFloat(diviner.Bool(true)); err == nil || err.Error() != "boolean value true is not a number" {
+		t.Errorf("bad error %v", err)
+	}
+}
+
+func TestLessKinds(t *testing.T) {
+	// Each value is less than all of the values that follow it.
+	ordered := []diviner.Value{
+		diviner.Float(-1),
+		diviner.Int(0),
+		diviner.Float(0.5),
+		diviner.Int(1),
+		diviner.Float(1),
+		diviner.Int(2),
+		diviner.String("a"),
+		diviner.String("b"),
+		diviner.Bool(false),
+		diviner.Bool(true),
+		diviner.Duration(time.Second),
+		diviner.Duration(time.Minute),
+		diviner.Time(0),
+	}
+	for i, v := range ordered {
+		for j, w := range ordered {
+			if got, want := v.Less(w), i < j; got != want {
+				t.Errorf("%s(%s).Less(%s(%s)): got %v, want %v", v.Kind(), v, w.Kind(), w, got, want)
+			}
+		}
+	}
+}
+
+func TestValuesLess(t *testing.T) {
+	ordered := []diviner.Values{
+		{},
+		{"a": diviner.Int(1)},
+		{"a": diviner.Int(1), "b": diviner.Int(1)},
+		{"a": diviner.Int(2)},
+		{"b": diviner.Int(0)},
+	}
+	for i, v := range ordered {
+		for j, w := range ordered {
+			if got, want := v.Less(w), i < j; got != want {
+				t.Errorf("{%s}.Less({%s}): got %v, want %v", v, w, got, want)
+			}
+		}
+	}
+	if !ordered[1].Less(&ordered[3]) {
+		t.Error("expected less")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	d, err := diviner.ParseDuration("1h30m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.Duration(), 90*time.Minute; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := d.String(), "1h30m0s"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !diviner.Duration(time.Minute).Less(d) || d.Less(d) || !d.Equal(diviner.Duration(90*time.Minute)) {
+		t.Error("wrong duration order")
+	}
+	if d.Equal(diviner.Int(int64(d))) || diviner.Hash(d) == diviner.Hash(diviner.Int(int64(d))) {
+		t.Error("duration confused with integer")
+	}
+	if _, err := diviner.ParseDuration("fast"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTime(t *testing.T) {
+	for _, test := range []struct {
+		s, want string
+	}{
+		{"2019-06-01T12:00:00Z", "2019-06-01T12:00:00Z"},
+		{"2019-06-01T12:00:00.25-07:00", "2019-06-01T19:00:00.25Z"},
+		{"2019-06-01", "2019-06-01T00:00:00Z"},
+	} {
+		v, err := diviner.ParseTime(test.s)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if got, want := v.String(), test.want; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	start := diviner.NewTime(time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC))
+	end := diviner.NewTime(start.Time().Add(time.Hour))
+	if !start.Less(end) || end.Less(start) || !start.Equal(start) || start.Equal(end) {
+		t.Error("wrong time order")
+	}
+	if _, err := diviner.ParseTime("yesterday"); err == nil {
+		t.Error("expected error")
+	}
+}
+
 func TestHash(t *testing.T) {
 	for i, test := range []struct {
 		val  diviner.Value
@@ -0,0 +1,288 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package vizier
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"google.golang.org/grpc"
+)
+
+func init() {
+	gob.Register(&Oracle{})
+}
+
+const (
+	defaultStudy   = "diviner"
+	defaultTimeout = 5 * time.Minute
+	clientID       = "diviner"
+)
+
+// Oracle is a diviner.Oracle (and diviner.MultiOracle) that requests
+// trials from a vizier.VizierService service. Oracle supports range,
+// log range, quantized range, and discrete parameters; discrete
+// parameters of strings are categorical.
+type Oracle struct {
+	// Addr is the address of the service.
+	Addr string
+	// Study is the name of the study, as presented to the service.
+	// Defaults to "diviner".
+	Study string
+	// Algorithm names the algorithm that the service should use to
+	// suggest trials. Its interpretation is up to the service.
+	Algorithm string
+	// Timeout is the maximum duration of each request to the service.
+	// Defaults to 5 minutes.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn
+}
+
+// New returns a new Oracle that requests trials for the named study
+// from the service at the provided address.
+func New(addr, study string) *Oracle {
+	return &Oracle{Addr: addr, Study: study}
+}
+
+// String returns a textual description of the oracle.
+func (o *Oracle) String() string {
+	return fmt.Sprintf("vizier(addr=%s, study=%s, algorithm=%s)", o.Addr, o.study(), o.Algorithm)
+}
+
+func (o *Oracle) study() string {
+	if o.Study == "" {
+		return defaultStudy
+	}
+	return o.Study
+}
+
+// Next implements diviner.Oracle.
+func (o *Oracle) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	return o.NextMulti(previous, params, []diviner.Objective{objective}, howmany)
+}
+
+// NextMulti implements diviner.MultiOracle.
+func (o *Oracle) NextMulti(previous []diviner.Trial, params diviner.Params, objectives []diviner.Objective, howmany int) ([]diviner.Values, error) {
+	spec, err := Spec(params, objectives)
+	if err != nil {
+		return nil, err
+	}
+	spec.Algorithm = o.Algorithm
+	req := &SuggestTrialsRequest{
+		Parent:          o.study(),
+		StudySpec:       spec,
+		SuggestionCount: howmany,
+		ClientID:        clientID,
+		Trials:          make([]Trial, len(previous)),
+	}
+	for i, trial := range previous {
+		req.Trials[i] = EncodeTrial(trial, objectives)
+		req.Trials[i].ID = strconv.Itoa(i + 1)
+	}
+	conn, err := o.dial()
+	if err != nil {
+		return nil, err
+	}
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	reply := new(SuggestTrialsResponse)
+	if err := conn.Invoke(ctx, "/"+serviceName+"/SuggestTrials", req, reply, grpc.CallContentSubtype(codecName)); err != nil {
+		return nil, fmt.Errorf("vizier %s: %v", o.Addr, err)
+	}
+	if howmany > 0 && len(reply.Trials) > howmany {
+		reply.Trials = reply.Trials[:howmany]
+	}
+	values := make([]diviner.Values, len(reply.Trials))
+	for i, trial := range reply.Trials {
+		if values[i], err = DecodeValues(params, trial.Parameters); err != nil {
+			return nil, fmt.Errorf("vizier %s: suggested trial %d: %v", o.Addr, i, err)
+		}
+	}
+	return values, nil
+}
+
+// Dial returns the oracle's connection to its service, dialing it if
+// it has not already been dialed. Connections are insecure.
+func (o *Oracle) dial() (*grpc.ClientConn, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn != nil {
+		return o.conn, nil
+	}
+	conn, err := grpc.Dial(o.Addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	o.conn = conn
+	return conn, nil
+}
+
+// Spec returns the study spec describing the provided parameters and
+// objectives. Spec returns an error if a parameter is not supported.
+func Spec(params diviner.Params, objectives []diviner.Objective) (StudySpec, error) {
+	var spec StudySpec
+	for _, obj := range objectives {
+		goal := Maximize
+		if obj.Direction == diviner.Minimize {
+			goal = Minimize
+		}
+		spec.Metrics = append(spec.Metrics, MetricSpec{MetricID: obj.Metric, Goal: goal})
+	}
+	for _, p := range params.Sorted() {
+		ps := ParameterSpec{ParameterID: p.Name}
+		var start, end diviner.Value
+		switch param := p.Param.(type) {
+		case *diviner.Range:
+			start, end = param.Start, param.End
+			ps.ScaleType = LinearScale
+		case *diviner.LogRange:
+			start, end = param.Start, param.End
+			ps.ScaleType = LogScale
+		}
+		switch p.Param.(type) {
+		case *diviner.Range, *diviner.LogRange:
+			switch p.Kind() {
+			case diviner.Integer:
+				ps.IntegerValueSpec = &IntegerValueSpec{MinValue: start.Int(), MaxValue: end.Int() - 1}
+			case diviner.Real:
+				ps.DoubleValueSpec = &DoubleValueSpec{MinValue: start.Float(), MaxValue: end.Float()}
+			default:
+				return StudySpec{}, fmt.Errorf("vizier: parameter %s: unsupported range kind %s", p.Name, p.Kind())
+			}
+		case *diviner.QuantizedRange, *diviner.Discrete, *diviner.Enum:
+			switch p.Kind() {
+			case diviner.Integer, diviner.Real:
+				spec := new(DiscreteValueSpec)
+				for _, v := range p.Values() {
+					spec.Values = append(spec.Values, v.Float())
+				}
+				ps.DiscreteValueSpec = spec
+			case diviner.Str:
+				spec := new(CategoricalValueSpec)
+				for _, v := range p.Values() {
+					spec.Values = append(spec.Values, v.Str())
+				}
+				ps.CategoricalValueSpec = spec
+			default:
+				return StudySpec{}, fmt.Errorf("vizier: parameter %s: unsupported discrete kind %s", p.Name, p.Kind())
+			}
+		default:
+			return StudySpec{}, fmt.Errorf("vizier: parameter %s: unsupported parameter %s", p.Name, p.Param)
+		}
+		spec.Parameters = append(spec.Parameters, ps)
+	}
+	return spec, nil
+}
+
+// EncodeTrial returns the provided trial as a Trial message. Pending
+// trials are active; completed trials that do not report all of the
+// objectives' metrics, or that violate their study's constraints
+// (diviner.Trial.Infeasible), are infeasible.
+func EncodeTrial(trial diviner.Trial, objectives []diviner.Objective) Trial {
+	var t Trial
+	for _, v := range trial.Values.Sorted() {
+		var value interface{}
+		switch v.Kind() {
+		case diviner.Integer, diviner.Real:
+			value = v.Value.Float()
+		case diviner.Str:
+			value = v.Str()
+		default:
+			value = v.String()
+		}
+		t.Parameters = append(t.Parameters, TrialParameter{ParameterID: v.Name, Value: value})
+	}
+	switch {
+	case trial.Pending:
+		t.State = Active
+	case trial.Infeasible || !reports(trial, objectives):
+		t.State = Infeasible
+	default:
+		t.State = Succeeded
+		t.FinalMeasurement = new(Measurement)
+		for _, obj := range objectives {
+			t.FinalMeasurement.Metrics = append(t.FinalMeasurement.Metrics, Metric{MetricID: obj.Metric, Value: trial.Metrics[obj.Metric]})
+		}
+	}
+	return t
+}
+
+// DecodeValues returns the parameter values represented by the
+// provided trial parameters. Numeric values of integer parameters are
+// rounded, and those of discrete parameters are mapped to the nearest
+// of the parameters' values. DecodeValues returns an error if the
+// values are not valid for the provided parameters.
+func DecodeValues(params diviner.Params, parameters []TrialParameter) (diviner.Values, error) {
+	values := make(diviner.Values)
+	for _, tp := range parameters {
+		param, ok := params[tp.ParameterID]
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter %s", tp.ParameterID)
+		}
+		var v diviner.Value
+		switch value := tp.Value.(type) {
+		case float64:
+			switch param.(type) {
+			case *diviner.QuantizedRange, *diviner.Discrete, *diviner.Enum:
+				v = nearest(param.Values(), value)
+			default:
+				if param.Kind() == diviner.Integer {
+					v = diviner.Int(int64(math.Round(value)))
+				} else {
+					v = diviner.Float(value)
+				}
+			}
+		case string:
+			v = diviner.String(value)
+		default:
+			return nil, fmt.Errorf("parameter %s: invalid value %v", tp.ParameterID, tp.Value)
+		}
+		if v == nil || !param.IsValid(v) {
+			return nil, fmt.Errorf("parameter %s: invalid value %v", tp.ParameterID, tp.Value)
+		}
+		values[tp.ParameterID] = v
+	}
+	if !params.IsValid(values) {
+		return nil, fmt.Errorf("invalid values %s", values)
+	}
+	return values, nil
+}
+
+// Reports tells whether the trial reports all of the objectives'
+// metrics.
+func reports(trial diviner.Trial, objectives []diviner.Objective) bool {
+	for _, obj := range objectives {
+		if v, ok := trial.Metrics[obj.Metric]; !ok || math.IsNaN(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Nearest returns the numeric value in values that is nearest to f.
+func nearest(values []diviner.Value, f float64) diviner.Value {
+	var (
+		best     diviner.Value
+		distance = math.Inf(1)
+	)
+	for _, v := range values {
+		if d := math.Abs(v.Float() - f); d < distance {
+			best, distance = v, d
+		}
+	}
+	return best
+}
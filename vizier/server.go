@@ -0,0 +1,45 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package vizier
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// A Suggester implements the vizier.VizierService service.
+type Suggester interface {
+	// SuggestTrials returns up to req.SuggestionCount new trials for
+	// the study described by the request.
+	SuggestTrials(ctx context.Context, req *SuggestTrialsRequest) (*SuggestTrialsResponse, error)
+}
+
+// Register registers a vizier.VizierService service, serving the
+// provided suggester, with the provided gRPC server.
+func Register(s *grpc.Server, suggester Suggester) {
+	const method = "SuggestTrials"
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*Suggester)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: method,
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := new(SuggestTrialsRequest)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(Suggester).SuggestTrials(ctx, req.(*SuggestTrialsRequest))
+				}
+				if interceptor == nil {
+					return handler(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + method}
+				return interceptor(ctx, req, info, handler)
+			},
+		}},
+	}, suggester)
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package vizier implements a diviner oracle that delegates to an
+// external suggestion service, so that optimizers written in other
+// languages (e.g., Python optimizers such as Ax, Optuna, or
+// Nevergrad) may be used without porting them to Go.
+//
+// The service, vizier.VizierService, is a gRPC service modeled on the
+// suggestion API of OSS Vizier. Its single method, SuggestTrials,
+// takes a SuggestTrialsRequest and returns a SuggestTrialsResponse.
+// The messages' field names follow those of Vizier's StudySpec,
+// ParameterSpec, MetricSpec, Trial, and Measurement messages, but
+// they are encoded as JSON (with the gRPC content subtype "json"),
+// so that services need not compile Vizier's protocol buffers:
+//
+//	{
+//	  "parent": "study",
+//	  "suggestion_count": 2,
+//	  "client_id": "diviner",
+//	  "study_spec": {
+//	    "algorithm": "tpe",
+//	    "metrics": [{"metric_id": "acc", "goal": "MAXIMIZE"}],
+//	    "parameters": [
+//	      {"parameter_id": "lr", "double_value_spec": {"min_value": 0.0001, "max_value": 1}, "scale_type": "UNIT_LOG_SCALE"},
+//	      {"parameter_id": "layers", "integer_value_spec": {"min_value": 1, "max_value": 9}},
+//	      {"parameter_id": "activation", "categorical_value_spec": {"values": ["relu", "tanh"]}}
+//	    ]
+//	  },
+//	  "trials": [
+//	    {
+//	      "id": "1",
+//	      "state": "SUCCEEDED",
+//	      "parameters": [{"parameter_id": "lr", "value": 0.01}, ...],
+//	      "final_measurement": {"metrics": [{"metric_id": "acc", "value": 0.9}]}
+//	    }
+//	  ]
+//	}
+//
+// Unlike Vizier, the service need not keep track of a study's
+// trials: as with all diviner oracles, each request includes all of
+// the study's previous trials, and so services may be stateless.
+// Pending trials have the state ACTIVE; trials that did not report
+// all of the study's metrics have the state INFEASIBLE. The
+// response's trials need only include their parameters.
+//
+// Python services may be implemented with grpcio's generic handlers,
+// using json.loads and json.dumps to deserialize requests and
+// serialize responses. Go services may be registered with Register.
+package vizier
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// serviceName is the fully qualified name of the gRPC service.
+const serviceName = "vizier.VizierService"
+
+// codecName is the gRPC content subtype used by the service.
+const codecName = "json"
+
+// Codec implements a gRPC codec (encoding.Codec) using JSON.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (codec) Name() string { return codecName }
+
+// Metric goals.
+const (
+	Maximize = "MAXIMIZE"
+	Minimize = "MINIMIZE"
+)
+
+// Parameter scale types.
+const (
+	LinearScale = "UNIT_LINEAR_SCALE"
+	LogScale    = "UNIT_LOG_SCALE"
+)
+
+// Trial states.
+const (
+	// Active trials are pending.
+	Active = "ACTIVE"
+	// Succeeded trials have completed, reporting all of the study's
+	// metrics.
+	Succeeded = "SUCCEEDED"
+	// Infeasible trials have completed without reporting all of the
+	// study's metrics.
+	Infeasible = "INFEASIBLE"
+)
+
+// A StudySpec describes the parameters and objectives of a study.
+type StudySpec struct {
+	// Algorithm names the algorithm that the service should use to
+	// suggest trials. Its interpretation is up to the service.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Metrics are the study's objectives.
+	Metrics []MetricSpec `json:"metrics"`
+	// Parameters are the study's parameters.
+	Parameters []ParameterSpec `json:"parameters"`
+}
+
+// A MetricSpec describes one of a study's objectives.
+type MetricSpec struct {
+	// MetricID is the name of the metric.
+	MetricID string `json:"metric_id"`
+	// Goal is the direction in which the metric is optimized:
+	// Maximize or Minimize.
+	Goal string `json:"goal"`
+}
+
+// A ParameterSpec describes one of a study's parameters. Exactly one
+// of its value specs is set.
+type ParameterSpec struct {
+	// ParameterID is the name of the parameter.
+	ParameterID string `json:"parameter_id"`
+	// DoubleValueSpec describes real ranges.
+	DoubleValueSpec *DoubleValueSpec `json:"double_value_spec,omitempty"`
+	// IntegerValueSpec describes integer ranges.
+	IntegerValueSpec *IntegerValueSpec `json:"integer_value_spec,omitempty"`
+	// CategoricalValueSpec describes sets of unordered (string)
+	// values.
+	CategoricalValueSpec *CategoricalValueSpec `json:"categorical_value_spec,omitempty"`
+	// DiscreteValueSpec describes sets of numeric values.
+	DiscreteValueSpec *DiscreteValueSpec `json:"discrete_value_spec,omitempty"`
+	// ScaleType is the scale of range parameters: LinearScale or
+	// LogScale.
+	ScaleType string `json:"scale_type,omitempty"`
+}
+
+// A DoubleValueSpec describes the real range [MinValue, MaxValue].
+type DoubleValueSpec struct {
+	MinValue float64 `json:"min_value"`
+	MaxValue float64 `json:"max_value"`
+}
+
+// An IntegerValueSpec describes the integer range [MinValue,
+// MaxValue].
+type IntegerValueSpec struct {
+	MinValue int64 `json:"min_value"`
+	MaxValue int64 `json:"max_value"`
+}
+
+// A CategoricalValueSpec describes a set of string values.
+type CategoricalValueSpec struct {
+	Values []string `json:"values"`
+}
+
+// A DiscreteValueSpec describes an ordered set of numeric values.
+type DiscreteValueSpec struct {
+	Values []float64 `json:"values"`
+}
+
+// A Trial is a set of parameter values, together with its final
+// measurement if it has completed.
+type Trial struct {
+	// ID is the trial's identifier, unique within the request.
+	ID string `json:"id,omitempty"`
+	// State is the state of the trial: Active, Succeeded, or
+	// Infeasible.
+	State string `json:"state,omitempty"`
+	// Parameters are the trial's parameter values.
+	Parameters []TrialParameter `json:"parameters"`
+	// FinalMeasurement contains the metrics reported by the trial.
+	FinalMeasurement *Measurement `json:"final_measurement,omitempty"`
+}
+
+// A TrialParameter is the value of one of a trial's parameters.
+type TrialParameter struct {
+	// ParameterID is the name of the parameter.
+	ParameterID string `json:"parameter_id"`
+	// Value is the parameter's value: a number, or a string for
+	// categorical parameters.
+	Value interface{} `json:"value"`
+}
+
+// A Measurement contains the metrics reported by a trial.
+type Measurement struct {
+	Metrics []Metric `json:"metrics"`
+}
+
+// A Metric is a metric reported by a trial.
+type Metric struct {
+	MetricID string  `json:"metric_id"`
+	Value    float64 `json:"value"`
+}
+
+// SuggestTrialsRequest is the request message of SuggestTrials.
+type SuggestTrialsRequest struct {
+	// Parent is the name of the study.
+	Parent string `json:"parent"`
+	// StudySpec describes the study's parameters and objectives.
+	StudySpec StudySpec `json:"study_spec"`
+	// SuggestionCount is the number of trials requested.
+	SuggestionCount int `json:"suggestion_count"`
+	// ClientID identifies the client making the request.
+	ClientID string `json:"client_id"`
+	// Trials are the study's previous trials.
+	Trials []Trial `json:"trials"`
+}
+
+// SuggestTrialsResponse is the response message of SuggestTrials.
+type SuggestTrialsResponse struct {
+	// Trials are the suggested trials. Services may suggest fewer
+	// trials than requested; a response without trials indicates that
+	// the service is exhausted.
+	Trials []Trial `json:"trials"`
+}
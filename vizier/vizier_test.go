@@ -0,0 +1,177 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package vizier_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/vizier"
+	"google.golang.org/grpc"
+)
+
+// Suggester is a vizier.Suggester that records its requests, and
+// suggests values determined by the number of previous trials.
+type suggester struct {
+	mu  sync.Mutex
+	req *vizier.SuggestTrialsRequest
+}
+
+func (s *suggester) SuggestTrials(ctx context.Context, req *vizier.SuggestTrialsRequest) (*vizier.SuggestTrialsResponse, error) {
+	s.mu.Lock()
+	s.req = req
+	s.mu.Unlock()
+	resp := new(vizier.SuggestTrialsResponse)
+	for i := 0; i < req.SuggestionCount; i++ {
+		var (
+			trial  vizier.Trial
+			offset = float64(len(req.Trials) + i)
+		)
+		for _, p := range req.StudySpec.Parameters {
+			var value interface{}
+			switch {
+			case p.DoubleValueSpec != nil:
+				value = p.DoubleValueSpec.MaxValue / (offset + 1)
+			case p.IntegerValueSpec != nil:
+				value = float64(p.IntegerValueSpec.MinValue) + offset
+			case p.CategoricalValueSpec != nil:
+				value = p.CategoricalValueSpec.Values[len(p.CategoricalValueSpec.Values)-1]
+			case p.DiscreteValueSpec != nil:
+				// The nearest discrete value is used.
+				value = p.DiscreteValueSpec.Values[0] + 0.1
+			}
+			trial.Parameters = append(trial.Parameters, vizier.TrialParameter{ParameterID: p.ParameterID, Value: value})
+		}
+		resp.Trials = append(resp.Trials, trial)
+	}
+	return resp, nil
+}
+
+func TestOracle(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		srv = grpc.NewServer()
+		s   = new(suggester)
+	)
+	vizier.Register(srv, s)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	params := diviner.Params{
+		"lr":         diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1)),
+		"layers":     diviner.NewRange(diviner.Int(1), diviner.Int(10)),
+		"activation": diviner.NewDiscrete(diviner.String("relu"), diviner.String("tanh")),
+		"width":      diviner.NewDiscrete(diviner.Int(32), diviner.Int(64)),
+	}
+	objective := diviner.Objective{Direction: diviner.Minimize, Metric: "loss"}
+	previous := []diviner.Trial{
+		{
+			Values:  diviner.Values{"lr": diviner.Float(0.01), "layers": diviner.Int(2), "activation": diviner.String("relu"), "width": diviner.Int(64)},
+			Metrics: diviner.Metrics{"loss": 0.5},
+		},
+		{
+			Values:  diviner.Values{"lr": diviner.Float(0.1), "layers": diviner.Int(3), "activation": diviner.String("tanh"), "width": diviner.Int(32)},
+			Pending: true,
+		},
+		{
+			Values: diviner.Values{"lr": diviner.Float(0.2), "layers": diviner.Int(4), "activation": diviner.String("tanh"), "width": diviner.Int(32)},
+		},
+	}
+	o := &vizier.Oracle{Addr: l.Addr().String(), Study: "test", Algorithm: "tpe"}
+	values, err := o.Next(previous, params, objective, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []diviner.Values{
+		{"lr": diviner.Float(0.25), "layers": diviner.Int(4), "activation": diviner.String("tanh"), "width": diviner.Int(32)},
+		{"lr": diviner.Float(0.2), "layers": diviner.Int(5), "activation": diviner.String("tanh"), "width": diviner.Int(32)},
+	}
+	if got := values; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	s.mu.Lock()
+	req := s.req
+	s.mu.Unlock()
+	if got, want := req.Parent, "test"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := req.StudySpec.Algorithm, "tpe"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := req.StudySpec.Metrics, []vizier.MetricSpec{{MetricID: "loss", Goal: vizier.Minimize}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	wantParams := []vizier.ParameterSpec{
+		{ParameterID: "activation", CategoricalValueSpec: &vizier.CategoricalValueSpec{Values: []string{"relu", "tanh"}}},
+		{ParameterID: "layers", IntegerValueSpec: &vizier.IntegerValueSpec{MinValue: 1, MaxValue: 9}, ScaleType: vizier.LinearScale},
+		{ParameterID: "lr", DoubleValueSpec: &vizier.DoubleValueSpec{MinValue: 1e-4, MaxValue: 1}, ScaleType: vizier.LogScale},
+		{ParameterID: "width", DiscreteValueSpec: &vizier.DiscreteValueSpec{Values: []float64{32, 64}}},
+	}
+	if got, want := req.StudySpec.Parameters, wantParams; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(req.Trials), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, state := range []string{vizier.Succeeded, vizier.Active, vizier.Infeasible} {
+		if got, want := req.Trials[i].State, state; got != want {
+			t.Errorf("trial %d: got %v, want %v", i, got, want)
+		}
+	}
+	if got, want := req.Trials[0].FinalMeasurement, (&vizier.Measurement{Metrics: []vizier.Metric{{MetricID: "loss", Value: 0.5}}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := req.Trials[0].Parameters[0], (vizier.TrialParameter{ParameterID: "activation", Value: "relu"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEncodeInfeasible(t *testing.T) {
+	objectives := []diviner.Objective{{Direction: diviner.Minimize, Metric: "loss"}}
+	trial := diviner.Trial{
+		Values:     diviner.Values{"lr": diviner.Float(0.1)},
+		Metrics:    diviner.Metrics{"loss": 0.5},
+		Infeasible: true,
+	}
+	if got, want := vizier.EncodeTrial(trial, objectives).State, vizier.Infeasible; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	trial.Infeasible = false
+	if got, want := vizier.EncodeTrial(trial, objectives).State, vizier.Succeeded; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSpecUnsupported(t *testing.T) {
+	params := diviner.Params{
+		"momentum":  diviner.NewConditional(diviner.NewRange(diviner.Float(0), diviner.Float(1)), "optimizer", diviner.String("sgd")),
+		"optimizer": diviner.NewDiscrete(diviner.String("sgd"), diviner.String("adam")),
+	}
+	if _, err := vizier.Spec(params, nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDecodeValuesInvalid(t *testing.T) {
+	params := diviner.Params{"layers": diviner.NewRange(diviner.Int(1), diviner.Int(10))}
+	for _, parameters := range [][]vizier.TrialParameter{
+		{{ParameterID: "layers", Value: float64(10)}},
+		{{ParameterID: "layers", Value: "ten"}},
+		{{ParameterID: "width", Value: float64(1)}},
+		nil,
+	} {
+		if _, err := vizier.DecodeValues(params, parameters); err == nil {
+			t.Errorf("%v: expected error", parameters)
+		}
+	}
+}
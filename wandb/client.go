@@ -0,0 +1,384 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package wandb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the URL of the hosted W&B service.
+const DefaultURL = "https://api.wandb.ai"
+
+// DefaultTimeout is the default timeout of a request to the service.
+const DefaultTimeout = time.Minute
+
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// The names of the files streamed to a run.
+const (
+	historyFile = "wandb-history.jsonl"
+	summaryFile = "wandb-summary.json"
+)
+
+// RunSpec describes a W&B run to be created or updated.
+type RunSpec struct {
+	// Name is the run's ID, unique within its project. It may
+	// contain only letters, digits, dashes, and underscores.
+	Name string
+	// DisplayName is the name of the run shown in dashboards.
+	DisplayName string
+	// Group is the name of the group to which the run belongs.
+	Group string
+	// Sweep is the ID of the sweep to which the run belongs, if any.
+	Sweep string
+	// Config is the run's configuration, i.e., its parameter values.
+	Config map[string]interface{}
+	// Tags is the set of tags applied to the run.
+	Tags []string
+	// Notes is a free-form description of the run.
+	Notes string
+}
+
+// RunInfo is the metadata of a W&B run, as returned by the service.
+type RunInfo struct {
+	// ID is the service's internal identifier of the run.
+	ID string `json:"id"`
+	// Name is the run's ID within its project.
+	Name string `json:"name"`
+	// HistoryLineCount is the number of history rows that the
+	// service has recorded for the run.
+	HistoryLineCount int `json:"historyLineCount"`
+}
+
+// A Stream is an update to a run's streamed files: rows appended to
+// its history, its summary, and whether it has completed.
+type Stream struct {
+	// HistoryOffset is the index of the first row in History.
+	HistoryOffset int
+	// History contains rows of the run's history, each a map of
+	// metric names to values. Rows are indexed by the special key
+	// "_step".
+	History []map[string]interface{}
+	// Summary, if non-nil, replaces the run's summary.
+	Summary map[string]interface{}
+	// Complete marks the run as completed, with the provided exit
+	// code.
+	Complete bool
+	ExitCode int
+}
+
+// A Command is a command issued by a sweep controller to an agent.
+type Command struct {
+	// Type is the type of the command: "run" asks the agent to run
+	// the provided arguments as the run with the provided ID; "stop"
+	// asks it to stop the provided runs; "exit" asks it to exit, as
+	// the sweep is done.
+	Type   string                            `json:"type"`
+	RunID  string                            `json:"run_id"`
+	Args   map[string]map[string]interface{} `json:"args"`
+	RunIDs []string                          `json:"run_ids"`
+}
+
+// Error is an error returned by the service.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	Message    string
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("wandb: %d: %s", e.StatusCode, e.Message)
+}
+
+// A Client issues requests to the W&B service through its GraphQL API
+// and its file stream API, the same APIs that are used by W&B's own
+// client library. Each client addresses a single project.
+type Client struct {
+	// URL is the base URL of the service. Defaults to DefaultURL.
+	URL string
+	// APIKey is the key with which requests are authorized. If
+	// empty, $WANDB_API_KEY is used.
+	APIKey string
+	// Entity is the user or team that owns the project.
+	Entity string
+	// Project is the name of the project.
+	Project string
+	// Header contains additional headers that are included in each
+	// request.
+	Header http.Header
+	// Client is the HTTP client used to issue requests. If nil, a
+	// client with a timeout of DefaultTimeout is used.
+	Client *http.Client
+}
+
+const upsertBucketQuery = `mutation UpsertBucket($name: String, $project: String, $entity: String, $groupName: String, $displayName: String, $notes: String, $config: JSONString, $sweep: String, $tags: [String!]) {
+	upsertBucket(input: {name: $name, modelName: $project, entityName: $entity, groupName: $groupName, displayName: $displayName, notes: $notes, config: $config, sweep: $sweep, tags: $tags}) {
+		bucket { id name historyLineCount }
+	}
+}`
+
+// UpsertRun creates the run described by the provided spec, or
+// updates it if it already exists, returning its metadata.
+func (c *Client) UpsertRun(ctx context.Context, spec RunSpec) (RunInfo, error) {
+	vars := map[string]interface{}{
+		"name":    spec.Name,
+		"project": c.Project,
+		"entity":  c.Entity,
+	}
+	if spec.DisplayName != "" {
+		vars["displayName"] = spec.DisplayName
+	}
+	if spec.Group != "" {
+		vars["groupName"] = spec.Group
+	}
+	if spec.Notes != "" {
+		vars["notes"] = spec.Notes
+	}
+	if spec.Sweep != "" {
+		vars["sweep"] = spec.Sweep
+	}
+	if spec.Tags != nil {
+		vars["tags"] = spec.Tags
+	}
+	if spec.Config != nil {
+		config, err := json.Marshal(spec.Config)
+		if err != nil {
+			return RunInfo{}, err
+		}
+		vars["config"] = string(config)
+	}
+	var reply struct {
+		UpsertBucket struct {
+			Bucket RunInfo `json:"bucket"`
+		} `json:"upsertBucket"`
+	}
+	err := c.query(ctx, upsertBucketQuery, vars, &reply)
+	return reply.UpsertBucket.Bucket, err
+}
+
+const upsertSweepQuery = `mutation UpsertSweep($config: String, $description: String, $project: String, $entity: String) {
+	upsertSweep(input: {config: $config, description: $description, projectName: $project, entityName: $entity}) {
+		sweep { name }
+	}
+}`
+
+// CreateSweep creates a sweep with the provided configuration and
+// description, returning its ID.
+func (c *Client) CreateSweep(ctx context.Context, config map[string]interface{}, description string) (string, error) {
+	// The service parses sweep configurations as YAML, of which JSON
+	// is a subset.
+	b, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	vars := map[string]interface{}{
+		"config":      string(b),
+		"description": description,
+		"project":     c.Project,
+		"entity":      c.Entity,
+	}
+	var reply struct {
+		UpsertSweep struct {
+			Sweep struct {
+				Name string `json:"name"`
+			} `json:"sweep"`
+		} `json:"upsertSweep"`
+	}
+	err = c.query(ctx, upsertSweepQuery, vars, &reply)
+	return reply.UpsertSweep.Sweep.Name, err
+}
+
+const createAgentQuery = `mutation CreateAgent($host: String!, $project: String, $entity: String, $sweep: String!) {
+	createAgent(input: {host: $host, projectName: $project, entityName: $entity, sweep: $sweep}) {
+		agent { id }
+	}
+}`
+
+// CreateAgent registers an agent of the provided sweep, returning
+// the agent's ID.
+func (c *Client) CreateAgent(ctx context.Context, sweep string) (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "diviner"
+	}
+	vars := map[string]interface{}{
+		"host":    host,
+		"project": c.Project,
+		"entity":  c.Entity,
+		"sweep":   sweep,
+	}
+	var reply struct {
+		CreateAgent struct {
+			Agent struct {
+				ID string `json:"id"`
+			} `json:"agent"`
+		} `json:"createAgent"`
+	}
+	err = c.query(ctx, createAgentQuery, vars, &reply)
+	return reply.CreateAgent.Agent.ID, err
+}
+
+const heartbeatQuery = `mutation Heartbeat($id: ID!, $metrics: JSONString, $runState: JSONString) {
+	agentHeartbeat(input: {id: $id, metrics: $metrics, runState: $runState}) {
+		agent { id }
+		commands
+	}
+}`
+
+// Heartbeat reports that the agent with the provided ID is alive and
+// running the provided runs, returning the commands issued to it by
+// the sweep controller.
+func (c *Client) Heartbeat(ctx context.Context, agent string, running []string) ([]Command, error) {
+	state := make(map[string]string)
+	for _, run := range running {
+		state[run] = "running"
+	}
+	runState, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]interface{}{
+		"id":       agent,
+		"metrics":  "{}",
+		"runState": string(runState),
+	}
+	var reply struct {
+		AgentHeartbeat struct {
+			// Commands is a JSON-encoded list of commands.
+			Commands string `json:"commands"`
+		} `json:"agentHeartbeat"`
+	}
+	if err := c.query(ctx, heartbeatQuery, vars, &reply); err != nil {
+		return nil, err
+	}
+	if reply.AgentHeartbeat.Commands == "" {
+		return nil, nil
+	}
+	var commands []Command
+	if err := json.Unmarshal([]byte(reply.AgentHeartbeat.Commands), &commands); err != nil {
+		return nil, fmt.Errorf("wandb: decoding commands: %v", err)
+	}
+	return commands, nil
+}
+
+// Stream applies the provided update to the streamed files of the
+// named run, which must exist.
+func (c *Client) Stream(ctx context.Context, run string, s Stream) error {
+	type chunk struct {
+		Offset  int      `json:"offset"`
+		Content []string `json:"content"`
+	}
+	req := struct {
+		Files    map[string]chunk `json:"files,omitempty"`
+		Complete bool             `json:"complete,omitempty"`
+		ExitCode *int             `json:"exitcode,omitempty"`
+	}{Files: make(map[string]chunk)}
+	if len(s.History) > 0 {
+		history := chunk{Offset: s.HistoryOffset}
+		for _, row := range s.History {
+			b, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+			history.Content = append(history.Content, string(b))
+		}
+		req.Files[historyFile] = history
+	}
+	if s.Summary != nil {
+		b, err := json.Marshal(s.Summary)
+		if err != nil {
+			return err
+		}
+		req.Files[summaryFile] = chunk{Content: []string{string(b)}}
+	}
+	if s.Complete {
+		req.Complete = true
+		req.ExitCode = &s.ExitCode
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/files/%s/%s/%s/file_stream", c.Entity, c.Project, run)
+	return c.post(ctx, path, b, nil)
+}
+
+func (c *Client) query(ctx context.Context, query string, vars map[string]interface{}, reply interface{}) error {
+	b, err := json.Marshal(struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}{query, vars})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := c.post(ctx, "/graphql", b, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		msgs := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			msgs[i] = e.Message
+		}
+		return &Error{StatusCode: http.StatusOK, Message: strings.Join(msgs, "; ")}
+	}
+	return json.Unmarshal(resp.Data, reply)
+}
+
+func (c *Client) post(ctx context.Context, path string, body []byte, reply interface{}) error {
+	base := c.URL
+	if base == "" {
+		base = DefaultURL
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(base, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, vs := range c.Header {
+		req.Header[k] = vs
+	}
+	key := c.APIKey
+	if key == "" {
+		key = os.Getenv("WANDB_API_KEY")
+	}
+	if key != "" {
+		req.SetBasicAuth("api", key)
+	}
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return &Error{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(msg))}
+	}
+	if reply == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package wandb
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/vizier"
+)
+
+func init() {
+	gob.Register(&Sweep{})
+}
+
+const (
+	defaultSweepTimeout = 5 * time.Minute
+	defaultInterval     = 5 * time.Second
+)
+
+// Sweep is a diviner.Oracle (and diviner.StatefulOracle) that acts as
+// an agent of a W&B sweep: each call to Next asks the sweep's
+// controller for the requested number of trials, waiting for the
+// controller to assign them, and logs the results of the trials that
+// have completed since the previous call to their W&B runs, so that
+// the controller can take them into account. The sweep's parameters
+// must match the study's (see SweepConfig). Sweep is exhausted once
+// the controller asks its agents to exit, e.g., because the sweep has
+// reached its run cap; requests from the controller to stop runs are
+// ignored.
+type Sweep struct {
+	// URL is the base URL of the W&B service. Defaults to
+	// DefaultURL. Requests are authorized by $WANDB_API_KEY.
+	URL string
+	// Entity is the user or team that owns the sweep's project.
+	Entity string
+	// Project is the name of the sweep's project.
+	Project string
+	// Sweep is the ID of the sweep.
+	Sweep string
+	// Timeout is the maximum duration of each call to Next, while it
+	// waits for the controller to suggest trials. Defaults to 5
+	// minutes.
+	Timeout time.Duration
+	// Interval is the interval at which the controller is polled for
+	// suggestions. Defaults to 5 seconds.
+	Interval time.Duration
+
+	mu    sync.Mutex
+	agent string
+	// Runs maps the values of pending trials suggested by the
+	// controller (as rendered by diviner.Values.String) to the IDs of
+	// their W&B runs.
+	runs map[string]string
+}
+
+// NewSweep returns a new Sweep that consumes suggestions from the
+// provided sweep of the provided entity and project.
+func NewSweep(entity, project, sweep string) *Sweep {
+	return &Sweep{Entity: entity, Project: project, Sweep: sweep}
+}
+
+// String returns a textual description of the oracle.
+func (o *Sweep) String() string {
+	return fmt.Sprintf("wandb_sweep(entity=%s, project=%s, sweep=%s)", o.Entity, o.Project, o.Sweep)
+}
+
+// Next implements diviner.Oracle.
+func (o *Sweep) Next(previous []diviner.Trial, params diviner.Params, objective diviner.Objective, howmany int) ([]diviner.Values, error) {
+	if howmany <= 0 {
+		return nil, nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = defaultSweepTimeout
+	}
+	interval := o.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client := &Client{URL: o.URL, Entity: o.Entity, Project: o.Project}
+	if o.agent == "" {
+		agent, err := client.CreateAgent(ctx, o.Sweep)
+		if err != nil {
+			return nil, fmt.Errorf("wandb sweep %s: creating agent: %v", o.Sweep, err)
+		}
+		o.agent = agent
+	}
+	if o.runs == nil {
+		o.runs = make(map[string]string)
+	}
+	for _, trial := range previous {
+		key := trial.Values.String()
+		name, ok := o.runs[key]
+		if !ok || trial.Pending {
+			continue
+		}
+		if err := o.report(ctx, client, name, trial); err != nil {
+			return nil, fmt.Errorf("wandb sweep %s: run %s: %v", o.Sweep, name, err)
+		}
+		delete(o.runs, key)
+	}
+	var values []diviner.Values
+	for len(values) < howmany {
+		running := make([]string, 0, len(o.runs))
+		for _, name := range o.runs {
+			running = append(running, name)
+		}
+		sort.Strings(running)
+		commands, err := client.Heartbeat(ctx, o.agent, running)
+		if err != nil {
+			return nil, fmt.Errorf("wandb sweep %s: %v", o.Sweep, err)
+		}
+		var exit bool
+		for _, cmd := range commands {
+			switch cmd.Type {
+			case "run":
+				if len(values) == howmany {
+					continue
+				}
+				v, err := decodeArgs(params, cmd.Args)
+				if err != nil {
+					return nil, fmt.Errorf("wandb sweep %s: run %s: %v", o.Sweep, cmd.RunID, err)
+				}
+				_, err = client.UpsertRun(ctx, RunSpec{
+					Name:   cmd.RunID,
+					Sweep:  o.Sweep,
+					Config: Config(v),
+					Tags:   []string{Tag},
+				})
+				if err != nil {
+					return nil, fmt.Errorf("wandb sweep %s: run %s: %v", o.Sweep, cmd.RunID, err)
+				}
+				o.runs[v.String()] = cmd.RunID
+				values = append(values, v)
+			case "exit":
+				exit = true
+			}
+		}
+		if exit {
+			break
+		}
+		if len(commands) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("wandb sweep %s: controller suggested %d of %d trials within %s", o.Sweep, len(values), howmany, timeout)
+			case <-time.After(interval):
+			}
+		}
+	}
+	return values, nil
+}
+
+// Report logs the provided completed trial to the W&B run with the
+// provided ID. The run's summary contains the trial's metrics, which
+// are aggregated over its replicates; infeasible trials are reported
+// as crashed runs.
+func (o *Sweep) report(ctx context.Context, client *Client, name string, trial diviner.Trial) error {
+	run := diviner.Run{Values: trial.Values, State: diviner.Success}
+	if n := len(trial.Runs); n > 0 {
+		run = trial.Runs[n-1]
+	}
+	if trial.Infeasible {
+		run.State = diviner.Failure
+	}
+	spec := RunSpec{Name: name, Sweep: o.Sweep, Config: Config(trial.Values)}
+	return logRun(ctx, client, spec, run, trial.Metrics)
+}
+
+// sweepSavedState is the encoding of a Sweep oracle's state, as
+// returned by SaveState.
+type sweepSavedState struct {
+	Agent string
+	Runs  map[string]string
+}
+
+// SaveState implements diviner.StatefulOracle, encoding the oracle's
+// agent and its pending runs.
+func (o *Sweep) SaveState() ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(sweepSavedState{o.agent, o.runs}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// LoadState implements diviner.StatefulOracle, restoring a state
+// returned by SaveState.
+func (o *Sweep) LoadState(state []byte) error {
+	var saved sweepSavedState
+	if err := gob.NewDecoder(bytes.NewReader(state)).Decode(&saved); err != nil {
+		return fmt.Errorf("wandb: decoding state: %v", err)
+	}
+	o.mu.Lock()
+	o.agent, o.runs = saved.Agent, saved.Runs
+	o.mu.Unlock()
+	return nil
+}
+
+// DecodeArgs returns the parameter values represented by the
+// arguments of a sweep controller's run command, as decoded by
+// vizier.DecodeValues.
+func decodeArgs(params diviner.Params, args map[string]map[string]interface{}) (diviner.Values, error) {
+	parameters := make([]vizier.TrialParameter, 0, len(args))
+	for name, arg := range args {
+		parameters = append(parameters, vizier.TrialParameter{ParameterID: name, Value: arg["value"]})
+	}
+	sort.Slice(parameters, func(i, j int) bool {
+		return parameters[i].ParameterID < parameters[j].ParameterID
+	})
+	return vizier.DecodeValues(params, parameters)
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package wandb bridges diviner and Weights & Biases [1], so that
+// trials are visible in W&B dashboards alongside other experiments.
+//
+// Export mirrors diviner runs into a W&B project: each run is logged
+// as a W&B run, grouped by its study, whose configuration is the
+// run's parameter values and whose history is the run's metric
+// history. Exports are incremental: only the reports that W&B has not
+// yet recorded are streamed, and runs are marked finished (or
+// crashed) once they complete.
+//
+// Sweep is a diviner.Oracle that consumes suggestions from a W&B
+// sweep controller: it acts as a sweep agent, running the trials
+// that the controller assigns to it, and logging their results back
+// to the sweep, so that the sweep's search method (e.g., W&B's
+// Bayesian search) drives a diviner study. SweepConfig translates a
+// study's parameters into a sweep configuration.
+//
+// [1] https://wandb.ai
+package wandb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grailbio/diviner"
+)
+
+// Tag is the tag applied to W&B runs exported from diviner.
+const Tag = "diviner"
+
+// RunName returns the W&B run ID of the provided diviner run: its
+// study name, with characters not permitted by W&B replaced by
+// dashes, followed by its sequence number.
+func RunName(run diviner.Run) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, run.Study)
+	return fmt.Sprintf("%s-%d", name, run.Seq)
+}
+
+// Config returns the W&B configuration representing the provided
+// parameter values.
+func Config(values diviner.Values) map[string]interface{} {
+	config := make(map[string]interface{}, len(values))
+	for name, v := range values {
+		var value interface{}
+		switch v.Kind() {
+		case diviner.Integer:
+			value = v.Int()
+		case diviner.Real:
+			value = finite(v.Float())
+		case diviner.Str:
+			value = v.Str()
+		case diviner.Boolean:
+			value = v.Bool()
+		default:
+			value = v.String()
+		}
+		config[name] = map[string]interface{}{"value": value}
+	}
+	return config
+}
+
+// Export logs every run in the named studies that has been updated
+// since the provided time, including pending runs, to the client's
+// project. It returns the number of runs exported.
+func Export(ctx context.Context, db diviner.Database, client *Client, studies []string, since time.Time) (int, error) {
+	var n int
+	for _, study := range studies {
+		runs, err := db.ListRuns(ctx, study, diviner.Any, since)
+		if err != nil && err != diviner.ErrNotExist {
+			return n, err
+		}
+		for _, run := range runs {
+			if err := ExportRun(ctx, client, run); err != nil {
+				return n, fmt.Errorf("%s: %v", run.ID(), err)
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ExportRun logs the provided run to the client's project, as the
+// W&B run named by RunName.
+func ExportRun(ctx context.Context, client *Client, run diviner.Run) error {
+	return logRun(ctx, client, RunSpec{
+		Name:        RunName(run),
+		DisplayName: run.ID(),
+		Group:       run.Study,
+		Config:      Config(run.Values),
+		Tags:        []string{Tag},
+	}, run, nil)
+}
+
+// LogRun creates or updates the W&B run described by spec, and
+// streams to it the provided diviner run's metric reports that the
+// service has not yet recorded, its summary, and, if the run has
+// completed, its exit code. The run's summary comprises the last
+// reported value of each metric, overridden by the provided
+// metrics.
+func logRun(ctx context.Context, client *Client, spec RunSpec, run diviner.Run, metrics diviner.Metrics) error {
+	info, err := client.UpsertRun(ctx, spec)
+	if err != nil {
+		return err
+	}
+	var (
+		update  = Stream{HistoryOffset: info.HistoryLineCount}
+		summary = make(map[string]interface{})
+		start   = run.Created
+		end     = run.Completed
+	)
+	if end.IsZero() {
+		end = run.Updated
+	}
+	if end.Before(start) {
+		end = start
+	}
+	for i, report := range run.Metrics {
+		// Metrics reports are not timestamped, so they are spread
+		// evenly over the run's lifetime.
+		t := start.Add(end.Sub(start) * time.Duration(i+1) / time.Duration(len(run.Metrics)))
+		row := map[string]interface{}{
+			"_step":      i,
+			"_timestamp": float64(t.UnixNano()) / 1e9,
+			"_runtime":   t.Sub(start).Seconds(),
+		}
+		for name, value := range report {
+			// JSON cannot represent non-finite values.
+			if v := finite(value); v != nil {
+				row[name] = v
+			}
+		}
+		for name, value := range row {
+			summary[name] = value
+		}
+		if i >= update.HistoryOffset {
+			update.History = append(update.History, row)
+		}
+	}
+	for name, value := range metrics {
+		if v := finite(value); v != nil {
+			summary[name] = v
+		}
+	}
+	update.Summary = summary
+	switch run.State {
+	case diviner.Pending:
+	case diviner.Success:
+		update.Complete = true
+	default:
+		update.Complete, update.ExitCode = true, 1
+	}
+	return client.Stream(ctx, info.Name, update)
+}
+
+// SweepConfig returns the configuration of a W&B sweep that explores
+// the provided study's parameters with the provided search method
+// ("bayes", "random", or "grid"), optimizing the study's objective.
+// SweepConfig returns an error if a parameter cannot be represented
+// in a sweep.
+func SweepConfig(study diviner.Study, method string) (map[string]interface{}, error) {
+	parameters := make(map[string]interface{})
+	for _, p := range study.Params.Sorted() {
+		var spec map[string]interface{}
+		switch param := p.Param.(type) {
+		case *diviner.Range:
+			switch p.Kind() {
+			case diviner.Integer:
+				spec = map[string]interface{}{"distribution": "int_uniform", "min": param.Start.Int(), "max": param.End.Int() - 1}
+			case diviner.Real:
+				spec = map[string]interface{}{"distribution": "uniform", "min": param.Start.Float(), "max": param.End.Float()}
+			}
+		case *diviner.LogRange:
+			switch p.Kind() {
+			case diviner.Integer:
+				spec = map[string]interface{}{"distribution": "q_log_uniform_values", "min": param.Start.Int(), "max": param.End.Int() - 1, "q": 1}
+			case diviner.Real:
+				spec = map[string]interface{}{"distribution": "log_uniform_values", "min": param.Start.Float(), "max": param.End.Float()}
+			}
+		case *diviner.QuantizedRange, *diviner.Discrete, *diviner.Enum:
+			var values []interface{}
+			for _, v := range p.Values() {
+				switch v.Kind() {
+				case diviner.Integer:
+					values = append(values, v.Int())
+				case diviner.Real:
+					values = append(values, v.Float())
+				case diviner.Str:
+					values = append(values, v.Str())
+				default:
+					return nil, fmt.Errorf("wandb: parameter %s: unsupported value %s", p.Name, v)
+				}
+			}
+			spec = map[string]interface{}{"values": values}
+		}
+		if spec == nil {
+			return nil, fmt.Errorf("wandb: parameter %s: unsupported parameter %s", p.Name, p.Param)
+		}
+		parameters[p.Name] = spec
+	}
+	goal := "maximize"
+	if study.Objective.Direction == diviner.Minimize {
+		goal = "minimize"
+	}
+	return map[string]interface{}{
+		"name":       study.Name,
+		"method":     method,
+		"metric":     map[string]interface{}{"name": study.Objective.Metric, "goal": goal},
+		"parameters": parameters,
+	}, nil
+}
+
+// Finite returns v if it is finite, and nil otherwise.
+func finite(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
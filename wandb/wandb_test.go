@@ -0,0 +1,339 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package wandb_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/diviner"
+	"github.com/grailbio/diviner/memdb"
+	"github.com/grailbio/diviner/wandb"
+)
+
+type run struct {
+	Sweep    string
+	Group    string
+	Config   map[string]map[string]interface{}
+	History  []map[string]interface{}
+	Summary  map[string]interface{}
+	Complete bool
+	ExitCode int
+}
+
+// Server is a fake W&B service that implements the subset of the
+// GraphQL and file stream APIs used by the package.
+type server struct {
+	mu   sync.Mutex
+	runs map[string]*run
+	// Commands are issued to agents, one per heartbeat.
+	commands []wandb.Command
+	// Running records the runs reported by the last heartbeat.
+	running map[string]string
+}
+
+func newServer() *server {
+	return &server{runs: make(map[string]*run)}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, key, ok := r.BasicAuth(); !ok || user != "api" || key != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/file_stream") {
+		parts := strings.Split(r.URL.Path, "/")
+		if got, want := strings.Join(parts[2:4], "/"), "entity/project"; got != want {
+			http.Error(w, "bad project "+got, http.StatusBadRequest)
+			return
+		}
+		run := s.runs[parts[4]]
+		if run == nil {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Files map[string]struct {
+				Offset  int
+				Content []string
+			}
+			Complete bool
+			ExitCode int
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if history, ok := req.Files["wandb-history.jsonl"]; ok {
+			if history.Offset != len(run.History) {
+				http.Error(w, "bad offset", http.StatusBadRequest)
+				return
+			}
+			for _, line := range history.Content {
+				var row map[string]interface{}
+				_ = json.Unmarshal([]byte(line), &row)
+				run.History = append(run.History, row)
+			}
+		}
+		if summary, ok := req.Files["wandb-summary.json"]; ok {
+			run.Summary = nil
+			_ = json.Unmarshal([]byte(summary.Content[0]), &run.Summary)
+		}
+		run.Complete, run.ExitCode = req.Complete, req.ExitCode
+		return
+	}
+	var req struct {
+		Query     string
+		Variables map[string]interface{}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	str := func(key string) string {
+		s, _ := req.Variables[key].(string)
+		return s
+	}
+	var data interface{}
+	switch {
+	case strings.HasPrefix(req.Query, "mutation UpsertBucket"):
+		name := str("name")
+		r := s.runs[name]
+		if r == nil {
+			r = new(run)
+			s.runs[name] = r
+		}
+		if v := str("sweep"); v != "" {
+			r.Sweep = v
+		}
+		if v := str("groupName"); v != "" {
+			r.Group = v
+		}
+		if v := str("config"); v != "" {
+			_ = json.Unmarshal([]byte(v), &r.Config)
+		}
+		data = map[string]interface{}{"upsertBucket": map[string]interface{}{
+			"bucket": map[string]interface{}{"id": "id-" + name, "name": name, "historyLineCount": len(r.History)},
+		}}
+	case strings.HasPrefix(req.Query, "mutation CreateAgent"):
+		data = map[string]interface{}{"createAgent": map[string]interface{}{"agent": map[string]string{"id": "agent-" + str("sweep")}}}
+	case strings.HasPrefix(req.Query, "mutation Heartbeat"):
+		s.running = nil
+		_ = json.Unmarshal([]byte(str("runState")), &s.running)
+		var commands []wandb.Command
+		if len(s.commands) > 0 {
+			commands, s.commands = s.commands[:1], s.commands[1:]
+		}
+		b, _ := json.Marshal(commands)
+		data = map[string]interface{}{"agentHeartbeat": map[string]interface{}{"commands": string(b)}}
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []map[string]string{{"message": "unknown query"}}})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func TestExport(t *testing.T) {
+	var (
+		ctx = context.Background()
+		db  = memdb.New()
+		srv = newServer()
+	)
+	httpsrv := httptest.NewServer(srv)
+	defer httpsrv.Close()
+	client := &wandb.Client{URL: httpsrv.URL, APIKey: "secret", Entity: "entity", Project: "project"}
+
+	if _, err := db.CreateStudyIfNotExist(ctx, diviner.Study{Name: "my study"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.InsertRun(ctx, diviner.Run{Study: "my study", Values: diviner.Values{"lr": diviner.Float(0.1), "opt": diviner.String("adam")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AppendRunMetrics(ctx, "my study", r.Seq, diviner.Metrics{"step": 1, "acc": 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := wandb.Export(ctx, db, client, []string{"my study", "nonexistent"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	wrun := srv.runs["my-study-1"]
+	if wrun == nil {
+		t.Fatalf("run not exported: %v", srv.runs)
+	}
+	if got, want := wrun.Group, "my study"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := wrun.Config, map[string]map[string]interface{}{"lr": {"value": 0.1}, "opt": {"value": "adam"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if wrun.Complete {
+		t.Error("pending run completed")
+	}
+
+	// Export again, after the run completes: only new reports are
+	// streamed.
+	if err := db.AppendRunMetrics(ctx, "my study", r.Seq, diviner.Metrics{"step": 2, "acc": 0.75}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateRun(ctx, "my study", r.Seq, diviner.Failure, "failed", time.Minute, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wandb.Export(ctx, db, client, []string{"my study"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	var acc []interface{}
+	for _, row := range wrun.History {
+		acc = append(acc, row["acc"])
+	}
+	if got, want := acc, []interface{}{0.5, 0.75}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := wrun.Summary["acc"], 0.75; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !wrun.Complete || wrun.ExitCode != 1 {
+		t.Errorf("got complete=%v, exitcode=%d", wrun.Complete, wrun.ExitCode)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	srv := newServer()
+	httpsrv := httptest.NewServer(srv)
+	defer httpsrv.Close()
+	params := diviner.Params{
+		"lr":  diviner.NewRange(diviner.Float(0), diviner.Float(1)),
+		"opt": diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd")),
+	}
+	objective := diviner.Objective{Direction: diviner.Maximize, Metric: "acc"}
+	for i, opt := range []string{"adam", "sgd"} {
+		srv.commands = append(srv.commands, wandb.Command{
+			Type:  "run",
+			RunID: fmt.Sprintf("run%d", i),
+			Args:  map[string]map[string]interface{}{"lr": {"value": 0.1}, "opt": {"value": opt}},
+		})
+	}
+	srv.commands = append(srv.commands, wandb.Command{Type: "exit"})
+
+	oracle := wandb.NewSweep("entity", "project", "sweep")
+	oracle.URL = httpsrv.URL
+	oracle.Interval = time.Millisecond
+	defer func(key string) { _ = os.Setenv("WANDB_API_KEY", key) }(os.Getenv("WANDB_API_KEY"))
+	if err := os.Setenv("WANDB_API_KEY", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	values, err := oracle.Next(nil, params, objective, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []diviner.Values{
+		{"lr": diviner.Float(0.1), "opt": diviner.String("adam")},
+		{"lr": diviner.Float(0.1), "opt": diviner.String("sgd")},
+	}
+	if got := values; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := srv.runs["run0"].Sweep, "sweep"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The oracle's state survives a restart.
+	state, err := oracle.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := wandb.NewSweep("entity", "project", "sweep")
+	restarted.URL = httpsrv.URL
+	restarted.Interval = time.Millisecond
+	if err := restarted.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first trial completes; the controller then asks the agent
+	// to exit.
+	trials := []diviner.Trial{
+		{Values: values[0], Metrics: diviner.Metrics{"acc": 0.9}},
+		{Values: values[1], Pending: true},
+	}
+	values, err = restarted.Next(trials, params, objective, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(values), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := srv.running, map[string]string{"run1": "running"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	done := srv.runs["run0"]
+	if !done.Complete || done.ExitCode != 0 {
+		t.Errorf("got complete=%v, exitcode=%d", done.Complete, done.ExitCode)
+	}
+	if got, want := done.Summary["acc"], 0.9; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if srv.runs["run1"].Complete {
+		t.Error("pending run completed")
+	}
+}
+
+func TestSweepTimeout(t *testing.T) {
+	srv := newServer()
+	httpsrv := httptest.NewServer(srv)
+	defer httpsrv.Close()
+	oracle := &wandb.Sweep{URL: httpsrv.URL, Entity: "entity", Project: "project", Sweep: "sweep", Timeout: 50 * time.Millisecond, Interval: time.Millisecond}
+	defer func(key string) { _ = os.Setenv("WANDB_API_KEY", key) }(os.Getenv("WANDB_API_KEY"))
+	if err := os.Setenv("WANDB_API_KEY", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	params := diviner.Params{"lr": diviner.NewRange(diviner.Float(0), diviner.Float(1))}
+	if _, err := oracle.Next(nil, params, diviner.Objective{Metric: "acc"}, 1); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSweepConfig(t *testing.T) {
+	study := diviner.Study{
+		Name: "test",
+		Params: diviner.Params{
+			"lr":     diviner.NewLogRange(diviner.Float(1e-4), diviner.Float(1e-1)),
+			"layers": diviner.NewRange(diviner.Int(1), diviner.Int(5)),
+			"opt":    diviner.NewDiscrete(diviner.String("adam"), diviner.String("sgd")),
+		},
+		Objective: diviner.Objective{Direction: diviner.Minimize, Metric: "loss"},
+	}
+	config, err := wandb.SweepConfig(study, "bayes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"method":"bayes","metric":{"goal":"minimize","name":"loss"},"name":"test","parameters":{`+
+		`"layers":{"distribution":"int_uniform","max":4,"min":1},`+
+		`"lr":{"distribution":"log_uniform_values","max":0.1,"min":0.0001},`+
+		`"opt":{"values":["adam","sgd"]}}}`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	study.Params["x"] = diviner.NewVector(diviner.NewRange(diviner.Int(0), diviner.Int(2)))
+	if _, err := wandb.SweepConfig(study, "bayes"); err == nil {
+		t.Error("expected error")
+	}
+}